@@ -2,11 +2,13 @@ package config
 
 import (
 	"context"
-	"crypto/rand"
 	"errors"
 	"fmt"
+	"reflect"
+	"strings"
 	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/libp2p/go-libp2p/core/connmgr"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/event"
@@ -21,18 +23,25 @@ import (
 	"github.com/libp2p/go-libp2p/core/sec"
 	"github.com/libp2p/go-libp2p/core/sec/insecure"
 	"github.com/libp2p/go-libp2p/core/transport"
+	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
 	"github.com/libp2p/go-libp2p/p2p/host/autonat"
 	"github.com/libp2p/go-libp2p/p2p/host/autorelay"
 	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	"github.com/libp2p/go-libp2p/p2p/host/conndedup"
 	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
-	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+	"github.com/libp2p/go-libp2p/p2p/host/netmon"
+	"github.com/libp2p/go-libp2p/p2p/host/relaysvc"
 	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
 	routed "github.com/libp2p/go-libp2p/p2p/host/routed"
+	"github.com/libp2p/go-libp2p/p2p/host/stun"
+	"github.com/libp2p/go-libp2p/p2p/metricshelper"
 	"github.com/libp2p/go-libp2p/p2p/net/swarm"
 	tptu "github.com/libp2p/go-libp2p/p2p/net/upgrader"
+	"github.com/libp2p/go-libp2p/p2p/protocol/attestation"
 	circuitv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
 	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
 	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
 	"github.com/libp2p/go-libp2p/p2p/transport/quicreuse"
 	"github.com/prometheus/client_golang/prometheus"
 
@@ -61,9 +70,25 @@ type AutoNATConfig struct {
 	ThrottleInterval    time.Duration
 }
 
+// ServiceScope attaches a built-in service to a dedicated resource manager
+// service scope.
+type ServiceScope struct {
+	// Name is the name of the service scope.
+	Name string
+	// Limit is the limit of the service scope. It is only applied to the
+	// default resource manager, a custom resource manager needs to be
+	// configured with the limits of the scope.
+	Limit rcmgr.BaseLimit
+	// ReservationPriority is the priority of the memory reservations of the
+	// service's streams. If 0, network.ReservationPriorityAlways is used.
+	ReservationPriority uint8
+}
+
 type Security struct {
 	ID          protocol.ID
 	Constructor interface{}
+	// Options are passed to the variadic parameter of the Constructor.
+	Options []interface{}
 }
 
 // Config describes a set of settings for a libp2p node
@@ -84,12 +109,34 @@ type Config struct {
 
 	PeerKey crypto.PrivKey
 
-	QUICReuse          []fx.Option
-	Transports         []fx.Option
-	Muxers             []tptu.StreamMuxer
-	SecurityTransports []Security
-	Insecure           bool
-	PSK                pnet.PSK
+	QUICReuse  []fx.Option
+	Transports []fx.Option
+	// UpgraderlessTransports are the names of the constructors of the
+	// Transports that don't take an upgrader, e.g. QUIC, since they secure
+	// and multiplex connections themselves.
+	UpgraderlessTransports []string
+	Muxers                 []tptu.StreamMuxer
+	SecurityTransports     []Security
+	Insecure               bool
+	DisallowInsecure       bool
+	PSK                    pnet.PSK
+
+	// HandshakeWorkers and HandshakeQueueLen limit the inbound handshakes of
+	// upgraded transports (see upgrader.WithHandshakeLimits). Unlimited if
+	// HandshakeWorkers is 0.
+	HandshakeWorkers  int
+	HandshakeQueueLen int
+	// ListenerUpgradeWorkers and ListenerUpgradeQueueLen limit the inbound
+	// upgrades per listener (see upgrader.WithListenerUpgradeLimits).
+	// Unlimited if ListenerUpgradeWorkers is 0.
+	ListenerUpgradeWorkers  int
+	ListenerUpgradeQueueLen int
+	// Admission enables the admission step of upgraded transports (see
+	// upgrader.WithAdmission). Disabled if nil.
+	Admission *tptu.Admission
+	// HandshakeObservers are notified of failed inbound handshakes of
+	// upgraded transports (see upgrader.WithHandshakeObserver).
+	HandshakeObservers []tptu.HandshakeObserver
 
 	DialTimeout time.Duration
 
@@ -101,6 +148,7 @@ type Config struct {
 
 	ListenAddrs     []ma.Multiaddr
 	AddrsFactory    bhost.AddrsFactory
+	ExternalAddrs   []bhost.ExternalAddr
 	ConnectionGater connmgr.ConnectionGater
 
 	ConnManager     connmgr.ConnManager
@@ -118,16 +166,56 @@ type Config struct {
 
 	EnableAutoRelay bool
 	AutoRelayOpts   []autorelay.Option
+	// AutoRelayWithRouting makes AutoRelay discover relay candidates through
+	// the configured Routing, see autorelay.NewRoutingPeerSource.
+	AutoRelayWithRouting bool
 	AutoNATConfig
 
 	EnableHolePunching  bool
 	HolePunchingOptions []holepunch.Option
 
+	EnableAddrAttestation  bool
+	AddrAttestationOptions []attestation.Option
+
+	IdentifyLimits  identify.Limits
+	IdentifyOptions []identify.Option
+	FastIdentify    bool
+
+	NegotiationCache    bool
+	NegotiationTimeouts map[protocol.ID]time.Duration
+	HandlerTimeouts     map[protocol.ID]time.Duration
+
+	// ServiceScopes is keyed by the default service name of the built-in
+	// service.
+	ServiceScopes map[string]ServiceScope
+
+	EnableNetworkMonitor  bool
+	NetworkMonitorOptions []netmon.Option
+
+	EnableSTUN  bool
+	STUNOptions []stun.Option
+
+	LowPowerProfile *event.PowerProfile
+	HealthCriteria  *bhost.HealthCriteria
+
+	DeduplicateConns     bool
+	DeduplicateConnsOpts []conndedup.Option
+
+	RecoverHandlerPanics bool
+
 	DisableMetrics       bool
 	PrometheusRegisterer prometheus.Registerer
+	// PrometheusRegisterers overrides PrometheusRegisterer for single subsystems.
+	PrometheusRegisterers map[metricshelper.Subsystem]prometheus.Registerer
+	// DisabledMetricsLabels are dropped from all metrics, see metricshelper.LabelFilter.
+	DisabledMetricsLabels []string
 
 	DialRanker network.DialRanker
 
+	// Clock is used by the swarm dial backoffs, the peerstore, identify and
+	// the relay service. Defaults to the system clock.
+	Clock clock.Clock
+
 	SwarmOpts []swarm.Option
 }
 
@@ -170,6 +258,9 @@ func (cfg *Config) makeSwarm(eventBus event.Bus, enableMetrics bool) (*swarm.Swa
 	if cfg.ConnectionGater != nil {
 		opts = append(opts, swarm.WithConnectionGater(cfg.ConnectionGater))
 	}
+	if cfg.ConnManager != nil {
+		opts = append(opts, swarm.WithConnManager(cfg.ConnManager))
+	}
 	if cfg.DialTimeout != 0 {
 		opts = append(opts, swarm.WithDialTimeout(cfg.DialTimeout))
 	}
@@ -182,10 +273,22 @@ func (cfg *Config) makeSwarm(eventBus event.Bus, enableMetrics bool) (*swarm.Swa
 	if cfg.DialRanker != nil {
 		opts = append(opts, swarm.WithDialRanker(cfg.DialRanker))
 	}
+	if cfg.Clock != nil {
+		opts = append(opts, swarm.WithClock(cfg.Clock))
+	}
+	if cfg.DeduplicateConns {
+		cmp, err := conndedup.Comparator(cfg.DeduplicateConnsOpts...)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, swarm.WithConnComparator(cmp))
+	}
 
 	if enableMetrics {
 		opts = append(opts,
-			swarm.WithMetricsTracer(swarm.NewMetricsTracer(swarm.WithRegisterer(cfg.PrometheusRegisterer))))
+			swarm.WithMetricsTracer(swarm.NewMetricsTracer(
+				swarm.WithRegisterer(cfg.metricsRegisterer(metricshelper.SubsystemSwarm)),
+				swarm.WithDisabledLabels(cfg.DisabledMetricsLabels...))))
 	}
 	// TODO: Make the swarm implementation configurable.
 	return swarm.NewSwarm(pid, cfg.Peerstore, eventBus, opts...)
@@ -194,7 +297,32 @@ func (cfg *Config) makeSwarm(eventBus event.Bus, enableMetrics bool) (*swarm.Swa
 func (cfg *Config) addTransports() ([]fx.Option, error) {
 	fxopts := []fx.Option{
 		fx.WithLogger(func() fxevent.Logger { return getFXLogger() }),
-		fx.Provide(fx.Annotate(tptu.New, fx.ParamTags(`name:"security"`))),
+		fx.Provide(fx.Annotate(
+			func(security []sec.SecureTransport, muxers []tptu.StreamMuxer, psk pnet.PSK, rcmgr network.ResourceManager, gater connmgr.ConnectionGater, eventBus event.Bus) (transport.Upgrader, error) {
+				opts := []tptu.Option{tptu.WithEventBus(eventBus)}
+				if cfg.DisallowInsecure {
+					opts = append(opts, tptu.DisallowInsecure())
+				}
+				if cfg.HandshakeWorkers > 0 {
+					opts = append(opts, tptu.WithHandshakeLimits(cfg.HandshakeWorkers, cfg.HandshakeQueueLen))
+				}
+				if cfg.ListenerUpgradeWorkers > 0 {
+					opts = append(opts, tptu.WithListenerUpgradeLimits(cfg.ListenerUpgradeWorkers, cfg.ListenerUpgradeQueueLen))
+				}
+				if cfg.Admission != nil {
+					opts = append(opts, tptu.WithAdmission(*cfg.Admission))
+				}
+				for _, o := range cfg.HandshakeObservers {
+					opts = append(opts, tptu.WithHandshakeObserver(o))
+				}
+				if (cfg.HandshakeWorkers > 0 || cfg.ListenerUpgradeWorkers > 0) && !cfg.DisableMetrics {
+					opts = append(opts, tptu.WithMetricsTracer(
+						tptu.NewMetricsTracer(tptu.WithRegisterer(cfg.metricsRegisterer(metricshelper.SubsystemUpgrader)))))
+				}
+				return tptu.New(security, muxers, psk, rcmgr, gater, opts...)
+			},
+			fx.ParamTags(`name:"security"`),
+		)),
 		fx.Supply(cfg.Muxers),
 		fx.Provide(func() connmgr.ConnectionGater { return cfg.ConnectionGater }),
 		fx.Provide(func() pnet.PSK { return cfg.PSK }),
@@ -220,10 +348,21 @@ func (cfg *Config) addTransports() ([]fx.Option, error) {
 		for _, s := range cfg.SecurityTransports {
 			fxName := fmt.Sprintf(`name:"security_%s"`, s.ID)
 			fxopts = append(fxopts, fx.Supply(fx.Annotate(s.ID, fx.ResultTags(fxName))))
+			params := []string{fxName}
+			if len(s.Options) > 0 {
+				// options are variadic, so they have to be the last argument of the constructor
+				optTag := fmt.Sprintf(`group:"securityopt_%s"`, s.ID)
+				params = make([]string, reflect.TypeOf(s.Constructor).NumIn())
+				params[0] = fxName
+				params[len(params)-1] = optTag
+				for _, opt := range s.Options {
+					fxopts = append(fxopts, fx.Supply(fx.Annotate(opt, fx.ResultTags(optTag))))
+				}
+			}
 			fxopts = append(fxopts,
 				fx.Provide(fx.Annotate(
 					s.Constructor,
-					fx.ParamTags(fxName),
+					fx.ParamTags(params...),
 					fx.As(new(sec.SecureTransport)),
 					fx.ResultTags(`group:"security_unordered"`),
 				)),
@@ -285,24 +424,80 @@ func (cfg *Config) addTransports() ([]fx.Option, error) {
 	if cfg.Relay {
 		fxopts = append(fxopts, fx.Invoke(circuitv2.AddTransport))
 	}
+	if cfg.FastIdentify {
+		fxopts = append(fxopts, fx.Invoke(
+			fx.Annotate(
+				func(h *bhost.BasicHost, secs []sec.SecureTransport) {
+					p, ok := h.IDService().(identify.HandshakeSnapshotProvider)
+					if !ok {
+						return
+					}
+					for _, s := range secs {
+						if st, ok := s.(sec.IdentifySnapshotter); ok {
+							st.SetIdentifySnapshot(p.HandshakeSnapshot)
+						}
+					}
+				},
+				fx.ParamTags("", `name:"security"`),
+			)),
+		)
+	}
 	return fxopts, nil
 }
 
+// metricsRegisterer returns the Registerer for the metrics of subsystem s.
+func (cfg *Config) metricsRegisterer(s metricshelper.Subsystem) prometheus.Registerer {
+	return metricshelper.Registerers{Default: cfg.PrometheusRegisterer, Subsystems: cfg.PrometheusRegisterers}.For(s)
+}
+
 func (cfg *Config) newBasicHost(swrm *swarm.Swarm, eventBus event.Bus) (*bhost.BasicHost, error) {
+	var serviceScopes map[string]bhost.ServiceScope
+	if len(cfg.ServiceScopes) > 0 {
+		serviceScopes = make(map[string]bhost.ServiceScope, len(cfg.ServiceScopes))
+		for service, scope := range cfg.ServiceScopes {
+			serviceScopes[service] = bhost.ServiceScope{Name: scope.Name, ReservationPriority: scope.ReservationPriority}
+		}
+	}
+	identifyOpts, relayOpts := cfg.IdentifyOptions, cfg.RelayServiceOpts
+	if cfg.Clock != nil {
+		// Prepended so that options set explicitly by the user take precedence.
+		identifyOpts = append([]identify.Option{identify.WithClock(cfg.Clock)}, identifyOpts...)
+		relayOpts = append([]relayv2.Option{relayv2.WithClock(cfg.Clock)}, relayOpts...)
+	}
+	if cfg.FastIdentify {
+		identifyOpts = append([]identify.Option{identify.FastIdentify()}, identifyOpts...)
+	}
+
 	h, err := bhost.NewHost(swrm, &bhost.HostOpts{
-		EventBus:             eventBus,
-		ConnManager:          cfg.ConnManager,
-		AddrsFactory:         cfg.AddrsFactory,
-		NATManager:           cfg.NATManager,
-		EnablePing:           !cfg.DisablePing,
-		UserAgent:            cfg.UserAgent,
-		ProtocolVersion:      cfg.ProtocolVersion,
-		EnableHolePunching:   cfg.EnableHolePunching,
-		HolePunchingOptions:  cfg.HolePunchingOptions,
-		EnableRelayService:   cfg.EnableRelayService,
-		RelayServiceOpts:     cfg.RelayServiceOpts,
-		EnableMetrics:        !cfg.DisableMetrics,
-		PrometheusRegisterer: cfg.PrometheusRegisterer,
+		EventBus:               eventBus,
+		ConnManager:            cfg.ConnManager,
+		AddrsFactory:           cfg.AddrsFactory,
+		ExternalAddrs:          cfg.ExternalAddrs,
+		NATManager:             cfg.NATManager,
+		EnablePing:             !cfg.DisablePing,
+		UserAgent:              cfg.UserAgent,
+		ProtocolVersion:        cfg.ProtocolVersion,
+		EnableHolePunching:     cfg.EnableHolePunching,
+		HolePunchingOptions:    cfg.HolePunchingOptions,
+		EnableAddrAttestation:  cfg.EnableAddrAttestation,
+		AddrAttestationOptions: cfg.AddrAttestationOptions,
+		IdentifyLimits:         cfg.IdentifyLimits,
+		IdentifyOptions:        identifyOpts,
+		EnableNegotiationCache: cfg.NegotiationCache,
+		HandlerTimeouts:        cfg.HandlerTimeouts,
+		NegotiationTimeouts:    cfg.NegotiationTimeouts,
+		ServiceScopes:          serviceScopes,
+		EnableNetworkMonitor:   cfg.EnableNetworkMonitor,
+		NetworkMonitorOptions:  cfg.NetworkMonitorOptions,
+		LowPowerProfile:        cfg.LowPowerProfile,
+		HealthCriteria:         cfg.HealthCriteria,
+		RecoverHandlerPanics:   cfg.RecoverHandlerPanics,
+		EnableRelayService:     cfg.EnableRelayService,
+		RelayServiceOpts:       relayOpts,
+		EnableMetrics:          !cfg.DisableMetrics,
+		PrometheusRegisterer:   cfg.PrometheusRegisterer,
+		PrometheusRegisterers:  cfg.PrometheusRegisterers,
+		DisabledMetricsLabels:  cfg.DisabledMetricsLabels,
 	})
 	if err != nil {
 		return nil, err
@@ -324,9 +519,18 @@ func (cfg *Config) newBasicHost(swrm *swarm.Swarm, eventBus event.Bus) (*bhost.B
 //
 // This function consumes the config. Do not reuse it (really!).
 func (cfg *Config) NewNode() (host.Host, error) {
+	if err := cfg.validateBuild(); err != nil {
+		return nil, err
+	}
 	if cfg.EnableAutoRelay && !cfg.Relay {
 		return nil, fmt.Errorf("cannot enable autorelay; relay is not enabled")
 	}
+	if cfg.Admission != nil && len(cfg.UpgraderlessTransports) > 0 {
+		return nil, fmt.Errorf("cannot enable admission; it can't be enforced on the connections of %s, which don't use the upgrader", strings.Join(cfg.UpgraderlessTransports, ", "))
+	}
+	if cfg.AutoRelayWithRouting && cfg.Routing == nil {
+		return nil, fmt.Errorf("cannot discover relays through routing; routing is not enabled")
+	}
 	// If possible check that the resource manager conn limit is higher than the
 	// limit set in the conn manager.
 	if l, ok := cfg.ResourceManager.(connmgr.GetConnLimiter); ok {
@@ -337,12 +541,16 @@ func (cfg *Config) NewNode() (host.Host, error) {
 	}
 
 	if !cfg.DisableMetrics {
-		rcmgr.MustRegisterWith(cfg.PrometheusRegisterer)
+		rcmgr.MustRegisterWith(cfg.metricsRegisterer(metricshelper.SubsystemResourceManager))
+		// Reporters that export metrics themselves, like bandwidth.Counter.
+		if c, ok := cfg.Reporter.(prometheus.Collector); ok {
+			metricshelper.RegisterCollectors(cfg.metricsRegisterer(metricshelper.SubsystemBandwidth), c)
+		}
 	}
 
 	fxopts := []fx.Option{
 		fx.Provide(func() event.Bus {
-			return eventbus.NewBus(eventbus.WithMetricsTracer(eventbus.NewMetricsTracer(eventbus.WithRegisterer(cfg.PrometheusRegisterer))))
+			return eventbus.NewBus(eventbus.WithMetricsTracer(eventbus.NewMetricsTracer(eventbus.WithRegisterer(cfg.metricsRegisterer(metricshelper.SubsystemEventBus)))))
 		}),
 		fx.Provide(func(eventBus event.Bus, lifecycle fx.Lifecycle) (*swarm.Swarm, error) {
 			sw, err := cfg.makeSwarm(eventBus, !cfg.DisableMetrics)
@@ -392,23 +600,89 @@ func (cfg *Config) NewNode() (host.Host, error) {
 		)
 	}
 
+	// Relays advertise themselves through content routing, so that AutoRelay
+	// can discover them, see EnableAutoRelayWithRouting.
+	if cfg.Routing != nil && cfg.EnableRelayService {
+		fxopts = append(fxopts,
+			fx.Invoke(func(h *bhost.BasicHost, router routing.PeerRouting, lifecycle fx.Lifecycle) error {
+				cr, ok := router.(routing.ContentRouting)
+				if !ok {
+					return nil
+				}
+				a, err := relaysvc.NewAdvertiser(h, drouting.NewRoutingDiscovery(cr))
+				if err != nil {
+					return err
+				}
+				lifecycle.Append(fx.StopHook(a.Close))
+				return nil
+			}),
+		)
+	}
+
 	// Note: h.AddrsFactory may be changed by relayFinder, but non-relay version is
 	// used by AutoNAT below.
 	if cfg.EnableAutoRelay {
 		if !cfg.DisableMetrics {
 			mt := autorelay.WithMetricsTracer(
-				autorelay.NewMetricsTracer(autorelay.WithRegisterer(cfg.PrometheusRegisterer)))
+				autorelay.NewMetricsTracer(autorelay.WithRegisterer(cfg.metricsRegisterer(metricshelper.SubsystemAutoRelay))))
 			mtOpts := []autorelay.Option{mt}
 			cfg.AutoRelayOpts = append(mtOpts, cfg.AutoRelayOpts...)
 		}
+		newAutoRelay := func(h *bhost.BasicHost, lifecycle fx.Lifecycle, opts []autorelay.Option) (*autorelay.AutoRelay, error) {
+			ar, err := autorelay.NewAutoRelay(h, opts...)
+			if err != nil {
+				return nil, err
+			}
+			lifecycle.Append(fx.StartStopHook(ar.Start, ar.Close))
+			return ar, nil
+		}
+		if cfg.AutoRelayWithRouting {
+			fxopts = append(fxopts,
+				fx.Invoke(func(h *bhost.BasicHost, router routing.PeerRouting, lifecycle fx.Lifecycle) (*autorelay.AutoRelay, error) {
+					cr, ok := router.(routing.ContentRouting)
+					if !ok {
+						return nil, fmt.Errorf("cannot discover relays through routing; %T doesn't implement content routing", router)
+					}
+					src, err := autorelay.NewRoutingPeerSource(h, drouting.NewRoutingDiscovery(cr))
+					if err != nil {
+						return nil, err
+					}
+					return newAutoRelay(h, lifecycle, append([]autorelay.Option{autorelay.WithPeerSource(src)}, cfg.AutoRelayOpts...))
+				}),
+			)
+		} else {
+			fxopts = append(fxopts,
+				fx.Invoke(func(h *bhost.BasicHost, lifecycle fx.Lifecycle) (*autorelay.AutoRelay, error) {
+					return newAutoRelay(h, lifecycle, cfg.AutoRelayOpts)
+				}),
+			)
+		}
+	}
+
+	if cfg.DeduplicateConns {
+		fxopts = append(fxopts,
+			fx.Invoke(func(h *bhost.BasicHost, lifecycle fx.Lifecycle) error {
+				d, err := conndedup.New(h, cfg.DeduplicateConnsOpts...)
+				if err != nil {
+					return err
+				}
+				lifecycle.Append(fx.StopHook(d.Close))
+				return nil
+			}),
+		)
+	}
+
+	if cfg.EnableSTUN {
 		fxopts = append(fxopts,
-			fx.Invoke(func(h *bhost.BasicHost, lifecycle fx.Lifecycle) (*autorelay.AutoRelay, error) {
-				ar, err := autorelay.NewAutoRelay(h, cfg.AutoRelayOpts...)
+			fx.Invoke(func(h *bhost.BasicHost, cm *quicreuse.ConnManager, lifecycle fx.Lifecycle) (*stun.Service, error) {
+				opts := append([]stun.Option{stun.WithIdentify(h.IDService())}, cfg.STUNOptions...)
+				s, err := stun.New(h, cm, opts...)
 				if err != nil {
 					return nil, err
 				}
-				lifecycle.Append(fx.StartStopHook(ar.Start, ar.Close))
-				return ar, nil
+				h.AddExternalAddrSource(s)
+				lifecycle.Append(fx.StartStopHook(s.Start, s.Close))
+				return s, nil
 			}),
 		)
 	}
@@ -446,76 +720,22 @@ func (cfg *Config) addAutoNAT(h *bhost.BasicHost) error {
 	}
 	if !cfg.DisableMetrics {
 		autonatOpts = append(autonatOpts, autonat.WithMetricsTracer(
-			autonat.NewMetricsTracer(autonat.WithRegisterer(cfg.PrometheusRegisterer)),
+			autonat.NewMetricsTracer(autonat.WithRegisterer(cfg.metricsRegisterer(metricshelper.SubsystemAutoNAT))),
 		))
 	}
+	if scope, ok := cfg.ServiceScopes[autonat.ServiceName]; ok {
+		autonatOpts = append(autonatOpts, autonat.WithServiceScope(scope.Name, scope.ReservationPriority))
+	}
 	if cfg.AutoNATConfig.ThrottleInterval != 0 {
 		autonatOpts = append(autonatOpts,
 			autonat.WithThrottling(cfg.AutoNATConfig.ThrottleGlobalLimit, cfg.AutoNATConfig.ThrottleInterval),
 			autonat.WithPeerThrottling(cfg.AutoNATConfig.ThrottlePeerLimit))
 	}
 	if cfg.AutoNATConfig.EnableService {
-		autonatPrivKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
-		if err != nil {
-			return err
-		}
-		ps, err := pstoremem.NewPeerstore()
-		if err != nil {
-			return err
-		}
-
-		// Pull out the pieces of the config that we _actually_ care about.
-		// Specifically, don't set up things like listeners, identify, etc.
-		autoNatCfg := Config{
-			Transports:         cfg.Transports,
-			Muxers:             cfg.Muxers,
-			SecurityTransports: cfg.SecurityTransports,
-			Insecure:           cfg.Insecure,
-			PSK:                cfg.PSK,
-			ConnectionGater:    cfg.ConnectionGater,
-			Reporter:           cfg.Reporter,
-			PeerKey:            autonatPrivKey,
-			Peerstore:          ps,
-			DialRanker:         swarm.NoDelayDialRanker,
-			SwarmOpts: []swarm.Option{
-				// It is better to disable black hole detection and just attempt a dial for autonat
-				swarm.WithUDPBlackHoleConfig(false, 0, 0),
-				swarm.WithIPv6BlackHoleConfig(false, 0, 0),
-			},
-		}
-
-		fxopts, err := autoNatCfg.addTransports()
-		if err != nil {
-			return err
-		}
-		var dialer *swarm.Swarm
-
-		fxopts = append(fxopts,
-			fx.Provide(eventbus.NewBus),
-			fx.Provide(func(lifecycle fx.Lifecycle, b event.Bus) (*swarm.Swarm, error) {
-				lifecycle.Append(fx.Hook{
-					OnStop: func(context.Context) error {
-						return ps.Close()
-					}})
-				var err error
-				dialer, err = autoNatCfg.makeSwarm(b, false)
-				return dialer, err
-
-			}),
-			fx.Provide(func() crypto.PrivKey { return autonatPrivKey }),
-		)
-		app := fx.New(fxopts...)
-		if err := app.Err(); err != nil {
-			return err
-		}
-		err = app.Start(context.Background())
+		dialer, err := cfg.makeAutoNATServiceDialer()
 		if err != nil {
 			return err
 		}
-		go func() {
-			<-dialer.Done() // The swarm used for autonat has closed, we can cleanup now
-			app.Stop(context.Background())
-		}()
 		autonatOpts = append(autonatOpts, autonat.EnableService(dialer))
 	}
 	if cfg.AutoNATConfig.ForceReachability != nil {
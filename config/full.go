@@ -0,0 +1,88 @@
+//go:build !libp2plite
+
+package config
+
+import (
+	"context"
+	"crypto/rand"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+
+	"go.uber.org/fx"
+)
+
+// validateBuild checks that the config doesn't request any subsystems that have
+// been compiled out of this build.
+func (cfg *Config) validateBuild() error { return nil }
+
+// makeAutoNATServiceDialer constructs the dedicated swarm used by the AutoNAT
+// service to dial back peers.
+func (cfg *Config) makeAutoNATServiceDialer() (*swarm.Swarm, error) {
+	autonatPrivKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		return nil, err
+	}
+
+	// Pull out the pieces of the config that we _actually_ care about.
+	// Specifically, don't set up things like listeners, identify, etc.
+	autoNatCfg := Config{
+		Transports:         cfg.Transports,
+		Muxers:             cfg.Muxers,
+		SecurityTransports: cfg.SecurityTransports,
+		Insecure:           cfg.Insecure,
+		DisallowInsecure:   cfg.DisallowInsecure,
+		PSK:                cfg.PSK,
+		ConnectionGater:    cfg.ConnectionGater,
+		Reporter:           cfg.Reporter,
+		PeerKey:            autonatPrivKey,
+		Peerstore:          ps,
+		DialRanker:         swarm.NoDelayDialRanker,
+		SwarmOpts: []swarm.Option{
+			// It is better to disable black hole detection and just attempt a dial for autonat
+			swarm.WithUDPBlackHoleConfig(false, 0, 0),
+			swarm.WithIPv6BlackHoleConfig(false, 0, 0),
+		},
+	}
+
+	fxopts, err := autoNatCfg.addTransports()
+	if err != nil {
+		return nil, err
+	}
+	var dialer *swarm.Swarm
+
+	fxopts = append(fxopts,
+		fx.Provide(eventbus.NewBus),
+		fx.Provide(func(lifecycle fx.Lifecycle, b event.Bus) (*swarm.Swarm, error) {
+			lifecycle.Append(fx.Hook{
+				OnStop: func(context.Context) error {
+					return ps.Close()
+				}})
+			var err error
+			dialer, err = autoNatCfg.makeSwarm(b, false)
+			return dialer, err
+
+		}),
+		fx.Provide(func() crypto.PrivKey { return autonatPrivKey }),
+	)
+	app := fx.New(fxopts...)
+	if err := app.Err(); err != nil {
+		return nil, err
+	}
+	err = app.Start(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-dialer.Done() // The swarm used for autonat has closed, we can cleanup now
+		app.Stop(context.Background())
+	}()
+	return dialer, nil
+}
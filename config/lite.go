@@ -0,0 +1,28 @@
+//go:build libp2plite
+
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+)
+
+// errNotInLiteBuild is returned when a subsystem that has been compiled out
+// of libp2plite builds is requested.
+var errNotInLiteBuild = errors.New("not available in libp2plite builds")
+
+func (cfg *Config) validateBuild() error {
+	if cfg.EnableRelayService {
+		return fmt.Errorf("relay service: %w", errNotInLiteBuild)
+	}
+	if cfg.AutoNATConfig.EnableService {
+		return fmt.Errorf("autonat service: %w", errNotInLiteBuild)
+	}
+	return nil
+}
+
+func (cfg *Config) makeAutoNATServiceDialer() (*swarm.Swarm, error) {
+	return nil, fmt.Errorf("autonat service: %w", errNotInLiteBuild)
+}
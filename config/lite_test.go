@@ -0,0 +1,25 @@
+//go:build libp2plite
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLiteBuildRejectsCompiledOutServices(t *testing.T) {
+	cfg := &Config{EnableRelayService: true}
+	_, err := cfg.NewNode()
+	require.ErrorIs(t, err, errNotInLiteBuild)
+	require.ErrorContains(t, err, "relay service")
+
+	cfg = &Config{}
+	cfg.AutoNATConfig.EnableService = true
+	_, err = cfg.NewNode()
+	require.ErrorIs(t, err, errNotInLiteBuild)
+	require.ErrorContains(t, err, "autonat service")
+
+	_, err = cfg.makeAutoNATServiceDialer()
+	require.ErrorIs(t, err, errNotInLiteBuild)
+}
@@ -0,0 +1,47 @@
+package connmgr
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// SupportsExpiringProtection evaluates if the provided ConnManager supports
+// expiring protections, and if so, it returns the ExpiringProtector object.
+func SupportsExpiringProtection(mgr ConnManager) (ExpiringProtector, bool) {
+	p, ok := mgr.(ExpiringProtector)
+	return p, ok
+}
+
+// Protection describes a protection placed on a peer.
+type Protection struct {
+	// Tag is the tag the protection was placed under.
+	Tag string
+	// Reason is a human-readable description of why the peer is protected,
+	// e.g. "during sync session". It is empty for protections placed with
+	// Protect.
+	Reason string
+	// Expires is the time the protection lapses. It is zero for protections
+	// that don't expire.
+	Expires time.Time
+}
+
+// ExpiringProtector is implemented by ConnManagers that support protections
+// which lapse automatically, so that a temporary protection isn't leaked if
+// the caller never calls Unprotect.
+//
+// Protections placed with ProtectFor and with Protect share the same tags:
+// each call replaces the protection previously placed under the same tag, and
+// Unprotect removes it.
+type ExpiringProtector interface {
+	// ProtectFor protects a peer under the tag for the duration ttl. The
+	// reason is reported by Protections.
+	ProtectFor(id peer.ID, tag string, reason string, ttl time.Duration)
+
+	// Protections returns the protections currently placed on a peer, sorted
+	// by tag.
+	Protections(id peer.ID) []Protection
+
+	// ProtectedPeers returns the protections currently placed on all peers.
+	ProtectedPeers() map[peer.ID][]Protection
+}
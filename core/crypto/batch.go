@@ -0,0 +1,87 @@
+package crypto
+
+import (
+	"bytes"
+	"runtime"
+	"sync"
+)
+
+// minParallelBatch is the minimum number of signatures for VerifyBatch to
+// verify them concurrently.
+const minParallelBatch = 16
+
+// SignedData is a signature, to be verified with VerifyBatch.
+type SignedData struct {
+	Key  PubKey
+	Data []byte
+	Sig  []byte
+}
+
+// VerifyBatch verifies a batch of signatures, and returns whether each of them
+// is valid. The results are the same as calling Verify for each entry, but it
+// is faster for large batches:
+//   - identical entries (e.g. the same peer record received from many peers)
+//     are only verified once,
+//   - the work is spread over all CPUs.
+//
+// Ed25519 signatures are deliberately verified one by one: batch equations
+// are cofactored, while Verify is cofactorless, so a batch equation would
+// accept some signatures that Verify rejects.
+func VerifyBatch(batch []SignedData) []bool {
+	valid := make([]bool, len(batch))
+
+	// dups maps entries to the index of an identical entry verified before.
+	dups := make(map[int]int)
+	var todo []int
+	first := make(map[string]int, len(batch))
+	for i, sd := range batch {
+		if j, ok := first[string(sd.Sig)]; ok && bytes.Equal(sd.Data, batch[j].Data) && sd.Key.Equals(batch[j].Key) {
+			dups[i] = j
+			continue
+		}
+		first[string(sd.Sig)] = i
+		todo = append(todo, i)
+	}
+
+	verify := func(i int) {
+		ok, err := batch[i].Key.Verify(batch[i].Data, batch[i].Sig)
+		valid[i] = ok && err == nil
+	}
+	jobs := make([]func(), 0, len(todo))
+	for _, i := range todo {
+		i := i
+		jobs = append(jobs, func() { verify(i) })
+	}
+
+	if len(todo) < minParallelBatch {
+		for _, job := range jobs {
+			job()
+		}
+	} else {
+		workers := runtime.GOMAXPROCS(0)
+		if workers > len(jobs) {
+			workers = len(jobs)
+		}
+		ch := make(chan func(), len(jobs))
+		for _, job := range jobs {
+			ch <- job
+		}
+		close(ch)
+		var wg sync.WaitGroup
+		wg.Add(workers)
+		for w := 0; w < workers; w++ {
+			go func() {
+				defer wg.Done()
+				for job := range ch {
+					job()
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	for i, j := range dups {
+		valid[i] = valid[j]
+	}
+	return valid
+}
@@ -82,3 +82,27 @@ func runBenchmarkVerify(b *testing.B, numBytes int, t int) {
 		}
 	}
 }
+
+func BenchmarkVerifyBatchEd25519(b *testing.B) {
+	batch := make([]SignedData, 1000)
+	for i := range batch {
+		secret, public, err := GenerateKeyPair(Ed25519, 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+		someData := make([]byte, 100)
+		signature, err := secret.Sign(someData)
+		if err != nil {
+			b.Fatal(err)
+		}
+		batch[i] = SignedData{Key: public, Data: someData, Sig: signature}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, valid := range VerifyBatch(batch) {
+			if !valid {
+				b.Fatal("signature should be valid")
+			}
+		}
+	}
+}
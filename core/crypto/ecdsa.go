@@ -131,8 +131,11 @@ func (ePriv *ECDSAPrivateKey) Equals(o Key) bool {
 
 // Sign returns the signature of the input data
 func (ePriv *ECDSAPrivateKey) Sign(data []byte) (sig []byte, err error) {
+	return ePriv.signDigest(sha256.Sum256(data))
+}
+
+func (ePriv *ECDSAPrivateKey) signDigest(hash [sha256.Size]byte) (sig []byte, err error) {
 	defer func() { catch.HandlePanic(recover(), &err, "ECDSA signing") }()
-	hash := sha256.Sum256(data)
 	r, s, err := ecdsa.Sign(rand.Reader, ePriv.priv, hash[:])
 	if err != nil {
 		return nil, err
@@ -166,6 +169,10 @@ func (ePub *ECDSAPublicKey) Equals(o Key) bool {
 
 // Verify compares data to a signature
 func (ePub *ECDSAPublicKey) Verify(data, sigBytes []byte) (success bool, err error) {
+	return ePub.verifyDigest(sha256.Sum256(data), sigBytes)
+}
+
+func (ePub *ECDSAPublicKey) verifyDigest(hash [sha256.Size]byte, sigBytes []byte) (success bool, err error) {
 	defer func() {
 		catch.HandlePanic(recover(), &err, "ECDSA signature verification")
 
@@ -180,7 +187,5 @@ func (ePub *ECDSAPublicKey) Verify(data, sigBytes []byte) (success bool, err err
 		return false, err
 	}
 
-	hash := sha256.Sum256(data)
-
 	return ecdsa.Verify(ePub.pub, hash[:], sig.R, sig.S), nil
 }
@@ -186,6 +186,7 @@ func testKeyType(typ int, t *testing.T) {
 	}
 
 	testKeySignature(t, sk)
+	testKeyStreamSignature(t, sk)
 	testKeyEncoding(t, sk)
 	testKeyEquals(t, sk)
 	testKeyEquals(t, pk)
@@ -214,6 +215,116 @@ func testKeySignature(t *testing.T, sk PrivKey) {
 	}
 }
 
+func testKeyStreamSignature(t *testing.T, sk PrivKey) {
+	pk := sk.GetPublic()
+
+	text := make([]byte, 100000)
+	if _, err := rand.Read(text); err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := SignReader(sk, bytes.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid, err := pk.Verify(text, sig); err != nil || !valid {
+		t.Fatal("signature created with SignReader is invalid", err)
+	}
+
+	sig, err = sk.Sign(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid, err := VerifyReader(pk, bytes.NewReader(text), sig); err != nil || !valid {
+		t.Fatal("VerifyReader failed to verify signature", err)
+	}
+
+	text[0] ^= 1
+	if valid, _ := VerifyReader(pk, bytes.NewReader(text), sig); valid {
+		t.Fatal("VerifyReader accepted signature of different data")
+	}
+}
+
+func TestVerifyBatch(t *testing.T) {
+	var batch []SignedData
+	var expected []bool
+	for i := 0; i < 40; i++ {
+		sk, pk, err := test.RandTestKeyPair(KeyTypes[i%len(KeyTypes)], 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := []byte(fmt.Sprintf("message %d", i))
+		sig, err := sk.Sign(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch = append(batch, SignedData{Key: pk, Data: data, Sig: sig})
+		expected = append(expected, true)
+		switch i % 4 {
+		case 1:
+			// duplicate
+			batch = append(batch, SignedData{Key: pk, Data: data, Sig: sig})
+			expected = append(expected, true)
+		case 2:
+			// same signature, different data
+			batch = append(batch, SignedData{Key: pk, Data: []byte("other"), Sig: sig})
+			expected = append(expected, false)
+		case 3:
+			// invalid signature
+			batch = append(batch, SignedData{Key: pk, Data: data, Sig: []byte("invalid")})
+			expected = append(expected, false)
+		}
+	}
+
+	if valid := VerifyBatch(batch); !reflect.DeepEqual(valid, expected) {
+		t.Fatalf("expected %v, got %v", expected, valid)
+	}
+	if valid := VerifyBatch(batch[:5]); !reflect.DeepEqual(valid, expected[:5]) {
+		t.Fatalf("expected %v, got %v", expected[:5], valid)
+	}
+}
+
+func TestVerifyBatchEd25519(t *testing.T) {
+	var batch []SignedData
+	for i := 0; i < 100; i++ {
+		sk, pk, err := GenerateEd25519Key(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := []byte(fmt.Sprintf("message %d", i))
+		sig, err := sk.Sign(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch = append(batch, SignedData{Key: pk, Data: data, Sig: sig})
+	}
+	expected := make([]bool, len(batch))
+	for i := range expected {
+		expected[i] = true
+	}
+	if valid := VerifyBatch(batch); !reflect.DeepEqual(valid, expected) {
+		t.Fatalf("expected %v, got %v", expected, valid)
+	}
+
+	// a modified message
+	batch[10].Data = []byte("other")
+	expected[10] = false
+	// a non-canonical s, that ed25519.Verify rejects: s + l
+	sig := bytes.Clone(batch[70].Sig)
+	l := []byte{0xed, 0xd3, 0xf5, 0x5c, 0x1a, 0x63, 0x12, 0x58, 0xd6, 0x9c, 0xf7, 0xa2, 0xde, 0xf9, 0xde, 0x14,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x10}
+	var carry uint16
+	for i := range l {
+		sum := uint16(sig[32+i]) + uint16(l[i]) + carry
+		sig[32+i], carry = byte(sum), sum>>8
+	}
+	batch[70].Sig = sig
+	expected[70] = false
+	if valid := VerifyBatch(batch); !reflect.DeepEqual(valid, expected) {
+		t.Fatalf("expected %v, got %v", expected, valid)
+	}
+}
+
 func testKeyEncoding(t *testing.T, sk PrivKey) {
 	skbm, err := MarshalPrivateKey(sk)
 	if err != nil {
@@ -43,6 +43,10 @@ func GenerateRSAKeyPair(bits int, src io.Reader) (PrivKey, PubKey, error) {
 
 // Verify compares a signature against input data
 func (pk *RsaPublicKey) Verify(data, sig []byte) (success bool, err error) {
+	return pk.verifyDigest(sha256.Sum256(data), sig)
+}
+
+func (pk *RsaPublicKey) verifyDigest(hashed [sha256.Size]byte, sig []byte) (success bool, err error) {
 	defer func() {
 		catch.HandlePanic(recover(), &err, "RSA signature verification")
 
@@ -51,7 +55,6 @@ func (pk *RsaPublicKey) Verify(data, sig []byte) (success bool, err error) {
 			success = false
 		}
 	}()
-	hashed := sha256.Sum256(data)
 	err = rsa.VerifyPKCS1v15(&pk.k, crypto.SHA256, hashed[:], sig)
 	if err != nil {
 		return false, err
@@ -81,8 +84,11 @@ func (pk *RsaPublicKey) Equals(k Key) bool {
 
 // Sign returns a signature of the input data
 func (sk *RsaPrivateKey) Sign(message []byte) (sig []byte, err error) {
+	return sk.signDigest(sha256.Sum256(message))
+}
+
+func (sk *RsaPrivateKey) signDigest(hashed [sha256.Size]byte) (sig []byte, err error) {
 	defer func() { catch.HandlePanic(recover(), &err, "RSA signing") }()
-	hashed := sha256.Sum256(message)
 	return rsa.SignPKCS1v15(rand.Reader, &sk.sk, crypto.SHA256, hashed[:])
 }
 
@@ -73,9 +73,12 @@ func (k *Secp256k1PrivateKey) Equals(o Key) bool {
 
 // Sign returns a signature from input data
 func (k *Secp256k1PrivateKey) Sign(data []byte) (_sig []byte, err error) {
+	return k.signDigest(sha256.Sum256(data))
+}
+
+func (k *Secp256k1PrivateKey) signDigest(hash [sha256.Size]byte) (_sig []byte, err error) {
 	defer func() { catch.HandlePanic(recover(), &err, "secp256k1 signing") }()
 	key := (*secp256k1.PrivateKey)(k)
-	hash := sha256.Sum256(data)
 	sig := ecdsa.Sign(key, hash[:])
 
 	return sig.Serialize(), nil
@@ -109,6 +112,10 @@ func (k *Secp256k1PublicKey) Equals(o Key) bool {
 
 // Verify compares a signature against the input data
 func (k *Secp256k1PublicKey) Verify(data []byte, sigStr []byte) (success bool, err error) {
+	return k.verifyDigest(sha256.Sum256(data), sigStr)
+}
+
+func (k *Secp256k1PublicKey) verifyDigest(hash [sha256.Size]byte, sigStr []byte) (success bool, err error) {
 	defer func() {
 		catch.HandlePanic(recover(), &err, "secp256k1 signature verification")
 
@@ -122,6 +129,5 @@ func (k *Secp256k1PublicKey) Verify(data []byte, sigStr []byte) (success bool, e
 		return false, err
 	}
 
-	hash := sha256.Sum256(data)
 	return sig.Verify(hash[:], (*secp256k1.PublicKey)(k)), nil
 }
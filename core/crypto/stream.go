@@ -0,0 +1,67 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"io"
+)
+
+// digestSigner is implemented by private keys that sign the SHA-256 digest of
+// the data.
+type digestSigner interface {
+	signDigest(digest [sha256.Size]byte) ([]byte, error)
+}
+
+// digestVerifier is implemented by public keys that verify signatures over
+// the SHA-256 digest of the data.
+type digestVerifier interface {
+	verifyDigest(digest [sha256.Size]byte, sig []byte) (bool, error)
+}
+
+// SignReader signs the data read from r until EOF. The signature is the same
+// as the one returned by k.Sign for the same data.
+//
+// RSA, ECDSA and Secp256k1 keys sign a digest of the data, so it is hashed
+// while reading and never held in memory. Ed25519 signatures (and those of
+// keys implemented outside this package) are computed over the whole message,
+// so the data is read into memory first.
+func SignReader(k PrivKey, r io.Reader) ([]byte, error) {
+	if ds, ok := k.(digestSigner); ok {
+		digest, err := digestReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return ds.signDigest(digest)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return k.Sign(data)
+}
+
+// VerifyReader verifies that sig is a signature of the data read from r until
+// EOF, see SignReader.
+func VerifyReader(k PubKey, r io.Reader, sig []byte) (bool, error) {
+	if dv, ok := k.(digestVerifier); ok {
+		digest, err := digestReader(r)
+		if err != nil {
+			return false, err
+		}
+		return dv.verifyDigest(digest, sig)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return false, err
+	}
+	return k.Verify(data, sig)
+}
+
+func digestReader(r io.Reader) ([sha256.Size]byte, error) {
+	var digest [sha256.Size]byte
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return digest, err
+	}
+	h.Sum(digest[:0])
+	return digest, nil
+}
@@ -81,3 +81,63 @@ type EvtLocalAddressesUpdated struct {
 	// wrapped in a record.Envelope and signed by the Host's private key.
 	SignedPeerRecord *record.Envelope
 }
+
+// EvtLocalInterfaceAddrsChanged should be emitted when the set of addresses
+// assigned to the local network interfaces changes, e.g. when a mobile device
+// switches from WiFi to cellular.
+//
+// Subsystems can use this event to re-evaluate their listeners, re-run
+// reachability checks, and replace connections that are bound to addresses
+// that are no longer available, instead of waiting for them to time out.
+type EvtLocalInterfaceAddrsChanged struct {
+	// Current contains all interface addresses after the change.
+	Current []ma.Multiaddr
+	// Added contains the interface addresses that weren't present before.
+	Added []ma.Multiaddr
+	// Removed contains the interface addresses that are no longer present.
+	Removed []ma.Multiaddr
+}
+
+// EvtLocalPeerRecordUpdated should be emitted when the signed peer record of
+// the local host changes, but its addresses don't, e.g. when new address
+// attestations were collected from other peers (see peer.AddrAttestation).
+// Changes of the addresses are announced by EvtLocalAddressesUpdated.
+type EvtLocalPeerRecordUpdated struct {
+	// SignedPeerRecord contains our own updated peer.PeerRecord, wrapped in a
+	// record.Envelope and signed by the Host's private key.
+	SignedPeerRecord *record.Envelope
+}
+
+// ListenAddrExpansion describes how a listen address of the local host is
+// turned into the addresses it advertises.
+type ListenAddrExpansion struct {
+	// Configured is the address the listener was configured with, e.g.
+	// /ip4/0.0.0.0/tcp/0. It is nil if it is unknown.
+	Configured ma.Multiaddr
+	// Listen is the address the listener is bound to, e.g.
+	// /ip4/0.0.0.0/tcp/4001.
+	Listen ma.Multiaddr
+	// Expanded contains the interface addresses an unspecified Listen address
+	// resolves to, e.g. /ip4/192.168.1.2/tcp/4001. It contains Listen itself
+	// if Listen isn't unspecified.
+	Expanded []ma.Multiaddr
+	// Mapped is the external address of a port mapping on the NAT device, or
+	// nil if there is none.
+	Mapped ma.Multiaddr
+	// Observed contains the addresses other peers observed for the Expanded
+	// addresses.
+	Observed []ma.Multiaddr
+	// Advertised contains the addresses announced to the network that were
+	// derived from this listen address, after the host's address filters
+	// were applied.
+	Advertised []ma.Multiaddr
+}
+
+// EvtListenAddrExpansionsUpdated is emitted when the expansion of the local
+// host's listen addresses changes, e.g. when a network interface gets a new
+// address or a NAT mapping is established. It is meant for debugging which
+// addresses are advertised, and why.
+type EvtListenAddrExpansionsUpdated struct {
+	// Expansions contains an entry for every listen address.
+	Expansions []ListenAddrExpansion
+}
@@ -0,0 +1,15 @@
+package event
+
+import "time"
+
+// EvtClockSkewDetected is emitted when dials to multiple peers failed because
+// their certificates weren't valid at the local time, which usually means that
+// the local clock is wrong. Until the clock is corrected, dials over
+// certificate-based transports like WebTransport will keep failing.
+type EvtClockSkewDetected struct {
+	// Skew is the estimated minimum offset of the local clock. It is positive
+	// if the local clock is ahead, and negative if it is behind.
+	Skew time.Duration
+	// Peers is the number of peers whose dials failed because of the skew.
+	Peers int
+}
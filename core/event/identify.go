@@ -37,6 +37,16 @@ type EvtPeerIdentificationCompleted struct {
 	ObservedAddr multiaddr.Multiaddr
 }
 
+// EvtPeerMetadataChanged is emitted when identify stores a new value for
+// the well-known metadata of a peer in the peerstore, i.e. the agent version
+// (peerstore.AgentVersionKey) or the protocol version (peerstore.ProtocolVersionKey).
+type EvtPeerMetadataChanged struct {
+	// Peer is the ID of the peer whose metadata changed.
+	Peer peer.ID
+	// Keys are the keys of the metadata values that changed.
+	Keys []string
+}
+
 // EvtPeerIdentificationFailed is emitted when the initial identification round for a peer failed.
 type EvtPeerIdentificationFailed struct {
 	// Peer is the ID of the peer whose identification failed.
@@ -53,3 +53,18 @@ type EvtPeerConnectednessChanged struct {
 	// Connectedness is the new connectedness state.
 	Connectedness network.Connectedness
 }
+
+// EvtRedundantConnClosed is emitted when a connection to a peer is closed
+// because it's redundant: there's a better connection to the same peer, e.g.
+// a direct connection after a successful hole punch replacing the relayed
+// connection.
+type EvtRedundantConnClosed struct {
+	// Peer is the remote peer of the connections.
+	Peer peer.ID
+	// Closed is the connection that was closed.
+	Closed network.Conn
+	// Kept is the connection that was kept.
+	Kept network.Conn
+	// Reason is the name of the rule by which Kept was preferred over Closed.
+	Reason string
+}
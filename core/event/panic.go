@@ -0,0 +1,20 @@
+package event
+
+import "github.com/libp2p/go-libp2p/core/peer"
+
+// EvtPanicRecovered is emitted when a panic in a libp2p subsystem was
+// recovered, and the affected connection or stream was torn down (see the
+// core/panics package).
+//
+// Panics aren't attributed to a host: in a process running multiple hosts,
+// every host emits the event.
+type EvtPanicRecovered struct {
+	// Subsystem is the subsystem the panic occurred in, e.g. "identify".
+	Subsystem string
+	// Peer is the peer whose connection or stream was torn down, if any.
+	Peer peer.ID
+	// Panic is the value passed to panic, formatted with %v.
+	Panic string
+	// Stack is the stack trace of the goroutine that panicked.
+	Stack []byte
+}
@@ -0,0 +1,40 @@
+package event
+
+import "time"
+
+// PowerProfile describes how background activity is throttled while the host
+// is in low power mode. A zero value for any of the fields means that the
+// respective subsystem keeps its normal behavior.
+type PowerProfile struct {
+	// AddrUpdateInterval is the interval at which the host checks for changes
+	// of its own addresses.
+	AddrUpdateInterval time.Duration
+	// IdentifyPushMinInterval is the minimum interval between two rounds of
+	// identify pushes. Address and protocol changes that happen within the
+	// interval are coalesced into a single push.
+	IdentifyPushMinInterval time.Duration
+	// AutoNATRetryInterval is the interval between AutoNAT probes when the
+	// reachability is unknown or the confidence is low.
+	AutoNATRetryInterval time.Duration
+	// AutoNATRefreshInterval is the interval between AutoNAT probes once the
+	// reachability has been determined with confidence.
+	AutoNATRefreshInterval time.Duration
+	// RelayReservationRefreshMargin is how long before expiry a relay
+	// reservation is refreshed. Reservations are checked once per minute, so
+	// smaller values are raised to one minute.
+	RelayReservationRefreshMargin time.Duration
+	// KeepAliveInterval is the interval between two keep-alives on a
+	// connection, for the stream multiplexers that send keep-alives (see
+	// network.KeepAliveConn). It applies to open and new connections.
+	KeepAliveInterval time.Duration
+}
+
+// EvtLocalPowerStateChanged is emitted when the host switches between normal
+// and low power operation.
+type EvtLocalPowerStateChanged struct {
+	// LowPower is true if the host is in low power mode.
+	LowPower bool
+	// Profile is the profile that subsystems should apply. It is the zero value
+	// when LowPower is false.
+	Profile PowerProfile
+}
@@ -0,0 +1,31 @@
+package event
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// EvtRelayReservationLost is emitted by autorelay when a reservation with a
+// relay is lost, either because the connection to the relay was closed or
+// because the reservation couldn't be refreshed, e.g. because the relay
+// restarted.
+//
+// Autorelay immediately tries to obtain a new reservation with the relay, and
+// looks for other relays in parallel.
+type EvtRelayReservationLost struct {
+	// Relay is the relay the reservation was held with.
+	Relay peer.ID
+}
+
+// EvtRelayReservationObtained is emitted by autorelay when it obtains a
+// reservation with a relay.
+type EvtRelayReservationObtained struct {
+	// Relay is the relay the reservation is held with.
+	Relay peer.ID
+	// Expiration is the time the reservation expires, unless refreshed.
+	Expiration time.Time
+	// Rejoined is true if the reservation replaces a lost reservation with
+	// the same relay.
+	Rejoined bool
+}
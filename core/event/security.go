@@ -0,0 +1,33 @@
+package event
+
+import (
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// EvtSecurityDowngrade is emitted when the negotiation of the security
+// protocol of a connection looks like a downgrade:
+//
+//   - We dialed the peer, and the peer rejected the security protocol we
+//     prefer. This happens when the peer doesn't support it, but it can also
+//     mean that an attacker on the path removed it from the negotiation.
+//   - An insecure (plaintext) protocol was selected, although we support
+//     secure protocols.
+//
+// The connection is not closed, but the event can be used to audit how many
+// connections are affected.
+type EvtSecurityDowngrade struct {
+	// Peer is the remote peer.
+	Peer peer.ID
+	// RemoteAddr is the address of the remote peer.
+	RemoteAddr ma.Multiaddr
+	// Direction is the direction of the connection.
+	Direction network.Direction
+	// Preferred is the security protocol we prefer.
+	Preferred protocol.ID
+	// Selected is the security protocol that was selected.
+	Selected protocol.ID
+}
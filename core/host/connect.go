@@ -0,0 +1,95 @@
+package host
+
+import (
+	"context"
+	"errors"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ErrConnectOptionsNotSupported is returned by ConnectWithOptions if the host
+// doesn't implement OptionsConnector.
+var ErrConnectOptionsNotSupported = errors.New("host doesn't support connect options")
+
+// ConnectOptions are the requirements for the connection established by
+// OptionsConnector.ConnectWithOptions.
+type ConnectOptions struct {
+	// WaitForIdentify waits for the identify protocol to complete on the
+	// connection, including connections that already existed.
+	WaitForIdentify bool
+	// RequireDirect rejects relayed connections.
+	RequireDirect bool
+	// Transports restricts the connection to the given transports, see
+	// RequireTransport.
+	Transports []string
+}
+
+// ConnectOption is an option for OptionsConnector.ConnectWithOptions.
+type ConnectOption func(*ConnectOptions) error
+
+// WaitForIdentify waits for the identify protocol to complete before
+// returning, so that the protocols and addresses of the peer are known.
+func WaitForIdentify() ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.WaitForIdentify = true
+		return nil
+	}
+}
+
+// RequireDirect fails the connection attempt rather than accept a relayed
+// connection. Existing relayed connections are not reused.
+func RequireDirect() ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.RequireDirect = true
+		return nil
+	}
+}
+
+// RequireTransport only accepts connections over one of the given transports.
+// Transports are identified by the name of their multiaddr protocol, e.g.
+// "tcp", "ws", "wss", "quic-v1", "webtransport", "webrtc-direct" or
+// "webrtc".
+func RequireTransport(transports ...string) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if len(transports) == 0 {
+			return errors.New("no transports given")
+		}
+		o.Transports = append(o.Transports, transports...)
+		return nil
+	}
+}
+
+// ConnectInfo describes the connection established or reused by
+// OptionsConnector.ConnectWithOptions.
+type ConnectInfo struct {
+	Conn network.Conn
+	// Transport is the name of the transport of the connection, see
+	// RequireTransport. It's "p2p-circuit" for relayed connections.
+	Transport string
+	// Direct is false for relayed connections.
+	Direct bool
+	// Reused is true if the connection already existed.
+	Reused bool
+	// Identified is true if the identify protocol completed on the connection.
+	Identified bool
+}
+
+// OptionsConnector is implemented by hosts that can connect to a peer with
+// additional requirements on the connection.
+type OptionsConnector interface {
+	// ConnectWithOptions is like Host.Connect, but only uses (or establishes)
+	// a connection that meets the requirements set by opts, and returns a
+	// description of it.
+	ConnectWithOptions(ctx context.Context, pi peer.AddrInfo, opts ...ConnectOption) (ConnectInfo, error)
+}
+
+// ConnectWithOptions connects h to pi, if it implements OptionsConnector. See
+// OptionsConnector.ConnectWithOptions.
+func ConnectWithOptions(ctx context.Context, h Host, pi peer.AddrInfo, opts ...ConnectOption) (ConnectInfo, error) {
+	c, ok := h.(OptionsConnector)
+	if !ok {
+		return ConnectInfo{}, ErrConnectOptionsNotSupported
+	}
+	return c.ConnectWithOptions(ctx, pi, opts...)
+}
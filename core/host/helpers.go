@@ -1,6 +1,16 @@
 package host
 
-import "github.com/libp2p/go-libp2p/core/peer"
+import (
+	"errors"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// ErrProtocolRegistrationNotSupported is returned by RegisterProtocols for
+// hosts that don't implement ProtocolRegistrar.
+var ErrProtocolRegistrationNotSupported = errors.New("host doesn't support protocol registrations")
 
 // InfoFromHost returns a peer.AddrInfo struct with the Host's ID and all of its Addrs.
 func InfoFromHost(h Host) *peer.AddrInfo {
@@ -9,3 +19,13 @@ func InfoFromHost(h Host) *peer.AddrInfo {
 		Addrs: h.Addrs(),
 	}
 }
+
+// RegisterProtocols registers handlers on h, if it implements
+// ProtocolRegistrar. See ProtocolRegistrar.RegisterProtocols.
+func RegisterProtocols(h Host, handlers map[protocol.ID]network.StreamHandler) (ProtocolRegistration, error) {
+	r, ok := h.(ProtocolRegistrar)
+	if !ok {
+		return nil, ErrProtocolRegistrationNotSupported
+	}
+	return r.RegisterProtocols(handlers)
+}
@@ -73,3 +73,68 @@ type Host interface {
 	// EventBus returns the hosts eventbus
 	EventBus() event.Bus
 }
+
+// NetworkChangeSignaler is implemented by hosts that can be notified of
+// changes of the device's network configuration.
+type NetworkChangeSignaler interface {
+	// SignalNetworkChange signals to the host that the network configuration
+	// of the device might have changed (e.g. after switching from WiFi to
+	// cellular).
+	SignalNetworkChange()
+}
+
+// PowerStateSetter is implemented by hosts that can throttle their background
+// activity to save power.
+type PowerStateSetter interface {
+	// SetPowerState switches the host between normal and low power operation.
+	SetPowerState(lowPower bool)
+}
+
+// ProtocolRegistrar is implemented by hosts that can register a set of
+// protocol handlers as a unit, e.g. for application modules that are loaded
+// and unloaded at runtime.
+type ProtocolRegistrar interface {
+	// RegisterProtocols sets the handlers for the given protocols. It fails if
+	// any of the protocols already has a handler, e.g. one owned by another
+	// registration that wasn't closed, or one set using SetStreamHandler.
+	RegisterProtocols(handlers map[protocol.ID]network.StreamHandler) (ProtocolRegistration, error)
+}
+
+// ProtocolRegistration is a set of protocol handlers registered using
+// ProtocolRegistrar.RegisterProtocols.
+//
+// Handlers set using Host.SetStreamHandler (or removed using
+// Host.RemoveStreamHandler) for one of its protocols take over the ownership of
+// the protocol, and are not removed when the registration is closed.
+type ProtocolRegistration interface {
+	// Protocols returns the protocols owned by the registration.
+	Protocols() []protocol.ID
+
+	// Close removes the handlers of the registration, and waits for the
+	// handlers of in-flight streams to return. Streams that arrive while
+	// closing are reset.
+	Close() error
+}
+
+// HealthReporter is implemented by hosts that can report whether they're
+// ready to serve, e.g. for readiness checks of orchestration systems.
+type HealthReporter interface {
+	// Health evaluates the health criteria of the host.
+	Health() HealthReport
+}
+
+// HealthReport is the result of evaluating the health criteria of a host.
+type HealthReport struct {
+	// Healthy is true if all checks passed.
+	Healthy bool `json:"healthy"`
+	// Checks holds the result of every check that was performed.
+	Checks []HealthCheck `json:"checks"`
+}
+
+// HealthCheck is the result of a single health check.
+type HealthCheck struct {
+	Name string `json:"name"`
+	OK   bool   `json:"ok"`
+	// Message describes the observed state, e.g. the number of connections.
+	Message string `json:"message,omitempty"`
+}
@@ -0,0 +1,64 @@
+package host
+
+import (
+	"errors"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// ErrProtocolShimsNotSupported is returned by AddProtocolShim for hosts that
+// don't implement ProtocolShimRegistry.
+var ErrProtocolShimsNotSupported = errors.New("host doesn't support protocol shims")
+
+// ProtocolShim serves the streams of a legacy protocol with the handler of
+// another protocol, e.g. streams of "/myproto/1" with the handler of
+// "/myproto/2". This allows migrating a network to a new version of a
+// protocol without maintaining a full handler for the old version.
+type ProtocolShim struct {
+	// From is the legacy protocol accepted by the shim.
+	From protocol.ID
+	// To is the protocol whose handler serves the streams. The handler is
+	// looked up for every stream, so it can be set after the shim is added.
+	To protocol.ID
+	// Adapt translates a stream of protocol From into a stream of protocol
+	// To, e.g. by rewriting the messages read from and written to it. If it
+	// returns an error, the stream is reset. If Adapt is nil, streams are
+	// passed to the handler unchanged.
+	Adapt func(network.Stream) (network.Stream, error)
+}
+
+// ProtocolShimStats are the statistics of a protocol shim.
+type ProtocolShimStats struct {
+	// To is the protocol the shim translates to.
+	To protocol.ID
+	// Streams is the number of streams passed to the handler of To.
+	Streams uint64
+	// Failures is the number of streams that were reset, because Adapt
+	// failed or because there was no handler for To.
+	Failures uint64
+}
+
+// ProtocolShimRegistry is implemented by hosts that support protocol shims.
+type ProtocolShimRegistry interface {
+	// AddProtocolShim sets a handler for shim.From that passes the streams to
+	// the handler of shim.To. It fails if shim.From already has a handler.
+	// Like any other handler, the shim is replaced by setting a handler for
+	// shim.From using Host.SetStreamHandler.
+	AddProtocolShim(shim ProtocolShim) error
+	// RemoveProtocolShim removes the shim for the protocol from, if any.
+	RemoveProtocolShim(from protocol.ID)
+	// ProtocolShims returns the statistics of the shims, keyed by the
+	// protocol they accept.
+	ProtocolShims() map[protocol.ID]ProtocolShimStats
+}
+
+// AddProtocolShim adds a shim to h, if it implements ProtocolShimRegistry. See
+// ProtocolShimRegistry.AddProtocolShim.
+func AddProtocolShim(h Host, shim ProtocolShim) error {
+	r, ok := h.(ProtocolShimRegistry)
+	if !ok {
+		return ErrProtocolShimsNotSupported
+	}
+	return r.AddProtocolShim(shim)
+}
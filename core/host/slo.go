@@ -0,0 +1,43 @@
+package host
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// StreamOpenSLO is a latency objective for opening outbound streams for a
+// protocol, see StreamOpenSLOSetter.
+type StreamOpenSLO struct {
+	// Latency is the maximum time NewStream should take, including dialing
+	// the peer and negotiating the protocol.
+	Latency time.Duration
+	// Consecutive is the number of consecutive streams that exceed Latency,
+	// or fail to open, after which OnViolation is called. Defaults to 1.
+	Consecutive int
+	// OnViolation is called on its own goroutine each time Consecutive
+	// streams in a row missed the objective.
+	OnViolation func(StreamOpenSLOViolation)
+}
+
+// StreamOpenSLOViolation describes the streams that missed a StreamOpenSLO.
+type StreamOpenSLOViolation struct {
+	Protocol protocol.ID
+	// Peers are the peers of the streams, oldest first.
+	Peers []peer.ID
+	// Latencies are the times it took to open, or fail to open, the streams,
+	// oldest first.
+	Latencies []time.Duration
+	// Errors are the errors of the streams that failed to open, nil for the
+	// others.
+	Errors []error
+}
+
+// StreamOpenSLOSetter is implemented by hosts that track latency objectives
+// for opening outbound streams.
+type StreamOpenSLOSetter interface {
+	// SetStreamOpenSLO sets the latency objective for opening outbound
+	// streams for pid. An objective with a Latency of 0 removes it.
+	SetStreamOpenSLO(pid protocol.ID, slo StreamOpenSLO)
+}
@@ -12,7 +12,14 @@ var panicWriter io.Writer = os.Stderr
 // HandlePanic handles and logs panics.
 func HandlePanic(rerr interface{}, err *error, where string) {
 	if rerr != nil {
-		fmt.Fprintf(panicWriter, "caught panic: %s\n%s\n", rerr, debug.Stack())
-		*err = fmt.Errorf("panic in %s: %s", where, rerr)
+		_, *err = Caught(rerr, where)
 	}
 }
+
+// Caught logs the panic rerr, recovered in where. It returns the stack trace
+// of the goroutine that panicked, and the panic as an error.
+func Caught(rerr interface{}, where string) (stack []byte, err error) {
+	stack = debug.Stack()
+	fmt.Fprintf(panicWriter, "caught panic: %s\n%s\n", rerr, stack)
+	return stack, fmt.Errorf("panic in %s: %s", where, rerr)
+}
@@ -29,3 +29,35 @@ type Reporter interface {
 	GetBandwidthByPeer() map[peer.ID]Stats
 	GetBandwidthByProtocol() map[protocol.ID]Stats
 }
+
+// TransportReporter is optionally implemented by Reporters that break down
+// bandwidth by transport. The swarm calls it for all data sent and received on
+// streams, in addition to LogSentMessageStream and LogRecvMessageStream.
+// transport is the name of the transport protocol, e.g. "tcp" or "quic-v1".
+type TransportReporter interface {
+	LogSentMessageTransport(size int64, transport string)
+	LogRecvMessageTransport(size int64, transport string)
+}
+
+// PeerReporter is optionally implemented by Reporters that keep state per
+// peer. The swarm calls RemovePeer when the last connection to a peer is
+// closed, so that this state doesn't grow as peers come and go.
+type PeerReporter interface {
+	RemovePeer(peer.ID)
+}
+
+// NullReporter is a Reporter that discards all bandwidth, e.g. to use a
+// UsageCounter on its own.
+type NullReporter struct{}
+
+var _ Reporter = NullReporter{}
+
+func (NullReporter) LogSentMessage(int64)                             {}
+func (NullReporter) LogRecvMessage(int64)                             {}
+func (NullReporter) LogSentMessageStream(int64, protocol.ID, peer.ID) {}
+func (NullReporter) LogRecvMessageStream(int64, protocol.ID, peer.ID) {}
+func (NullReporter) GetBandwidthForPeer(peer.ID) Stats                { return Stats{} }
+func (NullReporter) GetBandwidthForProtocol(protocol.ID) Stats        { return Stats{} }
+func (NullReporter) GetBandwidthTotals() Stats                        { return Stats{} }
+func (NullReporter) GetBandwidthByPeer() map[peer.ID]Stats            { return nil }
+func (NullReporter) GetBandwidthByProtocol() map[protocol.ID]Stats    { return nil }
@@ -0,0 +1,327 @@
+package metrics
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// StreamReporter is optionally implemented by Reporters that count streams.
+// The swarm calls LogStream when the protocol of a stream is set, i.e. once
+// per stream after protocol negotiation.
+type StreamReporter interface {
+	LogStream(protocol.ID, peer.ID)
+}
+
+// Usage is the usage of a protocol by a peer within a time window.
+type Usage struct {
+	Streams  int64
+	BytesIn  int64
+	BytesOut int64
+}
+
+func (u *Usage) add(o Usage) {
+	u.Streams += o.Streams
+	u.BytesIn += o.BytesIn
+	u.BytesOut += o.BytesOut
+}
+
+// usageBucket is the usage within one interval.
+type usageBucket struct {
+	// epoch is the interval number the bucket was last reset for
+	epoch    atomic.Int64
+	streams  atomic.Int64
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+}
+
+// usageRing is a ring buffer of usage buckets, each covering one interval. It
+// is updated without locks: usage recorded concurrently with the reset of a
+// bucket for a new interval may be lost.
+type usageRing struct {
+	buckets []usageBucket
+}
+
+func newUsageRing(n int) *usageRing {
+	r := &usageRing{buckets: make([]usageBucket, n)}
+	for i := range r.buckets {
+		r.buckets[i].epoch.Store(-1)
+	}
+	return r
+}
+
+func (r *usageRing) bucket(epoch int64) *usageBucket {
+	b := &r.buckets[epoch%int64(len(r.buckets))]
+	if e := b.epoch.Load(); e < epoch && b.epoch.CompareAndSwap(e, epoch) {
+		b.streams.Store(0)
+		b.bytesIn.Store(0)
+		b.bytesOut.Store(0)
+	}
+	return b
+}
+
+// sum returns the usage in the last n intervals, including the current one.
+func (r *usageRing) sum(epoch int64, n int) (u Usage) {
+	for i := range r.buckets {
+		b := &r.buckets[i]
+		if e := b.epoch.Load(); e > epoch-int64(n) && e <= epoch {
+			u.add(Usage{Streams: b.streams.Load(), BytesIn: b.bytesIn.Load(), BytesOut: b.bytesOut.Load()})
+		}
+	}
+	return u
+}
+
+// idle returns true if there was no usage in the window covered by the ring.
+func (r *usageRing) idle(epoch int64) bool {
+	for i := range r.buckets {
+		if r.buckets[i].epoch.Load() > epoch-int64(len(r.buckets)) {
+			return false
+		}
+	}
+	return true
+}
+
+type usageConfig struct {
+	interval time.Duration
+	buckets  int
+	now      func() time.Time
+}
+
+// UsageOption configures a UsageCounter.
+type UsageOption func(*usageConfig) error
+
+// WithUsageBuckets sets the granularity and the length of the history kept by
+// a UsageCounter: usage is kept in n buckets covering interval each. The
+// longest window that can be queried is n*interval.
+func WithUsageBuckets(interval time.Duration, n int) UsageOption {
+	return func(c *usageConfig) error {
+		if interval <= 0 || n <= 0 {
+			return errors.New("usage bucket interval and count must be positive")
+		}
+		c.interval = interval
+		c.buckets = n
+		return nil
+	}
+}
+
+// WithUsageClock sets the function used to get the current time.
+func WithUsageClock(now func() time.Time) UsageOption {
+	return func(c *usageConfig) error {
+		c.now = now
+		return nil
+	}
+}
+
+// UsageCounter tracks the number of streams and the bytes transferred per peer
+// and protocol over a sliding time window. It can be used to detect peers
+// that use a protocol excessively, e.g. to lower their score or gate their
+// connections.
+//
+// UsageCounter implements Reporter, StreamReporter and PeerReporter: the usage
+// of a peer is removed when the swarm closes its last connection. All
+// bandwidth is also passed to the Reporter it wraps, so it can be used in
+// place of it (see the BandwidthReporter option).
+type UsageCounter struct {
+	Reporter
+
+	conf usageConfig
+
+	// usage maps peer IDs to a *sync.Map of protocol IDs to *usageRing
+	usage sync.Map
+}
+
+var (
+	_ StreamReporter    = (*UsageCounter)(nil)
+	_ TransportReporter = (*UsageCounter)(nil)
+	_ PeerReporter      = (*UsageCounter)(nil)
+)
+
+// NewUsageCounter creates a new UsageCounter, passing bandwidth through to r.
+// If r is nil, a new BandwidthCounter is used. By default, usage is kept in
+// 60 buckets covering one minute each.
+func NewUsageCounter(r Reporter, opts ...UsageOption) (*UsageCounter, error) {
+	conf := usageConfig{
+		interval: time.Minute,
+		buckets:  60,
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		if err := opt(&conf); err != nil {
+			return nil, err
+		}
+	}
+	if r == nil {
+		r = NewBandwidthCounter()
+	}
+	return &UsageCounter{
+		Reporter: r,
+		conf:     conf,
+	}, nil
+}
+
+func (uc *UsageCounter) epoch() int64 {
+	return uc.conf.now().UnixNano() / int64(uc.conf.interval)
+}
+
+func (uc *UsageCounter) record(proto protocol.ID, p peer.ID, f func(*usageBucket)) {
+	protos, ok := uc.usage.Load(p)
+	if !ok {
+		protos, _ = uc.usage.LoadOrStore(p, new(sync.Map))
+	}
+	r, ok := protos.(*sync.Map).Load(proto)
+	if !ok {
+		r, _ = protos.(*sync.Map).LoadOrStore(proto, newUsageRing(uc.conf.buckets))
+	}
+	f(r.(*usageRing).bucket(uc.epoch()))
+}
+
+// LogSentMessageStream records the size of an outgoing message over a stream,
+// and passes it to the wrapped Reporter.
+func (uc *UsageCounter) LogSentMessageStream(size int64, proto protocol.ID, p peer.ID) {
+	uc.Reporter.LogSentMessageStream(size, proto, p)
+	uc.record(proto, p, func(b *usageBucket) { b.bytesOut.Add(size) })
+}
+
+// LogRecvMessageStream records the size of an incoming message over a stream,
+// and passes it to the wrapped Reporter.
+func (uc *UsageCounter) LogRecvMessageStream(size int64, proto protocol.ID, p peer.ID) {
+	uc.Reporter.LogRecvMessageStream(size, proto, p)
+	uc.record(proto, p, func(b *usageBucket) { b.bytesIn.Add(size) })
+}
+
+// LogSentMessageTransport passes the size of an outgoing message to the
+// wrapped Reporter, if it's a TransportReporter.
+func (uc *UsageCounter) LogSentMessageTransport(size int64, transport string) {
+	if tr, ok := uc.Reporter.(TransportReporter); ok {
+		tr.LogSentMessageTransport(size, transport)
+	}
+}
+
+// LogRecvMessageTransport passes the size of an incoming message to the
+// wrapped Reporter, if it's a TransportReporter.
+func (uc *UsageCounter) LogRecvMessageTransport(size int64, transport string) {
+	if tr, ok := uc.Reporter.(TransportReporter); ok {
+		tr.LogRecvMessageTransport(size, transport)
+	}
+}
+
+// LogStream records a new stream with p speaking proto.
+func (uc *UsageCounter) LogStream(proto protocol.ID, p peer.ID) {
+	if sr, ok := uc.Reporter.(StreamReporter); ok {
+		sr.LogStream(proto, p)
+	}
+	uc.record(proto, p, func(b *usageBucket) { b.streams.Add(1) })
+}
+
+// numBuckets returns the number of buckets covering window.
+func (uc *UsageCounter) numBuckets(window time.Duration) int {
+	n := int((window + uc.conf.interval - 1) / uc.conf.interval)
+	if n > uc.conf.buckets {
+		n = uc.conf.buckets
+	}
+	return n
+}
+
+// RemovePeer removes the usage of p, and passes the call to the wrapped
+// Reporter, if it's a PeerReporter.
+func (uc *UsageCounter) RemovePeer(p peer.ID) {
+	if pr, ok := uc.Reporter.(PeerReporter); ok {
+		pr.RemovePeer(p)
+	}
+	uc.usage.Delete(p)
+}
+
+// GetUsage returns the usage of proto by p within the last window. The window
+// is rounded up to a multiple of the bucket interval.
+func (uc *UsageCounter) GetUsage(p peer.ID, proto protocol.ID, window time.Duration) Usage {
+	protos, ok := uc.usage.Load(p)
+	if !ok {
+		return Usage{}
+	}
+	r, ok := protos.(*sync.Map).Load(proto)
+	if !ok {
+		return Usage{}
+	}
+	return r.(*usageRing).sum(uc.epoch(), uc.numBuckets(window))
+}
+
+// GetUsageForPeer returns the usage of all protocols by p within the last
+// window.
+func (uc *UsageCounter) GetUsageForPeer(p peer.ID, window time.Duration) map[protocol.ID]Usage {
+	res := make(map[protocol.ID]Usage)
+	protos, ok := uc.usage.Load(p)
+	if !ok {
+		return res
+	}
+	epoch, n := uc.epoch(), uc.numBuckets(window)
+	protos.(*sync.Map).Range(func(proto, r any) bool {
+		if u := r.(*usageRing).sum(epoch, n); u != (Usage{}) {
+			res[proto.(protocol.ID)] = u
+		}
+		return true
+	})
+	return res
+}
+
+// GetUsageForProtocol returns the usage of proto by all peers within the last
+// window.
+func (uc *UsageCounter) GetUsageForProtocol(proto protocol.ID, window time.Duration) map[peer.ID]Usage {
+	epoch, n := uc.epoch(), uc.numBuckets(window)
+	res := make(map[peer.ID]Usage)
+	uc.usage.Range(func(p, protos any) bool {
+		r, ok := protos.(*sync.Map).Load(proto)
+		if !ok {
+			return true
+		}
+		if u := r.(*usageRing).sum(epoch, n); u != (Usage{}) {
+			res[p.(peer.ID)] = u
+		}
+		return true
+	})
+	return res
+}
+
+// GetUsageByPeer returns the usage of all protocols by each peer within the
+// last window.
+func (uc *UsageCounter) GetUsageByPeer(window time.Duration) map[peer.ID]Usage {
+	epoch, n := uc.epoch(), uc.numBuckets(window)
+	res := make(map[peer.ID]Usage)
+	uc.usage.Range(func(p, protos any) bool {
+		var u Usage
+		protos.(*sync.Map).Range(func(_, r any) bool {
+			u.add(r.(*usageRing).sum(epoch, n))
+			return true
+		})
+		if u != (Usage{}) {
+			res[p.(peer.ID)] = u
+		}
+		return true
+	})
+	return res
+}
+
+// TrimIdle removes the peers and protocols without any usage in the history
+// kept by the counter. Peers are removed when they disconnect, but calling
+// it periodically also removes the protocols a connected peer stopped using.
+func (uc *UsageCounter) TrimIdle() {
+	epoch := uc.epoch()
+	uc.usage.Range(func(p, protos any) bool {
+		empty := true
+		protos.(*sync.Map).Range(func(proto, r any) bool {
+			if r.(*usageRing).idle(epoch) {
+				protos.(*sync.Map).Delete(proto)
+			} else {
+				empty = false
+			}
+			return true
+		})
+		if empty {
+			uc.usage.Delete(p)
+		}
+		return true
+	})
+}
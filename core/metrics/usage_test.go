@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageCounter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	uc, err := NewUsageCounter(nil, WithUsageBuckets(time.Second, 5), WithUsageClock(func() time.Time { return now }))
+	require.NoError(t, err)
+
+	const p1, p2 = peer.ID("peer1"), peer.ID("peer2")
+	const foo, bar = protocol.ID("/foo"), protocol.ID("/bar")
+
+	uc.LogStream(foo, p1)
+	uc.LogSentMessageStream(10, foo, p1)
+	uc.LogRecvMessageStream(20, foo, p1)
+	uc.LogStream(bar, p1)
+	uc.LogStream(foo, p2)
+
+	now = now.Add(2 * time.Second)
+	uc.LogStream(foo, p1)
+	uc.LogRecvMessageStream(5, foo, p1)
+
+	require.Equal(t, Usage{Streams: 1, BytesIn: 5}, uc.GetUsage(p1, foo, time.Second))
+	require.Equal(t, Usage{Streams: 2, BytesIn: 25, BytesOut: 10}, uc.GetUsage(p1, foo, 3*time.Second))
+	require.Equal(t, Usage{}, uc.GetUsage(p2, bar, time.Minute))
+	require.Equal(t, map[protocol.ID]Usage{
+		foo: {Streams: 2, BytesIn: 25, BytesOut: 10},
+		bar: {Streams: 1},
+	}, uc.GetUsageForPeer(p1, time.Minute))
+	require.Equal(t, map[peer.ID]Usage{
+		p1: {Streams: 2, BytesIn: 25, BytesOut: 10},
+		p2: {Streams: 1},
+	}, uc.GetUsageForProtocol(foo, time.Minute))
+
+	require.Equal(t, map[peer.ID]Usage{
+		p1: {Streams: 3, BytesIn: 25, BytesOut: 10},
+		p2: {Streams: 1},
+	}, uc.GetUsageByPeer(time.Minute))
+
+	// The first buckets fall out of the window.
+	now = now.Add(3 * time.Second)
+	require.Equal(t, map[peer.ID]Usage{p1: {Streams: 1, BytesIn: 5}}, uc.GetUsageForProtocol(foo, time.Minute))
+
+	// Buckets are reused.
+	uc.LogStream(foo, p1)
+	require.Equal(t, Usage{Streams: 2, BytesIn: 5}, uc.GetUsage(p1, foo, time.Minute))
+
+	uc.TrimIdle()
+	_, ok := uc.usage.Load(p2)
+	require.False(t, ok)
+	protos, ok := uc.usage.Load(p1)
+	require.True(t, ok)
+	_, ok = protos.(*sync.Map).Load(bar)
+	require.False(t, ok)
+
+	uc.RemovePeer(p1)
+	_, ok = uc.usage.Load(p1)
+	require.False(t, ok)
+	require.Empty(t, uc.GetUsageForPeer(p1, time.Minute))
+}
+
+func TestUsageCounterOptions(t *testing.T) {
+	_, err := NewUsageCounter(nil, WithUsageBuckets(0, 5))
+	require.Error(t, err)
+	uc, err := NewUsageCounter(nil)
+	require.NoError(t, err)
+	require.IsType(t, &BandwidthCounter{}, uc.Reporter)
+}
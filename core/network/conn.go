@@ -2,6 +2,7 @@ package network
 
 import (
 	"context"
+	"crypto/x509"
 	"io"
 
 	ic "github.com/libp2p/go-libp2p/core/crypto"
@@ -49,6 +50,55 @@ type ConnectionState struct {
 	Transport string
 	// indicates whether StreamMultiplexer was selected using inlined muxer negotiation
 	UsedEarlyMuxerNegotiation bool
+	// TLS holds information about the TLS session of connections secured using
+	// TLS (the TLS security protocol, QUIC and WebTransport). It is nil for
+	// other connections.
+	TLS *TLSConnectionState
+	// ICE holds information about the ICE candidates used by WebRTC
+	// connections. It is nil for other connections.
+	ICE *ICEConnectionState
+	// IdentifySnapshot is the identify message the remote peer embedded in
+	// the security handshake (fast identify). It is nil if the peer didn't
+	// send one.
+	IdentifySnapshot []byte
+}
+
+// ICEConnectionState holds information about the candidate pair selected by
+// ICE for a WebRTC connection.
+type ICEConnectionState struct {
+	// LocalCandidateType and RemoteCandidateType are the types of the
+	// selected candidates: "host", "srflx", "prflx" or "relay". A "relay"
+	// candidate means that the connection is relayed through a TURN server.
+	LocalCandidateType  string
+	RemoteCandidateType string
+}
+
+// TLSConnectionState holds information about the TLS session of a connection,
+// e.g. for auditing the negotiated parameters.
+type TLSConnectionState struct {
+	// Version is the TLS version (e.g. tls.VersionTLS13).
+	Version uint16
+	// CipherSuite is the cipher suite (e.g. tls.TLS_AES_128_GCM_SHA256).
+	// Use tls.CipherSuiteName to get its name.
+	CipherSuite uint16
+	// NegotiatedProtocol is the application protocol negotiated using ALPN.
+	NegotiatedProtocol string
+	// PeerCertificates are the certificates presented by the peer.
+	PeerCertificates []*x509.Certificate
+	// PeerKeyExtension is the content of the libp2p public key extension of the
+	// peer's certificate. It is nil if the certificate doesn't contain the
+	// extension, e.g. for WebTransport, where the server's certificate is
+	// verified using its hash.
+	PeerKeyExtension *TLSKeyExtension
+}
+
+// TLSKeyExtension is the content of the libp2p public key extension of a
+// certificate, see https://github.com/libp2p/specs/blob/master/tls/tls.md.
+type TLSKeyExtension struct {
+	// PublicKey is the peer's host key, in the libp2p protobuf encoding.
+	PublicKey []byte
+	// Signature is the signature of the certificate's public key, made using the host key.
+	Signature []byte
 }
 
 // ConnSecurity is the interface that one can mix into a connection interface to
@@ -91,3 +141,16 @@ type ConnScoper interface {
 	// Scope returns the user view of this connection's resource scope
 	Scope() ConnScope
 }
+
+// ObservedAddrConn is implemented by connections whose transport learns our
+// address as observed by the remote peer, independently of identify.
+// For example, QUIC connections learn it using QUIC address discovery, if both
+// peers negotiated it.
+type ObservedAddrConn interface {
+	// NotifyObservedAddr calls f with our address as observed by the remote
+	// peer, every time the peer reports a new one. If the peer reported it
+	// already, f is called immediately. f must not block.
+	// It returns ErrObservedAddrNotSupported if the connection doesn't support
+	// reporting it.
+	NotifyObservedAddr(f func(ma.Multiaddr)) error
+}
@@ -3,6 +3,8 @@ package network
 import (
 	"context"
 	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
 )
 
 // DialPeerTimeout is the default timeout for a single call to `DialPeer`. When
@@ -15,12 +17,19 @@ type dialPeerTimeoutCtxKey struct{}
 type forceDirectDialCtxKey struct{}
 type useTransientCtxKey struct{}
 type simConnectCtxKey struct{ isClient bool }
+type streamDeadlineCtxKey struct{}
+type resetStreamOnCancelCtxKey struct{}
+type dialAddrFilterCtxKey struct{}
+type connValuesCtxKey struct{}
+type streamValuesCtxKey struct{}
 
 var noDial = noDialCtxKey{}
 var forceDirectDial = forceDirectDialCtxKey{}
 var useTransient = useTransientCtxKey{}
 var simConnectIsServer = simConnectCtxKey{}
 var simConnectIsClient = simConnectCtxKey{isClient: true}
+var streamDeadline = streamDeadlineCtxKey{}
+var resetStreamOnCancel = resetStreamOnCancelCtxKey{}
 
 // EXPERIMENTAL
 // WithForceDirectDial constructs a new context with an option that instructs the network
@@ -108,3 +117,120 @@ func GetUseTransient(ctx context.Context) (usetransient bool, reason string) {
 	}
 	return false, ""
 }
+
+// WithStreamDeadline constructs a new context with an option that instructs the host
+// to use the context's deadline as the initial read and write deadline of the stream
+// returned by NewStream. This also bounds protocol negotiation that is deferred until
+// the first read or write on the stream.
+func WithStreamDeadline(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, streamDeadline, reason)
+}
+
+// GetStreamDeadline returns true if the stream deadline option is set in the context.
+func GetStreamDeadline(ctx context.Context) (streamdeadline bool, reason string) {
+	v := ctx.Value(streamDeadline)
+	if v != nil {
+		return true, v.(string)
+	}
+	return false, ""
+}
+
+// WithResetStreamOnCancel constructs a new context with an option that instructs the host
+// to reset the stream returned by NewStream when the context is canceled, unless the
+// stream was closed or reset before.
+func WithResetStreamOnCancel(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, resetStreamOnCancel, reason)
+}
+
+// GetResetStreamOnCancel returns true if the reset stream on cancel option is set in the context.
+func GetResetStreamOnCancel(ctx context.Context) (reset bool, reason string) {
+	v := ctx.Value(resetStreamOnCancel)
+	if v != nil {
+		return true, v.(string)
+	}
+	return false, ""
+}
+
+type dialAddrFilter struct {
+	match  func(ma.Multiaddr) bool
+	reason string
+}
+
+// EXPERIMENTAL
+// WithDialAddrFilter constructs a new context with an option that instructs the network
+// to only use connections and addresses for which match returns true when dialing a peer.
+// Existing connections are only reused if their remote address matches, otherwise a new
+// connection is dialed, using only the peer's matching addresses.
+func WithDialAddrFilter(ctx context.Context, match func(ma.Multiaddr) bool, reason string) context.Context {
+	return context.WithValue(ctx, dialAddrFilterCtxKey{}, dialAddrFilter{match: match, reason: reason})
+}
+
+// EXPERIMENTAL
+// GetDialAddrFilter returns the address filter set in the context, or nil if none is set.
+func GetDialAddrFilter(ctx context.Context) (match func(ma.Multiaddr) bool, reason string) {
+	if f, ok := ctx.Value(dialAddrFilterCtxKey{}).(dialAddrFilter); ok {
+		return f.match, f.reason
+	}
+	return nil, ""
+}
+
+// WithConnValue constructs a new context with a value that is attached to the
+// connection established by a dial using this context, e.g. a tenant or trace ID.
+// The value is stored in the Extra field of the connection's Stat, and can be
+// retrieved from the connection and the streams opened on it using ConnValue and
+// StreamValue.
+//
+// Existing connections are reused as is, and if there are concurrent dials to a
+// peer, the new connection may carry the values of any of them. Use
+// WithStreamValue to attach a value to a single stream.
+func WithConnValue(ctx context.Context, key, val interface{}) context.Context {
+	return context.WithValue(ctx, connValuesCtxKey{}, withValue(GetConnValues(ctx), key, val))
+}
+
+// GetConnValues returns the connection values set in the context, or nil if none are set.
+// The returned map must not be modified.
+func GetConnValues(ctx context.Context) map[interface{}]interface{} {
+	v, _ := ctx.Value(connValuesCtxKey{}).(map[interface{}]interface{})
+	return v
+}
+
+// WithStreamValue constructs a new context with a value that is attached to the
+// stream returned by NewStream. The value is stored in the Extra field of the
+// stream's Stat, and can be retrieved using StreamValue.
+func WithStreamValue(ctx context.Context, key, val interface{}) context.Context {
+	return context.WithValue(ctx, streamValuesCtxKey{}, withValue(GetStreamValues(ctx), key, val))
+}
+
+// GetStreamValues returns the stream values set in the context, or nil if none are set.
+// The returned map must not be modified.
+func GetStreamValues(ctx context.Context) map[interface{}]interface{} {
+	v, _ := ctx.Value(streamValuesCtxKey{}).(map[interface{}]interface{})
+	return v
+}
+
+// withValue returns a copy of m with key set to val. Contexts may be shared
+// between goroutines, so m is never modified.
+func withValue(m map[interface{}]interface{}, key, val interface{}) map[interface{}]interface{} {
+	n := make(map[interface{}]interface{}, len(m)+1)
+	for k, v := range m {
+		n[k] = v
+	}
+	n[key] = val
+	return n
+}
+
+// ConnValue returns the value associated with key on the connection, or nil.
+// See WithConnValue.
+func ConnValue(c Conn, key interface{}) interface{} {
+	return c.Stat().Extra[key]
+}
+
+// StreamValue returns the value associated with key on the stream, falling back
+// to the values of the stream's connection, or nil. See WithStreamValue and
+// WithConnValue.
+func StreamValue(s Stream, key interface{}) interface{} {
+	if v, ok := s.Stat().Extra[key]; ok {
+		return v
+	}
+	return ConnValue(s.Conn(), key)
+}
@@ -57,3 +57,13 @@ func TestSimultaneousConnect(t *testing.T) {
 		require.Equal(t, "foo", reason)
 	})
 }
+
+func TestConnAndStreamValues(t *testing.T) {
+	ctx := WithConnValue(context.Background(), "foo", 1)
+	ctx2 := WithConnValue(ctx, "bar", 2)
+	ctx2 = WithStreamValue(ctx2, "baz", 3)
+	require.Equal(t, map[interface{}]interface{}{"foo": 1}, GetConnValues(ctx))
+	require.Equal(t, map[interface{}]interface{}{"foo": 1, "bar": 2}, GetConnValues(ctx2))
+	require.Equal(t, map[interface{}]interface{}{"baz": 3}, GetStreamValues(ctx2))
+	require.Nil(t, GetStreamValues(ctx))
+}
@@ -2,32 +2,58 @@ package network
 
 import (
 	"errors"
-	"net"
+
+	neterrors "github.com/libp2p/go-libp2p/core/network/errors"
 )
 
-type temporaryError string
+// The classes of errors returned by network operations, see the
+// core/network/errors package.
+var (
+	// ErrNoRemoteAddrs is returned when there are no addresses associated with a peer during a dial.
+	ErrNoRemoteAddrs = neterrors.ErrNoRemoteAddrs
+
+	// ErrNoConn is returned when attempting to open a stream to a peer with the NoDial
+	// option and no usable connection is available.
+	ErrNoConn = neterrors.ErrNoConn
 
-func (e temporaryError) Error() string   { return string(e) }
-func (e temporaryError) Temporary() bool { return true }
-func (e temporaryError) Timeout() bool   { return false }
+	// ErrLimitedConn is returned when attempting to open a stream to a peer with only a
+	// limited connection, e.g. a relayed one, without specifying the UseTransient option.
+	ErrLimitedConn = neterrors.ErrLimitedConn
 
-var _ net.Error = temporaryError("")
+	// ErrTransientConn is returned when attempting to open a stream to a peer with only a transient
+	// connection, without specifying the UseTransient option.
+	//
+	// Deprecated: use ErrLimitedConn.
+	ErrTransientConn = ErrLimitedConn
 
-// ErrNoRemoteAddrs is returned when there are no addresses associated with a peer during a dial.
-var ErrNoRemoteAddrs = errors.New("no remote addresses")
+	// ErrResourceLimitExceeded is returned when attempting to perform an operation that would
+	// exceed system resource limits.
+	ErrResourceLimitExceeded = neterrors.ErrResourceLimitExceeded
 
-// ErrNoConn is returned when attempting to open a stream to a peer with the NoDial
-// option and no usable connection is available.
-var ErrNoConn = errors.New("no usable connection to peer")
+	// ErrNegotiationFailed is returned when negotiating a protocol with the peer failed.
+	// This applies to the security protocol, the stream multiplexer and application protocols.
+	ErrNegotiationFailed = neterrors.ErrNegotiationFailed
 
-// ErrTransientConn is returned when attempting to open a stream to a peer with only a transient
-// connection, without specifying the UseTransient option.
-var ErrTransientConn = errors.New("transient connection to peer")
+	// ErrProtocolNotSupported is returned when the peer doesn't support any of the
+	// requested protocols. Errors matching ErrProtocolNotSupported also match ErrNegotiationFailed.
+	ErrProtocolNotSupported = neterrors.ErrProtocolNotSupported
 
-// ErrResourceLimitExceeded is returned when attempting to perform an operation that would
-// exceed system resource limits.
-var ErrResourceLimitExceeded = temporaryError("resource limit exceeded")
+	// ErrGated is returned when a connection was refused by the connection gater.
+	ErrGated = neterrors.ErrGated
+)
 
 // ErrResourceScopeClosed is returned when attempting to reserve resources in a closed resource
 // scope.
 var ErrResourceScopeClosed = errors.New("resource scope closed")
+
+// ErrObservedAddrNotSupported is returned by ObservedAddrConn.NotifyObservedAddr when the
+// transport or the remote peer doesn't support reporting observed addresses.
+var ErrObservedAddrNotSupported = errors.New("observed address not supported")
+
+// ErrNoDelayNotSupported is returned by NoDelayStream.SetNoDelay when the muxer
+// of the stream doesn't coalesce writes.
+var ErrNoDelayNotSupported = errors.New("no delay not supported")
+
+// ErrKeepAliveNotSupported is returned by KeepAliveConn.SetKeepAliveInterval
+// when the muxer of the connection doesn't send keep-alives.
+var ErrKeepAliveNotSupported = errors.New("keep-alive not supported")
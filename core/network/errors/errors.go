@@ -0,0 +1,79 @@
+// Package errors defines the classes of errors returned by network operations,
+// i.e. dialing peers, upgrading connections and opening streams. Errors are
+// classified using Classify, so that callers can match them using errors.Is,
+// instead of matching error strings:
+//
+//	if errors.Is(err, neterrors.ErrGated) {
+//		// the connection gater refused the connection
+//	}
+//
+// The classes are re-exported by the network package, e.g.
+// network.ErrGated is ErrGated.
+package errors
+
+import (
+	"errors"
+	"net"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	mss "github.com/multiformats/go-multistream"
+)
+
+type temporaryError string
+
+func (e temporaryError) Error() string   { return string(e) }
+func (e temporaryError) Temporary() bool { return true }
+func (e temporaryError) Timeout() bool   { return false }
+
+var _ net.Error = temporaryError("")
+
+// ErrNoRemoteAddrs is returned when there are no addresses associated with a peer during a dial.
+var ErrNoRemoteAddrs = errors.New("no remote addresses")
+
+// ErrNoConn is returned when attempting to open a stream to a peer with the NoDial
+// option and no usable connection is available.
+var ErrNoConn = errors.New("no usable connection to peer")
+
+// ErrLimitedConn is returned when attempting to open a stream to a peer that is
+// only connected through a limited connection, e.g. a relayed connection with a
+// data or duration limit, without specifying the UseTransient option.
+var ErrLimitedConn = errors.New("limited connection to peer")
+
+// ErrResourceLimitExceeded is returned when attempting to perform an operation that would
+// exceed system resource limits.
+var ErrResourceLimitExceeded = temporaryError("resource limit exceeded")
+
+// ErrNegotiationFailed is returned when negotiating a protocol with the peer failed.
+// This applies to the security protocol, the stream multiplexer and application protocols.
+var ErrNegotiationFailed = errors.New("protocol negotiation failed")
+
+// ErrProtocolNotSupported is returned when the peer doesn't support any of the
+// requested protocols. Errors matching ErrProtocolNotSupported also match ErrNegotiationFailed.
+var ErrProtocolNotSupported = errors.New("protocol not supported")
+
+// ErrGated is returned when a connection was refused by the connection gater.
+var ErrGated = errors.New("connection gated")
+
+// Classify returns an error with the same message as err, which additionally
+// matches class when using errors.Is.
+func Classify(class, err error) error {
+	return &classifiedError{class: class, err: err}
+}
+
+// Negotiation classifies err, an error that occurred while negotiating a
+// protocol with multistream-select, as ErrNegotiationFailed, and as
+// ErrProtocolNotSupported if the peer didn't support the protocol.
+func Negotiation(err error) error {
+	if errors.Is(err, mss.ErrNotSupported[protocol.ID]{}) {
+		err = Classify(ErrProtocolNotSupported, err)
+	}
+	return Classify(ErrNegotiationFailed, err)
+}
+
+type classifiedError struct {
+	class, err error
+}
+
+func (e *classifiedError) Error() string   { return e.err.Error() }
+func (e *classifiedError) Unwrap() []error { return []error{e.err, e.class} }
@@ -0,0 +1,37 @@
+package errors
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	mss "github.com/multiformats/go-multistream"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassify(t *testing.T) {
+	base := errors.New("gater disallows connection to peer")
+	err := Classify(ErrGated, base)
+	require.Equal(t, base.Error(), err.Error())
+	require.ErrorIs(t, err, ErrGated)
+	require.ErrorIs(t, err, base)
+	require.NotErrorIs(t, err, ErrNegotiationFailed)
+
+	wrapped := fmt.Errorf("dial failed: %w", err)
+	require.ErrorIs(t, wrapped, ErrGated)
+	require.ErrorIs(t, wrapped, base)
+}
+
+func TestNegotiation(t *testing.T) {
+	err := Negotiation(errors.New("stream reset"))
+	require.ErrorIs(t, err, ErrNegotiationFailed)
+	require.NotErrorIs(t, err, ErrProtocolNotSupported)
+
+	notSupported := mss.ErrNotSupported[protocol.ID]{Protos: []protocol.ID{"/foo"}}
+	err = Negotiation(fmt.Errorf("failed to negotiate protocol: %w", notSupported))
+	require.ErrorIs(t, err, ErrNegotiationFailed)
+	require.ErrorIs(t, err, ErrProtocolNotSupported)
+	require.ErrorIs(t, err, notSupported)
+}
@@ -0,0 +1,66 @@
+package network
+
+import (
+	"context"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// BandwidthClass is the amount of traffic a connection is expected to carry.
+type BandwidthClass uint8
+
+const (
+	BandwidthUnknown BandwidthClass = iota
+	// BandwidthLow is used for control traffic, e.g. DHT queries or pings.
+	BandwidthLow
+	// BandwidthHigh is used for bulk transfers, e.g. fetching blocks or
+	// streaming media.
+	BandwidthHigh
+)
+
+func (c BandwidthClass) String() string {
+	switch c {
+	case BandwidthLow:
+		return "low"
+	case BandwidthHigh:
+		return "high"
+	default:
+		return "unknown"
+	}
+}
+
+// Intent describes what a connection is going to be used for. It is attached
+// to the context of Connect, DialPeer or NewStream using WithIntent, and is
+// available to the dial ranker of the swarm (see swarm.DialRankInfo) and to the
+// resource manager, which sizes the scope of the connection (see IntentScope).
+//
+// The intent of a dial is also stored on the resulting connection, see
+// ConnIntent. As with WithConnValue, existing connections are reused as is.
+type Intent struct {
+	// Protocols are the protocols the caller is going to use, in order of
+	// preference.
+	Protocols []protocol.ID
+	// Bandwidth is the expected amount of traffic.
+	Bandwidth BandwidthClass
+}
+
+// intentKey is the key of the intent in the connection values.
+type intentKey struct{}
+
+// WithIntent constructs a new context with the intent of the dial.
+func WithIntent(ctx context.Context, intent Intent) context.Context {
+	return WithConnValue(ctx, intentKey{}, intent)
+}
+
+// GetIntent returns the intent set in the context.
+func GetIntent(ctx context.Context) (Intent, bool) {
+	intent, ok := GetConnValues(ctx)[intentKey{}].(Intent)
+	return intent, ok
+}
+
+// ConnIntent returns the intent of the dial that established the connection.
+// It is not set for inbound connections.
+func ConnIntent(c Conn) (Intent, bool) {
+	intent, ok := ConnValue(c, intentKey{}).(Intent)
+	return intent, ok
+}
@@ -61,6 +61,24 @@ type MuxedStream interface {
 	SetWriteDeadline(time.Time) error
 }
 
+// NoDelayStream is implemented by streams whose muxer may delay small writes
+// to send them together with the writes of other streams.
+type NoDelayStream interface {
+	// SetNoDelay controls whether writes on the stream are sent immediately,
+	// bypassing write coalescing. Use it for latency-critical traffic.
+	// It returns ErrNoDelayNotSupported if the muxer doesn't coalesce writes.
+	SetNoDelay(noDelay bool) error
+}
+
+// KeepAliveConn is implemented by connections whose muxer sends keep-alives.
+type KeepAliveConn interface {
+	// SetKeepAliveInterval changes the interval between two keep-alives. A
+	// value of 0 restores the interval configured on the muxer.
+	// It returns ErrKeepAliveNotSupported if the muxer doesn't send
+	// keep-alives.
+	SetKeepAliveInterval(time.Duration) error
+}
+
 // MuxedConn represents a connection to a remote peer that has been
 // extended to support stream multiplexing.
 //
@@ -0,0 +1,34 @@
+package network
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// NegotiationFailure describes a failed multistream negotiation.
+type NegotiationFailure struct {
+	Time      time.Time
+	Peer      peer.ID
+	Direction Direction
+	// Offered are the protocols of the remote peer: those it proposed on an
+	// inbound stream, or those it announced via identify for an outbound
+	// stream.
+	Offered []protocol.ID
+	// Supported are the protocols on our side: those we have handlers for on
+	// an inbound stream, or those we proposed on an outbound stream.
+	//
+	// Offered and Supported are limited to the protocol families involved in
+	// the negotiation, e.g. "/ipfs/kad" for "/ipfs/kad/1.0.0".
+	Supported []protocol.ID
+}
+
+// NegotiationFailureConn is implemented by connections that keep the
+// negotiation failures of their streams, e.g. to report them when
+// introspecting the network.
+type NegotiationFailureConn interface {
+	// RecordNegotiationFailure records a failed negotiation on a stream of
+	// the connection.
+	RecordNegotiationFailure(f NegotiationFailure)
+}
@@ -112,7 +112,12 @@ type Stats struct {
 	// Opened is the timestamp when this connection was opened.
 	Opened time.Time
 	// Transient indicates that this connection is transient and may be closed soon.
+	// For streams, it's inherited from the stream's connection.
 	Transient bool
+	// Early indicates that the stream was opened before the remote peer's
+	// supported protocols were known, e.g. before identify completed on a new
+	// connection. It's only set on streams.
+	Early bool
 	// Extra stores additional metadata about this connection.
 	Extra map[interface{}]interface{}
 }
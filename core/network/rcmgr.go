@@ -224,6 +224,14 @@ type ConnManagementScope interface {
 	SetPeer(peer.ID) error
 }
 
+// IntentScope is implemented by connection scopes that size their limits
+// according to the intent of the dial, see Intent.
+type IntentScope interface {
+	// SetIntent sets the intent of the dial that established the connection.
+	// It is called by the swarm before the connection is attached to its peer.
+	SetIntent(Intent)
+}
+
 // ConnScope is the user view of a connection scope
 type ConnScope interface {
 	ResourceScope
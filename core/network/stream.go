@@ -28,3 +28,32 @@ type Stream interface {
 	// Scope returns the user's view of this stream's resource scope
 	Scope() StreamScope
 }
+
+// StreamState summarizes the properties of a stream that protocols commonly
+// use to adjust their behavior, see GetStreamState.
+type StreamState struct {
+	// Direction is the direction of the stream.
+	Direction Direction
+	// ConnDirection is the direction of the stream's connection. It differs
+	// from Direction if the stream was opened by the side that accepted the
+	// connection.
+	ConnDirection Direction
+	// Limited indicates that the stream traverses a limited connection, e.g.
+	// a relayed connection, that may be closed soon.
+	Limited bool
+	// Early indicates that the stream was opened before the remote peer's
+	// supported protocols were known.
+	Early bool
+}
+
+// GetStreamState returns the StreamState of s.
+func GetStreamState(s Stream) StreamState {
+	stat := s.Stat()
+	cstat := s.Conn().Stat()
+	return StreamState{
+		Direction:     stat.Direction,
+		ConnDirection: cstat.Direction,
+		Limited:       stat.Transient || cstat.Transient,
+		Early:         stat.Early,
+	}
+}
@@ -0,0 +1,88 @@
+// Package panics quarantines panics in goroutines spawned by libp2p
+// subsystems. A bug triggered by a single peer then only tears down the
+// affected connection or stream, instead of taking down the whole process.
+package panics
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/internal/catch"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Report describes a recovered panic.
+type Report struct {
+	// Subsystem is the subsystem the panic occurred in, e.g. "identify".
+	Subsystem string
+	// Peer is the peer whose connection or stream was torn down. It's empty
+	// if the panic isn't associated with a peer.
+	Peer peer.ID
+	// Value is the value passed to panic.
+	Value interface{}
+	// Stack is the stack trace of the goroutine that panicked.
+	Stack []byte
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf("panic in %s (peer %s): %v", r.Subsystem, r.Peer, r.Value)
+}
+
+// Hook is called with every recovered panic, e.g. to send it to a crash
+// reporting service. Hooks are called synchronously by the goroutine that
+// panicked.
+type Hook func(Report)
+
+var (
+	hooksMx sync.Mutex
+	hooks   = make(map[*Hook]struct{})
+)
+
+// RegisterHook registers h to be called with every recovered panic in the
+// process, until the returned function is called.
+func RegisterHook(h Hook) (unregister func()) {
+	key := &h
+	hooksMx.Lock()
+	hooks[key] = struct{}{}
+	hooksMx.Unlock()
+	return func() {
+		hooksMx.Lock()
+		delete(hooks, key)
+		hooksMx.Unlock()
+	}
+}
+
+// Recover recovers a panic in the calling goroutine. It must be deferred
+// directly:
+//
+//	defer panics.Recover("identify", p, func() { str.Reset() })
+//
+// If the goroutine panicked, the panic is logged like the panics caught in
+// core, teardown (if not nil) is called to clean up the affected connection or
+// stream, and the panic is passed to all registered hooks.
+func Recover(subsystem string, p peer.ID, teardown func()) {
+	rerr := recover()
+	if rerr == nil {
+		return
+	}
+	stack, _ := catch.Caught(rerr, subsystem)
+	r := Report{
+		Subsystem: subsystem,
+		Peer:      p,
+		Value:     rerr,
+		Stack:     stack,
+	}
+	if teardown != nil {
+		teardown()
+	}
+
+	hooksMx.Lock()
+	hs := make([]Hook, 0, len(hooks))
+	for h := range hooks {
+		hs = append(hs, *h)
+	}
+	hooksMx.Unlock()
+	for _, h := range hs {
+		h(r)
+	}
+}
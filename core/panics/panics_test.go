@@ -0,0 +1,40 @@
+package panics
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecover(t *testing.T) {
+	var reports []Report
+	unregister := RegisterHook(func(r Report) { reports = append(reports, r) })
+
+	var tornDown bool
+	run := func() {
+		defer Recover("test", peer.ID("peer"), func() { tornDown = true })
+		panic("boom")
+	}
+	run()
+	require.True(t, tornDown)
+	require.Len(t, reports, 1)
+	require.Equal(t, "test", reports[0].Subsystem)
+	require.Equal(t, peer.ID("peer"), reports[0].Peer)
+	require.Equal(t, "boom", reports[0].Value)
+	require.NotEmpty(t, reports[0].Stack)
+
+	// no panic, no report
+	func() {
+		defer Recover("test", "", nil)
+	}()
+	require.Len(t, reports, 1)
+
+	unregister()
+	func() {
+		defer Recover("test", "", nil)
+		panic("boom")
+	}()
+	require.Len(t, reports, 1)
+}
@@ -0,0 +1,133 @@
+package peer
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/internal/catch"
+	"github.com/libp2p/go-libp2p/core/peer/pb"
+	"github.com/libp2p/go-libp2p/core/record"
+
+	ma "github.com/multiformats/go-multiaddr"
+
+	"google.golang.org/protobuf/proto"
+)
+
+//go:generate protoc --proto_path=$PWD:$PWD/../.. --go_out=. --go_opt=Mpb/addr_attestation.proto=./pb pb/addr_attestation.proto
+
+var _ record.Record = (*AddrAttestation)(nil)
+
+func init() {
+	record.RegisterType(&AddrAttestation{})
+}
+
+// AddrAttestationEnvelopeDomain is the domain string used for address attestations contained in an Envelope.
+const AddrAttestationEnvelopeDomain = "libp2p-addr-attestation"
+
+// AddrAttestationEnvelopePayloadType is the type hint used to identify address attestations in an Envelope.
+// No multicodec is registered for address attestations yet, this is the varint
+// encoding of 0x300000, the start of the private use range of the multicodec table.
+var AddrAttestationEnvelopePayloadType = []byte{0x80, 0x80, 0xc0, 0x01}
+
+// AddrAttestation is the attestation of a peer that it successfully dialed the
+// Subject at Addr. It's signed by the attesting peer, and can be included by
+// the Subject in its PeerRecord, as evidence that Addr is dialable by others:
+//
+//	att := &AddrAttestation{Subject: conn.RemotePeer(), Addr: conn.RemoteMultiaddr(), Timestamp: time.Now()}
+//	envelope, err := record.Seal(att, myPrivateKey)
+//
+// The attesting peer is the signer of the envelope, use AddrAttestationFromEnvelope
+// to get both.
+type AddrAttestation struct {
+	// Subject is the peer that was dialed.
+	Subject ID
+
+	// Addr is the address the Subject was dialed at.
+	Addr ma.Multiaddr
+
+	// Timestamp is the time of the dial.
+	Timestamp time.Time
+}
+
+// AddrAttestationFromEnvelope returns the address attestation contained in
+// envelope, and the peer that signed it.
+func AddrAttestationFromEnvelope(envelope *record.Envelope) (attester ID, att *AddrAttestation, err error) {
+	rec, err := envelope.Record()
+	if err != nil {
+		return "", nil, err
+	}
+	att, ok := rec.(*AddrAttestation)
+	if !ok {
+		return "", nil, fmt.Errorf("unexpected record type: %T", rec)
+	}
+	attester, err = IDFromPublicKey(envelope.PublicKey)
+	if err != nil {
+		return "", nil, err
+	}
+	return attester, att, nil
+}
+
+// Domain is used when signing and validating AddrAttestations contained in Envelopes.
+// It is constant for all AddrAttestation instances.
+func (a *AddrAttestation) Domain() string {
+	return AddrAttestationEnvelopeDomain
+}
+
+// Codec is a binary identifier for the AddrAttestation type. It is constant for all AddrAttestation instances.
+func (a *AddrAttestation) Codec() []byte {
+	return AddrAttestationEnvelopePayloadType
+}
+
+// UnmarshalRecord parses an AddrAttestation from a byte slice.
+// This method is called automatically when consuming a record.Envelope
+// whose PayloadType indicates that it contains an AddrAttestation.
+func (a *AddrAttestation) UnmarshalRecord(bytes []byte) (err error) {
+	if a == nil {
+		return fmt.Errorf("cannot unmarshal AddrAttestation to nil receiver")
+	}
+
+	defer func() { catch.HandlePanic(recover(), &err, "libp2p addr attestation unmarshal") }()
+
+	var msg pb.AddrAttestation
+	if err := proto.Unmarshal(bytes, &msg); err != nil {
+		return err
+	}
+	var id ID
+	if err := id.UnmarshalBinary(msg.SubjectId); err != nil {
+		return err
+	}
+	addr, err := ma.NewMultiaddrBytes(msg.Addr)
+	if err != nil {
+		return err
+	}
+	if msg.Timestamp == 0 {
+		return errors.New("missing timestamp")
+	}
+	*a = AddrAttestation{
+		Subject:   id,
+		Addr:      addr,
+		Timestamp: time.Unix(0, int64(msg.Timestamp)),
+	}
+	return nil
+}
+
+// MarshalRecord serializes an AddrAttestation to a byte slice.
+// This method is called automatically when constructing a routing.Envelope
+// using Seal.
+func (a *AddrAttestation) MarshalRecord() (res []byte, err error) {
+	defer func() { catch.HandlePanic(recover(), &err, "libp2p addr attestation marshal") }()
+
+	idBytes, err := a.Subject.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	if a.Addr == nil {
+		return nil, errors.New("missing address")
+	}
+	return proto.Marshal(&pb.AddrAttestation{
+		SubjectId: idBytes,
+		Addr:      a.Addr.Bytes(),
+		Timestamp: uint64(a.Timestamp.UnixNano()),
+	})
+}
@@ -0,0 +1,53 @@
+package peer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	. "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/libp2p/go-libp2p/core/test"
+)
+
+func TestPeerRecordWithAttestations(t *testing.T) {
+	priv, _, err := test.RandTestKeyPair(crypto.Ed25519, 256)
+	test.AssertNilError(t, err)
+	id, err := IDFromPrivateKey(priv)
+	test.AssertNilError(t, err)
+	attesterPriv, _, err := test.RandTestKeyPair(crypto.Ed25519, 256)
+	test.AssertNilError(t, err)
+	attester, err := IDFromPrivateKey(attesterPriv)
+	test.AssertNilError(t, err)
+
+	addrs := test.GenerateTestAddrs(3)
+	attest := func(subject ID, addr int) *record.Envelope {
+		env, err := record.Seal(&AddrAttestation{Subject: subject, Addr: addrs[addr], Timestamp: time.Now()}, attesterPriv)
+		test.AssertNilError(t, err)
+		return env
+	}
+	valid := attest(id, 1)
+	otherPeer := attest(test.RandPeerIDFatal(t), 1)
+
+	rec := &PeerRecord{PeerID: id, Addrs: addrs, Seq: TimestampSeq(), Attestations: []*record.Envelope{valid, otherPeer}}
+	envelope, err := record.Seal(rec, priv)
+	test.AssertNilError(t, err)
+	envBytes, err := envelope.Marshal()
+	test.AssertNilError(t, err)
+
+	_, untypedRecord, err := record.ConsumeEnvelope(envBytes, PeerRecordEnvelopeDomain)
+	test.AssertNilError(t, err)
+	rec2 := untypedRecord.(*PeerRecord)
+	if len(rec2.Attestations) != 1 || !rec2.Attestations[0].Equal(valid) {
+		t.Fatalf("expected only the valid attestation, got %d attestations", len(rec2.Attestations))
+	}
+
+	signer, att, err := AddrAttestationFromEnvelope(rec2.Attestations[0])
+	test.AssertNilError(t, err)
+	if signer != attester {
+		t.Errorf("expected attester %s, got %s", attester, signer)
+	}
+	if att.Subject != id || !att.Addr.Equal(addrs[1]) {
+		t.Errorf("unexpected attestation: %s at %s", att.Subject, att.Addr)
+	}
+}
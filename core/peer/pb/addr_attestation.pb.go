@@ -0,0 +1,169 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.32.0
+// 	protoc        v3.21.12
+// source: pb/addr_attestation.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// AddrAttestation messages contain the attestation of a peer that it
+// successfully dialed another peer at an address.
+//
+// AddrAttestations are designed to be serialized to bytes and placed inside of
+// SignedEnvelopes, signed by the attesting peer.
+type AddrAttestation struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// subject_id contains the libp2p peer id of the dialed peer in its binary representation.
+	SubjectId []byte `protobuf:"bytes,1,opt,name=subject_id,json=subjectId,proto3" json:"subject_id,omitempty"`
+	// addr is the address the subject was dialed at.
+	Addr []byte `protobuf:"bytes,2,opt,name=addr,proto3" json:"addr,omitempty"`
+	// timestamp is the time of the dial, in nanoseconds since the Unix epoch.
+	Timestamp uint64 `protobuf:"varint,3,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+}
+
+func (x *AddrAttestation) Reset() {
+	*x = AddrAttestation{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pb_addr_attestation_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *AddrAttestation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddrAttestation) ProtoMessage() {}
+
+func (x *AddrAttestation) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_addr_attestation_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddrAttestation.ProtoReflect.Descriptor instead.
+func (*AddrAttestation) Descriptor() ([]byte, []int) {
+	return file_pb_addr_attestation_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *AddrAttestation) GetSubjectId() []byte {
+	if x != nil {
+		return x.SubjectId
+	}
+	return nil
+}
+
+func (x *AddrAttestation) GetAddr() []byte {
+	if x != nil {
+		return x.Addr
+	}
+	return nil
+}
+
+func (x *AddrAttestation) GetTimestamp() uint64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+var File_pb_addr_attestation_proto protoreflect.FileDescriptor
+
+var file_pb_addr_attestation_proto_rawDesc = []byte{
+	0x0a, 0x19, 0x70, 0x62, 0x2f, 0x61, 0x64, 0x64, 0x72, 0x5f, 0x61, 0x74, 0x74, 0x65, 0x73, 0x74,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x07, 0x70, 0x65, 0x65,
+	0x72, 0x2e, 0x70, 0x62, 0x22, 0x62, 0x0a, 0x0f, 0x41, 0x64, 0x64, 0x72, 0x41, 0x74, 0x74, 0x65,
+	0x73, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x1d, 0x0a, 0x0a, 0x73, 0x75, 0x62, 0x6a, 0x65,
+	0x63, 0x74, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x73, 0x75, 0x62,
+	0x6a, 0x65, 0x63, 0x74, 0x49, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x61, 0x64, 0x64, 0x72, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x61, 0x64, 0x64, 0x72, 0x12, 0x1c, 0x0a, 0x09, 0x74, 0x69,
+	0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x09, 0x74,
+	0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_pb_addr_attestation_proto_rawDescOnce sync.Once
+	file_pb_addr_attestation_proto_rawDescData = file_pb_addr_attestation_proto_rawDesc
+)
+
+func file_pb_addr_attestation_proto_rawDescGZIP() []byte {
+	file_pb_addr_attestation_proto_rawDescOnce.Do(func() {
+		file_pb_addr_attestation_proto_rawDescData = protoimpl.X.CompressGZIP(file_pb_addr_attestation_proto_rawDescData)
+	})
+	return file_pb_addr_attestation_proto_rawDescData
+}
+
+var file_pb_addr_attestation_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_pb_addr_attestation_proto_goTypes = []interface{}{
+	(*AddrAttestation)(nil), // 0: peer.pb.AddrAttestation
+}
+var file_pb_addr_attestation_proto_depIdxs = []int32{
+	0, // [0:0] is the sub-list for method output_type
+	0, // [0:0] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_pb_addr_attestation_proto_init() }
+func file_pb_addr_attestation_proto_init() {
+	if File_pb_addr_attestation_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_pb_addr_attestation_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*AddrAttestation); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_pb_addr_attestation_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_pb_addr_attestation_proto_goTypes,
+		DependencyIndexes: file_pb_addr_attestation_proto_depIdxs,
+		MessageInfos:      file_pb_addr_attestation_proto_msgTypes,
+	}.Build()
+	File_pb_addr_attestation_proto = out.File
+	file_pb_addr_attestation_proto_rawDesc = nil
+	file_pb_addr_attestation_proto_goTypes = nil
+	file_pb_addr_attestation_proto_depIdxs = nil
+}
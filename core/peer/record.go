@@ -94,6 +94,12 @@ type PeerRecord struct {
 	// but newer PeerRecords MUST have a greater Seq value than older records
 	// for the same peer.
 	Seq uint64
+
+	// Attestations contains signed AddrAttestations, in which other peers attest
+	// that they successfully dialed the peer at one of Addrs. Attestations that
+	// are invalid, or for other peers or addresses, are dropped when marshalling
+	// and unmarshalling the record.
+	Attestations []*record.Envelope
 }
 
 // NewPeerRecord returns a PeerRecord with a timestamp-based sequence number.
@@ -124,6 +130,7 @@ func PeerRecordFromProtobuf(msg *pb.PeerRecord) (*PeerRecord, error) {
 	record.PeerID = id
 	record.Addrs = addrsFromProtobuf(msg.Addresses)
 	record.Seq = msg.Seq
+	record.Attestations = attestationsFromProtobuf(id, msg.Addresses)
 
 	return record, nil
 }
@@ -216,6 +223,14 @@ func (r *PeerRecord) Equal(other *PeerRecord) bool {
 			return false
 		}
 	}
+	if len(r.Attestations) != len(other.Attestations) {
+		return false
+	}
+	for i := range r.Attestations {
+		if !r.Attestations[i].Equal(other.Attestations[i]) {
+			return false
+		}
+	}
 	return true
 }
 
@@ -225,9 +240,26 @@ func (r *PeerRecord) ToProtobuf() (*pb.PeerRecord, error) {
 	if err != nil {
 		return nil, err
 	}
+	addrs := addrsToProtobuf(r.Addrs)
+	for _, env := range r.Attestations {
+		_, att, err := AddrAttestationFromEnvelope(env)
+		if err != nil || att.Subject != r.PeerID {
+			continue
+		}
+		b, err := env.Marshal()
+		if err != nil {
+			continue
+		}
+		for i, a := range r.Addrs {
+			if a.Equal(att.Addr) {
+				addrs[i].Attestations = append(addrs[i].Attestations, b)
+				break
+			}
+		}
+	}
 	return &pb.PeerRecord{
 		PeerId:    idBytes,
-		Addresses: addrsToProtobuf(r.Addrs),
+		Addresses: addrs,
 		Seq:       r.Seq,
 	}, nil
 }
@@ -244,6 +276,42 @@ func addrsFromProtobuf(addrs []*pb.PeerRecord_AddressInfo) []ma.Multiaddr {
 	return out
 }
 
+func attestationsFromProtobuf(id ID, addrs []*pb.PeerRecord_AddressInfo) []*record.Envelope {
+	var (
+		data [][]byte
+		// attested holds the address attested by each element of data
+		attested []ma.Multiaddr
+	)
+	for _, addr := range addrs {
+		if len(addr.Attestations) == 0 {
+			continue
+		}
+		a, err := ma.NewMultiaddrBytes(addr.Multiaddr)
+		if err != nil {
+			continue
+		}
+		for _, b := range addr.Attestations {
+			data = append(data, b)
+			attested = append(attested, a)
+		}
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	envs, recs, errs := record.ConsumeEnvelopes(data, AddrAttestationEnvelopeDomain)
+	var out []*record.Envelope
+	for i, env := range envs {
+		if errs[i] != nil {
+			continue
+		}
+		if att, ok := recs[i].(*AddrAttestation); ok && att.Subject == id && att.Addr.Equal(attested[i]) {
+			out = append(out, env)
+		}
+	}
+	return out
+}
+
 func addrsToProtobuf(addrs []ma.Multiaddr) []*pb.PeerRecord_AddressInfo {
 	out := make([]*pb.PeerRecord_AddressInfo, 0, len(addrs))
 	for _, addr := range addrs {
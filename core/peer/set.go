@@ -0,0 +1,121 @@
+package peer
+
+import (
+	"errors"
+	"sort"
+)
+
+var (
+	// ErrShortIDNotFound is returned by IDSet.Resolve if no peer ID in the
+	// set matches the short form.
+	ErrShortIDNotFound = errors.New("no peer ID matches the short form")
+	// ErrShortIDAmbiguous is returned by IDSet.Resolve if several peer IDs in
+	// the set match the short form.
+	ErrShortIDAmbiguous = errors.New("several peer IDs match the short form")
+)
+
+// IDSet is a set of peer IDs. It is not safe for concurrent use.
+type IDSet map[ID]struct{}
+
+// NewIDSet returns a set containing ids.
+func NewIDSet(ids ...ID) IDSet {
+	s := make(IDSet, len(ids))
+	s.Add(ids...)
+	return s
+}
+
+// Add adds ids to the set.
+func (s IDSet) Add(ids ...ID) {
+	for _, id := range ids {
+		s[id] = struct{}{}
+	}
+}
+
+// Remove removes ids from the set.
+func (s IDSet) Remove(ids ...ID) {
+	for _, id := range ids {
+		delete(s, id)
+	}
+}
+
+// Contains returns true if id is in the set.
+func (s IDSet) Contains(id ID) bool {
+	_, ok := s[id]
+	return ok
+}
+
+// Len returns the number of peer IDs in the set.
+func (s IDSet) Len() int {
+	return len(s)
+}
+
+// Slice returns the peer IDs in the set, sorted.
+func (s IDSet) Slice() []ID {
+	ids := make([]ID, 0, len(s))
+	for id := range s {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Union returns a new set containing the peer IDs in s or other.
+func (s IDSet) Union(other IDSet) IDSet {
+	u := make(IDSet, len(s)+len(other))
+	for id := range s {
+		u[id] = struct{}{}
+	}
+	for id := range other {
+		u[id] = struct{}{}
+	}
+	return u
+}
+
+// Intersect returns a new set containing the peer IDs in both s and other.
+func (s IDSet) Intersect(other IDSet) IDSet {
+	small, large := s, other
+	if len(small) > len(large) {
+		small, large = large, small
+	}
+	i := make(IDSet)
+	for id := range small {
+		if large.Contains(id) {
+			i[id] = struct{}{}
+		}
+	}
+	return i
+}
+
+// Difference returns a new set containing the peer IDs in s that are not in
+// other.
+func (s IDSet) Difference(other IDSet) IDSet {
+	d := make(IDSet)
+	for id := range s {
+		if !other.Contains(id) {
+			d[id] = struct{}{}
+		}
+	}
+	return d
+}
+
+// Resolve returns the peer ID in the set that short is the short form of,
+// see ID.Short.
+func (s IDSet) Resolve(short string) (ID, error) {
+	sid, err := ParseShortID(short)
+	if err != nil {
+		return "", err
+	}
+	var found ID
+	for id := range s {
+		if sid.Matches(id) {
+			if found != "" {
+				return "", ErrShortIDAmbiguous
+			}
+			found = id
+		}
+	}
+	if found == "" {
+		return "", ErrShortIDNotFound
+	}
+	return found, nil
+}
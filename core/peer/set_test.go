@@ -0,0 +1,95 @@
+package peer_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+)
+
+func TestShortID(t *testing.T) {
+	id := test.RandPeerIDFatal(t)
+	short := id.Short()
+	if !strings.HasPrefix(short, id.String()[:6]) || len(short) != 6+1+6+1+4 {
+		t.Fatalf("unexpected short form %s of %s", short, id)
+	}
+	// ShortString keeps its format, since logs are parsed
+	if pid := id.String(); id.ShortString() != "<peer.ID "+pid[:2]+"*"+pid[len(pid)-6:]+">" {
+		t.Fatalf("unexpected short string %s", id.ShortString())
+	}
+
+	sid, err := ParseShortID(short)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sid.String() != short || !sid.Matches(id) {
+		t.Fatal("short ID doesn't round trip")
+	}
+	if sid.Matches(test.RandPeerIDFatal(t)) {
+		t.Fatal("short ID matches other peer ID")
+	}
+
+	// the checksum is verified
+	corrupted := short[:len(short)-1] + string("0123456789abcdef"[(strings.IndexByte("0123456789abcdef", short[len(short)-1])+1)%16])
+	sid, err = ParseShortID(corrupted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sid.Matches(id) {
+		t.Fatal("short ID with wrong checksum matches")
+	}
+
+	// complete peer IDs are accepted
+	sid, err = ParseShortID(id.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sid.Matches(id) {
+		t.Fatal("complete peer ID doesn't match")
+	}
+
+	for _, s := range []string{"", "foo", "12D3Ko*abc-1234", "12D3Ko*abcdef", "12D3Ko*abcdef-xyz1", "12D3Ko*abcdef-123456"} {
+		if _, err := ParseShortID(s); !errors.Is(err, ErrInvalidShortID) {
+			t.Errorf("expected %q to be invalid, got %v", s, err)
+		}
+	}
+}
+
+func TestIDSet(t *testing.T) {
+	a, b, c := test.RandPeerIDFatal(t), test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)
+	s1 := NewIDSet(a, b)
+	s2 := NewIDSet(b, c)
+
+	if !s1.Contains(a) || s1.Contains(c) || s1.Len() != 2 {
+		t.Fatal("unexpected set contents")
+	}
+	if u := s1.Union(s2); u.Len() != 3 {
+		t.Fatal("unexpected union")
+	}
+	if i := s1.Intersect(s2); i.Len() != 1 || !i.Contains(b) {
+		t.Fatal("unexpected intersection")
+	}
+	if d := s1.Difference(s2); d.Len() != 1 || !d.Contains(a) {
+		t.Fatal("unexpected difference")
+	}
+	ids := s1.Union(s2).Slice()
+	for i := 1; i < len(ids); i++ {
+		if ids[i-1] >= ids[i] {
+			t.Fatal("slice is not sorted")
+		}
+	}
+
+	if id, err := s1.Resolve(a.Short()); err != nil || id != a {
+		t.Fatalf("failed to resolve short ID: %v", err)
+	}
+	if _, err := s1.Resolve(c.Short()); !errors.Is(err, ErrShortIDNotFound) {
+		t.Fatalf("expected ErrShortIDNotFound, got %v", err)
+	}
+
+	s1.Remove(a)
+	if s1.Contains(a) || s1.Len() != 1 {
+		t.Fatal("failed to remove")
+	}
+}
@@ -0,0 +1,90 @@
+package peer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// shortIDChars is the number of characters of the peer ID kept at the start
+// and at the end of the short form.
+const shortIDChars = 6
+
+// shortIDChecksumLen is the number of bytes of the checksum in the short form.
+const shortIDChecksumLen = 2
+
+// ErrInvalidShortID is returned when parsing a malformed short peer ID.
+var ErrInvalidShortID = errors.New("invalid short peer ID")
+
+// ShortID is the parsed short form of a peer ID, see ID.Short.
+type ShortID struct {
+	Prefix, Suffix string
+	// Checksum is the first bytes of the SHA-256 hash of the peer ID. It is
+	// nil if the short form is the complete peer ID.
+	Checksum []byte
+}
+
+// Short returns the canonical short form of the peer ID, for use in logs and
+// metrics labels. It consists of the first and last characters of the peer ID
+// and a short checksum of the complete peer ID, e.g.
+// "12D3Ko*Wq2G7E-8b1f". The checksum makes it unlikely that two peer IDs with
+// the same prefix and suffix have the same short form. Short IDs can be parsed
+// using ParseShortID, and resolved using IDSet.Resolve.
+//
+// Peer IDs that are too short to be abbreviated are returned in full.
+func (id ID) Short() string {
+	return id.shortID().String()
+}
+
+func (id ID) shortID() ShortID {
+	pid := id.String()
+	if len(pid) <= 2*shortIDChars {
+		return ShortID{Prefix: pid}
+	}
+	sum := sha256.Sum256([]byte(id))
+	return ShortID{
+		Prefix:   pid[:shortIDChars],
+		Suffix:   pid[len(pid)-shortIDChars:],
+		Checksum: sum[:shortIDChecksumLen],
+	}
+}
+
+// ParseShortID parses the short form of a peer ID returned by ID.Short. A
+// complete peer ID is accepted as well.
+func ParseShortID(s string) (ShortID, error) {
+	if !strings.Contains(s, "*") {
+		if _, err := Decode(s); err != nil {
+			return ShortID{}, fmt.Errorf("%w: %s", ErrInvalidShortID, err)
+		}
+		return ShortID{Prefix: s}, nil
+	}
+	prefix, rest, _ := strings.Cut(s, "*")
+	suffix, checksum, ok := strings.Cut(rest, "-")
+	if !ok || len(prefix) != shortIDChars || len(suffix) != shortIDChars {
+		return ShortID{}, ErrInvalidShortID
+	}
+	sum, err := hex.DecodeString(checksum)
+	if err != nil || len(sum) != shortIDChecksumLen {
+		return ShortID{}, ErrInvalidShortID
+	}
+	return ShortID{Prefix: prefix, Suffix: suffix, Checksum: sum}, nil
+}
+
+// String returns the short form, see ID.Short.
+func (s ShortID) String() string {
+	if s.Checksum == nil {
+		return s.Prefix
+	}
+	return s.Prefix + "*" + s.Suffix + "-" + hex.EncodeToString(s.Checksum)
+}
+
+// Matches returns true if s is the short form of id.
+func (s ShortID) Matches(id ID) bool {
+	if s.Checksum == nil {
+		return id.String() == s.Prefix
+	}
+	other := id.shortID()
+	return s.Prefix == other.Prefix && s.Suffix == other.Suffix && string(s.Checksum) == string(other.Checksum)
+}
@@ -0,0 +1,185 @@
+package peerstore
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// AddrSource is the source an address of a peer was learned from.
+type AddrSource int
+
+const (
+	// AddrSourceUnknown is used for addresses added using AddrBook.AddAddrs,
+	// without specifying a source.
+	AddrSourceUnknown AddrSource = iota
+	// AddrSourceManual is used for addresses configured by the application,
+	// e.g. bootstrap nodes.
+	AddrSourceManual
+	// AddrSourceIdentify is used for addresses the peer itself reported using identify.
+	AddrSourceIdentify
+	// AddrSourceDHT is used for addresses found using peer routing, e.g. the DHT.
+	AddrSourceDHT
+	// AddrSourceMDNS is used for addresses discovered using mDNS on the local network.
+	AddrSourceMDNS
+	// AddrSourceRelay is used for the addresses of relays used for relay reservations.
+	AddrSourceRelay
+	// AddrSourceRelayVoucher is used for the addresses a relay reported in a
+	// reservation that came with a reservation voucher signed by the relay.
+	AddrSourceRelayVoucher
+)
+
+func (s AddrSource) String() string {
+	switch s {
+	case AddrSourceUnknown:
+		return "unknown"
+	case AddrSourceManual:
+		return "manual"
+	case AddrSourceIdentify:
+		return "identify"
+	case AddrSourceDHT:
+		return "dht"
+	case AddrSourceMDNS:
+		return "mdns"
+	case AddrSourceRelay:
+		return "relay"
+	case AddrSourceRelayVoucher:
+		return "relay-voucher"
+	default:
+		return fmt.Sprintf("unrecognized address source: %d", s)
+	}
+}
+
+// AddrTrust is the level of trust in an address. Higher values are more trusted.
+type AddrTrust int
+
+const (
+	// AddrTrustLow is used for addresses from sources that anyone can write
+	// to, e.g. the DHT.
+	AddrTrustLow AddrTrust = iota
+	// AddrTrustMedium is used for addresses from sources that are somewhat
+	// trusted, e.g. mDNS, which is limited to the local network.
+	AddrTrustMedium
+	// AddrTrustHigh is used for addresses configured by the application, or
+	// reported by the peer itself.
+	AddrTrustHigh
+)
+
+func (t AddrTrust) String() string {
+	switch t {
+	case AddrTrustLow:
+		return "low"
+	case AddrTrustMedium:
+		return "medium"
+	case AddrTrustHigh:
+		return "high"
+	default:
+		return fmt.Sprintf("unrecognized address trust: %d", t)
+	}
+}
+
+// DefaultAddrTrust returns the trust level used for addresses from source s.
+func DefaultAddrTrust(s AddrSource) AddrTrust {
+	switch s {
+	case AddrSourceManual, AddrSourceIdentify, AddrSourceRelayVoucher:
+		return AddrTrustHigh
+	case AddrSourceDHT:
+		return AddrTrustLow
+	default:
+		return AddrTrustMedium
+	}
+}
+
+// AddrProvenance describes where an address was learned from.
+type AddrProvenance struct {
+	Source AddrSource
+	Trust  AddrTrust
+	// Added is the time the address was added from Source.
+	Added time.Time
+}
+
+// SourcedAddrBook is implemented by address books that record the source of
+// addresses, and how much they're trusted. To test whether an AddrBook
+// supports it, use GetSourcedAddrBook.
+//
+// An address can be reported by multiple sources. Its provenance is that of
+// the most trusted one. Addresses added using AddrBook.AddAddrs or
+// AddrBook.SetAddrs have an unknown source, and don't change the provenance
+// of known addresses.
+type SourcedAddrBook interface {
+	// AddAddrsFromSource is like AddrBook.AddAddrs, but records the source of
+	// the addresses and how much they're trusted.
+	AddAddrsFromSource(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration, source AddrSource, trust AddrTrust)
+
+	// AddrProvenance returns the provenance of an address of a peer. It returns
+	// false if the address isn't known.
+	AddrProvenance(p peer.ID, addr ma.Multiaddr) (AddrProvenance, bool)
+}
+
+// GetSourcedAddrBook is a helper to "upcast" an AddrBook to a SourcedAddrBook
+// by using type assertion. Returns (nil, false) if the AddrBook is not a
+// SourcedAddrBook.
+func GetSourcedAddrBook(ab AddrBook) (sab SourcedAddrBook, ok bool) {
+	sab, ok = ab.(SourcedAddrBook)
+	return sab, ok
+}
+
+// AddAddrsFromSource adds addresses learned from source to ab, using the
+// default trust level of source. If ab is not a SourcedAddrBook, the source
+// isn't recorded.
+func AddAddrsFromSource(ab AddrBook, p peer.ID, addrs []ma.Multiaddr, ttl time.Duration, source AddrSource) {
+	if sab, ok := GetSourcedAddrBook(ab); ok {
+		sab.AddAddrsFromSource(p, addrs, ttl, source, DefaultAddrTrust(source))
+		return
+	}
+	ab.AddAddrs(p, addrs, ttl)
+}
+
+// AddNewAddrsFromSource is like AddAddrsFromSource, but only records source
+// for the addresses whose source ab doesn't know yet. The other addresses keep
+// their provenance, and only have their TTL extended. This is useful for
+// addresses that may have been looked up from another source before, e.g. the
+// addresses passed to Host.Connect.
+func AddNewAddrsFromSource(ab AddrBook, p peer.ID, addrs []ma.Multiaddr, ttl time.Duration, source AddrSource) {
+	sab, ok := GetSourcedAddrBook(ab)
+	if !ok {
+		ab.AddAddrs(p, addrs, ttl)
+		return
+	}
+	var known, unknown []ma.Multiaddr
+	for _, a := range addrs {
+		if prov, ok := sab.AddrProvenance(p, a); ok && prov.Source != AddrSourceUnknown {
+			known = append(known, a)
+		} else {
+			unknown = append(unknown, a)
+		}
+	}
+	if len(known) > 0 {
+		ab.AddAddrs(p, known, ttl)
+	}
+	if len(unknown) > 0 {
+		sab.AddAddrsFromSource(p, unknown, ttl, source, DefaultAddrTrust(source))
+	}
+}
+
+// AddrsWithMinTrust returns the addresses of p that are trusted at least
+// min, e.g. for advertising them to other peers. Addresses with an unknown
+// source are treated as AddrTrustMedium. If ab is not a SourcedAddrBook, all
+// addresses are returned.
+func AddrsWithMinTrust(ab AddrBook, p peer.ID, min AddrTrust) []ma.Multiaddr {
+	addrs := ab.Addrs(p)
+	sab, ok := GetSourcedAddrBook(ab)
+	if !ok {
+		return addrs
+	}
+	res := make([]ma.Multiaddr, 0, len(addrs))
+	for _, a := range addrs {
+		if prov, ok := sab.AddrProvenance(p, a); ok && prov.Trust >= min {
+			res = append(res, a)
+		}
+	}
+	return res
+}
@@ -0,0 +1,61 @@
+package peerstore
+
+import (
+	"context"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// AddrUpdate is a change of the addresses of a peer, sent by
+// WatchableAddrBook.WatchAddrs.
+type AddrUpdate struct {
+	// Snapshot is true for the first update sent on a channel. Added then
+	// contains all current addresses of the peer, and Removed is empty.
+	Snapshot bool
+	// Added are the addresses added since the last update.
+	Added []ma.Multiaddr
+	// Removed are the addresses removed since the last update.
+	Removed []ma.Multiaddr
+}
+
+// AddrWatchFilter selects the addresses reported to a watcher. certified is
+// true if the address is contained in the peer's current signed peer record.
+type AddrWatchFilter func(addr ma.Multiaddr, certified bool) bool
+
+// PublicAddrs is an AddrWatchFilter that only selects public addresses.
+func PublicAddrs(addr ma.Multiaddr, _ bool) bool {
+	return manet.IsPublicAddr(addr)
+}
+
+// CertifiedAddrs is an AddrWatchFilter that only selects addresses contained
+// in the peer's signed peer record.
+func CertifiedAddrs(_ ma.Multiaddr, certified bool) bool {
+	return certified
+}
+
+// WatchableAddrBook is implemented by address books that allow watching the
+// addresses of a peer. Unlike AddrBook.AddrStream, it reports removed
+// addresses, and batches changes if the receiver is slow. To test whether an
+// AddrBook supports it, use GetWatchableAddrBook.
+type WatchableAddrBook interface {
+	// WatchAddrs returns a channel that receives the addresses of p that
+	// match all filters: first a snapshot of the current addresses, then the
+	// changes. Changes that happen while the receiver is busy are merged into
+	// a single update.
+	//
+	// The channel is closed when ctx is done, or when the address book is
+	// closed. Expired addresses may only be reported as removed once they're
+	// garbage collected.
+	WatchAddrs(ctx context.Context, p peer.ID, filters ...AddrWatchFilter) <-chan AddrUpdate
+}
+
+// GetWatchableAddrBook is a helper to "upcast" an AddrBook to a
+// WatchableAddrBook by using type assertion. Returns (nil, false) if the
+// AddrBook is not a WatchableAddrBook.
+func GetWatchableAddrBook(ab AddrBook) (wab WatchableAddrBook, ok bool) {
+	wab, ok = ab.(WatchableAddrBook)
+	return wab, ok
+}
@@ -0,0 +1,81 @@
+package peerstore
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Keys of the well-known peer metadata.
+//
+// Prefer the typed accessors (e.g. GetAgentVersion) over using these keys
+// with PeerMetadata.Get and PeerMetadata.Put directly.
+const (
+	// AgentVersionKey is the key of the agent version reported by the peer via identify.
+	AgentVersionKey = "AgentVersion"
+	// ProtocolVersionKey is the key of the protocol version reported by the peer via identify.
+	ProtocolVersionKey = "ProtocolVersion"
+	// LastHandshakeKey is the key of the time the last identify handshake with the peer completed.
+	LastHandshakeKey = "LastHandshake"
+)
+
+// Get returns the metadata value stored for the peer under key.
+// It returns ErrNotFound if no value is stored, and an error if the value is not of type T.
+func Get[T any](m PeerMetadata, p peer.ID, key string) (T, error) {
+	var zero T
+	v, err := m.Get(p, key)
+	if err != nil {
+		return zero, err
+	}
+	t, ok := v.(T)
+	if !ok {
+		return zero, fmt.Errorf("peer metadata %q has type %T, expected %T", key, v, zero)
+	}
+	return t, nil
+}
+
+// Put stores a metadata value for the peer under key.
+func Put[T any](m PeerMetadata, p peer.ID, key string, val T) error {
+	return m.Put(p, key, val)
+}
+
+// GetAgentVersion returns the agent version reported by the peer.
+func GetAgentVersion(m PeerMetadata, p peer.ID) (string, error) {
+	return Get[string](m, p, AgentVersionKey)
+}
+
+// PutAgentVersion stores the agent version reported by the peer.
+func PutAgentVersion(m PeerMetadata, p peer.ID, av string) error {
+	return Put(m, p, AgentVersionKey, av)
+}
+
+// GetProtocolVersion returns the protocol version reported by the peer.
+func GetProtocolVersion(m PeerMetadata, p peer.ID) (string, error) {
+	return Get[string](m, p, ProtocolVersionKey)
+}
+
+// PutProtocolVersion stores the protocol version reported by the peer.
+func PutProtocolVersion(m PeerMetadata, p peer.ID, pv string) error {
+	return Put(m, p, ProtocolVersionKey, pv)
+}
+
+// GetLastHandshake returns the time the last identify handshake with the peer completed.
+func GetLastHandshake(m PeerMetadata, p peer.ID) (time.Time, error) {
+	return Get[time.Time](m, p, LastHandshakeKey)
+}
+
+// PutLastHandshake stores the time the last identify handshake with the peer completed.
+func PutLastHandshake(m PeerMetadata, p peer.ID, t time.Time) error {
+	return Put(m, p, LastHandshakeKey, t)
+}
+
+// GetLatencyEWMA returns the exponentially-weighted moving average of the
+// latency to the peer. It returns ErrNotFound if no latency was recorded.
+func GetLatencyEWMA(m Metrics, p peer.ID) (time.Duration, error) {
+	l := m.LatencyEWMA(p)
+	if l == 0 {
+		return 0, ErrNotFound
+	}
+	return l, nil
+}
@@ -77,6 +77,10 @@ type PeerMetadata interface {
 	// Get / Put is a simple registry for other peer-related key/value pairs.
 	// If we find something we use often, it should become its own set of
 	// methods. This is a last resort.
+	//
+	// For the well-known metadata, use the typed accessors (e.g. GetAgentVersion)
+	// instead. For application metadata, the generic Get and Put functions of
+	// this package take care of the type assertion.
 	Get(p peer.ID, key string) (interface{}, error)
 	Put(p peer.ID, key string, val interface{}) error
 
@@ -229,6 +233,43 @@ type Metrics interface {
 	RemovePeer(peer.ID)
 }
 
+// LatencyStats summarizes the latency measurements of a peer on one transport.
+type LatencyStats struct {
+	// EWMA is the exponentially-weighted moving average of all measurements.
+	EWMA time.Duration
+	// P50 and P95 are the median and the 95th percentile of the most recent
+	// measurements.
+	P50, P95 time.Duration
+	// Samples is the number of measurements P50 and P95 are computed from.
+	Samples int
+	// Updated is the time of the last measurement.
+	Updated time.Time
+}
+
+// TransportLatencyBook tracks the latencies of peers per transport. Averaging
+// measurements across transports produces misleading values, e.g. when a peer
+// is reachable both directly and through a relay.
+//
+// To test whether a given Metrics / Peerstore implementation tracks latencies
+// per transport, callers should use the GetTransportLatencyBook helper.
+type TransportLatencyBook interface {
+	// RecordTransportLatency records a latency measurement on a connection
+	// using transport, e.g. "tcp" or "quic-v1". The measurement is also
+	// included in Metrics.LatencyEWMA.
+	RecordTransportLatency(p peer.ID, transport string, d time.Duration)
+
+	// LatencyStats returns the latency statistics of a peer, keyed by
+	// transport. It returns nil if no measurements were recorded.
+	LatencyStats(p peer.ID) map[string]LatencyStats
+}
+
+// GetTransportLatencyBook is a helper to "upcast" Metrics (or a Peerstore) to
+// a TransportLatencyBook by using type assertion.
+func GetTransportLatencyBook(m Metrics) (lb TransportLatencyBook, ok bool) {
+	lb, ok = m.(TransportLatencyBook)
+	return lb, ok
+}
+
 // ProtoBook tracks the protocols supported by peers.
 type ProtoBook interface {
 	GetProtocols(peer.ID) ([]protocol.ID, error)
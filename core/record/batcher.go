@@ -0,0 +1,91 @@
+package record
+
+import "sync"
+
+// EnvelopeBatcher consumes Envelopes that are received concurrently, e.g. the
+// signed peer records received on many connections, in batches using
+// ConsumeEnvelopes.
+//
+// A call never waits for other Envelopes to arrive: the first caller consumes
+// its Envelope right away, and the Envelopes queued by other callers in the
+// meantime are consumed together in the next batch, by one of these callers.
+// Batches are therefore only formed when Envelopes arrive faster than they can
+// be verified one at a time, which is when batch verification saves the most
+// CPU.
+type EnvelopeBatcher struct {
+	domain string
+
+	mu      sync.Mutex
+	running bool
+	pending []*envelopeRequest
+}
+
+type envelopeRequest struct {
+	data []byte
+
+	envelope *Envelope
+	rec      Record
+	err      error
+	done     chan struct{}
+	// lead is closed when the caller has to consume the next batch.
+	lead chan struct{}
+}
+
+// NewEnvelopeBatcher creates an EnvelopeBatcher for Envelopes signed in domain.
+func NewEnvelopeBatcher(domain string) *EnvelopeBatcher {
+	return &EnvelopeBatcher{domain: domain}
+}
+
+// ConsumeEnvelope is like the package-level ConsumeEnvelope, using the domain
+// of the EnvelopeBatcher.
+func (b *EnvelopeBatcher) ConsumeEnvelope(data []byte) (*Envelope, Record, error) {
+	req := &envelopeRequest{data: data, done: make(chan struct{}), lead: make(chan struct{})}
+
+	b.mu.Lock()
+	b.pending = append(b.pending, req)
+	if b.running {
+		b.mu.Unlock()
+		select {
+		case <-req.done:
+			return req.envelope, req.rec, req.err
+		case <-req.lead:
+		}
+	} else {
+		b.running = true
+		b.mu.Unlock()
+	}
+	b.consumeNext()
+	return req.envelope, req.rec, req.err
+}
+
+// consumeNext consumes the pending Envelopes, which include the Envelope of
+// the caller. Every caller consumes at most one batch, and then hands over to
+// the caller of the first Envelope queued in the meantime.
+func (b *EnvelopeBatcher) consumeNext() {
+	b.mu.Lock()
+	reqs := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+
+	b.consume(reqs)
+
+	b.mu.Lock()
+	if len(b.pending) > 0 {
+		close(b.pending[0].lead)
+	} else {
+		b.running = false
+	}
+	b.mu.Unlock()
+}
+
+func (b *EnvelopeBatcher) consume(reqs []*envelopeRequest) {
+	data := make([][]byte, len(reqs))
+	for i, req := range reqs {
+		data[i] = req.data
+	}
+	envelopes, recs, errs := ConsumeEnvelopes(data, b.domain)
+	for i, req := range reqs {
+		req.envelope, req.rec, req.err = envelopes[i], recs[i], errs[i]
+		close(req.done)
+	}
+}
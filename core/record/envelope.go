@@ -107,8 +107,9 @@ func Seal(rec Record, privateKey crypto.PrivKey) (*Envelope, error) {
 //	}
 //
 // If the Envelope signature is valid, but no Record type is registered for the Envelope's
-// PayloadType, ErrPayloadTypeNotRegistered will be returned, along with the Envelope and
-// a nil Record.
+// PayloadType (and it can't be migrated to one, see RegisterMigration),
+// ErrPayloadTypeNotRegistered will be returned, along with the Envelope and a nil Record.
+// The Envelope can still be stored and forwarded intact.
 func ConsumeEnvelope(data []byte, domain string) (envelope *Envelope, rec Record, err error) {
 	e, err := UnmarshalEnvelope(data)
 	if err != nil {
@@ -121,12 +122,68 @@ func ConsumeEnvelope(data []byte, domain string) (envelope *Envelope, rec Record
 	}
 
 	rec, err = e.Record()
+	if errors.Is(err, ErrPayloadTypeNotRegistered) {
+		// The envelope is valid, the caller may still store and forward it.
+		return e, nil, fmt.Errorf("failed to unmarshal envelope payload: %w", err)
+	}
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to unmarshal envelope payload: %w", err)
 	}
 	return e, rec, nil
 }
 
+// ConsumeEnvelopes is like ConsumeEnvelope, for many serialized Envelopes at
+// once. The signatures are verified using crypto.VerifyBatch, which is faster
+// than consuming the envelopes one by one, e.g. when validating thousands of
+// peer records.
+//
+// The returned slices have the same length as data. For each envelope, either
+// the Envelope and its Record or an error is set. As with ConsumeEnvelope, the
+// Envelope is also set for ErrPayloadTypeNotRegistered.
+func ConsumeEnvelopes(data [][]byte, domain string) ([]*Envelope, []Record, []error) {
+	envelopes := make([]*Envelope, len(data))
+	recs := make([]Record, len(data))
+	errs := make([]error, len(data))
+
+	batch := make([]crypto.SignedData, 0, len(data))
+	idx := make([]int, 0, len(data))
+	for i, d := range data {
+		e, err := UnmarshalEnvelope(d)
+		if err != nil {
+			errs[i] = fmt.Errorf("failed when unmarshalling the envelope: %w", err)
+			continue
+		}
+		unsigned, err := makeUnsigned(domain, e.PayloadType, e.RawPayload)
+		if err != nil {
+			errs[i] = fmt.Errorf("failed to validate envelope: %w", err)
+			continue
+		}
+		envelopes[i] = e
+		batch = append(batch, crypto.SignedData{Key: e.PublicKey, Data: unsigned, Sig: e.signature})
+		idx = append(idx, i)
+	}
+
+	valid := crypto.VerifyBatch(batch)
+	for j, i := range idx {
+		pool.Put(batch[j].Data)
+		if !valid[j] {
+			envelopes[i] = nil
+			errs[i] = fmt.Errorf("failed to validate envelope: %w", ErrInvalidSignature)
+			continue
+		}
+		rec, err := envelopes[i].Record()
+		if err != nil {
+			if !errors.Is(err, ErrPayloadTypeNotRegistered) {
+				envelopes[i] = nil
+			}
+			errs[i] = fmt.Errorf("failed to unmarshal envelope payload: %w", err)
+			continue
+		}
+		recs[i] = rec
+	}
+	return envelopes, recs, errs
+}
+
 // ConsumeTypedEnvelope unmarshals a serialized Envelope and validates its
 // signature. If validation fails, an error is returned, along with the unmarshalled
 // envelope, so it can be inspected.
@@ -3,6 +3,8 @@ package record_test
 import (
 	"bytes"
 	"errors"
+	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/libp2p/go-libp2p/core/crypto"
@@ -110,6 +112,86 @@ func TestConsumeTypedEnvelope(t *testing.T) {
 	}
 }
 
+func TestConsumeEnvelopes(t *testing.T) {
+	RegisterType(&simpleRecord{})
+	var data [][]byte
+	for i := 0; i < 20; i++ {
+		priv, _, err := test.RandTestKeyPair(crypto.Ed25519, 256)
+		test.AssertNilError(t, err)
+		envelope, err := Seal(&simpleRecord{message: fmt.Sprintf("record %d", i)}, priv)
+		test.AssertNilError(t, err)
+		serialized, err := envelope.Marshal()
+		test.AssertNilError(t, err)
+		data = append(data, serialized)
+	}
+	// an envelope with an invalid signature
+	priv, _, err := test.RandTestKeyPair(crypto.Ed25519, 256)
+	test.AssertNilError(t, err)
+	otherDomain := "other-domain"
+	envelope, err := Seal(&simpleRecord{testDomain: &otherDomain, message: "forged"}, priv)
+	test.AssertNilError(t, err)
+	serialized, err := envelope.Marshal()
+	test.AssertNilError(t, err)
+	data = append(data, serialized, []byte("not an envelope"))
+
+	envelopes, recs, errs := ConsumeEnvelopes(data, "libp2p-testing")
+	for i := 0; i < 20; i++ {
+		test.AssertNilError(t, errs[i])
+		if envelopes[i] == nil {
+			t.Fatal("expected an envelope")
+		}
+		if msg := recs[i].(*simpleRecord).message; msg != fmt.Sprintf("record %d", i) {
+			t.Errorf("unexpected record %q", msg)
+		}
+	}
+	if !errors.Is(errs[20], ErrInvalidSignature) || envelopes[20] != nil || recs[20] != nil {
+		t.Error("expected the forged envelope to be rejected")
+	}
+	if errs[21] == nil || envelopes[21] != nil || recs[21] != nil {
+		t.Error("expected the invalid envelope to be rejected")
+	}
+}
+
+func TestEnvelopeBatcher(t *testing.T) {
+	RegisterType(&simpleRecord{})
+	b := NewEnvelopeBatcher("libp2p-testing")
+	otherDomain := "other-domain"
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			priv, _, err := test.RandTestKeyPair(crypto.Ed25519, 256)
+			test.AssertNilError(t, err)
+			rec := &simpleRecord{message: fmt.Sprintf("record %d", i)}
+			if i%5 == 0 {
+				rec.testDomain = &otherDomain
+			}
+			envelope, err := Seal(rec, priv)
+			test.AssertNilError(t, err)
+			serialized, err := envelope.Marshal()
+			test.AssertNilError(t, err)
+
+			_, consumed, err := b.ConsumeEnvelope(serialized)
+			if i%5 == 0 {
+				if !errors.Is(err, ErrInvalidSignature) {
+					t.Errorf("expected record %d to be rejected, got %v", i, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error for record %d: %s", i, err)
+				return
+			}
+			if msg := consumed.(*simpleRecord).message; msg != rec.message {
+				t.Errorf("unexpected record %q, expected %q", msg, rec.message)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
 func TestMakeEnvelopeFailsWithEmptyDomain(t *testing.T) {
 	var (
 		rec          = simpleRecord{message: "hello world!"}
@@ -145,6 +227,80 @@ func TestMakeEnvelopeFailsWithEmptyPayloadType(t *testing.T) {
 	test.ExpectError(t, err, "making an envelope with an empty payloadType should fail")
 }
 
+func TestConsumeEnvelopeMigratesPayload(t *testing.T) {
+	RegisterType(&simpleRecord{})
+	v1 := []byte("/libp2p/testdata/v1")
+	v2 := []byte("/libp2p/testdata/v2")
+	RegisterMigration(v1, v2, func(payload []byte) ([]byte, error) {
+		return append([]byte("v2: "), payload...), nil
+	})
+	RegisterMigration(v2, []byte("/libp2p/testdata"), func(payload []byte) ([]byte, error) {
+		return append([]byte("v3: "), payload...), nil
+	})
+
+	priv, _, err := test.RandTestKeyPair(crypto.Ed25519, 256)
+	test.AssertNilError(t, err)
+	envelope, err := Seal(&simpleRecord{testCodec: v1, message: "hello"}, priv)
+	test.AssertNilError(t, err)
+	serialized, err := envelope.Marshal()
+	test.AssertNilError(t, err)
+
+	envelope, rec, err := ConsumeEnvelope(serialized, "libp2p-testing")
+	test.AssertNilError(t, err)
+	if msg := rec.(*simpleRecord).message; msg != "v3: v2: hello" {
+		t.Errorf("unexpected migrated record %q", msg)
+	}
+	// the envelope still contains the signed payload
+	if !bytes.Equal(envelope.PayloadType, v1) || string(envelope.RawPayload) != "hello" {
+		t.Error("envelope was modified by the migration")
+	}
+	reserialized, err := envelope.Marshal()
+	test.AssertNilError(t, err)
+	if !bytes.Equal(reserialized, serialized) {
+		t.Error("envelope doesn't round trip")
+	}
+}
+
+func TestConsumeEnvelopeMigrationCycle(t *testing.T) {
+	a, b := []byte("/libp2p/testdata/a"), []byte("/libp2p/testdata/b")
+	nop := func(payload []byte) ([]byte, error) { return payload, nil }
+	RegisterMigration(a, b, nop)
+	RegisterMigration(b, a, nop)
+
+	priv, _, err := test.RandTestKeyPair(crypto.Ed25519, 256)
+	test.AssertNilError(t, err)
+	envelope, err := Seal(&simpleRecord{testCodec: a, message: "hello"}, priv)
+	test.AssertNilError(t, err)
+	serialized, err := envelope.Marshal()
+	test.AssertNilError(t, err)
+
+	_, _, err = ConsumeEnvelope(serialized, "libp2p-testing")
+	test.ExpectError(t, err, "consuming an envelope with a migration cycle should fail")
+}
+
+func TestConsumeEnvelopeUnknownPayloadType(t *testing.T) {
+	priv, _, err := test.RandTestKeyPair(crypto.Ed25519, 256)
+	test.AssertNilError(t, err)
+	envelope, err := Seal(&simpleRecord{testCodec: []byte("/libp2p/testdata/unknown"), message: "hello"}, priv)
+	test.AssertNilError(t, err)
+	serialized, err := envelope.Marshal()
+	test.AssertNilError(t, err)
+
+	envelope, rec, err := ConsumeEnvelope(serialized, "libp2p-testing")
+	if !errors.Is(err, ErrPayloadTypeNotRegistered) {
+		t.Fatalf("expected ErrPayloadTypeNotRegistered, got %v", err)
+	}
+	if rec != nil || envelope == nil {
+		t.Fatal("expected the envelope, and no record")
+	}
+	// the envelope can be passed on intact
+	reserialized, err := envelope.Marshal()
+	test.AssertNilError(t, err)
+	if !bytes.Equal(reserialized, serialized) {
+		t.Error("envelope doesn't round trip")
+	}
+}
+
 type failingRecord struct {
 	allowMarshal   bool
 	allowUnmarshal bool
@@ -2,6 +2,7 @@ package record
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
 
 	"github.com/libp2p/go-libp2p/core/internal/catch"
@@ -13,8 +14,14 @@ var (
 	ErrPayloadTypeNotRegistered = errors.New("payload type is not registered")
 
 	payloadTypeRegistry = make(map[string]reflect.Type)
+	migrationRegistry   = make(map[string]migration)
 )
 
+type migration struct {
+	to      []byte
+	migrate func(payload []byte) ([]byte, error)
+}
+
 // Record represents a data type that can be used as the payload of an Envelope.
 // The Record interface defines the methods used to marshal and unmarshal a Record
 // type to a byte slice.
@@ -71,9 +78,51 @@ func RegisterType(prototype Record) {
 	payloadTypeRegistry[string(prototype.Codec())] = getValueType(prototype)
 }
 
+// RegisterMigration registers a function that converts payloads of type from
+// into payloads of type to. This allows a Record type to evolve: when its
+// payload format changes, the new version gets a new Codec, and a migration
+// from the previous Codec keeps envelopes signed in the previous format usable.
+//
+// Envelopes with an unregistered PayloadType are migrated when their Record is
+// unmarshalled, following the chain of migrations up to a registered type (e.g.
+// v1 to v2 to v3). The Envelope itself is not modified: its PayloadType and
+// RawPayload remain the signed ones, so it can be marshalled and passed on
+// intact.
+//
+// Like RegisterType, RegisterMigration should be called in an init function.
+func RegisterMigration(from, to []byte, migrate func(payload []byte) ([]byte, error)) {
+	migrationRegistry[string(from)] = migration{to: to, migrate: migrate}
+}
+
+// migratePayload migrates the payload to a registered payload type, and
+// returns that type and the migrated payload.
+func migratePayload(payloadType []byte, payload []byte) ([]byte, []byte, error) {
+	// Each step moves to another payload type, so a longer chain has a cycle.
+	for i := 0; i <= len(migrationRegistry); i++ {
+		if _, ok := payloadTypeRegistry[string(payloadType)]; ok {
+			return payloadType, payload, nil
+		}
+		m, ok := migrationRegistry[string(payloadType)]
+		if !ok {
+			return nil, nil, ErrPayloadTypeNotRegistered
+		}
+		var err error
+		payload, err = m.migrate(payload)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to migrate payload: %w", err)
+		}
+		payloadType = m.to
+	}
+	return nil, nil, errors.New("payload type migrations form a cycle")
+}
+
 func unmarshalRecordPayload(payloadType []byte, payloadBytes []byte) (_rec Record, err error) {
 	defer func() { catch.HandlePanic(recover(), &err, "libp2p envelope record unmarshal") }()
 
+	payloadType, payloadBytes, err = migratePayload(payloadType, payloadBytes)
+	if err != nil {
+		return nil, err
+	}
 	rec, err := blankRecordForPayloadType(payloadType)
 	if err != nil {
 		return nil, err
@@ -31,6 +31,21 @@ type SecureTransport interface {
 	ID() protocol.ID
 }
 
+// MaxIdentifySnapshotSize is the maximum size of an identify snapshot embedded
+// in a security handshake. Larger snapshots are neither sent nor accepted.
+const MaxIdentifySnapshotSize = 8 << 10
+
+// IdentifySnapshotter is implemented by SecureTransports that can embed an
+// identify snapshot of the local peer in the handshake, so that the remote
+// peer doesn't have to wait for the identify round trip. The snapshot sent by
+// the remote peer is returned in the IdentifySnapshot field of the
+// network.ConnectionState of the secured connection.
+type IdentifySnapshotter interface {
+	// SetIdentifySnapshot sets the function returning the snapshot sent in
+	// subsequent handshakes. No snapshot is sent if it returns nil.
+	SetIdentifySnapshot(func() []byte)
+}
+
 type ErrPeerIDMismatch struct {
 	Expected peer.ID
 	Actual   peer.ID
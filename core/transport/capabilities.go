@@ -0,0 +1,95 @@
+package transport
+
+import (
+	"strings"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Capabilities is a bitmask of optional features of a transport.
+type Capabilities uint32
+
+const (
+	// CapHolePunching is set by transports whose connections can be
+	// established through NATs by hole punching (see the holepunch package).
+	CapHolePunching Capabilities = 1 << iota
+	// CapDatagrams is set by transports that can send unreliable datagrams.
+	CapDatagrams
+	// CapBrowser is set by transports that browsers can dial.
+	CapBrowser
+	// CapCerthash is set by transports whose addresses must contain the hash
+	// of the listener's certificate (/certhash) to be dialable.
+	CapCerthash
+	// CapSharedUDPPort is set by transports that can listen on the same UDP
+	// port as other transports.
+	CapSharedUDPPort
+	// CapNetworkMigration is set by transports whose connections survive
+	// changes of the local interface addresses, because the transport moves
+	// them to the new network itself, e.g. by restarting ICE.
+	CapNetworkMigration
+)
+
+var capabilityNames = []struct {
+	cap  Capabilities
+	name string
+}{
+	{CapHolePunching, "hole-punching"},
+	{CapDatagrams, "datagrams"},
+	{CapBrowser, "browser"},
+	{CapCerthash, "certhash"},
+	{CapSharedUDPPort, "shared-udp-port"},
+	{CapNetworkMigration, "network-migration"},
+}
+
+// Has returns true if c contains all capabilities in caps.
+func (c Capabilities) Has(caps Capabilities) bool {
+	return c&caps == caps
+}
+
+func (c Capabilities) String() string {
+	if c == 0 {
+		return "none"
+	}
+	names := make([]string, 0, len(capabilityNames))
+	for _, n := range capabilityNames {
+		if c.Has(n.cap) {
+			names = append(names, n.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// CapabilityReporter is optionally implemented by transports to report their
+// capabilities.
+type CapabilityReporter interface {
+	Capabilities() Capabilities
+}
+
+// CapabilitiesOf returns the capabilities of t. Transports that don't
+// implement CapabilityReporter have no capabilities.
+func CapabilitiesOf(t Transport) Capabilities {
+	if r, ok := t.(CapabilityReporter); ok {
+		return r.Capabilities()
+	}
+	return 0
+}
+
+// CapabilityResolver is implemented by networks that know the capabilities of
+// the transport used for an address, like the swarm.
+type CapabilityResolver interface {
+	// TransportCapabilities returns the capabilities of the transport used to
+	// dial (or listen on) a. The second return value is false if no
+	// transport handles a.
+	TransportCapabilities(a ma.Multiaddr) (Capabilities, bool)
+}
+
+// NeedsCerthash returns true if a is handled by a transport with CapCerthash,
+// but doesn't contain a /certhash yet.
+func NeedsCerthash(r CapabilityResolver, a ma.Multiaddr) bool {
+	caps, ok := r.TransportCapabilities(a)
+	if !ok || !caps.Has(CapCerthash) {
+		return false
+	}
+	_, err := a.ValueForProtocol(ma.P_CERTHASH)
+	return err != nil
+}
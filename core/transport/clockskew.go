@@ -0,0 +1,15 @@
+package transport
+
+import "time"
+
+// ClockSkewError is implemented by dial errors that were likely caused by a
+// wrong clock, e.g. because the certificate presented by the peer wasn't valid
+// at the local time. It can't be told from a single error whether the local
+// clock or the peer's clock is wrong.
+type ClockSkewError interface {
+	error
+	// ClockSkew is the minimum offset of the local clock that explains the
+	// error. It is positive if the local clock is ahead, and negative if it is
+	// behind.
+	ClockSkew() time.Duration
+}
@@ -4,6 +4,7 @@ package libp2p
 
 import (
 	"crypto/rand"
+	"runtime"
 
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
@@ -59,7 +60,11 @@ var DefaultPrivateTransports = ChainOptions(
 
 // DefaultPeerstore configures libp2p to use the default peerstore.
 var DefaultPeerstore Option = func(cfg *Config) error {
-	ps, err := pstoremem.NewPeerstore()
+	var opts []pstoremem.Option
+	if cfg.Clock != nil {
+		opts = append(opts, pstoremem.WithClock(cfg.Clock))
+	}
+	ps, err := pstoremem.NewPeerstore(opts...)
 	if err != nil {
 		return err
 	}
@@ -105,7 +110,12 @@ var DefaultResourceManager = func(cfg *Config) error {
 	// Default memory limit: 1/8th of total memory, minimum 128MB, maximum 1GB
 	limits := rcmgr.DefaultLimits
 	SetDefaultServiceLimits(&limits)
-	mgr, err := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(limits.AutoScale()))
+	for _, scope := range cfg.ServiceScopes {
+		if scope.Limit != (rcmgr.BaseLimit{}) {
+			limits.AddServiceLimit(scope.Name, scope.Limit, rcmgr.BaseLimitIncrease{})
+		}
+	}
+	mgr, err := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(limits.AutoScale()), rcmgr.WithDisabledMetricsLabels(cfg.DisabledMetricsLabels...))
 	if err != nil {
 		return err
 	}
@@ -142,7 +152,8 @@ var defaults = []struct {
 	opt      Option
 }{
 	{
-		fallback: func(cfg *Config) bool { return cfg.Transports == nil && cfg.ListenAddrs == nil },
+		// Browsers can't accept incoming connections, don't listen by default.
+		fallback: func(cfg *Config) bool { return cfg.Transports == nil && cfg.ListenAddrs == nil && runtime.GOOS != "js" },
 		opt:      DefaultListenAddrs,
 	},
 	{
@@ -0,0 +1,17 @@
+//go:build !libp2plite
+
+package libp2p
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// These tests need subsystems that are compiled out of libp2plite builds.
+
+func TestAutoNATService(t *testing.T) {
+	h, err := New(EnableNATService())
+	require.NoError(t, err)
+	h.Close()
+}
@@ -6,15 +6,26 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/libp2p/go-libp2p/config"
 	"github.com/libp2p/go-libp2p/core/connmgr"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/core/routing"
 	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/host/autorelay"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
 	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	tptu "github.com/libp2p/go-libp2p/p2p/net/upgrader"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	"github.com/libp2p/go-libp2p/p2p/security/membership"
 	"github.com/libp2p/go-libp2p/p2p/security/noise"
 	tls "github.com/libp2p/go-libp2p/p2p/security/tls"
 	quic "github.com/libp2p/go-libp2p/p2p/transport/quic"
@@ -22,6 +33,7 @@ import (
 	webtransport "github.com/libp2p/go-libp2p/p2p/transport/webtransport"
 	"go.uber.org/goleak"
 
+	"github.com/ipfs/go-cid"
 	ma "github.com/multiformats/go-multiaddr"
 	"github.com/stretchr/testify/require"
 )
@@ -83,6 +95,43 @@ func TestInsecure(t *testing.T) {
 	h.Close()
 }
 
+func TestDisallowInsecure(t *testing.T) {
+	_, err := New(NoSecurity, DisallowInsecure)
+	require.Error(t, err)
+	_, err = New(DisallowInsecure, NoSecurity)
+	require.Error(t, err)
+
+	h, err := New(DisallowInsecure)
+	require.NoError(t, err)
+	h.Close()
+}
+
+func TestHandshakeLimits(t *testing.T) {
+	_, err := New(HandshakeLimits(0, 10))
+	require.Error(t, err)
+
+	_, err = New(ListenerUpgradeLimits(0, 10))
+	require.Error(t, err)
+
+	h, err := New(HandshakeLimits(4, 16), ListenerUpgradeLimits(2, 8), ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	h.Close()
+}
+
+func TestAdmissionTransports(t *testing.T) {
+	// don't apply the defaults, so that nothing needs to be cleaned up
+	var cfg Config
+	require.NoError(t, cfg.Apply(Admission(tptu.Admission{}), Transport(tcp.NewTCPTransport), Transport(quic.NewTransport)))
+	_, err := cfg.NewNode()
+	require.ErrorContains(t, err, "cannot enable admission")
+	require.ErrorContains(t, err, "quic.NewTransport")
+	require.NotContains(t, err.Error(), "tcp")
+
+	h, err := New(Admission(tptu.Admission{}), Transport(tcp.NewTCPTransport), ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	h.Close()
+}
+
 func TestDefaultListenAddrs(t *testing.T) {
 	reTCP := regexp.MustCompile("/(ip)[4|6]/((0.0.0.0)|(::))/tcp/")
 	reQUIC := regexp.MustCompile("/(ip)[4|6]/((0.0.0.0)|(::))/udp/([0-9]*)/quic-v1")
@@ -277,6 +326,81 @@ func TestSecurityConstructor(t *testing.T) {
 	require.NoError(t, h2.Connect(context.Background(), ai))
 }
 
+func TestSecurityConstructorWithOpts(t *testing.T) {
+	authority, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	newHost := func(t *testing.T, isMember bool, opts ...Option) host.Host {
+		priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		require.NoError(t, err)
+		var secOpts []interface{}
+		if isMember {
+			id, err := peer.IDFromPrivateKey(priv)
+			require.NoError(t, err)
+			cert, err := membership.Issue(authority, &membership.Certificate{Network: "test", Peer: id, NotAfter: time.Now().Add(time.Hour)})
+			require.NoError(t, err)
+			m, err := membership.New(authority.GetPublic(), cert)
+			require.NoError(t, err)
+			secOpts = append(secOpts, noise.WithMembership(m))
+		}
+		h, err := New(append(opts,
+			Identity(priv),
+			Transport(tcp.NewTCPTransport),
+			Security(noise.ID, noise.New, secOpts...),
+			DisableRelay(),
+		)...)
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+		return h
+	}
+
+	h := newHost(t, true, ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	ai := peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()}
+	require.NoError(t, newHost(t, true, NoListenAddrs).Connect(context.Background(), ai))
+
+	// The dialer learns about its rejection only after completing the handshake.
+	other := newHost(t, false, ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	_ = other.Connect(context.Background(), ai)
+	require.Eventually(t, func() bool { return len(other.Network().ConnsToPeer(h.ID())) == 0 }, 5*time.Second, 10*time.Millisecond)
+	err = h.Connect(context.Background(), peer.AddrInfo{ID: other.ID(), Addrs: other.Addrs()})
+	require.ErrorContains(t, err, "membership certificate")
+
+	_, err = New(
+		Security(noise.ID, noise.New, tls.WithKeyLogWriter(nil)),
+		DisableRelay(),
+	)
+	require.ErrorContains(t, err, "security transport option of type libp2ptls.IdentityOption not assignable to noise.Option")
+}
+
+func TestFastIdentify(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		security Option
+	}{
+		{name: "noise", security: Security(noise.ID, noise.New)},
+		{name: "tls", security: Security(tls.ID, tls.New)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			newHost := func(t *testing.T, opts ...Option) host.Host {
+				h, err := New(append(opts, FastIdentify(), Transport(tcp.NewTCPTransport), tc.security, DisableRelay())...)
+				require.NoError(t, err)
+				t.Cleanup(func() { h.Close() })
+				return h
+			}
+			h1 := newHost(t, ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+			h2 := newHost(t, NoListenAddrs)
+			require.NoError(t, h2.Connect(context.Background(), peer.AddrInfo{ID: h1.ID(), Addrs: h1.Addrs()}))
+
+			conns := h2.Network().ConnsToPeer(h1.ID())
+			require.Len(t, conns, 1)
+			require.NotEmpty(t, conns[0].ConnState().IdentifySnapshot)
+			// Connect waits for identify, which returns as soon as the snapshot is consumed
+			protos, err := h2.Peerstore().SupportsProtocols(h1.ID(), identify.ID)
+			require.NoError(t, err)
+			require.Equal(t, []protocol.ID{identify.ID}, protos)
+		})
+	}
+}
+
 func TestTransportConstructorWebTransport(t *testing.T) {
 	h, err := New(
 		Transport(webtransport.New),
@@ -370,12 +494,158 @@ func TestRoutedHost(t *testing.T) {
 	require.Equal(t, []peer.ID{id}, mockRouter.queried)
 }
 
-func TestAutoNATService(t *testing.T) {
-	h, err := New(EnableNATService())
+type mockContentRouting struct {
+	mockPeerRouting
+}
+
+func (r *mockContentRouting) Provide(context.Context, cid.Cid, bool) error { return nil }
+
+func (r *mockContentRouting) FindProvidersAsync(context.Context, cid.Cid, int) <-chan peer.AddrInfo {
+	ch := make(chan peer.AddrInfo)
+	close(ch)
+	return ch
+}
+
+func TestAutoRelayWithRouting(t *testing.T) {
+	h, err := New(
+		ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		Routing(func(host.Host) (routing.PeerRouting, error) { return &mockContentRouting{}, nil }),
+		EnableAutoRelayWithRouting(),
+	)
 	require.NoError(t, err)
 	h.Close()
 }
 
+// sharedContentRouting is an in-memory content routing table, shared by the
+// hosts of a test.
+type sharedContentRouting struct {
+	mu        sync.Mutex
+	providers map[cid.Cid][]peer.AddrInfo
+}
+
+func (r *sharedContentRouting) forHost(h host.Host) routing.PeerRouting {
+	return &hostContentRouting{h: h, r: r}
+}
+
+type hostContentRouting struct {
+	h host.Host
+	r *sharedContentRouting
+}
+
+func (r *hostContentRouting) FindPeer(context.Context, peer.ID) (peer.AddrInfo, error) {
+	return peer.AddrInfo{}, routing.ErrNotFound
+}
+
+func (r *hostContentRouting) Provide(_ context.Context, c cid.Cid, _ bool) error {
+	r.r.mu.Lock()
+	defer r.r.mu.Unlock()
+	r.r.providers[c] = append(r.r.providers[c], peer.AddrInfo{ID: r.h.ID(), Addrs: r.h.Addrs()})
+	return nil
+}
+
+func (r *hostContentRouting) FindProvidersAsync(_ context.Context, c cid.Cid, _ int) <-chan peer.AddrInfo {
+	r.r.mu.Lock()
+	defer r.r.mu.Unlock()
+	ch := make(chan peer.AddrInfo, len(r.r.providers[c]))
+	for _, pi := range r.r.providers[c] {
+		ch <- pi
+	}
+	close(ch)
+	return ch
+}
+
+func TestAutoRelayDiscoveredThroughRouting(t *testing.T) {
+	cr := &sharedContentRouting{providers: make(map[cid.Cid][]peer.AddrInfo)}
+	relay, err := New(
+		Transport(tcp.NewTCPTransport),
+		ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		Routing(func(h host.Host) (routing.PeerRouting, error) { return cr.forHost(h), nil }),
+		EnableRelayService(),
+		ForceReachabilityPublic(),
+	)
+	require.NoError(t, err)
+	defer relay.Close()
+
+	h, err := New(
+		Transport(tcp.NewTCPTransport),
+		ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		Routing(func(h host.Host) (routing.PeerRouting, error) { return cr.forHost(h), nil }),
+		EnableAutoRelayWithRouting(
+			autorelay.WithBootDelay(0),
+			autorelay.WithMinCandidates(1),
+			autorelay.WithNumRelays(1),
+			autorelay.WithMinInterval(100*time.Millisecond),
+		),
+		ForceReachabilityPrivate(),
+	)
+	require.NoError(t, err)
+	defer h.Close()
+
+	// h discovers the relay and obtains a reservation, so that it can be
+	// reached through the relay
+	h2, err := New(Transport(tcp.NewTCPTransport), EnableRelay(), NoListenAddrs)
+	require.NoError(t, err)
+	defer h2.Close()
+	h.SetStreamHandler("/test", func(s network.Stream) { s.Close() })
+	relayed := relay.Addrs()[0].Encapsulate(ma.StringCast("/p2p/" + relay.ID().String() + "/p2p-circuit"))
+	require.Eventually(t, func() bool {
+		return h2.Connect(context.Background(), peer.AddrInfo{ID: h.ID(), Addrs: []ma.Multiaddr{relayed}}) == nil
+	}, 10*time.Second, 100*time.Millisecond)
+	s, err := h2.NewStream(network.WithUseTransient(context.Background(), "test"), h.ID(), "/test")
+	require.NoError(t, err)
+	s.Close()
+}
+
+func TestDedicatedServiceScope(t *testing.T) {
+	h1, err := New(
+		ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		DedicatedServiceScope(ping.ServiceName, config.ServiceScope{
+			Name: "test.ping",
+			// too little memory for a single ping
+			Limit:               rcmgr.BaseLimit{Streams: 1, StreamsInbound: 1, Memory: 1},
+			ReservationPriority: network.ReservationPriorityLow,
+		}),
+	)
+	require.NoError(t, err)
+	defer h1.Close()
+	h2, err := New(ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer h2.Close()
+
+	require.NoError(t, h2.Connect(context.Background(), peer.AddrInfo{ID: h1.ID(), Addrs: h1.Addrs()}))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res := <-ping.Ping(ctx, h2, h1.ID())
+	require.Error(t, res.Error)
+	res = <-ping.Ping(ctx, h1, h2.ID())
+	require.NoError(t, res.Error)
+
+	_, err = New(DedicatedServiceScope("libp2p.echo", config.ServiceScope{Name: "test.echo"}))
+	require.Error(t, err)
+}
+
+func TestDedicatedServiceScopeDefaultPriority(t *testing.T) {
+	h1, err := New(
+		ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		DedicatedServiceScope(ping.ServiceName, config.ServiceScope{
+			Name: "test.ping",
+			// enough memory for a ping, but only with ReservationPriorityAlways
+			Limit: rcmgr.BaseLimit{Streams: 1, StreamsInbound: 1, Memory: 4 * ping.PingSize},
+		}),
+	)
+	require.NoError(t, err)
+	defer h1.Close()
+	h2, err := New(ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer h2.Close()
+
+	require.NoError(t, h2.Connect(context.Background(), peer.AddrInfo{ID: h1.ID(), Addrs: h1.Addrs()}))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res := <-ping.Ping(ctx, h2, h1.ID())
+	require.NoError(t, res.Error)
+}
+
 func TestMain(m *testing.M) {
 	goleak.VerifyTestMain(
 		m,
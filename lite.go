@@ -0,0 +1,46 @@
+package libp2p
+
+// This file contains the "lite" option set for mobile and embedded users.
+
+import (
+	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	"github.com/libp2p/go-libp2p/p2p/security/noise"
+	quic "github.com/libp2p/go-libp2p/p2p/transport/quic"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+)
+
+// Lite configures libp2p with a reduced set of subsystems, suitable for
+// mobile and embedded devices where binary size and background CPU matter.
+//
+// It configures:
+//   - the TCP and QUIC transports only
+//   - the noise security transport only
+//   - the yamux stream multiplexer
+//   - a connection manager with low watermarks (16 / 32)
+//   - no Prometheus metrics
+//
+// The relay transport stays enabled so the node can still dial peers via
+// relays. The relay service and the AutoNAT service are never started.
+//
+// Lite can be combined with other options, as long as they don't configure the
+// same subsystems. For additional savings, also build with the `libp2plite`
+// build tag: this compiles out the relay service manager, the AutoNAT
+// service's dial-back host and the WebRTC transport. The relay (circuit v2)
+// and AutoNAT packages are still linked, since the relay client and the
+// AutoNAT client use them. In such builds, EnableRelayService and
+// EnableNATService cause New to return an error.
+var Lite Option = ChainOptions(
+	Transport(tcp.NewTCPTransport),
+	Transport(quic.NewTransport),
+	Security(noise.ID, noise.New),
+	Muxer(yamux.ID, yamux.DefaultTransport),
+	DisableMetrics(),
+	func(cfg *Config) error {
+		mgr, err := connmgr.NewConnManager(16, 32)
+		if err != nil {
+			return err
+		}
+		return cfg.Apply(ConnectionManager(mgr))
+	},
+)
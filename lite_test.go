@@ -0,0 +1,30 @@
+package libp2p
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLite(t *testing.T) {
+	h1, err := New(Lite, ListenAddrStrings("/ip4/127.0.0.1/tcp/0", "/ip4/127.0.0.1/udp/0/quic-v1"))
+	require.NoError(t, err)
+	defer h1.Close()
+	require.Len(t, h1.Addrs(), 2)
+
+	h2, err := New(Lite, ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer h2.Close()
+
+	var tcpAddrs []ma.Multiaddr
+	for _, a := range h1.Addrs() {
+		if _, err := a.ValueForProtocol(ma.P_TCP); err == nil {
+			tcpAddrs = append(tcpAddrs, a)
+		}
+	}
+	require.NoError(t, h2.Connect(context.Background(), peer.AddrInfo{ID: h1.ID(), Addrs: tcpAddrs}))
+}
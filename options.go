@@ -9,11 +9,14 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"runtime"
 	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/libp2p/go-libp2p/config"
 	"github.com/libp2p/go-libp2p/core/connmgr"
 	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/metrics"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -21,12 +24,20 @@ import (
 	"github.com/libp2p/go-libp2p/core/pnet"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/host/autonat"
 	"github.com/libp2p/go-libp2p/p2p/host/autorelay"
 	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	"github.com/libp2p/go-libp2p/p2p/host/conndedup"
+	"github.com/libp2p/go-libp2p/p2p/host/netmon"
+	"github.com/libp2p/go-libp2p/p2p/host/stun"
+	"github.com/libp2p/go-libp2p/p2p/metricshelper"
 	"github.com/libp2p/go-libp2p/p2p/net/swarm"
 	tptu "github.com/libp2p/go-libp2p/p2p/net/upgrader"
+	"github.com/libp2p/go-libp2p/p2p/protocol/attestation"
 	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
 	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
 	"github.com/libp2p/go-libp2p/p2p/transport/quicreuse"
 	"github.com/prometheus/client_golang/prometheus"
 
@@ -71,12 +82,27 @@ func ListenAddrs(addrs ...ma.Multiaddr) Option {
 // * Host
 // * Network
 // * Peerstore
-func Security(name string, constructor interface{}) Option {
+//
+// Like for Transport, options are passed to the constructor, if it takes them
+// as variadic arguments, e.g. Security(noise.ID, noise.New, noise.WithMembership(m)).
+func Security(name string, constructor interface{}, opts ...interface{}) Option {
 	return func(cfg *Config) error {
 		if cfg.Insecure {
 			return fmt.Errorf("cannot use security transports with an insecure libp2p configuration")
 		}
-		cfg.SecurityTransports = append(cfg.SecurityTransports, config.Security{ID: protocol.ID(name), Constructor: constructor})
+		if len(opts) > 0 {
+			typ := reflect.ValueOf(constructor).Type()
+			if !typ.IsVariadic() {
+				return errors.New("security transport constructor doesn't take any options")
+			}
+			paramType := typ.In(typ.NumIn() - 1).Elem()
+			for _, opt := range opts {
+				if typ := reflect.TypeOf(opt); !typ.AssignableTo(paramType) {
+					return fmt.Errorf("security transport option of type %s not assignable to %s", typ, paramType)
+				}
+			}
+		}
+		cfg.SecurityTransports = append(cfg.SecurityTransports, config.Security{ID: protocol.ID(name), Constructor: constructor, Options: opts})
 		return nil
 	}
 }
@@ -87,10 +113,92 @@ var NoSecurity Option = func(cfg *Config) error {
 	if len(cfg.SecurityTransports) > 0 {
 		return fmt.Errorf("cannot use security transports with an insecure libp2p configuration")
 	}
+	if cfg.DisallowInsecure {
+		return fmt.Errorf("cannot disable security when insecure connections are disallowed")
+	}
 	cfg.Insecure = true
 	return nil
 }
 
+// DisallowInsecure makes sure that connections never fall back to plaintext:
+// constructing the host fails if NoSecurity is used, or if the insecure
+// transport is configured as a security transport.
+var DisallowInsecure Option = func(cfg *Config) error {
+	if cfg.Insecure {
+		return fmt.Errorf("cannot disallow insecure connections when security is disabled")
+	}
+	cfg.DisallowInsecure = true
+	return nil
+}
+
+// HandshakeLimits limits the number of inbound connections that are upgraded
+// (secured and multiplexed) concurrently to workers, with up to queueLen more
+// connections waiting for a worker. Connections arriving while the queue is
+// full are closed before the handshake. This protects the node from handshake
+// floods. It only applies to transports using the upgrader, like TCP and
+// WebSocket: QUIC-based transports handle their handshakes themselves.
+func HandshakeLimits(workers, queueLen int) Option {
+	return func(cfg *Config) error {
+		if workers <= 0 {
+			return fmt.Errorf("need at least one handshake worker")
+		}
+		if queueLen < 0 {
+			return fmt.Errorf("handshake queue length must not be negative")
+		}
+		cfg.HandshakeWorkers = workers
+		cfg.HandshakeQueueLen = queueLen
+		return nil
+	}
+}
+
+// ListenerUpgradeLimits limits the number of inbound connections of each
+// listener that are upgraded concurrently to workers, with up to queueLen more
+// connections waiting for a worker. Unlike HandshakeLimits, the limit applies
+// to every listener separately. Like HandshakeLimits, it only applies to
+// transports using the upgrader.
+func ListenerUpgradeLimits(workers, queueLen int) Option {
+	return func(cfg *Config) error {
+		if workers <= 0 {
+			return fmt.Errorf("need at least one upgrade worker per listener")
+		}
+		if queueLen < 0 {
+			return fmt.Errorf("upgrade queue length must not be negative")
+		}
+		cfg.ListenerUpgradeWorkers = workers
+		cfg.ListenerUpgradeQueueLen = queueLen
+		return nil
+	}
+}
+
+// Admission enables the admission step: inbound connections can be challenged
+// to solve a proof of work or to present an admission token before they are
+// usable, e.g. while the node is under load.
+//
+// The admission step isn't negotiated: it changes the wire protocol of every
+// upgraded connection, so all nodes of the network must enable it, and
+// connections to nodes that didn't fail. It only applies to transports using
+// the upgrader, e.g. TCP and WebSocket. Since QUIC, WebTransport and WebRTC
+// connections would bypass it, creating a node that enables any of these
+// transports together with admission fails.
+func Admission(a tptu.Admission) Option {
+	return func(cfg *Config) error {
+		if cfg.Admission != nil {
+			return fmt.Errorf("admission already configured")
+		}
+		cfg.Admission = &a
+		return nil
+	}
+}
+
+// HandshakeObserver registers an observer of failed inbound handshakes, like
+// the dosguard.Engine. It only applies to transports using the upgrader.
+func HandshakeObserver(o tptu.HandshakeObserver) Option {
+	return func(cfg *Config) error {
+		cfg.HandshakeObservers = append(cfg.HandshakeObservers, o)
+		return nil
+	}
+}
+
 // Muxer configures libp2p to use the given stream multiplexer.
 // name is the protocol name.
 func Muxer(name string, muxer network.Multiplexer) Option {
@@ -167,6 +275,16 @@ func Transport(constructor interface{}, opts ...interface{}) Option {
 			}
 		}
 
+		takesUpgrader := false
+		for i := 0; i < numParams; i++ {
+			if typ.In(i) == reflect.TypeOf((*transport.Upgrader)(nil)).Elem() {
+				takesUpgrader = true
+			}
+		}
+		if !takesUpgrader {
+			cfg.UpgraderlessTransports = append(cfg.UpgraderlessTransports, runtime.FuncForPC(reflect.ValueOf(constructor).Pointer()).Name())
+		}
+
 		var params []string
 		if isVariadic && len(opts) > 0 {
 			// If there are transport options, apply the tag.
@@ -268,6 +386,21 @@ func AddrsFactory(factory config.AddrsFactory) Option {
 	}
 }
 
+// ExternalAddr advertises the listen addresses bound to the IP address and
+// port of bound (e.g. /ip4/0.0.0.0/udp/4001) with the IP address or DNS name
+// and port of external (e.g. /dns4/example.com/udp/443) instead. Use it when
+// the listeners are fronted by a reverse proxy or an L4 load balancer. The
+// rest of the addresses, including the certhashes of WebTransport and WebRTC
+// addresses, is kept as is. Use the WithProxyProtocol options of the TCP and
+// WebSocket transports to learn the addresses of the clients connecting
+// through the proxy.
+func ExternalAddr(bound, external ma.Multiaddr) Option {
+	return func(cfg *Config) error {
+		cfg.ExternalAddrs = append(cfg.ExternalAddrs, bhost.ExternalAddr{Bound: bound, External: external})
+		return nil
+	}
+}
+
 // EnableRelay configures libp2p to enable the relay transport.
 // This option only configures libp2p to accept inbound connections from relays
 // and make outbound connections_through_ relays when requested by the remote peer.
@@ -292,6 +425,8 @@ func DisableRelay() Option {
 
 // EnableRelayService configures libp2p to run a circuit v2 relay,
 // if we detect that we're publicly reachable.
+// If the configured Routing implements routing.ContentRouting, the relay is
+// advertised through it, so that it can be found by EnableAutoRelayWithRouting.
 func EnableRelayService(opts ...relayv2.Option) Option {
 	return func(cfg *Config) error {
 		cfg.EnableRelayService = true
@@ -345,6 +480,22 @@ func EnableAutoRelayWithPeerSource(peerSource autorelay.PeerSource, opts ...auto
 	}
 }
 
+// EnableAutoRelayWithRouting configures libp2p to enable the AutoRelay
+// subsystem, discovering relay candidates through the configured Routing (see
+// the Routing option). The router must implement routing.ContentRouting.
+// Candidates are probed for liveness and support of the relay protocol before
+// they are handed to AutoRelay, see autorelay.NewRoutingPeerSource. Relays
+// advertise themselves when they are configured with EnableRelayService and a
+// content routing.
+func EnableAutoRelayWithRouting(opts ...autorelay.Option) Option {
+	return func(cfg *Config) error {
+		cfg.EnableAutoRelay = true
+		cfg.AutoRelayWithRouting = true
+		cfg.AutoRelayOpts = opts
+		return nil
+	}
+}
+
 // ForceReachabilityPublic overrides automatic reachability detection in the AutoNAT subsystem,
 // forcing the local node to believe it is reachable externally.
 func ForceReachabilityPublic() Option {
@@ -474,6 +625,7 @@ var NoListenAddrs = func(cfg *Config) error {
 // options) and prevent libp2p from applying the default transports.
 var NoTransports = func(cfg *Config) error {
 	cfg.Transports = []fx.Option{}
+	cfg.UpgraderlessTransports = nil
 	return nil
 }
 
@@ -541,6 +693,202 @@ func EnableHolePunching(opts ...holepunch.Option) Option {
 	}
 }
 
+// EnableAddrAttestation enables exchanging signed address attestations with
+// other peers. (default: disabled)
+//
+// When dialing a peer that also enabled it, the host attests that it
+// successfully dialed the peer at the address of the connection. Attestations
+// of our own addresses received from other peers are included in our signed
+// peer record, as evidence that these addresses are dialable by others.
+// See the attestation package for details.
+func EnableAddrAttestation(opts ...attestation.Option) Option {
+	return func(cfg *Config) error {
+		cfg.EnableAddrAttestation = true
+		cfg.AddrAttestationOptions = opts
+		return nil
+	}
+}
+
+// IdentifyLimits limits the number of protocols and addresses, and the size of
+// the identify messages sent to other peers. If a limit is exceeded, rarely
+// used protocols and private addresses are elided first.
+// See identify.Limits for details.
+func IdentifyLimits(l identify.Limits) Option {
+	return func(cfg *Config) error {
+		cfg.IdentifyLimits = l
+		return nil
+	}
+}
+
+// IdentifyOptions configures the identify service, e.g. to disable identify
+// push (identify.DisablePush), to ignore the addresses other peers observe us
+// at (identify.DisableObservedAddrs), or to never record addresses sent by
+// other peers (identify.ReadOnly).
+func IdentifyOptions(opts ...identify.Option) Option {
+	return func(cfg *Config) error {
+		cfg.IdentifyOptions = append(cfg.IdentifyOptions, opts...)
+		return nil
+	}
+}
+
+// FastIdentify embeds a snapshot of our identify message in the security
+// handshake, for the security transports that support it (Noise and TLS).
+// Peers that enabled fast identify populate their peerstore as soon as the
+// connection is established, so the first stream can be opened without
+// waiting for the identify round trip. See identify.FastIdentify.
+func FastIdentify() Option {
+	return func(cfg *Config) error {
+		cfg.FastIdentify = true
+		return nil
+	}
+}
+
+// DedicatedServiceScope attaches the streams of a built-in service to a
+// dedicated resource manager service scope, so that peers abusing the service
+// can't consume the resources intended for application protocols. service is
+// the default service name of identify, ping or AutoNAT (identify.ServiceName,
+// ping.ServiceName or autonat.ServiceName).
+//
+// The memory reservations of the service's streams use scope's
+// ReservationPriority, e.g. network.ReservationPriorityLow. If it is 0, they
+// keep using network.ReservationPriorityAlways.
+func DedicatedServiceScope(service string, scope config.ServiceScope) Option {
+	return func(cfg *Config) error {
+		switch service {
+		case identify.ServiceName, ping.ServiceName, autonat.ServiceName:
+		default:
+			return fmt.Errorf("%s is not a built-in service", service)
+		}
+		if scope.Name == "" {
+			return errors.New("service scope name must not be empty")
+		}
+		if cfg.ServiceScopes == nil {
+			cfg.ServiceScopes = make(map[string]config.ServiceScope)
+		}
+		cfg.ServiceScopes[service] = scope
+		return nil
+	}
+}
+
+// ProtocolNegotiationCache makes the host remember, per connection, the
+// protocols the remote peer accepted and rejected. New streams for a protocol
+// the peer accepted skip the negotiation round trip, and protocols it rejected
+// aren't proposed again on the same connection.
+func ProtocolNegotiationCache() Option {
+	return func(cfg *Config) error {
+		cfg.NegotiationCache = true
+		return nil
+	}
+}
+
+// HandlerTimeout limits the time the handler of inbound streams for pid may
+// run. Streams whose handler is still running when the timeout expires are
+// reset. See basichost.BasicHost.SetHandlerTimeout.
+func HandlerTimeout(pid protocol.ID, d time.Duration) Option {
+	return func(cfg *Config) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid handler timeout for %s: %s", pid, d)
+		}
+		if cfg.HandlerTimeouts == nil {
+			cfg.HandlerTimeouts = make(map[protocol.ID]time.Duration)
+		}
+		cfg.HandlerTimeouts[pid] = d
+		return nil
+	}
+}
+
+// NegotiationTimeout overrides the protocol negotiation timeout of inbound
+// streams for pid. See basichost.BasicHost.SetNegotiationTimeout.
+func NegotiationTimeout(pid protocol.ID, d time.Duration) Option {
+	return func(cfg *Config) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid negotiation timeout for %s: %s", pid, d)
+		}
+		if cfg.NegotiationTimeouts == nil {
+			cfg.NegotiationTimeouts = make(map[protocol.ID]time.Duration)
+		}
+		cfg.NegotiationTimeouts[pid] = d
+		return nil
+	}
+}
+
+// EnableNetworkMonitor enables monitoring the local network interfaces for
+// address changes, e.g. when a mobile device switches between WiFi and
+// cellular. (default: disabled)
+//
+// When a change is detected, the host re-evaluates its addresses, AutoNAT
+// re-runs its reachability checks, and connections bound to addresses that are
+// no longer available are closed and re-established, instead of waiting for
+// them to time out.
+//
+// On platforms where network changes can't be detected automatically (e.g.
+// gomobile on iOS and Android), call SignalNetworkChange on the host from the
+// platform's connectivity callbacks.
+func EnableNetworkMonitor(opts ...netmon.Option) Option {
+	return func(cfg *Config) error {
+		cfg.EnableNetworkMonitor = true
+		cfg.NetworkMonitorOptions = opts
+		return nil
+	}
+}
+
+// EnableSTUN enables discovering our external QUIC addresses using STUN servers.
+// (default: disabled)
+//
+// This is useful when there are few peers to learn our addresses from using
+// identify, and when AutoNAT servers are scarce. The discovered addresses are
+// only advertised if they are consistent with the addresses observed by identify.
+// See the stun package for details.
+func EnableSTUN(opts ...stun.Option) Option {
+	return func(cfg *Config) error {
+		cfg.EnableSTUN = true
+		cfg.STUNOptions = opts
+		return nil
+	}
+}
+
+// LowPowerProfile sets the profile used to throttle background activity when
+// the host is switched to low power mode using SetPowerState. Zero fields keep
+// the normal behavior of the respective subsystem.
+// (default: basichost.DefaultLowPowerProfile)
+func LowPowerProfile(p event.PowerProfile) Option {
+	return func(cfg *Config) error {
+		cfg.LowPowerProfile = &p
+		return nil
+	}
+}
+
+// HealthCriteria sets the criteria the host's Health method evaluates, e.g.
+// for readiness checks. Use basichost.NewHealthHandler to serve them over HTTP.
+// (default: basichost.DefaultHealthCriteria)
+func HealthCriteria(c bhost.HealthCriteria) Option {
+	return func(cfg *Config) error {
+		cfg.HealthCriteria = &c
+		return nil
+	}
+}
+
+// DeduplicateConns closes redundant connections to the same peer, see
+// conndedup.Deduplicator, and makes new streams use the connection that is
+// kept.
+func DeduplicateConns(opts ...conndedup.Option) Option {
+	return func(cfg *Config) error {
+		cfg.DeduplicateConns = true
+		cfg.DeduplicateConnsOpts = opts
+		return nil
+	}
+}
+
+// RecoverHandlerPanics recovers panics in stream handlers. The stream of a
+// panicking handler is reset, and an event.EvtPanicRecovered is emitted.
+// By default, a panicking handler crashes the process.
+func RecoverHandlerPanics() Option {
+	return func(cfg *Config) error {
+		cfg.RecoverHandlerPanics = true
+		return nil
+	}
+}
+
 func WithDialTimeout(t time.Duration) Option {
 	return func(cfg *Config) error {
 		if t <= 0 {
@@ -551,6 +899,22 @@ func WithDialTimeout(t time.Duration) Option {
 	}
 }
 
+// WithClock sets the clock used for the dial backoffs of the swarm, the TTLs
+// of the default peerstore, identify and the relay service. This is mostly
+// useful to control time in tests.
+//
+// The connection manager is constructed by the user and takes its clock
+// directly, see connmgr.WithClock.
+func WithClock(cl clock.Clock) Option {
+	return func(cfg *Config) error {
+		if cfg.Clock != nil {
+			return fmt.Errorf("cannot specify multiple clocks")
+		}
+		cfg.Clock = cl
+		return nil
+	}
+}
+
 // DisableMetrics configures libp2p to disable prometheus metrics
 func DisableMetrics() Option {
 	return func(cfg *Config) error {
@@ -576,6 +940,45 @@ func PrometheusRegisterer(reg prometheus.Registerer) Option {
 	}
 }
 
+// PrometheusSubsystemRegisterer configures libp2p to use reg as the Registerer
+// for the metrics of subsystem s, instead of the one set using
+// PrometheusRegisterer.
+//
+// TCP metrics are not enabled by default, use tcp.WithMetricsRegisterer to
+// enable them with a custom Registerer.
+func PrometheusSubsystemRegisterer(s metricshelper.Subsystem, reg prometheus.Registerer) Option {
+	return func(cfg *Config) error {
+		if cfg.DisableMetrics {
+			return errors.New("cannot set registerer when metrics are disabled")
+		}
+		if _, ok := cfg.PrometheusRegisterers[s]; ok {
+			return fmt.Errorf("registerer for %s already set", s)
+		}
+		if reg == nil {
+			return errors.New("registerer cannot be nil")
+		}
+		if cfg.PrometheusRegisterers == nil {
+			cfg.PrometheusRegisterers = make(map[metricshelper.Subsystem]prometheus.Registerer)
+		}
+		cfg.PrometheusRegisterers[s] = reg
+		return nil
+	}
+}
+
+// DisableMetricsLabels drops the given labels (e.g. "transport" or "protocol")
+// from the metrics of all subsystems, to limit their cardinality. Series that
+// only differ in the dropped labels are merged.
+//
+// This applies to the swarm, hole punching and the default resource manager.
+// Since the per-protocol metrics of the resource manager are gauges, which
+// can't be merged, disabling "protocol" removes them.
+func DisableMetricsLabels(labels ...string) Option {
+	return func(cfg *Config) error {
+		cfg.DisabledMetricsLabels = append(cfg.DisabledMetricsLabels, labels...)
+		return nil
+	}
+}
+
 // DialRanker configures libp2p to use d as the dial ranker. To enable smart
 // dialing use `swarm.DefaultDialRanker`. use `swarm.NoDelayDialRanker` to
 // disable smart dialing.
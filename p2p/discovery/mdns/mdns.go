@@ -10,6 +10,7 @@ import (
 
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
 
 	"github.com/libp2p/zeroconf/v2"
 
@@ -180,6 +181,7 @@ func (s *mdnsService) startResolver(ctx context.Context) {
 				if info.ID == s.host.ID() {
 					continue
 				}
+				peerstore.AddAddrsFromSource(s.host.Peerstore(), info.ID, info.Addrs, peerstore.TempAddrTTL, peerstore.AddrSourceMDNS)
 				go s.notifee.HandlePeerFound(info)
 			}
 		}
@@ -0,0 +1,315 @@
+// Package addrcheck validates and normalizes the multiaddrs that enter a host.
+//
+// Addresses learned from other peers (via identify or signed peer records)
+// and passed in by the user (e.g. to Host.Connect) are checked before they are
+// stored or dialed:
+//
+//   - the protocols must be stacked in a sensible order, e.g. /tcp must follow
+//     an IP address or DNS name, and /quic-v1 must follow /udp,
+//   - /webtransport and /webrtc-direct addresses must contain at least one
+//     valid /certhash, as they can't be dialed otherwise,
+//   - IPv4-mapped IPv6 addresses are converted to /ip4,
+//   - /ip6zone is removed from addresses that aren't link-local, as the zone
+//     has no meaning for them,
+//   - consecutive /certhash components are sorted and deduplicated, so
+//     addresses that only differ in the order of their certhashes are equal.
+//
+// Rejected addresses are counted per boundary and reason, see RegisterMetrics.
+//
+// The peerstore only canonicalizes the addresses added to it, see Canonicalize.
+// It doesn't reject any address, so that addresses added by transports and
+// tests, which are trusted, are stored as they are.
+package addrcheck
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/p2p/metricshelper"
+	"github.com/prometheus/client_golang/prometheus"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multibase"
+	"github.com/multiformats/go-multihash"
+)
+
+var log = logging.Logger("addrcheck")
+
+var (
+	// ErrInvalidSequence is returned for addresses with protocols that can't
+	// be stacked, e.g. /ip4/1.2.3.4/quic-v1.
+	ErrInvalidSequence = errors.New("invalid protocol sequence")
+	// ErrMissingCerthash is returned for /webtransport and /webrtc-direct
+	// addresses without a /certhash.
+	ErrMissingCerthash = errors.New("missing certhash")
+	// ErrInvalidCerthash is returned for addresses with a /certhash that isn't
+	// a multibase-encoded multihash.
+	ErrInvalidCerthash = errors.New("invalid certhash")
+	// ErrInvalidZone is returned for addresses with an /ip6zone that isn't
+	// followed by an /ip6 address.
+	ErrInvalidZone = errors.New("ip6zone without ip6 address")
+)
+
+// Boundary is where an address entered the host.
+type Boundary string
+
+const (
+	BoundaryIdentify Boundary = "identify"
+	BoundaryUser     Boundary = "user"
+)
+
+var (
+	ipOrDNS = []int{ma.P_IP4, ma.P_IP6, ma.P_DNS, ma.P_DNS4, ma.P_DNS6}
+
+	// allowedAfter lists the protocols that the protocols in the map may
+	// follow. Protocols that aren't in the map may follow any protocol.
+	allowedAfter = map[int][]int{
+		ma.P_TCP:           ipOrDNS,
+		ma.P_UDP:           ipOrDNS,
+		ma.P_QUIC:          {ma.P_UDP},
+		ma.P_QUIC_V1:       {ma.P_UDP},
+		ma.P_WEBTRANSPORT:  {ma.P_QUIC_V1},
+		ma.P_WEBRTC_DIRECT: {ma.P_UDP},
+		ma.P_CERTHASH:      {ma.P_WEBTRANSPORT, ma.P_WEBRTC_DIRECT, ma.P_CERTHASH},
+		ma.P_TLS:           {ma.P_TCP},
+		ma.P_SNI:           {ma.P_TLS},
+		ma.P_WS:            {ma.P_TCP, ma.P_TLS, ma.P_SNI},
+		ma.P_WSS:           {ma.P_TCP},
+		ma.P_CIRCUIT:       {ma.P_P2P},
+	}
+
+	// firstOnly are the protocols that must be at the start of an address.
+	firstOnly = map[int]bool{
+		ma.P_IP4:     true,
+		ma.P_IP6:     true,
+		ma.P_IP6ZONE: true,
+		ma.P_DNS:     true,
+		ma.P_DNS4:    true,
+		ma.P_DNS6:    true,
+		ma.P_DNSADDR: true,
+		ma.P_UNIX:    true,
+	}
+)
+
+// Normalize validates a and returns its canonical form.
+func Normalize(a ma.Multiaddr) (ma.Multiaddr, error) {
+	return normalize(a, true)
+}
+
+// Canonicalize returns the canonical form of a, like Normalize, without
+// validating it. Addresses that can't be canonicalized are returned unchanged.
+func Canonicalize(a ma.Multiaddr) ma.Multiaddr {
+	n, err := normalize(a, false)
+	if err != nil {
+		return a
+	}
+	return n
+}
+
+func normalize(a ma.Multiaddr, check bool) (ma.Multiaddr, error) {
+	var comps []ma.Component
+	ma.ForEach(a, func(c ma.Component) bool {
+		comps = append(comps, c)
+		return true
+	})
+	if len(comps) == 0 {
+		return nil, fmt.Errorf("%w: empty address", ErrInvalidSequence)
+	}
+
+	// Leading /ip6zone and IPv4-mapped /ip6.
+	var zone *ma.Component
+	changed := false
+	if comps[0].Protocol().Code == ma.P_IP6ZONE {
+		if len(comps) < 2 || comps[1].Protocol().Code != ma.P_IP6 {
+			return nil, ErrInvalidZone
+		}
+		zone = &comps[0]
+		comps = comps[1:]
+	}
+	if comps[0].Protocol().Code == ma.P_IP6 {
+		ip := net.IP(comps[0].RawValue())
+		if ip4 := ip.To4(); ip4 != nil {
+			c, err := ma.NewComponent("ip4", ip4.String())
+			if err != nil {
+				return nil, err
+			}
+			comps[0] = *c
+			zone = nil
+			changed = true
+		} else if zone != nil && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() {
+			zone = nil
+			changed = true
+		}
+	}
+
+	if check {
+		if err := validate(comps); err != nil {
+			return nil, err
+		}
+	}
+
+	if sortCerthashes(&comps) {
+		changed = true
+	}
+
+	if !changed {
+		return a, nil
+	}
+	parts := make([]ma.Multiaddr, 0, len(comps)+1)
+	if zone != nil {
+		parts = append(parts, zone)
+	}
+	for i := range comps {
+		parts = append(parts, &comps[i])
+	}
+	// Join returns a *Component for single-component addresses. Always
+	// return the same concrete type as the parser.
+	return ma.NewMultiaddrBytes(ma.Join(parts...).Bytes())
+}
+
+// validate checks that the protocols of an address, without its leading
+// /ip6zone, are stacked in a sensible order, and that certhashes are present
+// and valid.
+func validate(comps []ma.Component) error {
+	for i, c := range comps {
+		code := c.Protocol().Code
+		if i > 0 && firstOnly[code] {
+			return fmt.Errorf("%w: /%s is not at the start of the address", ErrInvalidSequence, c.Protocol().Name)
+		}
+		if allowed, ok := allowedAfter[code]; ok {
+			if i == 0 || !contains(allowed, comps[i-1].Protocol().Code) {
+				return fmt.Errorf("%w: unexpected /%s", ErrInvalidSequence, c.Protocol().Name)
+			}
+		}
+		switch code {
+		case ma.P_CERTHASH:
+			if err := checkCerthash(c.Value()); err != nil {
+				return err
+			}
+		case ma.P_WEBTRANSPORT, ma.P_WEBRTC_DIRECT:
+			if i+1 == len(comps) || comps[i+1].Protocol().Code != ma.P_CERTHASH {
+				return fmt.Errorf("%w: /%s", ErrMissingCerthash, c.Protocol().Name)
+			}
+		}
+	}
+	return nil
+}
+
+// sortCerthashes sorts and deduplicates each run of consecutive /certhash
+// components. It returns true if comps changed.
+func sortCerthashes(comps *[]ma.Component) bool {
+	cs := *comps
+	res := make([]ma.Component, 0, len(cs))
+	changed := false
+	for i := 0; i < len(cs); {
+		if cs[i].Protocol().Code != ma.P_CERTHASH {
+			res = append(res, cs[i])
+			i++
+			continue
+		}
+		j := i
+		for j < len(cs) && cs[j].Protocol().Code == ma.P_CERTHASH {
+			j++
+		}
+		run := cs[i:j]
+		if !sort.SliceIsSorted(run, func(x, y int) bool { return bytes.Compare(run[x].RawValue(), run[y].RawValue()) < 0 }) {
+			run = append([]ma.Component(nil), run...)
+			sort.Slice(run, func(x, y int) bool { return bytes.Compare(run[x].RawValue(), run[y].RawValue()) < 0 })
+			changed = true
+		}
+		for k, c := range run {
+			if k > 0 && bytes.Equal(c.RawValue(), run[k-1].RawValue()) {
+				changed = true
+				continue
+			}
+			res = append(res, c)
+		}
+		i = j
+	}
+	if changed {
+		*comps = res
+	}
+	return changed
+}
+
+func checkCerthash(s string) error {
+	_, b, err := multibase.Decode(s)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidCerthash, err)
+	}
+	if _, err := multihash.Decode(b); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidCerthash, err)
+	}
+	return nil
+}
+
+func contains(codes []int, code int) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter returns the normalized form of the valid addresses in addrs. Invalid
+// addresses are dropped and counted as rejected at boundary b.
+func Filter(b Boundary, addrs []ma.Multiaddr) []ma.Multiaddr {
+	res := make([]ma.Multiaddr, 0, len(addrs))
+	for _, a := range addrs {
+		if a == nil {
+			continue
+		}
+		n, err := Normalize(a)
+		if err != nil {
+			Reject(b, a, err)
+			continue
+		}
+		res = append(res, n)
+	}
+	return res
+}
+
+// Reject counts a as rejected at boundary b, because of err.
+func Reject(b Boundary, a ma.Multiaddr, err error) {
+	log.Debugw("rejected address", "boundary", b, "addr", a, "error", err)
+	rejectedAddrsTotal.WithLabelValues(string(b), reason(err)).Inc()
+}
+
+func reason(err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidSequence):
+		return "invalid_sequence"
+	case errors.Is(err, ErrMissingCerthash):
+		return "missing_certhash"
+	case errors.Is(err, ErrInvalidCerthash):
+		return "invalid_certhash"
+	case errors.Is(err, ErrInvalidZone):
+		return "invalid_zone"
+	default:
+		return "other"
+	}
+}
+
+const metricNamespace = "libp2p_addrcheck"
+
+var rejectedAddrsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Name:      "rejected_addrs_total",
+		Help:      "Addresses rejected by validation",
+	},
+	[]string{"boundary", "reason"},
+)
+
+// RegisterMetrics registers the rejected address counters with reg.
+func RegisterMetrics(reg prometheus.Registerer) {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	metricshelper.RegisterCollectors(reg, rejectedAddrsTotal)
+}
@@ -0,0 +1,86 @@
+package addrcheck
+
+import (
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	certhash  = "uEiDDq4_xNyDorZBH3TlGazyJdOWSwvo4PUo5YHFMrvDE8g"
+	certhash2 = "uEiAkH5a4DPGKUuOBjYw0CgwjvcJCJMD2K_1aluKR_tpevQ"
+)
+
+func TestNormalize(t *testing.T) {
+	for _, tc := range []struct {
+		addr string
+		want string
+		err  error
+	}{
+		{addr: "/ip4/1.2.3.4/tcp/1234", want: "/ip4/1.2.3.4/tcp/1234"},
+		{addr: "/dns/example.com/tcp/443/tls/sni/example.com/ws", want: "/dns/example.com/tcp/443/tls/sni/example.com/ws"},
+		{addr: "/ip4/1.2.3.4/udp/1234/quic-v1/webtransport/certhash/" + certhash, want: "/ip4/1.2.3.4/udp/1234/quic-v1/webtransport/certhash/" + certhash},
+		{addr: "/ip4/1.2.3.4/udp/1234/webrtc-direct/certhash/" + certhash, want: "/ip4/1.2.3.4/udp/1234/webrtc-direct/certhash/" + certhash},
+		{addr: "/ip4/1.2.3.4/tcp/1234/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC/p2p-circuit", want: "/ip4/1.2.3.4/tcp/1234/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC/p2p-circuit"},
+		// canonicalization
+		{addr: "/ip6/::ffff:1.2.3.4/tcp/1234", want: "/ip4/1.2.3.4/tcp/1234"},
+		{addr: "/ip6zone/eth0/ip6/fe80::1/tcp/1234", want: "/ip6zone/eth0/ip6/fe80::1/tcp/1234"},
+		{addr: "/ip6zone/eth0/ip6/2001:db8::1/tcp/1234", want: "/ip6/2001:db8::1/tcp/1234"},
+		{addr: "/ip4/1.2.3.4/tcp/1234/ipfs/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC", want: "/ip4/1.2.3.4/tcp/1234/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC"},
+		{addr: "/ip4/1.2.3.4/udp/1234/quic-v1/webtransport/certhash/" + certhash2 + "/certhash/" + certhash, want: "/ip4/1.2.3.4/udp/1234/quic-v1/webtransport/certhash/" + certhash2 + "/certhash/" + certhash},
+		{addr: "/ip4/1.2.3.4/udp/1234/quic-v1/webtransport/certhash/" + certhash + "/certhash/" + certhash2, want: "/ip4/1.2.3.4/udp/1234/quic-v1/webtransport/certhash/" + certhash2 + "/certhash/" + certhash},
+		{addr: "/ip4/1.2.3.4/udp/1234/webrtc-direct/certhash/" + certhash + "/certhash/" + certhash + "/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC", want: "/ip4/1.2.3.4/udp/1234/webrtc-direct/certhash/" + certhash + "/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC"},
+		// invalid
+		{addr: "/ip4/1.2.3.4/quic-v1", err: ErrInvalidSequence},
+		{addr: "/tcp/1234", err: ErrInvalidSequence},
+		{addr: "/ip4/1.2.3.4/tcp/1234/ip4/1.2.3.4", err: ErrInvalidSequence},
+		{addr: "/ip4/1.2.3.4/udp/1234/quic/webtransport/certhash/" + certhash, err: ErrInvalidSequence},
+		{addr: "/ip4/1.2.3.4/udp/1234/quic-v1/webtransport", err: ErrMissingCerthash},
+		{addr: "/ip4/1.2.3.4/udp/1234/webrtc-direct", err: ErrMissingCerthash},
+		{addr: "/ip4/1.2.3.4/udp/1234/quic-v1/certhash/" + certhash, err: ErrInvalidSequence},
+		{addr: "/ip6zone/eth0/ip4/1.2.3.4/tcp/1234", err: ErrInvalidZone},
+	} {
+		t.Run(tc.addr, func(t *testing.T) {
+			a, err := ma.NewMultiaddr(tc.addr)
+			require.NoError(t, err)
+			n, err := Normalize(a)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, n.String())
+		})
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	for _, tc := range []struct {
+		addr string
+		want string
+	}{
+		{addr: "/ip6/::ffff:1.2.3.4/tcp/1234", want: "/ip4/1.2.3.4/tcp/1234"},
+		{addr: "/ip4/1.2.3.4/udp/1234/quic-v1/webtransport/certhash/" + certhash + "/certhash/" + certhash2, want: "/ip4/1.2.3.4/udp/1234/quic-v1/webtransport/certhash/" + certhash2 + "/certhash/" + certhash},
+		// invalid addresses are kept
+		{addr: "/ip4/1.2.3.4/udp/1234/quic-v1/webtransport", want: "/ip4/1.2.3.4/udp/1234/quic-v1/webtransport"},
+		{addr: "/ip6/::ffff:1.2.3.4/udp/1234/webrtc-direct", want: "/ip4/1.2.3.4/udp/1234/webrtc-direct"},
+		{addr: "/ip6zone/eth0/ip4/1.2.3.4/tcp/1234", want: "/ip6zone/eth0/ip4/1.2.3.4/tcp/1234"},
+	} {
+		t.Run(tc.addr, func(t *testing.T) {
+			require.Equal(t, tc.want, Canonicalize(ma.StringCast(tc.addr)).String())
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	before := testutil.ToFloat64(rejectedAddrsTotal.WithLabelValues(string(BoundaryUser), "missing_certhash"))
+	addrs := Filter(BoundaryUser, []ma.Multiaddr{
+		ma.StringCast("/ip6/::ffff:1.2.3.4/tcp/1234"),
+		ma.StringCast("/ip4/1.2.3.4/udp/1234/quic-v1/webtransport"),
+		nil,
+	})
+	require.Equal(t, []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/tcp/1234")}, addrs)
+	require.Equal(t, before+1, testutil.ToFloat64(rejectedAddrsTotal.WithLabelValues(string(BoundaryUser), "missing_certhash")))
+}
@@ -21,6 +21,11 @@ var log = logging.Logger("autonat")
 
 const maxConfidence = 3
 
+// maxStableBackoff bounds the backoff of the probe interval while the
+// reachability is stable: probes are made at most every
+// refreshInterval * 2^maxStableBackoff.
+const maxStableBackoff = 2
+
 // AmbientAutoNAT is the implementation of ambient NAT autodiscovery
 type AmbientAutoNAT struct {
 	host host.Host
@@ -40,11 +45,21 @@ type AmbientAutoNAT struct {
 	// If it is <3, then multiple autoNAT peers may be contacted for dialback
 	// If only a single autoNAT peer is known, then the confidence increases
 	// for each failure until it reaches 3.
-	confidence   int
-	lastInbound  time.Time
-	lastProbeTry time.Time
-	lastProbe    time.Time
-	recentProbes map[peer.ID]time.Time
+	confidence int
+	// stableProbes is the number of consecutive probes that confirmed the
+	// current status at maximum confidence. The probe interval backs off as it
+	// grows.
+	stableProbes      int
+	lastInbound       time.Time
+	lastProbeTry      time.Time
+	lastProbe         time.Time
+	lastNetworkChange time.Time
+	recentProbes      map[peer.ID]time.Time
+	// schedule is a snapshot of the scheduler state, updated by the background
+	// go routine.
+	schedule atomic.Pointer[ProbeSchedule]
+	// power is the power profile currently in effect.
+	power event.PowerProfile
 
 	service *autoNATService
 
@@ -52,6 +67,26 @@ type AmbientAutoNAT struct {
 	subscriber              event.Subscription
 }
 
+// ProbeSchedule describes the state of the AutoNAT probe scheduler.
+type ProbeSchedule struct {
+	// Reachability is the current reachability status.
+	Reachability network.Reachability
+	// Confidence is the confidence in Reachability, from 0 to 3. Probes are
+	// made every retry interval until the confidence is at its maximum.
+	Confidence int
+	// StableProbes is the number of consecutive probes that confirmed
+	// Reachability at maximum confidence. The probe interval doubles with every
+	// stable probe, up to 4 times the refresh interval.
+	StableProbes int
+	// LastProbe is the time of the last completed probe.
+	LastProbe time.Time
+	// NextProbe is the time the next probe is scheduled for.
+	NextProbe time.Time
+	// LastNetworkChange is the time the last change of the local interface
+	// addresses was observed.
+	LastNetworkChange time.Time
+}
+
 // StaticAutoNAT is a simple AutoNAT implementation when a single NAT status is desired.
 type StaticAutoNAT struct {
 	host         host.Host
@@ -115,9 +150,10 @@ func New(h host.Host, options ...Option) (AutoNAT, error) {
 	}
 	reachability := network.ReachabilityUnknown
 	as.status.Store(&reachability)
+	as.schedule.Store(&ProbeSchedule{Reachability: reachability, NextProbe: time.Now().Add(conf.bootDelay)})
 
 	subscriber, err := as.host.EventBus().Subscribe(
-		[]any{new(event.EvtLocalAddressesUpdated), new(event.EvtPeerIdentificationCompleted)},
+		[]any{new(event.EvtLocalAddressesUpdated), new(event.EvtPeerIdentificationCompleted), new(event.EvtLocalInterfaceAddrsChanged), new(event.EvtLocalPowerStateChanged)},
 		eventbus.Name("autonat"),
 	)
 	if err != nil {
@@ -137,6 +173,11 @@ func (as *AmbientAutoNAT) Status() network.Reachability {
 	return *s
 }
 
+// ProbeSchedule returns the current state of the probe scheduler.
+func (as *AmbientAutoNAT) ProbeSchedule() ProbeSchedule {
+	return *as.schedule.Load()
+}
+
 func (as *AmbientAutoNAT) emitStatus() {
 	status := *as.status.Load()
 	as.emitReachabilityChanged.Emit(event.EvtLocalReachabilityChanged{Reachability: status})
@@ -187,6 +228,18 @@ func (as *AmbientAutoNAT) background() {
 				if as.confidence == maxConfidence {
 					as.confidence--
 				}
+				as.stableProbes = 0
+			case event.EvtLocalInterfaceAddrsChanged:
+				// The network we're connected to might have changed. Our previous
+				// observations are stale, so drop our confidence and probe again soon.
+				// Peers probed recently may be probed again right away.
+				as.confidence = 0
+				as.stableProbes = 0
+				as.lastProbe = time.Time{}
+				as.lastNetworkChange = time.Now()
+				clear(as.recentProbes)
+			case event.EvtLocalPowerStateChanged:
+				as.power = e.Profile
 			case event.EvtPeerIdentificationCompleted:
 				if s, err := as.host.Peerstore().SupportsProtocols(e.Peer, AutoNATProto); err == nil && len(s) > 0 {
 					currentStatus := *as.status.Load()
@@ -241,6 +294,8 @@ func (as *AmbientAutoNAT) scheduleProbe(retryProbe bool) time.Duration {
 	// This is modulated by:
 	// * if we are in an unknown state, have low confidence, or we want to retry because a probe was refused that
 	//   should drop to 'AutoNATRetryInterval'
+	// * consecutive probes confirming our status at maximum confidence back off the interval exponentially,
+	//   up to 2^maxStableBackoff times 'AutoNATRefreshInterval'
 	// * recent inbound connections (implying continued connectivity) should decrease the retry when public
 	// * recent inbound connections when not public mean we should try more actively to see if we're public.
 	fixedNow := time.Now()
@@ -255,13 +310,22 @@ func (as *AmbientAutoNAT) scheduleProbe(retryProbe bool) time.Duration {
 		}
 	}
 	if !as.lastProbe.IsZero() {
-		untilNext := as.config.refreshInterval
+		refreshInterval := as.config.refreshInterval
+		if as.power.AutoNATRefreshInterval > 0 {
+			refreshInterval = as.power.AutoNATRefreshInterval
+		}
+		retryInterval := as.config.retryInterval
+		if as.power.AutoNATRetryInterval > 0 {
+			retryInterval = as.power.AutoNATRetryInterval
+		}
+
+		untilNext := refreshInterval << min(as.stableProbes, maxStableBackoff)
 		if retryProbe {
-			untilNext = as.config.retryInterval
+			untilNext = retryInterval
 		} else if currentStatus == network.ReachabilityUnknown {
-			untilNext = as.config.retryInterval
+			untilNext = retryInterval
 		} else if as.confidence < maxConfidence {
-			untilNext = as.config.retryInterval
+			untilNext = retryInterval
 		} else if currentStatus == network.ReachabilityPublic && as.lastInbound.After(as.lastProbe) {
 			untilNext *= 2
 		} else if currentStatus != network.ReachabilityPublic && as.lastInbound.After(as.lastProbe) {
@@ -272,6 +336,14 @@ func (as *AmbientAutoNAT) scheduleProbe(retryProbe bool) time.Duration {
 			nextProbe = as.lastProbe.Add(untilNext)
 		}
 	}
+	as.schedule.Store(&ProbeSchedule{
+		Reachability:      currentStatus,
+		Confidence:        as.confidence,
+		StableProbes:      as.stableProbes,
+		LastProbe:         as.lastProbe,
+		NextProbe:         nextProbe,
+		LastNetworkChange: as.lastNetworkChange,
+	})
 	if as.metricsTracer != nil {
 		as.metricsTracer.NextProbeTime(nextProbe)
 	}
@@ -297,6 +369,7 @@ func (as *AmbientAutoNAT) handleDialResponse(dialErr error) {
 func (as *AmbientAutoNAT) recordObservation(observation network.Reachability) {
 
 	currentStatus := *as.status.Load()
+	prevConfidence := as.confidence
 
 	if observation == network.ReachabilityPublic {
 		changed := false
@@ -349,6 +422,11 @@ func (as *AmbientAutoNAT) recordObservation(observation network.Reachability) {
 			as.emitStatus()
 		}
 	}
+	if prevConfidence == maxConfidence && as.confidence == maxConfidence && observation == currentStatus {
+		as.stableProbes++
+	} else {
+		as.stableProbes = 0
+	}
 	if as.metricsTracer != nil {
 		as.metricsTracer.ReachabilityStatusConfidence(as.confidence)
 	}
@@ -379,7 +457,13 @@ func (as *AmbientAutoNAT) tryProbe(p peer.ID) bool {
 }
 
 func (as *AmbientAutoNAT) probe(pi *peer.AddrInfo) {
-	cli := NewAutoNATClient(as.host, as.config.addressFunc, as.metricsTracer)
+	cli := &client{
+		h:                   as.host,
+		addrFunc:            as.config.addressFunc,
+		mt:                  as.metricsTracer,
+		serviceName:         as.config.serviceName,
+		reservationPriority: as.config.reservationPriority,
+	}
 	ctx, cancel := context.WithTimeout(as.ctx, as.config.requestTimeout)
 	defer cancel()
 
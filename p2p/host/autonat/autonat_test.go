@@ -311,6 +311,79 @@ func TestAutoNATObservationRecording(t *testing.T) {
 	}
 }
 
+func TestAutoNATScheduleBackoff(t *testing.T) {
+	an := &AmbientAutoNAT{
+		config:       &config{retryInterval: time.Minute, refreshInterval: time.Hour},
+		confidence:   maxConfidence,
+		lastProbe:    time.Now(),
+		recentProbes: make(map[peer.ID]time.Time),
+	}
+	public := network.ReachabilityPublic
+	an.status.Store(&public)
+
+	untilNext := func() time.Duration {
+		return an.lastProbe.Add(an.scheduleProbe(false)).Sub(time.Now()).Round(time.Minute)
+	}
+	require.Equal(t, time.Hour, untilNext())
+
+	// stable probes back off the interval, up to 2^maxStableBackoff times the refresh interval
+	an.recordObservation(network.ReachabilityPublic)
+	require.Equal(t, 2*time.Hour, untilNext())
+	an.recordObservation(network.ReachabilityPublic)
+	an.recordObservation(network.ReachabilityPublic)
+	require.Equal(t, 4*time.Hour, untilNext())
+	require.Equal(t, 3, an.ProbeSchedule().StableProbes)
+
+	// a contradicting observation reduces confidence, and we probe again soon
+	an.recordObservation(network.ReachabilityPrivate)
+	require.Equal(t, time.Minute, untilNext())
+	sched := an.ProbeSchedule()
+	require.Equal(t, network.ReachabilityPublic, sched.Reachability)
+	require.Equal(t, maxConfidence-1, sched.Confidence)
+	require.Zero(t, sched.StableProbes)
+}
+
+func TestAutoNATScheduleNetworkChange(t *testing.T) {
+	hs := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	defer hs.Close()
+	hs.SetStreamHandler(AutoNATProto, func(s network.Stream) {
+		defer s.Close()
+		// probes may be canceled when closing the client
+		if err := pbio.NewDelimitedReader(s, network.MessageSizeMax).ReadMsg(&pb.Message{}); err != nil {
+			return
+		}
+		pbio.NewDelimitedWriter(s).WriteMsg(&pb.Message{
+			Type:         pb.Message_DIAL_RESPONSE.Enum(),
+			DialResponse: newDialResponseOK(s.Conn().RemoteMultiaddr()),
+		})
+	})
+	hc := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	defer hc.Close()
+	identifyAsServer(hs, hc)
+	ani, err := New(hc, WithSchedule(100*time.Millisecond, time.Second), WithoutStartupDelay())
+	require.NoError(t, err)
+	defer ani.Close()
+	an := ani.(*AmbientAutoNAT)
+	an.config.dialPolicy.allowSelfDials = true
+	an.config.throttlePeerPeriod = 100 * time.Millisecond
+	connect(t, hs, hc)
+
+	require.Eventually(t, func() bool {
+		return an.ProbeSchedule().Reachability == network.ReachabilityPublic
+	}, 3*time.Second, 50*time.Millisecond)
+
+	em, err := hc.EventBus().Emitter(new(event.EvtLocalInterfaceAddrsChanged))
+	require.NoError(t, err)
+	defer em.Close()
+	require.NoError(t, em.Emit(event.EvtLocalInterfaceAddrsChanged{}))
+
+	// the network change is observed, and we probe again right away
+	require.Eventually(t, func() bool {
+		sched := an.ProbeSchedule()
+		return !sched.LastNetworkChange.IsZero() && sched.LastProbe.After(sched.LastNetworkChange)
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
 func TestStaticNat(t *testing.T) {
 	_, cancel := context.WithCancel(context.Background())
 	defer cancel()
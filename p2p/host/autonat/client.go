@@ -19,13 +19,16 @@ func NewAutoNATClient(h host.Host, addrFunc AddrFunc, mt MetricsTracer) Client {
 	if addrFunc == nil {
 		addrFunc = h.Addrs
 	}
-	return &client{h: h, addrFunc: addrFunc, mt: mt}
+	return &client{h: h, addrFunc: addrFunc, mt: mt, serviceName: ServiceName, reservationPriority: network.ReservationPriorityAlways}
 }
 
 type client struct {
 	h        host.Host
 	addrFunc AddrFunc
 	mt       MetricsTracer
+
+	serviceName         string
+	reservationPriority uint8
 }
 
 // DialBack asks peer p to dial us back on all addresses returned by the addrFunc.
@@ -40,13 +43,13 @@ func (c *client) DialBack(ctx context.Context, p peer.ID) error {
 		return err
 	}
 
-	if err := s.Scope().SetService(ServiceName); err != nil {
+	if err := s.Scope().SetService(c.serviceName); err != nil {
 		log.Debugf("error attaching stream to autonat service: %s", err)
 		s.Reset()
 		return err
 	}
 
-	if err := s.Scope().ReserveMemory(maxMsgSize, network.ReservationPriorityAlways); err != nil {
+	if err := s.Scope().ReserveMemory(maxMsgSize, c.reservationPriority); err != nil {
 		log.Debugf("error reserving memory for autonat stream: %s", err)
 		s.Reset()
 		return err
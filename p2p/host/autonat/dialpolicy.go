@@ -4,6 +4,7 @@ import (
 	"net"
 
 	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/transport"
 
 	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr/net"
@@ -25,6 +26,13 @@ func (d *dialPolicy) skipDial(addr ma.Multiaddr) bool {
 		return true
 	}
 
+	// skip addresses that are missing the certhash required by their transport
+	if d.host != nil {
+		if r, ok := d.host.Network().(transport.CapabilityResolver); ok && transport.NeedsCerthash(r, addr) {
+			return true
+		}
+	}
+
 	if d.allowSelfDials {
 		return false
 	}
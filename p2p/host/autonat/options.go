@@ -19,6 +19,12 @@ type config struct {
 	reachability      network.Reachability
 	metricsTracer     MetricsTracer
 
+	// serviceName is the resource manager service scope of AutoNAT streams.
+	serviceName string
+	// reservationPriority is used for the memory reservations of AutoNAT
+	// streams.
+	reservationPriority uint8
+
 	// client
 	bootDelay          time.Duration
 	retryInterval      time.Duration
@@ -48,6 +54,8 @@ var defaults = func(c *config) error {
 	c.throttlePeerMax = 3
 	c.throttleResetPeriod = 1 * time.Minute
 	c.throttleResetJitter = 15 * time.Second
+	c.serviceName = ServiceName
+	c.reservationPriority = network.ReservationPriorityAlways
 	return nil
 }
 
@@ -151,3 +159,21 @@ func WithMetricsTracer(mt MetricsTracer) Option {
 		return nil
 	}
 }
+
+// WithServiceScope attaches AutoNAT streams to the resource manager service
+// scope named service instead of ServiceName, and uses priority for their
+// memory reservations. This allows limiting AutoNAT independently from the
+// other services. A priority of 0 means network.ReservationPriorityAlways.
+func WithServiceScope(service string, priority uint8) Option {
+	return func(c *config) error {
+		if service == "" {
+			return errors.New("service name must not be empty")
+		}
+		if priority == 0 {
+			priority = network.ReservationPriorityAlways
+		}
+		c.serviceName = service
+		c.reservationPriority = priority
+		return nil
+	}
+}
@@ -51,13 +51,13 @@ func newAutoNATService(c *config) (*autoNATService, error) {
 }
 
 func (as *autoNATService) handleStream(s network.Stream) {
-	if err := s.Scope().SetService(ServiceName); err != nil {
+	if err := s.Scope().SetService(as.config.serviceName); err != nil {
 		log.Debugf("error attaching stream to autonat service: %s", err)
 		s.Reset()
 		return
 	}
 
-	if err := s.Scope().ReserveMemory(maxMsgSize, network.ReservationPriorityAlways); err != nil {
+	if err := s.Scope().ReserveMemory(maxMsgSize, as.config.reservationPriority); err != nil {
 		log.Debugf("error reserving memory for autonat stream: %s", err)
 		s.Reset()
 		return
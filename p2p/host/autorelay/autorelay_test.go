@@ -2,6 +2,7 @@ package autorelay_test
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
 	"strings"
 	"sync/atomic"
@@ -9,6 +10,8 @@ import (
 	"time"
 
 	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -85,9 +88,9 @@ func newPrivateNodeWithStaticRelays(t *testing.T, static []peer.AddrInfo, opts .
 	return h
 }
 
-func newRelay(t *testing.T) host.Host {
+func newRelay(t *testing.T, opts ...libp2p.Option) host.Host {
 	t.Helper()
-	h, err := libp2p.New(
+	h, err := libp2p.New(append([]libp2p.Option{
 		libp2p.DisableRelay(),
 		libp2p.EnableRelayService(),
 		libp2p.ForceReachabilityPublic(),
@@ -101,7 +104,7 @@ func newRelay(t *testing.T) host.Host {
 			}
 			return addrs
 		}),
-	)
+	}, opts...)...)
 	require.NoError(t, err)
 	require.Eventually(t, func() bool {
 		for _, p := range h.Mux().Protocols() {
@@ -444,6 +447,10 @@ func TestReconnectToStaticRelays(t *testing.T) {
 		return numRelays(h) == 1
 	}, 10*time.Second, 100*time.Millisecond)
 
+	sub, err := h.EventBus().Subscribe(new(event.EvtRelayReservationLost))
+	require.NoError(t, err)
+	defer sub.Close()
+
 	relaysInUse := usedRelays(h)
 	oldRelay := relaysInUse[0]
 	for _, r := range relays {
@@ -451,14 +458,66 @@ func TestReconnectToStaticRelays(t *testing.T) {
 			r.Network().ClosePeer(h.ID())
 		}
 	}
-	require.Eventually(t, func() bool {
-		return numRelays(h) == 0
-	}, 10*time.Second, 100*time.Millisecond)
+	select {
+	case ev := <-sub.Out():
+		require.Equal(t, oldRelay, ev.(event.EvtRelayReservationLost).Relay)
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the reservation to be lost")
+	}
 
-	cl.AdvanceBy(time.Hour)
+	// the relay is still up, so we get a new reservation without waiting for the backoff
 	require.Eventually(t, func() bool {
 		return numRelays(h) == 1
 	}, 10*time.Second, 100*time.Millisecond)
+	require.Equal(t, []peer.ID{oldRelay}, usedRelays(h))
+}
+
+func TestRejoinRelayAfterRestart(t *testing.T) {
+	priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	r := newRelay(t, libp2p.Identity(priv))
+	t.Cleanup(func() { r.Close() })
+
+	h := newPrivateNodeWithStaticRelays(t,
+		[]peer.AddrInfo{{ID: r.ID(), Addrs: r.Addrs()}},
+		autorelay.WithBootDelay(0),
+	)
+	defer h.Close()
+
+	sub, err := h.EventBus().Subscribe([]interface{}{new(event.EvtRelayReservationLost), new(event.EvtRelayReservationObtained)})
+	require.NoError(t, err)
+	defer sub.Close()
+
+	require.Eventually(t, func() bool { return numRelays(h) == 1 }, 10*time.Second, 100*time.Millisecond)
+
+	// restart the relay on the same addresses
+	listenAddrs := r.Network().ListenAddresses()
+	require.NoError(t, r.Close())
+	restarted := newRelay(t, libp2p.Identity(priv), libp2p.ListenAddrs(listenAddrs...))
+	t.Cleanup(func() { restarted.Close() })
+
+	var lost bool
+	timeout := time.After(15 * time.Second)
+	for {
+		select {
+		case ev := <-sub.Out():
+			switch e := ev.(type) {
+			case event.EvtRelayReservationLost:
+				require.Equal(t, r.ID(), e.Relay)
+				lost = true
+			case event.EvtRelayReservationObtained:
+				if !lost {
+					continue // the initial reservation
+				}
+				require.Equal(t, r.ID(), e.Relay)
+				require.True(t, e.Rejoined)
+				require.Eventually(t, func() bool { return numRelays(h) == 1 }, 5*time.Second, 100*time.Millisecond)
+				return
+			}
+		case <-timeout:
+			t.Fatal("expected a new reservation with the restarted relay")
+		}
+	}
 }
 
 func TestMinInterval(t *testing.T) {
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"slices"
 	"sync"
 	"time"
 
@@ -13,6 +14,8 @@ import (
 	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/transport"
 	basic "github.com/libp2p/go-libp2p/p2p/host/basic"
 	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
 	circuitv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
@@ -28,13 +31,20 @@ const protoIDv2 = circuitv2_proto.ProtoIDv2Hop
 // Candidate: Once we connect to a node and it supports relay protocol,
 // we call it a candidate, and consider using it as a relay.
 // Relay: Out of the list of candidates, we select a relay to connect to.
-// Currently, we just randomly select a candidate, but we can employ more sophisticated
-// selection strategies here (e.g. by facotring in the RTT).
+// Candidates with a lower latency are preferred, candidates without latency
+// measurements are selected randomly.
 
 const (
 	rsvpRefreshInterval = time.Minute
 	rsvpExpirationSlack = 2 * time.Minute
 
+	// When a reservation is lost, e.g. because the relay restarted, we try to
+	// obtain a new reservation with the relay right away, and then retry with
+	// an exponential backoff starting at rejoinInitialBackoff, up to
+	// maxRejoinAttempts times.
+	rejoinInitialBackoff = time.Second
+	maxRejoinAttempts    = 6
+
 	autorelayTag = "autorelay"
 )
 
@@ -44,6 +54,13 @@ type candidate struct {
 	ai              peer.AddrInfo
 }
 
+// lostRelay is a relay we lost our reservation with.
+type lostRelay struct {
+	ai       peer.AddrInfo
+	attempts int
+	next     time.Time // time of the next attempt to obtain a reservation
+}
+
 // relayFinder is a Host that uses relays for connectivity when a NAT is detected.
 type relayFinder struct {
 	bootTime time.Time
@@ -62,6 +79,7 @@ type relayFinder struct {
 	candidateMx                sync.Mutex
 	candidates                 map[peer.ID]*candidate
 	backoff                    map[peer.ID]time.Time
+	lostRelays                 map[peer.ID]*lostRelay
 	maybeConnectToRelayTrigger chan struct{} // cap: 1
 	// Any time _something_ happens that might cause us to need new candidates.
 	// This could be
@@ -78,9 +96,18 @@ type relayFinder struct {
 	cachedAddrs       []ma.Multiaddr
 	cachedAddrsExpiry time.Time
 
+	// rsvpRefreshMargin is how long before expiry a reservation is refreshed.
+	// It is only accessed from the background go routine.
+	rsvpRefreshMargin time.Duration
+
 	// A channel that triggers a run of `runScheduledWork`.
 	triggerRunScheduledWork chan struct{}
 	metricsTracer           MetricsTracer
+
+	emitters struct {
+		evtReservationLost     event.Emitter
+		evtReservationObtained event.Emitter
+	}
 }
 
 var errAlreadyRunning = errors.New("relayFinder already running")
@@ -97,12 +124,14 @@ func newRelayFinder(host *basic.BasicHost, peerSource PeerSource, conf *config)
 		peerSource:                 peerSource,
 		candidates:                 make(map[peer.ID]*candidate),
 		backoff:                    make(map[peer.ID]time.Time),
+		lostRelays:                 make(map[peer.ID]*lostRelay),
 		candidateFound:             make(chan struct{}, 1),
 		maybeConnectToRelayTrigger: make(chan struct{}, 1),
 		maybeRequestNewCandidates:  make(chan struct{}, 1),
 		triggerRunScheduledWork:    make(chan struct{}, 1),
 		relays:                     make(map[peer.ID]*circuitv2.Reservation),
 		relayUpdated:               make(chan struct{}, 1),
+		rsvpRefreshMargin:          rsvpExpirationSlack,
 		metricsTracer:              &wrappedMetricsTracer{conf.metricsTracer},
 	}
 }
@@ -136,6 +165,13 @@ func (rf *relayFinder) background(ctx context.Context) {
 	}
 	defer subConnectedness.Close()
 
+	subPowerState, err := rf.host.EventBus().Subscribe(new(event.EvtLocalPowerStateChanged), eventbus.Name("autorelay (relay finder)"))
+	if err != nil {
+		log.Error("failed to subscribe to the EvtLocalPowerStateChanged")
+		return
+	}
+	defer subPowerState.Close()
+
 	now := rf.conf.clock.Now()
 	bootDelayTimer := rf.conf.clock.InstantTimer(now.Add(rf.conf.bootDelay))
 	defer bootDelayTimer.Stop()
@@ -180,7 +216,6 @@ func (rf *relayFinder) background(ctx context.Context) {
 			if rf.usingRelay(evt.Peer) { // we were disconnected from a relay
 				log.Debugw("disconnected from relay", "id", evt.Peer)
 				delete(rf.relays, evt.Peer)
-				rf.notifyMaybeConnectToRelay()
 				rf.notifyMaybeNeedNewCandidates()
 				push = true
 			}
@@ -189,6 +224,18 @@ func (rf *relayFinder) background(ctx context.Context) {
 			if push {
 				rf.clearCachedAddrsAndSignalAddressChange()
 				rf.metricsTracer.ReservationEnded(1)
+				rf.relayLost(evt.Peer)
+			}
+		case ev, ok := <-subPowerState.Out():
+			if !ok {
+				return
+			}
+			evt := ev.(event.EvtLocalPowerStateChanged)
+			rf.rsvpRefreshMargin = rsvpExpirationSlack
+			if evt.Profile.RelayReservationRefreshMargin > 0 {
+				// Reservations are only checked every rsvpRefreshInterval. A smaller margin
+				// would allow reservations to expire before we get a chance to refresh them.
+				rf.rsvpRefreshMargin = max(evt.Profile.RelayReservationRefreshMargin, rsvpRefreshInterval)
 			}
 		case <-rf.candidateFound:
 			rf.notifyMaybeConnectToRelay()
@@ -203,8 +250,7 @@ func (rf *relayFinder) background(ctx context.Context) {
 			nextTime := rf.runScheduledWork(ctx, now, scheduledWork, peerSourceRateLimiter)
 			workTimer.Reset(nextTime)
 		case <-rf.triggerRunScheduledWork:
-			// Ignore the next time because we aren't scheduling any future work here
-			_ = rf.runScheduledWork(ctx, rf.conf.clock.Now(), scheduledWork, peerSourceRateLimiter)
+			workTimer.Reset(rf.runScheduledWork(ctx, rf.conf.clock.Now(), scheduledWork, peerSourceRateLimiter))
 		case <-ctx.Done():
 			return
 		}
@@ -238,6 +284,14 @@ func (rf *relayFinder) runScheduledWork(ctx context.Context, now time.Time, sche
 		scheduledWork.nextOldCandidateCheck = rf.clearOldCandidates(now)
 	}
 
+	if nextRejoin, ok := rf.nextRejoin(); ok {
+		if !nextRejoin.After(now) {
+			rf.notifyMaybeConnectToRelay()
+		} else if nextRejoin.Before(nextTime) {
+			nextTime = nextRejoin
+		}
+	}
+
 	if now.After(scheduledWork.nextAllowedCallToPeerSource) {
 		select {
 		case peerSourceRateLimiter <- struct{}{}:
@@ -518,6 +572,8 @@ func (rf *relayFinder) handleNewCandidates(ctx context.Context) {
 }
 
 func (rf *relayFinder) maybeConnectToRelay(ctx context.Context) {
+	rf.rejoinLostRelays(ctx)
+
 	rf.relayMx.Lock()
 	numRelays := len(rf.relays)
 	rf.relayMx.Unlock()
@@ -563,24 +619,138 @@ func (rf *relayFinder) maybeConnectToRelay(ctx context.Context) {
 			continue
 		}
 		log.Debugw("adding new relay", "id", id)
-		rf.relayMx.Lock()
-		rf.relays[id] = rsvp
-		numRelays := len(rf.relays)
-		rf.relayMx.Unlock()
-		rf.notifyMaybeNeedNewCandidates()
+		numRelays := rf.addRelay(id, rsvp, false)
+		rf.metricsTracer.ReservationRequestFinished(false, nil)
+
+		if numRelays >= rf.conf.desiredRelays {
+			break
+		}
+	}
+}
+
+// addRelay starts using a relay we obtained a reservation with. It returns the
+// number of relays in use.
+func (rf *relayFinder) addRelay(id peer.ID, rsvp *circuitv2.Reservation, rejoined bool) int {
+	rf.relayMx.Lock()
+	rf.relays[id] = rsvp
+	numRelays := len(rf.relays)
+	rf.relayMx.Unlock()
+	rf.notifyMaybeNeedNewCandidates()
 
-		rf.host.ConnManager().Protect(id, autorelayTag) // protect the connection
+	rf.host.ConnManager().Protect(id, autorelayTag) // protect the connection
 
-		select {
-		case rf.relayUpdated <- struct{}{}:
-		default:
+	select {
+	case rf.relayUpdated <- struct{}{}:
+	default:
+	}
+
+	if rf.emitters.evtReservationObtained != nil {
+		rf.emitters.evtReservationObtained.Emit(event.EvtRelayReservationObtained{
+			Relay:      id,
+			Expiration: rsvp.Expiration,
+			Rejoined:   rejoined,
+		})
+	}
+	return numRelays
+}
+
+// relayLost is called when we lost the reservation with a relay, and schedules
+// an immediate attempt to obtain a new reservation with it.
+func (rf *relayFinder) relayLost(p peer.ID) {
+	rf.host.ConnManager().Unprotect(p, autorelayTag)
+
+	rf.candidateMx.Lock()
+	if _, ok := rf.lostRelays[p]; !ok {
+		rf.lostRelays[p] = &lostRelay{
+			ai:   peer.AddrInfo{ID: p, Addrs: rf.host.Peerstore().Addrs(p)},
+			next: rf.conf.clock.Now(),
 		}
+	}
+	rf.candidateMx.Unlock()
 
-		rf.metricsTracer.ReservationRequestFinished(false, nil)
+	if rf.emitters.evtReservationLost != nil {
+		rf.emitters.evtReservationLost.Emit(event.EvtRelayReservationLost{Relay: p})
+	}
+	rf.notifyMaybeConnectToRelay()
+}
+
+// nextRejoin returns the time of the next attempt to obtain a reservation with
+// a lost relay.
+func (rf *relayFinder) nextRejoin() (time.Time, bool) {
+	rf.candidateMx.Lock()
+	defer rf.candidateMx.Unlock()
+
+	var next time.Time
+	for _, l := range rf.lostRelays {
+		if next.IsZero() || l.next.Before(next) {
+			next = l.next
+		}
+	}
+	return next, !next.IsZero()
+}
 
+// rejoinLostRelays tries to obtain new reservations with the lost relays that
+// are due for another attempt. A relay that restarted usually accepts a new
+// reservation after a few seconds, which is a lot faster than finding and
+// checking new candidates.
+func (rf *relayFinder) rejoinLostRelays(ctx context.Context) {
+	now := rf.conf.clock.Now()
+	rf.candidateMx.Lock()
+	var due []*lostRelay
+	for _, l := range rf.lostRelays {
+		if !l.next.After(now) {
+			due = append(due, l)
+		}
+	}
+	rf.candidateMx.Unlock()
+	if len(due) == 0 {
+		return
+	}
+
+	for _, l := range due {
+		id := l.ai.ID
+		rf.relayMx.Lock()
+		numRelays := len(rf.relays)
+		rf.relayMx.Unlock()
+		// We found other relays in the meantime.
 		if numRelays >= rf.conf.desiredRelays {
-			break
+			rf.candidateMx.Lock()
+			clear(rf.lostRelays)
+			rf.candidateMx.Unlock()
+			return
 		}
+
+		// We back off ourselves, so don't let the dial backoff of the swarm delay
+		// reconnecting to a relay that comes back up.
+		dctx := network.WithForceDirectDial(ctx, "autorelay: rejoin relay")
+		rsvp, err := rf.connectToRelay(dctx, &candidate{ai: l.ai, supportsRelayV2: true})
+		rf.metricsTracer.ReservationRequestFinished(false, err)
+		rf.candidateMx.Lock()
+		if err == nil || l.attempts+1 >= maxRejoinAttempts {
+			delete(rf.lostRelays, id)
+			if err != nil {
+				// give up, and don't consider the relay as a candidate until the backoff expires
+				rf.backoff[id] = rf.conf.clock.Now()
+			}
+		} else {
+			l.next = rf.conf.clock.Now().Add(rejoinInitialBackoff << l.attempts)
+			l.attempts++
+		}
+		rf.candidateMx.Unlock()
+
+		if err != nil {
+			log.Debugw("failed to obtain a new reservation with relay", "peer", id, "attempt", l.attempts, "error", err)
+			rf.notifyMaybeNeedNewCandidates()
+			continue
+		}
+		log.Debugw("obtained a new reservation with relay", "id", id)
+		rf.addRelay(id, rsvp, true)
+	}
+
+	// schedule the next attempts
+	select {
+	case rf.triggerRunScheduledWork <- struct{}{}:
+	default:
 	}
 }
 
@@ -624,7 +794,7 @@ func (rf *relayFinder) refreshReservations(ctx context.Context, now time.Time) b
 	// find reservations about to expire and refresh them in parallel
 	g := new(errgroup.Group)
 	for p, rsvp := range rf.relays {
-		if now.Add(rsvpExpirationSlack).Before(rsvp.Expiration) {
+		if now.Add(rf.rsvpRefreshMargin).Before(rsvp.Expiration) {
 			continue
 		}
 
@@ -655,6 +825,7 @@ func (rf *relayFinder) refreshRelayReservation(ctx context.Context, p peer.ID) e
 		rf.relayMx.Unlock()
 		if exists {
 			rf.metricsTracer.ReservationEnded(1)
+			rf.relayLost(p)
 		}
 		return err
 	}
@@ -699,14 +870,53 @@ func (rf *relayFinder) selectCandidates() []*candidate {
 		}
 	}
 
-	// TODO: better relay selection strategy; this just selects random relays,
-	// but we should probably use ping latency as the selection metric
 	rand.Shuffle(len(candidates), func(i, j int) {
 		candidates[i], candidates[j] = candidates[j], candidates[i]
 	})
+	// Prefer the candidates with the lowest latency. Candidates without
+	// latency measurements are tried after them, in random order.
+	lb, ok := peerstore.GetTransportLatencyBook(rf.host.Peerstore())
+	if !ok {
+		return candidates
+	}
+	latencies := make(map[peer.ID]time.Duration, len(candidates))
+	for _, cand := range candidates {
+		latencies[cand.ai.ID] = directLatency(lb.LatencyStats(cand.ai.ID))
+	}
+	slices.SortStableFunc(candidates, func(a, b *candidate) int {
+		x, y := latencies[a.ai.ID], latencies[b.ai.ID]
+		switch {
+		case x == y:
+			return 0
+		case x == 0:
+			return 1
+		case y == 0:
+			return -1
+		case x < y:
+			return -1
+		default:
+			return 1
+		}
+	})
 	return candidates
 }
 
+// directLatency returns the lowest median latency of a peer over a direct
+// connection, or 0 if unknown. Latencies measured over a relayed connection
+// include the latency to the relay.
+func directLatency(stats map[string]peerstore.LatencyStats) time.Duration {
+	var lat time.Duration
+	for t, s := range stats {
+		if t == ma.ProtocolWithCode(ma.P_CIRCUIT).Name || s.Samples == 0 {
+			continue
+		}
+		if lat == 0 || s.P50 < lat {
+			lat = s.P50
+		}
+	}
+	return lat
+}
+
 // This function is computes the NATed relay addrs when our status is private:
 //   - The public addrs are removed from the address set.
 //   - The non-public addrs are included verbatim so that peers behind the same NAT/firewall
@@ -731,15 +941,26 @@ func (rf *relayFinder) relayAddrs(addrs []ma.Multiaddr) []ma.Multiaddr {
 		}
 	}
 
+	// If we accept private-to-private WebRTC connections, peers can dial our
+	// relay addrs with /webrtc appended.
+	webrtc := rf.listensOnWebRTC()
+
 	// add relay specific addrs to the list
 	relayAddrCnt := 0
 	for p := range rf.relays {
 		addrs := cleanupAddressSet(rf.host.Peerstore().Addrs(p))
+		if r, ok := rf.host.Network().(transport.CapabilityResolver); ok {
+			// Addresses without the certhash required by their transport can't be dialed.
+			addrs = ma.FilterAddrs(addrs, func(a ma.Multiaddr) bool { return !transport.NeedsCerthash(r, a) })
+		}
 		relayAddrCnt += len(addrs)
 		circuit := ma.StringCast(fmt.Sprintf("/p2p/%s/p2p-circuit", p))
 		for _, addr := range addrs {
 			pub := addr.Encapsulate(circuit)
 			raddrs = append(raddrs, pub)
+			if webrtc {
+				raddrs = append(raddrs, pub.Encapsulate(webrtcComponent))
+			}
 		}
 	}
 
@@ -750,6 +971,19 @@ func (rf *relayFinder) relayAddrs(addrs []ma.Multiaddr) []ma.Multiaddr {
 	return raddrs
 }
 
+var webrtcComponent = ma.StringCast("/webrtc")
+
+// listensOnWebRTC returns true if the host listens for private-to-private
+// WebRTC connections.
+func (rf *relayFinder) listensOnWebRTC() bool {
+	for _, a := range rf.host.Network().ListenAddresses() {
+		if a.Equal(webrtcComponent) {
+			return true
+		}
+	}
+	return false
+}
+
 func (rf *relayFinder) Start() error {
 	rf.ctxCancelMx.Lock()
 	defer rf.ctxCancelMx.Unlock()
@@ -760,6 +994,16 @@ func (rf *relayFinder) Start() error {
 
 	rf.initMetrics()
 
+	var err error
+	eb := rf.host.EventBus()
+	if rf.emitters.evtReservationLost, err = eb.Emitter(new(event.EvtRelayReservationLost)); err != nil {
+		return err
+	}
+	if rf.emitters.evtReservationObtained, err = eb.Emitter(new(event.EvtRelayReservationObtained)); err != nil {
+		rf.emitters.evtReservationLost.Close()
+		return err
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	rf.ctxCancel = cancel
 	rf.refCount.Add(1)
@@ -779,6 +1023,12 @@ func (rf *relayFinder) Stop() error {
 	}
 	rf.refCount.Wait()
 	rf.ctxCancel = nil
+	if rf.emitters.evtReservationLost != nil {
+		rf.emitters.evtReservationLost.Close()
+		rf.emitters.evtReservationObtained.Close()
+		rf.emitters.evtReservationLost = nil
+		rf.emitters.evtReservationObtained = nil
+	}
 
 	rf.resetMetrics()
 	return nil
@@ -0,0 +1,249 @@
+package autorelay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/discovery"
+	"github.com/libp2p/go-libp2p/core/peer"
+	basic "github.com/libp2p/go-libp2p/p2p/host/basic"
+	circuitv2_proto "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/proto"
+)
+
+// RelayNamespace is the discovery namespace that relays advertise themselves
+// under (see relaysvc.NewAdvertiser), and that the peer source returned by
+// NewRoutingPeerSource queries.
+const RelayNamespace = circuitv2_proto.RelayNamespace
+
+type routingSourceConfig struct {
+	probeTimeout time.Duration
+	probeTTL     time.Duration
+	maxProbes    int
+	queryLimit   int
+}
+
+// RoutingSourceOption configures the peer source returned by
+// NewRoutingPeerSource.
+type RoutingSourceOption func(*routingSourceConfig) error
+
+// WithProbeTimeout sets the timeout for connecting to a candidate and
+// learning the protocols it supports.
+func WithProbeTimeout(d time.Duration) RoutingSourceOption {
+	return func(c *routingSourceConfig) error {
+		if d <= 0 {
+			return errors.New("probe timeout must be positive")
+		}
+		c.probeTimeout = d
+		return nil
+	}
+}
+
+// WithProbeTTL sets how long the result of probing a candidate is reused.
+// Candidates that failed a probe less than the TTL ago aren't returned.
+func WithProbeTTL(d time.Duration) RoutingSourceOption {
+	return func(c *routingSourceConfig) error {
+		if d < 0 {
+			return errors.New("probe TTL must not be negative")
+		}
+		c.probeTTL = d
+		return nil
+	}
+}
+
+// WithMaxConcurrentProbes sets the maximum number of candidates probed at the
+// same time.
+func WithMaxConcurrentProbes(n int) RoutingSourceOption {
+	return func(c *routingSourceConfig) error {
+		if n <= 0 {
+			return errors.New("must allow at least one probe")
+		}
+		c.maxProbes = n
+		return nil
+	}
+}
+
+// WithQueryLimit sets the maximum number of candidates requested from the
+// discovery service per query.
+func WithQueryLimit(n int) RoutingSourceOption {
+	return func(c *routingSourceConfig) error {
+		if n <= 0 {
+			return errors.New("query limit must be positive")
+		}
+		c.queryLimit = n
+		return nil
+	}
+}
+
+type probeResult struct {
+	at time.Time
+	ok bool
+}
+
+type routingSource struct {
+	conf routingSourceConfig
+	host *basic.BasicHost
+	disc discovery.Discoverer
+
+	mx     sync.Mutex
+	probes map[peer.ID]probeResult
+}
+
+// NewRoutingPeerSource returns a PeerSource that finds relay candidates
+// advertised under RelayNamespace, e.g. by a discovery service backed by the
+// DHT (see p2p/discovery/routing).
+//
+// Candidates are only returned once they have been probed: the host connects
+// to them, and checks that they support the circuit v2 hop protocol. Probe
+// results are cached, candidates that recently failed a probe are skipped.
+// Candidates are probed as they are consumed, so that a slow consumer doesn't
+// cause the source to connect to more peers than necessary.
+func NewRoutingPeerSource(h *basic.BasicHost, d discovery.Discoverer, opts ...RoutingSourceOption) (PeerSource, error) {
+	conf := routingSourceConfig{
+		probeTimeout: 15 * time.Second,
+		probeTTL:     10 * time.Minute,
+		maxProbes:    4,
+		queryLimit:   100,
+	}
+	for _, opt := range opts {
+		if err := opt(&conf); err != nil {
+			return nil, err
+		}
+	}
+	s := &routingSource{
+		conf:   conf,
+		host:   h,
+		disc:   d,
+		probes: make(map[peer.ID]probeResult),
+	}
+	return s.findCandidates, nil
+}
+
+func (s *routingSource) findCandidates(ctx context.Context, num int) <-chan peer.AddrInfo {
+	out := make(chan peer.AddrInfo)
+	go func() {
+		defer close(out)
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		s.pruneProbes()
+		peers, err := s.disc.FindPeers(ctx, RelayNamespace, discovery.Limit(s.conf.queryLimit))
+		if err != nil {
+			log.Debugw("failed to find relay candidates", "error", err)
+			return
+		}
+
+		// Senders hold the lock while blocked on out, and the probe slot while
+		// waiting for the lock. A consumer that doesn't read stops the probing.
+		var sendMx sync.Mutex
+		sent := 0
+		send := func(pi peer.AddrInfo) {
+			sendMx.Lock()
+			defer sendMx.Unlock()
+			if sent == num {
+				return
+			}
+			select {
+			case out <- pi:
+				sent++
+				if sent == num {
+					cancel()
+				}
+			case <-ctx.Done():
+			}
+		}
+
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		probeSlots := make(chan struct{}, s.conf.maxProbes)
+		for pi := range peers {
+			if pi.ID == s.host.ID() || pi.ID.Validate() != nil {
+				continue
+			}
+			select {
+			case probeSlots <- struct{}{}:
+			case <-ctx.Done():
+				// drain the channel, so that the discovery service can exit
+				continue
+			}
+			wg.Add(1)
+			go func(pi peer.AddrInfo) {
+				defer wg.Done()
+				defer func() { <-probeSlots }()
+				if s.isLiveRelay(ctx, pi) {
+					send(pi)
+				}
+			}(pi)
+		}
+	}()
+	return out
+}
+
+// isLiveRelay returns true if pi recently passed a probe.
+func (s *routingSource) isLiveRelay(ctx context.Context, pi peer.AddrInfo) bool {
+	// Don't connect to peers that told us (via identify) that they aren't relays.
+	if protos, err := s.host.Peerstore().GetProtocols(pi.ID); err == nil && len(protos) > 0 {
+		if supported, _ := s.host.Peerstore().SupportsProtocols(pi.ID, protoIDv2); len(supported) == 0 {
+			return false
+		}
+	}
+
+	s.mx.Lock()
+	res, ok := s.probes[pi.ID]
+	s.mx.Unlock()
+	if ok && time.Since(res.at) < s.conf.probeTTL {
+		return res.ok
+	}
+
+	err := s.probe(ctx, pi)
+	if ctx.Err() != nil {
+		// canceled because we have enough candidates, don't count it as a failure
+		return false
+	}
+	if err != nil {
+		log.Debugw("relay candidate failed probe", "peer", pi.ID, "error", err)
+	}
+	s.mx.Lock()
+	s.probes[pi.ID] = probeResult{at: time.Now(), ok: err == nil}
+	s.mx.Unlock()
+	return err == nil
+}
+
+func (s *routingSource) probe(ctx context.Context, pi peer.AddrInfo) error {
+	ctx, cancel := context.WithTimeout(ctx, s.conf.probeTimeout)
+	defer cancel()
+
+	if err := s.host.Connect(ctx, pi); err != nil {
+		return err
+	}
+	conns := s.host.Network().ConnsToPeer(pi.ID)
+	if len(conns) == 0 {
+		return errors.New("not connected")
+	}
+	select {
+	case <-s.host.IDService().IdentifyWait(conns[0]):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	supported, err := s.host.Peerstore().SupportsProtocols(pi.ID, protoIDv2)
+	if err != nil {
+		return fmt.Errorf("error checking relay protocol support: %w", err)
+	}
+	if len(supported) == 0 {
+		return errProtocolNotSupported
+	}
+	return nil
+}
+
+func (s *routingSource) pruneProbes() {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	for p, res := range s.probes {
+		if time.Since(res.at) >= s.conf.probeTTL {
+			delete(s.probes, p)
+		}
+	}
+}
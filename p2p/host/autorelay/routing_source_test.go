@@ -0,0 +1,121 @@
+package autorelay_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/discovery"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/libp2p/go-libp2p/p2p/host/autorelay"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	swarmt "github.com/libp2p/go-libp2p/p2p/net/swarm/testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+type mockDiscoverer struct {
+	peers []peer.AddrInfo
+}
+
+func (d *mockDiscoverer) FindPeers(ctx context.Context, ns string, _ ...discovery.Option) (<-chan peer.AddrInfo, error) {
+	ch := make(chan peer.AddrInfo)
+	go func() {
+		defer close(ch)
+		if ns != autorelay.RelayNamespace {
+			return
+		}
+		for _, pi := range d.peers {
+			select {
+			case ch <- pi:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func newTestBasicHost(t *testing.T) *bhost.BasicHost {
+	t.Helper()
+	h, err := bhost.NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	h.Start()
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func collect(ch <-chan peer.AddrInfo) []peer.ID {
+	var peers []peer.ID
+	for pi := range ch {
+		peers = append(peers, pi.ID)
+	}
+	return peers
+}
+
+func TestRoutingPeerSource(t *testing.T) {
+	h := newTestBasicHost(t)
+
+	relays := make([]*bhost.BasicHost, 2)
+	for i := range relays {
+		relays[i] = newTestBasicHost(t)
+		relays[i].SetStreamHandler(protoIDv2, func(s network.Stream) { s.Reset() })
+	}
+	notRelay := newTestBasicHost(t)
+	dead := peer.AddrInfo{ID: test.RandPeerIDFatal(t), Addrs: []ma.Multiaddr{ma.StringCast("/ip4/127.0.0.1/tcp/1")}}
+
+	disc := &mockDiscoverer{peers: []peer.AddrInfo{
+		dead,
+		{ID: h.ID(), Addrs: h.Addrs()},
+		{ID: notRelay.ID(), Addrs: notRelay.Addrs()},
+		{ID: relays[0].ID(), Addrs: relays[0].Addrs()},
+		{ID: relays[1].ID(), Addrs: relays[1].Addrs()},
+	}}
+	src, err := autorelay.NewRoutingPeerSource(h, disc, autorelay.WithProbeTimeout(5*time.Second))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.ElementsMatch(t, []peer.ID{relays[0].ID(), relays[1].ID()}, collect(src(ctx, 5)))
+	// at most num candidates are returned
+	require.Len(t, collect(src(ctx, 1)), 1)
+
+	// The non-relay isn't probed again: we know its protocols.
+	notRelay.SetStreamHandler(protoIDv2, func(s network.Stream) { s.Reset() })
+	require.NotContains(t, collect(src(ctx, 5)), notRelay.ID())
+}
+
+func TestRoutingPeerSourceBackpressure(t *testing.T) {
+	h := newTestBasicHost(t)
+
+	var peers []peer.AddrInfo
+	for i := 0; i < 6; i++ {
+		r := newTestBasicHost(t)
+		r.SetStreamHandler(protoIDv2, func(s network.Stream) { s.Reset() })
+		peers = append(peers, peer.AddrInfo{ID: r.ID(), Addrs: r.Addrs()})
+	}
+	src, err := autorelay.NewRoutingPeerSource(h, &mockDiscoverer{peers: peers}, autorelay.WithMaxConcurrentProbes(1))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := src(ctx, 6)
+	<-ch
+	// One candidate is waiting to be consumed, no other candidate is probed.
+	time.Sleep(200 * time.Millisecond)
+	require.Len(t, h.Network().Peers(), 2)
+
+	cancel()
+	collect(ch)
+}
+
+func TestRoutingPeerSourceOptions(t *testing.T) {
+	h := newTestBasicHost(t)
+	_, err := autorelay.NewRoutingPeerSource(h, &mockDiscoverer{}, autorelay.WithMaxConcurrentProbes(0))
+	require.Error(t, err)
+	_, err = autorelay.NewRoutingPeerSource(h, &mockDiscoverer{}, autorelay.WithProbeTimeout(0))
+	require.Error(t, err)
+}
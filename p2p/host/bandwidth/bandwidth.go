@@ -0,0 +1,559 @@
+// Package bandwidth implements a bandwidth counter that keeps rollups of the
+// traffic over the last minute, 5 minutes and hour, broken down by peer,
+// protocol and transport.
+//
+// Counter is a drop-in replacement for metrics.BandwidthCounter: pass it to
+// libp2p.BandwidthReporter. In addition to the Reporter methods, it allows
+// querying the top consumers of bandwidth, exports prometheus metrics, and can
+// persist its totals across restarts, see WithDatastore.
+package bandwidth
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	ds "github.com/ipfs/go-datastore"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("bandwidth")
+
+// Windows are the windows rollups are kept for.
+var Windows = []time.Duration{time.Minute, 5 * time.Minute, time.Hour}
+
+const (
+	// The last minute is kept in fine grained buckets, so that its rate
+	// isn't dominated by the current, partial bucket.
+	fineInterval = 10 * time.Second
+	fineBuckets  = 6
+	// The last hour is kept in one minute buckets.
+	coarseInterval = time.Minute
+	coarseBuckets  = 60
+)
+
+// Rollup is the traffic within a window.
+type Rollup struct {
+	Window   time.Duration
+	BytesIn  int64
+	BytesOut int64
+	// RateIn and RateOut are in bytes per second.
+	RateIn  float64
+	RateOut float64
+}
+
+// Snapshot is the traffic of a peer, protocol or transport, or of all traffic.
+type Snapshot struct {
+	// TotalIn and TotalOut are the bytes transferred since the counter was
+	// created, including the totals restored from the datastore.
+	TotalIn  int64
+	TotalOut int64
+	// Rollups holds one Rollup per window in Windows.
+	Rollups []Rollup
+}
+
+// Rollup returns the rollup for window w, if it's one of Windows.
+func (s Snapshot) Rollup(w time.Duration) (Rollup, bool) {
+	for _, r := range s.Rollups {
+		if r.Window == w {
+			return r, true
+		}
+	}
+	return Rollup{}, false
+}
+
+type counts struct {
+	In, Out int64
+}
+
+// bucket holds the counts of one interval.
+type bucket struct {
+	// epoch is the interval number the bucket was last reset for
+	epoch   atomic.Int64
+	in, out atomic.Int64
+}
+
+// ring keeps counts in buckets covering one interval each. It is updated
+// without locks: counts added concurrently with the reset of a bucket for a
+// new interval may be lost.
+type ring struct {
+	interval time.Duration
+	buckets  []bucket
+}
+
+func newRing(interval time.Duration, n int) ring {
+	r := ring{interval: interval, buckets: make([]bucket, n)}
+	for i := range r.buckets {
+		r.buckets[i].epoch.Store(-1)
+	}
+	return r
+}
+
+func (r *ring) epoch(now time.Time) int64 {
+	return now.UnixNano() / int64(r.interval)
+}
+
+func (r *ring) add(now time.Time, c counts) {
+	epoch := r.epoch(now)
+	b := &r.buckets[epoch%int64(len(r.buckets))]
+	if e := b.epoch.Load(); e < epoch && b.epoch.CompareAndSwap(e, epoch) {
+		b.in.Store(0)
+		b.out.Store(0)
+	}
+	b.in.Add(c.In)
+	b.out.Add(c.Out)
+}
+
+// span returns the longest window the ring covers.
+func (r *ring) span() time.Duration {
+	return time.Duration(len(r.buckets)) * r.interval
+}
+
+// coverage returns the number of buckets of the given interval covering
+// window, and the duration actually covered: window is rounded up to whole
+// buckets, the current bucket only covering the time elapsed since it
+// started.
+func coverage(now time.Time, interval time.Duration, buckets int, window time.Duration) (int64, time.Duration) {
+	n := int64((window + interval - 1) / interval)
+	if n > int64(buckets) {
+		n = int64(buckets)
+	}
+	elapsed := time.Duration(now.UnixNano() % int64(interval))
+	return n, time.Duration(n-1)*interval + elapsed
+}
+
+// sum returns the counts within window, and the duration actually covered,
+// see coverage.
+func (r *ring) sum(now time.Time, window time.Duration) (counts, time.Duration) {
+	n, covered := coverage(now, r.interval, len(r.buckets), window)
+	epoch := r.epoch(now)
+	var c counts
+	for i := range r.buckets {
+		b := &r.buckets[i]
+		if e := b.epoch.Load(); e > epoch-n && e <= epoch {
+			c.In += b.in.Load()
+			c.Out += b.out.Load()
+		}
+	}
+	return c, covered
+}
+
+// newRollup returns the rollup of the counts c within window, covering the
+// given duration. Rates are calculated over the time since start if it's
+// shorter.
+func newRollup(now, start time.Time, window time.Duration, c counts, covered time.Duration) Rollup {
+	covered = min(covered, max(now.Sub(start), time.Second))
+	rollup := Rollup{Window: window, BytesIn: c.In, BytesOut: c.Out}
+	if secs := covered.Seconds(); secs > 0 {
+		rollup.RateIn = float64(c.In) / secs
+		rollup.RateOut = float64(c.Out) / secs
+	}
+	return rollup
+}
+
+// series is the traffic of a protocol or transport, or of all traffic.
+type series struct {
+	totalIn, totalOut atomic.Int64
+	fine, coarse      ring
+	// lastActive is the time of the last traffic, in Unix nanoseconds
+	lastActive atomic.Int64
+}
+
+func newSeries() *series {
+	return &series{
+		fine:   newRing(fineInterval, fineBuckets),
+		coarse: newRing(coarseInterval, coarseBuckets),
+	}
+}
+
+func (s *series) add(now time.Time, c counts) {
+	s.totalIn.Add(c.In)
+	s.totalOut.Add(c.Out)
+	s.fine.add(now, c)
+	s.coarse.add(now, c)
+	s.lastActive.Store(now.UnixNano())
+}
+
+func (s *series) rollup(now, start time.Time, window time.Duration) Rollup {
+	r := &s.coarse
+	if window <= s.fine.span() {
+		r = &s.fine
+	}
+	c, covered := r.sum(now, window)
+	return newRollup(now, start, window, c, covered)
+}
+
+func (s *series) snapshot(now, start time.Time) Snapshot {
+	snap := Snapshot{TotalIn: s.totalIn.Load(), TotalOut: s.totalOut.Load(), Rollups: make([]Rollup, 0, len(Windows))}
+	for _, w := range Windows {
+		snap.Rollups = append(snap.Rollups, s.rollup(now, start, w))
+	}
+	return snap
+}
+
+// stats converts the series to metrics.Stats, using the rate of the shortest
+// window.
+func (s *series) stats(now, start time.Time) metrics.Stats {
+	r := s.rollup(now, start, Windows[0])
+	return metrics.Stats{TotalIn: s.totalIn.Load(), TotalOut: s.totalOut.Load(), RateIn: r.RateIn, RateOut: r.RateOut}
+}
+
+// Option configures a Counter.
+type Option func(*Counter) error
+
+// WithDatastore makes the counter save its totals, and the rollups of all
+// traffic and of each protocol and transport, to d, and restore them when the
+// counter is created. The state is saved periodically, and when the counter is
+// closed. Peers aren't saved.
+func WithDatastore(d ds.Datastore) Option {
+	return func(c *Counter) error {
+		if d == nil {
+			return errors.New("bandwidth: datastore cannot be nil")
+		}
+		c.datastore = d
+		return nil
+	}
+}
+
+// WithClock sets the function used to get the current time.
+func WithClock(now func() time.Time) Option {
+	return func(c *Counter) error {
+		c.now = now
+		return nil
+	}
+}
+
+// Counter tracks the traffic of the local peer. It implements
+// metrics.Reporter, metrics.TransportReporter and metrics.PeerReporter.
+//
+// The traffic of each peer is kept by a metrics.UsageCounter, for the last
+// hour: the totals of a peer are those of the last hour, and it is removed
+// when the swarm closes its last connection.
+type Counter struct {
+	now       func() time.Time
+	datastore ds.Datastore
+	// start is when the counter started counting
+	start time.Time
+
+	total *series
+	// protocols maps protocol IDs to *series
+	protocols sync.Map
+	// transports maps transport names to *series
+	transports sync.Map
+	peers      *metrics.UsageCounter
+
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+	closeOnce sync.Once
+	refs      sync.WaitGroup
+}
+
+var (
+	_ metrics.Reporter          = (*Counter)(nil)
+	_ metrics.TransportReporter = (*Counter)(nil)
+	_ metrics.PeerReporter      = (*Counter)(nil)
+)
+
+// peerHistory is how long the traffic of peers is kept.
+const peerHistory = coarseInterval * coarseBuckets
+
+// New creates a new Counter. It must be closed if it was created
+// WithDatastore.
+func New(opts ...Option) (*Counter, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Counter{
+		now:       time.Now,
+		total:     newSeries(),
+		ctx:       ctx,
+		ctxCancel: cancel,
+	}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	peers, err := metrics.NewUsageCounter(metrics.NullReporter{},
+		metrics.WithUsageBuckets(coarseInterval, coarseBuckets),
+		metrics.WithUsageClock(c.now),
+	)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	c.peers = peers
+	c.start = c.now()
+	if c.datastore != nil {
+		if err := c.restoreState(); err != nil {
+			log.Warnf("failed to restore bandwidth state: %s", err)
+		}
+		c.refs.Add(1)
+		go c.persistState()
+	}
+	return c, nil
+}
+
+// Close stops the counter, saving its state if it was created WithDatastore.
+func (c *Counter) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.ctxCancel()
+		c.refs.Wait()
+		if c.datastore != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err = c.saveState(ctx)
+		}
+	})
+	return err
+}
+
+func getSeries(m *sync.Map, k any) *series {
+	s, ok := m.Load(k)
+	if !ok {
+		s, _ = m.LoadOrStore(k, newSeries())
+	}
+	return s.(*series)
+}
+
+// LogSentMessage records the size of an outgoing message.
+func (c *Counter) LogSentMessage(size int64) {
+	c.total.add(c.now(), counts{Out: size})
+}
+
+// LogRecvMessage records the size of an incoming message.
+func (c *Counter) LogRecvMessage(size int64) {
+	c.total.add(c.now(), counts{In: size})
+}
+
+// LogSentMessageStream records the size of an outgoing message over a stream.
+func (c *Counter) LogSentMessageStream(size int64, proto protocol.ID, p peer.ID) {
+	getSeries(&c.protocols, proto).add(c.now(), counts{Out: size})
+	c.peers.LogSentMessageStream(size, proto, p)
+}
+
+// LogRecvMessageStream records the size of an incoming message over a stream.
+func (c *Counter) LogRecvMessageStream(size int64, proto protocol.ID, p peer.ID) {
+	getSeries(&c.protocols, proto).add(c.now(), counts{In: size})
+	c.peers.LogRecvMessageStream(size, proto, p)
+}
+
+// LogSentMessageTransport records the size of an outgoing message over a
+// connection using transport.
+func (c *Counter) LogSentMessageTransport(size int64, transport string) {
+	getSeries(&c.transports, transport).add(c.now(), counts{Out: size})
+}
+
+// LogRecvMessageTransport records the size of an incoming message over a
+// connection using transport.
+func (c *Counter) LogRecvMessageTransport(size int64, transport string) {
+	getSeries(&c.transports, transport).add(c.now(), counts{In: size})
+}
+
+// RemovePeer removes the traffic of p.
+func (c *Counter) RemovePeer(p peer.ID) {
+	c.peers.RemovePeer(p)
+}
+
+func snapshotOf(c *Counter, m *sync.Map, k any) Snapshot {
+	now := c.now()
+	s, ok := m.Load(k)
+	if !ok {
+		return newSeries().snapshot(now, c.start)
+	}
+	return s.(*series).snapshot(now, c.start)
+}
+
+func snapshotsOf[K comparable](c *Counter, m *sync.Map) map[K]Snapshot {
+	now := c.now()
+	res := make(map[K]Snapshot)
+	m.Range(func(k, s any) bool {
+		res[k.(K)] = s.(*series).snapshot(now, c.start)
+		return true
+	})
+	return res
+}
+
+// Totals returns the snapshot of all traffic.
+func (c *Counter) Totals() Snapshot {
+	return c.total.snapshot(c.now(), c.start)
+}
+
+// peerUsage returns the traffic with p within window.
+func (c *Counter) peerUsage(p peer.ID, window time.Duration) (u metrics.Usage) {
+	for _, pu := range c.peers.GetUsageForPeer(p, window) {
+		u.BytesIn += pu.BytesIn
+		u.BytesOut += pu.BytesOut
+	}
+	return u
+}
+
+// peerRollup returns the rollup of the traffic u of a peer within window.
+func (c *Counter) peerRollup(now time.Time, u metrics.Usage, window time.Duration) Rollup {
+	_, covered := coverage(now, coarseInterval, coarseBuckets, window)
+	return newRollup(now, c.start, window, counts{In: u.BytesIn, Out: u.BytesOut}, covered)
+}
+
+// ForPeer returns the snapshot of the traffic with p. Its totals are those
+// of the last hour.
+func (c *Counter) ForPeer(p peer.ID) Snapshot {
+	now := c.now()
+	total := c.peerUsage(p, peerHistory)
+	snap := Snapshot{TotalIn: total.BytesIn, TotalOut: total.BytesOut, Rollups: make([]Rollup, 0, len(Windows))}
+	for _, w := range Windows {
+		snap.Rollups = append(snap.Rollups, c.peerRollup(now, c.peerUsage(p, w), w))
+	}
+	return snap
+}
+
+// ForProtocol returns the snapshot of the traffic of proto.
+func (c *Counter) ForProtocol(proto protocol.ID) Snapshot {
+	return snapshotOf(c, &c.protocols, proto)
+}
+
+// ForTransport returns the snapshot of the traffic over transport, e.g.
+// "tcp" or "quic-v1".
+func (c *Counter) ForTransport(transport string) Snapshot {
+	return snapshotOf(c, &c.transports, transport)
+}
+
+// ByTransport returns the snapshots of all transports.
+func (c *Counter) ByTransport() map[string]Snapshot { return snapshotsOf[string](c, &c.transports) }
+
+// Dimension is a dimension the traffic is broken down by.
+type Dimension int
+
+const (
+	DimensionPeer Dimension = iota
+	DimensionProtocol
+	DimensionTransport
+)
+
+// Consumer is a peer, protocol or transport, and its traffic.
+type Consumer struct {
+	// Key is the peer ID, protocol ID, or transport name.
+	Key    string
+	Rollup Rollup
+}
+
+func topOf[K ~string](now, start time.Time, m *sync.Map, window time.Duration) []Consumer {
+	var consumers []Consumer
+	m.Range(func(k, s any) bool {
+		r := s.(*series).rollup(now, start, window)
+		if r.BytesIn+r.BytesOut > 0 {
+			consumers = append(consumers, Consumer{Key: string(k.(K)), Rollup: r})
+		}
+		return true
+	})
+	return consumers
+}
+
+// Top returns the n peers, protocols or transports that transferred the most
+// bytes within window, in descending order. Windows longer than an hour are
+// truncated.
+func (c *Counter) Top(d Dimension, window time.Duration, n int) []Consumer {
+	now := c.now()
+	var consumers []Consumer
+	switch d {
+	case DimensionPeer:
+		for p, u := range c.peers.GetUsageByPeer(window) {
+			if u.BytesIn+u.BytesOut > 0 {
+				consumers = append(consumers, Consumer{Key: string(p), Rollup: c.peerRollup(now, u, window)})
+			}
+		}
+	case DimensionProtocol:
+		consumers = topOf[protocol.ID](now, c.start, &c.protocols, window)
+	case DimensionTransport:
+		consumers = topOf[string](now, c.start, &c.transports, window)
+	}
+
+	sort.Slice(consumers, func(i, j int) bool {
+		ri, rj := consumers[i].Rollup, consumers[j].Rollup
+		if bi, bj := ri.BytesIn+ri.BytesOut, rj.BytesIn+rj.BytesOut; bi != bj {
+			return bi > bj
+		}
+		return consumers[i].Key < consumers[j].Key
+	})
+	if len(consumers) > n {
+		consumers = consumers[:n]
+	}
+	return consumers
+}
+
+// peerStats converts the traffic of a peer in the last hour and in the
+// shortest window to metrics.Stats.
+func (c *Counter) peerStats(now time.Time, total, recent metrics.Usage) metrics.Stats {
+	r := c.peerRollup(now, recent, Windows[0])
+	return metrics.Stats{TotalIn: total.BytesIn, TotalOut: total.BytesOut, RateIn: r.RateIn, RateOut: r.RateOut}
+}
+
+// GetBandwidthForPeer returns the bandwidth of p. The totals are those of the
+// last hour, the rates those of the last minute.
+func (c *Counter) GetBandwidthForPeer(p peer.ID) metrics.Stats {
+	return c.peerStats(c.now(), c.peerUsage(p, peerHistory), c.peerUsage(p, Windows[0]))
+}
+
+// GetBandwidthForProtocol returns the bandwidth of proto. The rates are those
+// of the last minute.
+func (c *Counter) GetBandwidthForProtocol(proto protocol.ID) metrics.Stats {
+	s, ok := c.protocols.Load(proto)
+	if !ok {
+		return metrics.Stats{}
+	}
+	return s.(*series).stats(c.now(), c.start)
+}
+
+// GetBandwidthTotals returns the bandwidth of all traffic. The rates are those
+// of the last minute.
+func (c *Counter) GetBandwidthTotals() metrics.Stats {
+	return c.total.stats(c.now(), c.start)
+}
+
+// GetBandwidthByPeer returns the bandwidth of all peers, see
+// GetBandwidthForPeer.
+func (c *Counter) GetBandwidthByPeer() map[peer.ID]metrics.Stats {
+	now := c.now()
+	recent := c.peers.GetUsageByPeer(Windows[0])
+	res := make(map[peer.ID]metrics.Stats)
+	for p, total := range c.peers.GetUsageByPeer(peerHistory) {
+		res[p] = c.peerStats(now, total, recent[p])
+	}
+	return res
+}
+
+// GetBandwidthByProtocol returns the bandwidth of all protocols.
+func (c *Counter) GetBandwidthByProtocol() map[protocol.ID]metrics.Stats {
+	now := c.now()
+	res := make(map[protocol.ID]metrics.Stats)
+	c.protocols.Range(func(proto, s any) bool {
+		res[proto.(protocol.ID)] = s.(*series).stats(now, c.start)
+		return true
+	})
+	return res
+}
+
+// TrimIdle removes the protocols and transports idle since the given time,
+// and the peers without traffic in the last hour. Peers are also removed when
+// they disconnect, but it should be called periodically, so that the counter
+// doesn't keep growing as protocols come and go.
+func (c *Counter) TrimIdle(since time.Time) {
+	trimIdle(&c.protocols, since)
+	trimIdle(&c.transports, since)
+	c.peers.TrimIdle()
+}
+
+func trimIdle(m *sync.Map, since time.Time) {
+	m.Range(func(k, s any) bool {
+		if s.(*series).lastActive.Load() < since.UnixNano() {
+			m.Delete(k)
+		}
+		return true
+	})
+}
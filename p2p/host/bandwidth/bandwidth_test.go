@@ -0,0 +1,200 @@
+package bandwidth
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+type mockClock struct{ t time.Time }
+
+func (c *mockClock) now() time.Time          { return c.t }
+func (c *mockClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// newMockClock returns a clock at the start of a minute.
+func newMockClock() *mockClock {
+	return &mockClock{t: time.Unix(1_000_000_020, 0)}
+}
+
+func TestRollups(t *testing.T) {
+	clk := newMockClock()
+	c, err := New(WithClock(clk.now))
+	require.NoError(t, err)
+	defer c.Close()
+
+	// 1000 bytes per second for an hour
+	for i := 0; i < 3600; i++ {
+		c.LogRecvMessage(1000)
+		c.LogSentMessage(500)
+		clk.advance(time.Second)
+	}
+	// then idle for two minutes
+	clk.advance(2 * time.Minute)
+
+	totals := c.Totals()
+	require.Equal(t, int64(3600*1000), totals.TotalIn)
+	require.Equal(t, int64(3600*500), totals.TotalOut)
+
+	r, ok := totals.Rollup(time.Minute)
+	require.True(t, ok)
+	require.Zero(t, r.BytesIn)
+	require.Zero(t, r.RateIn)
+
+	// The window includes the current bucket, which just started.
+	r, ok = totals.Rollup(5 * time.Minute)
+	require.True(t, ok)
+	require.Equal(t, int64(2*60*1000), r.BytesIn)
+	require.InDelta(t, 500, r.RateIn, 0.1)
+	require.InDelta(t, 250, r.RateOut, 0.1)
+
+	r, ok = totals.Rollup(time.Hour)
+	require.True(t, ok)
+	require.Equal(t, int64(57*60*1000), r.BytesIn)
+	require.InDelta(t, 1000*57.0/59, r.RateIn, 0.1)
+
+	stats := c.GetBandwidthTotals()
+	require.Equal(t, int64(3600*1000), stats.TotalIn)
+	require.Zero(t, stats.RateIn)
+}
+
+func TestRateSinceStart(t *testing.T) {
+	clk := newMockClock()
+	c, err := New(WithClock(clk.now))
+	require.NoError(t, err)
+	defer c.Close()
+
+	for i := 0; i < 30; i++ {
+		clk.advance(time.Second)
+		c.LogRecvMessage(100)
+	}
+	// The rate of the hour is calculated over the 30s since the counter
+	// started, not the whole hour.
+	r, _ := c.Totals().Rollup(time.Hour)
+	require.InDelta(t, 100, r.RateIn, 0.1)
+}
+
+func TestTopConsumers(t *testing.T) {
+	clk := newMockClock()
+	c, err := New(WithClock(clk.now))
+	require.NoError(t, err)
+	defer c.Close()
+
+	p1, p2, p3 := peer.ID("p1"), peer.ID("p2"), peer.ID("p3")
+	c.LogRecvMessageStream(1000, "/foo", p1)
+	c.LogSentMessageStream(3000, "/bar", p2)
+	c.LogRecvMessageStream(2000, "/foo", p3)
+	c.LogRecvMessageTransport(4000, "tcp")
+	c.LogSentMessageTransport(2000, "quic-v1")
+
+	top := c.Top(DimensionPeer, time.Minute, 2)
+	require.Len(t, top, 2)
+	require.Equal(t, string(p2), top[0].Key)
+	require.Equal(t, int64(3000), top[0].Rollup.BytesOut)
+	require.Equal(t, string(p3), top[1].Key)
+
+	top = c.Top(DimensionProtocol, time.Minute, 10)
+	require.Equal(t, []string{"/bar", "/foo"}, []string{top[0].Key, top[1].Key})
+	require.Equal(t, int64(3000), top[1].Rollup.BytesIn)
+
+	top = c.Top(DimensionTransport, time.Minute, 10)
+	require.Equal(t, []string{"tcp", "quic-v1"}, []string{top[0].Key, top[1].Key})
+	require.Equal(t, int64(4000), c.ForTransport("tcp").TotalIn)
+
+	// old traffic isn't included
+	clk.advance(2 * time.Minute)
+	c.LogRecvMessageStream(10, "/foo", p1)
+	top = c.Top(DimensionPeer, time.Minute, 10)
+	require.Len(t, top, 1)
+	require.Equal(t, string(p1), top[0].Key)
+
+	require.Equal(t, map[protocol.ID]int64{"/foo": 3010, "/bar": 0}, map[protocol.ID]int64{
+		"/foo": c.GetBandwidthForProtocol("/foo").TotalIn,
+		"/bar": c.GetBandwidthForProtocol("/bar").TotalIn,
+	})
+
+	// peers are kept for an hour, unless they disconnect
+	c.TrimIdle(clk.now().Add(-time.Minute))
+	require.Len(t, c.GetBandwidthByPeer(), 3)
+	require.Len(t, c.GetBandwidthByProtocol(), 1)
+	require.Empty(t, c.ByTransport())
+	c.RemovePeer(p2)
+	require.Len(t, c.GetBandwidthByPeer(), 2)
+	require.Zero(t, c.ForPeer(p2).TotalOut)
+	require.Equal(t, int64(1010), c.ForPeer(p1).TotalIn)
+}
+
+func TestPersistence(t *testing.T) {
+	clk := newMockClock()
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	c, err := New(WithClock(clk.now), WithDatastore(d))
+	require.NoError(t, err)
+	for i := 0; i < 120; i++ {
+		clk.advance(time.Second)
+		c.LogRecvMessage(100)
+		c.LogRecvMessageStream(100, "/foo", "p1")
+		c.LogRecvMessageTransport(100, "tcp")
+	}
+	require.NoError(t, c.Close())
+
+	clk.advance(time.Minute)
+	c, err = New(WithClock(clk.now), WithDatastore(d))
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.Equal(t, int64(12000), c.Totals().TotalIn)
+	r, _ := c.Totals().Rollup(time.Hour)
+	require.Equal(t, int64(12000), r.BytesIn)
+	require.InDelta(t, 12000.0/180, r.RateIn, 1)
+	require.Equal(t, int64(12000), c.ForProtocol("/foo").TotalIn)
+	require.Equal(t, int64(12000), c.ForTransport("tcp").TotalIn)
+	// peers aren't saved
+	require.Zero(t, c.ForPeer("p1").TotalIn)
+}
+
+func TestMetricsAndHandler(t *testing.T) {
+	c, err := New()
+	require.NoError(t, err)
+	defer c.Close()
+	c.LogRecvMessage(100)
+	c.LogSentMessage(200)
+	c.LogRecvMessageStream(100, "/foo", "p1")
+	c.LogSentMessageTransport(200, "tcp")
+
+	expected := `
+# HELP libp2p_bandwidth_bytes_total Bytes transferred
+# TYPE libp2p_bandwidth_bytes_total counter
+libp2p_bandwidth_bytes_total{dir="inbound"} 100
+libp2p_bandwidth_bytes_total{dir="outbound"} 200
+# HELP libp2p_bandwidth_transport_bytes_total Bytes transferred per transport
+# TYPE libp2p_bandwidth_transport_bytes_total counter
+libp2p_bandwidth_transport_bytes_total{dir="inbound",transport="tcp"} 0
+libp2p_bandwidth_transport_bytes_total{dir="outbound",transport="tcp"} 200
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected), "libp2p_bandwidth_bytes_total", "libp2p_bandwidth_transport_bytes_total"))
+	require.Equal(t, 2*len(Windows), testutil.CollectAndCount(c, "libp2p_bandwidth_rate_bytes_per_second"))
+
+	rec := httptest.NewRecorder()
+	NewHandler(c).ServeHTTP(rec, httptest.NewRequest("GET", "/?window=5m&n=1", nil))
+	require.Equal(t, 200, rec.Code)
+	var report Report
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	require.Equal(t, int64(100), report.Totals.TotalIn)
+	require.Equal(t, 5*time.Minute, report.Window)
+	require.Len(t, report.TopPeers, 1)
+	require.Equal(t, "/foo", report.TopProtocols[0].Key)
+	require.Contains(t, report.Transports, "tcp")
+
+	rec = httptest.NewRecorder()
+	NewHandler(c).ServeHTTP(rec, httptest.NewRequest("GET", "/?window=foo", nil))
+	require.Equal(t, 400, rec.Code)
+}
@@ -0,0 +1,106 @@
+package bandwidth
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricNamespace = "libp2p_bandwidth"
+
+var (
+	bytesTotalDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricNamespace, "", "bytes_total"),
+		"Bytes transferred",
+		[]string{"dir"}, nil,
+	)
+	transportBytesTotalDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricNamespace, "", "transport_bytes_total"),
+		"Bytes transferred per transport",
+		[]string{"dir", "transport"}, nil,
+	)
+	rateDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricNamespace, "", "rate_bytes_per_second"),
+		"Transfer rate over a window",
+		[]string{"dir", "window"}, nil,
+	)
+)
+
+var _ prometheus.Collector = (*Counter)(nil)
+
+// Describe implements prometheus.Collector.
+func (c *Counter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- bytesTotalDesc
+	ch <- transportBytesTotalDesc
+	ch <- rateDesc
+}
+
+// Collect implements prometheus.Collector. Only the totals and the transports
+// are exported, peers and protocols would result in too many series.
+func (c *Counter) Collect(ch chan<- prometheus.Metric) {
+	totals := c.Totals()
+	ch <- prometheus.MustNewConstMetric(bytesTotalDesc, prometheus.CounterValue, float64(totals.TotalIn), "inbound")
+	ch <- prometheus.MustNewConstMetric(bytesTotalDesc, prometheus.CounterValue, float64(totals.TotalOut), "outbound")
+	for _, r := range totals.Rollups {
+		w := r.Window.String()
+		ch <- prometheus.MustNewConstMetric(rateDesc, prometheus.GaugeValue, r.RateIn, "inbound", w)
+		ch <- prometheus.MustNewConstMetric(rateDesc, prometheus.GaugeValue, r.RateOut, "outbound", w)
+	}
+	for t, snap := range c.ByTransport() {
+		ch <- prometheus.MustNewConstMetric(transportBytesTotalDesc, prometheus.CounterValue, float64(snap.TotalIn), "inbound", t)
+		ch <- prometheus.MustNewConstMetric(transportBytesTotalDesc, prometheus.CounterValue, float64(snap.TotalOut), "outbound", t)
+	}
+}
+
+// defaultTopN is the number of top consumers returned by the HTTP handler,
+// unless the request sets n.
+const defaultTopN = 10
+
+// Report is the report served by the handler returned by NewHandler.
+type Report struct {
+	Totals     Snapshot
+	Transports map[string]Snapshot
+	// TopPeers and TopProtocols are the top consumers of the window.
+	Window       time.Duration
+	TopPeers     []Consumer
+	TopProtocols []Consumer
+}
+
+// NewHandler returns an http.Handler serving a Report of c as JSON. The
+// window of the top consumers, and their number can be set with the window
+// (e.g. "5m") and n query parameters. They default to one minute and 10.
+func NewHandler(c *Counter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		window, n := Windows[0], defaultTopN
+		if s := r.URL.Query().Get("window"); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil || d <= 0 {
+				http.Error(w, "invalid window", http.StatusBadRequest)
+				return
+			}
+			window = d
+		}
+		if s := r.URL.Query().Get("n"); s != "" {
+			v, err := strconv.Atoi(s)
+			if err != nil || v < 0 {
+				http.Error(w, "invalid n", http.StatusBadRequest)
+				return
+			}
+			n = v
+		}
+		report := Report{
+			Totals:       c.Totals(),
+			Transports:   c.ByTransport(),
+			Window:       window,
+			TopPeers:     c.Top(DimensionPeer, window, n),
+			TopProtocols: c.Top(DimensionProtocol, window, n),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			log.Debugw("failed to write bandwidth report", "error", err)
+		}
+	})
+}
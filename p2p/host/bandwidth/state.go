@@ -0,0 +1,126 @@
+package bandwidth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	ds "github.com/ipfs/go-datastore"
+)
+
+var stateKey = ds.NewKey("/libp2p/bandwidth/state")
+
+// statePersistInterval is the interval at which the state is saved, in
+// addition to when the counter is closed.
+const statePersistInterval = 5 * time.Minute
+
+type savedBucket struct {
+	Epoch   int64
+	In, Out int64
+}
+
+type savedSeries struct {
+	TotalIn, TotalOut int64
+	// Buckets are the buckets of the last hour.
+	Buckets []savedBucket `json:",omitempty"`
+}
+
+type savedState struct {
+	SavedAt    time.Time
+	Start      time.Time
+	Total      savedSeries
+	Protocols  map[protocol.ID]savedSeries `json:",omitempty"`
+	Transports map[string]savedSeries      `json:",omitempty"`
+}
+
+func (s *series) saved() savedSeries {
+	saved := savedSeries{TotalIn: s.totalIn.Load(), TotalOut: s.totalOut.Load()}
+	for i := range s.coarse.buckets {
+		b := &s.coarse.buckets[i]
+		if e := b.epoch.Load(); e >= 0 {
+			saved.Buckets = append(saved.Buckets, savedBucket{Epoch: e, In: b.in.Load(), Out: b.out.Load()})
+		}
+	}
+	return saved
+}
+
+func restoreSeries(saved savedSeries) *series {
+	s := newSeries()
+	s.totalIn.Store(saved.TotalIn)
+	s.totalOut.Store(saved.TotalOut)
+	for _, b := range saved.Buckets {
+		s.coarse.add(time.Unix(0, b.Epoch*int64(coarseInterval)), counts{In: b.In, Out: b.Out})
+	}
+	return s
+}
+
+// restoreState restores the saved state. It is called when the counter is
+// created, before it's used.
+func (c *Counter) restoreState() error {
+	b, err := c.datastore.Get(c.ctx, stateKey)
+	if errors.Is(err, ds.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var st savedState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return err
+	}
+
+	if st.Start.Before(c.start) {
+		c.start = st.Start
+	}
+	c.total = restoreSeries(st.Total)
+	for proto, saved := range st.Protocols {
+		c.protocols.Store(proto, restoreSeries(saved))
+	}
+	for t, saved := range st.Transports {
+		c.transports.Store(t, restoreSeries(saved))
+	}
+	return nil
+}
+
+func (c *Counter) saveState(ctx context.Context) error {
+	st := savedState{
+		SavedAt:    c.now(),
+		Start:      c.start,
+		Total:      c.total.saved(),
+		Protocols:  make(map[protocol.ID]savedSeries),
+		Transports: make(map[string]savedSeries),
+	}
+	c.protocols.Range(func(proto, s any) bool {
+		st.Protocols[proto.(protocol.ID)] = s.(*series).saved()
+		return true
+	})
+	c.transports.Range(func(t, s any) bool {
+		st.Transports[t.(string)] = s.(*series).saved()
+		return true
+	})
+
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return c.datastore.Put(ctx, stateKey, b)
+}
+
+func (c *Counter) persistState() {
+	defer c.refs.Done()
+	t := time.NewTicker(statePersistInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := c.saveState(c.ctx); err != nil {
+				log.Warnf("failed to save bandwidth state: %s", err)
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
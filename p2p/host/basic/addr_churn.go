@@ -0,0 +1,124 @@
+package basichost
+
+import (
+	"bytes"
+	"slices"
+	"sync"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxAddrChanges is the number of changes kept in the address change history.
+const maxAddrChanges = 64
+
+// AddrChange is a change of the addresses advertised by the host.
+type AddrChange struct {
+	Time    time.Time
+	Added   []ma.Multiaddr
+	Removed []ma.Multiaddr
+	// Total is the number of addresses after the change.
+	Total int
+}
+
+// DiffAddrs returns the addresses added to and removed from prev in current.
+// Addresses are compared by their binary representation, duplicates are
+// ignored, and both lists are sorted, so that equal sets always produce equal
+// diffs.
+func DiffAddrs(prev, current []ma.Multiaddr) (added, removed []ma.Multiaddr) {
+	prevSet := make(map[string]struct{}, len(prev))
+	for _, a := range prev {
+		prevSet[string(a.Bytes())] = struct{}{}
+	}
+	currSet := make(map[string]struct{}, len(current))
+	for _, a := range current {
+		k := string(a.Bytes())
+		if _, ok := currSet[k]; ok {
+			continue
+		}
+		currSet[k] = struct{}{}
+		if _, ok := prevSet[k]; !ok {
+			added = append(added, a)
+		}
+	}
+	for _, a := range prev {
+		k := string(a.Bytes())
+		if _, ok := currSet[k]; ok {
+			continue
+		}
+		// mark as seen, to skip duplicates
+		currSet[k] = struct{}{}
+		removed = append(removed, a)
+	}
+	sortAddrs(added)
+	sortAddrs(removed)
+	return added, removed
+}
+
+func sortAddrs(addrs []ma.Multiaddr) {
+	slices.SortFunc(addrs, func(a, b ma.Multiaddr) int { return bytes.Compare(a.Bytes(), b.Bytes()) })
+}
+
+var (
+	addrChangesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "addr_changes_total",
+			Help:      "Addresses added to and removed from the advertised addresses",
+		},
+		[]string{"action"},
+	)
+	advertisedAddrs = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Name:      "advertised_addrs",
+			Help:      "Number of advertised addresses",
+		},
+	)
+)
+
+// addrChanges keeps a history of the most recent address changes.
+type addrChanges struct {
+	mu      sync.Mutex
+	changes []AddrChange
+}
+
+func (ac *addrChanges) add(c AddrChange) {
+	addrChangesTotal.WithLabelValues("added").Add(float64(len(c.Added)))
+	addrChangesTotal.WithLabelValues("removed").Add(float64(len(c.Removed)))
+	advertisedAddrs.Set(float64(c.Total))
+
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	if len(ac.changes) >= maxAddrChanges {
+		ac.changes = append(ac.changes[:0], ac.changes[1:]...)
+	}
+	ac.changes = append(ac.changes, c)
+}
+
+func (ac *addrChanges) list() []AddrChange {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	return append([]AddrChange(nil), ac.changes...)
+}
+
+// AddrChanges returns the most recent changes of the advertised addresses,
+// oldest first. The number of added and removed addresses is also counted in
+// the libp2p_host_addr_changes_total metric.
+func (h *BasicHost) AddrChanges() []AddrChange {
+	return h.addrChanges.list()
+}
+
+// AddrChurn returns the number of addresses added and removed within the last
+// window, as far as the history of AddrChanges goes back.
+func (h *BasicHost) AddrChurn(window time.Duration) int {
+	cutoff := time.Now().Add(-window)
+	var churn int
+	for _, c := range h.addrChanges.list() {
+		if c.Time.After(cutoff) {
+			churn += len(c.Added) + len(c.Removed)
+		}
+	}
+	return churn
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/connmgr"
@@ -14,20 +15,24 @@ import (
 	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
+	neterrors "github.com/libp2p/go-libp2p/core/network/errors"
+	"github.com/libp2p/go-libp2p/core/panics"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/core/record"
 	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/host/addrcheck"
 	"github.com/libp2p/go-libp2p/p2p/host/autonat"
 	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	"github.com/libp2p/go-libp2p/p2p/host/netmon"
 	"github.com/libp2p/go-libp2p/p2p/host/pstoremanager"
-	"github.com/libp2p/go-libp2p/p2p/host/relaysvc"
+	"github.com/libp2p/go-libp2p/p2p/metricshelper"
+	"github.com/libp2p/go-libp2p/p2p/protocol/attestation"
 	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
 	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
 	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
 	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
-	libp2pwebrtc "github.com/libp2p/go-libp2p/p2p/transport/webrtc"
 	libp2pwebtransport "github.com/libp2p/go-libp2p/p2p/transport/webtransport"
 	"github.com/prometheus/client_golang/prometheus"
 
@@ -43,6 +48,13 @@ import (
 // addrChangeTickrInterval is the interval between two address change ticks.
 var addrChangeTickrInterval = 5 * time.Second
 
+// reconnectTimeout is the timeout for redialing a peer after a network change.
+var reconnectTimeout = 15 * time.Second
+
+// staleConnProbeTimeout is the time a connection that may have lost its
+// interface has to answer a ping after a network change.
+var staleConnProbeTimeout = 5 * time.Second
+
 var log = logging.Logger("basichost")
 
 var (
@@ -51,12 +63,28 @@ var (
 
 	// DefaultAddrsFactory is the default value for HostOpts.AddrsFactory.
 	DefaultAddrsFactory = func(addrs []ma.Multiaddr) []ma.Multiaddr { return addrs }
+
+	// DefaultLowPowerProfile is the default value for HostOpts.LowPowerProfile.
+	DefaultLowPowerProfile = event.PowerProfile{
+		AddrUpdateInterval:            time.Minute,
+		IdentifyPushMinInterval:       time.Minute,
+		AutoNATRetryInterval:          10 * time.Minute,
+		AutoNATRefreshInterval:        time.Hour,
+		RelayReservationRefreshMargin: 90 * time.Second,
+		KeepAliveInterval:             2 * time.Minute,
+	}
 )
 
 // AddrsFactory functions can be passed to New in order to override
 // addresses returned by Addrs.
 type AddrsFactory func([]ma.Multiaddr) []ma.Multiaddr
 
+// ExternalAddrSource is a source of external addresses of the host that are
+// discovered without the help of other peers, e.g. using STUN.
+type ExternalAddrSource interface {
+	ExternalAddrs() []ma.Multiaddr
+}
+
 // BasicHost is the basic implementation of the host.Host interface. This
 // particular host implementation:
 //   - uses a protocol muxer to mux per-protocol streams
@@ -75,21 +103,42 @@ type BasicHost struct {
 	mux          *msmux.MultistreamMuxer[protocol.ID]
 	ids          identify.IDService
 	hps          *holepunch.Service
+	attestation  *attestation.Service
 	pings        *ping.PingService
 	natmgr       NATManager
 	maResolver   *madns.Resolver
 	cmgr         connmgr.ConnManager
 	eventbus     event.Bus
-	relayManager *relaysvc.RelayManager
+	relayManager io.Closer
+	netmon       *netmon.Monitor
 
-	AddrsFactory AddrsFactory
+	AddrsFactory  AddrsFactory
+	externalAddrs []ExternalAddr
 
-	negtimeout time.Duration
+	negtimeout      time.Duration
+	negTimeouts     protocolTimeouts
+	handlerTimeouts protocolTimeouts
 
 	emitters struct {
-		evtLocalProtocolsUpdated event.Emitter
-		evtLocalAddrsUpdated     event.Emitter
-	}
+		evtLocalProtocolsUpdated  event.Emitter
+		evtLocalAddrsUpdated      event.Emitter
+		evtListenAddrExpansions   event.Emitter
+		evtLocalPowerStateChanged event.Emitter
+		evtLocalPeerRecordUpdated event.Emitter
+		evtPanicRecovered         event.Emitter
+	}
+	unregisterPanicHook func()
+
+	lowPowerProfile event.PowerProfile
+	healthCriteria  HealthCriteria
+	recoverPanics   bool
+	powerMu         sync.Mutex
+	lowPower        bool
+	// keepAliveInterval is the keep-alive interval of the low power profile
+	// while in low power mode, and 0 otherwise
+	keepAliveInterval time.Duration
+	// addrUpdateInterval is the current interval between two address change ticks, in nanoseconds
+	addrUpdateInterval atomic.Int64
 
 	addrChangeChan chan struct{}
 
@@ -102,9 +151,37 @@ type BasicHost struct {
 	caBook                  peerstore.CertifiedAddrBook
 
 	autoNat autonat.AutoNAT
+
+	externalAddrSources []ExternalAddrSource
+
+	protoOwnersMu sync.Mutex
+	// protoOwners holds the owners of the protocols registered using RegisterProtocols.
+	protoOwners map[protocol.ID]*protocolRegistration
+	// protoShims holds the shims added using AddProtocolShim, keyed by the
+	// protocol they accept.
+	protoShims map[protocol.ID]*protocolShim
+	// protoHandlers holds the handlers set through the host, so that shims
+	// can find the handler of the protocol they translate to.
+	protoHandlers map[protocol.ID]protocol.HandlerFunc
+
+	protoInterner protocolInterner
+	// negCache is nil unless EnableNegotiationCache is set.
+	negCache       *negotiationCache
+	negCacheNotifs *network.NotifyBundle
+	negFailures    negotiationFailures
+	streamOpenSLOs streamOpenSLOs
+	addrChanges    addrChanges
 }
 
-var _ host.Host = (*BasicHost)(nil)
+var (
+	_ host.Host                  = (*BasicHost)(nil)
+	_ host.NetworkChangeSignaler = (*BasicHost)(nil)
+	_ host.PowerStateSetter      = (*BasicHost)(nil)
+	_ host.ProtocolRegistrar     = (*BasicHost)(nil)
+	_ host.HealthReporter        = (*BasicHost)(nil)
+	_ host.ProtocolShimRegistry  = (*BasicHost)(nil)
+	_ host.StreamOpenSLOSetter   = (*BasicHost)(nil)
+)
 
 // HostOpts holds options that can be passed to NewHost in order to
 // customize construction of the *BasicHost.
@@ -118,12 +195,27 @@ type HostOpts struct {
 	// NegotiationTimeout determines the read and write timeouts on streams.
 	// If 0 or omitted, it will use DefaultNegotiationTimeout.
 	// If below 0, timeouts on streams will be deactivated.
+	// Inbound streams that time out are counted in the
+	// libp2p_host_stream_timeouts_total metric.
 	NegotiationTimeout time.Duration
 
+	// NegotiationTimeouts overrides NegotiationTimeout for the inbound
+	// streams of some protocols. See BasicHost.SetNegotiationTimeout.
+	NegotiationTimeouts map[protocol.ID]time.Duration
+
+	// HandlerTimeouts limits the time the handlers of inbound streams may run,
+	// per protocol. See BasicHost.SetHandlerTimeout.
+	HandlerTimeouts map[protocol.ID]time.Duration
+
 	// AddrsFactory holds a function which can be used to override or filter the result of Addrs.
 	// If omitted, there's no override or filtering, and the results of Addrs and AllAddrs are the same.
 	AddrsFactory AddrsFactory
 
+	// ExternalAddrs map the addresses of listeners behind a reverse proxy or
+	// load balancer to the address they are reachable at. The mapping is
+	// applied to the result of AllAddrs, before AddrsFactory.
+	ExternalAddrs []ExternalAddr
+
 	// MultiaddrResolves holds the go-multiaddr-dns.Resolver used for resolving
 	// /dns4, /dns6, and /dnsaddr addresses before trying to connect to a peer.
 	MultiaddrResolver *madns.Resolver
@@ -152,15 +244,74 @@ type HostOpts struct {
 	// DisableSignedPeerRecord disables the generation of Signed Peer Records on this host.
 	DisableSignedPeerRecord bool
 
+	// IdentifyLimits limits the size of the identify messages sent by this host.
+	IdentifyLimits identify.Limits
+	// IdentifyOptions are additional options for the identify service. They
+	// are applied after the options derived from the other fields.
+	IdentifyOptions []identify.Option
+
+	// ServiceScopes attaches built-in services to dedicated resource manager
+	// service scopes. The map is keyed by the default service name of the
+	// service, identify.ServiceName or ping.ServiceName.
+	ServiceScopes map[string]ServiceScope
+
+	// EnableNegotiationCache makes the host remember the protocols a peer
+	// accepted and rejected on each connection, to avoid negotiation round
+	// trips on new streams.
+	EnableNegotiationCache bool
+
 	// EnableHolePunching enables the peer to initiate/respond to hole punching attempts for NAT traversal.
 	EnableHolePunching bool
 	// HolePunchingOptions are options for the hole punching service
 	HolePunchingOptions []holepunch.Option
 
+	// EnableAddrAttestation enables exchanging signed address attestations
+	// with other peers. Collected attestations are included in the signed
+	// peer record. See the attestation package for details.
+	EnableAddrAttestation bool
+	// AddrAttestationOptions are options for the address attestation service
+	AddrAttestationOptions []attestation.Option
+
+	// EnableNetworkMonitor enables monitoring the local network interfaces for
+	// changes. See the netmon package for details.
+	EnableNetworkMonitor bool
+	// NetworkMonitorOptions are options for the network monitor
+	NetworkMonitorOptions []netmon.Option
+
+	// LowPowerProfile is the profile applied by SetPowerState when switching to
+	// low power mode. If omitted, DefaultLowPowerProfile is used.
+	LowPowerProfile *event.PowerProfile
+
+	// HealthCriteria are the criteria evaluated by Health. If omitted,
+	// DefaultHealthCriteria is used.
+	HealthCriteria *HealthCriteria
+
+	// RecoverHandlerPanics recovers panics in stream handlers: the stream of a
+	// panicking handler is reset and an EvtPanicRecovered is emitted, instead
+	// of crashing the process.
+	RecoverHandlerPanics bool
+
 	// EnableMetrics enables the metrics subsystems
 	EnableMetrics bool
 	// PrometheusRegisterer is the PrometheusRegisterer used for metrics
 	PrometheusRegisterer prometheus.Registerer
+	// PrometheusRegisterers overrides PrometheusRegisterer for single subsystems.
+	PrometheusRegisterers map[metricshelper.Subsystem]prometheus.Registerer
+	// DisabledMetricsLabels are dropped from all metrics, see metricshelper.LabelFilter.
+	DisabledMetricsLabels []string
+}
+
+// ServiceScope is a resource manager service scope for a built-in service.
+type ServiceScope struct {
+	// Name is the name of the service scope. Its limits are configured in the
+	// resource manager.
+	Name string
+	// ReservationPriority is the priority of the memory reservations of the
+	// service's streams. Reservations with a lower priority fail earlier
+	// when the memory of the peer or the system is scarce, see
+	// network.ReservationPriorityLow. If 0, network.ReservationPriorityAlways
+	// is used, as for the services that don't have a dedicated scope.
+	ReservationPriority uint8
 }
 
 // NewHost constructs a new *BasicHost and activates it by attaching its stream and connection handlers to the given inet.Network.
@@ -171,6 +322,11 @@ func NewHost(n network.Network, opts *HostOpts) (*BasicHost, error) {
 	if opts.EventBus == nil {
 		opts.EventBus = eventbus.NewBus()
 	}
+	for _, e := range opts.ExternalAddrs {
+		if err := e.validate(); err != nil {
+			return nil, fmt.Errorf("invalid external address: %w", err)
+		}
+	}
 
 	psManager, err := pstoremanager.NewPeerstoreManager(n.Peerstore(), opts.EventBus, n)
 	if err != nil {
@@ -189,7 +345,30 @@ func NewHost(n network.Network, opts *HostOpts) (*BasicHost, error) {
 		ctx:                     hostCtx,
 		ctxCancel:               cancel,
 		disableSignedPeerRecord: opts.DisableSignedPeerRecord,
+		lowPowerProfile:         DefaultLowPowerProfile,
+		healthCriteria:          DefaultHealthCriteria,
+		recoverPanics:           opts.RecoverHandlerPanics,
+	}
+	for pid, d := range opts.NegotiationTimeouts {
+		h.negTimeouts.set(pid, d)
+	}
+	for pid, d := range opts.HandlerTimeouts {
+		h.handlerTimeouts.set(pid, d)
+	}
+	if opts.LowPowerProfile != nil {
+		h.lowPowerProfile = *opts.LowPowerProfile
+	}
+	if opts.HealthCriteria != nil {
+		h.healthCriteria = *opts.HealthCriteria
+	}
+	if opts.EnableNegotiationCache {
+		h.negCache = newNegotiationCache()
+		h.negCacheNotifs = &network.NotifyBundle{
+			DisconnectedF: func(_ network.Network, c network.Conn) { h.negCache.removeConn(c) },
+		}
+		n.Notify(h.negCacheNotifs)
 	}
+	h.addrUpdateInterval.Store(int64(addrChangeTickrInterval))
 
 	h.updateLocalIpAddr()
 
@@ -199,6 +378,18 @@ func NewHost(n network.Network, opts *HostOpts) (*BasicHost, error) {
 	if h.emitters.evtLocalAddrsUpdated, err = h.eventbus.Emitter(&event.EvtLocalAddressesUpdated{}, eventbus.Stateful); err != nil {
 		return nil, err
 	}
+	if h.emitters.evtListenAddrExpansions, err = h.eventbus.Emitter(&event.EvtListenAddrExpansionsUpdated{}, eventbus.Stateful); err != nil {
+		return nil, err
+	}
+	if h.emitters.evtLocalPowerStateChanged, err = h.eventbus.Emitter(&event.EvtLocalPowerStateChanged{}, eventbus.Stateful); err != nil {
+		return nil, err
+	}
+	if h.emitters.evtLocalPeerRecordUpdated, err = h.eventbus.Emitter(&event.EvtLocalPeerRecordUpdated{}, eventbus.Stateful); err != nil {
+		return nil, err
+	}
+	if h.emitters.evtPanicRecovered, err = h.eventbus.Emitter(&event.EvtPanicRecovered{}); err != nil {
+		return nil, err
+	}
 
 	if !h.disableSignedPeerRecord {
 		cab, ok := peerstore.GetCertifiedAddrBook(n.Peerstore())
@@ -233,18 +424,27 @@ func NewHost(n network.Network, opts *HostOpts) (*BasicHost, error) {
 	idOpts := []identify.Option{
 		identify.UserAgent(opts.UserAgent),
 		identify.ProtocolVersion(opts.ProtocolVersion),
+		identify.WithLimits(opts.IdentifyLimits),
 	}
 
 	// we can't set this as a default above because it depends on the *BasicHost.
 	if h.disableSignedPeerRecord {
 		idOpts = append(idOpts, identify.DisableSignedPeerRecord())
 	}
+	if scope, ok := opts.ServiceScopes[identify.ServiceName]; ok {
+		idOpts = append(idOpts, identify.WithServiceScope(scope.Name, scope.ReservationPriority))
+	}
+	registerers := metricshelper.Registerers{Default: opts.PrometheusRegisterer, Subsystems: opts.PrometheusRegisterers}
 	if opts.EnableMetrics {
 		idOpts = append(idOpts,
 			identify.WithMetricsTracer(
-				identify.NewMetricsTracer(identify.WithRegisterer(opts.PrometheusRegisterer))))
+				identify.NewMetricsTracer(identify.WithRegisterer(registerers.For(metricshelper.SubsystemIdentify)))))
+		addrcheck.RegisterMetrics(registerers.For(metricshelper.SubsystemAddrCheck))
+		metricshelper.RegisterCollectors(registerers.For(metricshelper.SubsystemHost), negotiationFailuresTotal, addrChangesTotal, advertisedAddrs, streamTimeoutsTotal, protocolShimStreamsTotal, streamOpenDuration, streamOpenSLOViolationsTotal)
 	}
 
+	idOpts = append(idOpts, opts.IdentifyOptions...)
+
 	h.ids, err = identify.NewIDService(h, idOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Identify service: %s", err)
@@ -253,7 +453,9 @@ func NewHost(n network.Network, opts *HostOpts) (*BasicHost, error) {
 	if opts.EnableHolePunching {
 		if opts.EnableMetrics {
 			hpOpts := []holepunch.Option{
-				holepunch.WithMetricsTracer(holepunch.NewMetricsTracer(holepunch.WithRegisterer(opts.PrometheusRegisterer)))}
+				holepunch.WithMetricsTracer(holepunch.NewMetricsTracer(
+					holepunch.WithRegisterer(registerers.For(metricshelper.SubsystemHolePunch)),
+					holepunch.WithDisabledLabels(opts.DisabledMetricsLabels...)))}
 			opts.HolePunchingOptions = append(hpOpts, opts.HolePunchingOptions...)
 
 		}
@@ -263,6 +465,13 @@ func NewHost(n network.Network, opts *HostOpts) (*BasicHost, error) {
 		}
 	}
 
+	if opts.EnableAddrAttestation {
+		h.attestation, err = attestation.NewService(h, opts.AddrAttestationOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create address attestation service: %w", err)
+		}
+	}
+
 	if uint64(opts.NegotiationTimeout) != 0 {
 		h.negtimeout = opts.NegotiationTimeout
 	}
@@ -270,6 +479,7 @@ func NewHost(n network.Network, opts *HostOpts) (*BasicHost, error) {
 	if opts.AddrsFactory != nil {
 		h.AddrsFactory = opts.AddrsFactory
 	}
+	h.externalAddrs = opts.ExternalAddrs
 
 	if opts.NATManager != nil {
 		h.natmgr = opts.NATManager(n)
@@ -291,14 +501,29 @@ func NewHost(n network.Network, opts *HostOpts) (*BasicHost, error) {
 			// Prefer explicitly provided metrics tracer
 			metricsOpt := []relayv2.Option{
 				relayv2.WithMetricsTracer(
-					relayv2.NewMetricsTracer(relayv2.WithRegisterer(opts.PrometheusRegisterer)))}
+					relayv2.NewMetricsTracer(relayv2.WithRegisterer(registerers.For(metricshelper.SubsystemRelayService))))}
 			opts.RelayServiceOpts = append(metricsOpt, opts.RelayServiceOpts...)
 		}
-		h.relayManager = relaysvc.NewRelayManager(h, opts.RelayServiceOpts...)
+		h.relayManager = newRelayService(h, opts.RelayServiceOpts)
 	}
 
 	if opts.EnablePing {
-		h.pings = ping.NewPingService(h)
+		var pingOpts []ping.Option
+		if scope, ok := opts.ServiceScopes[ping.ServiceName]; ok {
+			pingOpts = append(pingOpts, ping.WithServiceScope(scope.Name, scope.ReservationPriority))
+		}
+		h.pings = ping.NewPingService(h, pingOpts...)
+	}
+
+	netmonOpts := opts.NetworkMonitorOptions
+	if !opts.EnableNetworkMonitor {
+		// Without the network monitor, we only check for changes when
+		// SignalNetworkChange is called.
+		netmonOpts = []netmon.Option{netmon.WithPollInterval(0), netmon.DisablePlatformNotifications()}
+	}
+	h.netmon, err = netmon.NewMonitor(h.eventbus, netmonOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create network monitor: %w", err)
 	}
 
 	n.SetStreamHandler(h.newStreamHandler)
@@ -311,6 +536,22 @@ func NewHost(n network.Network, opts *HostOpts) (*BasicHost, error) {
 	n.Notify(&network.NotifyBundle{
 		ListenF:      listenHandler,
 		ListenCloseF: listenHandler,
+		ConnectedF: func(_ network.Network, c network.Conn) {
+			h.powerMu.Lock()
+			defer h.powerMu.Unlock()
+			if h.keepAliveInterval > 0 {
+				setKeepAliveInterval(c, h.keepAliveInterval)
+			}
+		},
+	})
+
+	h.unregisterPanicHook = panics.RegisterHook(func(r panics.Report) {
+		h.emitters.evtPanicRecovered.Emit(event.EvtPanicRecovered{
+			Subsystem: r.Subsystem,
+			Peer:      r.Peer,
+			Panic:     fmt.Sprint(r.Value),
+			Stack:     r.Stack,
+		})
 	})
 
 	return h, nil
@@ -389,7 +630,161 @@ func (h *BasicHost) Start() {
 	h.psManager.Start()
 	h.refCount.Add(1)
 	h.ids.Start()
+	h.netmon.Start()
 	go h.background()
+
+	// Connection managers that dial to stay above their low watermark need
+	// the network to dial on, see connmgr.BasicConnMgr.StartMaintainer.
+	if m, ok := h.cmgr.(interface{ StartMaintainer(network.Network) }); ok {
+		m.StartMaintainer(h.Network())
+	}
+
+	// Also subscribe if the network monitor is disabled. Users might be emitting these events themselves.
+	sub, err := eventbus.SubscribeTyped[event.EvtLocalInterfaceAddrsChanged](h.eventbus, eventbus.Name("basichost"))
+	if err != nil {
+		log.Warnf("subscription failed. Not reacting to network changes. Error: %s", err)
+		return
+	}
+	h.refCount.Add(1)
+	go h.handleInterfaceAddrsChanges(sub)
+}
+
+// SignalNetworkChange signals to the host that the network configuration of
+// the device might have changed (e.g. after switching from WiFi to cellular).
+// The host then re-reads the interface addresses, and handles the change the
+// same way as changes detected by the network monitor. This works regardless
+// of whether the network monitor is enabled.
+//
+// This is intended to be called from the platform's connectivity callbacks on
+// platforms where changes aren't detected automatically.
+func (h *BasicHost) SignalNetworkChange() {
+	h.netmon.Trigger()
+}
+
+// handleInterfaceAddrsChanges reacts to changes of the local interface addresses.
+// It updates our addresses and closes connections that use a local address that
+// has been removed, unless their transport migrates them (see
+// transport.CapNetworkMigration). Peers that lost all their connections are
+// redialed, so that connectivity is restored quickly instead of waiting for the
+// connections to time out.
+func (h *BasicHost) handleInterfaceAddrsChanges(sub *eventbus.TypedSubscription[event.EvtLocalInterfaceAddrsChanged]) {
+	defer h.refCount.Done()
+	defer sub.Close()
+
+	for {
+		select {
+		case evt, ok := <-sub.Out():
+			if !ok {
+				return
+			}
+			h.updateLocalIpAddr()
+			h.SignalAddressChange()
+			if len(evt.Removed) > 0 {
+				h.replaceStaleConns(evt.Removed)
+			}
+		case <-h.ctx.Done():
+			return
+		}
+	}
+}
+
+// replaceStaleConns closes the connections that were using one of the removed
+// interface addresses, and reconnects to their peers.
+//
+// Connections on sockets bound to all interfaces, like QUIC connections
+// sharing the listener's socket, don't tell which interface they use. quic-go
+// doesn't migrate connections to a new path, so these connections are probed
+// with a ping instead, and the ones that stopped working are replaced.
+func (h *BasicHost) replaceStaleConns(removed []ma.Multiaddr) {
+	removedIPs := make(map[string]struct{}, len(removed))
+	for _, a := range removed {
+		if ip, err := manet.ToIP(a); err == nil {
+			removedIPs[ip.String()] = struct{}{}
+		}
+	}
+
+	affected := make(peer.IDSet)
+	var unbound []network.Conn
+	for _, c := range h.Network().Conns() {
+		ip, err := manet.ToIP(c.LocalMultiaddr())
+		if err != nil {
+			continue
+		}
+		_, stale := removedIPs[ip.String()]
+		if !stale && !ip.IsUnspecified() {
+			continue
+		}
+		if r, ok := h.Network().(transport.CapabilityResolver); ok {
+			if caps, _ := r.TransportCapabilities(c.LocalMultiaddr()); caps.Has(transport.CapNetworkMigration) {
+				continue
+			}
+		}
+		if !stale {
+			unbound = append(unbound, c)
+			continue
+		}
+		log.Debugw("closing connection bound to removed interface address", "peer", c.RemotePeer().Short(), "local", c.LocalMultiaddr())
+		affected.Add(c.RemotePeer())
+		c.Close()
+	}
+	h.reconnect(affected)
+
+	if len(unbound) > 0 {
+		h.refCount.Add(1)
+		go func() {
+			defer h.refCount.Done()
+			h.reconnect(h.closeBrokenConns(unbound))
+		}()
+	}
+}
+
+// closeBrokenConns pings the connections concurrently, and closes the ones
+// that don't answer within staleConnProbeTimeout. It returns the peers of the
+// closed connections.
+func (h *BasicHost) closeBrokenConns(conns []network.Conn) peer.IDSet {
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		broken = make(peer.IDSet)
+	)
+	for _, c := range conns {
+		wg.Add(1)
+		go func(c network.Conn) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(h.ctx, staleConnProbeTimeout)
+			defer cancel()
+			_, err := ping.PingConn(ctx, c)
+			// A peer refusing the ping protocol still answered on the connection.
+			if err == nil || errors.Is(err, msmux.ErrNotSupported[protocol.ID]{}) || h.ctx.Err() != nil {
+				return
+			}
+			log.Debugw("closing connection that stopped working after a network change", "peer", c.RemotePeer().Short(), "local", c.LocalMultiaddr(), "error", err)
+			c.Close()
+			mu.Lock()
+			broken.Add(c.RemotePeer())
+			mu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+	return broken
+}
+
+// reconnect redials the peers that aren't connected anymore.
+func (h *BasicHost) reconnect(peers peer.IDSet) {
+	for p := range peers {
+		if h.Network().Connectedness(p) == network.Connected {
+			continue
+		}
+		h.refCount.Add(1)
+		go func(p peer.ID) {
+			defer h.refCount.Done()
+			ctx, cancel := context.WithTimeout(h.ctx, reconnectTimeout)
+			defer cancel()
+			if err := h.Connect(ctx, peer.AddrInfo{ID: p}); err != nil {
+				log.Debugw("failed to reconnect after network change", "peer", p.Short(), "error", err)
+			}
+		}(p)
+	}
 }
 
 // newStreamHandler is the remote-opened stream handler for network.Network
@@ -399,45 +794,75 @@ func (h *BasicHost) newStreamHandler(s network.Stream) {
 
 	if h.negtimeout > 0 {
 		if err := s.SetDeadline(time.Now().Add(h.negtimeout)); err != nil {
-			log.Debug("setting stream deadline: ", err)
+			log.Debugw("setting stream deadline", "error", err, "stream", s.ID())
 			s.Reset()
 			return
 		}
 	}
 
-	protoID, handle, err := h.Mux().Negotiate(s)
+	// The negotiation timeout is the one of the protocol proposed last by the
+	// remote peer, see SetNegotiationTimeout.
+	timeout := h.negtimeout
+	rec := &offerRecorder{ReadWriteCloser: s}
+	rec.onOffer = func(pid protocol.ID) {
+		d := h.negTimeouts.get(pid)
+		if d == 0 {
+			d = h.negtimeout
+		}
+		if d == timeout {
+			return
+		}
+		timeout = d
+		var deadline time.Time
+		if d > 0 {
+			deadline = before.Add(d)
+		}
+		if err := s.SetDeadline(deadline); err != nil {
+			log.Debugw("setting stream deadline", "error", err, "stream", s.ID())
+		}
+	}
+	protoID, handle, err := h.Mux().Negotiate(rec)
 	took := time.Since(before)
 	if err != nil {
+		h.recordInboundFailure(s, rec)
+		if isTimeout(err) {
+			streamTimeoutsTotal.WithLabelValues("negotiation", "").Inc()
+		}
 		if err == io.EOF {
 			logf := log.Debugf
 			if took > time.Second*10 {
 				logf = log.Warnf
 			}
-			logf("protocol EOF: %s (took %s)", s.Conn().RemotePeer(), took)
+			logf("protocol EOF: %s (stream %s, took %s)", s.Conn().RemotePeer(), s.ID(), took)
 		} else {
-			log.Debugf("protocol mux failed: %s (took %s, id:%s, remote peer:%s, remote addr:%v)", err, took, s.ID(), s.Conn().RemotePeer(), s.Conn().RemoteMultiaddr())
+			log.Debugw("protocol mux failed", "error", err, "took", took, "stream", s.ID(), "peer", s.Conn().RemotePeer().Short(), "addr", s.Conn().RemoteMultiaddr())
 		}
 		s.Reset()
 		return
 	}
 
-	if h.negtimeout > 0 {
+	if timeout > 0 {
 		if err := s.SetDeadline(time.Time{}); err != nil {
-			log.Debugf("resetting stream deadline: ", err)
+			log.Debugw("resetting stream deadline", "error", err, "stream", s.ID())
 			s.Reset()
 			return
 		}
 	}
 
+	protoID = h.protoInterner.intern(protoID)
 	if err := s.SetProtocol(protoID); err != nil {
-		log.Debugf("error setting stream protocol: %s", err)
+		log.Debugw("error setting stream protocol", "error", err, "stream", s.ID())
 		s.Reset()
 		return
 	}
 
-	log.Debugf("negotiated: %s (took %s)", protoID, took)
+	log.Debugw("negotiated protocol", "protocol", protoID, "took", took, "stream", s.ID())
 
-	handle(protoID, s)
+	if h.recoverPanics {
+		// A panicking handler only resets its stream.
+		defer panics.Recover(string(protoID), s.Conn().RemotePeer(), func() { s.Reset() })
+	}
+	h.runHandler(protoID, s, handle)
 }
 
 // SignalAddressChange signals to the host that it needs to determine whether our listen addresses have recently
@@ -487,17 +912,44 @@ func (h *BasicHost) makeSignedPeerRecord(evt *event.EvtLocalAddressesUpdated) (*
 	for _, a := range evt.Current {
 		current = append(current, a.Address)
 	}
+	return h.signPeerRecord(current)
+}
 
+func (h *BasicHost) signPeerRecord(addrs []ma.Multiaddr) (*record.Envelope, error) {
 	rec := peer.PeerRecordFromAddrInfo(peer.AddrInfo{
 		ID:    h.ID(),
-		Addrs: current,
+		Addrs: addrs,
 	})
+	if h.attestation != nil {
+		rec.Attestations = h.attestation.Attestations(addrs)
+	}
 	return record.Seal(rec, h.signKey)
 }
 
+// updateSignedPeerRecord signs and persists a new peer record for unchanged
+// addresses, to include new address attestations.
+func (h *BasicHost) updateSignedPeerRecord(addrs []ma.Multiaddr) {
+	if h.disableSignedPeerRecord {
+		return
+	}
+	sr, err := h.signPeerRecord(addrs)
+	if err != nil {
+		log.Errorf("error creating a signed peer record, err=%s", err)
+		return
+	}
+	if _, err := h.caBook.ConsumePeerRecord(sr, peerstore.PermanentAddrTTL); err != nil {
+		log.Errorf("failed to persist signed peer record in peer store, err=%s", err)
+		return
+	}
+	if err := h.emitters.evtLocalPeerRecordUpdated.Emit(event.EvtLocalPeerRecordUpdated{SignedPeerRecord: sr}); err != nil {
+		log.Warnf("error emitting event for updated peer record: %s", err)
+	}
+}
+
 func (h *BasicHost) background() {
 	defer h.refCount.Done()
 	var lastAddrs []ma.Multiaddr
+	var lastExpansions []event.ListenAddrExpansion
 
 	emitAddrChange := func(currentAddrs []ma.Multiaddr, lastAddrs []ma.Multiaddr) {
 		// nothing to do if both are nil..defensive check
@@ -510,6 +962,9 @@ func (h *BasicHost) background() {
 		if changeEvt == nil {
 			return
 		}
+		added, removed := DiffAddrs(lastAddrs, currentAddrs)
+		h.addrChanges.add(AddrChange{Time: time.Now(), Added: added, Removed: removed, Total: len(currentAddrs)})
+		log.Debugw("advertised addresses changed", "added", added, "removed", removed)
 
 		if !h.disableSignedPeerRecord {
 			// add signed peer record to the event
@@ -535,10 +990,20 @@ func (h *BasicHost) background() {
 
 	// periodically schedules an IdentifyPush to update our peers for changes
 	// in our address set (if needed)
-	ticker := time.NewTicker(addrChangeTickrInterval)
+	tickInterval := time.Duration(h.addrUpdateInterval.Load())
+	ticker := time.NewTicker(tickInterval)
 	defer ticker.Stop()
 
+	var attestationsUpdated <-chan struct{}
+	if h.attestation != nil {
+		attestationsUpdated = h.attestation.Updated()
+	}
+
 	for {
+		if d := time.Duration(h.addrUpdateInterval.Load()); d != tickInterval {
+			tickInterval = d
+			ticker.Reset(tickInterval)
+		}
 		if len(h.network.ListenAddresses()) > 0 {
 			h.updateLocalIpAddr()
 		}
@@ -548,15 +1013,71 @@ func (h *BasicHost) background() {
 		emitAddrChange(curr, lastAddrs)
 		lastAddrs = curr
 
+		if expansions := h.listenAddrExpansions(curr); !listenAddrExpansionsEqual(expansions, lastExpansions) {
+			if err := h.emitters.evtListenAddrExpansions.Emit(event.EvtListenAddrExpansionsUpdated{Expansions: expansions}); err != nil {
+				log.Warnf("error emitting event for updated listen addr expansions: %s", err)
+			}
+			lastExpansions = expansions
+		}
+
 		select {
 		case <-ticker.C:
 		case <-h.addrChangeChan:
+		case <-attestationsUpdated:
+			h.updateSignedPeerRecord(lastAddrs)
 		case <-h.ctx.Done():
 			return
 		}
 	}
 }
 
+// SetPowerState switches the host between normal and low power operation.
+//
+// In low power mode, background activity is throttled according to the
+// host's low power profile (see HostOpts.LowPowerProfile): address updates
+// are checked (and pushed to peers via identify) less frequently, AutoNAT
+// probes less often, relay reservations are refreshed closer to their
+// expiry, and stream multiplexers send fewer keep-alives. This is intended for
+// battery-powered devices, e.g. when a mobile application moves to the
+// background.
+func (h *BasicHost) SetPowerState(lowPower bool) {
+	h.powerMu.Lock()
+	defer h.powerMu.Unlock()
+	if h.lowPower == lowPower {
+		return
+	}
+	h.lowPower = lowPower
+
+	evt := event.EvtLocalPowerStateChanged{LowPower: lowPower}
+	addrUpdateInterval := addrChangeTickrInterval
+	if lowPower {
+		evt.Profile = h.lowPowerProfile
+		if evt.Profile.AddrUpdateInterval > 0 {
+			addrUpdateInterval = evt.Profile.AddrUpdateInterval
+		}
+	}
+	h.addrUpdateInterval.Store(int64(addrUpdateInterval))
+	h.keepAliveInterval = evt.Profile.KeepAliveInterval
+	for _, c := range h.Network().Conns() {
+		setKeepAliveInterval(c, h.keepAliveInterval)
+	}
+	// Wake up the background loop so it picks up the new interval.
+	h.SignalAddressChange()
+	if err := h.emitters.evtLocalPowerStateChanged.Emit(evt); err != nil {
+		log.Warnf("error emitting power state changed event: %s", err)
+	}
+}
+
+func setKeepAliveInterval(c network.Conn, d time.Duration) {
+	kc, ok := c.(network.KeepAliveConn)
+	if !ok {
+		return
+	}
+	if err := kc.SetKeepAliveInterval(d); err != nil && !errors.Is(err, network.ErrKeepAliveNotSupported) {
+		log.Debugw("failed to set keep-alive interval", "peer", c.RemotePeer().Short(), "error", err)
+	}
+}
+
 // ID returns the (local) peer.ID associated with this Host
 func (h *BasicHost) ID() peer.ID {
 	return h.Network().LocalPeer()
@@ -593,11 +1114,13 @@ func (h *BasicHost) EventBus() event.Bus {
 //
 // (Thread-safe)
 func (h *BasicHost) SetStreamHandler(pid protocol.ID, handler network.StreamHandler) {
-	h.Mux().AddHandler(pid, func(p protocol.ID, rwc io.ReadWriteCloser) error {
+	hf := func(p protocol.ID, rwc io.ReadWriteCloser) error {
 		is := rwc.(network.Stream)
 		handler(is)
 		return nil
-	})
+	}
+	h.claimProtocol(pid, hf)
+	h.Mux().AddHandler(pid, hf)
 	h.emitters.evtLocalProtocolsUpdated.Emit(event.EvtLocalProtocolsUpdated{
 		Added: []protocol.ID{pid},
 	})
@@ -606,11 +1129,13 @@ func (h *BasicHost) SetStreamHandler(pid protocol.ID, handler network.StreamHand
 // SetStreamHandlerMatch sets the protocol handler on the Host's Mux
 // using a matching function to do protocol comparisons
 func (h *BasicHost) SetStreamHandlerMatch(pid protocol.ID, m func(protocol.ID) bool, handler network.StreamHandler) {
-	h.Mux().AddHandlerWithFunc(pid, m, func(p protocol.ID, rwc io.ReadWriteCloser) error {
+	hf := func(p protocol.ID, rwc io.ReadWriteCloser) error {
 		is := rwc.(network.Stream)
 		handler(is)
 		return nil
-	})
+	}
+	h.claimProtocol(pid, hf)
+	h.Mux().AddHandlerWithFunc(pid, m, hf)
 	h.emitters.evtLocalProtocolsUpdated.Emit(event.EvtLocalProtocolsUpdated{
 		Added: []protocol.ID{pid},
 	})
@@ -618,6 +1143,7 @@ func (h *BasicHost) SetStreamHandlerMatch(pid protocol.ID, m func(protocol.ID) b
 
 // RemoveStreamHandler returns ..
 func (h *BasicHost) RemoveStreamHandler(pid protocol.ID) {
+	h.disownProtocol(pid)
 	h.Mux().RemoveHandler(pid)
 	h.emitters.evtLocalProtocolsUpdated.Emit(event.EvtLocalProtocolsUpdated{
 		Removed: []protocol.ID{pid},
@@ -627,10 +1153,35 @@ func (h *BasicHost) RemoveStreamHandler(pid protocol.ID) {
 // NewStream opens a new stream to given peer p, and writes a p2p/protocol
 // header with given protocol.ID. If there is no connection to p, attempts
 // to create one. If ProtocolID is "", writes no header.
+//
+// The context's deadline bounds protocol negotiation. Use
+// network.WithStreamDeadline to also apply it to the returned stream, and
+// network.WithResetStreamOnCancel to reset the stream when the context is canceled.
 // (Thread-safe)
 func (h *BasicHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	start := time.Now()
+	s, err := h.newStream(ctx, p, pids...)
+	if err != nil {
+		h.recordStreamOpen(ctx, p, pids, "", start, err)
+		return nil, err
+	}
+	h.recordStreamOpen(ctx, p, pids, s.Protocol(), start, nil)
+	if reset, _ := network.GetResetStreamOnCancel(ctx); reset {
+		cs := &ctxStream{Stream: s}
+		cs.stop = context.AfterFunc(ctx, func() { cs.Stream.Reset() })
+		return cs, nil
+	}
+	return s, nil
+}
+
+func (h *BasicHost) newStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
 	// If the caller wants to prevent the host from dialing, it should use the NoDial option.
 	if nodial, _ := network.GetNoDial(ctx); !nodial {
+		// Let the dial ranker know which protocols the connection is for.
+		if intent, _ := network.GetIntent(ctx); len(intent.Protocols) == 0 {
+			intent.Protocols = pids
+			ctx = network.WithIntent(ctx, intent)
+		}
 		err := h.Connect(ctx, peer.AddrInfo{ID: p})
 		if err != nil {
 			return nil, err
@@ -642,7 +1193,7 @@ func (h *BasicHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.I
 		// TODO: It would be nicer to get the actual error from the swarm,
 		// but this will require some more work.
 		if errors.Is(err, network.ErrNoConn) {
-			return nil, errors.New("connection failed")
+			return nil, neterrors.Classify(neterrors.ErrNoConn, errors.New("connection failed"))
 		}
 		return nil, fmt.Errorf("failed to open stream: %w", err)
 	}
@@ -664,8 +1215,30 @@ func (h *BasicHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.I
 		_ = s.Reset()
 		return nil, err
 	}
+	if pref == "" && h.negCache != nil {
+		var remaining []protocol.ID
+		pref, remaining = h.negCache.preferred(s.Conn(), pids)
+		if pref == "" && len(remaining) == 0 {
+			_ = s.Reset()
+			return nil, neterrors.Negotiation(msmux.ErrNotSupported[protocol.ID]{Protos: pids})
+		}
+		pids = remaining
+	}
+
+	// Apply the context deadline to protocol negotiation. If requested, keep it as
+	// the stream's initial deadline, otherwise clear it once negotiation completes.
+	deadline, hasDeadline := ctx.Deadline()
+	keepDeadline, _ := network.GetStreamDeadline(ctx)
+	if hasDeadline {
+		if err := s.SetDeadline(deadline); err != nil {
+			log.Debugw("failed to set stream deadline", "error", err, "stream", s.ID())
+		}
+	}
 
 	if pref != "" {
+		if hasDeadline && !keepDeadline {
+			s.SetDeadline(time.Time{})
+		}
 		if err := s.SetProtocol(pref); err != nil {
 			return nil, err
 		}
@@ -685,19 +1258,31 @@ func (h *BasicHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.I
 	}()
 	select {
 	case err = <-errCh:
+		if errors.Is(err, msmux.ErrNotSupported[protocol.ID]{}) {
+			h.recordOutboundFailure(s.Conn(), pids)
+			if h.negCache != nil {
+				h.negCache.record(s.Conn(), pids, "")
+			}
+		}
 		if err != nil {
 			s.Reset()
-			return nil, fmt.Errorf("failed to negotiate protocol: %w", err)
+			return nil, neterrors.Negotiation(fmt.Errorf("failed to negotiate protocol: %w", err))
 		}
 	case <-ctx.Done():
 		s.Reset()
 		// wait for `SelectOneOf` to error out because of resetting the stream.
 		<-errCh
-		return nil, fmt.Errorf("failed to negotiate protocol: %w", ctx.Err())
+		return nil, neterrors.Negotiation(fmt.Errorf("failed to negotiate protocol: %w", ctx.Err()))
 	}
 
+	if hasDeadline && !keepDeadline {
+		s.SetDeadline(time.Time{})
+	}
 	s.SetProtocol(selected)
 	h.Peerstore().AddProtocols(p, selected)
+	if h.negCache != nil {
+		h.negCache.record(s.Conn(), pids, selected)
+	}
 	return s, nil
 }
 
@@ -720,8 +1305,9 @@ func (h *BasicHost) preferredProtocol(p peer.ID, pids []protocol.ID) (protocol.I
 // Connect will absorb the addresses in pi into its internal peerstore.
 // It will also resolve any /dns4, /dns6, and /dnsaddr addresses.
 func (h *BasicHost) Connect(ctx context.Context, pi peer.AddrInfo) error {
-	// absorb addresses into peerstore
-	h.Peerstore().AddAddrs(pi.ID, pi.Addrs, peerstore.TempAddrTTL)
+	// absorb addresses into peerstore. Addresses the peerstore doesn't know
+	// yet were provided by the application.
+	peerstore.AddNewAddrsFromSource(h.Peerstore(), pi.ID, addrcheck.Filter(addrcheck.BoundaryUser, pi.Addrs), peerstore.TempAddrTTL, peerstore.AddrSourceManual)
 
 	forceDirect, _ := network.GetForceDirectDial(ctx)
 	if !forceDirect {
@@ -768,13 +1354,14 @@ func (h *BasicHost) Addrs() []ma.Multiaddr {
 	// proper address pipeline, rework this. See the issue for more context.
 	type transportForListeninger interface {
 		TransportForListening(a ma.Multiaddr) transport.Transport
+		transport.CapabilityResolver
 	}
 
 	type addCertHasher interface {
 		AddCertHashes(m ma.Multiaddr) (ma.Multiaddr, bool)
 	}
 
-	addrs := h.AddrsFactory(h.AllAddrs())
+	addrs := h.AddrsFactory(mapExternalAddrs(h.AllAddrs(), h.externalAddrs))
 
 	s, ok := h.Network().(transportForListeninger)
 	if !ok {
@@ -787,9 +1374,7 @@ func (h *BasicHost) Addrs() []ma.Multiaddr {
 	copy(addrs, addrsOld)
 
 	for i, addr := range addrs {
-		wtOK, wtN := libp2pwebtransport.IsWebtransportMultiaddr(addr)
-		webrtcOK, webrtcN := libp2pwebrtc.IsWebRTCDirectMultiaddr(addr)
-		if (wtOK && wtN == 0) || (webrtcOK && webrtcN == 0) {
+		if transport.NeedsCerthash(s, addr) {
 			t := s.TransportForListening(addr)
 			tpt, ok := t.(addCertHasher)
 			if !ok {
@@ -912,6 +1497,13 @@ func (h *BasicHost) AllAddrs() []ma.Multiaddr {
 		}
 		finalAddrs = append(finalAddrs, observedAddrs...)
 	}
+
+	h.addrMu.RLock()
+	externalAddrSources := h.externalAddrSources
+	h.addrMu.RUnlock()
+	for _, s := range externalAddrSources {
+		finalAddrs = append(finalAddrs, s.ExternalAddrs()...)
+	}
 	finalAddrs = ma.Unique(finalAddrs)
 	finalAddrs = inferWebtransportAddrsFromQuic(finalAddrs)
 
@@ -1000,6 +1592,15 @@ func (h *BasicHost) SetAutoNat(a autonat.AutoNAT) {
 	}
 }
 
+// AddExternalAddrSource adds a source of external addresses. Its addresses
+// are included in AllAddrs. Sources should call SignalAddressChange when their
+// addresses change.
+func (h *BasicHost) AddExternalAddrSource(s ExternalAddrSource) {
+	h.addrMu.Lock()
+	defer h.addrMu.Unlock()
+	h.externalAddrSources = append(h.externalAddrSources, s)
+}
+
 // GetAutoNat returns the host's AutoNAT service, if AutoNAT is enabled.
 func (h *BasicHost) GetAutoNat() autonat.AutoNAT {
 	h.addrMu.Lock()
@@ -1011,6 +1612,9 @@ func (h *BasicHost) GetAutoNat() autonat.AutoNAT {
 func (h *BasicHost) Close() error {
 	h.closeSync.Do(func() {
 		h.ctxCancel()
+		if h.negCacheNotifs != nil {
+			h.network.StopNotify(h.negCacheNotifs)
+		}
 		if h.natmgr != nil {
 			h.natmgr.Close()
 		}
@@ -1029,9 +1633,20 @@ func (h *BasicHost) Close() error {
 		if h.hps != nil {
 			h.hps.Close()
 		}
+		if h.attestation != nil {
+			h.attestation.Close()
+		}
+		if h.netmon != nil {
+			h.netmon.Close()
+		}
 
 		_ = h.emitters.evtLocalProtocolsUpdated.Close()
 		_ = h.emitters.evtLocalAddrsUpdated.Close()
+		_ = h.emitters.evtListenAddrExpansions.Close()
+		_ = h.emitters.evtLocalPowerStateChanged.Close()
+		_ = h.emitters.evtLocalPeerRecordUpdated.Close()
+		h.unregisterPanicHook()
+		_ = h.emitters.evtPanicRecovered.Close()
 
 		h.psManager.Close()
 		if h.Peerstore() != nil {
@@ -1054,11 +1669,22 @@ type streamWrapper struct {
 }
 
 func (s *streamWrapper) Read(b []byte) (int, error) {
-	return s.rw.Read(b)
+	n, err := s.rw.Read(b)
+	return n, lazyNegotiationError(err)
 }
 
 func (s *streamWrapper) Write(b []byte) (int, error) {
-	return s.rw.Write(b)
+	n, err := s.rw.Write(b)
+	return n, lazyNegotiationError(err)
+}
+
+// lazyNegotiationError classifies the error returned when the peer rejects
+// a protocol that was optimistically selected.
+func lazyNegotiationError(err error) error {
+	if err != nil && errors.Is(err, msmux.ErrNotSupported[protocol.ID]{}) {
+		return neterrors.Negotiation(err)
+	}
+	return err
 }
 
 func (s *streamWrapper) Close() error {
@@ -1076,3 +1702,20 @@ func (s *streamWrapper) CloseWrite() error {
 	}
 	return s.Stream.CloseWrite()
 }
+
+// ctxStream resets the stream when the context passed to NewStream is canceled,
+// see network.WithResetStreamOnCancel.
+type ctxStream struct {
+	network.Stream
+	stop func() bool
+}
+
+func (s *ctxStream) Close() error {
+	s.stop()
+	return s.Stream.Close()
+}
+
+func (s *ctxStream) Reset() error {
+	s.stop()
+	return s.Stream.Reset()
+}
@@ -1,9 +1,14 @@
 package basichost
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"reflect"
 	"sort"
 	"strings"
@@ -20,10 +25,14 @@ import (
 	"github.com/libp2p/go-libp2p/core/record"
 	"github.com/libp2p/go-libp2p/p2p/host/autonat"
 	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
 	swarmt "github.com/libp2p/go-libp2p/p2p/net/swarm/testing"
 	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
 
 	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	msmux "github.com/multiformats/go-multistream"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -167,6 +176,193 @@ func TestProtocolHandlerEvents(t *testing.T) {
 	assert(nil, []protocol.ID{protocol.TestingID})
 }
 
+func TestRegisterProtocols(t *testing.T) {
+	h1, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h1.Close()
+	h1.Start()
+	h2, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h2.Close()
+	h2.Start()
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+
+	handling := make(chan struct{})
+	unblock := make(chan struct{})
+	reg, err := host.RegisterProtocols(h2, map[protocol.ID]network.StreamHandler{
+		"/foo": func(s network.Stream) {
+			defer s.Close()
+			handling <- struct{}{}
+			<-unblock
+			s.Write([]byte("foo"))
+		},
+		"/bar": func(s network.Stream) { s.Close() },
+	})
+	require.NoError(t, err)
+	require.Equal(t, []protocol.ID{"/bar", "/foo"}, reg.Protocols())
+
+	// protocols can't be owned by multiple registrations
+	_, err = h2.RegisterProtocols(map[protocol.ID]network.StreamHandler{"/bar": func(s network.Stream) {}})
+	require.Error(t, err)
+
+	// handlers set using SetStreamHandler aren't overwritten, nor removed
+	// when closing the registration
+	h2.SetStreamHandler("/baz", func(s network.Stream) { s.Close() })
+	_, err = h2.RegisterProtocols(map[protocol.ID]network.StreamHandler{
+		"/baz":  func(s network.Stream) {},
+		"/quux": func(s network.Stream) {},
+	})
+	require.ErrorContains(t, err, "already has a handler")
+	require.Contains(t, h2.Mux().Protocols(), protocol.ID("/baz"))
+	require.NotContains(t, h2.Mux().Protocols(), protocol.ID("/quux"))
+
+	// setting a handler takes over the protocol
+	h2.SetStreamHandler("/bar", func(s network.Stream) { s.Close() })
+	require.Equal(t, []protocol.ID{"/foo"}, reg.Protocols())
+
+	s, err := h1.NewStream(context.Background(), h2.ID(), "/foo")
+	require.NoError(t, err)
+	defer s.Close()
+	<-handling
+
+	// Close waits for the in-flight handler to return
+	closed := make(chan error)
+	go func() { closed <- reg.Close() }()
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the handler")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(unblock)
+	require.NoError(t, <-closed)
+	b, err := io.ReadAll(s)
+	require.NoError(t, err)
+	require.Equal(t, "foo", string(b))
+
+	require.NotContains(t, h2.Mux().Protocols(), protocol.ID("/foo"))
+	require.Contains(t, h2.Mux().Protocols(), protocol.ID("/bar"))
+	require.Empty(t, reg.Protocols())
+	require.NoError(t, reg.Close())
+
+	// the protocol can be registered again once the registration is closed
+	reg, err = h2.RegisterProtocols(map[protocol.ID]network.StreamHandler{"/foo": func(s network.Stream) {}})
+	require.NoError(t, err)
+	require.NoError(t, reg.Close())
+}
+
+// upperStream adapts a stream by upper-casing the data written to it.
+type upperStream struct {
+	network.Stream
+}
+
+func (s upperStream) Write(b []byte) (int, error) {
+	return s.Stream.Write(bytes.ToUpper(b))
+}
+
+func TestProtocolShims(t *testing.T) {
+	h1, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h1.Close()
+	h1.Start()
+	h2, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h2.Close()
+	h2.Start()
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+
+	read := func(pid protocol.ID) (string, error) {
+		s, err := h1.NewStream(context.Background(), h2.ID(), pid)
+		if err != nil {
+			return "", err
+		}
+		defer s.Close()
+		b, err := io.ReadAll(s)
+		return string(b), err
+	}
+
+	require.NoError(t, host.AddProtocolShim(h2, host.ProtocolShim{
+		From:  "/myproto/1",
+		To:    "/myproto/2",
+		Adapt: func(s network.Stream) (network.Stream, error) { return upperStream{s}, nil },
+	}))
+	require.Contains(t, h2.Mux().Protocols(), protocol.ID("/myproto/1"))
+
+	// the handler of the target protocol can be set after the shim
+	_, err = read("/myproto/1")
+	require.Error(t, err)
+	h2.SetStreamHandler("/myproto/2", func(s network.Stream) {
+		defer s.Close()
+		s.Write([]byte("hello"))
+	})
+	out, err := read("/myproto/2")
+	require.NoError(t, err)
+	require.Equal(t, "hello", out)
+	out, err = read("/myproto/1")
+	require.NoError(t, err)
+	require.Equal(t, "HELLO", out)
+	require.Equal(t, map[protocol.ID]host.ProtocolShimStats{
+		"/myproto/1": {To: "/myproto/2", Streams: 1, Failures: 1},
+	}, h2.ProtocolShims())
+
+	// protocols that have a handler can't be shimmed, and shims can't be chained
+	require.Error(t, h2.AddProtocolShim(host.ProtocolShim{From: "/myproto/2", To: "/myproto/3"}))
+	require.Error(t, h2.AddProtocolShim(host.ProtocolShim{From: "/myproto/0", To: "/myproto/1"}))
+
+	h2.RemoveProtocolShim("/myproto/1")
+	require.NotContains(t, h2.Mux().Protocols(), protocol.ID("/myproto/1"))
+	require.Empty(t, h2.ProtocolShims())
+
+	// setting a handler replaces the shim
+	require.NoError(t, h2.AddProtocolShim(host.ProtocolShim{From: "/myproto/1", To: "/myproto/2"}))
+	h2.SetStreamHandler("/myproto/1", func(s network.Stream) { s.Close() })
+	require.Empty(t, h2.ProtocolShims())
+}
+
+func TestHealth(t *testing.T) {
+	h1, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), &HostOpts{
+		HealthCriteria: &HealthCriteria{RequireListeners: true, MinConns: 1},
+	})
+	require.NoError(t, err)
+	defer h1.Close()
+	h1.Start()
+	h2, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h2.Close()
+	h2.Start()
+
+	handler := NewHealthHandler(h1)
+	getHealth := func() (int, host.HealthReport) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+		var report host.HealthReport
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+		return rec.Code, report
+	}
+
+	code, report := getHealth()
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.False(t, report.Healthy)
+	require.Equal(t, []host.HealthCheck{
+		{Name: "listeners", OK: true, Message: fmt.Sprintf("%d listen addresses", len(h1.Network().ListenAddresses()))},
+		{Name: "connections", OK: false, Message: "0 connections, need 1"},
+	}, report.Checks)
+
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+	code, report = getHealth()
+	require.Equal(t, http.StatusOK, code)
+	require.True(t, report.Healthy)
+
+	// the default criteria don't require listeners
+	h3, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC, swarmt.OptDialOnly), nil)
+	require.NoError(t, err)
+	defer h3.Close()
+	h3.Start()
+	require.Empty(t, h3.Network().ListenAddresses())
+	require.True(t, h3.Health().Healthy)
+	require.Empty(t, h3.Health().Checks)
+}
+
 func TestHostAddrsFactory(t *testing.T) {
 	maddr := ma.StringCast("/ip4/1.2.3.4/tcp/1234")
 	addrsFactory := func(addrs []ma.Multiaddr) []ma.Multiaddr {
@@ -243,6 +439,42 @@ func TestAllAddrs(t *testing.T) {
 
 // getHostPair gets a new pair of hosts.
 // The first host initiates the connection to the second host.
+func TestStreamHandlerPanic(t *testing.T) {
+	h1, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h1.Close()
+	h1.Start()
+	h2, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), &HostOpts{RecoverHandlerPanics: true})
+	require.NoError(t, err)
+	defer h2.Close()
+	h2.Start()
+	require.NoError(t, h1.Connect(context.Background(), h2.Peerstore().PeerInfo(h2.ID())))
+
+	sub, err := h2.EventBus().Subscribe(new(event.EvtPanicRecovered))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	h2.SetStreamHandler(protocol.TestingID, func(s network.Stream) { panic("boom") })
+	// the stream is reset, either during or after the protocol negotiation
+	s, err := h1.NewStream(context.Background(), h2.ID(), protocol.TestingID)
+	if err == nil {
+		_, err = s.Read(make([]byte, 1))
+	}
+	require.Error(t, err)
+
+	select {
+	case e := <-sub.Out():
+		evt := e.(event.EvtPanicRecovered)
+		require.Equal(t, string(protocol.TestingID), evt.Subsystem)
+		require.Equal(t, h1.ID(), evt.Peer)
+		require.Equal(t, "boom", evt.Panic)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	// the connection survives
+	require.Equal(t, network.Connected, h1.Network().Connectedness(h2.ID()))
+}
+
 func getHostPair(t *testing.T) (host.Host, host.Host) {
 	t.Helper()
 
@@ -344,6 +576,252 @@ func TestHostProtoMismatch(t *testing.T) {
 	if err == nil {
 		t.Fatal("expected new stream to fail")
 	}
+	require.ErrorIs(t, err, network.ErrProtocolNotSupported)
+	require.ErrorIs(t, err, network.ErrNegotiationFailed)
+}
+
+func TestNegotiationFailures(t *testing.T) {
+	h1, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h1.Close()
+	h2, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h2.Close()
+	h1.Start()
+	h2.Start()
+
+	h1.SetStreamHandler("/foo/1.0.0", func(s network.Stream) { s.Close() })
+	h1.SetStreamHandler("/bar/1.0.0", func(s network.Stream) { s.Close() })
+	require.NoError(t, h2.Connect(context.Background(), peer.AddrInfo{ID: h1.ID(), Addrs: h1.Addrs()}))
+
+	_, err = h2.NewStream(context.Background(), h1.ID(), "/foo/2.0.0", "/foo/3.0.0")
+	require.ErrorIs(t, err, network.ErrProtocolNotSupported)
+
+	failures := h2.NegotiationFailures()
+	require.Len(t, failures, 1)
+	require.Equal(t, h1.ID(), failures[0].Peer)
+	require.Equal(t, network.DirOutbound, failures[0].Direction)
+	require.Equal(t, []protocol.ID{"/foo/1.0.0"}, failures[0].Offered)
+	require.Equal(t, []protocol.ID{"/foo/2.0.0", "/foo/3.0.0"}, failures[0].Supported)
+	infos := h2.Network().(*swarm.Swarm).Introspect()
+	require.Len(t, infos, 1)
+	require.Equal(t, failures, infos[0].NegotiationFailures)
+
+	require.Eventually(t, func() bool { return len(h1.NegotiationFailures()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	failures = h1.NegotiationFailures()
+	require.Equal(t, h2.ID(), failures[0].Peer)
+	require.Equal(t, network.DirInbound, failures[0].Direction)
+	require.Equal(t, []protocol.ID{"/foo/2.0.0", "/foo/3.0.0"}, failures[0].Offered)
+	require.Equal(t, []protocol.ID{"/foo/1.0.0"}, failures[0].Supported)
+	infos = h1.Network().(*swarm.Swarm).Introspect()
+	require.Len(t, infos, 1)
+	require.Equal(t, failures, infos[0].NegotiationFailures)
+}
+
+func TestNegotiationTimeoutOverride(t *testing.T) {
+	h1, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), &HostOpts{
+		NegotiationTimeouts: map[protocol.ID]time.Duration{"/slow": 100 * time.Millisecond},
+	})
+	require.NoError(t, err)
+	defer h1.Close()
+	h2, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h2.Close()
+	h1.Start()
+	h2.Start()
+	require.NoError(t, h2.Connect(context.Background(), peer.AddrInfo{ID: h1.ID(), Addrs: h1.Addrs()}))
+
+	// propose opens a stream and proposes protos without waiting for the
+	// negotiation to complete.
+	propose := func(protos ...string) network.Stream {
+		s, err := h2.Network().NewStream(context.Background(), h1.ID())
+		require.NoError(t, err)
+		for _, p := range append([]string{msmux.ProtocolID}, protos...) {
+			_, err := s.Write(append([]byte{byte(len(p) + 1)}, p+"\n"...))
+			require.NoError(t, err)
+		}
+		return s
+	}
+
+	s := propose("/slow")
+	defer s.Reset()
+	s.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.Copy(io.Discard, s)
+	require.ErrorIs(t, err, network.ErrReset)
+
+	// other protocols use the global timeout
+	s = propose("/other")
+	defer s.Reset()
+	s.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	_, err = io.Copy(io.Discard, s)
+	require.True(t, isTimeout(err), "expected a read timeout, got %v", err)
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	h1, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), &HostOpts{
+		HandlerTimeouts: map[protocol.ID]time.Duration{"/stuck": 100 * time.Millisecond},
+	})
+	require.NoError(t, err)
+	defer h1.Close()
+	h2, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h2.Close()
+	h1.Start()
+	h2.Start()
+
+	handlerErr := make(chan error, 2)
+	stuck := func(s network.Stream) {
+		// blocks until the stream is reset
+		_, err := io.Copy(io.Discard, s)
+		handlerErr <- err
+	}
+	h1.SetStreamHandler("/stuck", stuck)
+	h1.SetStreamHandler("/unlimited", stuck)
+	require.NoError(t, h2.Connect(context.Background(), peer.AddrInfo{ID: h1.ID(), Addrs: h1.Addrs()}))
+
+	before := testutil.ToFloat64(streamTimeoutsTotal.WithLabelValues("handler", "/stuck"))
+	s, err := h2.NewStream(context.Background(), h1.ID(), "/stuck")
+	require.NoError(t, err)
+	defer s.Close()
+	_, err = s.Write([]byte("foo"))
+	require.NoError(t, err)
+	select {
+	case err := <-handlerErr:
+		require.ErrorIs(t, err, network.ErrReset)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the stream to be reset")
+	}
+	require.Equal(t, before+1, testutil.ToFloat64(streamTimeoutsTotal.WithLabelValues("handler", "/stuck")))
+
+	s2, err := h2.NewStream(context.Background(), h1.ID(), "/unlimited")
+	require.NoError(t, err)
+	defer s2.Reset()
+	_, err = s2.Write([]byte("foo"))
+	require.NoError(t, err)
+	select {
+	case <-handlerErr:
+		t.Fatal("didn't expect the stream to be reset")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	// limits can be set at runtime
+	h1.SetHandlerTimeout("/unlimited", 50*time.Millisecond)
+	s3, err := h2.NewStream(context.Background(), h1.ID(), "/unlimited")
+	require.NoError(t, err)
+	defer s3.Close()
+	_, err = s3.Write([]byte("foo"))
+	require.NoError(t, err)
+	select {
+	case err := <-handlerErr:
+		require.ErrorIs(t, err, network.ErrReset)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the stream to be reset")
+	}
+}
+
+func TestStreamOpenSLO(t *testing.T) {
+	h1, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h1.Close()
+	h2, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h2.Close()
+	h1.Start()
+	h2.Start()
+
+	h1.SetStreamHandler("/fast", func(s network.Stream) { s.Close() })
+	require.NoError(t, h2.Connect(context.Background(), peer.AddrInfo{ID: h1.ID(), Addrs: h1.Addrs()}))
+
+	violations := make(chan StreamOpenSLOViolation, 10)
+	onViolation := func(v StreamOpenSLOViolation) { violations <- v }
+	h2.SetStreamOpenSLO("/fast", StreamOpenSLO{Latency: time.Minute, OnViolation: onViolation})
+	h2.SetStreamOpenSLO("/missing", StreamOpenSLO{Latency: time.Minute, Consecutive: 2, OnViolation: onViolation})
+
+	before := testutil.ToFloat64(streamOpenSLOViolationsTotal.WithLabelValues("/missing"))
+	s, err := h2.NewStream(context.Background(), h1.ID(), "/fast")
+	require.NoError(t, err)
+	s.Close()
+
+	// failed streams miss the objective
+	_, err = h2.NewStream(context.Background(), h1.ID(), "/missing")
+	require.Error(t, err)
+	select {
+	case v := <-violations:
+		t.Fatalf("unexpected violation: %+v", v)
+	case <-time.After(100 * time.Millisecond):
+	}
+	_, err = h2.NewStream(context.Background(), h1.ID(), "/missing")
+	require.Error(t, err)
+	select {
+	case v := <-violations:
+		require.Equal(t, protocol.ID("/missing"), v.Protocol)
+		require.Equal(t, []peer.ID{h1.ID(), h1.ID()}, v.Peers)
+		require.Len(t, v.Latencies, 2)
+		require.Len(t, v.Errors, 2)
+		require.Error(t, v.Errors[1])
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a violation")
+	}
+	require.Equal(t, before+1, testutil.ToFloat64(streamOpenSLOViolationsTotal.WithLabelValues("/missing")))
+
+	// streams opened by canceled callers are ignored
+	h2.SetStreamOpenSLO("/missing", StreamOpenSLO{Latency: time.Minute, OnViolation: onViolation})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = h2.NewStream(ctx, h1.ID(), "/missing")
+	require.Error(t, err)
+	select {
+	case v := <-violations:
+		t.Fatalf("unexpected violation: %+v", v)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestStreamOpenSLOConsecutive(t *testing.T) {
+	var slos streamOpenSLOs
+	var violations []StreamOpenSLOViolation
+	var mu sync.Mutex
+	done := make(chan struct{}, 10)
+	slos.set("/foo", StreamOpenSLO{
+		Latency:     100 * time.Millisecond,
+		Consecutive: 3,
+		OnViolation: func(v StreamOpenSLOViolation) {
+			mu.Lock()
+			violations = append(violations, v)
+			mu.Unlock()
+			done <- struct{}{}
+		},
+	})
+
+	// a stream that meets the objective resets the count
+	slos.record("/foo", "", time.Second, nil)
+	slos.record("/foo", "", time.Second, nil)
+	slos.record("/foo", "", time.Millisecond, nil)
+	slos.record("/foo", "", time.Second, nil)
+	slos.record("/foo", "", time.Second, nil)
+	slos.record("/bar", "", time.Second, nil)
+	slos.record("/foo", "", 2*time.Second, nil)
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, violations, 1)
+	require.Equal(t, []time.Duration{time.Second, time.Second, 2 * time.Second}, violations[0].Latencies)
+
+	// removing the objective
+	slos.set("/foo", StreamOpenSLO{})
+	require.Empty(t, slos.trackers)
+}
+
+func TestProtocolFamily(t *testing.T) {
+	for p, family := range map[protocol.ID]string{
+		"/ipfs/kad/1.0.0":                 "/ipfs/kad",
+		"/ipfs/id/push/1.0.0":             "/ipfs/id/push",
+		"/libp2p/circuit/relay/0.2.0/hop": "/libp2p/circuit/relay/0.2.0/hop",
+		"/foo":                            "/foo",
+	} {
+		require.Equal(t, family, ProtocolFamily(p))
+	}
 }
 
 func TestHostProtoPreknowledge(t *testing.T) {
@@ -896,3 +1374,385 @@ func TestInferWebtransportAddrsFromQuic(t *testing.T) {
 	}
 
 }
+
+func TestReplaceConnsOnInterfaceAddrsChange(t *testing.T) {
+	h1, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h1.Close()
+	h1.Start()
+	h2, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h2.Close()
+	h2.Start()
+
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+	conns := h1.Network().ConnsToPeer(h2.ID())
+	require.Len(t, conns, 1)
+	oldConn := conns[0]
+
+	em, err := h1.EventBus().Emitter(new(event.EvtLocalInterfaceAddrsChanged))
+	require.NoError(t, err)
+	defer em.Close()
+	require.NoError(t, em.Emit(event.EvtLocalInterfaceAddrsChanged{Removed: []ma.Multiaddr{ma.StringCast("/ip4/127.0.0.1")}}))
+
+	require.Eventually(t, func() bool {
+		conns := h1.Network().ConnsToPeer(h2.ID())
+		return len(conns) == 1 && conns[0] != oldConn
+	}, 5*time.Second, 10*time.Millisecond)
+	require.True(t, oldConn.IsClosed())
+}
+
+func TestSetPowerState(t *testing.T) {
+	profile := event.PowerProfile{AddrUpdateInterval: time.Hour, AutoNATRefreshInterval: 2 * time.Hour, KeepAliveInterval: time.Minute}
+	h, err := NewHost(swarmt.GenSwarm(t), &HostOpts{LowPowerProfile: &profile})
+	require.NoError(t, err)
+	defer h.Close()
+	h.Start()
+
+	sub, err := h.EventBus().Subscribe(new(event.EvtLocalPowerStateChanged))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	nextEvent := func() event.EvtLocalPowerStateChanged {
+		t.Helper()
+		select {
+		case e := <-sub.Out():
+			return e.(event.EvtLocalPowerStateChanged)
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected a power state changed event")
+		}
+		return event.EvtLocalPowerStateChanged{}
+	}
+
+	h.SetPowerState(true)
+	evt := nextEvent()
+	require.True(t, evt.LowPower)
+	require.Equal(t, profile, evt.Profile)
+	require.Equal(t, time.Hour, time.Duration(h.addrUpdateInterval.Load()))
+	require.Equal(t, time.Minute, h.keepAliveInterval)
+
+	// setting the same state again is a no-op
+	h.SetPowerState(true)
+	select {
+	case e := <-sub.Out():
+		t.Fatalf("didn't expect an event: %v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	h.SetPowerState(false)
+	evt = nextEvent()
+	require.False(t, evt.LowPower)
+	require.Zero(t, evt.Profile)
+	require.Equal(t, addrChangeTickrInterval, time.Duration(h.addrUpdateInterval.Load()))
+	require.Zero(t, h.keepAliveInterval)
+}
+
+func TestKeepAliveConn(t *testing.T) {
+	h1, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h1.Close()
+	h1.Start()
+	h2, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h2.Close()
+	h2.Start()
+
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+	conns := h1.Network().ConnsToPeer(h2.ID())
+	require.Len(t, conns, 1)
+	// the keep-alive interval of yamux is set through the swarm and the upgrader
+	kc, ok := conns[0].(network.KeepAliveConn)
+	require.True(t, ok)
+	require.NoError(t, kc.SetKeepAliveInterval(time.Minute))
+}
+
+func TestNewStreamDeadlineFromContext(t *testing.T) {
+	h1, h2 := getHostPair(t)
+	defer h1.Close()
+	defer h2.Close()
+	// a handler that never responds
+	h2.SetStreamHandler("/testing", func(s network.Stream) {})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	s, err := h1.NewStream(network.WithStreamDeadline(ctx, "test"), h2.ID(), "/testing")
+	require.NoError(t, err)
+	defer s.Close()
+
+	start := time.Now()
+	_, err = s.Read(make([]byte, 1))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestNewStreamResetOnCancel(t *testing.T) {
+	h1, h2 := getHostPair(t)
+	defer h1.Close()
+	defer h2.Close()
+	h2.SetStreamHandler("/testing", func(s network.Stream) {})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s, err := h1.NewStream(network.WithResetStreamOnCancel(ctx, "test"), h2.ID(), "/testing")
+	require.NoError(t, err)
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := s.Read(make([]byte, 1))
+		errCh <- err
+	}()
+	cancel()
+	select {
+	case err := <-errCh:
+		require.ErrorIs(t, err, network.ErrReset)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the stream to be reset")
+	}
+
+	// After closing the stream, canceling the context doesn't reset it.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	s, err = h1.NewStream(network.WithResetStreamOnCancel(ctx, "test"), h2.ID(), "/testing")
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+	require.False(t, s.(*ctxStream).stop())
+}
+
+func TestNegotiationCache(t *testing.T) {
+	h1, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), &HostOpts{EnableNegotiationCache: true})
+	require.NoError(t, err)
+	defer h1.Close()
+	h1.Start()
+	h2, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h2.Close()
+	h2.Start()
+
+	// count the protocols proposed to h2
+	var mx sync.Mutex
+	proposed := make(map[protocol.ID]int)
+	handled := make(chan struct{}, 10)
+	h2.SetStreamHandlerMatch("/b", func(id protocol.ID) bool {
+		if id == "/a" || id == "/b" {
+			mx.Lock()
+			proposed[id]++
+			mx.Unlock()
+		}
+		return id == "/b"
+	}, func(s network.Stream) {
+		s.Close()
+		handled <- struct{}{}
+	})
+
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+	<-h1.IDService().IdentifyWait(h1.Network().ConnsToPeer(h2.ID())[0])
+
+	for i := 0; i < 3; i++ {
+		// make sure the protocol isn't known from the peerstore
+		require.NoError(t, h1.Peerstore().RemoveProtocols(h2.ID(), "/b"))
+		s, err := h1.NewStream(context.Background(), h2.ID(), "/a", "/b")
+		require.NoError(t, err)
+		_, err = s.Write([]byte("foo"))
+		require.NoError(t, err)
+		select {
+		case <-handled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("stream not handled")
+		}
+		require.Equal(t, protocol.ID("/b"), s.Protocol())
+		s.Close()
+	}
+
+	// the rejected protocol is not proposed again
+	_, err = h1.NewStream(context.Background(), h2.ID(), "/a")
+	require.ErrorIs(t, err, network.ErrProtocolNotSupported)
+
+	mx.Lock()
+	defer mx.Unlock()
+	require.Equal(t, map[protocol.ID]int{"/a": 1, "/b": 3}, proposed)
+
+	// the cache is cleared when the connection is closed
+	require.NoError(t, h1.Network().ClosePeer(h2.ID()))
+	require.Eventually(t, func() bool {
+		h1.negCache.mu.Lock()
+		defer h1.negCache.mu.Unlock()
+		return len(h1.negCache.conns) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestListenAddrExpansions(t *testing.T) {
+	swrm := swarmt.GenSwarm(t, swarmt.OptDialOnly)
+	h, err := NewHost(swrm, nil)
+	require.NoError(t, err)
+	defer h.Close()
+	sub, err := h.EventBus().Subscribe(&event.EvtListenAddrExpansionsUpdated{})
+	require.NoError(t, err)
+	defer sub.Close()
+
+	configured := ma.StringCast("/ip4/0.0.0.0/tcp/0")
+	require.NoError(t, swrm.Listen(configured))
+	h.Start()
+
+	var expansions []event.ListenAddrExpansion
+	require.Eventually(t, func() bool {
+		select {
+		case e := <-sub.Out():
+			expansions = e.(event.EvtListenAddrExpansionsUpdated).Expansions
+		default:
+		}
+		return len(expansions) == 1 && len(expansions[0].Advertised) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	e := expansions[0]
+	require.True(t, e.Configured.Equal(configured))
+	require.True(t, manet.IsIPUnspecified(e.Listen))
+	require.NotEqual(t, configured, e.Listen)
+	require.NotEmpty(t, e.Expanded)
+	for _, a := range e.Expanded {
+		require.False(t, manet.IsIPUnspecified(a))
+	}
+	for _, a := range e.Advertised {
+		require.Contains(t, h.Addrs(), a)
+	}
+	require.Equal(t, expansions, h.ListenAddrExpansions())
+}
+
+func TestNewStreamIntent(t *testing.T) {
+	h1, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h1.Close()
+	h2, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h2.Close()
+	h1.Start()
+	h2.Start()
+
+	h1.SetStreamHandler("/foo", func(s network.Stream) { s.Close() })
+	h2.Peerstore().AddAddrs(h1.ID(), h1.Addrs(), peerstore.PermanentAddrTTL)
+
+	ctx := network.WithIntent(context.Background(), network.Intent{Bandwidth: network.BandwidthHigh})
+	s, err := h2.NewStream(ctx, h1.ID(), "/foo")
+	require.NoError(t, err)
+	defer s.Close()
+	intent, ok := network.ConnIntent(s.Conn())
+	require.True(t, ok)
+	require.Equal(t, network.Intent{Protocols: []protocol.ID{"/foo"}, Bandwidth: network.BandwidthHigh}, intent)
+}
+
+func TestDiffAddrs(t *testing.T) {
+	a := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	b := ma.StringCast("/ip4/1.2.3.4/tcp/2")
+	c := ma.StringCast("/ip4/1.2.3.4/udp/1/quic-v1")
+	d := ma.StringCast("/ip6/::1/tcp/1")
+
+	added, removed := DiffAddrs([]ma.Multiaddr{a, b, b}, []ma.Multiaddr{d, c, a, c})
+	require.Equal(t, []ma.Multiaddr{c, d}, added)
+	require.Equal(t, []ma.Multiaddr{b}, removed)
+
+	added, removed = DiffAddrs([]ma.Multiaddr{a, b}, []ma.Multiaddr{b, a})
+	require.Empty(t, added)
+	require.Empty(t, removed)
+}
+
+func TestAddrChanges(t *testing.T) {
+	h, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h.Close()
+	h.Start()
+
+	require.Eventually(t, func() bool { return len(h.AddrChanges()) > 0 }, 5*time.Second, 10*time.Millisecond)
+	change := h.AddrChanges()[0]
+	require.ElementsMatch(t, h.Addrs(), change.Added)
+	require.Empty(t, change.Removed)
+	require.Equal(t, len(h.Addrs()), change.Total)
+	require.Equal(t, len(h.Addrs()), h.AddrChurn(time.Minute))
+}
+
+func TestMapExternalAddrs(t *testing.T) {
+	mappings := []ExternalAddr{
+		{Bound: ma.StringCast("/ip4/0.0.0.0/udp/4001"), External: ma.StringCast("/dns4/example.com/udp/443")},
+		{Bound: ma.StringCast("/ip6/::1/tcp/4001"), External: ma.StringCast("/ip6/2001:db8::1/tcp/443")},
+	}
+	addrs := []ma.Multiaddr{
+		ma.StringCast("/ip4/10.0.0.1/udp/4001/quic-v1"),
+		ma.StringCast("/ip4/127.0.0.1/udp/4001/quic-v1"),
+		ma.StringCast("/ip4/10.0.0.1/udp/4001/quic-v1/webtransport/certhash/uEiDDq4_xNyDorZBH3TlGazyJdOWSwvo4PUo5YHFMrvDE8g"),
+		ma.StringCast("/ip4/10.0.0.1/udp/4002/quic-v1"),
+		ma.StringCast("/ip4/10.0.0.1/tcp/4001"),
+		ma.StringCast("/ip6/::1/tcp/4001"),
+		ma.StringCast("/ip6/::2/tcp/4001"),
+	}
+	require.Equal(t, []ma.Multiaddr{
+		ma.StringCast("/dns4/example.com/udp/443/quic-v1"),
+		ma.StringCast("/dns4/example.com/udp/443/quic-v1/webtransport/certhash/uEiDDq4_xNyDorZBH3TlGazyJdOWSwvo4PUo5YHFMrvDE8g"),
+		ma.StringCast("/ip4/10.0.0.1/udp/4002/quic-v1"),
+		ma.StringCast("/ip4/10.0.0.1/tcp/4001"),
+		ma.StringCast("/ip6/2001:db8::1/tcp/443"),
+		ma.StringCast("/ip6/::2/tcp/4001"),
+	}, mapExternalAddrs(addrs, mappings))
+
+	for _, invalid := range []ExternalAddr{
+		{Bound: ma.StringCast("/ip4/0.0.0.0/udp/4001")},
+		{Bound: ma.StringCast("/ip4/0.0.0.0/udp/4001"), External: ma.StringCast("/ip4/1.2.3.4/tcp/443")},
+		{Bound: ma.StringCast("/dns4/example.com/udp/4001"), External: ma.StringCast("/ip4/1.2.3.4/udp/443")},
+		{Bound: ma.StringCast("/ip4/0.0.0.0/udp/4001/quic-v1"), External: ma.StringCast("/ip4/1.2.3.4/udp/443")},
+	} {
+		_, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), &HostOpts{ExternalAddrs: []ExternalAddr{invalid}})
+		require.Error(t, err)
+	}
+}
+
+func TestConnectWithOptions(t *testing.T) {
+	h1, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h1.Close()
+	h2, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h2.Close()
+	h1.Start()
+	h2.Start()
+	pi := peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}
+
+	_, err = h1.ConnectWithOptions(context.Background(), pi, host.RequireTransport())
+	require.Error(t, err)
+	_, err = h1.ConnectWithOptions(context.Background(), pi, host.RequireTransport("quic-v1"))
+	require.Error(t, err)
+	require.Empty(t, h1.Network().ConnsToPeer(h2.ID()))
+
+	info, err := h1.ConnectWithOptions(context.Background(), pi, host.RequireDirect(), host.RequireTransport("tcp"), host.WaitForIdentify())
+	require.NoError(t, err)
+	require.Equal(t, "tcp", info.Transport)
+	require.True(t, info.Direct)
+	require.False(t, info.Reused)
+	require.True(t, info.Identified)
+	protos, err := h1.Peerstore().GetProtocols(h2.ID())
+	require.NoError(t, err)
+	require.Contains(t, protos, protocol.ID(identify.ID))
+
+	info2, err := host.ConnectWithOptions(context.Background(), h1, pi)
+	require.NoError(t, err)
+	require.Equal(t, info.Conn, info2.Conn)
+	require.True(t, info2.Reused)
+}
+
+func TestCloseBrokenConns(t *testing.T) {
+	h1, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h1.Close()
+	h1.Start()
+	// h2 doesn't run the ping service, but still answers on the connection.
+	h2, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), &HostOpts{EnablePing: false})
+	require.NoError(t, err)
+	defer h2.Close()
+	h2.Start()
+
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+	conns := h1.Network().ConnsToPeer(h2.ID())
+	require.Len(t, conns, 1)
+	require.Empty(t, h1.closeBrokenConns(conns))
+	require.False(t, conns[0].IsClosed())
+
+	old := staleConnProbeTimeout
+	staleConnProbeTimeout = 100 * time.Millisecond
+	defer func() { staleConnProbeTimeout = old }()
+	require.NoError(t, conns[0].Close())
+	require.True(t, h1.closeBrokenConns(conns).Contains(h2.ID()))
+}
@@ -0,0 +1,101 @@
+package basichost
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/host/addrcheck"
+	"github.com/libp2p/go-libp2p/p2p/metricshelper"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+var _ host.OptionsConnector = (*BasicHost)(nil)
+
+// ConnectWithOptions is like Connect, but only uses (or dials) connections
+// that meet the requirements set by opts. Unlike Connect, it only waits for
+// identify if the WaitForIdentify option is given.
+func (h *BasicHost) ConnectWithOptions(ctx context.Context, pi peer.AddrInfo, opts ...host.ConnectOption) (host.ConnectInfo, error) {
+	var o host.ConnectOptions
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return host.ConnectInfo{}, err
+		}
+	}
+	h.Peerstore().AddAddrs(pi.ID, addrcheck.Filter(addrcheck.BoundaryUser, pi.Addrs), peerstore.TempAddrTTL)
+
+	if match := connectAddrFilter(&o); match != nil {
+		if other, _ := network.GetDialAddrFilter(ctx); other != nil {
+			m := match
+			match = func(a ma.Multiaddr) bool { return m(a) && other(a) }
+		}
+		ctx = network.WithDialAddrFilter(ctx, match, "connect options")
+	}
+
+	existing := make(map[network.Conn]struct{})
+	for _, c := range h.Network().ConnsToPeer(pi.ID) {
+		existing[c] = struct{}{}
+	}
+	c, err := h.Network().DialPeer(ctx, pi.ID)
+	if err != nil {
+		return host.ConnectInfo{}, fmt.Errorf("failed to dial: %w", err)
+	}
+	if match := connectAddrFilter(&o); match != nil && !match(c.RemoteMultiaddr()) {
+		// the network doesn't support dial address filters
+		return host.ConnectInfo{}, fmt.Errorf("connection over %s doesn't meet the requirements", c.RemoteMultiaddr())
+	}
+
+	_, reused := existing[c]
+	info := host.ConnectInfo{
+		Conn:      c,
+		Transport: metricshelper.GetTransport(c.RemoteMultiaddr()),
+		Direct:    !isRelayAddr(c.RemoteMultiaddr()),
+		Reused:    reused,
+	}
+	if o.WaitForIdentify {
+		select {
+		case <-h.ids.IdentifyWait(c):
+		case <-ctx.Done():
+			return host.ConnectInfo{}, fmt.Errorf("identify failed to complete: %w", ctx.Err())
+		}
+	}
+	select {
+	case <-h.ids.IdentifyWait(c):
+		// identify may have failed, e.g. because the connection was closed
+		info.Identified = !c.IsClosed()
+	default:
+	}
+	return info, nil
+}
+
+// connectAddrFilter returns a filter for the remote addresses of connections
+// that meet the requirements of o, or nil if all connections do.
+func connectAddrFilter(o *host.ConnectOptions) func(ma.Multiaddr) bool {
+	if !o.RequireDirect && len(o.Transports) == 0 {
+		return nil
+	}
+	return func(a ma.Multiaddr) bool {
+		if o.RequireDirect && isRelayAddr(a) {
+			return false
+		}
+		if len(o.Transports) == 0 {
+			return true
+		}
+		t := metricshelper.GetTransport(a)
+		for _, want := range o.Transports {
+			if t == want {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func isRelayAddr(a ma.Multiaddr) bool {
+	_, err := a.ValueForProtocol(ma.P_CIRCUIT)
+	return err == nil
+}
@@ -0,0 +1,89 @@
+package basichost
+
+import (
+	"fmt"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// ExternalAddr maps the addresses of the listeners bound to Bound to External,
+// the address of a reverse proxy or load balancer forwarding to them. Only
+// the IP address (or DNS name) and port are replaced, the rest of the address
+// is kept. This includes the certhashes of WebTransport and WebRTC addresses,
+// so fronted UDP listeners are advertised with their current certificates.
+type ExternalAddr struct {
+	// Bound is the IP address and TCP or UDP port the listeners are bound to,
+	// e.g. /ip4/0.0.0.0/udp/4001. An unspecified IP address matches all
+	// addresses of the same IP version.
+	Bound ma.Multiaddr
+	// External is the IP address or DNS name and port the listeners are
+	// reachable at, e.g. /dns4/example.com/udp/443.
+	External ma.Multiaddr
+}
+
+func (e ExternalAddr) validate() error {
+	for _, a := range []ma.Multiaddr{e.Bound, e.External} {
+		if a == nil {
+			return fmt.Errorf("missing address")
+		}
+		comps := ma.Split(a)
+		if len(comps) != 2 {
+			return fmt.Errorf("expected an IP address or DNS name and a port: %s", a)
+		}
+		if c := protoCode(comps[1]); c != ma.P_TCP && c != ma.P_UDP {
+			return fmt.Errorf("expected a TCP or UDP port: %s", a)
+		}
+	}
+	if c := protoCode(ma.Split(e.Bound)[0]); c != ma.P_IP4 && c != ma.P_IP6 {
+		return fmt.Errorf("expected an IP address: %s", e.Bound)
+	}
+	if protoCode(ma.Split(e.Bound)[1]) != protoCode(ma.Split(e.External)[1]) {
+		return fmt.Errorf("%s and %s use different transport protocols", e.Bound, e.External)
+	}
+	return nil
+}
+
+// matches returns true if the IP address and port of an address match e.Bound.
+func (e ExternalAddr) matches(ip, port ma.Multiaddr) bool {
+	bound := ma.Split(e.Bound)
+	if !port.Equal(bound[1]) || protoCode(ip) != protoCode(bound[0]) {
+		return false
+	}
+	return ip.Equal(bound[0]) || manet.IsIPUnspecified(bound[0])
+}
+
+func protoCode(c ma.Multiaddr) int {
+	return c.Protocols()[0].Code
+}
+
+// mapExternalAddrs replaces the addresses matching one of the mappings with
+// the external address. Duplicates, e.g. from listeners bound to the
+// unspecified address, are removed.
+func mapExternalAddrs(addrs []ma.Multiaddr, mappings []ExternalAddr) []ma.Multiaddr {
+	if len(mappings) == 0 {
+		return addrs
+	}
+	res := make([]ma.Multiaddr, 0, len(addrs))
+	seen := make(map[string]struct{}, len(addrs))
+	for _, a := range addrs {
+		comps := ma.Split(a)
+		if len(comps) >= 2 {
+			for _, m := range mappings {
+				if m.matches(comps[0], comps[1]) {
+					a = m.External
+					if len(comps) > 2 {
+						a = a.Encapsulate(ma.Join(comps[2:]...))
+					}
+					break
+				}
+			}
+		}
+		if _, ok := seen[string(a.Bytes())]; ok {
+			continue
+		}
+		seen[string(a.Bytes())] = struct{}{}
+		res = append(res, a)
+	}
+	return res
+}
@@ -0,0 +1,93 @@
+package basichost
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var streamTimeoutsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Name:      "stream_timeouts_total",
+		Help:      "Inbound streams reset because negotiation or their handler took too long",
+	},
+	[]string{"stage", "protocol"},
+)
+
+// protocolTimeouts holds a timeout per protocol.
+type protocolTimeouts struct {
+	mu       sync.RWMutex
+	timeouts map[protocol.ID]time.Duration
+}
+
+func (ht *protocolTimeouts) get(pid protocol.ID) time.Duration {
+	ht.mu.RLock()
+	defer ht.mu.RUnlock()
+	return ht.timeouts[pid]
+}
+
+func (ht *protocolTimeouts) set(pid protocol.ID, d time.Duration) {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+	if d <= 0 {
+		delete(ht.timeouts, pid)
+		return
+	}
+	if ht.timeouts == nil {
+		ht.timeouts = make(map[protocol.ID]time.Duration)
+	}
+	ht.timeouts[pid] = d
+}
+
+// SetHandlerTimeout limits the time the handler of inbound streams for pid
+// may run. If the handler hasn't returned when the timeout expires, the
+// stream is reset, which releases its resource manager reservations. Handlers
+// should still return once their stream is reset. A duration of 0 removes the
+// limit.
+// Timeouts are counted in the libp2p_host_stream_timeouts_total metric.
+func (h *BasicHost) SetHandlerTimeout(pid protocol.ID, d time.Duration) {
+	h.handlerTimeouts.set(pid, d)
+}
+
+// SetNegotiationTimeout overrides the negotiation timeout of inbound streams
+// for pid. The timeout applies from the time the stream was accepted, as soon
+// as the remote peer proposes pid. A duration of 0 removes the override.
+// See HostOpts.NegotiationTimeout.
+func (h *BasicHost) SetNegotiationTimeout(pid protocol.ID, d time.Duration) {
+	h.negTimeouts.set(pid, d)
+}
+
+// runHandler runs the handler of the negotiated protocol on s, resetting s
+// if the handler exceeds its timeout.
+func (h *BasicHost) runHandler(pid protocol.ID, s network.Stream, handle protocol.HandlerFunc) {
+	if d := h.handlerTimeouts.get(pid); d > 0 {
+		var done atomic.Bool
+		t := time.AfterFunc(d, func() {
+			if done.Load() {
+				return
+			}
+			log.Debugw("stream handler timed out", "protocol", pid, "stream", s.ID(), "peer", s.Conn().RemotePeer().Short(), "timeout", d)
+			streamTimeoutsTotal.WithLabelValues("handler", string(pid)).Inc()
+			s.Reset()
+		})
+		defer func() {
+			done.Store(true)
+			t.Stop()
+		}()
+	}
+	handle(pid, s)
+}
+
+// isTimeout returns true if err was caused by a stream deadline.
+func isTimeout(err error) bool {
+	var nerr net.Error
+	return errors.As(err, &nerr) && nerr.Timeout()
+}
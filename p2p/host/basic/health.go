@@ -0,0 +1,148 @@
+package basichost
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// HealthCriteria configures the checks performed by BasicHost.Health.
+type HealthCriteria struct {
+	// RequireListeners requires the host to have at least one bound listen address.
+	// It is off by default, since client-only hosts don't listen.
+	RequireListeners bool
+	// MinConns is the minimum number of open connections.
+	MinConns int
+	// RequireKnownReachability requires AutoNAT to have determined whether the
+	// host is reachable.
+	RequireKnownReachability bool
+	// RequireRelayIfPrivate requires the host to hold a relay reservation, i.e.
+	// to advertise a relay address, if it's not publicly reachable.
+	RequireRelayIfPrivate bool
+	// MaxResourceUsage is the fraction of the resource manager's system limits
+	// above which the host is considered overloaded. If 0, resource usage is not
+	// checked.
+	MaxResourceUsage float64
+}
+
+// DefaultHealthCriteria is the default value for HostOpts.HealthCriteria.
+var DefaultHealthCriteria = HealthCriteria{
+	MaxResourceUsage: 0.9,
+}
+
+// Health evaluates the health criteria of the host (see
+// HostOpts.HealthCriteria).
+func (h *BasicHost) Health() host.HealthReport {
+	c := h.healthCriteria
+	var checks []host.HealthCheck
+	if c.RequireListeners {
+		n := len(h.Network().ListenAddresses())
+		checks = append(checks, host.HealthCheck{
+			Name:    "listeners",
+			OK:      n > 0,
+			Message: fmt.Sprintf("%d listen addresses", n),
+		})
+	}
+	if c.MinConns > 0 {
+		n := len(h.Network().Conns())
+		checks = append(checks, host.HealthCheck{
+			Name:    "connections",
+			OK:      n >= c.MinConns,
+			Message: fmt.Sprintf("%d connections, need %d", n, c.MinConns),
+		})
+	}
+	reachability := network.ReachabilityUnknown
+	if a := h.GetAutoNat(); a != nil {
+		reachability = a.Status()
+	}
+	if c.RequireKnownReachability {
+		checks = append(checks, host.HealthCheck{
+			Name:    "reachability",
+			OK:      reachability != network.ReachabilityUnknown,
+			Message: reachability.String(),
+		})
+	}
+	if c.RequireRelayIfPrivate && reachability == network.ReachabilityPrivate {
+		hasRelayAddr := false
+		for _, a := range h.Addrs() {
+			if _, err := a.ValueForProtocol(ma.P_CIRCUIT); err == nil {
+				hasRelayAddr = true
+				break
+			}
+		}
+		checks = append(checks, host.HealthCheck{
+			Name:    "relay",
+			OK:      hasRelayAddr,
+			Message: fmt.Sprintf("private, has relay address: %t", hasRelayAddr),
+		})
+	}
+	if c.MaxResourceUsage > 0 {
+		if check, ok := h.resourceUsageCheck(c.MaxResourceUsage); ok {
+			checks = append(checks, check)
+		}
+	}
+
+	healthy := true
+	for _, check := range checks {
+		healthy = healthy && check.OK
+	}
+	return host.HealthReport{Healthy: healthy, Checks: checks}
+}
+
+// resourceUsageCheck checks the usage of the system scope against its limits.
+// It returns false if the resource manager doesn't expose its limits.
+func (h *BasicHost) resourceUsageCheck(max float64) (check host.HealthCheck, ok bool) {
+	rm := h.Network().ResourceManager()
+	if rm == nil {
+		return host.HealthCheck{}, false
+	}
+	_ = rm.ViewSystem(func(s network.ResourceScope) error {
+		l, isLimiter := s.(rcmgr.ResourceScopeLimiter)
+		if !isLimiter {
+			return nil
+		}
+		ok = true
+		limit := l.Limit()
+		stat := s.Stat()
+		check = host.HealthCheck{Name: "resources", OK: true, Message: "within limits"}
+		for _, r := range []struct {
+			name        string
+			used, limit int64
+		}{
+			{"memory", stat.Memory, limit.GetMemoryLimit()},
+			{"connections", int64(stat.NumConnsInbound + stat.NumConnsOutbound), int64(limit.GetConnTotalLimit())},
+			{"streams", int64(stat.NumStreamsInbound + stat.NumStreamsOutbound), int64(limit.GetStreamTotalLimit())},
+			{"file descriptors", int64(stat.NumFD), int64(limit.GetFDLimit())},
+		} {
+			if r.limit > 0 && float64(r.used) > max*float64(r.limit) {
+				check.OK = false
+				check.Message = fmt.Sprintf("%s usage %d exceeds %.0f%% of the limit %d", r.name, r.used, max*100, r.limit)
+				break
+			}
+		}
+		return nil
+	})
+	return check, ok
+}
+
+// NewHealthHandler returns an http.Handler serving the health report of h as
+// JSON. It responds with status 200 if the host is healthy, and 503 otherwise,
+// so it can be used as a readiness probe.
+func NewHealthHandler(h host.HealthReporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		report := h.Health()
+		w.Header().Set("Content-Type", "application/json")
+		if !report.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			log.Debugw("failed to write health report", "error", err)
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package basichost
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/event"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// ListenAddrExpansions returns, for every listen address, the interface
+// addresses it expands to and the addresses advertised for it. Changes are
+// announced by an event.EvtListenAddrExpansionsUpdated.
+func (h *BasicHost) ListenAddrExpansions() []event.ListenAddrExpansion {
+	return h.listenAddrExpansions(h.Addrs())
+}
+
+func (h *BasicHost) listenAddrExpansions(advertised []ma.Multiaddr) []event.ListenAddrExpansion {
+	type configuredListenAddrer interface {
+		ConfiguredListenAddr(ma.Multiaddr) (ma.Multiaddr, bool)
+	}
+
+	listenAddrs := h.Network().ListenAddresses()
+	if len(listenAddrs) == 0 {
+		return nil
+	}
+
+	h.addrMu.RLock()
+	filteredIfaceAddrs := h.filteredInterfaceAddrs
+	h.addrMu.RUnlock()
+
+	advertisedByAddr := make(map[string]ma.Multiaddr, len(advertised))
+	for _, a := range advertised {
+		advertisedByAddr[string(h.NormalizeMultiaddr(a).Bytes())] = a
+	}
+
+	cfg, _ := h.Network().(configuredListenAddrer)
+	expansions := make([]event.ListenAddrExpansion, 0, len(listenAddrs))
+	for _, l := range listenAddrs {
+		e := event.ListenAddrExpansion{Listen: l}
+		if cfg != nil {
+			e.Configured, _ = cfg.ConfiguredListenAddr(l)
+		}
+		// Same as AllAddrs: only resolve to our primary interfaces.
+		if resolved, err := manet.ResolveUnspecifiedAddress(l, filteredIfaceAddrs); err == nil {
+			e.Expanded = resolved
+		}
+		if h.natmgr != nil && h.natmgr.HasDiscoveredNAT() {
+			e.Mapped = h.natmgr.GetMapping(l)
+		}
+		if h.ids != nil {
+			for _, a := range e.Expanded {
+				e.Observed = append(e.Observed, h.ids.ObservedAddrsFor(a)...)
+			}
+			e.Observed = ma.Unique(e.Observed)
+		}
+
+		candidates := append([]ma.Multiaddr{}, e.Expanded...)
+		if e.Mapped != nil {
+			candidates = append(candidates, e.Mapped)
+		}
+		candidates = append(candidates, e.Observed...)
+		for _, c := range candidates {
+			if a, ok := advertisedByAddr[string(h.NormalizeMultiaddr(c).Bytes())]; ok {
+				e.Advertised = append(e.Advertised, a)
+			}
+		}
+		e.Advertised = ma.Unique(e.Advertised)
+		expansions = append(expansions, e)
+	}
+	sort.Slice(expansions, func(i, j int) bool {
+		return expansions[i].Listen.String() < expansions[j].Listen.String()
+	})
+	return expansions
+}
+
+// listenAddrExpansionsEqual returns true if a and b, as returned by
+// listenAddrExpansions, are the same.
+func listenAddrExpansionsEqual(a, b []event.ListenAddrExpansion) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if expansionKey(a[i]) != expansionKey(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func expansionKey(e event.ListenAddrExpansion) string {
+	var sb strings.Builder
+	writeAddr := func(a ma.Multiaddr) {
+		if a != nil {
+			sb.WriteString(a.String())
+		}
+		sb.WriteByte('\n')
+	}
+	writeAddrs := func(addrs []ma.Multiaddr) {
+		for _, a := range addrs {
+			writeAddr(a)
+		}
+		sb.WriteString("|\n")
+	}
+	writeAddr(e.Configured)
+	writeAddr(e.Listen)
+	writeAddr(e.Mapped)
+	writeAddrs(e.Expanded)
+	writeAddrs(e.Observed)
+	writeAddrs(e.Advertised)
+	return sb.String()
+}
@@ -0,0 +1,120 @@
+package basichost
+
+import (
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// maxInternedProtocols bounds the number of protocol IDs interned by the host.
+// Handlers registered with a match function accept arbitrary protocol IDs, so
+// remote peers could otherwise make the interner grow without bounds.
+const maxInternedProtocols = 1024
+
+// protocolInterner deduplicates the protocol IDs negotiated on inbound streams,
+// so that each stream doesn't retain its own copy.
+type protocolInterner struct {
+	lk       sync.RWMutex
+	interned map[protocol.ID]protocol.ID
+}
+
+func (pi *protocolInterner) intern(proto protocol.ID) protocol.ID {
+	pi.lk.RLock()
+	interned, ok := pi.interned[proto]
+	pi.lk.RUnlock()
+	if ok {
+		return interned
+	}
+
+	pi.lk.Lock()
+	defer pi.lk.Unlock()
+	if interned, ok := pi.interned[proto]; ok {
+		return interned
+	}
+	if pi.interned == nil {
+		pi.interned = make(map[protocol.ID]protocol.ID)
+	}
+	if len(pi.interned) >= maxInternedProtocols {
+		return proto
+	}
+	pi.interned[proto] = proto
+	return proto
+}
+
+// negotiationCache remembers the protocols a peer accepted and rejected on a
+// connection. It allows skipping the multistream round trip for protocols the
+// peer is known to support, and not proposing protocols it rejected.
+//
+// Protocols the peer announces later (e.g. in an identify push) are recorded in
+// the peerstore, which takes precedence over the rejections cached here.
+type negotiationCache struct {
+	mu    sync.Mutex
+	conns map[network.Conn]*connProtocols
+}
+
+type connProtocols struct {
+	supported   map[protocol.ID]struct{}
+	unsupported map[protocol.ID]struct{}
+}
+
+func newNegotiationCache() *negotiationCache {
+	return &negotiationCache{conns: make(map[network.Conn]*connProtocols)}
+}
+
+// preferred returns the first of pids the peer accepted on c. If there is none,
+// it returns the pids that the peer didn't reject.
+func (nc *negotiationCache) preferred(c network.Conn, pids []protocol.ID) (protocol.ID, []protocol.ID) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	cp, ok := nc.conns[c]
+	if !ok {
+		return "", pids
+	}
+	for _, pid := range pids {
+		if _, ok := cp.supported[pid]; ok {
+			return pid, nil
+		}
+	}
+	remaining := make([]protocol.ID, 0, len(pids))
+	for _, pid := range pids {
+		if _, ok := cp.unsupported[pid]; !ok {
+			remaining = append(remaining, pid)
+		}
+	}
+	return "", remaining
+}
+
+// record records the result of negotiating pids on c. Protocols are proposed
+// in order, so all protocols before the selected one were rejected. If
+// selected is empty, all of pids were rejected.
+func (nc *negotiationCache) record(c network.Conn, pids []protocol.ID, selected protocol.ID) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	cp, ok := nc.conns[c]
+	if !ok {
+		// The entry would never be removed.
+		if c.IsClosed() {
+			return
+		}
+		cp = &connProtocols{
+			supported:   make(map[protocol.ID]struct{}),
+			unsupported: make(map[protocol.ID]struct{}),
+		}
+		nc.conns[c] = cp
+	}
+	for _, pid := range pids {
+		if pid == selected {
+			cp.supported[pid] = struct{}{}
+			delete(cp.unsupported, pid)
+			return
+		}
+		cp.unsupported[pid] = struct{}{}
+	}
+}
+
+func (nc *negotiationCache) removeConn(c network.Conn) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	delete(nc.conns, c)
+}
@@ -0,0 +1,195 @@
+package basichost
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/metricshelper"
+
+	msmux "github.com/multiformats/go-multistream"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// maxNegotiationFailures is the number of failures kept in the log.
+	maxNegotiationFailures = 32
+	// maxRecordedOffer bounds the bytes of an inbound negotiation that are
+	// recorded to determine the protocols offered by the remote peer.
+	maxRecordedOffer = 1024
+)
+
+// NegotiationFailure describes a failed multistream negotiation.
+type NegotiationFailure = network.NegotiationFailure
+
+// ProtocolFamily returns the protocol ID without its version, e.g.
+// "/ipfs/kad" for "/ipfs/kad/1.0.0". Protocol IDs that don't end in a version
+// are their own family.
+func ProtocolFamily(p protocol.ID) string {
+	s := string(p)
+	if i := strings.LastIndexByte(s, '/'); i > 0 && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9' {
+		return s[:i]
+	}
+	return s
+}
+
+const metricNamespace = "libp2p_host"
+
+var negotiationFailuresTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Name:      "negotiation_failures_total",
+		Help:      "Failed protocol negotiations",
+	},
+	[]string{"dir", "family"},
+)
+
+// negotiationFailures keeps a log of the most recent negotiation failures.
+type negotiationFailures struct {
+	mu       sync.Mutex
+	failures []NegotiationFailure
+}
+
+func (nf *negotiationFailures) add(f NegotiationFailure, family string) {
+	negotiationFailuresTotal.WithLabelValues(metricshelper.GetDirection(f.Direction), family).Inc()
+
+	nf.mu.Lock()
+	defer nf.mu.Unlock()
+	if len(nf.failures) >= maxNegotiationFailures {
+		nf.failures = append(nf.failures[:0], nf.failures[1:]...)
+	}
+	nf.failures = append(nf.failures, f)
+}
+
+func (nf *negotiationFailures) list() []NegotiationFailure {
+	nf.mu.Lock()
+	defer nf.mu.Unlock()
+	return append([]NegotiationFailure(nil), nf.failures...)
+}
+
+// NegotiationFailures returns the most recent failed protocol negotiations,
+// oldest first. They are also counted per protocol family in the
+// libp2p_host_negotiation_failures_total metric.
+func (h *BasicHost) NegotiationFailures() []NegotiationFailure {
+	return h.negFailures.list()
+}
+
+// sameFamilies returns the protocols of protos that share a family with any
+// of the protocols in of.
+func sameFamilies(protos []protocol.ID, of []protocol.ID) []protocol.ID {
+	families := make(map[string]struct{}, len(of))
+	for _, p := range of {
+		families[ProtocolFamily(p)] = struct{}{}
+	}
+	var out []protocol.ID
+	for _, p := range protos {
+		if _, ok := families[ProtocolFamily(p)]; ok {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// recordFailure records f in the log of the host and, if the connection keeps
+// them, in the negotiation failures of c.
+func (h *BasicHost) recordFailure(c network.Conn, f NegotiationFailure, family string) {
+	h.negFailures.add(f, family)
+	if fc, ok := c.(network.NegotiationFailureConn); ok {
+		fc.RecordNegotiationFailure(f)
+	}
+}
+
+// recordInboundFailure records a failed negotiation on an inbound stream, if
+// the remote peer offered any protocols.
+func (h *BasicHost) recordInboundFailure(s network.Stream, rec *offerRecorder) {
+	offered := rec.offered()
+	if len(offered) == 0 {
+		return
+	}
+	supported := sameFamilies(h.Mux().Protocols(), offered)
+	// The offered protocols are chosen by the remote peer, so only families
+	// we support are used as metric labels.
+	family := "other"
+	if len(supported) > 0 {
+		family = ProtocolFamily(supported[0])
+	}
+	h.recordFailure(s.Conn(), NegotiationFailure{
+		Time:      time.Now(),
+		Peer:      s.Conn().RemotePeer(),
+		Direction: network.DirInbound,
+		Offered:   offered,
+		Supported: supported,
+	}, family)
+}
+
+// recordOutboundFailure records that the peer of c supports none of pids.
+func (h *BasicHost) recordOutboundFailure(c network.Conn, pids []protocol.ID) {
+	p := c.RemotePeer()
+	remote, _ := h.Peerstore().GetProtocols(p)
+	h.recordFailure(c, NegotiationFailure{
+		Time:      time.Now(),
+		Peer:      p,
+		Direction: network.DirOutbound,
+		Offered:   sameFamilies(remote, pids),
+		Supported: pids,
+	}, ProtocolFamily(pids[0]))
+}
+
+// offerRecorder records the beginning of an inbound negotiation, to determine
+// the protocols the remote peer proposed if the negotiation fails.
+type offerRecorder struct {
+	io.ReadWriteCloser
+	buf []byte
+	// parsed is the number of bytes of buf passed to onOffer.
+	parsed int
+	// onOffer, if set, is called with every protocol proposed by the remote
+	// peer, as soon as it is read.
+	onOffer func(protocol.ID)
+}
+
+func (r *offerRecorder) Read(b []byte) (int, error) {
+	n, err := r.ReadWriteCloser.Read(b)
+	if rem := maxRecordedOffer - len(r.buf); rem > 0 {
+		r.buf = append(r.buf, b[:min(n, rem)]...)
+		if r.onOffer != nil {
+			r.parseOffers()
+		}
+	}
+	return n, err
+}
+
+// parseOffers calls onOffer with the protocols read since the last call.
+func (r *offerRecorder) parseOffers() {
+	br := bytes.NewReader(r.buf[r.parsed:])
+	for {
+		tok, err := msmux.ReadNextToken[protocol.ID](br)
+		if err != nil {
+			return
+		}
+		r.parsed = len(r.buf) - br.Len()
+		if tok == msmux.ProtocolID || tok == "ls" {
+			continue
+		}
+		r.onOffer(tok)
+	}
+}
+
+// offered returns the protocols proposed by the remote peer.
+func (r *offerRecorder) offered() []protocol.ID {
+	var offered []protocol.ID
+	br := bytes.NewReader(r.buf)
+	for {
+		tok, err := msmux.ReadNextToken[protocol.ID](br)
+		if err != nil {
+			return offered
+		}
+		if tok == msmux.ProtocolID || tok == "ls" {
+			continue
+		}
+		offered = append(offered, tok)
+	}
+}
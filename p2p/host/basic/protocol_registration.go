@@ -0,0 +1,165 @@
+package basichost
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// protocolRegistration is a set of protocol handlers registered using
+// BasicHost.RegisterProtocols.
+type protocolRegistration struct {
+	h      *BasicHost
+	protos []protocol.ID
+
+	mx     sync.Mutex
+	closed bool
+	// inflight counts the handlers that are currently running
+	inflight sync.WaitGroup
+}
+
+var _ host.ProtocolRegistration = (*protocolRegistration)(nil)
+
+// RegisterProtocols sets the handlers for the given protocols on the Host's
+// Mux. It fails without setting any handler if one of the protocols already
+// has a handler. The returned registration owns the protocols until it is
+// closed, or until another handler is set for one of them using
+// SetStreamHandler.
+// (Thread-safe)
+func (h *BasicHost) RegisterProtocols(handlers map[protocol.ID]network.StreamHandler) (host.ProtocolRegistration, error) {
+	r := &protocolRegistration{h: h, protos: make([]protocol.ID, 0, len(handlers))}
+	for pid := range handlers {
+		r.protos = append(r.protos, pid)
+	}
+	slices.Sort(r.protos)
+
+	h.protoOwnersMu.Lock()
+	existing := h.Mux().Protocols()
+	for _, pid := range r.protos {
+		if _, ok := h.protoOwners[pid]; ok {
+			h.protoOwnersMu.Unlock()
+			return nil, fmt.Errorf("protocol %s is already registered", pid)
+		}
+		if slices.Contains(existing, pid) {
+			h.protoOwnersMu.Unlock()
+			return nil, fmt.Errorf("protocol %s already has a handler", pid)
+		}
+	}
+	if h.protoOwners == nil {
+		h.protoOwners = make(map[protocol.ID]*protocolRegistration)
+	}
+	if h.protoHandlers == nil {
+		h.protoHandlers = make(map[protocol.ID]protocol.HandlerFunc)
+	}
+	for _, pid := range r.protos {
+		hf := r.wrapHandler(handlers[pid])
+		h.protoOwners[pid] = r
+		h.protoHandlers[pid] = hf
+		h.Mux().AddHandler(pid, hf)
+	}
+	h.protoOwnersMu.Unlock()
+
+	h.emitters.evtLocalProtocolsUpdated.Emit(event.EvtLocalProtocolsUpdated{
+		Added: slices.Clone(r.protos),
+	})
+	return r, nil
+}
+
+// disownProtocol removes the owner of pid, if any, so that closing it doesn't
+// remove the handler of pid. It also drops the shim and the handler for pid.
+func (h *BasicHost) disownProtocol(pid protocol.ID) {
+	h.protoOwnersMu.Lock()
+	delete(h.protoOwners, pid)
+	delete(h.protoShims, pid)
+	delete(h.protoHandlers, pid)
+	h.protoOwnersMu.Unlock()
+}
+
+// claimProtocol is like disownProtocol, but records handler as the handler of
+// pid.
+func (h *BasicHost) claimProtocol(pid protocol.ID, handler protocol.HandlerFunc) {
+	h.protoOwnersMu.Lock()
+	delete(h.protoOwners, pid)
+	delete(h.protoShims, pid)
+	if h.protoHandlers == nil {
+		h.protoHandlers = make(map[protocol.ID]protocol.HandlerFunc)
+	}
+	h.protoHandlers[pid] = handler
+	h.protoOwnersMu.Unlock()
+}
+
+// protocolHandler returns the handler set through the host for pid.
+func (h *BasicHost) protocolHandler(pid protocol.ID) (protocol.HandlerFunc, bool) {
+	h.protoOwnersMu.Lock()
+	defer h.protoOwnersMu.Unlock()
+	handler, ok := h.protoHandlers[pid]
+	return handler, ok
+}
+
+func (r *protocolRegistration) wrapHandler(handler network.StreamHandler) protocol.HandlerFunc {
+	return func(_ protocol.ID, rwc io.ReadWriteCloser) error {
+		s := rwc.(network.Stream)
+		r.mx.Lock()
+		if r.closed {
+			r.mx.Unlock()
+			s.Reset()
+			return nil
+		}
+		r.inflight.Add(1)
+		r.mx.Unlock()
+
+		defer r.inflight.Done()
+		handler(s)
+		return nil
+	}
+}
+
+func (r *protocolRegistration) Protocols() []protocol.ID {
+	r.h.protoOwnersMu.Lock()
+	defer r.h.protoOwnersMu.Unlock()
+
+	protos := make([]protocol.ID, 0, len(r.protos))
+	for _, pid := range r.protos {
+		if r.h.protoOwners[pid] == r {
+			protos = append(protos, pid)
+		}
+	}
+	return protos
+}
+
+func (r *protocolRegistration) Close() error {
+	r.mx.Lock()
+	if r.closed {
+		r.mx.Unlock()
+		return nil
+	}
+	r.closed = true
+	r.mx.Unlock()
+
+	var removed []protocol.ID
+	r.h.protoOwnersMu.Lock()
+	for _, pid := range r.protos {
+		if r.h.protoOwners[pid] != r {
+			continue
+		}
+		delete(r.h.protoOwners, pid)
+		delete(r.h.protoHandlers, pid)
+		r.h.Mux().RemoveHandler(pid)
+		removed = append(removed, pid)
+	}
+	r.h.protoOwnersMu.Unlock()
+
+	if len(removed) > 0 {
+		r.h.emitters.evtLocalProtocolsUpdated.Emit(event.EvtLocalProtocolsUpdated{
+			Removed: removed,
+		})
+	}
+	r.inflight.Wait()
+	return nil
+}
@@ -0,0 +1,130 @@
+package basichost
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sync/atomic"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var protocolShimStreamsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Name:      "protocol_shim_streams_total",
+		Help:      "Inbound streams accepted by protocol shims",
+	},
+	[]string{"from", "to", "result"},
+)
+
+// protocolShim is a shim added using BasicHost.AddProtocolShim.
+type protocolShim struct {
+	host.ProtocolShim
+	streams  atomic.Uint64
+	failures atomic.Uint64
+}
+
+// AddProtocolShim sets a handler for shim.From that passes the streams to the
+// handler of shim.To, after adapting them using shim.Adapt. The handler of
+// shim.To has to be set through the host, e.g. using SetStreamHandler, and not
+// directly on the Mux: the shim resets the streams when there is none. Streams
+// accepted by the shim are counted in the
+// libp2p_host_protocol_shim_streams_total metric.
+// (Thread-safe)
+func (h *BasicHost) AddProtocolShim(shim host.ProtocolShim) error {
+	if shim.From == "" || shim.To == "" {
+		return fmt.Errorf("protocol shim needs a From and a To protocol")
+	}
+	if shim.From == shim.To {
+		return fmt.Errorf("protocol shim for %s translates to itself", shim.From)
+	}
+	ps := &protocolShim{ProtocolShim: shim}
+
+	h.protoOwnersMu.Lock()
+	if _, ok := h.protoShims[shim.To]; ok {
+		h.protoOwnersMu.Unlock()
+		return fmt.Errorf("protocol %s is accepted by a shim itself", shim.To)
+	}
+	if slices.Contains(h.Mux().Protocols(), shim.From) {
+		h.protoOwnersMu.Unlock()
+		return fmt.Errorf("protocol %s already has a handler", shim.From)
+	}
+	if h.protoShims == nil {
+		h.protoShims = make(map[protocol.ID]*protocolShim)
+	}
+	h.protoShims[shim.From] = ps
+	h.Mux().AddHandler(shim.From, h.shimHandler(ps))
+	h.protoOwnersMu.Unlock()
+
+	h.emitters.evtLocalProtocolsUpdated.Emit(event.EvtLocalProtocolsUpdated{
+		Added: []protocol.ID{shim.From},
+	})
+	return nil
+}
+
+// RemoveProtocolShim removes the shim for the protocol from, if any.
+// (Thread-safe)
+func (h *BasicHost) RemoveProtocolShim(from protocol.ID) {
+	h.protoOwnersMu.Lock()
+	if _, ok := h.protoShims[from]; !ok {
+		h.protoOwnersMu.Unlock()
+		return
+	}
+	delete(h.protoShims, from)
+	h.Mux().RemoveHandler(from)
+	h.protoOwnersMu.Unlock()
+
+	h.emitters.evtLocalProtocolsUpdated.Emit(event.EvtLocalProtocolsUpdated{
+		Removed: []protocol.ID{from},
+	})
+}
+
+// ProtocolShims returns the statistics of the shims added using
+// AddProtocolShim, keyed by the protocol they accept.
+func (h *BasicHost) ProtocolShims() map[protocol.ID]host.ProtocolShimStats {
+	h.protoOwnersMu.Lock()
+	defer h.protoOwnersMu.Unlock()
+
+	stats := make(map[protocol.ID]host.ProtocolShimStats, len(h.protoShims))
+	for from, ps := range h.protoShims {
+		stats[from] = host.ProtocolShimStats{
+			To:       ps.To,
+			Streams:  ps.streams.Load(),
+			Failures: ps.failures.Load(),
+		}
+	}
+	return stats
+}
+
+func (h *BasicHost) shimHandler(ps *protocolShim) protocol.HandlerFunc {
+	fail := func(s network.Stream, reason string, err error) error {
+		log.Debugw("protocol shim failed", "from", ps.From, "to", ps.To, "stream", s.ID(), "peer", s.Conn().RemotePeer().Short(), "reason", reason, "error", err)
+		ps.failures.Add(1)
+		protocolShimStreamsTotal.WithLabelValues(string(ps.From), string(ps.To), reason).Inc()
+		s.Reset()
+		return nil
+	}
+	return func(_ protocol.ID, rwc io.ReadWriteCloser) error {
+		s := rwc.(network.Stream)
+		handler, ok := h.protocolHandler(ps.To)
+		if !ok {
+			return fail(s, "no_handler", nil)
+		}
+		if ps.Adapt != nil {
+			as, err := ps.Adapt(s)
+			if err != nil {
+				return fail(s, "adapt_failed", err)
+			}
+			s = as
+		}
+		ps.streams.Add(1)
+		protocolShimStreamsTotal.WithLabelValues(string(ps.From), string(ps.To), "ok").Inc()
+		return handler(ps.To, s)
+	}
+}
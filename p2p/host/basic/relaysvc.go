@@ -0,0 +1,14 @@
+//go:build !libp2plite
+
+package basichost
+
+import (
+	"io"
+
+	"github.com/libp2p/go-libp2p/p2p/host/relaysvc"
+	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+)
+
+func newRelayService(h *BasicHost, opts []relayv2.Option) io.Closer {
+	return relaysvc.NewRelayManager(h, opts...)
+}
@@ -0,0 +1,16 @@
+//go:build libp2plite
+
+package basichost
+
+import (
+	"io"
+
+	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+)
+
+// The relay service is not compiled into libp2plite builds.
+
+func newRelayService(*BasicHost, []relayv2.Option) io.Closer {
+	log.Warn("relay service is not available in libp2plite builds")
+	return nil
+}
@@ -0,0 +1,131 @@
+package basichost
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	streamOpenDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricNamespace,
+			Name:      "stream_open_seconds",
+			Help:      "Time to open outbound streams, including dialing and protocol negotiation",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16),
+		},
+		[]string{"protocol", "result"},
+	)
+	streamOpenSLOViolationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "stream_open_slo_violations_total",
+			Help:      "Times the stream open latency objective of a protocol was exceeded for the configured number of consecutive streams",
+		},
+		[]string{"protocol"},
+	)
+)
+
+// StreamOpenSLO is a latency objective for opening outbound streams for a
+// protocol, see BasicHost.SetStreamOpenSLO.
+type StreamOpenSLO = host.StreamOpenSLO
+
+// StreamOpenSLOViolation describes the streams that missed a StreamOpenSLO.
+type StreamOpenSLOViolation = host.StreamOpenSLOViolation
+
+// streamOpenSLOTracker tracks a StreamOpenSLO. It must be used with
+// streamOpenSLOs.mu held.
+type streamOpenSLOTracker struct {
+	slo StreamOpenSLO
+	// violation collects the consecutive streams that missed the objective
+	violation StreamOpenSLOViolation
+}
+
+// streamOpenSLOs holds the latency objectives per protocol.
+type streamOpenSLOs struct {
+	mu       sync.Mutex
+	trackers map[protocol.ID]*streamOpenSLOTracker
+}
+
+func (s *streamOpenSLOs) set(pid protocol.ID, slo StreamOpenSLO) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if slo.Latency <= 0 {
+		delete(s.trackers, pid)
+		return
+	}
+	if slo.Consecutive <= 0 {
+		slo.Consecutive = 1
+	}
+	if s.trackers == nil {
+		s.trackers = make(map[protocol.ID]*streamOpenSLOTracker)
+	}
+	s.trackers[pid] = &streamOpenSLOTracker{slo: slo}
+}
+
+// record records that opening a stream for pid to p took d, and failed with
+// err if it's not nil.
+func (s *streamOpenSLOs) record(pid protocol.ID, p peer.ID, d time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	streamOpenDuration.WithLabelValues(string(pid), result).Observe(d.Seconds())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.trackers[pid]
+	if !ok {
+		return
+	}
+	if err == nil && d <= t.slo.Latency {
+		t.violation = StreamOpenSLOViolation{}
+		return
+	}
+	t.violation.Peers = append(t.violation.Peers, p)
+	t.violation.Latencies = append(t.violation.Latencies, d)
+	t.violation.Errors = append(t.violation.Errors, err)
+	if len(t.violation.Latencies) < t.slo.Consecutive {
+		return
+	}
+	v := t.violation
+	v.Protocol = pid
+	t.violation = StreamOpenSLOViolation{}
+	streamOpenSLOViolationsTotal.WithLabelValues(string(pid)).Inc()
+	if t.slo.OnViolation != nil {
+		go t.slo.OnViolation(v)
+	}
+}
+
+// SetStreamOpenSLO sets a latency objective for opening outbound streams
+// for pid. Streams are attributed to their negotiated protocol, or to the
+// first of the requested protocols if they fail to open. Streams that fail to
+// open miss the objective, unless the caller canceled their context.
+// An objective with a Latency of 0 removes the objective for pid.
+//
+// The time to open streams is observed in the libp2p_host_stream_open_seconds
+// metric for all protocols, and violations of the objective are counted in
+// the libp2p_host_stream_open_slo_violations_total metric.
+// (Thread-safe)
+func (h *BasicHost) SetStreamOpenSLO(pid protocol.ID, slo StreamOpenSLO) {
+	h.streamOpenSLOs.set(pid, slo)
+}
+
+// recordStreamOpen records the outcome of NewStream.
+func (h *BasicHost) recordStreamOpen(ctx context.Context, p peer.ID, pids []protocol.ID, selected protocol.ID, start time.Time, err error) {
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+		return
+	}
+	pid := selected
+	if pid == "" && len(pids) > 0 {
+		pid = pids[0]
+	}
+	h.streamOpenSLOs.record(pid, p, time.Since(start), err)
+}
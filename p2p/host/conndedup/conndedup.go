@@ -0,0 +1,455 @@
+// Package conndedup closes redundant connections to peers.
+//
+// When AutoRelay, hole punching (DCUtR) and direct dials all succeed, a host
+// can end up with several connections to the same peer. The Deduplicator
+// regularly checks the connections to every peer, picks the best one using a
+// list of rules, and closes the others once they have been redundant for a
+// grace period. Connections that still have open streams are marked as
+// draining first, so that new streams use the kept connection, and are closed
+// once their streams are done (or when the drain timeout expires).
+//
+// For every closed connection, an event.EvtRedundantConnClosed is emitted,
+// naming the rule that decided between the kept and the closed connection.
+//
+// Both sides of a connection may run a Deduplicator. If the peers kept
+// different connections, they would close all of them. To avoid this, the peer
+// with the higher peer ID waits twice the grace period, giving the other peer
+// the chance to close the redundant connections first. Rules should still be
+// symmetric where possible, i.e. produce the same ranking on both sides.
+//
+// Use swarm.WithConnComparator with Comparator, or the libp2p.DeduplicateConns
+// option, so that the swarm opens new streams on the connection the
+// Deduplicator keeps.
+package conndedup
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+var log = logging.Logger("conndedup")
+
+const (
+	defaultInterval     = 5 * time.Second
+	defaultGracePeriod  = 30 * time.Second
+	defaultDrainTimeout = time.Minute
+)
+
+// Rule compares two connections to the same peer.
+type Rule struct {
+	// Name is used as the reason in events and logs when the rule decided
+	// which connection to keep.
+	Name string
+	// Compare returns a negative number if a is better than b, a positive
+	// number if b is better than a, and 0 if the rule doesn't prefer either.
+	Compare func(a, b network.Conn) int
+}
+
+// PreferDirect prefers direct connections over relayed (and other transient)
+// connections.
+var PreferDirect = Rule{
+	Name: "direct",
+	Compare: func(a, b network.Conn) int {
+		return compareBool(isDirect(a), isDirect(b))
+	},
+}
+
+// PreferNotDraining prefers connections that aren't draining, e.g. because
+// they were found to be unhealthy (see the connquality package).
+var PreferNotDraining = Rule{
+	Name: "not draining",
+	Compare: func(a, b network.Conn) int {
+		return compareBool(!isDraining(a), !isDraining(b))
+	},
+}
+
+// PreferTransports prefers transports in the given order, as reported by
+// ConnState().Transport (e.g. "quic-v1", "tcp"). Transports that are not
+// listed rank last.
+func PreferTransports(transports ...string) Rule {
+	rank := func(c network.Conn) int {
+		t := c.ConnState().Transport
+		for i, tr := range transports {
+			if tr == t {
+				return i
+			}
+		}
+		return len(transports)
+	}
+	return Rule{
+		Name: "transport",
+		Compare: func(a, b network.Conn) int {
+			return rank(a) - rank(b)
+		},
+	}
+}
+
+// PreferIntent prefers connections that were dialed for more bandwidth, see
+// network.WithIntent. Connections without an intent, including all inbound
+// connections, rank like connections with an unknown bandwidth class. Since
+// only the dialing peer knows the intent, the rule isn't symmetric, and is not
+// part of the DefaultRules.
+var PreferIntent = Rule{
+	Name: "intent",
+	Compare: func(a, b network.Conn) int {
+		return intentRank(a) - intentRank(b)
+	},
+}
+
+func intentRank(c network.Conn) int {
+	intent, _ := network.ConnIntent(c)
+	switch intent.Bandwidth {
+	case network.BandwidthHigh:
+		return 0
+	case network.BandwidthLow:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// PreferOlder prefers the connection that was opened first. Opening times are
+// compared in units of tolerance, so that the rule is transitive, and both
+// peers agree on the order unless the connections were opened less than
+// tolerance apart.
+func PreferOlder(tolerance time.Duration) Rule {
+	opened := func(c network.Conn) int64 {
+		t := c.Stat().Opened.UnixNano()
+		if tolerance <= 0 {
+			return t
+		}
+		return t / int64(tolerance)
+	}
+	return Rule{
+		Name: "older",
+		Compare: func(a, b network.Conn) int {
+			return cmp.Compare(opened(a), opened(b))
+		},
+	}
+}
+
+// DefaultRules are the rules used by default, in order.
+var DefaultRules = []Rule{
+	PreferDirect,
+	PreferNotDraining,
+	PreferTransports("quic-v1", "webtransport", "tcp", "websocket", "webrtc-direct"),
+	PreferOlder(time.Second),
+}
+
+// tieBreak is applied after all other rules, making the order a strict total
+// order. It only uses what both peers know about a connection, so that they
+// agree on the result: it prefers the connections dialed by the peer with the
+// lower peer ID, and then orders connections by the address they were dialed
+// on. Only if these are equal as well, e.g. for two relayed connections
+// through the same relay, it falls back to the opening time and the connection
+// ID, which are only known locally.
+var tieBreak = Rule{
+	Name: "tie-break",
+	Compare: func(a, b network.Conn) int {
+		if c := compareBool(dialedByLowerID(a), dialedByLowerID(b)); c != 0 {
+			return c
+		}
+		if c := bytes.Compare(dialedAddr(a).Bytes(), dialedAddr(b).Bytes()); c != 0 {
+			return c
+		}
+		if c := a.Stat().Opened.Compare(b.Stat().Opened); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID(), b.ID())
+	},
+}
+
+type Option func(*Deduplicator) error
+
+// WithInterval sets the interval at which connections are checked.
+func WithInterval(d time.Duration) Option {
+	return func(dd *Deduplicator) error {
+		if d <= 0 {
+			return errors.New("interval must be positive")
+		}
+		dd.interval = d
+		return nil
+	}
+}
+
+// WithGracePeriod sets the time a connection has to be redundant before it's
+// closed. This gives applications time to move their streams to the better
+// connection. If the remote peer has a lower peer ID, the connection is closed
+// after twice the grace period.
+func WithGracePeriod(d time.Duration) Option {
+	return func(dd *Deduplicator) error {
+		if d < 0 {
+			return errors.New("grace period must not be negative")
+		}
+		dd.gracePeriod = d
+		return nil
+	}
+}
+
+// WithDrainTimeout sets the time a redundant connection with open streams is
+// kept after the grace period, waiting for its streams to be closed.
+func WithDrainTimeout(d time.Duration) Option {
+	return func(dd *Deduplicator) error {
+		if d < 0 {
+			return errors.New("drain timeout must not be negative")
+		}
+		dd.drainTimeout = d
+		return nil
+	}
+}
+
+// WithRules sets the rules used to pick the best connection to a peer.
+// The rules are applied in order: the first rule that prefers one connection
+// over the other decides.
+func WithRules(rules ...Rule) Option {
+	return func(dd *Deduplicator) error {
+		for _, r := range rules {
+			if r.Compare == nil {
+				return errors.New("rule without compare function")
+			}
+		}
+		dd.rules = rules
+		return nil
+	}
+}
+
+// Comparator returns the order in which a Deduplicator created with opts ranks
+// the connections to a peer: a negative number if a is better than b, a
+// positive number if b is better than a. It is a strict total order, i.e. it
+// only returns 0 for the same connection.
+func Comparator(opts ...Option) (func(a, b network.Conn) int, error) {
+	d := &Deduplicator{rules: DefaultRules}
+	for _, opt := range opts {
+		if err := opt(d); err != nil {
+			return nil, err
+		}
+	}
+	return d.compare, nil
+}
+
+// Deduplicator closes redundant connections.
+type Deduplicator struct {
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+
+	host    host.Host
+	emitter event.Emitter
+
+	interval     time.Duration
+	gracePeriod  time.Duration
+	drainTimeout time.Duration
+	rules        []Rule
+
+	// redundant holds the connections that are currently redundant. It is only
+	// accessed from the background go routine.
+	redundant map[network.Conn]*redundantConn
+
+	refCount sync.WaitGroup
+}
+
+type redundantConn struct {
+	since         time.Time
+	drainingSince time.Time // zero if we haven't started draining the connection
+}
+
+type drainer interface {
+	StartDraining()
+	IsDraining() bool
+}
+
+// New creates a new Deduplicator, and starts checking the host's connections.
+func New(h host.Host, opts ...Option) (*Deduplicator, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Deduplicator{
+		ctx:          ctx,
+		ctxCancel:    cancel,
+		host:         h,
+		interval:     defaultInterval,
+		gracePeriod:  defaultGracePeriod,
+		drainTimeout: defaultDrainTimeout,
+		rules:        DefaultRules,
+		redundant:    make(map[network.Conn]*redundantConn),
+	}
+	for _, opt := range opts {
+		if err := opt(d); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	emitter, err := h.EventBus().Emitter(new(event.EvtRedundantConnClosed))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	d.emitter = emitter
+
+	d.refCount.Add(1)
+	go d.background()
+	return d, nil
+}
+
+// Close stops the Deduplicator. Connections are not closed.
+func (d *Deduplicator) Close() error {
+	d.ctxCancel()
+	d.refCount.Wait()
+	return d.emitter.Close()
+}
+
+func (d *Deduplicator) background() {
+	defer d.refCount.Done()
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.checkConns(time.Now())
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *Deduplicator) checkConns(now time.Time) {
+	redundant := make(map[network.Conn]*redundantConn, len(d.redundant))
+	for _, p := range d.host.Network().Peers() {
+		conns := d.host.Network().ConnsToPeer(p)
+		if len(conns) < 2 {
+			continue
+		}
+		open := conns[:0]
+		for _, c := range conns {
+			if !c.IsClosed() {
+				open = append(open, c)
+			}
+		}
+		if len(open) < 2 {
+			continue
+		}
+		d.sort(open)
+		best := open[0]
+		for _, c := range open[1:] {
+			rc, ok := d.redundant[c]
+			if !ok {
+				rc = &redundantConn{since: now}
+				log.Debugw("redundant connection", "peer", p.Short(), "addr", c.RemoteMultiaddr(), "kept", best.RemoteMultiaddr(), "reason", d.reason(best, c))
+			}
+			if !d.handleRedundant(best, c, rc, now) {
+				redundant[c] = rc
+			}
+		}
+	}
+	d.redundant = redundant
+}
+
+// handleRedundant closes c, if the grace period has passed and its streams are
+// done. It returns true if c was closed.
+func (d *Deduplicator) handleRedundant(best, c network.Conn, rc *redundantConn, now time.Time) bool {
+	grace := d.gracePeriod
+	if c.RemotePeer() < d.host.ID() {
+		grace *= 2
+	}
+	if now.Sub(rc.since) < grace {
+		return false
+	}
+	if len(c.GetStreams()) > 0 {
+		if rc.drainingSince.IsZero() {
+			rc.drainingSince = now
+			if dr, ok := c.(drainer); ok {
+				dr.StartDraining()
+			}
+		}
+		if now.Sub(rc.drainingSince) < d.drainTimeout {
+			return false
+		}
+	}
+
+	reason := d.reason(best, c)
+	log.Debugw("closing redundant connection", "peer", c.RemotePeer().Short(), "addr", c.RemoteMultiaddr(), "kept", best.RemoteMultiaddr(), "reason", reason)
+	if err := c.Close(); err != nil {
+		log.Debugw("failed to close redundant connection", "peer", c.RemotePeer().Short(), "error", err)
+	}
+	d.emitter.Emit(event.EvtRedundantConnClosed{
+		Peer:   c.RemotePeer(),
+		Closed: c,
+		Kept:   best,
+		Reason: reason,
+	})
+	return true
+}
+
+// sort sorts conns from best to worst.
+func (d *Deduplicator) sort(conns []network.Conn) {
+	sort.SliceStable(conns, func(i, j int) bool {
+		return d.compare(conns[i], conns[j]) < 0
+	})
+}
+
+func (d *Deduplicator) compare(a, b network.Conn) int {
+	_, res := d.decidingRule(a, b)
+	return res
+}
+
+// reason returns the name of the rule by which best is preferred over c.
+func (d *Deduplicator) reason(best, c network.Conn) string {
+	r, _ := d.decidingRule(best, c)
+	return r.Name
+}
+
+func (d *Deduplicator) decidingRule(a, b network.Conn) (Rule, int) {
+	for _, r := range d.rules {
+		if res := r.Compare(a, b); res != 0 {
+			return r, res
+		}
+	}
+	return tieBreak, tieBreak.Compare(a, b)
+}
+
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return -1
+	default:
+		return 1
+	}
+}
+
+func isDirect(c network.Conn) bool {
+	if c.Stat().Transient {
+		return false
+	}
+	_, err := c.RemoteMultiaddr().ValueForProtocol(ma.P_CIRCUIT)
+	return err != nil
+}
+
+func isDraining(c network.Conn) bool {
+	dr, ok := c.(drainer)
+	return ok && dr.IsDraining()
+}
+
+func dialedByLowerID(c network.Conn) bool {
+	return (c.Stat().Direction == network.DirOutbound) == (c.LocalPeer() < c.RemotePeer())
+}
+
+// dialedAddr returns the address c was dialed on.
+func dialedAddr(c network.Conn) ma.Multiaddr {
+	if c.Stat().Direction == network.DirOutbound {
+		return c.RemoteMultiaddr()
+	}
+	return c.LocalMultiaddr()
+}
@@ -0,0 +1,155 @@
+package conndedup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+type mockConn struct {
+	network.Conn
+	id            string
+	local, remote peer.ID
+	dir           network.Direction
+	transport     string
+	transient     bool
+	opened        time.Time
+	localAddr     ma.Multiaddr
+	remoteAddr    ma.Multiaddr
+	extra         map[interface{}]interface{}
+}
+
+func (c *mockConn) ID() string { return c.id }
+
+func (c *mockConn) ConnState() network.ConnectionState {
+	return network.ConnectionState{Transport: c.transport}
+}
+
+func (c *mockConn) Stat() network.ConnStats {
+	return network.ConnStats{Stats: network.Stats{Direction: c.dir, Opened: c.opened, Transient: c.transient, Extra: c.extra}}
+}
+
+func (c *mockConn) LocalPeer() peer.ID  { return c.local }
+func (c *mockConn) RemotePeer() peer.ID { return c.remote }
+
+func (c *mockConn) LocalMultiaddr() ma.Multiaddr {
+	if c.localAddr == nil {
+		return ma.StringCast("/ip4/127.0.0.1/tcp/1")
+	}
+	return c.localAddr
+}
+func (c *mockConn) RemoteMultiaddr() ma.Multiaddr { return c.remoteAddr }
+
+// mirror returns c as seen by the remote peer.
+func (c *mockConn) mirror() *mockConn {
+	m := *c
+	m.local, m.remote = c.remote, c.local
+	m.localAddr, m.remoteAddr = c.remoteAddr, c.LocalMultiaddr()
+	switch c.dir {
+	case network.DirOutbound:
+		m.dir = network.DirInbound
+	case network.DirInbound:
+		m.dir = network.DirOutbound
+	}
+	return &m
+}
+
+func TestRules(t *testing.T) {
+	d := &Deduplicator{rules: DefaultRules}
+	now := time.Now()
+	tcp := &mockConn{dir: network.DirOutbound, transport: "tcp", opened: now, remoteAddr: ma.StringCast("/ip4/1.2.3.4/tcp/1")}
+	tcpOld := &mockConn{transport: "tcp", opened: now.Add(-time.Minute), remoteAddr: ma.StringCast("/ip4/1.2.3.4/tcp/2")}
+	quic := &mockConn{transport: "quic-v1", opened: now, remoteAddr: ma.StringCast("/ip4/1.2.3.4/udp/1/quic-v1")}
+	relay := &mockConn{
+		transport:  "p2p-circuit",
+		transient:  true,
+		opened:     now.Add(-time.Hour),
+		remoteAddr: ma.StringCast("/ip4/1.2.3.4/tcp/1/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC/p2p-circuit"),
+	}
+
+	conns := []network.Conn{relay, tcp, quic, tcpOld}
+	d.sort(conns)
+	require.Equal(t, []network.Conn{quic, tcpOld, tcp, relay}, conns)
+	require.Equal(t, "direct", d.reason(tcp, relay))
+	require.Equal(t, "transport", d.reason(quic, tcp))
+	require.Equal(t, "older", d.reason(tcpOld, tcp))
+
+	tcp2 := &mockConn{dir: network.DirOutbound, transport: "tcp", opened: now, remoteAddr: ma.StringCast("/ip4/1.2.3.4/tcp/3")}
+	require.Equal(t, "tie-break", d.reason(tcp, tcp2))
+	require.Negative(t, d.compare(tcp, tcp2))
+	require.Positive(t, d.compare(tcp2, tcp))
+}
+
+func TestPreferOlderIsTransitive(t *testing.T) {
+	d := &Deduplicator{rules: []Rule{PreferOlder(time.Second)}}
+	start := time.Unix(1000, 0)
+	// pairwise within a second of each other, but a and c are not
+	a := &mockConn{id: "a", opened: start.Add(100 * time.Millisecond)}
+	b := &mockConn{id: "b", opened: start.Add(900 * time.Millisecond)}
+	c := &mockConn{id: "c", opened: start.Add(1700 * time.Millisecond)}
+	for _, conns := range [][]network.Conn{{a, b, c}, {c, b, a}, {b, c, a}, {c, a, b}} {
+		d.sort(conns)
+		require.Equal(t, []network.Conn{a, b, c}, conns)
+	}
+	require.Equal(t, "older", d.reason(a, c))
+	require.Equal(t, "tie-break", d.reason(a, b))
+}
+
+func TestTieBreakIsSymmetric(t *testing.T) {
+	d := &Deduplicator{}
+	const p1, p2 = peer.ID("peer1"), peer.ID("peer2")
+	now := time.Now()
+	// two connections in opposite directions, and a second one dialed by p1
+	out := &mockConn{id: "1", local: p1, remote: p2, dir: network.DirOutbound, opened: now,
+		localAddr: ma.StringCast("/ip4/1.1.1.1/tcp/1000"), remoteAddr: ma.StringCast("/ip4/2.2.2.2/tcp/1")}
+	in := &mockConn{id: "2", local: p1, remote: p2, dir: network.DirInbound, opened: now.Add(-time.Minute),
+		localAddr: ma.StringCast("/ip4/1.1.1.1/tcp/1"), remoteAddr: ma.StringCast("/ip4/2.2.2.2/tcp/2000")}
+	out2 := &mockConn{id: "3", local: p1, remote: p2, dir: network.DirOutbound, opened: now.Add(-time.Hour),
+		localAddr: ma.StringCast("/ip4/1.1.1.1/tcp/1001"), remoteAddr: ma.StringCast("/ip4/2.2.2.2/tcp/2")}
+
+	conns := []network.Conn{in, out2, out}
+	d.sort(conns)
+	require.Equal(t, []network.Conn{out, out2, in}, conns)
+
+	// The remote peer ranks the connections the same way, even though the
+	// local opening times and IDs differ.
+	mOut, mIn, mOut2 := out.mirror(), in.mirror(), out2.mirror()
+	mOut.id, mOut.opened = "9", now.Add(time.Hour)
+	conns = []network.Conn{mOut, mOut2, mIn}
+	d.sort(conns)
+	require.Equal(t, []network.Conn{mOut, mOut2, mIn}, conns)
+}
+
+func TestComparator(t *testing.T) {
+	_, err := Comparator(WithRules(Rule{Name: "broken"}))
+	require.Error(t, err)
+
+	cmp, err := Comparator(WithRules(PreferTransports("tcp")))
+	require.NoError(t, err)
+	tcp := &mockConn{id: "1", transport: "tcp", remoteAddr: ma.StringCast("/ip4/1.2.3.4/tcp/1")}
+	quic := &mockConn{id: "2", transport: "quic-v1", remoteAddr: ma.StringCast("/ip4/1.2.3.4/udp/1/quic-v1")}
+	require.Negative(t, cmp(tcp, quic))
+	require.Positive(t, cmp(quic, tcp))
+	require.Zero(t, cmp(tcp, tcp))
+}
+
+func TestPreferIntent(t *testing.T) {
+	d := &Deduplicator{rules: []Rule{PreferIntent}}
+	withIntent := func(bw network.BandwidthClass) map[interface{}]interface{} {
+		return network.GetConnValues(network.WithIntent(context.Background(), network.Intent{Bandwidth: bw}))
+	}
+	high := &mockConn{remoteAddr: ma.StringCast("/ip4/1.2.3.4/tcp/1"), extra: withIntent(network.BandwidthHigh)}
+	low := &mockConn{remoteAddr: ma.StringCast("/ip4/1.2.3.4/tcp/2"), extra: withIntent(network.BandwidthLow)}
+	inbound := &mockConn{remoteAddr: ma.StringCast("/ip4/1.2.3.4/tcp/3")}
+
+	conns := []network.Conn{low, inbound, high}
+	d.sort(conns)
+	require.Equal(t, []network.Conn{high, inbound, low}, conns)
+	require.Equal(t, "intent", d.reason(high, low))
+}
@@ -0,0 +1,95 @@
+package conndedup_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/host/conndedup"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func newHost(t *testing.T, opts ...libp2p.Option) host.Host {
+	t.Helper()
+	opts = append(opts, libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0", "/ip4/127.0.0.1/tcp/0/ws"))
+	h, err := libp2p.New(opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func isWebsocket(a ma.Multiaddr) bool {
+	_, err := a.ValueForProtocol(ma.P_WS)
+	return err == nil
+}
+
+func TestCloseRedundantConn(t *testing.T) {
+	h1 := newHost(t)
+	h2 := newHost(t)
+
+	sub, err := h1.EventBus().Subscribe(new(event.EvtRedundantConnClosed))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	d, err := conndedup.New(h1, conndedup.WithInterval(50*time.Millisecond), conndedup.WithGracePeriod(200*time.Millisecond))
+	require.NoError(t, err)
+	defer d.Close()
+
+	// connect over websocket first, then over plain TCP
+	h1.Peerstore().AddAddrs(h2.ID(), h2.Addrs(), time.Hour)
+	ctx := network.WithDialAddrFilter(context.Background(), isWebsocket, "test")
+	wsConn, err := h1.Network().DialPeer(ctx, h2.ID())
+	require.NoError(t, err)
+	ctx = network.WithDialAddrFilter(context.Background(), func(a ma.Multiaddr) bool { return !isWebsocket(a) }, "test")
+	tcpConn, err := h1.Network().DialPeer(ctx, h2.ID())
+	require.NoError(t, err)
+	require.NotEqual(t, wsConn, tcpConn)
+	require.Len(t, h1.Network().ConnsToPeer(h2.ID()), 2)
+
+	select {
+	case e := <-sub.Out():
+		evt := e.(event.EvtRedundantConnClosed)
+		require.Equal(t, h2.ID(), evt.Peer)
+		require.Equal(t, wsConn, evt.Closed)
+		require.Equal(t, tcpConn, evt.Kept)
+		require.Equal(t, "transport", evt.Reason)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the redundant connection to be closed")
+	}
+	require.Equal(t, []network.Conn{tcpConn}, h1.Network().ConnsToPeer(h2.ID()))
+	require.Equal(t, network.Connected, h1.Network().Connectedness(h2.ID()))
+}
+
+func TestDeduplicateConnsOption(t *testing.T) {
+	h1 := newHost(t, libp2p.DeduplicateConns(
+		conndedup.WithInterval(50*time.Millisecond),
+		conndedup.WithGracePeriod(200*time.Millisecond),
+	))
+	h2 := newHost(t)
+	h2.SetStreamHandler("/test", func(s network.Stream) { s.Close() })
+
+	// connect over plain TCP first, then over websocket
+	h1.Peerstore().AddAddrs(h2.ID(), h2.Addrs(), time.Hour)
+	ctx := network.WithDialAddrFilter(context.Background(), func(a ma.Multiaddr) bool { return !isWebsocket(a) }, "test")
+	tcpConn, err := h1.Network().DialPeer(ctx, h2.ID())
+	require.NoError(t, err)
+	ctx = network.WithDialAddrFilter(context.Background(), isWebsocket, "test")
+	wsConn, err := h1.Network().DialPeer(ctx, h2.ID())
+	require.NoError(t, err)
+	require.NotEqual(t, wsConn, tcpConn)
+
+	// new streams use the connection the deduplicator keeps
+	s, err := h1.NewStream(context.Background(), h2.ID(), "/test")
+	require.NoError(t, err)
+	require.Equal(t, tcpConn, s.Conn())
+	s.Close()
+
+	require.Eventually(t, wsConn.IsClosed, 5*time.Second, 50*time.Millisecond)
+	require.Equal(t, []network.Conn{tcpConn}, h1.Network().ConnsToPeer(h2.ID()))
+}
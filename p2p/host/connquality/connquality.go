@@ -0,0 +1,361 @@
+// Package connquality implements a monitor for the health of a host's connections.
+//
+// The Monitor regularly pings every connection and checks the health counters
+// the swarm keeps for it (stream resets and stalled writes). When a connection
+// is unhealthy, the Monitor moves new streams to a healthier connection to the
+// same peer: if there is none, it dials an alternative address (or transport)
+// of the peer. The unhealthy connection is marked as draining, so it's only
+// used until the remaining streams are closed, but it's not closed by the
+// Monitor. If it becomes healthy again, it's no longer draining.
+//
+// Connections are checked one at a time, so that checking a large number of
+// connections doesn't start a burst of pings.
+package connquality
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+
+	ma "github.com/multiformats/go-multiaddr"
+	msmux "github.com/multiformats/go-multistream"
+)
+
+var log = logging.Logger("connquality")
+
+const (
+	defaultInterval        = 30 * time.Second
+	defaultPingTimeout     = 10 * time.Second
+	defaultRTTSpikeFactor  = 4
+	defaultMaxStreamResets = 10
+	defaultMaxWriteStalls  = 3
+
+	// minRTTSamples is the number of RTT samples needed before detecting RTT spikes.
+	minRTTSamples = 3
+	// minRTTSpike is the minimum increase of the RTT over the average to be considered a spike.
+	// It prevents jitter on low-latency connections from being treated as spikes.
+	minRTTSpike = 50 * time.Millisecond
+	// rttAlpha is the weight of a new sample in the RTT moving average.
+	rttAlpha = 0.1
+
+	dialTimeout = time.Minute
+)
+
+type Option func(*Monitor) error
+
+// WithInterval sets the interval at which connections are checked.
+func WithInterval(d time.Duration) Option {
+	return func(m *Monitor) error {
+		if d <= 0 {
+			return errors.New("interval must be positive")
+		}
+		m.interval = d
+		return nil
+	}
+}
+
+// WithPingTimeout sets the timeout for a single ping. A connection that
+// doesn't respond to a ping within the timeout is considered unhealthy.
+func WithPingTimeout(d time.Duration) Option {
+	return func(m *Monitor) error {
+		if d <= 0 {
+			return errors.New("ping timeout must be positive")
+		}
+		m.pingTimeout = d
+		return nil
+	}
+}
+
+// WithRTTSpikeFactor sets the factor by which an RTT sample has to exceed
+// the average RTT of the connection for the connection to be considered unhealthy.
+func WithRTTSpikeFactor(f float64) Option {
+	return func(m *Monitor) error {
+		if f <= 1 {
+			return errors.New("RTT spike factor must be larger than 1")
+		}
+		m.rttSpikeFactor = f
+		return nil
+	}
+}
+
+// WithMaxStreamResets sets the number of streams that can be reset by the
+// remote peer between two checks before the connection is considered unhealthy.
+func WithMaxStreamResets(n uint64) Option {
+	return func(m *Monitor) error {
+		m.maxStreamResets = n
+		return nil
+	}
+}
+
+// WithMaxWriteStalls sets the number of stalled writes between two checks
+// before the connection is considered unhealthy.
+func WithMaxWriteStalls(n uint64) Option {
+	return func(m *Monitor) error {
+		m.maxWriteStalls = n
+		return nil
+	}
+}
+
+// Monitor monitors the health of the host's connections.
+type Monitor struct {
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+
+	host host.Host
+
+	interval        time.Duration
+	pingTimeout     time.Duration
+	rttSpikeFactor  float64
+	maxStreamResets uint64
+	maxWriteStalls  uint64
+
+	mx    sync.Mutex
+	conns map[network.Conn]*connState
+
+	refCount sync.WaitGroup
+}
+
+type connState struct {
+	rtt        time.Duration // moving average
+	rttSamples int
+	health     swarm.ConnHealth // health counters at the last check
+	// drained is set if the Monitor marked the connection as draining
+	drained bool
+}
+
+type healthReporter interface {
+	Health() swarm.ConnHealth
+}
+
+type drainer interface {
+	StartDraining()
+	StopDraining()
+	IsDraining() bool
+}
+
+// New creates a new Monitor, and starts monitoring the host's connections.
+// RTT spikes are only detected for peers that support the ping protocol.
+func New(h host.Host, opts ...Option) (*Monitor, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Monitor{
+		ctx:             ctx,
+		ctxCancel:       cancel,
+		host:            h,
+		interval:        defaultInterval,
+		pingTimeout:     defaultPingTimeout,
+		rttSpikeFactor:  defaultRTTSpikeFactor,
+		maxStreamResets: defaultMaxStreamResets,
+		maxWriteStalls:  defaultMaxWriteStalls,
+		conns:           make(map[network.Conn]*connState),
+	}
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	m.refCount.Add(1)
+	go m.background()
+	return m, nil
+}
+
+// Close stops the Monitor.
+func (m *Monitor) Close() error {
+	m.ctxCancel()
+	m.refCount.Wait()
+	return nil
+}
+
+func (m *Monitor) background() {
+	defer m.refCount.Done()
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.checkConns()
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *Monitor) checkConns() {
+	conns := m.host.Network().Conns()
+	live := make(map[network.Conn]struct{}, len(conns))
+	for _, c := range conns {
+		if m.ctx.Err() != nil {
+			return
+		}
+		live[c] = struct{}{}
+		// Don't use up the data limit of limited relay connections.
+		if c.Stat().Transient {
+			continue
+		}
+		// Connections drained by someone else, e.g. after a migration, are
+		// left alone.
+		draining := false
+		if d, ok := c.(drainer); ok && d.IsDraining() {
+			if !m.drainedByMonitor(c) {
+				continue
+			}
+			draining = true
+		}
+		reason := m.checkConn(c)
+		switch {
+		case reason == "" && draining:
+			m.handleRecovered(c)
+		case reason != "" && !draining:
+			m.handleUnhealthy(c, reason)
+		}
+	}
+
+	m.mx.Lock()
+	for c := range m.conns {
+		if _, ok := live[c]; !ok {
+			delete(m.conns, c)
+		}
+	}
+	m.mx.Unlock()
+}
+
+func (m *Monitor) drainedByMonitor(c network.Conn) bool {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	st, ok := m.conns[c]
+	return ok && st.drained
+}
+
+// startDraining marks c as draining, remembering that the Monitor did so.
+func (m *Monitor) startDraining(c network.Conn, d drainer) {
+	m.mx.Lock()
+	if st, ok := m.conns[c]; ok {
+		st.drained = true
+	}
+	m.mx.Unlock()
+	d.StartDraining()
+}
+
+// handleRecovered lets new streams use c again, after it was drained by the
+// Monitor and became healthy again.
+func (m *Monitor) handleRecovered(c network.Conn) {
+	m.mx.Lock()
+	if st, ok := m.conns[c]; ok {
+		st.drained = false
+	}
+	m.mx.Unlock()
+	log.Debugw("connection recovered", "peer", c.RemotePeer(), "addr", c.RemoteMultiaddr())
+	c.(drainer).StopDraining()
+}
+
+// checkConn checks the health of c. It returns the reason why the connection
+// is unhealthy, or an empty string if the connection is healthy.
+func (m *Monitor) checkConn(c network.Conn) string {
+	ctx, cancel := context.WithTimeout(m.ctx, m.pingTimeout)
+	defer cancel()
+	rtt, pingErr := ping.PingConn(ctx, c)
+	if m.ctx.Err() != nil {
+		return ""
+	}
+
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	st, ok := m.conns[c]
+	if !ok {
+		st = &connState{}
+		m.conns[c] = st
+	}
+
+	var health swarm.ConnHealth
+	if hr, ok := c.(healthReporter); ok {
+		health = hr.Health()
+	}
+	resets := health.StreamResets - st.health.StreamResets
+	stalls := health.WriteStalls - st.health.WriteStalls
+	st.health = health
+
+	var notSupported msmux.ErrNotSupported[protocol.ID]
+	if errors.As(pingErr, &notSupported) {
+		// We can't measure the RTT, but that doesn't make the connection unhealthy.
+		pingErr = nil
+		rtt = 0
+	}
+	if pingErr != nil {
+		log.Debugw("ping failed", "peer", c.RemotePeer(), "addr", c.RemoteMultiaddr(), "error", pingErr)
+		return "ping failed"
+	}
+	if rtt == 0 {
+		return m.checkCounters(resets, stalls)
+	}
+	spike := st.rttSamples >= minRTTSamples &&
+		float64(rtt) > m.rttSpikeFactor*float64(st.rtt) &&
+		rtt-st.rtt > minRTTSpike
+	if st.rttSamples == 0 {
+		st.rtt = rtt
+	} else {
+		st.rtt = time.Duration(rttAlpha*float64(rtt) + (1-rttAlpha)*float64(st.rtt))
+	}
+	st.rttSamples++
+
+	if spike {
+		return "RTT spike"
+	}
+	return m.checkCounters(resets, stalls)
+}
+
+func (m *Monitor) checkCounters(resets, stalls uint64) string {
+	switch {
+	case m.maxStreamResets > 0 && resets >= m.maxStreamResets:
+		return "stream resets"
+	case m.maxWriteStalls > 0 && stalls >= m.maxWriteStalls:
+		return "write stalls"
+	}
+	return ""
+}
+
+// handleUnhealthy moves new streams to c's peer to a different connection.
+func (m *Monitor) handleUnhealthy(c network.Conn, reason string) {
+	d, ok := c.(drainer)
+	if !ok {
+		return
+	}
+	p := c.RemotePeer()
+	log.Debugw("unhealthy connection", "peer", p, "addr", c.RemoteMultiaddr(), "reason", reason)
+
+	for _, other := range m.host.Network().ConnsToPeer(p) {
+		if other == c || other.IsClosed() {
+			continue
+		}
+		if od, ok := other.(drainer); ok && od.IsDraining() {
+			continue
+		}
+		m.startDraining(c, d)
+		return
+	}
+
+	// There's no other connection. Dial an alternative address.
+	ctx, cancel := context.WithTimeout(m.ctx, dialTimeout)
+	defer cancel()
+	addr := c.RemoteMultiaddr()
+	ctx = network.WithDialAddrFilter(ctx, func(a ma.Multiaddr) bool { return !a.Equal(addr) }, "connquality")
+	nc, err := m.host.Network().DialPeer(ctx, p)
+	if err != nil {
+		log.Debugw("failed to dial alternative connection", "peer", p, "error", err)
+		return
+	}
+	if nc == c {
+		return
+	}
+	log.Debugw("moving to alternative connection", "peer", p, "from", addr, "to", nc.RemoteMultiaddr())
+	m.startDraining(c, d)
+}
@@ -0,0 +1,138 @@
+package connquality
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func newHost(t *testing.T, opts ...libp2p.Option) host.Host {
+	t.Helper()
+	opts = append(opts, libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0", "/ip4/127.0.0.1/udp/0/quic-v1"))
+	h, err := libp2p.New(opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func isTCP(a ma.Multiaddr) bool {
+	_, err := a.ValueForProtocol(ma.P_TCP)
+	return err == nil
+}
+
+// connectTCP connects h1 to h2 over TCP, and returns the connection.
+func connectTCP(t *testing.T, h1, h2 host.Host) network.Conn {
+	t.Helper()
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{
+		ID:    h2.ID(),
+		Addrs: ma.FilterAddrs(h2.Addrs(), isTCP),
+	}))
+	conns := h1.Network().ConnsToPeer(h2.ID())
+	require.Len(t, conns, 1)
+	return conns[0]
+}
+
+func isDraining(c network.Conn) bool {
+	return c.(drainer).IsDraining()
+}
+
+func TestStreamResets(t *testing.T) {
+	h1 := newHost(t)
+	h2 := newHost(t)
+	h2.SetStreamHandler("/reset", func(s network.Stream) { s.Reset() })
+
+	c := connectTCP(t, h1, h2)
+	m, err := New(h1, WithInterval(100*time.Millisecond), WithMaxStreamResets(2))
+	require.NoError(t, err)
+	defer m.Close()
+
+	for i := 0; i < 2; i++ {
+		s, err := h1.NewStream(context.Background(), h2.ID(), "/reset")
+		require.NoError(t, err)
+		_, err = s.Read(make([]byte, 1))
+		require.ErrorIs(t, err, network.ErrReset)
+		s.Reset()
+	}
+
+	require.Eventually(t, func() bool { return isDraining(c) }, 5*time.Second, 50*time.Millisecond)
+	conns := h1.Network().ConnsToPeer(h2.ID())
+	require.Len(t, conns, 2)
+
+	// New streams use the new connection.
+	s, err := h1.NewStream(context.Background(), h2.ID(), "/reset")
+	require.NoError(t, err)
+	require.NotEqual(t, c, s.Conn())
+	s.Reset()
+}
+
+func TestHealthyConnection(t *testing.T) {
+	h1 := newHost(t)
+	h2 := newHost(t)
+
+	c := connectTCP(t, h1, h2)
+	m, err := New(h1, WithInterval(50*time.Millisecond))
+	require.NoError(t, err)
+	defer m.Close()
+
+	require.Never(t, func() bool { return isDraining(c) }, 500*time.Millisecond, 50*time.Millisecond)
+	require.Len(t, h1.Network().ConnsToPeer(h2.ID()), 1)
+}
+
+func TestPingTimeout(t *testing.T) {
+	h1 := newHost(t)
+	h2 := newHost(t)
+	// never respond to pings
+	h2.SetStreamHandler(ping.ID, func(s network.Stream) {})
+
+	c := connectTCP(t, h1, h2)
+	m, err := New(h1, WithInterval(100*time.Millisecond), WithPingTimeout(100*time.Millisecond))
+	require.NoError(t, err)
+	defer m.Close()
+
+	require.Eventually(t, func() bool { return isDraining(c) }, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestRecovery(t *testing.T) {
+	newTCPHost := func(addrs ...string) host.Host {
+		h, err := libp2p.New(libp2p.Transport(tcp.NewTCPTransport), libp2p.ListenAddrStrings(addrs...))
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+		return h
+	}
+	h1 := newTCPHost("/ip4/127.0.0.1/tcp/0")
+	// two addresses, so that there's an alternative to dial
+	h2 := newTCPHost("/ip4/127.0.0.1/tcp/0", "/ip4/127.0.0.1/tcp/0")
+	h2.SetStreamHandler(ping.ID, func(s network.Stream) { s.Reset() })
+
+	c := connectTCP(t, h1, h2)
+	m, err := New(h1, WithInterval(100*time.Millisecond), WithPingTimeout(100*time.Millisecond))
+	require.NoError(t, err)
+	defer m.Close()
+	require.Eventually(t, func() bool { return isDraining(c) }, 5*time.Second, 50*time.Millisecond)
+
+	// respond to pings again
+	ping.NewPingService(h2)
+	require.Eventually(t, func() bool { return !isDraining(c) }, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestPingNotSupported(t *testing.T) {
+	h1 := newHost(t)
+	h2 := newHost(t, libp2p.Ping(false))
+
+	c := connectTCP(t, h1, h2)
+	m, err := New(h1, WithInterval(50*time.Millisecond))
+	require.NoError(t, err)
+	defer m.Close()
+
+	require.Never(t, func() bool { return isDraining(c) }, 500*time.Millisecond, 50*time.Millisecond)
+}
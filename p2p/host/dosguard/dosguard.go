@@ -0,0 +1,544 @@
+// Package dosguard mitigates denial of service attacks automatically.
+//
+// The Engine counts signals, like failed inbound handshakes or streams blocked
+// by the resource manager, per peer, IP address or subnet. When more signals
+// than allowed by a Rule are observed, the rule's action is taken for the
+// rule's duration, e.g. blocking the subnet in the connection gater. Every
+// mitigation is recorded in an audit log.
+//
+// Signals are fed to the Engine by:
+//   - the upgrader, for failed inbound handshakes: pass the Engine to
+//     libp2p.HandshakeObserver.
+//   - the resource manager, for blocked connections, streams and memory
+//     reservations: pass the Engine to rcmgr.WithTraceReporter.
+//   - the application, for any other signal: Engine.Observe.
+//
+// The gater passed to WithGater must also be used by the host (see
+// libp2p.ConnectionGater). It shouldn't persist its rules, since mitigations
+// wouldn't be lifted if the process exits while they are in effect.
+package dosguard
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/libp2p/go-libp2p/core/peer"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+
+	logging "github.com/ipfs/go-log/v2"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+var log = logging.Logger("dosguard")
+
+const (
+	defaultAuditLogSize = 256
+	// gcInterval is the interval at which counters that didn't see any
+	// signals during their rule's window are removed.
+	gcInterval = time.Minute
+)
+
+// Signal is a kind of event counted by the Engine.
+type Signal string
+
+const (
+	// SignalHandshakeFailure is a failed inbound handshake. It has an
+	// address, but no peer.
+	SignalHandshakeFailure Signal = "handshake_failure"
+	// SignalResourceLimit is a connection, stream or memory reservation of a
+	// peer that was blocked by the resource manager. It has a peer, but no
+	// address.
+	SignalResourceLimit Signal = "resource_limit"
+)
+
+// Scope determines what signals are counted together.
+type Scope int
+
+const (
+	ScopePeer Scope = iota
+	ScopeIP
+	// ScopeSubnet counts signals per /24 IPv4 and /48 IPv6 subnet.
+	ScopeSubnet
+)
+
+func (s Scope) String() string {
+	switch s {
+	case ScopePeer:
+		return "peer"
+	case ScopeIP:
+		return "ip"
+	case ScopeSubnet:
+		return "subnet"
+	default:
+		return fmt.Sprintf("scope(%d)", int(s))
+	}
+}
+
+// Action is the mitigation taken when a rule triggers.
+type Action int
+
+const (
+	// ActionGate blocks the peer, IP address or subnet in the gater.
+	ActionGate Action = iota
+	// ActionLog only records the mitigation in the audit log. This is useful
+	// to tune rules before enforcing them.
+	ActionLog
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionGate:
+		return "gate"
+	case ActionLog:
+		return "log"
+	default:
+		return fmt.Sprintf("action(%d)", int(a))
+	}
+}
+
+// Rule triggers a mitigation when more than Threshold signals are observed in
+// a scope within Window. For example, gating a /24 for 10 minutes after more
+// than 100 failed handshakes per minute:
+//
+//	Rule{
+//		Name:      "handshake-flood",
+//		Signal:    SignalHandshakeFailure,
+//		Scope:     ScopeSubnet,
+//		Threshold: 100,
+//		Window:    time.Minute,
+//		Action:    ActionGate,
+//		Duration:  10 * time.Minute,
+//	}
+type Rule struct {
+	// Name identifies the rule in the audit log. It must be unique.
+	Name      string
+	Signal    Signal
+	Scope     Scope
+	Threshold int
+	Window    time.Duration
+	Action    Action
+	// Duration is the time the mitigation is in effect.
+	Duration time.Duration
+}
+
+func (r *Rule) validate() error {
+	if r.Name == "" {
+		return errors.New("rule needs a name")
+	}
+	if r.Threshold < 0 {
+		return fmt.Errorf("rule %s: threshold must not be negative", r.Name)
+	}
+	if r.Window <= 0 || r.Duration <= 0 {
+		return fmt.Errorf("rule %s: window and duration must be positive", r.Name)
+	}
+	if r.Scope < ScopePeer || r.Scope > ScopeSubnet {
+		return fmt.Errorf("rule %s: invalid scope %s", r.Name, r.Scope)
+	}
+	if r.Action < ActionGate || r.Action > ActionLog {
+		return fmt.Errorf("rule %s: invalid action %s", r.Name, r.Action)
+	}
+	return nil
+}
+
+// AuditEntry records a mitigation being applied or lifted.
+type AuditEntry struct {
+	Time   time.Time
+	Rule   string
+	Action Action
+	// Target is the peer ID, IP address or subnet the mitigation applies to.
+	Target string
+	// Count is the number of signals that triggered the mitigation. It is 0
+	// when the mitigation is lifted.
+	Count  int
+	Lifted bool
+}
+
+// Gater is the connection gater used by ActionGate. It is implemented by
+// conngater.BasicConnectionGater.
+type Gater interface {
+	BlockPeer(peer.ID) error
+	UnblockPeer(peer.ID) error
+	BlockAddr(net.IP) error
+	UnblockAddr(net.IP) error
+	BlockSubnet(*net.IPNet) error
+	UnblockSubnet(*net.IPNet) error
+}
+
+type Option func(*Engine) error
+
+// WithGater sets the gater used by ActionGate. It is required if any of the
+// rules gates.
+func WithGater(g Gater) Option {
+	return func(e *Engine) error {
+		e.gater = g
+		return nil
+	}
+}
+
+// WithAllowlist exempts the peers and addresses on the resource manager's
+// allowlist from all rules, see rcmgr.GetAllowlist.
+func WithAllowlist(al *rcmgr.Allowlist) Option {
+	return func(e *Engine) error {
+		e.allowlist = al
+		return nil
+	}
+}
+
+// WithAuditLogSize sets the number of entries kept in the audit log.
+func WithAuditLogSize(n int) Option {
+	return func(e *Engine) error {
+		if n <= 0 {
+			return errors.New("audit log size must be positive")
+		}
+		e.auditLogSize = n
+		return nil
+	}
+}
+
+func WithClock(cl clock.Clock) Option {
+	return func(e *Engine) error {
+		e.clock = cl
+		return nil
+	}
+}
+
+// target is the peer, IP address or subnet a rule applies to.
+type target struct {
+	scope Scope
+	peer  peer.ID
+	ipnet *net.IPNet
+}
+
+func (t target) String() string {
+	switch t.scope {
+	case ScopePeer:
+		return t.peer.String()
+	case ScopeIP:
+		return t.ipnet.IP.String()
+	default:
+		return t.ipnet.String()
+	}
+}
+
+func targetFor(s Scope, p peer.ID, ip net.IP) (target, bool) {
+	switch s {
+	case ScopePeer:
+		return target{scope: s, peer: p}, p != ""
+	case ScopeIP:
+		if ip == nil {
+			return target{}, false
+		}
+		return target{scope: s, ipnet: &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}}, true
+	case ScopeSubnet:
+		if ip == nil {
+			return target{}, false
+		}
+		bits := 48
+		if len(ip) == net.IPv4len {
+			bits = 24
+		}
+		mask := net.CIDRMask(bits, len(ip)*8)
+		return target{scope: s, ipnet: &net.IPNet{IP: ip.Mask(mask), Mask: mask}}, true
+	}
+	return target{}, false
+}
+
+type mitigationKey struct {
+	rule   int
+	target string
+}
+
+type mitigation struct {
+	target target
+	timer  *clock.Timer
+}
+
+// Engine applies the rules to the observed signals.
+type Engine struct {
+	rules        []Rule
+	gater        Gater
+	allowlist    *rcmgr.Allowlist
+	auditLogSize int
+	clock        clock.Clock
+
+	mu       sync.Mutex
+	closed   bool
+	counters []map[string][]time.Time // per rule and target
+	active   map[mitigationKey]*mitigation
+	// blocked counts the gating mitigations per target, since multiple rules
+	// may gate the same target.
+	blocked map[string]int
+	audit   []AuditEntry
+
+	closing chan struct{}
+	done    chan struct{}
+}
+
+var _ rcmgr.TraceReporter = &Engine{}
+
+// New creates an Engine applying rules. It must be closed when the host is
+// closed, which lifts all mitigations.
+func New(rules []Rule, opts ...Option) (*Engine, error) {
+	e := &Engine{
+		rules:        append([]Rule(nil), rules...),
+		auditLogSize: defaultAuditLogSize,
+		clock:        clock.New(),
+		active:       make(map[mitigationKey]*mitigation),
+		blocked:      make(map[string]int),
+		closing:      make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		if err := opt(e); err != nil {
+			return nil, err
+		}
+	}
+	names := make(map[string]struct{}, len(e.rules))
+	for i := range e.rules {
+		r := &e.rules[i]
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := names[r.Name]; ok {
+			return nil, fmt.Errorf("duplicate rule %s", r.Name)
+		}
+		names[r.Name] = struct{}{}
+		if r.Action == ActionGate && e.gater == nil {
+			return nil, fmt.Errorf("rule %s: gating requires a gater", r.Name)
+		}
+		e.counters = append(e.counters, make(map[string][]time.Time))
+	}
+	go e.background()
+	return e, nil
+}
+
+// InboundHandshakeFailed implements upgrader.HandshakeObserver.
+func (e *Engine) InboundHandshakeFailed(raddr ma.Multiaddr, _ error) {
+	e.Observe(SignalHandshakeFailure, "", raddr)
+}
+
+// ConsumeEvent implements rcmgr.TraceReporter.
+func (e *Engine) ConsumeEvent(evt rcmgr.TraceEvt) {
+	switch evt.Type {
+	case rcmgr.TraceBlockAddConnEvt, rcmgr.TraceBlockAddStreamEvt, rcmgr.TraceBlockReserveMemoryEvt:
+	default:
+		return
+	}
+	s := rcmgr.PeerStrInScopeName(evt.Name)
+	if s == "" {
+		return
+	}
+	p, err := peer.Decode(s)
+	if err != nil {
+		return
+	}
+	e.Observe(SignalResourceLimit, p, nil)
+}
+
+// Observe counts a signal. Either p or addr may be empty, in which case the
+// rules that count per peer or per address ignore the signal.
+func (e *Engine) Observe(sig Signal, p peer.ID, addr ma.Multiaddr) {
+	if e.allowed(p, addr) {
+		return
+	}
+	var ip net.IP
+	if addr != nil {
+		ip, _ = manet.ToIP(addr)
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+	}
+
+	now := e.clock.Now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	for i := range e.rules {
+		r := &e.rules[i]
+		if r.Signal != sig {
+			continue
+		}
+		t, ok := targetFor(r.Scope, p, ip)
+		if !ok {
+			continue
+		}
+		key := t.String()
+		if _, ok := e.active[mitigationKey{rule: i, target: key}]; ok {
+			continue
+		}
+		times := append(expire(e.counters[i][key], now.Add(-r.Window)), now)
+		if len(times) <= r.Threshold {
+			e.counters[i][key] = times
+			continue
+		}
+		delete(e.counters[i], key)
+		e.mitigate(i, t, len(times), now)
+	}
+}
+
+// allowed returns true if the peer p is allowlisted, or if the address addr is
+// allowlisted for p.
+func (e *Engine) allowed(p peer.ID, addr ma.Multiaddr) bool {
+	if e.allowlist == nil {
+		return false
+	}
+	if p != "" && e.allowlist.AllowedPeer(p) {
+		return true
+	}
+	if addr == nil {
+		return false
+	}
+	if p != "" {
+		return e.allowlist.AllowedPeerAndMultiaddr(p, addr)
+	}
+	return e.allowlist.Allowed(addr)
+}
+
+// expire removes the times before cutoff.
+func expire(times []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(times) && times[i].Before(cutoff) {
+		i++
+	}
+	return times[i:]
+}
+
+// mitigate applies the action of rule ri to t. It must be called with the
+// lock held.
+func (e *Engine) mitigate(ri int, t target, count int, now time.Time) {
+	r := &e.rules[ri]
+	key := t.String()
+	if r.Action == ActionGate {
+		if e.blocked[key] == 0 {
+			if err := block(e.gater, t); err != nil {
+				log.Warnw("failed to apply mitigation", "rule", r.Name, "target", key, "error", err)
+				return
+			}
+		}
+		e.blocked[key]++
+	}
+	log.Infow("applying mitigation", "rule", r.Name, "action", r.Action, "target", key, "signals", count, "duration", r.Duration)
+
+	mk := mitigationKey{rule: ri, target: key}
+	m := &mitigation{target: t}
+	m.timer = e.clock.AfterFunc(r.Duration, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		// The mitigation might have been lifted by Close already.
+		if e.active[mk] == m {
+			e.lift(mk, m)
+		}
+	})
+	e.active[mk] = m
+	e.record(AuditEntry{Time: now, Rule: r.Name, Action: r.Action, Target: key, Count: count})
+}
+
+// lift lifts a mitigation. It must be called with the lock held.
+func (e *Engine) lift(mk mitigationKey, m *mitigation) {
+	delete(e.active, mk)
+	r := &e.rules[mk.rule]
+	if r.Action == ActionGate {
+		e.blocked[mk.target]--
+		if e.blocked[mk.target] == 0 {
+			delete(e.blocked, mk.target)
+			if err := unblock(e.gater, m.target); err != nil {
+				log.Warnw("failed to lift mitigation", "rule", r.Name, "target", mk.target, "error", err)
+			}
+		}
+	}
+	log.Infow("lifting mitigation", "rule", r.Name, "target", mk.target)
+	e.record(AuditEntry{Time: e.clock.Now(), Rule: r.Name, Action: r.Action, Target: mk.target, Lifted: true})
+}
+
+func block(g Gater, t target) error {
+	switch t.scope {
+	case ScopePeer:
+		return g.BlockPeer(t.peer)
+	case ScopeIP:
+		return g.BlockAddr(t.ipnet.IP)
+	default:
+		return g.BlockSubnet(t.ipnet)
+	}
+}
+
+func unblock(g Gater, t target) error {
+	switch t.scope {
+	case ScopePeer:
+		return g.UnblockPeer(t.peer)
+	case ScopeIP:
+		return g.UnblockAddr(t.ipnet.IP)
+	default:
+		return g.UnblockSubnet(t.ipnet)
+	}
+}
+
+func (e *Engine) record(entry AuditEntry) {
+	if len(e.audit) >= e.auditLogSize {
+		e.audit = append(e.audit[:0], e.audit[1:]...)
+	}
+	e.audit = append(e.audit, entry)
+}
+
+// AuditLog returns the most recent mitigations applied and lifted, oldest
+// first.
+func (e *Engine) AuditLog() []AuditEntry {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]AuditEntry(nil), e.audit...)
+}
+
+func (e *Engine) background() {
+	defer close(e.done)
+	t := e.clock.Ticker(gcInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			e.gc()
+		case <-e.closing:
+			return
+		}
+	}
+}
+
+func (e *Engine) gc() {
+	now := e.clock.Now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, counters := range e.counters {
+		cutoff := now.Add(-e.rules[i].Window)
+		for key, times := range counters {
+			if times = expire(times, cutoff); len(times) == 0 {
+				delete(counters, key)
+			} else {
+				counters[key] = times
+			}
+		}
+	}
+}
+
+// Close stops the Engine, and lifts all mitigations.
+func (e *Engine) Close() error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	for mk, m := range e.active {
+		m.timer.Stop()
+		e.lift(mk, m)
+	}
+	e.mu.Unlock()
+
+	close(e.closing)
+	<-e.done
+	return nil
+}
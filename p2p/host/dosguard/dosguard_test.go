@@ -0,0 +1,153 @@
+package dosguard
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"github.com/libp2p/go-libp2p/p2p/net/conngater"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+var handshakeFlood = Rule{
+	Name:      "handshake-flood",
+	Signal:    SignalHandshakeFailure,
+	Scope:     ScopeSubnet,
+	Threshold: 3,
+	Window:    time.Minute,
+	Action:    ActionGate,
+	Duration:  10 * time.Minute,
+}
+
+func TestGateSubnet(t *testing.T) {
+	cl := clock.NewMock()
+	g, err := conngater.NewBasicConnectionGater(nil)
+	require.NoError(t, err)
+	e, err := New([]Rule{handshakeFlood}, WithGater(g), WithClock(cl))
+	require.NoError(t, err)
+	defer e.Close()
+
+	addr := func(ip string) ma.Multiaddr { return ma.StringCast("/ip4/" + ip + "/tcp/1234") }
+
+	// failures spread over more than the window don't trigger the rule
+	for i := 0; i < 3; i++ {
+		e.InboundHandshakeFailed(addr("1.2.3.4"), errors.New("failed"))
+		cl.Add(40 * time.Second)
+	}
+	require.Empty(t, g.ListBlockedSubnets())
+
+	e.InboundHandshakeFailed(addr("1.2.3.4"), errors.New("failed"))
+	e.InboundHandshakeFailed(addr("1.2.3.5"), errors.New("failed"))
+	e.InboundHandshakeFailed(addr("1.2.4.4"), errors.New("failed"))
+	require.Empty(t, g.ListBlockedSubnets())
+	e.InboundHandshakeFailed(addr("1.2.3.6"), errors.New("failed"))
+	require.Len(t, g.ListBlockedSubnets(), 1)
+	require.Equal(t, "1.2.3.0/24", g.ListBlockedSubnets()[0].String())
+
+	cl.Add(10 * time.Minute)
+	require.Empty(t, g.ListBlockedSubnets())
+
+	log := e.AuditLog()
+	require.Len(t, log, 2)
+	require.Equal(t, AuditEntry{Time: log[0].Time, Rule: "handshake-flood", Action: ActionGate, Target: "1.2.3.0/24", Count: 4}, log[0])
+	require.True(t, log[1].Lifted)
+	require.Equal(t, 10*time.Minute, log[1].Time.Sub(log[0].Time))
+}
+
+func TestResourceLimitSignal(t *testing.T) {
+	cl := clock.NewMock()
+	g, err := conngater.NewBasicConnectionGater(nil)
+	require.NoError(t, err)
+	rule := Rule{
+		Name:      "stream-flood",
+		Signal:    SignalResourceLimit,
+		Scope:     ScopePeer,
+		Threshold: 1,
+		Window:    time.Minute,
+		Action:    ActionGate,
+		Duration:  time.Minute,
+	}
+	logOnly := rule
+	logOnly.Name = "stream-flood-dry-run"
+	logOnly.Action = ActionLog
+	e, err := New([]Rule{rule, logOnly}, WithGater(g), WithClock(cl))
+	require.NoError(t, err)
+
+	p := test.RandPeerIDFatal(t)
+	for i := 0; i < 2; i++ {
+		e.ConsumeEvent(rcmgr.TraceEvt{Type: rcmgr.TraceBlockAddStreamEvt, Name: "peer:" + p.String()})
+		// other events are ignored
+		e.ConsumeEvent(rcmgr.TraceEvt{Type: rcmgr.TraceAddStreamEvt, Name: "peer:" + p.String()})
+	}
+	require.Equal(t, []peer.ID{p}, g.ListBlockedPeers())
+	require.Len(t, e.AuditLog(), 2)
+
+	// closing lifts all mitigations
+	require.NoError(t, e.Close())
+	require.Empty(t, g.ListBlockedPeers())
+	require.Len(t, e.AuditLog(), 4)
+}
+
+func TestAllowlist(t *testing.T) {
+	g, err := conngater.NewBasicConnectionGater(nil)
+	require.NoError(t, err)
+	var al rcmgr.Allowlist
+	require.NoError(t, al.Add(ma.StringCast("/ip4/1.2.3.0/ipcidr/24")))
+	rule := handshakeFlood
+	rule.Scope = ScopeIP
+	rule.Threshold = 0
+	e, err := New([]Rule{rule}, WithGater(g), WithAllowlist(&al))
+	require.NoError(t, err)
+	defer e.Close()
+
+	e.InboundHandshakeFailed(ma.StringCast("/ip4/1.2.3.4/udp/1/quic-v1"), errors.New("failed"))
+	require.Empty(t, g.ListBlockedAddrs())
+	e.InboundHandshakeFailed(ma.StringCast("/ip4/1.2.4.4/udp/1/quic-v1"), errors.New("failed"))
+	require.Len(t, g.ListBlockedAddrs(), 1)
+	require.True(t, g.ListBlockedAddrs()[0].Equal(net.ParseIP("1.2.4.4")))
+}
+
+func TestAllowlistPeer(t *testing.T) {
+	g, err := conngater.NewBasicConnectionGater(nil)
+	require.NoError(t, err)
+	allowed := test.RandPeerIDFatal(t)
+	var al rcmgr.Allowlist
+	require.NoError(t, al.Add(ma.StringCast("/ip4/1.2.3.4/p2p/"+allowed.String())))
+	rule := Rule{
+		Name:      "stream-flood",
+		Signal:    SignalResourceLimit,
+		Scope:     ScopePeer,
+		Threshold: 0,
+		Window:    time.Minute,
+		Action:    ActionGate,
+		Duration:  time.Minute,
+	}
+	e, err := New([]Rule{rule}, WithGater(g), WithAllowlist(&al))
+	require.NoError(t, err)
+	defer e.Close()
+
+	// resource limit signals don't carry an address
+	e.ConsumeEvent(rcmgr.TraceEvt{Type: rcmgr.TraceBlockAddStreamEvt, Name: "peer:" + allowed.String()})
+	require.Empty(t, g.ListBlockedPeers())
+	p := test.RandPeerIDFatal(t)
+	e.ConsumeEvent(rcmgr.TraceEvt{Type: rcmgr.TraceBlockAddStreamEvt, Name: "peer:" + p.String()})
+	require.Equal(t, []peer.ID{p}, g.ListBlockedPeers())
+}
+
+func TestInvalidRules(t *testing.T) {
+	_, err := New([]Rule{handshakeFlood})
+	require.ErrorContains(t, err, "requires a gater")
+	_, err = New([]Rule{handshakeFlood, handshakeFlood}, WithGater(&conngater.BasicConnectionGater{}))
+	require.ErrorContains(t, err, "duplicate rule")
+	r := handshakeFlood
+	r.Window = 0
+	_, err = New([]Rule{r}, WithGater(&conngater.BasicConnectionGater{}))
+	require.Error(t, err)
+}
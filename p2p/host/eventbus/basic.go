@@ -38,7 +38,7 @@ func (e *emitter) Emit(evt interface{}) error {
 	}
 
 	e.n.emit(evt)
-	e.w.emit(evt)
+	e.w.emit(evt, e.typ)
 
 	if e.metricsTracer != nil {
 		e.metricsTracer.EventEmitted(e.typ)
@@ -159,7 +159,10 @@ func (s *sub) Close() error {
 	go func() {
 		// drain the event channel, will return when closed and drained.
 		// this is necessary to unblock publishes to this channel.
-		for range s.ch {
+		for evt := range s.ch {
+			if t, ok := s.metricsTracer.(EventTypeMetricsTracer); ok {
+				t.SubscriberEventDropped(s.name, reflect.TypeOf(evt))
+			}
 		}
 	}()
 
@@ -348,7 +351,7 @@ func (n *wildcardNode) removeSink(ch chan interface{}) {
 	n.Unlock()
 }
 
-func (n *wildcardNode) emit(evt interface{}) {
+func (n *wildcardNode) emit(evt interface{}, typ reflect.Type) {
 	if n.nSinks.Load() == 0 {
 		return
 	}
@@ -358,7 +361,7 @@ func (n *wildcardNode) emit(evt interface{}) {
 
 		// Sending metrics before sending on channel allows us to
 		// record channel full events before blocking
-		sendSubscriberMetrics(n.metricsTracer, sink)
+		sendSubscriberMetrics(n.metricsTracer, sink, typ)
 
 		sink.ch <- evt
 	}
@@ -403,16 +406,20 @@ func (n *node) emit(evt interface{}) {
 
 		// Sending metrics before sending on channel allows us to
 		// record channel full events before blocking
-		sendSubscriberMetrics(n.metricsTracer, sink)
+		sendSubscriberMetrics(n.metricsTracer, sink, n.typ)
 		sink.ch <- evt
 	}
 	n.lk.Unlock()
 }
 
-func sendSubscriberMetrics(metricsTracer MetricsTracer, sink *namedSink) {
+func sendSubscriberMetrics(metricsTracer MetricsTracer, sink *namedSink, typ reflect.Type) {
 	if metricsTracer != nil {
 		metricsTracer.SubscriberQueueLength(sink.name, len(sink.ch)+1)
 		metricsTracer.SubscriberQueueFull(sink.name, len(sink.ch)+1 >= cap(sink.ch))
-		metricsTracer.SubscriberEventQueued(sink.name)
+		if t, ok := metricsTracer.(EventTypeMetricsTracer); ok {
+			t.SubscriberEventQueuedWithType(sink.name, typ)
+		} else {
+			metricsTracer.SubscriberEventQueued(sink.name)
+		}
 	}
 }
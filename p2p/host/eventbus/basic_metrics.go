@@ -50,7 +50,15 @@ var (
 			Name:      "subscriber_event_queued",
 			Help:      "Event Queued for subscriber",
 		},
-		[]string{"subscriber_name"},
+		[]string{"event", "subscriber_name"},
+	)
+	subscriberEventDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "subscriber_event_dropped_total",
+			Help:      "Events queued for a subscriber that were dropped when it was closed",
+		},
+		[]string{"event", "subscriber_name"},
 	)
 	collectors = []prometheus.Collector{
 		eventsEmitted,
@@ -58,6 +66,7 @@ var (
 		subscriberQueueLength,
 		subscriberQueueFull,
 		subscriberEventQueued,
+		subscriberEventDropped,
 	}
 )
 
@@ -83,9 +92,25 @@ type MetricsTracer interface {
 	SubscriberEventQueued(name string)
 }
 
+// EventTypeMetricsTracer is an optional interface implemented by
+// MetricsTracers that group the events queued for subscribers by event type.
+// If implemented, the bus calls SubscriberEventQueuedWithType instead of
+// SubscriberEventQueued.
+type EventTypeMetricsTracer interface {
+	// SubscriberEventQueuedWithType counts the total number of events grouped by event type and subscriber
+	SubscriberEventQueuedWithType(name string, typ reflect.Type)
+
+	// SubscriberEventDropped counts the events that were queued for a subscriber,
+	// but not read before it was closed, grouped by event type and subscriber
+	SubscriberEventDropped(name string, typ reflect.Type)
+}
+
 type metricsTracer struct{}
 
-var _ MetricsTracer = &metricsTracer{}
+var (
+	_ MetricsTracer          = &metricsTracer{}
+	_ EventTypeMetricsTracer = &metricsTracer{}
+)
 
 type metricsTracerSetting struct {
 	reg prometheus.Registerer
@@ -156,9 +181,28 @@ func (m *metricsTracer) SubscriberQueueFull(name string, isFull bool) {
 }
 
 func (m *metricsTracer) SubscriberEventQueued(name string) {
+	m.SubscriberEventQueuedWithType(name, nil)
+}
+
+func (m *metricsTracer) SubscriberEventQueuedWithType(name string, typ reflect.Type) {
 	tags := metricshelper.GetStringSlice()
 	defer metricshelper.PutStringSlice(tags)
 
-	*tags = append(*tags, name)
+	*tags = append(*tags, eventTypeLabel(typ), name)
 	subscriberEventQueued.WithLabelValues(*tags...).Inc()
 }
+
+func (m *metricsTracer) SubscriberEventDropped(name string, typ reflect.Type) {
+	tags := metricshelper.GetStringSlice()
+	defer metricshelper.PutStringSlice(tags)
+
+	*tags = append(*tags, eventTypeLabel(typ), name)
+	subscriberEventDropped.WithLabelValues(*tags...).Inc()
+}
+
+func eventTypeLabel(typ reflect.Type) string {
+	if typ == nil {
+		return ""
+	}
+	return strings.TrimPrefix(typ.String(), "event.")
+}
@@ -49,6 +49,7 @@ var names = []string{
 
 func TestMetricsNoAllocNoCover(t *testing.T) {
 	mt := NewMetricsTracer()
+	et := mt.(EventTypeMetricsTracer)
 	tests := map[string]func(){
 		"EventEmitted":          func() { mt.EventEmitted(eventTypes[rand.Intn(len(eventTypes))]) },
 		"AddSubscriber":         func() { mt.AddSubscriber(eventTypes[rand.Intn(len(eventTypes))]) },
@@ -56,6 +57,12 @@ func TestMetricsNoAllocNoCover(t *testing.T) {
 		"SubscriberQueueLength": func() { mt.SubscriberQueueLength(names[rand.Intn(len(names))], rand.Intn(100)) },
 		"SubscriberQueueFull":   func() { mt.SubscriberQueueFull(names[rand.Intn(len(names))], rand.Intn(2) == 1) },
 		"SubscriberEventQueued": func() { mt.SubscriberEventQueued(names[rand.Intn(len(names))]) },
+		"SubscriberEventQueuedWithType": func() {
+			et.SubscriberEventQueuedWithType(names[rand.Intn(len(names))], eventTypes[rand.Intn(len(eventTypes))])
+		},
+		"SubscriberEventDropped": func() {
+			et.SubscriberEventDropped(names[rand.Intn(len(names))], eventTypes[rand.Intn(len(eventTypes))])
+		},
 	}
 	for method, f := range tests {
 		allocs := testing.AllocsPerRun(1000, f)
@@ -13,6 +13,7 @@ import (
 
 	"github.com/libp2p/go-libp2p-testing/race"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/require"
 )
 
@@ -666,3 +667,45 @@ func BenchmarkSubscribeAndEmitter(b *testing.B) {
 		}
 	}
 }
+
+func TestSubscriberEventMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	bus := NewBus(WithMetricsTracer(NewMetricsTracer(WithRegisterer(reg))))
+
+	counter := func(name string) float64 {
+		mfs, err := reg.Gather()
+		require.NoError(t, err)
+		for _, mf := range mfs {
+			if mf.GetName() != name {
+				continue
+			}
+			for _, m := range mf.GetMetric() {
+				labels := make(map[string]string)
+				for _, l := range m.GetLabel() {
+					labels[l.GetName()] = l.GetValue()
+				}
+				if labels["event"] == "eventbus.EventA" && labels["subscriber_name"] == "metrics-test" {
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+		return 0
+	}
+
+	sub, err := bus.Subscribe(new(EventA), Name("metrics-test"))
+	require.NoError(t, err)
+	em, err := bus.Emitter(new(EventA))
+	require.NoError(t, err)
+	defer em.Close()
+
+	require.NoError(t, em.Emit(EventA{}))
+	require.NoError(t, em.Emit(EventA{}))
+	<-sub.Out()
+	require.Equal(t, 2.0, counter("libp2p_eventbus_subscriber_event_queued"))
+
+	// the event that wasn't read is dropped when closing the subscription
+	require.NoError(t, sub.Close())
+	require.Eventually(t, func() bool {
+		return counter("libp2p_eventbus_subscriber_event_dropped_total") == 1
+	}, time.Second, 10*time.Millisecond)
+}
@@ -22,21 +22,26 @@ var subSettingsDefault = subSettings{
 // The default naming strategy is sub-<fileName>-L<lineNum>
 func newSubSettings() subSettings {
 	settings := subSettingsDefault
-	_, file, line, ok := runtime.Caller(2) // skip=1 is eventbus.Subscriber
-	if ok {
-		file = strings.TrimPrefix(file, "github.com/")
-		// remove the version number from the path, for example
-		// go-libp2p-package@v0.x.y-some-hash-123/file.go will be shortened go go-libp2p-package/file.go
-		if idx1 := strings.Index(file, "@"); idx1 != -1 {
-			if idx2 := strings.Index(file[idx1:], "/"); idx2 != -1 {
-				file = file[:idx1] + file[idx1+idx2:]
-			}
+	settings.name = subscriberName(3) // skip=2 is eventbus.Subscriber
+	return settings
+}
+
+// subscriberName returns the default name for a subscriber, derived from the
+// caller skip frames up the stack.
+func subscriberName(skip int) string {
+	_, file, line, ok := runtime.Caller(skip)
+	if !ok {
+		return fmt.Sprintf("subscriber-%d", subCnt.Add(1))
+	}
+	file = strings.TrimPrefix(file, "github.com/")
+	// remove the version number from the path, for example
+	// go-libp2p-package@v0.x.y-some-hash-123/file.go will be shortened go go-libp2p-package/file.go
+	if idx1 := strings.Index(file, "@"); idx1 != -1 {
+		if idx2 := strings.Index(file[idx1:], "/"); idx2 != -1 {
+			file = file[:idx1] + file[idx1+idx2:]
 		}
-		settings.name = fmt.Sprintf("%s-L%d", file, line)
-	} else {
-		settings.name = fmt.Sprintf("subscriber-%d", subCnt.Add(1))
 	}
-	return settings
+	return fmt.Sprintf("%s-L%d", file, line)
 }
 
 func BufSize(n int) func(interface{}) error {
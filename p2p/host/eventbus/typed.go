@@ -0,0 +1,107 @@
+package eventbus
+
+import (
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/event"
+)
+
+// TypedSubscription is a subscription to a single event type.
+// Unlike event.Subscription, it delivers the events as values of type T.
+type TypedSubscription[T any] struct {
+	sub event.Subscription
+	out chan T
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	done      chan struct{}
+}
+
+// SubscribeTyped subscribes to events of type T. It accepts the same options as
+// event.Bus.Subscribe.
+//
+//	sub, err := eventbus.SubscribeTyped[event.EvtLocalAddressesUpdated](bus)
+//	defer sub.Close()
+//	for evt := range sub.Out() {
+//		[...]
+//	}
+func SubscribeTyped[T any](bus event.Bus, opts ...event.SubscriptionOpt) (*TypedSubscription[T], error) {
+	// Name the subscription after our caller, not after this function.
+	opts = append([]event.SubscriptionOpt{Name(subscriberName(2))}, opts...)
+	sub, err := bus.Subscribe(new(T), opts...)
+	if err != nil {
+		return nil, err
+	}
+	s := &TypedSubscription[T]{
+		sub:    sub,
+		out:    make(chan T),
+		closed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go s.forward()
+	return s, nil
+}
+
+func (s *TypedSubscription[T]) forward() {
+	defer close(s.done)
+	defer close(s.out)
+	for {
+		select {
+		case e, ok := <-s.sub.Out():
+			if !ok {
+				return
+			}
+			select {
+			case s.out <- e.(T):
+			case <-s.closed:
+				return
+			}
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// Out returns the channel from which to consume events.
+// It is closed when the subscription is closed.
+func (s *TypedSubscription[T]) Out() <-chan T {
+	return s.out
+}
+
+// Name returns the name of the subscription.
+func (s *TypedSubscription[T]) Name() string {
+	return s.sub.Name()
+}
+
+// Close closes the subscription.
+func (s *TypedSubscription[T]) Close() error {
+	s.closeOnce.Do(func() { close(s.closed) })
+	err := s.sub.Close()
+	<-s.done
+	return err
+}
+
+// TypedEmitter emits events of type T.
+type TypedEmitter[T any] struct {
+	em event.Emitter
+}
+
+// NewTypedEmitter creates an emitter for events of type T. It accepts the same
+// options as event.Bus.Emitter.
+func NewTypedEmitter[T any](bus event.Bus, opts ...event.EmitterOpt) (*TypedEmitter[T], error) {
+	em, err := bus.Emitter(new(T), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedEmitter[T]{em: em}, nil
+}
+
+// Emit emits an event onto the eventbus.
+func (e *TypedEmitter[T]) Emit(evt T) error {
+	return e.em.Emit(evt)
+}
+
+// Close closes the emitter.
+func (e *TypedEmitter[T]) Close() error {
+	return e.em.Close()
+}
@@ -0,0 +1,54 @@
+package eventbus
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTypedSubscription(t *testing.T) {
+	bus := NewBus()
+	sub, err := SubscribeTyped[EventB](bus)
+	require.NoError(t, err)
+	defer sub.Close()
+	require.True(t, strings.HasSuffix(strings.Split(sub.Name(), "-L")[0], "typed_test.go"), "unexpected name: %s", sub.Name())
+
+	em, err := NewTypedEmitter[EventB](bus)
+	require.NoError(t, err)
+	defer em.Close()
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, em.Emit(EventB(i)))
+	}
+	for i := 0; i < 10; i++ {
+		select {
+		case evt := <-sub.Out():
+			require.Equal(t, EventB(i), evt)
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+}
+
+func TestTypedSubscriptionClose(t *testing.T) {
+	bus := NewBus()
+	sub, err := SubscribeTyped[EventB](bus, Name("typed"), BufSize(1))
+	require.NoError(t, err)
+	require.Equal(t, "typed", sub.Name())
+
+	em, err := NewTypedEmitter[EventB](bus)
+	require.NoError(t, err)
+	defer em.Close()
+	// fill the buffers without consuming the events
+	for i := 0; i < 2; i++ {
+		require.NoError(t, em.Emit(EventB(i)))
+	}
+
+	// Close must not block, even though nobody is reading.
+	require.NoError(t, sub.Close())
+	require.NoError(t, em.Emit(EventB(42)))
+	for range sub.Out() {
+	}
+}
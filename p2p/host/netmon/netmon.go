@@ -0,0 +1,193 @@
+// Package netmon implements a monitor for changes of the local network
+// interfaces.
+//
+// The monitor emits an event.EvtLocalInterfaceAddrsChanged on the event bus
+// every time the set of interface addresses changes. Changes are detected by
+// periodically polling the interface addresses and, where supported by the
+// platform (currently Linux, via netlink), by subscribing to kernel
+// notifications. Platforms that don't deliver notifications to Go code (e.g.
+// iOS and Android when using gomobile) can use Trigger to signal a network
+// change from the platform's own connectivity callbacks.
+package netmon
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+
+	logging "github.com/ipfs/go-log/v2"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+var log = logging.Logger("netmon")
+
+// DefaultPollInterval is the default interval between two polls of the
+// interface addresses.
+const DefaultPollInterval = 30 * time.Second
+
+type Option func(*Monitor) error
+
+// WithPollInterval sets the interval at which the interface addresses are
+// polled. A value of 0 disables polling, in which case changes are only
+// detected via platform notifications and Trigger.
+func WithPollInterval(d time.Duration) Option {
+	return func(m *Monitor) error {
+		m.pollInterval = d
+		return nil
+	}
+}
+
+// WithInterfaceAddrs sets the function used to obtain the interface addresses.
+// It defaults to manet.InterfaceMultiaddrs.
+func WithInterfaceAddrs(f func() ([]ma.Multiaddr, error)) Option {
+	return func(m *Monitor) error {
+		m.interfaceAddrs = f
+		return nil
+	}
+}
+
+// DisablePlatformNotifications disables subscribing to the platform's network
+// change notifications.
+func DisablePlatformNotifications() Option {
+	return func(m *Monitor) error {
+		m.disablePlatform = true
+		return nil
+	}
+}
+
+// Monitor watches the local network interfaces for address changes.
+type Monitor struct {
+	emitter event.Emitter
+
+	pollInterval    time.Duration
+	interfaceAddrs  func() ([]ma.Multiaddr, error)
+	disablePlatform bool
+
+	trigger chan struct{}
+
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+	refCount  sync.WaitGroup
+}
+
+// NewMonitor creates a new Monitor emitting events on eventBus.
+// Call Start to start monitoring.
+func NewMonitor(eventBus event.Bus, opts ...Option) (*Monitor, error) {
+	m := &Monitor{
+		pollInterval:   DefaultPollInterval,
+		interfaceAddrs: manet.InterfaceMultiaddrs,
+		trigger:        make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+	emitter, err := eventBus.Emitter(new(event.EvtLocalInterfaceAddrsChanged), eventbus.Stateful)
+	if err != nil {
+		return nil, err
+	}
+	m.emitter = emitter
+	m.ctx, m.ctxCancel = context.WithCancel(context.Background())
+	return m, nil
+}
+
+// Start starts monitoring the network interfaces.
+func (m *Monitor) Start() {
+	if !m.disablePlatform {
+		m.refCount.Add(1)
+		go func() {
+			defer m.refCount.Done()
+			if err := watchPlatform(m.ctx, m.Trigger); err != nil {
+				log.Debugw("platform network change notifications unavailable", "error", err)
+			}
+		}()
+	}
+	last, err := m.interfaceAddrs()
+	if err != nil {
+		log.Debugw("failed to get interface addresses", "error", err)
+	}
+	m.refCount.Add(1)
+	go m.background(last)
+}
+
+// Trigger signals the monitor that the network configuration might have
+// changed. The monitor then re-reads the interface addresses, and emits an
+// event if they changed.
+//
+// This is useful on platforms where the Go runtime isn't notified of network
+// changes, e.g. it can be called from the connectivity callbacks of a mobile
+// application.
+func (m *Monitor) Trigger() {
+	select {
+	case m.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Monitor) background(last []ma.Multiaddr) {
+	defer m.refCount.Done()
+
+	var tick <-chan time.Time
+	if m.pollInterval > 0 {
+		ticker := time.NewTicker(m.pollInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+		case <-m.trigger:
+		case <-m.ctx.Done():
+			return
+		}
+		current, err := m.interfaceAddrs()
+		if err != nil {
+			log.Debugw("failed to get interface addresses", "error", err)
+			continue
+		}
+		if evt := diffAddrs(last, current); evt != nil {
+			log.Debugw("interface addresses changed", "added", evt.Added, "removed", evt.Removed)
+			if err := m.emitter.Emit(*evt); err != nil {
+				log.Warnf("error emitting interface addresses changed event: %s", err)
+			}
+		}
+		last = current
+	}
+}
+
+func diffAddrs(prev, current []ma.Multiaddr) *event.EvtLocalInterfaceAddrsChanged {
+	prevMap := make(map[string]ma.Multiaddr, len(prev))
+	for _, a := range prev {
+		prevMap[string(a.Bytes())] = a
+	}
+	evt := &event.EvtLocalInterfaceAddrsChanged{Current: current}
+	for _, a := range current {
+		if _, ok := prevMap[string(a.Bytes())]; ok {
+			delete(prevMap, string(a.Bytes()))
+			continue
+		}
+		evt.Added = append(evt.Added, a)
+	}
+	for _, a := range prev {
+		if _, ok := prevMap[string(a.Bytes())]; ok {
+			evt.Removed = append(evt.Removed, a)
+		}
+	}
+	if len(evt.Added) == 0 && len(evt.Removed) == 0 {
+		return nil
+	}
+	return evt
+}
+
+// Close stops the monitor.
+func (m *Monitor) Close() error {
+	m.ctxCancel()
+	m.refCount.Wait()
+	return m.emitter.Close()
+}
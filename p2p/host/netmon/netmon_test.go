@@ -0,0 +1,102 @@
+package netmon
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+type mockInterfaces struct {
+	mx    sync.Mutex
+	addrs []ma.Multiaddr
+}
+
+func (m *mockInterfaces) set(addrs ...ma.Multiaddr) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.addrs = addrs
+}
+
+func (m *mockInterfaces) get() ([]ma.Multiaddr, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return append([]ma.Multiaddr(nil), m.addrs...), nil
+}
+
+func TestMonitorTrigger(t *testing.T) {
+	wifi := ma.StringCast("/ip4/192.168.1.10")
+	cellular := ma.StringCast("/ip4/10.20.30.40")
+	loopback := ma.StringCast("/ip4/127.0.0.1")
+
+	ifaces := &mockInterfaces{}
+	ifaces.set(loopback, wifi)
+
+	bus := eventbus.NewBus()
+	sub, err := bus.Subscribe(new(event.EvtLocalInterfaceAddrsChanged))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	m, err := NewMonitor(bus,
+		WithPollInterval(0),
+		WithInterfaceAddrs(ifaces.get),
+		DisablePlatformNotifications(),
+	)
+	require.NoError(t, err)
+	m.Start()
+	defer m.Close()
+
+	// nothing changed, so we don't expect an event
+	m.Trigger()
+	select {
+	case e := <-sub.Out():
+		t.Fatalf("didn't expect an event: %v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	ifaces.set(loopback, cellular)
+	m.Trigger()
+	select {
+	case e := <-sub.Out():
+		evt := e.(event.EvtLocalInterfaceAddrsChanged)
+		require.Equal(t, []ma.Multiaddr{cellular}, evt.Added)
+		require.Equal(t, []ma.Multiaddr{wifi}, evt.Removed)
+		require.Equal(t, []ma.Multiaddr{loopback, cellular}, evt.Current)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an event")
+	}
+}
+
+func TestMonitorPolling(t *testing.T) {
+	ifaces := &mockInterfaces{}
+	ifaces.set(ma.StringCast("/ip4/192.168.1.10"))
+
+	bus := eventbus.NewBus()
+	sub, err := bus.Subscribe(new(event.EvtLocalInterfaceAddrsChanged))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	m, err := NewMonitor(bus,
+		WithPollInterval(10*time.Millisecond),
+		WithInterfaceAddrs(ifaces.get),
+		DisablePlatformNotifications(),
+	)
+	require.NoError(t, err)
+	m.Start()
+	defer m.Close()
+
+	ifaces.set(ma.StringCast("/ip6/2001:db8::1"))
+	select {
+	case e := <-sub.Out():
+		evt := e.(event.EvtLocalInterfaceAddrsChanged)
+		require.Len(t, evt.Added, 1)
+		require.Len(t, evt.Removed, 1)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an event")
+	}
+}
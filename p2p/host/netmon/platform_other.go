@@ -0,0 +1,12 @@
+//go:build !linux
+
+package netmon
+
+import (
+	"context"
+	"errors"
+)
+
+func watchPlatform(context.Context, func()) error {
+	return errors.New("not supported on this platform")
+}
@@ -1,10 +1,15 @@
 package peerstore
 
 import (
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/metricshelper"
+
+	ma "github.com/multiformats/go-multiaddr"
 )
 
 // LatencyEWMASmoothing governs the decay of the EWMA (the speed
@@ -12,35 +17,124 @@ import (
 // 1 is 100% change, 0 is no change.
 var LatencyEWMASmoothing = 0.1
 
+// LatencyWindowSize is the number of recent measurements per peer and
+// transport that the latency percentiles are computed from.
+var LatencyWindowSize = 32
+
+// LatencyTransport returns the name of the transport used by a connection to
+// addr, as used by peerstore.TransportLatencyBook, e.g. "tcp", "quic-v1" or
+// "p2p-circuit".
+func LatencyTransport(addr ma.Multiaddr) string {
+	return metricshelper.GetTransport(addr)
+}
+
+// latencyRecord holds the measurements of a peer on one transport.
+type latencyRecord struct {
+	ewma    time.Duration
+	window  []time.Duration // ring buffer of the most recent measurements
+	next    int             // index of the next measurement in window
+	updated time.Time
+}
+
+func (r *latencyRecord) add(d time.Duration, s float64) {
+	if r.updated.IsZero() {
+		r.ewma = d
+	} else {
+		r.ewma = ewma(r.ewma, d, s)
+	}
+	r.updated = time.Now()
+	size := max(LatencyWindowSize, 1)
+	if len(r.window) < size {
+		r.window = append(r.window, d)
+		return
+	}
+	r.window[r.next] = d
+	r.next = (r.next + 1) % len(r.window)
+}
+
+func (r *latencyRecord) stats() peerstore.LatencyStats {
+	sorted := slices.Clone(r.window)
+	slices.Sort(sorted)
+	return peerstore.LatencyStats{
+		EWMA:    r.ewma,
+		P50:     percentile(sorted, 50),
+		P95:     percentile(sorted, 95),
+		Samples: len(sorted),
+		Updated: r.updated,
+	}
+}
+
+// percentile returns the p-th percentile of sorted, using the nearest rank method.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+func ewma(prev, next time.Duration, s float64) time.Duration {
+	return time.Duration(((1.0 - s) * float64(prev)) + (s * float64(next)))
+}
+
 type metrics struct {
 	mutex  sync.RWMutex
 	latmap map[peer.ID]time.Duration
+	// transports holds the latency records per peer and transport
+	transports map[peer.ID]map[string]*latencyRecord
 }
 
+var _ peerstore.TransportLatencyBook = (*metrics)(nil)
+
 func NewMetrics() *metrics {
 	return &metrics{
-		latmap: make(map[peer.ID]time.Duration),
+		latmap:     make(map[peer.ID]time.Duration),
+		transports: make(map[peer.ID]map[string]*latencyRecord),
 	}
 }
 
-// RecordLatency records a new latency measurement
-func (m *metrics) RecordLatency(p peer.ID, next time.Duration) {
-	nextf := float64(next)
+func smoothing() float64 {
 	s := LatencyEWMASmoothing
 	if s > 1 || s < 0 {
 		s = 0.1 // ignore the knob. it's broken. look, it jiggles.
 	}
+	return s
+}
 
+// RecordLatency records a new latency measurement
+func (m *metrics) RecordLatency(p peer.ID, next time.Duration) {
 	m.mutex.Lock()
-	ewma, found := m.latmap[p]
-	ewmaf := float64(ewma)
-	if !found {
+	m.recordLatencyLocked(p, next, smoothing())
+	m.mutex.Unlock()
+}
+
+func (m *metrics) recordLatencyLocked(p peer.ID, next time.Duration, s float64) {
+	if ewmaLat, found := m.latmap[p]; !found {
 		m.latmap[p] = next // when no data, just take it as the mean.
 	} else {
-		nextf = ((1.0 - s) * ewmaf) + (s * nextf)
-		m.latmap[p] = time.Duration(nextf)
+		m.latmap[p] = ewma(ewmaLat, next, s)
 	}
-	m.mutex.Unlock()
+}
+
+// RecordTransportLatency records a new latency measurement on a connection
+// using transport. It is also included in the EWMA returned by LatencyEWMA.
+func (m *metrics) RecordTransportLatency(p peer.ID, transport string, next time.Duration) {
+	s := smoothing()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.recordLatencyLocked(p, next, s)
+	records, ok := m.transports[p]
+	if !ok {
+		records = make(map[string]*latencyRecord)
+		m.transports[p] = records
+	}
+	r, ok := records[transport]
+	if !ok {
+		r = &latencyRecord{}
+		records[transport] = r
+	}
+	r.add(next, s)
 }
 
 // LatencyEWMA returns an exponentially-weighted moving avg.
@@ -51,8 +145,24 @@ func (m *metrics) LatencyEWMA(p peer.ID) time.Duration {
 	return m.latmap[p]
 }
 
+// LatencyStats returns the latency statistics of a peer per transport.
+func (m *metrics) LatencyStats(p peer.ID) map[string]peerstore.LatencyStats {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	records := m.transports[p]
+	if len(records) == 0 {
+		return nil
+	}
+	stats := make(map[string]peerstore.LatencyStats, len(records))
+	for t, r := range records {
+		stats[t] = r.stats()
+	}
+	return stats
+}
+
 func (m *metrics) RemovePeer(p peer.ID) {
 	m.mutex.Lock()
 	delete(m.latmap, p)
+	delete(m.transports, p)
 	m.mutex.Unlock()
 }
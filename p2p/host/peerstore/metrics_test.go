@@ -61,3 +61,60 @@ func TestLatencyEWMA(t *testing.T) {
 		t.Fatalf("latency outside of expected range. expected %d ± %d, got %d", exp, sig, lat)
 	}
 }
+
+func TestTransportLatency(t *testing.T) {
+	m := NewMetrics()
+	id, err := test.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats := m.LatencyStats(id); stats != nil {
+		t.Fatalf("expected no stats, got %v", stats)
+	}
+
+	// 1ms to 100ms over TCP, shuffled
+	for _, i := range rand.Perm(100) {
+		m.RecordTransportLatency(id, "tcp", time.Duration(i+1)*time.Millisecond)
+	}
+	m.RecordTransportLatency(id, "p2p-circuit", 500*time.Millisecond)
+
+	stats := m.LatencyStats(id)
+	if len(stats) != 2 {
+		t.Fatalf("expected stats for 2 transports, got %v", stats)
+	}
+	tcp := stats["tcp"]
+	if tcp.Samples != LatencyWindowSize {
+		t.Fatalf("expected %d samples, got %d", LatencyWindowSize, tcp.Samples)
+	}
+	if tcp.P50 > tcp.P95 || tcp.P95 > 100*time.Millisecond || tcp.P50 == 0 {
+		t.Fatalf("unexpected percentiles: p50 %s, p95 %s", tcp.P50, tcp.P95)
+	}
+	if relayed := stats["p2p-circuit"]; relayed.P50 != 500*time.Millisecond || relayed.P95 != 500*time.Millisecond || relayed.EWMA != 500*time.Millisecond {
+		t.Fatalf("unexpected stats for the relayed connection: %+v", relayed)
+	}
+	// measurements on all transports are included in the EWMA
+	if m.LatencyEWMA(id) <= tcp.EWMA {
+		t.Fatalf("expected the EWMA to include the relayed measurement")
+	}
+
+	m.RemovePeer(id)
+	if stats := m.LatencyStats(id); stats != nil {
+		t.Fatalf("expected no stats after removing the peer, got %v", stats)
+	}
+}
+
+func TestLatencyPercentiles(t *testing.T) {
+	m := NewMetrics()
+	id, err := test.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 20; i > 0; i-- {
+		m.RecordTransportLatency(id, "quic-v1", time.Duration(i)*time.Millisecond)
+	}
+	s := m.LatencyStats(id)["quic-v1"]
+	if s.Samples != 20 || s.P50 != 10*time.Millisecond || s.P95 != 19*time.Millisecond {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+}
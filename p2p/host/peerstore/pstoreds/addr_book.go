@@ -11,6 +11,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/peer"
 	pstore "github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/libp2p/go-libp2p/p2p/host/addrcheck"
 	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoreds/pb"
 	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
 
@@ -549,6 +550,11 @@ func (ab *dsAddrBook) setAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duratio
 
 	pr.dirty = true
 	pr.clean(ab.clock.Now())
+	// clean sorts the addresses by expiry, evict the ones that expire first
+	if limit := ab.opts.MaxAddrsPerPeer; limit > 0 && len(pr.Addrs) > limit {
+		log.Debugw("address book full, evicting addresses", "peer", p, "count", len(pr.Addrs)-limit)
+		pr.Addrs = pr.Addrs[len(pr.Addrs)-limit:]
+	}
 	return pr.flush(ab.ds)
 }
 
@@ -611,6 +617,7 @@ func cleanAddrs(addrs []ma.Multiaddr, pid peer.ID) []ma.Multiaddr {
 			log.Warnf("Was passed p2p address with a different peerId. found: %s, expected: %s", addrPid, pid)
 			continue
 		}
+		addr = addrcheck.Canonicalize(addr)
 		clean = append(clean, addr)
 	}
 	return clean
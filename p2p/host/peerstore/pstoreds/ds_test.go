@@ -40,6 +40,18 @@ func TestDsPeerstore(t *testing.T) {
 			defer ps.Close()
 			pt.TestPeerstoreProtoStoreLimits(t, ps, limit)
 		})
+
+		t.Run("addrbook limits", func(t *testing.T) {
+			const limit = 10
+			opts := DefaultOpts()
+			opts.MaxAddrsPerPeer = limit
+			ds, close := dsFactory(t)
+			defer close()
+			ps, err := NewPeerstore(context.Background(), ds, opts)
+			require.NoError(t, err)
+			defer ps.Close()
+			pt.TestPeerstoreAddrBookLimits(t, ps, limit)
+		})
 	}
 }
 
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/gob"
+	"time"
 
 	pool "github.com/libp2p/go-buffer-pool"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -30,6 +31,8 @@ func init() {
 	//
 	// Register complex types used by the peerstore itself.
 	gob.Register(make(map[protocol.ID]struct{}))
+	// Register types of the well-known metadata.
+	gob.Register(time.Time{})
 }
 
 // NewPeerMetadata creates a metadata store backed by a persistent db. It uses gob for serialisation.
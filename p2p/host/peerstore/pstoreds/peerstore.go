@@ -25,6 +25,10 @@ type Options struct {
 	// MaxProtocols is the maximum number of protocols we store for one peer.
 	MaxProtocols int
 
+	// MaxAddrsPerPeer is the maximum number of addresses we store for one peer. When it's exceeded, the addresses
+	// that expire first are evicted. A value of 0 or lower disables the limit.
+	MaxAddrsPerPeer int
+
 	// Sweep interval to purge expired addresses from the datastore. If this is a zero value, GC will not run
 	// automatically, but it'll be available on demand via explicit calls.
 	GCPurgeInterval time.Duration
@@ -44,6 +48,7 @@ type Options struct {
 //
 // * Cache size: 1024.
 // * MaxProtocols: 1024.
+// * MaxAddrsPerPeer: 1024.
 // * GC purge interval: 2 hours.
 // * GC lookahead interval: disabled.
 // * GC initial delay: 60 seconds.
@@ -51,6 +56,7 @@ func DefaultOpts() Options {
 	return Options{
 		CacheSize:           1024,
 		MaxProtocols:        1024,
+		MaxAddrsPerPeer:     1024,
 		GCPurgeInterval:     2 * time.Hour,
 		GCLookaheadInterval: 0,
 		GCInitialDelay:      60 * time.Second,
@@ -67,7 +73,10 @@ type pstoreds struct {
 	*dsPeerMetadata
 }
 
-var _ peerstore.Peerstore = &pstoreds{}
+var (
+	_ peerstore.Peerstore            = &pstoreds{}
+	_ peerstore.TransportLatencyBook = &pstoreds{}
+)
 
 // NewPeerstore creates a peerstore backed by the provided persistent datastore.
 // It's the caller's responsibility to call RemovePeer to ensure
@@ -191,3 +200,21 @@ func (ps *pstoreds) RemovePeer(p peer.ID) {
 	ps.dsPeerMetadata.RemovePeer(p)
 	ps.Metrics.RemovePeer(p)
 }
+
+// RecordTransportLatency records a latency measurement on a connection using
+// transport.
+func (ps *pstoreds) RecordTransportLatency(p peer.ID, transport string, d time.Duration) {
+	if lb, ok := peerstore.GetTransportLatencyBook(ps.Metrics); ok {
+		lb.RecordTransportLatency(p, transport, d)
+		return
+	}
+	ps.Metrics.RecordLatency(p, d)
+}
+
+// LatencyStats returns the latency statistics of a peer per transport.
+func (ps *pstoreds) LatencyStats(p peer.ID) map[string]peerstore.LatencyStats {
+	if lb, ok := peerstore.GetTransportLatencyBook(ps.Metrics); ok {
+		return lb.LatencyStats(p)
+	}
+	return nil
+}
@@ -10,17 +10,20 @@ import (
 	"github.com/libp2p/go-libp2p/core/peer"
 	pstore "github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/libp2p/go-libp2p/p2p/host/addrcheck"
 
 	logging "github.com/ipfs/go-log/v2"
 	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
 )
 
 var log = logging.Logger("peerstore")
 
 type expiringAddr struct {
-	Addr    ma.Multiaddr
-	TTL     time.Duration
-	Expires time.Time
+	Addr       ma.Multiaddr
+	TTL        time.Duration
+	Expires    time.Time
+	Provenance pstore.AddrProvenance
 }
 
 func (e *expiringAddr) ExpiredBy(t time.Time) bool {
@@ -68,13 +71,20 @@ type memoryAddrBook struct {
 
 	refCount sync.WaitGroup
 	cancel   func()
+	closing  <-chan struct{}
 
 	subManager *AddrSubManager
+	watchers   addrWatchers
 	clock      clock
+
+	maxAddrsPerPeer int
+	resolver        *madns.Resolver
 }
 
 var _ pstore.AddrBook = (*memoryAddrBook)(nil)
 var _ pstore.CertifiedAddrBook = (*memoryAddrBook)(nil)
+var _ pstore.SourcedAddrBook = (*memoryAddrBook)(nil)
+var _ pstore.WatchableAddrBook = (*memoryAddrBook)(nil)
 
 func NewAddrBook() *memoryAddrBook {
 	ctx, cancel := context.WithCancel(context.Background())
@@ -89,8 +99,12 @@ func NewAddrBook() *memoryAddrBook {
 			return ret
 		}(),
 		subManager: NewAddrSubManager(),
+		watchers:   addrWatchers{m: make(map[peer.ID][]*addrWatcher)},
 		cancel:     cancel,
+		closing:    ctx.Done(),
 		clock:      realclock{},
+
+		maxAddrsPerPeer: 1024,
 	}
 	ab.refCount.Add(1)
 	go ab.background(ctx)
@@ -106,7 +120,32 @@ func WithClock(clock clock) AddrBookOption {
 	}
 }
 
-// background periodically schedules a gc
+// WithMaxAddrsPerPeer limits the number of addresses stored for a peer. When
+// the limit is reached, adding an address evicts the one least worth keeping:
+// expired addresses first, then the addresses from the least trusted sources,
+// then the ones that expire first. An address that would be evicted itself is
+// not added. A value of 0 or lower disables the limit. Defaults to 1024.
+func WithMaxAddrsPerPeer(num int) AddrBookOption {
+	return func(book *memoryAddrBook) error {
+		book.maxAddrsPerPeer = num
+		return nil
+	}
+}
+
+// WithDNSResolver makes the address book periodically resolve the /dns,
+// /dns4 and /dns6 addresses of peers that also have IP addresses, and drop
+// the IP addresses that the DNS addresses resolve to, if they are redundant:
+// an IP address is only dropped if it was learned from the same source and
+// with the same TTL as the DNS address, and doesn't outlive it. Connected
+// addresses and the addresses of signed peer records are always kept.
+func WithDNSResolver(r *madns.Resolver) AddrBookOption {
+	return func(book *memoryAddrBook) error {
+		book.resolver = r
+		return nil
+	}
+}
+
+// background periodically schedules a gc and a compaction
 func (mab *memoryAddrBook) background(ctx context.Context) {
 	defer mab.refCount.Done()
 	ticker := time.NewTicker(1 * time.Hour)
@@ -116,6 +155,7 @@ func (mab *memoryAddrBook) background(ctx context.Context) {
 		select {
 		case <-ticker.C:
 			mab.gc()
+			mab.compact(ctx)
 		case <-ctx.Done():
 			return
 		}
@@ -134,15 +174,20 @@ func (mab *memoryAddrBook) gc() {
 	for _, s := range mab.segments {
 		s.Lock()
 		for p, amap := range s.addrs {
+			removed := false
 			for k, addr := range amap {
 				if addr.ExpiredBy(now) {
 					delete(amap, k)
+					removed = true
 				}
 			}
 			if len(amap) == 0 {
 				delete(s.addrs, p)
 				delete(s.signedPeerRecords, p)
 			}
+			if removed {
+				mab.notifyWatchersLocked(s, p)
+			}
 		}
 		s.Unlock()
 	}
@@ -181,7 +226,34 @@ func (mab *memoryAddrBook) AddAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Du
 	// if peerRec != nil {
 	// 	return
 	// }
-	mab.addAddrs(p, addrs, ttl)
+	mab.addAddrs(p, addrs, ttl, mab.unknownProvenance())
+}
+
+// AddAddrsFromSource is like AddAddrs, but records the source of the addresses
+// and how much they're trusted.
+func (mab *memoryAddrBook) AddAddrsFromSource(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration, source pstore.AddrSource, trust pstore.AddrTrust) {
+	mab.addAddrs(p, addrs, ttl, pstore.AddrProvenance{Source: source, Trust: trust, Added: mab.clock.Now()})
+}
+
+// AddrProvenance returns the provenance of an address of a peer.
+func (mab *memoryAddrBook) AddrProvenance(p peer.ID, addr ma.Multiaddr) (pstore.AddrProvenance, bool) {
+	s := mab.segments.get(p)
+	s.RLock()
+	defer s.RUnlock()
+
+	a, ok := s.addrs[p][string(addr.Bytes())]
+	if !ok || a.ExpiredBy(mab.clock.Now()) {
+		return pstore.AddrProvenance{}, false
+	}
+	return a.Provenance, true
+}
+
+func (mab *memoryAddrBook) unknownProvenance() pstore.AddrProvenance {
+	return pstore.AddrProvenance{
+		Source: pstore.AddrSourceUnknown,
+		Trust:  pstore.DefaultAddrTrust(pstore.AddrSourceUnknown),
+		Added:  mab.clock.Now(),
+	}
 }
 
 // ConsumePeerRecord adds addresses from a signed peer.PeerRecord (contained in
@@ -212,19 +284,21 @@ func (mab *memoryAddrBook) ConsumePeerRecord(recordEnvelope *record.Envelope, tt
 		Envelope: recordEnvelope,
 		Seq:      rec.Seq,
 	}
-	mab.addAddrsUnlocked(s, rec.PeerID, rec.Addrs, ttl, true)
+	mab.addAddrsUnlocked(s, rec.PeerID, rec.Addrs, ttl, true, mab.unknownProvenance())
+	// the set of certified addresses may have changed
+	mab.notifyWatchersLocked(s, rec.PeerID)
 	return true, nil
 }
 
-func (mab *memoryAddrBook) addAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
+func (mab *memoryAddrBook) addAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration, prov pstore.AddrProvenance) {
 	s := mab.segments.get(p)
 	s.Lock()
 	defer s.Unlock()
 
-	mab.addAddrsUnlocked(s, p, addrs, ttl, false)
+	mab.addAddrsUnlocked(s, p, addrs, ttl, false, prov)
 }
 
-func (mab *memoryAddrBook) addAddrsUnlocked(s *addrSegment, p peer.ID, addrs []ma.Multiaddr, ttl time.Duration, signed bool) {
+func (mab *memoryAddrBook) addAddrsUnlocked(s *addrSegment, p peer.ID, addrs []ma.Multiaddr, ttl time.Duration, signed bool, prov pstore.AddrProvenance) {
 	// if ttl is zero, exit. nothing to do.
 	if ttl <= 0 {
 		return
@@ -237,6 +311,7 @@ func (mab *memoryAddrBook) addAddrsUnlocked(s *addrSegment, p peer.ID, addrs []m
 	}
 
 	exp := mab.clock.Now().Add(ttl)
+	changed := false
 	for _, addr := range addrs {
 		// Remove suffix of /p2p/peer-id from address
 		addr, addrPid := peer.SplitAddr(addr)
@@ -248,15 +323,28 @@ func (mab *memoryAddrBook) addAddrsUnlocked(s *addrSegment, p peer.ID, addrs []m
 			log.Warnf("Was passed p2p address with a different peerId. found: %s, expected: %s", addrPid, p)
 			continue
 		}
+		addr = addrcheck.Canonicalize(addr)
 		// find the highest TTL and Expiry time between
 		// existing records and function args
 		a, found := amap[string(addr.Bytes())] // won't allocate.
 		if !found {
 			// not found, announce it.
-			entry := &expiringAddr{Addr: addr, Expires: exp, TTL: ttl}
+			entry := &expiringAddr{Addr: addr, Expires: exp, TTL: ttl, Provenance: prov}
+			if !mab.makeRoom(p, amap, entry) {
+				continue
+			}
 			amap[string(addr.Bytes())] = entry
 			mab.subManager.BroadcastAddr(p, addr)
+			changed = true
 		} else {
+			if a.ExpiredBy(mab.clock.Now()) {
+				changed = true
+			}
+			// keep the provenance of the most trusted source
+			if a.ExpiredBy(mab.clock.Now()) || prov.Source != pstore.AddrSourceUnknown &&
+				(a.Provenance.Source == pstore.AddrSourceUnknown || prov.Trust >= a.Provenance.Trust) {
+				a.Provenance = prov
+			}
 			// update ttl & exp to whichever is greater between new and existing entry
 			if ttl > a.TTL {
 				a.TTL = ttl
@@ -266,6 +354,43 @@ func (mab *memoryAddrBook) addAddrsUnlocked(s *addrSegment, p peer.ID, addrs []m
 			}
 		}
 	}
+	if changed && !signed {
+		mab.notifyWatchersLocked(s, p)
+	}
+}
+
+// makeRoom makes room for the new address e if amap holds the maximum number
+// of addresses, by evicting the address that is least worth keeping. It
+// returns false if that's e itself.
+func (mab *memoryAddrBook) makeRoom(p peer.ID, amap map[string]*expiringAddr, e *expiringAddr) bool {
+	if mab.maxAddrsPerPeer <= 0 || len(amap) < mab.maxAddrsPerPeer {
+		return true
+	}
+	now := mab.clock.Now()
+	victimKey, victim := "", e
+	for k, a := range amap {
+		if evictBefore(a, victim, now) {
+			victimKey, victim = k, a
+		}
+	}
+	if victim == e {
+		log.Debugw("address book full, not adding address", "peer", p, "addr", e.Addr)
+		return false
+	}
+	log.Debugw("address book full, evicting address", "peer", p, "addr", victim.Addr)
+	delete(amap, victimKey)
+	return true
+}
+
+// evictBefore returns true if a should be evicted before b.
+func evictBefore(a, b *expiringAddr, now time.Time) bool {
+	if aExp, bExp := a.ExpiredBy(now), b.ExpiredBy(now); aExp != bExp {
+		return aExp
+	}
+	if a.Provenance.Trust != b.Provenance.Trust {
+		return a.Provenance.Trust < b.Provenance.Trust
+	}
+	return a.Expires.Before(b.Expires)
 }
 
 // SetAddr calls mgr.SetAddrs(p, addr, ttl)
@@ -297,17 +422,28 @@ func (mab *memoryAddrBook) SetAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Du
 			log.Warnf("was passed p2p address with a different peerId, found: %s wanted: %s", addrPid, p)
 			continue
 		}
+		addr = addrcheck.Canonicalize(addr)
 		aBytes := addr.Bytes()
 		key := string(aBytes)
 
 		// re-set all of them for new ttl.
 		if ttl > 0 {
-			amap[key] = &expiringAddr{Addr: addr, Expires: exp, TTL: ttl}
+			prov := mab.unknownProvenance()
+			a, ok := amap[key]
+			if ok {
+				prov = a.Provenance
+			}
+			entry := &expiringAddr{Addr: addr, Expires: exp, TTL: ttl, Provenance: prov}
+			if !ok && !mab.makeRoom(p, amap, entry) {
+				continue
+			}
+			amap[key] = entry
 			mab.subManager.BroadcastAddr(p, addr)
 		} else {
 			delete(amap, key)
 		}
 	}
+	mab.notifyWatchersLocked(s, p)
 }
 
 // UpdateAddrs updates the addresses associated with the given peer that have
@@ -322,10 +458,12 @@ func (mab *memoryAddrBook) UpdateAddrs(p peer.ID, oldTTL time.Duration, newTTL t
 		return
 	}
 
+	removed := false
 	for k, a := range amap {
 		if oldTTL == a.TTL {
 			if newTTL == 0 {
 				delete(amap, k)
+				removed = true
 			} else {
 				a.TTL = newTTL
 				a.Expires = exp
@@ -333,6 +471,9 @@ func (mab *memoryAddrBook) UpdateAddrs(p peer.ID, oldTTL time.Duration, newTTL t
 			}
 		}
 	}
+	if removed {
+		mab.notifyWatchersLocked(s, p)
+	}
 }
 
 // Addrs returns all known (and valid) addresses for a given peer
@@ -388,6 +529,7 @@ func (mab *memoryAddrBook) ClearAddrs(p peer.ID) {
 
 	delete(s.addrs, p)
 	delete(s.signedPeerRecords, p)
+	mab.notifyWatchersLocked(s, p)
 }
 
 // AddrStream returns a channel on which all new addresses discovered for a
@@ -0,0 +1,133 @@
+package pstoremem
+
+import (
+	"context"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	pstore "github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/host/addrcheck"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// resolveTimeout is the time compact waits for a DNS address to resolve.
+const resolveTimeout = 10 * time.Second
+
+type dnsAddr struct {
+	peer peer.ID
+	addr ma.Multiaddr
+}
+
+// compact removes the IP addresses of peers that one of their DNS addresses
+// resolves to, if they are redundant, see mergeResolvedAddrs. It is a no-op
+// unless a resolver was set with WithDNSResolver.
+//
+// The DNS addresses are resolved without holding the segment locks.
+func (mab *memoryAddrBook) compact(ctx context.Context) {
+	if mab.resolver == nil {
+		return
+	}
+	for _, s := range mab.segments {
+		for _, d := range mab.dnsAddrsToResolve(s) {
+			rctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+			resolved, err := mab.resolver.Resolve(rctx, d.addr)
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Debugw("failed to resolve address", "peer", d.peer, "addr", d.addr, "error", err)
+				continue
+			}
+			mab.mergeResolvedAddrs(s, d, resolved)
+		}
+	}
+}
+
+// dnsAddrsToResolve returns the /dns, /dns4 and /dns6 addresses of the peers
+// in s that also have IP addresses.
+func (mab *memoryAddrBook) dnsAddrsToResolve(s *addrSegment) []dnsAddr {
+	now := mab.clock.Now()
+	s.RLock()
+	defer s.RUnlock()
+
+	var res []dnsAddr
+	for p, amap := range s.addrs {
+		var dns []ma.Multiaddr
+		hasIP := false
+		for _, a := range amap {
+			if a.ExpiredBy(now) {
+				continue
+			}
+			switch first, _ := ma.SplitFirst(a.Addr); first.Protocol().Code {
+			case ma.P_DNS, ma.P_DNS4, ma.P_DNS6:
+				dns = append(dns, a.Addr)
+			case ma.P_IP4, ma.P_IP6:
+				hasIP = true
+			}
+		}
+		if !hasIP {
+			continue
+		}
+		for _, a := range dns {
+			res = append(res, dnsAddr{peer: p, addr: a})
+		}
+	}
+	return res
+}
+
+// mergeResolvedAddrs removes the addresses in resolved from the addresses of
+// d.peer, if they are redundant with d.addr: they must have the same source
+// and TTL as d.addr, and expire no later than d.addr. Connected addresses and
+// certified addresses are never removed. d.addr itself is left unchanged.
+func (mab *memoryAddrBook) mergeResolvedAddrs(s *addrSegment, d dnsAddr, resolved []ma.Multiaddr) {
+	s.Lock()
+	defer s.Unlock()
+
+	amap := s.addrs[d.peer]
+	dnsEntry, ok := amap[string(d.addr.Bytes())]
+	if !ok || dnsEntry.ExpiredBy(mab.clock.Now()) {
+		return
+	}
+	certified := certifiedAddrsLocked(s, d.peer)
+	removed := false
+	for _, r := range resolved {
+		key := string(addrcheck.Canonicalize(r).Bytes())
+		a, ok := amap[key]
+		if !ok || a == dnsEntry || certified[key] {
+			continue
+		}
+		if a.TTL == pstore.ConnectedAddrTTL || a.TTL != dnsEntry.TTL ||
+			a.Provenance.Source != dnsEntry.Provenance.Source || a.Expires.After(dnsEntry.Expires) {
+			continue
+		}
+		delete(amap, key)
+		removed = true
+	}
+	if removed {
+		mab.notifyWatchersLocked(s, d.peer)
+	}
+}
+
+// certifiedAddrsLocked returns the addresses in the signed peer record of p,
+// keyed by their canonical binary representation.
+func certifiedAddrsLocked(s *addrSegment, p peer.ID) map[string]bool {
+	state, ok := s.signedPeerRecords[p]
+	if !ok {
+		return nil
+	}
+	r, err := state.Envelope.Record()
+	if err != nil {
+		return nil
+	}
+	rec, ok := r.(*peer.PeerRecord)
+	if !ok {
+		return nil
+	}
+	certified := make(map[string]bool, len(rec.Addrs))
+	for _, a := range rec.Addrs {
+		certified[string(addrcheck.Canonicalize(a).Bytes())] = true
+	}
+	return certified
+}
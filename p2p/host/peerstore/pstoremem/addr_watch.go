@@ -0,0 +1,212 @@
+package pstoremem
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	pstore "github.com/libp2p/go-libp2p/core/peerstore"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// addrWatcher tracks the filtered addresses of a peer for a WatchAddrs
+// channel. The address book replaces the current set on every change, and the
+// watcher's Go routine sends the difference to the last set it sent.
+type addrWatcher struct {
+	filters []pstore.AddrWatchFilter
+
+	mu sync.Mutex
+	// current is never modified, only replaced.
+	current map[string]ma.Multiaddr
+
+	notify chan struct{}
+}
+
+func (w *addrWatcher) matches(a ma.Multiaddr, certified bool) bool {
+	for _, f := range w.filters {
+		if !f(a, certified) {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *addrWatcher) update(addrs []ma.Multiaddr, certified map[string]struct{}) {
+	current := make(map[string]ma.Multiaddr, len(addrs))
+	for _, a := range addrs {
+		k := string(a.Bytes())
+		if _, ok := certified[k]; w.matches(a, ok) {
+			current[k] = a
+		}
+	}
+	w.mu.Lock()
+	w.current = current
+	w.mu.Unlock()
+
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (w *addrWatcher) run(ctx context.Context, closing <-chan struct{}, out chan<- pstore.AddrUpdate) {
+	var sent map[string]ma.Multiaddr
+	for {
+		w.mu.Lock()
+		current := w.current
+		w.mu.Unlock()
+
+		if u, ok := diffAddrs(sent, current); ok {
+			// If more changes arrived in the meantime, merge them into this update.
+			select {
+			case <-w.notify:
+				continue
+			default:
+			}
+			select {
+			case out <- u:
+				sent = current
+			case <-w.notify:
+				continue
+			case <-ctx.Done():
+				return
+			case <-closing:
+				return
+			}
+		}
+
+		select {
+		case <-w.notify:
+		case <-ctx.Done():
+			return
+		case <-closing:
+			return
+		}
+	}
+}
+
+// diffAddrs returns the update from sent to current. If sent is nil, it returns
+// a snapshot. It returns false if there are no changes.
+func diffAddrs(sent, current map[string]ma.Multiaddr) (pstore.AddrUpdate, bool) {
+	if sent == nil {
+		u := pstore.AddrUpdate{Snapshot: true, Added: make([]ma.Multiaddr, 0, len(current))}
+		for _, a := range current {
+			u.Added = append(u.Added, a)
+		}
+		sort.Sort(addrList(u.Added))
+		return u, true
+	}
+	var u pstore.AddrUpdate
+	for k, a := range current {
+		if _, ok := sent[k]; !ok {
+			u.Added = append(u.Added, a)
+		}
+	}
+	for k, a := range sent {
+		if _, ok := current[k]; !ok {
+			u.Removed = append(u.Removed, a)
+		}
+	}
+	sort.Sort(addrList(u.Added))
+	sort.Sort(addrList(u.Removed))
+	return u, len(u.Added) > 0 || len(u.Removed) > 0
+}
+
+type addrWatchers struct {
+	mu sync.Mutex
+	m  map[peer.ID][]*addrWatcher
+}
+
+func (ws *addrWatchers) add(p peer.ID, w *addrWatcher) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.m[p] = append(ws.m[p], w)
+}
+
+func (ws *addrWatchers) remove(p peer.ID, w *addrWatcher) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	watchers := ws.m[p]
+	for i, v := range watchers {
+		if v == w {
+			watchers[i] = watchers[len(watchers)-1]
+			watchers[len(watchers)-1] = nil
+			watchers = watchers[:len(watchers)-1]
+			break
+		}
+	}
+	if len(watchers) == 0 {
+		delete(ws.m, p)
+	} else {
+		ws.m[p] = watchers
+	}
+}
+
+func (ws *addrWatchers) get(p peer.ID) []*addrWatcher {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return slices.Clone(ws.m[p])
+}
+
+// WatchAddrs returns a channel that receives a snapshot of the addresses of p
+// that match all filters, followed by the changes.
+// See https://godoc.org/github.com/libp2p/go-libp2p/core/peerstore#WatchableAddrBook for more details.
+func (mab *memoryAddrBook) WatchAddrs(ctx context.Context, p peer.ID, filters ...pstore.AddrWatchFilter) <-chan pstore.AddrUpdate {
+	w := &addrWatcher{
+		filters: filters,
+		notify:  make(chan struct{}, 1),
+	}
+	out := make(chan pstore.AddrUpdate)
+
+	// Hold the segment lock, so that no change is missed between taking the
+	// snapshot and registering the watcher.
+	s := mab.segments.get(p)
+	s.RLock()
+	addrs, certified := mab.watchedAddrsLocked(s, p)
+	w.update(addrs, certified)
+	mab.watchers.add(p, w)
+	s.RUnlock()
+
+	mab.refCount.Add(1)
+	go func() {
+		defer mab.refCount.Done()
+		defer close(out)
+		defer mab.watchers.remove(p, w)
+		w.run(ctx, mab.closing, out)
+	}()
+	return out
+}
+
+// notifyWatchersLocked sends the current addresses of p to its watchers. It
+// must be called with the segment of p locked.
+func (mab *memoryAddrBook) notifyWatchersLocked(s *addrSegment, p peer.ID) {
+	watchers := mab.watchers.get(p)
+	if len(watchers) == 0 {
+		return
+	}
+	addrs, certified := mab.watchedAddrsLocked(s, p)
+	for _, w := range watchers {
+		w.update(addrs, certified)
+	}
+}
+
+// watchedAddrsLocked returns the valid addresses of p, and the set of
+// addresses contained in its signed peer record.
+func (mab *memoryAddrBook) watchedAddrsLocked(s *addrSegment, p peer.ID) ([]ma.Multiaddr, map[string]struct{}) {
+	addrs := validAddrs(mab.clock.Now(), s.addrs[p])
+	var certified map[string]struct{}
+	if state := s.signedPeerRecords[p]; state != nil {
+		if r, err := state.Envelope.Record(); err == nil {
+			if rec, ok := r.(*peer.PeerRecord); ok {
+				certified = make(map[string]struct{}, len(rec.Addrs))
+				for _, a := range rec.Addrs {
+					certified[string(a.Bytes())] = struct{}{}
+				}
+			}
+		}
+	}
+	return addrs, certified
+}
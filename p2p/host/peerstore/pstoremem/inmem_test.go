@@ -1,12 +1,22 @@
 package pstoremem
 
 import (
+	"context"
+	"crypto/rand"
+	"net"
 	"testing"
+	"time"
 
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
 	pstore "github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/libp2p/go-libp2p/core/test"
 	pt "github.com/libp2p/go-libp2p/p2p/host/peerstore/test"
 
 	mockClock "github.com/benbjohnson/clock"
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/goleak"
 )
@@ -42,6 +52,83 @@ func TestPeerstoreProtoStoreLimits(t *testing.T) {
 	pt.TestPeerstoreProtoStoreLimits(t, ps, limit)
 }
 
+func TestPeerstoreAddrBookLimits(t *testing.T) {
+	const limit = 10
+	ps, err := NewPeerstore(WithMaxAddrsPerPeer(limit))
+	require.NoError(t, err)
+	defer ps.Close()
+	pt.TestPeerstoreAddrBookLimits(t, ps, limit)
+}
+
+func TestAddrBookLimitsPreferTrustedAddrs(t *testing.T) {
+	ab := NewAddrBook()
+	defer ab.Close()
+	require.NoError(t, WithMaxAddrsPerPeer(2)(ab))
+
+	p := peer.ID("foobar")
+	dht := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	connected := ma.StringCast("/ip4/1.2.3.4/tcp/2")
+	gossip := ma.StringCast("/ip4/1.2.3.4/tcp/3")
+	ab.AddAddrsFromSource(p, []ma.Multiaddr{dht}, time.Minute, pstore.AddrSourceDHT, pstore.AddrTrustLow)
+	ab.AddAddrsFromSource(p, []ma.Multiaddr{connected}, time.Minute, pstore.AddrSourceIdentify, pstore.AddrTrustHigh)
+	// a long-lived address from a less trusted source doesn't evict a more trusted one
+	ab.AddAddrsFromSource(p, []ma.Multiaddr{gossip}, time.Hour, pstore.AddrSourceDHT, pstore.AddrTrustLow)
+	pt.AssertAddressesEqual(t, []ma.Multiaddr{connected, gossip}, ab.Addrs(p))
+}
+
+func TestAddrBookCompactDNS(t *testing.T) {
+	resolver, err := madns.NewResolver(madns.WithDefaultResolver(&madns.MockResolver{
+		IP: map[string][]net.IPAddr{"example.com": {
+			{IP: net.ParseIP("1.2.3.1")},
+			{IP: net.ParseIP("1.2.3.2")},
+			{IP: net.ParseIP("1.2.3.3")},
+			{IP: net.ParseIP("1.2.3.4")},
+			{IP: net.ParseIP("1.2.3.5")},
+			{IP: net.ParseIP("1.2.3.6")},
+		}},
+	}))
+	require.NoError(t, err)
+	clk := mockClock.NewMock()
+	ab := NewAddrBook()
+	defer ab.Close()
+	require.NoError(t, WithClock(clk)(ab))
+	require.NoError(t, WithDNSResolver(resolver)(ab))
+
+	priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	p, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+
+	dns := ma.StringCast("/dns4/example.com/tcp/1234")
+	redundant := ma.StringCast("/ip4/1.2.3.1/tcp/1234")
+	otherSource := ma.StringCast("/ip4/1.2.3.2/tcp/1234")
+	otherTTL := ma.StringCast("/ip4/1.2.3.3/tcp/1234")
+	connected := ma.StringCast("/ip4/1.2.3.4/tcp/1234")
+	certified := ma.StringCast("/ip4/1.2.3.5/tcp/1234")
+	unrelated := ma.StringCast("/ip4/1.2.3.7/tcp/1234")
+
+	pstore.AddAddrsFromSource(ab, p, []ma.Multiaddr{dns, redundant, certified}, time.Hour, pstore.AddrSourceDHT)
+	pstore.AddAddrsFromSource(ab, p, []ma.Multiaddr{otherSource}, time.Hour, pstore.AddrSourceIdentify)
+	pstore.AddAddrsFromSource(ab, p, []ma.Multiaddr{otherTTL}, time.Minute, pstore.AddrSourceDHT)
+	ab.AddAddr(p, connected, pstore.ConnectedAddrTTL)
+	ab.AddAddr(p, unrelated, time.Hour)
+	env, err := record.Seal(peer.PeerRecordFromAddrInfo(peer.AddrInfo{ID: p, Addrs: []ma.Multiaddr{certified}}), priv)
+	require.NoError(t, err)
+	_, err = ab.ConsumePeerRecord(env, time.Hour)
+	require.NoError(t, err)
+	// an address that outlives the DNS address
+	clk.Add(time.Second)
+	later := ma.StringCast("/ip4/1.2.3.6/tcp/1234")
+	pstore.AddAddrsFromSource(ab, p, []ma.Multiaddr{later}, time.Hour, pstore.AddrSourceDHT)
+
+	ab.compact(context.Background())
+	pt.AssertAddressesEqual(t, []ma.Multiaddr{dns, otherSource, otherTTL, connected, certified, later, unrelated}, ab.Addrs(p))
+
+	// the DNS address is not extended
+	clk.Add(time.Hour - time.Second)
+	require.NotContains(t, ab.Addrs(p), dns)
+}
+
 func TestInMemoryAddrBook(t *testing.T) {
 	clk := mockClock.NewMock()
 	pt.TestAddrBook(t, func() (pstore.AddrBook, func()) {
@@ -51,6 +138,156 @@ func TestInMemoryAddrBook(t *testing.T) {
 	}, clk)
 }
 
+func TestInMemoryAddrProvenance(t *testing.T) {
+	clk := mockClock.NewMock()
+	ps, err := NewPeerstore(WithClock(clk))
+	require.NoError(t, err)
+	defer ps.Close()
+
+	p := test.RandPeerIDFatal(t)
+	a1 := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	a2 := ma.StringCast("/ip4/1.2.3.4/tcp/2")
+	a3 := ma.StringCast("/ip4/1.2.3.4/tcp/3")
+
+	getProvenance := func(a ma.Multiaddr) pstore.AddrProvenance {
+		t.Helper()
+		prov, ok := ps.AddrProvenance(p, a)
+		require.True(t, ok)
+		return prov
+	}
+
+	_, ok := ps.AddrProvenance(p, a1)
+	require.False(t, ok)
+
+	pstore.AddAddrsFromSource(ps, p, []ma.Multiaddr{a1, a2}, time.Hour, pstore.AddrSourceDHT)
+	ps.AddAddr(p, a3, time.Hour)
+	require.Equal(t, pstore.AddrProvenance{Source: pstore.AddrSourceDHT, Trust: pstore.AddrTrustLow, Added: clk.Now()}, getProvenance(a1))
+	require.Equal(t, pstore.AddrSourceUnknown, getProvenance(a3).Source)
+	require.Equal(t, pstore.AddrTrustMedium, getProvenance(a3).Trust)
+
+	// a more trusted source replaces the provenance
+	clk.Add(time.Second)
+	pstore.AddAddrsFromSource(ps, p, []ma.Multiaddr{a1}, time.Hour, pstore.AddrSourceIdentify)
+	require.Equal(t, pstore.AddrProvenance{Source: pstore.AddrSourceIdentify, Trust: pstore.AddrTrustHigh, Added: clk.Now()}, getProvenance(a1))
+
+	// a less trusted source, or an unknown one, doesn't
+	pstore.AddAddrsFromSource(ps, p, []ma.Multiaddr{a1}, time.Hour, pstore.AddrSourceDHT)
+	ps.AddAddr(p, a1, time.Hour)
+	ps.SetAddr(p, a1, time.Hour)
+	require.Equal(t, pstore.AddrSourceIdentify, getProvenance(a1).Source)
+
+	// any known source replaces an unknown one
+	pstore.AddAddrsFromSource(ps, p, []ma.Multiaddr{a3}, time.Hour, pstore.AddrSourceDHT)
+	require.Equal(t, pstore.AddrSourceDHT, getProvenance(a3).Source)
+
+	require.ElementsMatch(t, []ma.Multiaddr{a1, a2, a3}, pstore.AddrsWithMinTrust(ps, p, pstore.AddrTrustLow))
+	require.ElementsMatch(t, []ma.Multiaddr{a1}, pstore.AddrsWithMinTrust(ps, p, pstore.AddrTrustMedium))
+	require.ElementsMatch(t, []ma.Multiaddr{a1}, pstore.AddrsWithMinTrust(ps, p, pstore.AddrTrustHigh))
+
+	// expired addresses have no provenance
+	clk.Add(2 * time.Hour)
+	_, ok = ps.AddrProvenance(p, a1)
+	require.False(t, ok)
+}
+
+func TestInMemoryAddNewAddrsFromSource(t *testing.T) {
+	ps, err := NewPeerstore()
+	require.NoError(t, err)
+	defer ps.Close()
+
+	p := test.RandPeerIDFatal(t)
+	fromDHT := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	unknown := ma.StringCast("/ip4/1.2.3.4/tcp/2")
+	unseen := ma.StringCast("/ip4/1.2.3.4/tcp/3")
+	pstore.AddAddrsFromSource(ps, p, []ma.Multiaddr{fromDHT}, time.Minute, pstore.AddrSourceDHT)
+	ps.AddAddr(p, unknown, time.Minute)
+
+	pstore.AddNewAddrsFromSource(ps, p, []ma.Multiaddr{fromDHT, unknown, unseen}, time.Hour, pstore.AddrSourceManual)
+	for a, source := range map[ma.Multiaddr]pstore.AddrSource{
+		fromDHT: pstore.AddrSourceDHT,
+		unknown: pstore.AddrSourceManual,
+		unseen:  pstore.AddrSourceManual,
+	} {
+		prov, ok := ps.AddrProvenance(p, a)
+		require.True(t, ok)
+		require.Equal(t, source, prov.Source, a)
+	}
+	pt.AssertAddressesEqual(t, []ma.Multiaddr{fromDHT, unknown, unseen}, ps.Addrs(p))
+}
+
+func TestInMemoryAddrWatch(t *testing.T) {
+	ps, err := NewPeerstore()
+	require.NoError(t, err)
+
+	priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	p, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+	public1 := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	public2 := ma.StringCast("/ip4/1.2.3.4/tcp/2")
+	private := ma.StringCast("/ip4/192.168.1.1/tcp/1")
+	ps.AddAddrs(p, []ma.Multiaddr{public1, private}, time.Hour)
+
+	next := func(ch <-chan pstore.AddrUpdate) pstore.AddrUpdate {
+		t.Helper()
+		select {
+		case u := <-ch:
+			return u
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for address update")
+			return pstore.AddrUpdate{}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	all := ps.WatchAddrs(ctx, p)
+	public := ps.WatchAddrs(ctx, p, pstore.PublicAddrs)
+	certified := ps.WatchAddrs(ctx, p, pstore.PublicAddrs, pstore.CertifiedAddrs)
+
+	// snapshots
+	u := next(all)
+	require.True(t, u.Snapshot)
+	require.ElementsMatch(t, []ma.Multiaddr{public1, private}, u.Added)
+	require.Equal(t, pstore.AddrUpdate{Snapshot: true, Added: []ma.Multiaddr{public1}}, next(public))
+	require.Equal(t, pstore.AddrUpdate{Snapshot: true, Added: []ma.Multiaddr{}}, next(certified))
+
+	// changes that happen before an update is received are batched
+	ps.AddAddr(p, public2, time.Hour)
+	ps.SetAddr(p, private, 0)
+	require.Equal(t, pstore.AddrUpdate{Added: []ma.Multiaddr{public2}, Removed: []ma.Multiaddr{private}}, next(all))
+	require.Equal(t, pstore.AddrUpdate{Added: []ma.Multiaddr{public2}}, next(public))
+
+	// only addresses in the signed peer record are certified
+	env, err := record.Seal(peer.PeerRecordFromAddrInfo(peer.AddrInfo{ID: p, Addrs: []ma.Multiaddr{public1, private}}), priv)
+	require.NoError(t, err)
+	accepted, err := ps.ConsumePeerRecord(env, time.Hour)
+	require.NoError(t, err)
+	require.True(t, accepted)
+	require.Equal(t, pstore.AddrUpdate{Added: []ma.Multiaddr{private}}, next(all))
+	require.Equal(t, pstore.AddrUpdate{Added: []ma.Multiaddr{public1}}, next(certified))
+
+	ps.ClearAddrs(p)
+	u = next(all)
+	require.Empty(t, u.Added)
+	require.ElementsMatch(t, []ma.Multiaddr{public1, public2, private}, u.Removed)
+	u = next(certified)
+	require.Equal(t, []ma.Multiaddr{public1}, u.Removed)
+
+	// the channels are closed on cancellation
+	cancel()
+	for range all {
+	}
+	for range certified {
+	}
+
+	// and when the peerstore is closed
+	ch := ps.WatchAddrs(context.Background(), p)
+	require.True(t, next(ch).Snapshot)
+	require.NoError(t, ps.Close())
+	_, ok := <-ch
+	require.False(t, ok)
+}
+
 func TestInMemoryKeyBook(t *testing.T) {
 	pt.TestKeyBook(t, func() (pstore.KeyBook, func()) {
 		ps, err := NewPeerstore()
@@ -3,6 +3,7 @@ package pstoremem
 import (
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
@@ -18,7 +19,10 @@ type pstoremem struct {
 	*memoryPeerMetadata
 }
 
-var _ peerstore.Peerstore = &pstoremem{}
+var (
+	_ peerstore.Peerstore            = &pstoremem{}
+	_ peerstore.TransportLatencyBook = &pstoremem{}
+)
 
 type Option interface{}
 
@@ -39,7 +43,9 @@ func NewPeerstore(opts ...Option) (ps *pstoremem, err error) {
 		case ProtoBookOption:
 			protoBookOpts = append(protoBookOpts, o)
 		case AddrBookOption:
-			o(ab)
+			if err := o(ab); err != nil {
+				return nil, err
+			}
 		default:
 			return nil, fmt.Errorf("unexpected peer store option: %v", o)
 		}
@@ -112,3 +118,21 @@ func (ps *pstoremem) RemovePeer(p peer.ID) {
 	ps.memoryPeerMetadata.RemovePeer(p)
 	ps.Metrics.RemovePeer(p)
 }
+
+// RecordTransportLatency records a latency measurement on a connection using
+// transport.
+func (ps *pstoremem) RecordTransportLatency(p peer.ID, transport string, d time.Duration) {
+	if lb, ok := peerstore.GetTransportLatencyBook(ps.Metrics); ok {
+		lb.RecordTransportLatency(p, transport, d)
+		return
+	}
+	ps.Metrics.RecordLatency(p, d)
+}
+
+// LatencyStats returns the latency statistics of a peer per transport.
+func (ps *pstoremem) LatencyStats(p peer.ID) map[string]peerstore.LatencyStats {
+	if lb, ok := peerstore.GetTransportLatencyBook(ps.Metrics); ok {
+		return lb.LatencyStats(p)
+	}
+	return nil
+}
@@ -345,6 +345,40 @@ func testMetadata(ps pstore.Peerstore) func(t *testing.T) {
 			}
 		})
 
+		t.Run("typed accessors", func(t *testing.T) {
+			p := peer.ID("typed")
+			_, err := pstore.GetAgentVersion(ps, p)
+			require.ErrorIs(t, err, pstore.ErrNotFound)
+
+			require.NoError(t, pstore.PutAgentVersion(ps, p, "agent"))
+			require.NoError(t, pstore.PutProtocolVersion(ps, p, "proto"))
+			now := time.Now()
+			require.NoError(t, pstore.PutLastHandshake(ps, p, now))
+			av, err := pstore.GetAgentVersion(ps, p)
+			require.NoError(t, err)
+			require.Equal(t, "agent", av)
+			pv, err := pstore.GetProtocolVersion(ps, p)
+			require.NoError(t, err)
+			require.Equal(t, "proto", pv)
+			lh, err := pstore.GetLastHandshake(ps, p)
+			require.NoError(t, err)
+			require.True(t, now.Equal(lh))
+
+			_, err = pstore.GetLatencyEWMA(ps, p)
+			require.ErrorIs(t, err, pstore.ErrNotFound)
+			ps.RecordLatency(p, time.Second)
+			l, err := pstore.GetLatencyEWMA(ps, p)
+			require.NoError(t, err)
+			require.Equal(t, time.Second, l)
+
+			require.NoError(t, pstore.Put(ps, p, "bar", 42))
+			v, err := pstore.Get[int](ps, p, "bar")
+			require.NoError(t, err)
+			require.Equal(t, 42, v)
+			_, err = pstore.Get[string](ps, p, "bar")
+			require.Error(t, err)
+		})
+
 		t.Run("removing a peer", func(t *testing.T) {
 			p := peer.ID("foo")
 			otherP := peer.ID("foobar")
@@ -405,3 +439,20 @@ func TestPeerstoreProtoStoreLimits(t *testing.T, ps pstore.Peerstore, limit int)
 		require.EqualError(t, ps.AddProtocols(p, "proto"), "too many protocols")
 	})
 }
+
+func TestPeerstoreAddrBookLimits(t *testing.T, ps pstore.Peerstore, limit int) {
+	p := peer.ID("foobar")
+	addrs := GenerateAddrs(limit + 2)
+	for i, a := range addrs[:limit] {
+		ps.AddAddr(p, a, time.Duration(i+1)*time.Hour)
+	}
+	require.Len(t, ps.Addrs(p), limit)
+
+	// the address that expires first is evicted
+	ps.AddAddr(p, addrs[limit], time.Duration(limit+1)*time.Hour)
+	AssertAddressesEqual(t, addrs[1:limit+1], ps.Addrs(p))
+
+	// an address that would be evicted right away isn't stored
+	ps.AddAddr(p, addrs[limit+1], time.Minute)
+	AssertAddressesEqual(t, addrs[1:limit+1], ps.Addrs(p))
+}
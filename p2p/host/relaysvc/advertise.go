@@ -0,0 +1,93 @@
+package relaysvc
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/discovery"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/p2p/discovery/util"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/proto"
+)
+
+// Advertiser advertises the host under proto.RelayNamespace while it runs a
+// relay, i.e. while it handles the circuit v2 hop protocol, so that other
+// peers can discover it as a relay candidate, see
+// autorelay.NewRoutingPeerSource.
+type Advertiser struct {
+	host host.Host
+	disc discovery.Advertiser
+
+	sub       event.Subscription
+	refCount  sync.WaitGroup
+	ctxCancel context.CancelFunc
+}
+
+// NewAdvertiser creates an Advertiser, advertising the host through d.
+func NewAdvertiser(h host.Host, d discovery.Advertiser) (*Advertiser, error) {
+	sub, err := h.EventBus().Subscribe(new(event.EvtLocalProtocolsUpdated), eventbus.Name("relaysvc-advertiser"))
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &Advertiser{
+		host:      h,
+		disc:      d,
+		sub:       sub,
+		ctxCancel: cancel,
+	}
+	a.refCount.Add(1)
+	go a.background(ctx)
+	return a, nil
+}
+
+func (a *Advertiser) background(ctx context.Context) {
+	defer a.refCount.Done()
+
+	// stop is set while advertising
+	var stop context.CancelFunc
+	defer func() {
+		if stop != nil {
+			stop()
+		}
+	}()
+	update := func(running bool) {
+		switch {
+		case running && stop == nil:
+			var advCtx context.Context
+			advCtx, stop = context.WithCancel(ctx)
+			util.Advertise(advCtx, a.disc, proto.RelayNamespace)
+		case !running && stop != nil:
+			stop()
+			stop = nil
+		}
+	}
+
+	update(slices.Contains(a.host.Mux().Protocols(), proto.ProtoIDv2Hop))
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-a.sub.Out():
+			if !ok {
+				return
+			}
+			evt := ev.(event.EvtLocalProtocolsUpdated)
+			if slices.Contains(evt.Added, proto.ProtoIDv2Hop) {
+				update(true)
+			} else if slices.Contains(evt.Removed, proto.ProtoIDv2Hop) {
+				update(false)
+			}
+		}
+	}
+}
+
+// Close stops advertising the host.
+func (a *Advertiser) Close() error {
+	a.ctxCancel()
+	a.refCount.Wait()
+	return a.sub.Close()
+}
@@ -0,0 +1,65 @@
+package relaysvc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/discovery"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/blank"
+	swarmt "github.com/libp2p/go-libp2p/p2p/net/swarm/testing"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/proto"
+	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	"github.com/stretchr/testify/require"
+)
+
+// mockAdvertiser records the active advertisements, i.e. the ones whose
+// context wasn't canceled.
+type mockAdvertiser struct {
+	mu     sync.Mutex
+	active map[string]int
+}
+
+func (a *mockAdvertiser) Advertise(ctx context.Context, ns string, _ ...discovery.Option) (time.Duration, error) {
+	a.mu.Lock()
+	a.active[ns]++
+	a.mu.Unlock()
+	context.AfterFunc(ctx, func() {
+		a.mu.Lock()
+		a.active[ns]--
+		a.mu.Unlock()
+	})
+	return time.Hour, nil
+}
+
+func (a *mockAdvertiser) isActive(ns string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.active[ns] > 0
+}
+
+func TestAdvertiser(t *testing.T) {
+	h := bhost.NewBlankHost(swarmt.GenSwarm(t))
+	defer h.Close()
+	disc := &mockAdvertiser{active: make(map[string]int)}
+	a, err := NewAdvertiser(h, disc)
+	require.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+	require.False(t, disc.isActive(proto.RelayNamespace), "not a relay yet")
+
+	relay, err := relayv2.New(h)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return disc.isActive(proto.RelayNamespace) }, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, relay.Close())
+	require.Eventually(t, func() bool { return !disc.isActive(proto.RelayNamespace) }, time.Second, 10*time.Millisecond)
+
+	relay, err = relayv2.New(h)
+	require.NoError(t, err)
+	defer relay.Close()
+	require.Eventually(t, func() bool { return disc.isActive(proto.RelayNamespace) }, time.Second, 10*time.Millisecond)
+	require.NoError(t, a.Close())
+	require.Eventually(t, func() bool { return !disc.isActive(proto.RelayNamespace) }, time.Second, 10*time.Millisecond)
+}
@@ -214,3 +214,12 @@ func (al *Allowlist) AllowedPeerAndMultiaddr(peerID peer.ID, ma multiaddr.Multia
 
 	return false
 }
+
+// AllowedPeer returns true if the allowlist has entries for the peer, i.e.
+// entries added with a `/p2p` protocol.
+func (al *Allowlist) AllowedPeer(peerID peer.ID) bool {
+	al.mu.RLock()
+	defer al.mu.RUnlock()
+
+	return len(al.allowedPeerByNetwork[peerID]) > 0
+}
@@ -286,3 +286,30 @@ func BenchmarkAllowlistCheck(b *testing.B) {
 		}
 	}
 }
+
+func TestAllowedPeer(t *testing.T) {
+	peerA := test.RandPeerIDFatal(t)
+	peerB := test.RandPeerIDFatal(t)
+	maA := multiaddr.StringCast("/ip4/1.2.3.4/p2p/" + peerA.String())
+
+	allowlist := newAllowlist()
+	if err := allowlist.Add(maA); err != nil {
+		t.Fatalf("failed to add ip4: %s", err)
+	}
+	if err := allowlist.Add(multiaddr.StringCast("/ip4/1.2.3.5")); err != nil {
+		t.Fatalf("failed to add ip4: %s", err)
+	}
+
+	if !allowlist.AllowedPeer(peerA) {
+		t.Fatalf("peer should be allowed")
+	}
+	if allowlist.AllowedPeer(peerB) {
+		t.Fatalf("peer should not be allowed")
+	}
+
+	allowlist.Remove(maA)
+
+	if allowlist.AllowedPeer(peerA) {
+		t.Fatalf("peer should not be allowed")
+	}
+}
@@ -23,6 +23,8 @@ type resourceManager struct {
 	trace          *trace
 	metrics        *metrics
 	disableMetrics bool
+	// disabledMetricsLabels are passed to the default StatsTraceReporter
+	disabledMetricsLabels []string
 
 	allowlist *Allowlist
 
@@ -44,7 +46,15 @@ type resourceManager struct {
 	stickyProto map[protocol.ID]struct{}
 	stickyPeer  map[peer.ID]struct{}
 
+	// open connection and stream scopes, for ScopeTree
+	conns   map[*connectionScope]struct{}
+	streams map[*streamScope]struct{}
+
 	connId, streamId int64
+
+	// bandwidthConnMemory are the memory limits of the connections dialed
+	// with an intent, per bandwidth class, see WithConnMemoryByBandwidth.
+	bandwidthConnMemory map[network.BandwidthClass]int64
 }
 
 var _ network.ResourceManager = (*resourceManager)(nil)
@@ -107,6 +117,7 @@ type connectionScope struct {
 
 var _ network.ConnScope = (*connectionScope)(nil)
 var _ network.ConnManagementScope = (*connectionScope)(nil)
+var _ network.IntentScope = (*connectionScope)(nil)
 
 type streamScope struct {
 	*resourceScope
@@ -126,6 +137,20 @@ var _ network.StreamManagementScope = (*streamScope)(nil)
 
 type Option func(*resourceManager) error
 
+// WithConnMemoryByBandwidth sets the memory limit of the outbound connections
+// dialed with the given bandwidth class, see network.WithIntent.
+// By default, connections dialed for low bandwidth are limited to a quarter of
+// the connection memory limit, and the others use the connection limit.
+func WithConnMemoryByBandwidth(class network.BandwidthClass, memory int64) Option {
+	return func(r *resourceManager) error {
+		if r.bandwidthConnMemory == nil {
+			r.bandwidthConnMemory = make(map[network.BandwidthClass]int64)
+		}
+		r.bandwidthConnMemory[class] = memory
+		return nil
+	}
+}
+
 func NewResourceManager(limits Limiter, opts ...Option) (network.ResourceManager, error) {
 	allowlist := newAllowlist()
 	r := &resourceManager{
@@ -134,6 +159,8 @@ func NewResourceManager(limits Limiter, opts ...Option) (network.ResourceManager
 		svc:       make(map[string]*serviceScope),
 		proto:     make(map[protocol.ID]*protocolScope),
 		peer:      make(map[peer.ID]*peerScope),
+		conns:     make(map[*connectionScope]struct{}),
+		streams:   make(map[*streamScope]struct{}),
 	}
 
 	for _, opt := range opts {
@@ -144,7 +171,7 @@ func NewResourceManager(limits Limiter, opts ...Option) (network.ResourceManager
 
 	if !r.disableMetrics {
 		var sr TraceReporter
-		sr, err := NewStatsTraceReporter()
+		sr, err := NewStatsTraceReporter(WithDisabledLabels(r.disabledMetricsLabels...))
 		if err != nil {
 			log.Errorf("failed to initialise StatsTraceReporter %s", err)
 		} else {
@@ -153,7 +180,7 @@ func NewResourceManager(limits Limiter, opts ...Option) (network.ResourceManager
 			}
 			found := false
 			for _, rep := range r.trace.reporters {
-				if rep == sr {
+				if _, ok := rep.(StatsTraceReporter); ok {
 					found = true
 					break
 				}
@@ -333,6 +360,9 @@ func (r *resourceManager) OpenConnection(dir network.Direction, usefd bool, endp
 	}
 
 	r.metrics.AllowConn(dir, usefd)
+	r.mx.Lock()
+	r.conns[conn] = struct{}{}
+	r.mx.Unlock()
 	return conn, nil
 }
 
@@ -349,6 +379,9 @@ func (r *resourceManager) OpenStream(p peer.ID, dir network.Direction) (network.
 	}
 
 	r.metrics.AllowStream(p, dir)
+	r.mx.Lock()
+	r.streams[stream] = struct{}{}
+	r.mx.Unlock()
 	return stream, nil
 }
 
@@ -643,6 +676,39 @@ func (s *connectionScope) PeerScope() network.PeerScope {
 	return s.peer
 }
 
+// SetIntent sizes the memory limit of the connection according to the
+// bandwidth class of the intent, see WithConnMemoryByBandwidth.
+func (s *connectionScope) SetIntent(intent network.Intent) {
+	s.Lock()
+	defer s.Unlock()
+
+	memory, ok := s.rcmgr.bandwidthConnMemory[intent.Bandwidth]
+	if !ok {
+		if intent.Bandwidth != network.BandwidthLow {
+			return
+		}
+		memory = s.rc.limit.GetMemoryLimit() / 4
+	}
+	s.rc.limit = &memoryLimit{Limit: s.rc.limit, memory: memory}
+}
+
+// memoryLimit overrides the memory limit of a Limit.
+type memoryLimit struct {
+	Limit
+	memory int64
+}
+
+func (l *memoryLimit) GetMemoryLimit() int64 {
+	return l.memory
+}
+
+func (s *connectionScope) Done() {
+	s.rcmgr.mx.Lock()
+	delete(s.rcmgr.conns, s)
+	s.rcmgr.mx.Unlock()
+	s.resourceScope.Done()
+}
+
 // transferAllowedToStandard transfers this connection scope from being part of
 // the allowlist set of scopes to being part of the standard set of scopes.
 // Happens when we first allowlisted this connection due to its IP, but later
@@ -746,6 +812,13 @@ func (s *connectionScope) SetPeer(p peer.ID) error {
 	return nil
 }
 
+func (s *streamScope) Done() {
+	s.rcmgr.mx.Lock()
+	delete(s.rcmgr.streams, s)
+	s.rcmgr.mx.Unlock()
+	s.resourceScope.Done()
+}
+
 func (s *streamScope) ProtocolScope() network.ProtocolScope {
 	s.Lock()
 	defer s.Unlock()
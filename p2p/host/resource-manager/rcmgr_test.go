@@ -1051,3 +1051,38 @@ func TestResourceManagerWithAllowlist(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestResourceManagerConnIntent(t *testing.T) {
+	limits := DefaultLimits.AutoScale()
+	limits.conn.Memory = 4 << 20
+
+	rcmgr, err := NewResourceManager(NewFixedLimiter(limits), WithConnMemoryByBandwidth(network.BandwidthHigh, 8<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rcmgr.Close()
+
+	reserve := func(bw network.BandwidthClass, size int) error {
+		connScope, err := rcmgr.OpenConnection(network.DirOutbound, false, dummyMA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer connScope.Done()
+		connScope.(network.IntentScope).SetIntent(network.Intent{Bandwidth: bw})
+		return connScope.ReserveMemory(size, network.ReservationPriorityAlways)
+	}
+
+	if err := reserve(network.BandwidthUnknown, 3<<20); err != nil {
+		t.Fatal(err)
+	}
+	// low bandwidth connections get a quarter of the connection limit by default
+	if err := reserve(network.BandwidthLow, 3<<20); err == nil {
+		t.Fatal("expected the reservation to fail")
+	}
+	if err := reserve(network.BandwidthLow, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	if err := reserve(network.BandwidthHigh, 6<<20); err != nil {
+		t.Fatal(err)
+	}
+}
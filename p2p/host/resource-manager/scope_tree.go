@@ -0,0 +1,261 @@
+package rcmgr
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ScopeNode is a resource scope in a ScopeTree, with its current usage and
+// its limit.
+type ScopeNode struct {
+	// Name is the name of the scope, e.g. "system", "service:identify",
+	// "peer:12D3KooW..." or "conn-12".
+	Name     string
+	Stat     network.ScopeStat
+	Limit    BaseLimit
+	Children []ScopeNode `json:",omitempty"`
+}
+
+// ScopeTree is a snapshot of the scopes of the resource manager. Scopes
+// account for their usage in several parent scopes, e.g. a stream in its
+// peer, protocol and service scopes; the tree places every scope below a
+// single parent:
+//
+//	system
+//	├── transient
+//	│   └── connections not yet attached to a peer
+//	├── service:<name>
+//	│   └── service:<name>.peer:<peer>
+//	├── protocol:<id>
+//	│   └── protocol:<id>.peer:<peer>
+//	└── peer:<peer>
+//	    ├── conn-<n>
+//	    └── stream-<n>
+//
+// Connections of allowlisted peers are accounted for in a second root,
+// allowlistedSystem, until they are attached to their peer.
+type ScopeTree struct {
+	Roots []ScopeNode
+}
+
+// ScopeTreeExporter is implemented by the resource manager returned by
+// NewResourceManager.
+type ScopeTreeExporter interface {
+	ScopeTree() ScopeTree
+}
+
+var _ ScopeTreeExporter = (*resourceManager)(nil)
+
+func (r *resourceManager) ScopeTree() ScopeTree {
+	r.mx.Lock()
+	svcs := make([]*serviceScope, 0, len(r.svc))
+	for _, svc := range r.svc {
+		svcs = append(svcs, svc)
+	}
+	protos := make([]*protocolScope, 0, len(r.proto))
+	for _, proto := range r.proto {
+		protos = append(protos, proto)
+	}
+	peers := make([]*peerScope, 0, len(r.peer))
+	for _, peer := range r.peer {
+		peers = append(peers, peer)
+	}
+	conns := make([]*connectionScope, 0, len(r.conns))
+	for conn := range r.conns {
+		conns = append(conns, conn)
+	}
+	streams := make([]*streamScope, 0, len(r.streams))
+	for stream := range r.streams {
+		streams = append(streams, stream)
+	}
+	r.mx.Unlock()
+
+	// Like Stat, this is not an atomic snapshot: usage changes while we walk
+	// the scopes.
+	peerChildren := make(map[*peerScope][]ScopeNode)
+	var transientChildren, allowlistedTransientChildren []ScopeNode
+	for _, conn := range conns {
+		node := scopeNode(conn.resourceScope, nil)
+		conn.Lock()
+		peer, allowlisted := conn.peer, conn.isAllowlisted
+		conn.Unlock()
+		switch {
+		case peer != nil:
+			peerChildren[peer] = append(peerChildren[peer], node)
+		case allowlisted:
+			allowlistedTransientChildren = append(allowlistedTransientChildren, node)
+		default:
+			transientChildren = append(transientChildren, node)
+		}
+	}
+	for _, stream := range streams {
+		peerChildren[stream.peer] = append(peerChildren[stream.peer], scopeNode(stream.resourceScope, nil))
+	}
+
+	system := scopeNode(r.system.resourceScope, nil)
+	system.Children = append(system.Children, scopeNode(r.transient.resourceScope, transientChildren))
+	for _, svc := range svcs {
+		system.Children = append(system.Children, scopeNode(svc.resourceScope, peerScopeNodes(svc.resourceScope, svc.peers)))
+	}
+	for _, proto := range protos {
+		system.Children = append(system.Children, scopeNode(proto.resourceScope, peerScopeNodes(proto.resourceScope, proto.peers)))
+	}
+	for _, peer := range peers {
+		system.Children = append(system.Children, scopeNode(peer.resourceScope, peerChildren[peer]))
+	}
+
+	allowlisted := scopeNode(r.allowlistedSystem.resourceScope, []ScopeNode{
+		scopeNode(r.allowlistedTransient.resourceScope, allowlistedTransientChildren),
+	})
+
+	return ScopeTree{Roots: []ScopeNode{system, allowlisted}}
+}
+
+// peerScopeNodes returns the nodes of the per-peer scopes of a service or
+// protocol scope s, guarded by the lock of s.
+func peerScopeNodes(s *resourceScope, peers map[peer.ID]*resourceScope) []ScopeNode {
+	s.Lock()
+	scopes := make([]*resourceScope, 0, len(peers))
+	for _, ps := range peers {
+		scopes = append(scopes, ps)
+	}
+	s.Unlock()
+
+	nodes := make([]ScopeNode, 0, len(scopes))
+	for _, ps := range scopes {
+		nodes = append(nodes, scopeNode(ps, nil))
+	}
+	return nodes
+}
+
+func scopeNode(s *resourceScope, children []ScopeNode) ScopeNode {
+	s.Lock()
+	node := ScopeNode{
+		Name:  s.name,
+		Stat:  s.rc.stat(),
+		Limit: (*ResourceLimits)(nil).Build(s.rc.limit),
+	}
+	s.Unlock()
+
+	sort.Slice(children, func(i, j int) bool { return children[i].Name < children[j].Name })
+	node.Children = children
+	return node
+}
+
+// WriteJSON writes the tree as JSON.
+func (t ScopeTree) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(t)
+}
+
+// WriteDOT writes the tree as a Graphviz DOT graph. Every node shows the usage
+// and the limit of its scope, and is colored by its highest utilization: green
+// below 50%, yellow below 90%, and red above.
+func (t ScopeTree) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph rcmgr {")
+	fmt.Fprintln(bw, "\tnode [shape=box, style=filled, fontname=monospace];")
+	var n int
+	var write func(node ScopeNode) string
+	write = func(node ScopeNode) string {
+		id := fmt.Sprintf("n%d", n)
+		n++
+		fmt.Fprintf(bw, "\t%s [label=%q, fillcolor=%q];\n", id, dotLabel(node), dotColor(node))
+		for _, c := range node.Children {
+			fmt.Fprintf(bw, "\t%s -> %s;\n", id, write(c))
+		}
+		return id
+	}
+	for _, root := range t.Roots {
+		write(root)
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+func dotLabel(node ScopeNode) string {
+	st, l := node.Stat, node.Limit
+	return fmt.Sprintf("%s\nconns: %d/%s (in %d/%s, out %d/%s)\nstreams: %d/%s (in %d/%s, out %d/%s)\nfd: %d/%s\nmemory: %d/%s",
+		node.Name,
+		st.NumConnsInbound+st.NumConnsOutbound, limitString(l.Conns),
+		st.NumConnsInbound, limitString(l.ConnsInbound),
+		st.NumConnsOutbound, limitString(l.ConnsOutbound),
+		st.NumStreamsInbound+st.NumStreamsOutbound, limitString(l.Streams),
+		st.NumStreamsInbound, limitString(l.StreamsInbound),
+		st.NumStreamsOutbound, limitString(l.StreamsOutbound),
+		st.NumFD, limitString(l.FD),
+		st.Memory, limitString64(l.Memory),
+	)
+}
+
+func limitString(l int) string {
+	if l == math.MaxInt {
+		return "unlimited"
+	}
+	return fmt.Sprint(l)
+}
+
+func limitString64(l int64) string {
+	if l == math.MaxInt64 {
+		return "unlimited"
+	}
+	return fmt.Sprint(l)
+}
+
+func dotColor(node ScopeNode) string {
+	st, l := node.Stat, node.Limit
+	u := max(
+		utilization(int64(st.NumConnsInbound+st.NumConnsOutbound), int64(l.Conns)),
+		utilization(int64(st.NumStreamsInbound+st.NumStreamsOutbound), int64(l.Streams)),
+		utilization(int64(st.NumFD), int64(l.FD)),
+		utilization(st.Memory, l.Memory),
+	)
+	switch {
+	case u >= 0.9:
+		return "lightcoral"
+	case u >= 0.5:
+		return "khaki"
+	default:
+		return "palegreen"
+	}
+}
+
+func utilization(used, limit int64) float64 {
+	if limit <= 0 {
+		if used > 0 {
+			return 1
+		}
+		return 0
+	}
+	return float64(used) / float64(limit)
+}
+
+// NewScopeTreeHandler returns an http.Handler serving the scope tree of e as
+// JSON, or as a Graphviz DOT graph if the format query parameter is "dot".
+func NewScopeTreeHandler(e ScopeTreeExporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tree := e.ScopeTree()
+		var err error
+		switch r.URL.Query().Get("format") {
+		case "", "json":
+			w.Header().Set("Content-Type", "application/json")
+			err = tree.WriteJSON(w)
+		case "dot":
+			w.Header().Set("Content-Type", "text/vnd.graphviz")
+			err = tree.WriteDOT(w)
+		default:
+			http.Error(w, "invalid format", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Debugw("failed to write scope tree", "error", err)
+		}
+	})
+}
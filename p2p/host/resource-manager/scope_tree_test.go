@@ -0,0 +1,108 @@
+package rcmgr
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/stretchr/testify/require"
+)
+
+func findScopeNode(nodes []ScopeNode, name string) *ScopeNode {
+	for i := range nodes {
+		if nodes[i].Name == name {
+			return &nodes[i]
+		}
+		if n := findScopeNode(nodes[i].Children, name); n != nil {
+			return n
+		}
+	}
+	return nil
+}
+
+func TestScopeTree(t *testing.T) {
+	limits := DefaultLimits.AutoScale()
+	mgr, err := NewResourceManager(NewFixedLimiter(limits), WithMetricsDisabled())
+	require.NoError(t, err)
+	defer mgr.Close()
+	e := mgr.(ScopeTreeExporter)
+
+	p := peer.ID("A")
+	pending, err := mgr.OpenConnection(network.DirInbound, true, dummyMA)
+	require.NoError(t, err)
+	defer pending.Done()
+	conn, err := mgr.OpenConnection(network.DirOutbound, true, dummyMA)
+	require.NoError(t, err)
+	require.NoError(t, conn.SetPeer(p))
+	stream, err := mgr.OpenStream(p, network.DirOutbound)
+	require.NoError(t, err)
+	require.NoError(t, stream.SetProtocol("/test"))
+	require.NoError(t, stream.SetService("test.svc"))
+	require.NoError(t, stream.ReserveMemory(1024, network.ReservationPriorityAlways))
+
+	tree := e.ScopeTree()
+	require.Len(t, tree.Roots, 2)
+	require.Equal(t, "system", tree.Roots[0].Name)
+	require.Equal(t, "allowlistedSystem", tree.Roots[1].Name)
+
+	transient := findScopeNode(tree.Roots[0].Children, "transient")
+	require.NotNil(t, transient)
+	require.Len(t, transient.Children, 1)
+	require.Equal(t, 1, transient.Children[0].Stat.NumConnsInbound)
+
+	peerNode := findScopeNode(tree.Roots[0].Children, peerScopeName(p))
+	require.NotNil(t, peerNode)
+	require.Equal(t, 1, peerNode.Stat.NumConnsOutbound)
+	require.Equal(t, 1, peerNode.Stat.NumStreamsOutbound)
+	require.Equal(t, int64(1024), peerNode.Stat.Memory)
+	require.Equal(t, NewFixedLimiter(limits).GetPeerLimits(p).GetConnTotalLimit(), peerNode.Limit.Conns)
+	require.Len(t, peerNode.Children, 2)
+
+	svc := findScopeNode(tree.Roots[0].Children, "service:test.svc")
+	require.NotNil(t, svc)
+	require.Equal(t, int64(1024), svc.Stat.Memory)
+	require.NotNil(t, findScopeNode(svc.Children, "service:test.svc.peer:"+p.String()))
+	require.NotNil(t, findScopeNode(tree.Roots[0].Children, "protocol:/test"))
+
+	var dot bytes.Buffer
+	require.NoError(t, tree.WriteDOT(&dot))
+	require.True(t, strings.HasPrefix(dot.String(), "digraph rcmgr {"))
+	require.Contains(t, dot.String(), "service:test.svc")
+
+	// Closed scopes are removed from the tree.
+	stream.Done()
+	conn.Done()
+	peerNode = findScopeNode(e.ScopeTree().Roots, peerScopeName(p))
+	require.NotNil(t, peerNode)
+	require.Empty(t, peerNode.Children)
+}
+
+func TestScopeTreeHandler(t *testing.T) {
+	mgr, err := NewResourceManager(NewFixedLimiter(DefaultLimits.AutoScale()), WithMetricsDisabled())
+	require.NoError(t, err)
+	defer mgr.Close()
+	h := NewScopeTreeHandler(mgr.(ScopeTreeExporter))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var tree ScopeTree
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &tree))
+	require.Len(t, tree.Roots, 2)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?format=dot", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "text/vnd.graphviz", rec.Header().Get("Content-Type"))
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?format=svg", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -167,12 +167,44 @@ func WithMetricsDisabled() Option {
 	}
 }
 
+// WithDisabledMetricsLabels drops the given labels from the metrics of the
+// default StatsTraceReporter, see WithDisabledLabels.
+func WithDisabledMetricsLabels(labels ...string) Option {
+	return func(r *resourceManager) error {
+		r.disabledMetricsLabels = append(r.disabledMetricsLabels, labels...)
+		return nil
+	}
+}
+
 // StatsTraceReporter reports stats on the resource manager using its traces.
-type StatsTraceReporter struct{}
+type StatsTraceReporter struct {
+	// disableProtocolMetrics skips the per-protocol series
+	disableProtocolMetrics bool
+}
 
-func NewStatsTraceReporter() (StatsTraceReporter, error) {
+type StatsTraceReporterOption func(*StatsTraceReporter)
+
+// WithDisabledLabels drops the given labels from the metrics, to limit their
+// cardinality. Only the "protocol" label is supported: since the per-protocol
+// metrics are gauges, which can't be merged, they're not recorded at all.
+// Other labels are ignored.
+func WithDisabledLabels(labels ...string) StatsTraceReporterOption {
+	return func(r *StatsTraceReporter) {
+		for _, l := range labels {
+			if l == "protocol" {
+				r.disableProtocolMetrics = true
+			}
+		}
+	}
+}
+
+func NewStatsTraceReporter(opts ...StatsTraceReporterOption) (StatsTraceReporter, error) {
 	// TODO tell prometheus the system limits
-	return StatsTraceReporter{}, nil
+	var r StatsTraceReporter
+	for _, opt := range opts {
+		opt(&r)
+	}
+	return r, nil
 }
 
 func (r StatsTraceReporter) ConsumeEvent(evt TraceEvt) {
@@ -220,7 +252,7 @@ func (r StatsTraceReporter) consumeEventWithLabelSlice(evt TraceEvt, tags *[]str
 					*tags = (*tags)[:0]
 					*tags = append(*tags, "outbound", evt.Name, "")
 					streams.WithLabelValues(*tags...).Set(float64(evt.StreamsOut))
-				} else if proto := ParseProtocolScopeName(evt.Name); proto != "" {
+				} else if proto := ParseProtocolScopeName(evt.Name); proto != "" && !r.disableProtocolMetrics {
 					*tags = (*tags)[:0]
 					*tags = append(*tags, "outbound", "protocol", proto)
 					streams.WithLabelValues(*tags...).Set(float64(evt.StreamsOut))
@@ -237,7 +269,7 @@ func (r StatsTraceReporter) consumeEventWithLabelSlice(evt TraceEvt, tags *[]str
 					*tags = (*tags)[:0]
 					*tags = append(*tags, "inbound", evt.Name, "")
 					streams.WithLabelValues(*tags...).Set(float64(evt.StreamsIn))
-				} else if proto := ParseProtocolScopeName(evt.Name); proto != "" {
+				} else if proto := ParseProtocolScopeName(evt.Name); proto != "" && !r.disableProtocolMetrics {
 					*tags = (*tags)[:0]
 					*tags = append(*tags, "inbound", "protocol", proto)
 					streams.WithLabelValues(*tags...).Set(float64(evt.StreamsIn))
@@ -329,7 +361,7 @@ func (r StatsTraceReporter) consumeEventWithLabelSlice(evt TraceEvt, tags *[]str
 				*tags = (*tags)[:0]
 				*tags = append(*tags, evt.Name, "")
 				memoryTotal.WithLabelValues(*tags...).Set(float64(evt.Memory))
-			} else if proto := ParseProtocolScopeName(evt.Name); proto != "" {
+			} else if proto := ParseProtocolScopeName(evt.Name); proto != "" && !r.disableProtocolMetrics {
 				*tags = (*tags)[:0]
 				*tags = append(*tags, "protocol", proto)
 				memoryTotal.WithLabelValues(*tags...).Set(float64(evt.Memory))
@@ -363,6 +395,9 @@ func (r StatsTraceReporter) consumeEventWithLabelSlice(evt TraceEvt, tags *[]str
 		if idSplitIdx != -1 {
 			scopeName = scopeName[0:idSplitIdx]
 		}
+		if r.disableProtocolMetrics && strings.HasPrefix(scopeName, "protocol:") {
+			scopeName = "protocol"
+		}
 
 		if evt.DeltaIn != 0 {
 			*tags = (*tags)[:0]
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
 )
 
 var registerOnce sync.Once
@@ -37,3 +38,39 @@ func TestConsumeEvent(t *testing.T) {
 
 	str.ConsumeEvent(evt)
 }
+
+func TestConsumeEventDisabledProtocolLabel(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	MustRegisterWith(reg)
+
+	hasProtocolSeries := func(proto string) bool {
+		mfs, err := reg.Gather()
+		require.NoError(t, err)
+		for _, mf := range mfs {
+			for _, m := range mf.GetMetric() {
+				for _, l := range m.GetLabel() {
+					if l.GetName() == "protocol" && l.GetValue() == proto {
+						return true
+					}
+				}
+			}
+		}
+		return false
+	}
+
+	evt := TraceEvt{
+		Type:       TraceAddStreamEvt,
+		Name:       "protocol:/disabled-label-test",
+		DeltaOut:   1,
+		StreamsOut: 1,
+	}
+	str, err := NewStatsTraceReporter(WithDisabledLabels("protocol"))
+	require.NoError(t, err)
+	str.ConsumeEvent(evt)
+	require.False(t, hasProtocolSeries("/disabled-label-test"))
+
+	str, err = NewStatsTraceReporter()
+	require.NoError(t, err)
+	str.ConsumeEvent(evt)
+	require.True(t, hasProtocolSeries("/disabled-label-test"))
+}
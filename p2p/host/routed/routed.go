@@ -12,6 +12,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/host/addrcheck"
 
 	logging "github.com/ipfs/go-log/v2"
 
@@ -53,10 +54,32 @@ func (rh *RoutedHost) Connect(ctx context.Context, pi peer.AddrInfo) error {
 			return nil
 		}
 	}
+	return rh.connect(ctx, pi, func(pi peer.AddrInfo) error {
+		return rh.host.Connect(ctx, pi)
+	})
+}
+
+// ConnectWithOptions connects to the peer using the wrapped host, which must
+// implement host.OptionsConnector. Like Connect, it uses the routing system
+// to find addresses of the peer if the host doesn't have any.
+func (rh *RoutedHost) ConnectWithOptions(ctx context.Context, pi peer.AddrInfo, opts ...host.ConnectOption) (host.ConnectInfo, error) {
+	if _, ok := rh.host.(host.OptionsConnector); !ok {
+		return host.ConnectInfo{}, host.ErrConnectOptionsNotSupported
+	}
+	var info host.ConnectInfo
+	err := rh.connect(ctx, pi, func(pi peer.AddrInfo) (err error) {
+		info, err = host.ConnectWithOptions(ctx, rh.host, pi, opts...)
+		return err
+	})
+	return info, err
+}
 
+// connect finds addresses of the peer if needed, and connects to it using
+// the wrapped host by calling dial.
+func (rh *RoutedHost) connect(ctx context.Context, pi peer.AddrInfo, dial func(peer.AddrInfo) error) error {
 	// if we were given some addresses, keep + use them.
 	if len(pi.Addrs) > 0 {
-		rh.Peerstore().AddAddrs(pi.ID, pi.Addrs, peerstore.TempAddrTTL)
+		peerstore.AddNewAddrsFromSource(rh.Peerstore(), pi.ID, addrcheck.Filter(addrcheck.BoundaryUser, pi.Addrs), peerstore.TempAddrTTL, peerstore.AddrSourceManual)
 	}
 
 	// Check if we have some addresses in our recent memory.
@@ -107,7 +130,7 @@ func (rh *RoutedHost) Connect(ctx context.Context, pi peer.AddrInfo) error {
 
 	// if we're here, we got some addrs. let's use our wrapped host to connect.
 	pi.Addrs = addrs
-	if cerr := rh.host.Connect(ctx, pi); cerr != nil {
+	if cerr := dial(pi); cerr != nil {
 		// We couldn't connect. Let's check if we have the most
 		// up-to-date addresses for the given peer. If there
 		// are addresses we didn't know about previously, we
@@ -133,7 +156,7 @@ func (rh *RoutedHost) Connect(ctx context.Context, pi peer.AddrInfo) error {
 			}
 
 			pi.Addrs = newAddrs
-			return rh.host.Connect(ctx, pi)
+			return dial(pi)
 		}
 		// No appropriate new address found.
 		// Return the original dial error.
@@ -158,6 +181,7 @@ func (rh *RoutedHost) findPeerAddrs(ctx context.Context, id peer.ID) ([]ma.Multi
 		return nil, err
 	}
 
+	peerstore.AddAddrsFromSource(rh.Peerstore(), id, pi.Addrs, peerstore.TempAddrTTL, peerstore.AddrSourceDHT)
 	return pi.Addrs, nil
 }
 
@@ -219,4 +243,75 @@ func (rh *RoutedHost) ConnManager() connmgr.ConnManager {
 	return rh.host.ConnManager()
 }
 
-var _ (host.Host) = (*RoutedHost)(nil)
+// SignalNetworkChange forwards the signal to the wrapped host, if it
+// implements host.NetworkChangeSignaler.
+func (rh *RoutedHost) SignalNetworkChange() {
+	if s, ok := rh.host.(host.NetworkChangeSignaler); ok {
+		s.SignalNetworkChange()
+	}
+}
+
+// SetPowerState forwards the power state to the wrapped host, if it
+// implements host.PowerStateSetter.
+func (rh *RoutedHost) SetPowerState(lowPower bool) {
+	if s, ok := rh.host.(host.PowerStateSetter); ok {
+		s.SetPowerState(lowPower)
+	}
+}
+
+// RegisterProtocols registers the handlers on the wrapped host, if it
+// implements host.ProtocolRegistrar.
+func (rh *RoutedHost) RegisterProtocols(handlers map[protocol.ID]network.StreamHandler) (host.ProtocolRegistration, error) {
+	return host.RegisterProtocols(rh.host, handlers)
+}
+
+// Health returns the health report of the wrapped host, if it implements
+// host.HealthReporter. Otherwise, the host is reported healthy.
+func (rh *RoutedHost) Health() host.HealthReport {
+	if r, ok := rh.host.(host.HealthReporter); ok {
+		return r.Health()
+	}
+	return host.HealthReport{Healthy: true}
+}
+
+// AddProtocolShim adds the shim to the wrapped host, if it implements
+// host.ProtocolShimRegistry.
+func (rh *RoutedHost) AddProtocolShim(shim host.ProtocolShim) error {
+	return host.AddProtocolShim(rh.host, shim)
+}
+
+// RemoveProtocolShim removes the shim from the wrapped host, if it implements
+// host.ProtocolShimRegistry.
+func (rh *RoutedHost) RemoveProtocolShim(from protocol.ID) {
+	if r, ok := rh.host.(host.ProtocolShimRegistry); ok {
+		r.RemoveProtocolShim(from)
+	}
+}
+
+// ProtocolShims returns the shims of the wrapped host, if it implements
+// host.ProtocolShimRegistry.
+func (rh *RoutedHost) ProtocolShims() map[protocol.ID]host.ProtocolShimStats {
+	if r, ok := rh.host.(host.ProtocolShimRegistry); ok {
+		return r.ProtocolShims()
+	}
+	return nil
+}
+
+// SetStreamOpenSLO sets the latency objective on the wrapped host, if it
+// implements host.StreamOpenSLOSetter.
+func (rh *RoutedHost) SetStreamOpenSLO(pid protocol.ID, slo host.StreamOpenSLO) {
+	if s, ok := rh.host.(host.StreamOpenSLOSetter); ok {
+		s.SetStreamOpenSLO(pid, slo)
+	}
+}
+
+var (
+	_ host.Host                  = (*RoutedHost)(nil)
+	_ host.NetworkChangeSignaler = (*RoutedHost)(nil)
+	_ host.PowerStateSetter      = (*RoutedHost)(nil)
+	_ host.ProtocolRegistrar     = (*RoutedHost)(nil)
+	_ host.HealthReporter        = (*RoutedHost)(nil)
+	_ host.OptionsConnector      = (*RoutedHost)(nil)
+	_ host.ProtocolShimRegistry  = (*RoutedHost)(nil)
+	_ host.StreamOpenSLOSetter   = (*RoutedHost)(nil)
+)
@@ -3,8 +3,13 @@ package routedhost
 import (
 	"context"
 	"testing"
+	"time"
 
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
 	basic "github.com/libp2p/go-libp2p/p2p/host/basic"
 	swarmt "github.com/libp2p/go-libp2p/p2p/net/swarm/testing"
 
@@ -89,3 +94,52 @@ func TestRoutedHostConnectFindPeerNoUsefulAddrs(t *testing.T) {
 	require.Error(t, rh.Connect(context.Background(), pi))
 	require.Equal(t, 1, mr.callCount, "the mocked FindPeer function should have been called")
 }
+
+func TestRoutedHostForwardsPowerState(t *testing.T) {
+	h, err := basic.NewHost(swarmt.GenSwarm(t), nil)
+	require.NoError(t, err)
+	defer h.Close()
+	h.Start()
+
+	sub, err := h.EventBus().Subscribe(new(event.EvtLocalPowerStateChanged))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	rh := Wrap(h, &mockRouting{})
+	rh.SetPowerState(true)
+	select {
+	case e := <-sub.Out():
+		require.True(t, e.(event.EvtLocalPowerStateChanged).LowPower)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a power state change event")
+	}
+}
+
+func TestRoutedHostForwardsStreamOpenSLO(t *testing.T) {
+	h1, err := basic.NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h1.Close()
+	h1.Start()
+	h2, err := basic.NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h2.Close()
+	h2.Start()
+
+	violations := make(chan host.StreamOpenSLOViolation, 1)
+	rh := Wrap(h2, &mockRouting{})
+	rh.SetStreamOpenSLO("/missing", host.StreamOpenSLO{
+		Latency:     time.Minute,
+		OnViolation: func(v host.StreamOpenSLOViolation) { violations <- v },
+	})
+
+	h2.Peerstore().AddAddrs(h1.ID(), h1.Addrs(), peerstore.PermanentAddrTTL)
+	_, err = rh.NewStream(context.Background(), h1.ID(), "/missing")
+	require.Error(t, err)
+	select {
+	case v := <-violations:
+		require.Equal(t, protocol.ID("/missing"), v.Protocol)
+		require.Equal(t, []peer.ID{h1.ID()}, v.Peers)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a violation of the objective")
+	}
+}
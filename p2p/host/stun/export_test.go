@@ -0,0 +1,3 @@
+package stun
+
+var RunServer = runServer
@@ -0,0 +1,33 @@
+package stun_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/p2p/host/stun"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHost(t *testing.T) {
+	mapped := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 4321}
+	h, err := libp2p.New(
+		libp2p.ListenAddrStrings("/ip4/0.0.0.0/udp/0/quic-v1"),
+		libp2p.EnableSTUN(stun.WithServers(stun.RunServer(t, mapped))),
+	)
+	require.NoError(t, err)
+	defer h.Close()
+
+	expected := ma.StringCast("/ip4/1.2.3.4/udp/4321/quic-v1")
+	require.Eventually(t, func() bool {
+		for _, a := range h.Addrs() {
+			if a.Equal(expected) {
+				return true
+			}
+		}
+		return false
+	}, 10*time.Second, 50*time.Millisecond)
+}
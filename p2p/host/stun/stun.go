@@ -0,0 +1,363 @@
+// Package stun discovers the external addresses of the host's UDP sockets
+// using STUN servers.
+//
+// For every socket the QUIC listeners listen on, the Service asks the STUN
+// servers which address they see the socket on. The result is advertised as
+// an external QUIC address of the host if all servers agree on the address
+// (i.e. the NAT uses an endpoint-independent mapping), and if it's consistent
+// with the addresses that other peers observed us on using identify. That way,
+// we learn our external address even when there are few peers that can
+// help us, e.g. directly after startup.
+//
+// The Service queries the servers when it starts, when the host's network
+// interfaces change, and regularly after that.
+package stun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	"github.com/libp2p/go-libp2p/p2p/transport/quicreuse"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	pionstun "github.com/pion/stun"
+	"github.com/quic-go/quic-go"
+)
+
+var log = logging.Logger("stun")
+
+// DefaultServers are the STUN servers used if no servers are configured.
+// They are run by different operators, so that they can be cross-checked.
+var DefaultServers = []string{
+	"stun.l.google.com:19302",
+	"stun.cloudflare.com:3478",
+}
+
+const (
+	defaultRefreshInterval = 30 * time.Minute
+	defaultQueryTimeout    = 5 * time.Second
+
+	// retransmitInterval is the interval at which STUN requests are retransmitted.
+	retransmitInterval = time.Second
+)
+
+type Option func(*Service) error
+
+// WithServers sets the STUN servers (as host:port) to query.
+func WithServers(servers ...string) Option {
+	return func(s *Service) error {
+		if len(servers) == 0 {
+			return errors.New("no STUN servers")
+		}
+		for _, srv := range servers {
+			if _, _, err := net.SplitHostPort(srv); err != nil {
+				return fmt.Errorf("invalid STUN server %q: %w", srv, err)
+			}
+		}
+		s.servers = servers
+		return nil
+	}
+}
+
+// WithRefreshInterval sets the interval at which the STUN servers are queried again.
+func WithRefreshInterval(d time.Duration) Option {
+	return func(s *Service) error {
+		if d <= 0 {
+			return errors.New("refresh interval must be positive")
+		}
+		s.refreshInterval = d
+		return nil
+	}
+}
+
+// WithQueryTimeout sets the timeout for querying a single STUN server.
+func WithQueryTimeout(d time.Duration) Option {
+	return func(s *Service) error {
+		if d <= 0 {
+			return errors.New("query timeout must be positive")
+		}
+		s.queryTimeout = d
+		return nil
+	}
+}
+
+// WithIdentify cross-checks the addresses discovered using STUN against the
+// addresses observed by identify: if other peers observe us on different
+// addresses, the STUN result is not used.
+func WithIdentify(ids identify.IDService) Option {
+	return func(s *Service) error {
+		s.ids = ids
+		return nil
+	}
+}
+
+type addrChangeSignaler interface {
+	SignalAddressChange()
+}
+
+// Service discovers the external addresses of the host's UDP sockets.
+type Service struct {
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+
+	host        host.Host
+	connManager *quicreuse.ConnManager
+	// ids is used to cross-check the STUN results, if set
+	ids identify.IDService
+
+	servers         []string
+	refreshInterval time.Duration
+	queryTimeout    time.Duration
+
+	mx    sync.Mutex
+	addrs []ma.Multiaddr
+
+	refCount sync.WaitGroup
+}
+
+// New creates a STUN service for the sockets of the QUIC listeners of the connection manager.
+func New(h host.Host, cm *quicreuse.ConnManager, opts ...Option) (*Service, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Service{
+		ctx:             ctx,
+		ctxCancel:       cancel,
+		host:            h,
+		connManager:     cm,
+		servers:         DefaultServers,
+		refreshInterval: defaultRefreshInterval,
+		queryTimeout:    defaultQueryTimeout,
+	}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Start starts discovering addresses.
+func (s *Service) Start() error {
+	sub, err := eventbus.SubscribeTyped[event.EvtLocalInterfaceAddrsChanged](s.host.EventBus(), eventbus.Name("stun"))
+	if err != nil {
+		return err
+	}
+	s.refCount.Add(1)
+	go s.background(sub)
+	return nil
+}
+
+// Close stops the service.
+func (s *Service) Close() error {
+	s.ctxCancel()
+	s.refCount.Wait()
+	return nil
+}
+
+// ExternalAddrs returns the external QUIC addresses discovered using STUN.
+func (s *Service) ExternalAddrs() []ma.Multiaddr {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return append([]ma.Multiaddr(nil), s.addrs...)
+}
+
+func (s *Service) background(sub *eventbus.TypedSubscription[event.EvtLocalInterfaceAddrsChanged]) {
+	defer s.refCount.Done()
+	defer sub.Close()
+
+	s.refresh()
+	ticker := time.NewTicker(s.refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.refresh()
+		case _, ok := <-sub.Out():
+			if !ok {
+				return
+			}
+			s.refresh()
+			ticker.Reset(s.refreshInterval)
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Service) refresh() {
+	var addrs []ma.Multiaddr
+	for _, pc := range s.connManager.ListenPacketConns() {
+		if s.ctx.Err() != nil {
+			return
+		}
+		if a := s.discover(pc); a != nil {
+			addrs = append(addrs, a)
+		}
+	}
+	addrs = ma.Unique(addrs)
+
+	s.mx.Lock()
+	changed := !sameAddrs(s.addrs, addrs)
+	s.addrs = addrs
+	s.mx.Unlock()
+	if changed {
+		log.Debugw("external addresses changed", "addrs", addrs)
+		if sig, ok := s.host.(addrChangeSignaler); ok {
+			sig.SignalAddressChange()
+		}
+	}
+}
+
+// discover returns the external QUIC address of pc, or nil if it couldn't be
+// determined reliably.
+func (s *Service) discover(pc quicreuse.PacketConn) ma.Multiaddr {
+	laddr, ok := pc.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return nil
+	}
+	netw := "udp6"
+	if laddr.IP.To4() != nil {
+		netw = "udp4"
+	}
+	local, err := quicreuse.ToQuicMultiaddr(laddr, quic.Version1)
+	if err != nil {
+		return nil
+	}
+	if manet.IsIPLoopback(local) {
+		return nil
+	}
+
+	var mapped *net.UDPAddr
+	for _, srv := range s.servers {
+		a, err := s.query(pc, netw, srv)
+		if err != nil {
+			log.Debugw("STUN query failed", "server", srv, "local", laddr, "error", err)
+			continue
+		}
+		if mapped != nil && !(mapped.IP.Equal(a.IP) && mapped.Port == a.Port) {
+			// Endpoint-dependent mapping: peers will see yet another address.
+			log.Debugw("STUN servers disagree on external address", "local", laddr, "addrs", []*net.UDPAddr{mapped, a})
+			return nil
+		}
+		mapped = a
+	}
+	if mapped == nil {
+		return nil
+	}
+	external, err := quicreuse.ToQuicMultiaddr(mapped, quic.Version1)
+	if err != nil || !manet.IsPublicAddr(external) {
+		return nil
+	}
+
+	if s.ids != nil {
+		if observed := s.ids.ObservedAddrsFor(local); len(observed) > 0 && !ma.Contains(observed, external) {
+			// The addresses other peers observe take precedence.
+			log.Debugw("STUN address inconsistent with identify observations", "external", external, "observed", observed)
+			return nil
+		}
+	}
+	return external
+}
+
+// query asks the STUN server srv for the address it sees pc on.
+func (s *Service) query(pc quicreuse.PacketConn, netw, srv string) (*net.UDPAddr, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	raddr, err := resolve(ctx, netw, srv)
+	if err != nil {
+		return nil, err
+	}
+	req, err := pionstun.Build(pionstun.TransactionID, pionstun.BindingRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	// Retransmit the request until we get a response, since UDP is unreliable.
+	s.refCount.Add(1)
+	go func() {
+		defer s.refCount.Done()
+		t := time.NewTicker(retransmitInterval)
+		defer t.Stop()
+		for {
+			if _, err := pc.WriteTo(req.Raw, raddr); err != nil {
+				log.Debugw("failed to send STUN request", "server", srv, "error", err)
+			}
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := pc.ReadNonQUICPacket(ctx, buf)
+		if err != nil {
+			return nil, err
+		}
+		if !isFrom(from, raddr) || !pionstun.IsMessage(buf[:n]) {
+			continue
+		}
+		resp := &pionstun.Message{Raw: append([]byte(nil), buf[:n]...)}
+		if err := resp.Decode(); err != nil || resp.TransactionID != req.TransactionID {
+			continue
+		}
+		if resp.Type != pionstun.BindingSuccess {
+			return nil, fmt.Errorf("unexpected STUN response: %s", resp.Type)
+		}
+		var xorAddr pionstun.XORMappedAddress
+		if err := xorAddr.GetFrom(resp); err != nil {
+			return nil, err
+		}
+		return &net.UDPAddr{IP: xorAddr.IP, Port: xorAddr.Port}, nil
+	}
+}
+
+func resolve(ctx context.Context, netw, srv string) (*net.UDPAddr, error) {
+	host, portStr, err := net.SplitHostPort(srv)
+	if err != nil {
+		return nil, err
+	}
+	port, err := net.DefaultResolver.LookupPort(ctx, "udp", portStr)
+	if err != nil {
+		return nil, err
+	}
+	ipNet := "ip4"
+	if netw == "udp6" {
+		ipNet = "ip6"
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, ipNet, host)
+	if err != nil {
+		return nil, err
+	}
+	return &net.UDPAddr{IP: ips[0], Port: port}, nil
+}
+
+func isFrom(from net.Addr, raddr *net.UDPAddr) bool {
+	a, ok := from.(*net.UDPAddr)
+	return ok && a.IP.Equal(raddr.IP) && a.Port == raddr.Port
+}
+
+func sameAddrs(a, b []ma.Multiaddr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, x := range a {
+		if !ma.Contains(b, x) {
+			return false
+		}
+	}
+	return true
+}
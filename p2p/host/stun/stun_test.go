@@ -0,0 +1,149 @@
+package stun
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	"github.com/libp2p/go-libp2p/p2p/transport/quicreuse"
+
+	ma "github.com/multiformats/go-multiaddr"
+	pionstun "github.com/pion/stun"
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/require"
+)
+
+// runServer runs a STUN server that reports mapped as the address of every client.
+func runServer(t *testing.T, mapped *net.UDPAddr) string {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req := &pionstun.Message{Raw: append([]byte(nil), buf[:n]...)}
+			if err := req.Decode(); err != nil || req.Type != pionstun.BindingRequest {
+				continue
+			}
+			resp, err := pionstun.Build(
+				pionstun.NewTransactionIDSetter(req.TransactionID),
+				pionstun.BindingSuccess,
+				&pionstun.XORMappedAddress{IP: mapped.IP, Port: mapped.Port},
+				pionstun.Fingerprint,
+			)
+			if err != nil {
+				panic(err)
+			}
+			conn.WriteTo(resp.Raw, from)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func newConnManager(t *testing.T) *quicreuse.ConnManager {
+	t.Helper()
+	cm, err := quicreuse.NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{})
+	require.NoError(t, err)
+	t.Cleanup(func() { cm.Close() })
+	ln, err := cm.ListenQUIC(ma.StringCast("/ip4/0.0.0.0/udp/0/quic-v1"), &tls.Config{NextProtos: []string{"libp2p"}}, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	return cm
+}
+
+func newHost(t *testing.T) host.Host {
+	t.Helper()
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+	h, err := mn.GenPeer()
+	require.NoError(t, err)
+	return h
+}
+
+func newService(t *testing.T, opts ...Option) *Service {
+	t.Helper()
+	s, err := New(newHost(t), newConnManager(t), opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestDiscover(t *testing.T) {
+	mapped := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 4321}
+	s := newService(t, WithServers(runServer(t, mapped), runServer(t, mapped)))
+	require.NoError(t, s.Start())
+
+	expected := ma.StringCast("/ip4/1.2.3.4/udp/4321/quic-v1")
+	require.Eventually(t, func() bool {
+		addrs := s.ExternalAddrs()
+		return len(addrs) == 1 && addrs[0].Equal(expected)
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestServersDisagree(t *testing.T) {
+	s := newService(t,
+		WithServers(
+			runServer(t, &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 4321}),
+			runServer(t, &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 4322}),
+		),
+	)
+	s.refresh()
+	require.Empty(t, s.ExternalAddrs())
+}
+
+func TestUnreachableServer(t *testing.T) {
+	// nobody is listening on this port
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	addr := conn.LocalAddr().String()
+	conn.Close()
+
+	mapped := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 4321}
+	s := newService(t, WithServers(addr, runServer(t, mapped)), WithQueryTimeout(200*time.Millisecond))
+	s.refresh()
+	addrs := s.ExternalAddrs()
+	require.Len(t, addrs, 1)
+	require.True(t, addrs[0].Equal(ma.StringCast("/ip4/1.2.3.4/udp/4321/quic-v1")))
+}
+
+type mockIDService struct {
+	identify.IDService
+	observed []ma.Multiaddr
+}
+
+func (m *mockIDService) ObservedAddrsFor(ma.Multiaddr) []ma.Multiaddr { return m.observed }
+
+func TestCrossCheckWithIdentify(t *testing.T) {
+	mapped := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 4321}
+	server := runServer(t, mapped)
+
+	t.Run("consistent", func(t *testing.T) {
+		ids := &mockIDService{observed: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/udp/4321/quic-v1")}}
+		s := newService(t, WithServers(server), WithIdentify(ids))
+		s.refresh()
+		require.Len(t, s.ExternalAddrs(), 1)
+	})
+
+	t.Run("inconsistent", func(t *testing.T) {
+		ids := &mockIDService{observed: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/udp/5555/quic-v1")}}
+		s := newService(t, WithServers(server), WithIdentify(ids))
+		s.refresh()
+		require.Empty(t, s.ExternalAddrs())
+	})
+}
+
+func TestInvalidOptions(t *testing.T) {
+	_, err := New(newHost(t), newConnManager(t), WithServers())
+	require.Error(t, err)
+	_, err = New(newHost(t), newConnManager(t), WithServers("stun.example.com"))
+	require.Error(t, err)
+}
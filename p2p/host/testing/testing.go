@@ -0,0 +1,148 @@
+// Package testing provides a builder for test hosts.
+//
+// Test hosts are constructed using libp2p.New, so they exercise the same
+// construction path as production hosts, and every libp2p.Option can be used
+// to configure them. On top of that, the builder sets defaults that suit
+// tests (listening on localhost only) and offers test-only knobs.
+package testing
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/p2ptest"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+type config struct {
+	libp2pOpts []libp2p.Option
+	rand       *p2ptest.Rand
+	dialOnly   bool
+}
+
+// Option is an option that can be passed when constructing a test host.
+type Option func(*testing.T, *config)
+
+// WithOptions configures the host using libp2p options. Listen addresses
+// configured here replace the default (localhost) listen addresses.
+func WithOptions(opts ...libp2p.Option) Option {
+	return func(_ *testing.T, c *config) {
+		c.libp2pOpts = append(c.libp2pOpts, opts...)
+	}
+}
+
+// WithRand makes the host generate its identity (an Ed25519 key) and pick
+// its listen port using r, so that they are reproducible.
+func WithRand(r *p2ptest.Rand) Option {
+	return func(_ *testing.T, c *config) {
+		c.rand = r
+	}
+}
+
+// OptDialOnly prevents the test host from listening.
+var OptDialOnly Option = func(_ *testing.T, c *config) {
+	c.dialOnly = true
+}
+
+// GenHost generates a new test host. It is closed when the test completes.
+func GenHost(t *testing.T, opts ...Option) host.Host {
+	t.Helper()
+	var cfg config
+	for _, o := range opts {
+		o(t, &cfg)
+	}
+
+	var libp2pOpts []libp2p.Option
+	if cfg.rand != nil {
+		priv, _, err := cfg.rand.KeyPair(crypto.Ed25519, -1)
+		require.NoError(t, err)
+		libp2pOpts = append(libp2pOpts, libp2p.Identity(priv))
+	}
+	libp2pOpts = append(libp2pOpts, cfg.libp2pOpts...)
+
+	var h host.Host
+	var err error
+	switch {
+	case cfg.dialOnly:
+		h, err = libp2p.New(append(libp2pOpts, libp2p.NoListenAddrs)...)
+	case cfg.rand == nil:
+		h, err = libp2p.New(append(libp2pOpts, defaultListenAddrs(0, nil))...)
+	default:
+		// Binding the port picked using the Rand is the only way to know
+		// that it is free, so the host is constructed again with another port
+		// if it can't listen on all its default addresses.
+		_, err = cfg.rand.ListenPort(func(port int) error {
+			var isDefault bool
+			var err error
+			h, err = libp2p.New(append(libp2pOpts, defaultListenAddrs(port, &isDefault))...)
+			if err != nil {
+				return err
+			}
+			if isDefault && !listensOn(h, port) {
+				h.Close()
+				return fmt.Errorf("failed to listen on port %d", port)
+			}
+			return nil
+		})
+	}
+	require.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+// GenHosts generates n test hosts using the same options.
+func GenHosts(t *testing.T, n int, opts ...Option) []host.Host {
+	t.Helper()
+	hosts := make([]host.Host, 0, n)
+	for i := 0; i < n; i++ {
+		hosts = append(hosts, GenHost(t, opts...))
+	}
+	return hosts
+}
+
+// Connect connects a to b.
+func Connect(t *testing.T, a, b host.Host) {
+	t.Helper()
+	require.NoError(t, a.Connect(context.Background(), peer.AddrInfo{ID: b.ID(), Addrs: b.Addrs()}))
+}
+
+// defaultListenAddrs returns an option that makes the host listen on
+// localhost on port using TCP and QUIC, unless listen addresses were
+// configured, and sets *isDefault if it isn't nil. It must be the last option.
+func defaultListenAddrs(port int, isDefault *bool) libp2p.Option {
+	return func(cfg *libp2p.Config) error {
+		if cfg.ListenAddrs != nil {
+			return nil
+		}
+		cfg.ListenAddrs = []ma.Multiaddr{
+			ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", port)),
+			ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/udp/%d/quic-v1", port)),
+		}
+		if isDefault != nil {
+			*isDefault = true
+		}
+		return nil
+	}
+}
+
+// listensOn returns true if h listens on port using both TCP and UDP.
+func listensOn(h host.Host, port int) bool {
+	var tcp, udp bool
+	for _, a := range h.Network().ListenAddresses() {
+		if v, err := a.ValueForProtocol(ma.P_TCP); err == nil && v == strconv.Itoa(port) {
+			tcp = true
+		}
+		if v, err := a.ValueForProtocol(ma.P_UDP); err == nil && v == strconv.Itoa(port) {
+			udp = true
+		}
+	}
+	return tcp && udp
+}
@@ -0,0 +1,86 @@
+package testing
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"github.com/libp2p/go-libp2p/p2p/p2ptest"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenHost(t *testing.T) {
+	hosts := GenHosts(t, 2)
+	for _, h := range hosts {
+		require.NotEmpty(t, h.Addrs())
+		for _, a := range h.Addrs() {
+			require.True(t, manet.IsIPLoopback(a), "expected a localhost address: %s", a)
+		}
+	}
+	Connect(t, hosts[0], hosts[1])
+	res := <-ping.Ping(context.Background(), hosts[0], hosts[1].ID())
+	require.NoError(t, res.Error)
+}
+
+func TestWithOptions(t *testing.T) {
+	rm, err := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(rcmgr.DefaultLimits.AutoScale()))
+	require.NoError(t, err)
+	h := GenHost(t, WithOptions(
+		libp2p.ResourceManager(rm),
+		libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+	))
+	require.Equal(t, rm, h.Network().ResourceManager())
+	// the listen addresses replace the default ones
+	for _, a := range h.Network().ListenAddresses() {
+		_, err := a.ValueForProtocol(ma.P_QUIC_V1)
+		require.Error(t, err, "didn't expect to listen on QUIC: %s", a)
+	}
+}
+
+func TestDialOnly(t *testing.T) {
+	h := GenHost(t, OptDialOnly)
+	require.Empty(t, h.Network().ListenAddresses())
+	Connect(t, h, GenHost(t))
+}
+
+func TestWithRand(t *testing.T) {
+	h1 := GenHost(t, WithRand(p2ptest.New(t, p2ptest.WithSeed(42))), OptDialOnly)
+	h2 := GenHost(t, WithRand(p2ptest.New(t, p2ptest.WithSeed(42))), OptDialOnly)
+	require.Equal(t, h1.ID(), h2.ID())
+}
+
+func TestWithRandPortInUse(t *testing.T) {
+	h1 := GenHost(t, WithRand(p2ptest.New(t, p2ptest.WithSeed(42))))
+	var port string
+	for _, a := range h1.Network().ListenAddresses() {
+		if p, err := a.ValueForProtocol(ma.P_UDP); err == nil {
+			port = p
+		}
+	}
+	require.NotEmpty(t, port)
+	require.NoError(t, h1.Close())
+
+	// the same seed picks the same port first, but its UDP port is in use
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:"+port)
+	require.NoError(t, err)
+	defer pc.Close()
+	h2 := GenHost(t, WithRand(p2ptest.New(t, p2ptest.WithSeed(42))))
+	require.Equal(t, h1.ID(), h2.ID())
+	var ports []string
+	for _, a := range h2.Network().ListenAddresses() {
+		for _, code := range []int{ma.P_TCP, ma.P_UDP} {
+			if p, err := a.ValueForProtocol(code); err == nil {
+				ports = append(ports, p)
+			}
+		}
+	}
+	require.Len(t, ports, 2)
+	require.Equal(t, ports[0], ports[1])
+	require.NotEqual(t, port, ports[0])
+}
@@ -0,0 +1,416 @@
+// Package identity stores the identity keys of hosts on disk.
+//
+// Keys are stored PEM encoded. They can be encrypted with a passphrase, using
+// scrypt and XChaCha20-Poly1305, or wrapped by a KeyWrapper, e.g. a key
+// management service. Key files must only be accessible by their owner, files
+// that can be read by other users are rejected.
+//
+// Keys stored in the formats commonly used before this package existed, i.e.
+// the protobuf encoding of crypto.MarshalPrivateKey, either raw or base64
+// encoded, are loaded as well, and rewritten in the current format if
+// WithMigration is used.
+package identity
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+var log = logging.Logger("identity")
+
+const (
+	pemTypePlain     = "LIBP2P PRIVATE KEY"
+	pemTypeEncrypted = "LIBP2P ENCRYPTED PRIVATE KEY"
+
+	headerMethod  = "Method"
+	headerSalt    = "Salt"
+	headerScryptN = "Scrypt-N"
+	headerNonce   = "Nonce"
+	headerWrapper = "Wrapper"
+
+	methodPassphrase = "scrypt-xchacha20poly1305"
+	methodWrapped    = "wrapped"
+
+	// scrypt parameters, as recommended for interactive logins in 2017.
+	defaultScryptN = 1 << 15
+	// maxScryptN bounds the memory and time used to decrypt a key file.
+	maxScryptN = 1 << 20
+	scryptR    = 8
+	scryptP    = 1
+	saltLen    = 16
+)
+
+var (
+	// ErrInsecurePermissions is returned when loading a key file that is
+	// accessible by other users.
+	ErrInsecurePermissions = errors.New("identity: key file is accessible by other users")
+	// ErrEncrypted is returned when loading an encrypted key without the
+	// passphrase or key wrapper needed to decrypt it.
+	ErrEncrypted = errors.New("identity: key is encrypted")
+	// ErrDecrypt is returned when an encrypted key can't be decrypted, e.g.
+	// because the passphrase is wrong.
+	ErrDecrypt = errors.New("identity: failed to decrypt key")
+)
+
+// KeyWrapper encrypts keys with a key that is managed elsewhere, e.g. by a key
+// management service or a hardware security module.
+type KeyWrapper interface {
+	// Name identifies the wrapping key, e.g. the key ID of the KMS. It is
+	// stored in the key file, and must match when unwrapping.
+	Name() string
+	Wrap(plaintext []byte) ([]byte, error)
+	Unwrap(ciphertext []byte) ([]byte, error)
+}
+
+type config struct {
+	passphrase          []byte
+	wrapper             KeyWrapper
+	insecurePermissions bool
+	migrate             bool
+	keyType             int
+	scryptN             int
+}
+
+type Option func(*config) error
+
+// WithPassphrase encrypts the key with a key derived from passphrase.
+func WithPassphrase(passphrase []byte) Option {
+	return func(cfg *config) error {
+		if len(passphrase) == 0 {
+			return errors.New("identity: empty passphrase")
+		}
+		cfg.passphrase = passphrase
+		return nil
+	}
+}
+
+// WithKeyWrapper encrypts the key using w.
+func WithKeyWrapper(w KeyWrapper) Option {
+	return func(cfg *config) error {
+		cfg.wrapper = w
+		return nil
+	}
+}
+
+// WithInsecurePermissions disables the check that key files are only
+// accessible by their owner.
+func WithInsecurePermissions() Option {
+	return func(cfg *config) error {
+		cfg.insecurePermissions = true
+		return nil
+	}
+}
+
+// WithMigration rewrites keys that are stored in a legacy format, or that are
+// not encrypted although a passphrase or key wrapper is used, when they are
+// loaded.
+func WithMigration() Option {
+	return func(cfg *config) error {
+		cfg.migrate = true
+		return nil
+	}
+}
+
+// WithKeyType sets the type of keys generated by LoadOrGenerate (e.g.
+// crypto.Ed25519, the default).
+func WithKeyType(typ int) Option {
+	return func(cfg *config) error {
+		switch typ {
+		case crypto.Ed25519, crypto.Secp256k1, crypto.ECDSA:
+		default:
+			return fmt.Errorf("identity: unsupported key type %d", typ)
+		}
+		cfg.keyType = typ
+		return nil
+	}
+}
+
+func newConfig(opts []Option) (*config, error) {
+	cfg := &config{keyType: crypto.Ed25519, scryptN: defaultScryptN}
+	for _, opt := range opts {
+		if err := opt(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.passphrase != nil && cfg.wrapper != nil {
+		return nil, errors.New("identity: cannot use a passphrase and a key wrapper")
+	}
+	return cfg, nil
+}
+
+// Save stores key at path, encrypted if a passphrase or key wrapper is
+// used. The file is replaced atomically, and is only accessible by its owner.
+func Save(path string, key crypto.PrivKey, opts ...Option) error {
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return err
+	}
+	return save(path, key, cfg)
+}
+
+func save(path string, key crypto.PrivKey, cfg *config) error {
+	b, err := encode(key, cfg)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
+
+// Load loads the key stored at path.
+func Load(path string, opts ...Option) (crypto.PrivKey, error) {
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return load(path, cfg)
+}
+
+// LoadOrGenerate loads the key stored at path, or generates and stores a new
+// key if the file doesn't exist.
+func LoadOrGenerate(path string, opts ...Option) (crypto.PrivKey, error) {
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	key, err := load(path, cfg)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return key, err
+	}
+	bits := -1
+	if cfg.keyType == crypto.ECDSA {
+		bits = 256
+	}
+	key, _, err = crypto.GenerateKeyPairWithReader(cfg.keyType, bits, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := save(path, key, cfg); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func load(path string, cfg *config) (crypto.PrivKey, error) {
+	if !cfg.insecurePermissions {
+		if err := checkPermissions(path); err != nil {
+			return nil, err
+		}
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, current, err := decode(b, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if !current && cfg.migrate {
+		if err := save(path, key, cfg); err != nil {
+			return nil, fmt.Errorf("identity: failed to migrate key: %w", err)
+		}
+		log.Infof("migrated key at %s", path)
+	}
+	return key, nil
+}
+
+func checkPermissions(path string) error {
+	// Windows doesn't have Unix permissions, access is controlled by ACLs.
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Perm()&0o077 != 0 {
+		return fmt.Errorf("%w: %s has mode %s", ErrInsecurePermissions, path, fi.Mode().Perm())
+	}
+	return nil
+}
+
+func encode(key crypto.PrivKey, cfg *config) ([]byte, error) {
+	raw, err := crypto.MarshalPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	block := &pem.Block{Type: pemTypePlain, Bytes: raw}
+	switch {
+	case cfg.passphrase != nil:
+		salt := make([]byte, saltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		aead, err := passphraseAEAD(cfg.passphrase, salt, cfg.scryptN)
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		block = &pem.Block{
+			Type: pemTypeEncrypted,
+			Headers: map[string]string{
+				headerMethod:  methodPassphrase,
+				headerScryptN: strconv.Itoa(cfg.scryptN),
+				headerSalt:    base64.StdEncoding.EncodeToString(salt),
+				headerNonce:   base64.StdEncoding.EncodeToString(nonce),
+			},
+			Bytes: aead.Seal(nil, nonce, raw, []byte(pemTypeEncrypted)),
+		}
+	case cfg.wrapper != nil:
+		wrapped, err := cfg.wrapper.Wrap(raw)
+		if err != nil {
+			return nil, fmt.Errorf("identity: failed to wrap key: %w", err)
+		}
+		block = &pem.Block{
+			Type: pemTypeEncrypted,
+			Headers: map[string]string{
+				headerMethod:  methodWrapped,
+				headerWrapper: cfg.wrapper.Name(),
+			},
+			Bytes: wrapped,
+		}
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// decode decodes a key file. current is false if the file uses a legacy
+// format, or isn't encrypted as configured.
+func decode(b []byte, cfg *config) (key crypto.PrivKey, current bool, err error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		key, err := decodeLegacy(b)
+		return key, false, err
+	}
+	switch block.Type {
+	case pemTypePlain:
+		key, err := crypto.UnmarshalPrivateKey(block.Bytes)
+		return key, cfg.passphrase == nil && cfg.wrapper == nil, err
+	case pemTypeEncrypted:
+		raw, err := decrypt(block, cfg)
+		if err != nil {
+			return nil, false, err
+		}
+		key, err := crypto.UnmarshalPrivateKey(raw)
+		return key, true, err
+	default:
+		return nil, false, fmt.Errorf("identity: unexpected PEM block %q", block.Type)
+	}
+}
+
+// decodeLegacy decodes keys stored as the protobuf encoding of
+// crypto.MarshalPrivateKey, either raw or base64 encoded.
+func decodeLegacy(b []byte) (crypto.PrivKey, error) {
+	if raw, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(b))); err == nil {
+		if key, err := crypto.UnmarshalPrivateKey(raw); err == nil {
+			return key, nil
+		}
+	}
+	key, err := crypto.UnmarshalPrivateKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("identity: unknown key format: %w", err)
+	}
+	return key, nil
+}
+
+func decrypt(block *pem.Block, cfg *config) ([]byte, error) {
+	switch method := block.Headers[headerMethod]; method {
+	case methodPassphrase:
+		if cfg.passphrase == nil {
+			return nil, fmt.Errorf("%w with a passphrase", ErrEncrypted)
+		}
+		n, err := strconv.Atoi(block.Headers[headerScryptN])
+		if err != nil || n > maxScryptN {
+			return nil, fmt.Errorf("identity: invalid scrypt parameter %q", block.Headers[headerScryptN])
+		}
+		salt, err := base64.StdEncoding.DecodeString(block.Headers[headerSalt])
+		if err != nil {
+			return nil, fmt.Errorf("identity: invalid salt: %w", err)
+		}
+		nonce, err := base64.StdEncoding.DecodeString(block.Headers[headerNonce])
+		if err != nil {
+			return nil, fmt.Errorf("identity: invalid nonce: %w", err)
+		}
+		aead, err := passphraseAEAD(cfg.passphrase, salt, n)
+		if err != nil {
+			return nil, err
+		}
+		if len(nonce) != aead.NonceSize() {
+			return nil, errors.New("identity: invalid nonce")
+		}
+		raw, err := aead.Open(nil, nonce, block.Bytes, []byte(pemTypeEncrypted))
+		if err != nil {
+			return nil, ErrDecrypt
+		}
+		return raw, nil
+	case methodWrapped:
+		name := block.Headers[headerWrapper]
+		if cfg.wrapper == nil {
+			return nil, fmt.Errorf("%w with key wrapper %s", ErrEncrypted, name)
+		}
+		if cfg.wrapper.Name() != name {
+			return nil, fmt.Errorf("identity: key is wrapped with %s, not %s", name, cfg.wrapper.Name())
+		}
+		raw, err := cfg.wrapper.Unwrap(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrDecrypt, err)
+		}
+		return raw, nil
+	default:
+		return nil, fmt.Errorf("identity: unknown encryption method %q", method)
+	}
+}
+
+func passphraseAEAD(passphrase, salt []byte, n int) (cipher.AEAD, error) {
+	k, err := scrypt.Key(passphrase, salt, n, scryptR, scryptP, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("identity: failed to derive key: %w", err)
+	}
+	return chacha20poly1305.NewX(k)
+}
+
+func writeFileAtomic(path string, b []byte) error {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := os.CreateTemp(dir, "."+strings.TrimPrefix(name, ".")+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0o600); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,134 @@
+package identity
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadOrGenerate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	key, err := LoadOrGenerate(path)
+	require.NoError(t, err)
+	require.Equal(t, crypto.Ed25519, int(key.Type()))
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	if runtime.GOOS != "windows" {
+		require.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+	}
+
+	loaded, err := LoadOrGenerate(path)
+	require.NoError(t, err)
+	require.True(t, key.Equals(loaded))
+
+	key, err = LoadOrGenerate(filepath.Join(t.TempDir(), "key"), WithKeyType(crypto.ECDSA))
+	require.NoError(t, err)
+	require.Equal(t, crypto.ECDSA, int(key.Type()))
+}
+
+func TestPassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	key, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	require.NoError(t, Save(path, key, WithPassphrase([]byte("secret"))))
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	raw, err := crypto.MarshalPrivateKey(key)
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(b, raw))
+
+	_, err = Load(path)
+	require.ErrorIs(t, err, ErrEncrypted)
+	_, err = Load(path, WithPassphrase([]byte("wrong")))
+	require.ErrorIs(t, err, ErrDecrypt)
+	loaded, err := Load(path, WithPassphrase([]byte("secret")))
+	require.NoError(t, err)
+	require.True(t, key.Equals(loaded))
+}
+
+type xorWrapper struct{ name string }
+
+func (w xorWrapper) Name() string { return w.name }
+
+func (w xorWrapper) Wrap(b []byte) ([]byte, error) {
+	out := make([]byte, len(b))
+	for i := range b {
+		out[i] = b[i] ^ 0x42
+	}
+	return out, nil
+}
+
+func (w xorWrapper) Unwrap(b []byte) ([]byte, error) { return w.Wrap(b) }
+
+func TestKeyWrapper(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	key, err := LoadOrGenerate(path, WithKeyWrapper(xorWrapper{"kms-1"}))
+	require.NoError(t, err)
+
+	_, err = Load(path)
+	require.ErrorIs(t, err, ErrEncrypted)
+	_, err = Load(path, WithKeyWrapper(xorWrapper{"kms-2"}))
+	require.ErrorContains(t, err, "wrapped with kms-1")
+	loaded, err := Load(path, WithKeyWrapper(xorWrapper{"kms-1"}))
+	require.NoError(t, err)
+	require.True(t, key.Equals(loaded))
+}
+
+func TestPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no Unix permissions on Windows")
+	}
+	path := filepath.Join(t.TempDir(), "key")
+	key, err := LoadOrGenerate(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Chmod(path, 0o644))
+
+	_, err = Load(path)
+	require.ErrorIs(t, err, ErrInsecurePermissions)
+	loaded, err := Load(path, WithInsecurePermissions())
+	require.NoError(t, err)
+	require.True(t, key.Equals(loaded))
+}
+
+func TestMigration(t *testing.T) {
+	key, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	raw, err := crypto.MarshalPrivateKey(key)
+	require.NoError(t, err)
+
+	for name, contents := range map[string][]byte{
+		"raw":    raw,
+		"base64": []byte(base64.StdEncoding.EncodeToString(raw) + "\n"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "key")
+			require.NoError(t, os.WriteFile(path, contents, 0o600))
+
+			// legacy formats are loaded, but only rewritten if requested
+			loaded, err := Load(path)
+			require.NoError(t, err)
+			require.True(t, key.Equals(loaded))
+			b, err := os.ReadFile(path)
+			require.NoError(t, err)
+			require.Equal(t, contents, b)
+
+			loaded, err = Load(path, WithMigration(), WithPassphrase([]byte("secret")))
+			require.NoError(t, err)
+			require.True(t, key.Equals(loaded))
+			_, err = Load(path)
+			require.ErrorIs(t, err, ErrEncrypted)
+			loaded, err = Load(path, WithPassphrase([]byte("secret")))
+			require.NoError(t, err)
+			require.True(t, key.Equals(loaded))
+		})
+	}
+}
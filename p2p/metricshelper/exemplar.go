@@ -0,0 +1,70 @@
+package metricshelper
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ObserveWithExemplar observes v, and attaches an exemplar with the label
+// name=value to it, e.g. the ID of the connection the observation belongs to.
+// This allows tying a single observation to the corresponding log lines.
+//
+// Exemplars are only exposed when using the OpenMetrics format, see
+// promhttp.HandlerOpts.EnableOpenMetrics. If o doesn't support exemplars, value
+// is empty, or the exemplar is too long, v is observed without exemplar.
+func ObserveWithExemplar(o prometheus.Observer, v float64, name, value string) {
+	eo, ok := o.(prometheus.ExemplarObserver)
+	if !ok || value == "" || utf8.RuneCountInString(name)+utf8.RuneCountInString(value) > prometheus.ExemplarMaxRunes {
+		o.Observe(v)
+		return
+	}
+	eo.ObserveWithExemplar(v, prometheus.Labels{name: value})
+}
+
+// ExemplarSampler limits how often exemplars are attached to the observations
+// of a histogram, since attaching an exemplar allocates. Prometheus only keeps
+// the most recent exemplar of every bucket, so attaching an exemplar at most
+// once per interval and bucket is enough to tie every bucket to a recent
+// observation.
+type ExemplarSampler struct {
+	buckets  []float64
+	interval time.Duration
+	// time of the last exemplar per bucket, in unix nanoseconds
+	last []atomic.Int64
+}
+
+// NewExemplarSampler returns a sampler for a histogram with the given buckets.
+func NewExemplarSampler(buckets []float64, interval time.Duration) *ExemplarSampler {
+	return &ExemplarSampler{
+		buckets:  buckets,
+		interval: interval,
+		// the last bucket is +Inf
+		last: make([]atomic.Int64, len(buckets)+1),
+	}
+}
+
+// Observe observes v, and attaches an exemplar with the label name=value to
+// it (see ObserveWithExemplar) if no exemplar was attached to an observation
+// in the same bucket within the interval.
+func (s *ExemplarSampler) Observe(o prometheus.Observer, v float64, name, value string) {
+	if value == "" || !s.sample(v) {
+		o.Observe(v)
+		return
+	}
+	ObserveWithExemplar(o, v, name, value)
+}
+
+func (s *ExemplarSampler) sample(v float64) bool {
+	// the first bucket whose upper bound is >= v
+	last := &s.last[sort.SearchFloat64s(s.buckets, v)]
+	now := time.Now().UnixNano()
+	prev := last.Load()
+	if prev != 0 && now-prev < int64(s.interval) {
+		return false
+	}
+	return last.CompareAndSwap(prev, now)
+}
@@ -0,0 +1,77 @@
+package metricshelper
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestObserveWithExemplar(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	h := prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "latency",
+			Buckets: []float64{1, 2},
+		},
+	)
+	reg.MustRegister(h)
+
+	ObserveWithExemplar(h, 0.5, "conn_id", "12D3KooWAb-1")
+	ObserveWithExemplar(h, 1.5, "conn_id", "")
+	// too long to be attached as exemplar
+	require.NotPanics(t, func() { ObserveWithExemplar(h, 1.5, "conn_id", strings.Repeat("a", prometheus.ExemplarMaxRunes)) })
+
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	require.Len(t, mfs, 1)
+	hist := mfs[0].GetMetric()[0].GetHistogram()
+	require.Equal(t, uint64(3), hist.GetSampleCount())
+	buckets := hist.GetBucket()
+	require.Len(t, buckets[0].GetExemplar().GetLabel(), 1)
+	require.Equal(t, "conn_id", buckets[0].GetExemplar().GetLabel()[0].GetName())
+	require.Equal(t, "12D3KooWAb-1", buckets[0].GetExemplar().GetLabel()[0].GetValue())
+	require.Nil(t, buckets[1].GetExemplar())
+}
+
+func TestExemplarSampler(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	buckets := []float64{1, 2}
+	h := prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "latency",
+			Buckets: buckets,
+		},
+	)
+	reg.MustRegister(h)
+
+	exemplar := func(bucket int) string {
+		mfs, err := reg.Gather()
+		require.NoError(t, err)
+		e := mfs[0].GetMetric()[0].GetHistogram().GetBucket()[bucket].GetExemplar()
+		if e == nil {
+			return ""
+		}
+		return e.GetLabel()[0].GetValue()
+	}
+
+	s := NewExemplarSampler(buckets, time.Hour)
+	s.Observe(h, 0.5, "conn_id", "first")
+	s.Observe(h, 0.7, "conn_id", "second")
+	s.Observe(h, 1.5, "conn_id", "third")
+	require.Equal(t, "first", exemplar(0))
+	require.Equal(t, "third", exemplar(1))
+
+	s = NewExemplarSampler(buckets, 0)
+	s.Observe(h, 0.5, "conn_id", "fourth")
+	require.Equal(t, "fourth", exemplar(0))
+
+	allocs := testing.AllocsPerRun(1000, func() { s.Observe(h, 0.5, "conn_id", "fifth") })
+	require.NotZero(t, allocs, "attaching an exemplar allocates")
+	s = NewExemplarSampler(buckets, time.Hour)
+	allocs = testing.AllocsPerRun(1000, func() { s.Observe(h, 0.5, "conn_id", "sixth") })
+	require.Zero(t, allocs)
+}
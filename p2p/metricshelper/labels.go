@@ -0,0 +1,42 @@
+package metricshelper
+
+// LabelFilter limits the cardinality of metrics by dropping the values of
+// disabled labels, e.g. the transport or protocol. Prometheus treats labels
+// with an empty value as absent, so all series that only differ in disabled
+// labels are merged into one.
+//
+// A nil LabelFilter doesn't drop any labels.
+type LabelFilter map[string]struct{}
+
+// NewLabelFilter returns a LabelFilter that drops the given labels.
+func NewLabelFilter(disabled ...string) LabelFilter {
+	if len(disabled) == 0 {
+		return nil
+	}
+	f := make(LabelFilter, len(disabled))
+	for _, l := range disabled {
+		f[l] = struct{}{}
+	}
+	return f
+}
+
+// Value returns value, or "" if label is disabled.
+func (f LabelFilter) Value(label, value string) string {
+	if _, ok := f[label]; ok {
+		return ""
+	}
+	return value
+}
+
+// Apply drops the disabled labels from values in place. names are the label
+// names, in the order of values.
+func (f LabelFilter) Apply(names []string, values []string) {
+	if len(f) == 0 {
+		return
+	}
+	for i, name := range names {
+		if _, ok := f[name]; ok && i < len(values) {
+			values[i] = ""
+		}
+	}
+}
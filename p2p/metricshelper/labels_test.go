@@ -0,0 +1,22 @@
+package metricshelper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabelFilter(t *testing.T) {
+	var none LabelFilter
+	require.Equal(t, "tcp", none.Value("transport", "tcp"))
+
+	f := NewLabelFilter("transport", "protocol")
+	require.Equal(t, "", f.Value("transport", "tcp"))
+	require.Equal(t, "inbound", f.Value("dir", "inbound"))
+
+	values := []string{"inbound", "tcp", "tls"}
+	f.Apply([]string{"dir", "transport", "security"}, values)
+	require.Equal(t, []string{"inbound", "", "tls"}, values)
+
+	require.Zero(t, testing.AllocsPerRun(100, func() { f.Apply([]string{"dir", "transport", "security"}, values) }))
+}
@@ -30,3 +30,11 @@ func TestRegisterCollectors(t *testing.T) {
 	require.NotPanics(t, func() { RegisterCollectors(reg, c1, c2) })
 	require.NotPanics(t, func() { RegisterCollectors(reg, c3) }, "should not panic on duplicate registration")
 }
+
+func TestRegisterersFor(t *testing.T) {
+	def := prometheus.NewRegistry()
+	swarmReg := prometheus.NewRegistry()
+	r := Registerers{Default: def, Subsystems: map[Subsystem]prometheus.Registerer{SubsystemSwarm: swarmReg}}
+	require.Same(t, swarmReg, r.For(SubsystemSwarm))
+	require.Same(t, def, r.For(SubsystemIdentify))
+}
@@ -0,0 +1,36 @@
+package metricshelper
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Subsystem is a libp2p subsystem that exports metrics.
+type Subsystem string
+
+const (
+	SubsystemSwarm           Subsystem = "swarm"
+	SubsystemEventBus        Subsystem = "eventbus"
+	SubsystemIdentify        Subsystem = "identify"
+	SubsystemHolePunch       Subsystem = "holepunch"
+	SubsystemRelayService    Subsystem = "relaysvc"
+	SubsystemAutoRelay       Subsystem = "autorelay"
+	SubsystemAutoNAT         Subsystem = "autonat"
+	SubsystemResourceManager Subsystem = "rcmgr"
+	SubsystemAddrCheck       Subsystem = "addrcheck"
+	SubsystemUpgrader        Subsystem = "upgrader"
+	SubsystemHost            Subsystem = "host"
+	SubsystemBandwidth       Subsystem = "bandwidth"
+)
+
+// Registerers holds the Registerer of every subsystem.
+type Registerers struct {
+	// Default is used for subsystems that don't have a Registerer in Subsystems.
+	Default    prometheus.Registerer
+	Subsystems map[Subsystem]prometheus.Registerer
+}
+
+// For returns the Registerer of subsystem s.
+func (r Registerers) For(s Subsystem) prometheus.Registerer {
+	if reg, ok := r.Subsystems[s]; ok {
+		return reg
+	}
+	return r.Default
+}
@@ -0,0 +1,194 @@
+package shaping
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Each frame starts with a header: the frame type, the length of the payload
+// and the length of the padding following the payload.
+const headerSize = 1 + 2 + 2
+
+const (
+	frameData  byte = 0
+	frameCover byte = 1
+)
+
+// conn frames the data written to it, and removes the framing from the data
+// read from it.
+type conn struct {
+	net.Conn
+	cfg Config
+
+	writeMx   sync.Mutex
+	lastWrite time.Time
+
+	readMx    sync.Mutex
+	remaining int // payload bytes of the current data frame not read yet
+	padding   int // padding of the current frame, discarded before the next header
+	header    [headerSize]byte
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	coverDone chan struct{}
+}
+
+func newConn(nc net.Conn, cfg Config) *conn {
+	c := &conn{
+		Conn:      nc,
+		cfg:       cfg,
+		closed:    make(chan struct{}),
+		coverDone: make(chan struct{}),
+	}
+	if cfg.CoverInterval > 0 {
+		go c.sendCover()
+	} else {
+		close(c.coverDone)
+	}
+	return c
+}
+
+// maxPayload returns the maximum payload of a frame.
+func (c *conn) maxPayload() int {
+	if len(c.cfg.Buckets) == 0 {
+		return math.MaxUint16
+	}
+	return c.cfg.Buckets[len(c.cfg.Buckets)-1] - headerSize
+}
+
+// frameSize returns the size of the frame carrying a payload of n bytes.
+func (c *conn) frameSize(n int) int {
+	for _, b := range c.cfg.Buckets {
+		if b >= headerSize+n {
+			return b
+		}
+	}
+	return headerSize + n
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	c.writeMx.Lock()
+	defer c.writeMx.Unlock()
+
+	var written int
+	for written < len(b) {
+		n := min(len(b)-written, c.maxPayload())
+		if err := c.writeFrameLocked(frameData, b[written:written+n]); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// writeFrameLocked writes a frame carrying payload, padded to the next bucket.
+// The frame is written in one call, so that it's sent in as few packets as
+// possible.
+func (c *conn) writeFrameLocked(typ byte, payload []byte) error {
+	size := c.frameSize(len(payload))
+	buf := make([]byte, size)
+	buf[0] = typ
+	binary.BigEndian.PutUint16(buf[1:], uint16(len(payload)))
+	binary.BigEndian.PutUint16(buf[3:], uint16(size-headerSize-len(payload)))
+	copy(buf[headerSize:], payload)
+	c.lastWrite = time.Now()
+	_, err := c.Conn.Write(buf)
+	return err
+}
+
+// sendCover sends a cover frame about every CoverInterval, unless data was
+// written in the meantime.
+func (c *conn) sendCover() {
+	defer close(c.coverDone)
+	for {
+		// Randomize the interval, so that cover frames can't be told apart
+		// by their timing.
+		d := c.cfg.CoverInterval/2 + time.Duration(rand.Int63n(int64(c.cfg.CoverInterval)))
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+		case <-c.closed:
+			timer.Stop()
+			return
+		}
+
+		c.writeMx.Lock()
+		var err error
+		if time.Since(c.lastWrite) >= d {
+			var size int
+			if len(c.cfg.Buckets) > 0 {
+				size = c.cfg.Buckets[rand.Intn(len(c.cfg.Buckets))] - headerSize
+			}
+			err = c.writeFrameLocked(frameCover, make([]byte, size))
+		}
+		c.writeMx.Unlock()
+		if err != nil {
+			log.Debugw("failed to send cover frame", "error", err)
+			return
+		}
+	}
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	c.readMx.Lock()
+	defer c.readMx.Unlock()
+
+	for c.remaining == 0 {
+		if err := c.nextFrameLocked(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := c.Conn.Read(b[:min(len(b), c.remaining)])
+	c.remaining -= n
+	if err == io.EOF && c.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// nextFrameLocked skips the padding of the current frame and reads the header
+// of the next one. Cover frames are skipped entirely.
+func (c *conn) nextFrameLocked() error {
+	if c.padding > 0 {
+		if err := c.discard(c.padding); err != nil {
+			return err
+		}
+		c.padding = 0
+	}
+	if _, err := io.ReadFull(c.Conn, c.header[:]); err != nil {
+		return err
+	}
+	payload := int(binary.BigEndian.Uint16(c.header[1:]))
+	padding := int(binary.BigEndian.Uint16(c.header[3:]))
+	switch c.header[0] {
+	case frameData:
+		c.remaining = payload
+		c.padding = padding
+		return nil
+	case frameCover:
+		return c.discard(payload + padding)
+	default:
+		return fmt.Errorf("unknown frame type: %d", c.header[0])
+	}
+}
+
+func (c *conn) discard(n int) error {
+	_, err := io.CopyN(io.Discard, c.Conn, int64(n))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (c *conn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	err := c.Conn.Close()
+	<-c.coverDone
+	return err
+}
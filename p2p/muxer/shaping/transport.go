@@ -0,0 +1,149 @@
+// Package shaping implements a stream multiplexer that shapes the traffic of
+// another multiplexer, to make it harder for an observer of the (encrypted)
+// connection to infer what is being transferred from the size and timing of
+// the packets.
+//
+// Data written by the inner multiplexer is split into frames, which are padded
+// to one of a few sizes. Optionally, cover frames are sent at a low rate while
+// the connection is idle. Both cost bandwidth, so shaping is opt-in, and has to
+// be enabled on both ends of a connection:
+//
+//	shaped, err := shaping.New(yamux.DefaultTransport)
+//	...
+//	libp2p.New(
+//		libp2p.Muxer(string(shaping.ProtocolID(yamux.ID)), shaped),
+//		libp2p.Muxer(yamux.ID, yamux.DefaultTransport),
+//	)
+//
+// The frame format is the same for all configurations: padding and cover
+// traffic are decided by the sender, so each side configures its own shaping,
+// and the configuration can differ between connections, see WithPolicy.
+package shaping
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("shaping")
+
+// idPrefix is prepended to the protocol ID of the inner multiplexer.
+const idPrefix = "/shaped/1.0.0"
+
+// ProtocolID returns the protocol ID to negotiate for a shaped connection
+// using the inner multiplexer with protocol ID inner.
+func ProtocolID(inner protocol.ID) protocol.ID {
+	return idPrefix + inner
+}
+
+// maxFrameSize is the size of the largest frame: the payload and padding
+// lengths are encoded as uint16.
+const maxFrameSize = headerSize + math.MaxUint16
+
+// DefaultBuckets are the frame sizes used by default.
+var DefaultBuckets = []int{256, 1024, 4096, 16384}
+
+// Config configures the shaping of the traffic sent on a connection.
+type Config struct {
+	// Buckets are the sizes, in bytes including the frame header, that frames
+	// are padded to. Writes larger than the largest bucket are split into
+	// multiple frames. If empty, frames aren't padded.
+	Buckets []int
+	// CoverInterval is the average interval at which a cover frame is sent
+	// while no data is sent. Cover frames have the size of a random bucket.
+	// 0 disables cover traffic.
+	CoverInterval time.Duration
+}
+
+func (c *Config) validate() error {
+	if !sort.IntsAreSorted(c.Buckets) {
+		return errors.New("buckets must be sorted")
+	}
+	for _, b := range c.Buckets {
+		if b <= headerSize || b > maxFrameSize {
+			return fmt.Errorf("bucket size must be between %d and %d, got %d", headerSize+1, maxFrameSize, b)
+		}
+	}
+	if c.CoverInterval < 0 {
+		return errors.New("cover interval must not be negative")
+	}
+	return nil
+}
+
+// Policy returns the Config for a new connection. It allows shaping classes of
+// connections differently, e.g. only connections to untrusted peers or over
+// particular transports. nc is usually a sec.SecureConn.
+type Policy func(nc net.Conn, isServer bool) Config
+
+// Option configures a Transport.
+type Option func(*Transport) error
+
+// WithConfig uses c for all connections. It defaults to padding to the
+// DefaultBuckets, without cover traffic.
+func WithConfig(c Config) Option {
+	return func(t *Transport) error {
+		if err := c.validate(); err != nil {
+			return err
+		}
+		t.policy = func(net.Conn, bool) Config { return c }
+		return nil
+	}
+}
+
+// WithPolicy uses p to configure each connection. Connections with an invalid
+// Config are rejected.
+func WithPolicy(p Policy) Option {
+	return func(t *Transport) error {
+		if p == nil {
+			return errors.New("policy must not be nil")
+		}
+		t.policy = p
+		return nil
+	}
+}
+
+// Transport is a network.Multiplexer that shapes the traffic of the
+// connections of an inner multiplexer.
+type Transport struct {
+	inner  network.Multiplexer
+	policy Policy
+}
+
+var _ network.Multiplexer = &Transport{}
+
+// New creates a Transport that shapes the traffic of inner.
+func New(inner network.Multiplexer, opts ...Option) (*Transport, error) {
+	t := &Transport{
+		inner:  inner,
+		policy: func(net.Conn, bool) Config { return Config{Buckets: DefaultBuckets} },
+	}
+	for _, opt := range opts {
+		if err := opt(t); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *Transport) NewConn(nc net.Conn, isServer bool, scope network.PeerScope) (network.MuxedConn, error) {
+	cfg := t.policy(nc, isServer)
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid shaping config: %w", err)
+	}
+	sc := newConn(nc, cfg)
+	mc, err := t.inner.NewConn(sc, isServer, scope)
+	if err != nil {
+		sc.Close()
+		return nil, err
+	}
+	return mc, nil
+}
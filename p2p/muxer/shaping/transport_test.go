@@ -0,0 +1,114 @@
+package shaping
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	tmux "github.com/libp2p/go-libp2p/p2p/muxer/testsuite"
+	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShapedTransport(t *testing.T) {
+	// Yamux doesn't have any backpressure when it comes to opening streams.
+	// If the peer opens too many streams, those are just reset.
+	delete(tmux.Subtests, "github.com/libp2p/go-libp2p-testing/suites/mux.SubtestStress1Conn1000Stream10Msg")
+
+	tr, err := New(yamux.DefaultTransport, WithConfig(Config{
+		Buckets:       DefaultBuckets,
+		CoverInterval: 10 * time.Millisecond,
+	}))
+	require.NoError(t, err)
+	tmux.SubtestAll(t, tr)
+}
+
+func TestStreamConformance(t *testing.T) {
+	tr, err := New(yamux.DefaultTransport)
+	require.NoError(t, err)
+	tmux.SubtestStreamAll(t, tmux.MultiplexerStreamPair(tr))
+}
+
+// readFrame reads a raw frame, returning its type and total size.
+func readFrame(t *testing.T, r io.Reader) (byte, int) {
+	t.Helper()
+	var hdr [headerSize]byte
+	_, err := io.ReadFull(r, hdr[:])
+	require.NoError(t, err)
+	rest := int(binary.BigEndian.Uint16(hdr[1:])) + int(binary.BigEndian.Uint16(hdr[3:]))
+	_, err = io.CopyN(io.Discard, r, int64(rest))
+	require.NoError(t, err)
+	return hdr[0], headerSize + rest
+}
+
+func TestFramePadding(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := newConn(c1, Config{Buckets: []int{64, 256}})
+	defer c.Close()
+
+	go func() {
+		c.Write(make([]byte, 10))
+		c.Write(make([]byte, 100))
+		c.Write(make([]byte, 300))
+	}()
+	// The last write is split into a full frame of the largest bucket, and the
+	// rest.
+	for _, size := range []int{64, 256, 256, 64} {
+		typ, n := readFrame(t, c2)
+		require.Equal(t, frameData, typ)
+		require.Equal(t, size, n)
+	}
+}
+
+func TestCoverTraffic(t *testing.T) {
+	c1, c2 := net.Pipe()
+	sender := newConn(c1, Config{Buckets: []int{64}, CoverInterval: 5 * time.Millisecond})
+	defer sender.Close()
+	receiver := newConn(c2, Config{})
+	defer receiver.Close()
+
+	// cover frames are skipped by the receiver
+	msg := []byte("foobar")
+	time.Sleep(50 * time.Millisecond)
+	go sender.Write(msg)
+	buf := make([]byte, 100)
+	n, err := receiver.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, msg, buf[:n])
+
+	// cover frames are only sent while idle
+	c3, c4 := net.Pipe()
+	defer c4.Close()
+	idle := newConn(c3, Config{Buckets: []int{64}, CoverInterval: 5 * time.Millisecond})
+	defer idle.Close()
+	typ, size := readFrame(t, c4)
+	require.Equal(t, frameCover, typ)
+	require.Equal(t, 64, size)
+}
+
+func TestConfigValidation(t *testing.T) {
+	for _, cfg := range []Config{
+		{Buckets: []int{1024, 256}},
+		{Buckets: []int{headerSize}},
+		{Buckets: []int{maxFrameSize + 1}},
+		{CoverInterval: -time.Second},
+	} {
+		_, err := New(yamux.DefaultTransport, WithConfig(cfg))
+		require.Error(t, err)
+	}
+
+	// Invalid configs returned by a policy reject the connection.
+	tr, err := New(yamux.DefaultTransport, WithPolicy(func(net.Conn, bool) Config {
+		return Config{Buckets: []int{1}}
+	}))
+	require.NoError(t, err)
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	_, err = tr.NewConn(c1, false, nil)
+	require.ErrorContains(t, err, "invalid shaping config")
+}
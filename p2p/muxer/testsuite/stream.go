@@ -0,0 +1,354 @@
+package mux
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"github.com/stretchr/testify/require"
+)
+
+// NewStreamPair returns two connected streams, a and b, where a was opened by
+// one side of a connection and b was accepted by the other side.
+//
+// If the peer only learns about a stream when data is sent on it (e.g. QUIC),
+// the function has to send and consume some data, so that both streams are
+// ready to use. The streams are reset when the test completes.
+type NewStreamPair func(t *testing.T) (a, b network.MuxedStream)
+
+// StreamTest is a stream conformance test case.
+type StreamTest func(t *testing.T, newPair NewStreamPair)
+
+// StreamSubtests are all the subtests run by SubtestStreamAll.
+var StreamSubtests = map[string]StreamTest{}
+
+func init() {
+	for _, f := range streamSubtests {
+		StreamSubtests[getFunctionName(f)] = f
+	}
+}
+
+var streamSubtests = []StreamTest{
+	SubtestStreamZeroLengthWrite,
+	SubtestStreamHalfClose,
+	SubtestStreamCloseFlushes,
+	SubtestStreamResetRemote,
+	SubtestStreamResetLocal,
+	SubtestStreamReadDeadline,
+	SubtestStreamWriteDeadline,
+	SubtestStreamConcurrentReadWrite,
+}
+
+// SubtestStreamAll runs the stream conformance tests. Any network.MuxedStream
+// implementation should pass them, so that protocols observe the same
+// semantics on all transports.
+func SubtestStreamAll(t *testing.T, newPair NewStreamPair) {
+	for name, f := range StreamSubtests {
+		t.Run(name, func(t *testing.T) {
+			f(t, newPair)
+		})
+	}
+}
+
+// MultiplexerStreamPair returns a NewStreamPair that runs the stream
+// multiplexer tr over a TCP connection.
+func MultiplexerStreamPair(tr network.Multiplexer) NewStreamPair {
+	return func(t *testing.T) (network.MuxedStream, network.MuxedStream) {
+		c1, c2 := tcpPipe(t)
+		client, err := tr.NewConn(c1, false, &peerScope{})
+		require.NoError(t, err)
+		t.Cleanup(func() { client.Close() })
+		server, err := tr.NewConn(c2, true, &peerScope{})
+		require.NoError(t, err)
+		t.Cleanup(func() { server.Close() })
+
+		return OpenStreamPair(t, client, server)
+	}
+}
+
+// OpenStreamPair opens a stream on the client connection, and accepts it on
+// the server connection.
+func OpenStreamPair(t *testing.T, client, server network.MuxedConn) (network.MuxedStream, network.MuxedStream) {
+	t.Helper()
+	accepted := make(chan network.MuxedStream, 1)
+	go func() {
+		str, err := server.AcceptStream()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- str
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	a, err := client.OpenStream(ctx)
+	require.NoError(t, err)
+	// Some multiplexers only announce new streams to the peer when data is sent.
+	_, err = a.Write([]byte{0})
+	require.NoError(t, err)
+	var b network.MuxedStream
+	select {
+	case b = <-accepted:
+		require.NotNil(t, b, "failed to accept stream")
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout accepting stream")
+	}
+	_, err = io.ReadFull(b, make([]byte, 1))
+	require.NoError(t, err)
+	return a, b
+}
+
+func newStreamPair(t *testing.T, newPair NewStreamPair) (network.MuxedStream, network.MuxedStream) {
+	t.Helper()
+	a, b := newPair(t)
+	t.Cleanup(func() {
+		a.Reset()
+		b.Reset()
+	})
+	return a, b
+}
+
+// readAll reads from s until EOF, failing the test if that takes too long.
+func readAll(t *testing.T, s network.MuxedStream) ([]byte, error) {
+	t.Helper()
+	require.NoError(t, s.SetReadDeadline(time.Now().Add(5*time.Second)))
+	defer s.SetReadDeadline(time.Time{})
+	return io.ReadAll(s)
+}
+
+func isTimeout(err error) bool {
+	var nerr net.Error
+	return errors.As(err, &nerr) && nerr.Timeout()
+}
+
+// SubtestStreamZeroLengthWrite checks that writing an empty slice succeeds,
+// and doesn't affect the peer.
+func SubtestStreamZeroLengthWrite(t *testing.T, newPair NewStreamPair) {
+	a, b := newStreamPair(t, newPair)
+
+	n, err := a.Write(nil)
+	require.NoError(t, err)
+	require.Zero(t, n)
+	n, err = a.Write([]byte{})
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	_, err = a.Write([]byte("foobar"))
+	require.NoError(t, err)
+	require.NoError(t, a.CloseWrite())
+	data, err := readAll(t, b)
+	require.NoError(t, err)
+	require.Equal(t, []byte("foobar"), data)
+}
+
+// SubtestStreamHalfClose checks that closing the write side of a stream
+// delivers an EOF to the peer, and that the peer can still write to the stream.
+func SubtestStreamHalfClose(t *testing.T, newPair NewStreamPair) {
+	a, b := newStreamPair(t, newPair)
+
+	_, err := a.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.NoError(t, a.CloseWrite())
+	_, err = a.Write([]byte("ping"))
+	require.Error(t, err, "expected write after CloseWrite to fail")
+
+	data, err := readAll(t, b)
+	require.NoError(t, err)
+	require.Equal(t, []byte("ping"), data)
+	// reading again returns another EOF
+	n, err := b.Read(make([]byte, 10))
+	require.Zero(t, n)
+	require.ErrorIs(t, err, io.EOF)
+
+	_, err = b.Write([]byte("pong"))
+	require.NoError(t, err)
+	require.NoError(t, b.CloseWrite())
+	data, err = readAll(t, a)
+	require.NoError(t, err)
+	require.Equal(t, []byte("pong"), data)
+
+	require.NoError(t, a.Close())
+	require.NoError(t, b.Close())
+}
+
+// SubtestStreamCloseFlushes checks that data written before Close is delivered.
+func SubtestStreamCloseFlushes(t *testing.T, newPair NewStreamPair) {
+	a, b := newStreamPair(t, newPair)
+
+	msg := randBuf(100 << 10)
+	errCh := make(chan error, 1)
+	go func() {
+		if _, err := a.Write(msg); err != nil {
+			errCh <- err
+			return
+		}
+		errCh <- a.Close()
+	}()
+	data, err := readAll(t, b)
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(msg, data), "received different data")
+	require.NoError(t, <-errCh)
+}
+
+// SubtestStreamResetRemote checks that the peer observes a network.ErrReset
+// when the stream is reset.
+func SubtestStreamResetRemote(t *testing.T, newPair NewStreamPair) {
+	a, b := newStreamPair(t, newPair)
+
+	require.NoError(t, a.Reset())
+
+	require.NoError(t, b.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err := io.ReadAll(b)
+	require.ErrorIs(t, err, network.ErrReset)
+
+	// Once the reset was received, writes fail too.
+	require.Eventually(t, func() bool {
+		_, err := b.Write([]byte("foobar"))
+		return err != nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// SubtestStreamResetLocal checks that reading and writing fail after a stream
+// was reset, and that a reset after closing the stream is a no-op.
+func SubtestStreamResetLocal(t *testing.T, newPair NewStreamPair) {
+	a, _ := newStreamPair(t, newPair)
+
+	require.NoError(t, a.Reset())
+	_, err := a.Write([]byte("foobar"))
+	require.Error(t, err)
+	_, err = a.Read(make([]byte, 10))
+	require.Error(t, err)
+	require.NotErrorIs(t, err, io.EOF)
+
+	c, _ := newStreamPair(t, newPair)
+	require.NoError(t, c.Close())
+	require.NoError(t, c.Reset())
+}
+
+// SubtestStreamReadDeadline checks that a read deadline interrupts a blocked
+// Read with a timeout error, and that the stream can still be used after
+// the deadline was removed.
+func SubtestStreamReadDeadline(t *testing.T, newPair NewStreamPair) {
+	a, b := newStreamPair(t, newPair)
+
+	require.NoError(t, b.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+	start := time.Now()
+	_, err := b.Read(make([]byte, 10))
+	require.Error(t, err)
+	require.True(t, isTimeout(err), "expected a timeout error, got %v", err)
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	// a deadline in the past makes Read fail immediately
+	require.NoError(t, b.SetReadDeadline(time.Now().Add(-time.Second)))
+	_, err = b.Read(make([]byte, 10))
+	require.True(t, isTimeout(err), "expected a timeout error, got %v", err)
+
+	require.NoError(t, b.SetReadDeadline(time.Time{}))
+	_, err = a.Write([]byte("foobar"))
+	require.NoError(t, err)
+	require.NoError(t, a.CloseWrite())
+	data, err := readAll(t, b)
+	require.NoError(t, err)
+	require.Equal(t, []byte("foobar"), data)
+}
+
+// SubtestStreamWriteDeadline checks that a write deadline interrupts a blocked
+// Write with a timeout error, and that the stream can still be used after the
+// deadline was removed.
+func SubtestStreamWriteDeadline(t *testing.T, newPair NewStreamPair) {
+	a, b := newStreamPair(t, newPair)
+
+	// The peer doesn't read, so the Write blocks until the deadline once the
+	// flow control window is exhausted.
+	require.NoError(t, a.SetWriteDeadline(time.Now().Add(100*time.Millisecond)))
+	buf := randBuf(512 << 10)
+	start := time.Now()
+	var err error
+	for {
+		_, err = a.Write(buf)
+		if err != nil {
+			break
+		}
+		require.Less(t, time.Since(start), 10*time.Second, "expected Write to block")
+	}
+	require.True(t, isTimeout(err), "expected a timeout error, got %v", err)
+
+	require.NoError(t, a.SetWriteDeadline(time.Time{}))
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, b)
+		done <- err
+	}()
+	_, err = a.Write([]byte("foobar"))
+	require.NoError(t, err)
+	require.NoError(t, a.CloseWrite())
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout reading")
+	}
+}
+
+// SubtestStreamConcurrentReadWrite checks that both sides can send large
+// amounts of data at the same time.
+func SubtestStreamConcurrentReadWrite(t *testing.T, newPair NewStreamPair) {
+	a, b := newStreamPair(t, newPair)
+
+	const size = 512 << 10
+	msgA, msgB := randBuf(size), randBuf(size)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	received := make([][]byte, 2)
+	for i, s := range []network.MuxedStream{a, b} {
+		msg := msgA
+		if s == b {
+			msg = msgB
+		}
+		wg.Add(2)
+		go func(s network.MuxedStream, msg []byte) {
+			defer wg.Done()
+			if _, err := s.Write(msg); err != nil {
+				errs <- err
+				return
+			}
+			if err := s.CloseWrite(); err != nil {
+				errs <- err
+			}
+		}(s, msg)
+		go func(i int, s network.MuxedStream) {
+			defer wg.Done()
+			data, err := io.ReadAll(s)
+			if err != nil {
+				errs <- err
+				return
+			}
+			received[i] = data
+		}(i, s)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("timeout")
+	}
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.True(t, bytes.Equal(msgB, received[0]), "a received different data")
+	require.True(t, bytes.Equal(msgA, received[1]), "b received different data")
+}
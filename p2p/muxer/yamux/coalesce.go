@@ -0,0 +1,180 @@
+package yamux
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+// Frames of the yamux protocol start with a header: version (1 byte),
+// type (1 byte), flags (2 bytes), stream ID (4 bytes) and length (4 bytes).
+const (
+	frameHeaderSize = 12
+
+	frameTypePing   = 2
+	frameTypeGoAway = 3
+)
+
+// maxCoalescedSize is the amount of buffered data after which a coalescingConn
+// writes to the underlying connection without waiting for the delay to expire.
+const maxCoalescedSize = 16 << 10
+
+// coalescingConn merges the frames written by the yamux session into fewer
+// writes on the underlying connection. The yamux session writes every frame
+// separately, so chatty protocols end up sending many small packets.
+//
+// Like Nagle's algorithm, frames written while the connection is idle are sent
+// immediately. Frames written less than delay after the last write on the
+// underlying connection are buffered until delay has passed, and sent together
+// with the frames written in the meantime. Pings, go away frames and frames of streams that
+// disabled coalescing (see stream.SetNoDelay) are written immediately,
+// together with the frames buffered before them.
+type coalescingConn struct {
+	net.Conn
+	delay time.Duration
+
+	mx        sync.Mutex
+	buf       []byte
+	timer     *time.Timer
+	lastWrite time.Time
+	// err is the error of the last write on the underlying connection. It's
+	// returned by all subsequent writes.
+	err error
+
+	noDelayMx sync.Mutex
+	noDelay   map[uint32]struct{}
+}
+
+func newCoalescingConn(nc net.Conn, delay time.Duration) *coalescingConn {
+	return &coalescingConn{
+		Conn:    nc,
+		delay:   delay,
+		noDelay: make(map[uint32]struct{}),
+	}
+}
+
+// Write is called by the send loop of the yamux session, with exactly one
+// frame at a time.
+func (c *coalescingConn) Write(b []byte) (int, error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if c.err != nil {
+		return 0, c.err
+	}
+	if len(c.buf) == 0 && (len(b) >= maxCoalescedSize || time.Since(c.lastWrite) >= c.delay || c.isUrgent(b)) {
+		return c.write(b)
+	}
+	c.buf = append(c.buf, b...)
+	if len(c.buf) >= maxCoalescedSize || c.isUrgent(b) {
+		if _, err := c.flush(); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if c.timer == nil {
+		c.timer = time.AfterFunc(c.delay-time.Since(c.lastWrite), c.flushDelayed)
+	}
+	return len(b), nil
+}
+
+// isUrgent says if the frame b must be sent without delay.
+func (c *coalescingConn) isUrgent(b []byte) bool {
+	if len(b) < frameHeaderSize {
+		return true
+	}
+	switch b[1] {
+	case frameTypePing, frameTypeGoAway:
+		return true
+	}
+	c.noDelayMx.Lock()
+	defer c.noDelayMx.Unlock()
+	_, ok := c.noDelay[binary.BigEndian.Uint32(b[4:8])]
+	return ok
+}
+
+// write writes b to the underlying connection.
+// It must be called with mx held.
+func (c *coalescingConn) write(b []byte) (int, error) {
+	c.lastWrite = time.Now()
+	n, err := c.Conn.Write(b)
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// flush writes the buffered frames to the underlying connection.
+// It must be called with mx held.
+func (c *coalescingConn) flush() (int, error) {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if len(c.buf) == 0 {
+		return 0, nil
+	}
+	n, err := c.write(c.buf)
+	c.buf = c.buf[:0]
+	return n, err
+}
+
+// flushDelayed is called when the delay of the oldest buffered frame expired.
+func (c *coalescingConn) flushDelayed() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if c.err != nil {
+		return
+	}
+	c.timer = nil
+	if _, err := c.flush(); err != nil {
+		// Nobody is waiting for this write. Close the connection, so that the
+		// session notices the error when reading from it.
+		c.Conn.Close()
+	}
+}
+
+// setNoDelay enables or disables coalescing the frames of the stream id.
+func (c *coalescingConn) setNoDelay(id uint32, noDelay bool) error {
+	c.noDelayMx.Lock()
+	if noDelay {
+		c.noDelay[id] = struct{}{}
+	} else {
+		delete(c.noDelay, id)
+	}
+	c.noDelayMx.Unlock()
+	if !noDelay {
+		return nil
+	}
+
+	// send the frames of the stream written before
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	_, err := c.flush()
+	return err
+}
+
+// removeStream forgets about the stream id, once it was closed.
+func (c *coalescingConn) removeStream(id uint32) {
+	c.noDelayMx.Lock()
+	defer c.noDelayMx.Unlock()
+	delete(c.noDelay, id)
+}
+
+// Close sends the buffered frames and closes the underlying connection. If a
+// write is in progress, the buffered frames are dropped, since closing the
+// connection must not block.
+func (c *coalescingConn) Close() error {
+	if c.mx.TryLock() {
+		if c.err == nil {
+			c.flush()
+		}
+		c.mx.Unlock()
+	}
+	return c.Conn.Close()
+}
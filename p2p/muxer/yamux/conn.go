@@ -2,6 +2,7 @@ package yamux
 
 import (
 	"context"
+	"time"
 
 	"github.com/libp2p/go-libp2p/core/network"
 
@@ -9,13 +10,22 @@ import (
 )
 
 // conn implements mux.MuxedConn over yamux.Session.
-type conn yamux.Session
+type conn struct {
+	session *yamux.Session
+	// coalescer is nil if write coalescing is disabled
+	coalescer *coalescingConn
+	// keepAlive is nil if keep-alives are disabled
+	keepAlive *keepAlive
+}
 
-var _ network.MuxedConn = &conn{}
+var (
+	_ network.MuxedConn     = &conn{}
+	_ network.KeepAliveConn = &conn{}
+)
 
 // NewMuxedConn constructs a new MuxedConn from a yamux.Session.
 func NewMuxedConn(m *yamux.Session) network.MuxedConn {
-	return (*conn)(m)
+	return &conn{session: m}
 }
 
 // Close closes underlying yamux
@@ -35,15 +45,28 @@ func (c *conn) OpenStream(ctx context.Context) (network.MuxedStream, error) {
 		return nil, err
 	}
 
-	return (*stream)(s), nil
+	return &stream{stream: s, conn: c}, nil
 }
 
 // AcceptStream accepts a stream opened by the other side.
 func (c *conn) AcceptStream() (network.MuxedStream, error) {
 	s, err := c.yamux().AcceptStream()
-	return (*stream)(s), err
+	if err != nil {
+		return nil, err
+	}
+	return &stream{stream: s, conn: c}, nil
+}
+
+// SetKeepAliveInterval changes the interval between two keep-alives of the
+// connection. A value of 0 restores the KeepAliveInterval of the transport.
+func (c *conn) SetKeepAliveInterval(d time.Duration) error {
+	if c.keepAlive == nil {
+		return network.ErrKeepAliveNotSupported
+	}
+	c.keepAlive.setInterval(d)
+	return nil
 }
 
 func (c *conn) yamux() *yamux.Session {
-	return (*yamux.Session)(c)
+	return c.session
 }
@@ -0,0 +1,62 @@
+package yamux
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-yamux/v4"
+)
+
+// keepAlive pings a session every interval, and closes the session when a
+// ping fails. It replaces the keep-alives of the session itself, whose
+// interval can't be changed once the session is created.
+type keepAlive struct {
+	session *yamux.Session
+	// defaultInterval is the KeepAliveInterval of the transport's config.
+	defaultInterval time.Duration
+	interval        atomic.Int64
+	reset           chan struct{}
+}
+
+func newKeepAlive(s *yamux.Session, interval time.Duration) *keepAlive {
+	k := &keepAlive{
+		session:         s,
+		defaultInterval: interval,
+		reset:           make(chan struct{}, 1),
+	}
+	k.interval.Store(int64(interval))
+	go k.run()
+	return k
+}
+
+func (k *keepAlive) setInterval(d time.Duration) {
+	if d <= 0 {
+		d = k.defaultInterval
+	}
+	k.interval.Store(int64(d))
+	select {
+	case k.reset <- struct{}{}:
+	default:
+	}
+}
+
+func (k *keepAlive) run() {
+	timer := time.NewTimer(time.Duration(k.interval.Load()))
+	defer timer.Stop()
+	for {
+		select {
+		case <-k.session.CloseChan():
+			return
+		case <-k.reset:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-timer.C:
+			if _, err := k.session.Ping(); err != nil {
+				k.session.Close()
+				return
+			}
+		}
+		timer.Reset(time.Duration(k.interval.Load()))
+	}
+}
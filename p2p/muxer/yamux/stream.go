@@ -9,9 +9,15 @@ import (
 )
 
 // stream implements mux.MuxedStream over yamux.Stream.
-type stream yamux.Stream
+type stream struct {
+	stream *yamux.Stream
+	conn   *conn
+}
 
-var _ network.MuxedStream = &stream{}
+var (
+	_ network.MuxedStream   = &stream{}
+	_ network.NoDelayStream = &stream{}
+)
 
 func (s *stream) Read(b []byte) (n int, err error) {
 	n, err = s.yamux().Read(b)
@@ -32,10 +38,12 @@ func (s *stream) Write(b []byte) (n int, err error) {
 }
 
 func (s *stream) Close() error {
+	s.removeFromCoalescer()
 	return s.yamux().Close()
 }
 
 func (s *stream) Reset() error {
+	s.removeFromCoalescer()
 	return s.yamux().Reset()
 }
 
@@ -59,6 +67,21 @@ func (s *stream) SetWriteDeadline(t time.Time) error {
 	return s.yamux().SetWriteDeadline(t)
 }
 
+// SetNoDelay disables coalescing the writes of the stream with the writes of
+// other streams, see Transport.NewConn.
+func (s *stream) SetNoDelay(noDelay bool) error {
+	if s.conn.coalescer == nil {
+		return network.ErrNoDelayNotSupported
+	}
+	return s.conn.coalescer.setNoDelay(s.yamux().StreamID(), noDelay)
+}
+
+func (s *stream) removeFromCoalescer() {
+	if s.conn.coalescer != nil {
+		s.conn.coalescer.removeStream(s.yamux().StreamID())
+	}
+}
+
 func (s *stream) yamux() *yamux.Stream {
-	return (*yamux.Stream)(s)
+	return s.stream
 }
@@ -30,6 +30,8 @@ func init() {
 	// Effectively disable the incoming streams limit.
 	// This is now dynamically limited by the resource manager.
 	config.MaxIncomingStreams = math.MaxUint32
+	// Write coalescing is opt-in, see Transport.NewConn.
+	config.WriteCoalesceDelay = 0
 	DefaultTransport = (*Transport)(config)
 }
 
@@ -39,23 +41,45 @@ type Transport yamux.Config
 
 var _ network.Multiplexer = &Transport{}
 
+// NewConn constructs a muxed connection over nc.
+//
+// If WriteCoalesceDelay is set, small frames are delayed for up to
+// WriteCoalesceDelay (1ms is a sensible value), and written to nc together
+// with the frames of other streams, which reduces the number of packets sent
+// by chatty protocols. Latency-critical streams bypass the delay with
+// network.NoDelayStream.
 func (t *Transport) NewConn(nc net.Conn, isServer bool, scope network.PeerScope) (network.MuxedConn, error) {
+	var coalescer *coalescingConn
+	if t.WriteCoalesceDelay > 0 {
+		coalescer = newCoalescingConn(nc, t.WriteCoalesceDelay)
+		nc = coalescer
+	}
+
 	var newSpan func() (yamux.MemoryManager, error)
 	if scope != nil {
 		newSpan = func() (yamux.MemoryManager, error) { return scope.BeginSpan() }
 	}
 
+	// Keep-alives are sent by the conn, so that their interval can be changed,
+	// see conn.SetKeepAliveInterval.
+	config := *t.Config()
+	config.EnableKeepAlive = false
+
 	var s *yamux.Session
 	var err error
 	if isServer {
-		s, err = yamux.Server(nc, t.Config(), newSpan)
+		s, err = yamux.Server(nc, &config, newSpan)
 	} else {
-		s, err = yamux.Client(nc, t.Config(), newSpan)
+		s, err = yamux.Client(nc, &config, newSpan)
 	}
 	if err != nil {
 		return nil, err
 	}
-	return NewMuxedConn(s), nil
+	c := &conn{session: s, coalescer: coalescer}
+	if t.EnableKeepAlive {
+		c.keepAlive = newKeepAlive(s, t.KeepAliveInterval)
+	}
+	return c, nil
 }
 
 func (t *Transport) Config() *yamux.Config {
@@ -1,9 +1,17 @@
 package yamux
 
 import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/libp2p/go-libp2p/core/network"
 	tmux "github.com/libp2p/go-libp2p/p2p/muxer/testsuite"
+
+	"github.com/stretchr/testify/require"
 )
 
 func TestDefaultTransport(t *testing.T) {
@@ -13,3 +21,152 @@ func TestDefaultTransport(t *testing.T) {
 
 	tmux.SubtestAll(t, DefaultTransport)
 }
+
+func TestStreamConformance(t *testing.T) {
+	tmux.SubtestStreamAll(t, tmux.MultiplexerStreamPair(DefaultTransport))
+}
+
+func coalescingTransport() *Transport {
+	tr := *DefaultTransport
+	tr.WriteCoalesceDelay = time.Millisecond
+	return &tr
+}
+
+func TestCoalescingTransport(t *testing.T) {
+	delete(tmux.Subtests, "github.com/libp2p/go-libp2p-testing/suites/mux.SubtestStress1Conn1000Stream10Msg")
+	// Every stream in this test waits for a round trip before the next one is
+	// opened, so it takes too long with the coalescing delay.
+	const openStress = "github.com/libp2p/go-libp2p/p2p/muxer/testsuite.SubtestStreamOpenStress"
+	f := tmux.Subtests[openStress]
+	delete(tmux.Subtests, openStress)
+	defer func() { tmux.Subtests[openStress] = f }()
+
+	tmux.SubtestAll(t, coalescingTransport())
+}
+
+// countingConn counts the writes on a net.Conn.
+type countingConn struct {
+	net.Conn
+	writes atomic.Int32
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(b)
+}
+
+func newConnPair(t *testing.T, tr *Transport) (*countingConn, network.MuxedConn, network.MuxedConn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := ln.Accept()
+		accepted <- c
+	}()
+	nc, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	rnc := <-accepted
+	require.NotNil(t, rnc)
+
+	cc := &countingConn{Conn: nc}
+	client, err := tr.NewConn(cc, false, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	server, err := DefaultTransport.NewConn(rnc, true, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+	return cc, client, server
+}
+
+// writeSmallFrames opens n streams on client, and writes a small message on
+// each of them at the same time. It returns the number of writes on nc.
+func writeSmallFrames(t *testing.T, nc *countingConn, client, server network.MuxedConn, n int, noDelay bool) int32 {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	streams := make([]network.MuxedStream, 0, n)
+	for i := 0; i < n; i++ {
+		str, err := client.OpenStream(ctx)
+		require.NoError(t, err)
+		defer str.Close()
+		if noDelay {
+			require.NoError(t, str.(network.NoDelayStream).SetNoDelay(true))
+		}
+		streams = append(streams, str)
+	}
+
+	done := make(chan error, n)
+	go func() {
+		for i := 0; i < n; i++ {
+			str, err := server.AcceptStream()
+			if err != nil {
+				done <- err
+				continue
+			}
+			go func() {
+				defer str.Close()
+				_, err := io.ReadFull(str, make([]byte, 5))
+				done <- err
+			}()
+		}
+	}()
+
+	start := nc.writes.Load()
+	for _, str := range streams {
+		_, err := str.Write([]byte("hello"))
+		require.NoError(t, err)
+	}
+	for i := 0; i < n; i++ {
+		require.NoError(t, <-done)
+	}
+	return nc.writes.Load() - start
+}
+
+func TestWriteCoalescing(t *testing.T) {
+	const n = 50
+
+	nc, client, server := newConnPair(t, coalescingTransport())
+	coalesced := writeSmallFrames(t, nc, client, server, n, false)
+	require.Less(t, coalesced, int32(n/2))
+
+	// streams that disabled coalescing write every frame immediately
+	nc, client, server = newConnPair(t, coalescingTransport())
+	require.GreaterOrEqual(t, writeSmallFrames(t, nc, client, server, n, true), int32(n))
+}
+
+func TestNoDelayNotSupported(t *testing.T) {
+	_, client, _ := newConnPair(t, DefaultTransport)
+	str, err := client.OpenStream(context.Background())
+	require.NoError(t, err)
+	defer str.Close()
+	require.ErrorIs(t, str.(network.NoDelayStream).SetNoDelay(true), network.ErrNoDelayNotSupported)
+}
+
+func TestSetKeepAliveInterval(t *testing.T) {
+	nc, client, _ := newConnPair(t, DefaultTransport)
+	// wait for the initial RTT measurement
+	time.Sleep(100 * time.Millisecond)
+	start := nc.writes.Load()
+	time.Sleep(200 * time.Millisecond)
+	require.Equal(t, start, nc.writes.Load(), "no keep-alives before the default interval")
+
+	require.NoError(t, client.(network.KeepAliveConn).SetKeepAliveInterval(20*time.Millisecond))
+	require.Eventually(t, func() bool { return nc.writes.Load() >= start+3 }, 5*time.Second, 10*time.Millisecond)
+	require.False(t, client.IsClosed())
+
+	require.NoError(t, client.(network.KeepAliveConn).SetKeepAliveInterval(0))
+	time.Sleep(100 * time.Millisecond)
+	sent := nc.writes.Load()
+	time.Sleep(200 * time.Millisecond)
+	require.Equal(t, sent, nc.writes.Load(), "the default interval is restored")
+}
+
+func TestKeepAliveNotSupported(t *testing.T) {
+	tr := *DefaultTransport
+	tr.EnableKeepAlive = false
+	_, client, _ := newConnPair(t, &tr)
+	require.ErrorIs(t, client.(network.KeepAliveConn).SetKeepAliveInterval(time.Second), network.ErrKeepAliveNotSupported)
+}
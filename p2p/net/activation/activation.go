@@ -0,0 +1,174 @@
+// Package activation provides listening sockets inherited from a parent
+// process, e.g. for systemd socket activation, or for a zero-downtime upgrade
+// where the old process passes its sockets to the new one.
+//
+// Sockets are passed using the systemd socket activation protocol: they are
+// file descriptors 3 to 3+LISTEN_FDS-1, and LISTEN_PID is the process ID of the
+// receiving process. A parent process that doesn't know the process ID of its
+// child (e.g. when using exec.Cmd.ExtraFiles) may leave LISTEN_PID unset.
+//
+// The TCP and QUIC transports use an inherited socket when asked to listen on
+// its exact address, see tcp.WithInheritedSockets and
+// quicreuse.WithInheritedSockets.
+package activation
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+)
+
+// listenFdsStart is the first file descriptor passed by the parent process.
+const listenFdsStart = 3
+
+// Sockets is a set of inherited listening sockets. Each socket is used at most
+// once.
+type Sockets struct {
+	mu  sync.Mutex
+	tcp []*net.TCPListener
+	udp []*net.UDPConn
+}
+
+// FromEnv returns the sockets passed to this process. It unsets the
+// environment variables, so they aren't passed on to child processes. If no
+// sockets were passed, it returns an empty set.
+func FromEnv() (*Sockets, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	if pid := os.Getenv("LISTEN_PID"); pid != "" {
+		p, err := strconv.Atoi(pid)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LISTEN_PID: %w", err)
+		}
+		if p != os.Getpid() {
+			// the sockets were meant for another process
+			return &Sockets{}, nil
+		}
+	}
+	fds := os.Getenv("LISTEN_FDS")
+	if fds == "" {
+		return &Sockets{}, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: %q", fds)
+	}
+	files := make([]*os.File, 0, n)
+	for i := 0; i < n; i++ {
+		files = append(files, os.NewFile(uintptr(listenFdsStart+i), "LISTEN_FD_"+strconv.Itoa(listenFdsStart+i)))
+	}
+	return New(files...)
+}
+
+// New returns the sockets of the given files. The files are closed, the
+// returned sockets use duplicated file descriptors. Only TCP listeners and UDP
+// sockets are supported.
+func New(files ...*os.File) (*Sockets, error) {
+	s := &Sockets{}
+	var errs []error
+	for _, f := range files {
+		if l, err := net.FileListener(f); err == nil {
+			if tl, ok := l.(*net.TCPListener); ok {
+				s.tcp = append(s.tcp, tl)
+			} else {
+				l.Close()
+				errs = append(errs, fmt.Errorf("%s: unsupported listener type %T", f.Name(), l))
+			}
+		} else if c, err := net.FilePacketConn(f); err == nil {
+			if uc, ok := c.(*net.UDPConn); ok {
+				s.udp = append(s.udp, uc)
+			} else {
+				c.Close()
+				errs = append(errs, fmt.Errorf("%s: unsupported socket type %T", f.Name(), c))
+			}
+		} else {
+			errs = append(errs, fmt.Errorf("%s: not a listening socket: %w", f.Name(), err))
+		}
+		f.Close()
+	}
+	if len(errs) > 0 {
+		s.Close()
+		return nil, errors.Join(errs...)
+	}
+	return s, nil
+}
+
+// Pass makes the given sockets available to the command, which can use FromEnv
+// to receive them. It must be called before the command is started.
+func Pass(cmd *exec.Cmd, files ...*os.File) {
+	cmd.ExtraFiles = files
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, "LISTEN_FDS="+strconv.Itoa(len(files)))
+}
+
+// TakeTCP returns the inherited TCP listener bound to laddr, and removes it
+// from the set. It returns nil if there's no such listener. Listeners are only
+// returned for exact matches: if the port of laddr is 0, nil is returned.
+func (s *Sockets) TakeTCP(network string, laddr *net.TCPAddr) *net.TCPListener {
+	if s == nil || laddr == nil || laddr.Port == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, l := range s.tcp {
+		if a := l.Addr().(*net.TCPAddr); matches(network, laddr.IP, laddr.Port, a.IP, a.Port) {
+			s.tcp = append(s.tcp[:i], s.tcp[i+1:]...)
+			return l
+		}
+	}
+	return nil
+}
+
+// TakeUDP returns the inherited UDP socket bound to laddr, and removes it from
+// the set. It returns nil if there's no such socket. Sockets are only returned
+// for exact matches: if the port of laddr is 0, nil is returned.
+func (s *Sockets) TakeUDP(network string, laddr *net.UDPAddr) *net.UDPConn {
+	if s == nil || laddr == nil || laddr.Port == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, c := range s.udp {
+		if a := c.LocalAddr().(*net.UDPAddr); matches(network, laddr.IP, laddr.Port, a.IP, a.Port) {
+			s.udp = append(s.udp[:i], s.udp[i+1:]...)
+			return c
+		}
+	}
+	return nil
+}
+
+// Len returns the number of sockets that haven't been taken yet.
+func (s *Sockets) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.tcp) + len(s.udp)
+}
+
+// Close closes the sockets that haven't been taken.
+func (s *Sockets) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for _, l := range s.tcp {
+		errs = append(errs, l.Close())
+	}
+	for _, c := range s.udp {
+		errs = append(errs, c.Close())
+	}
+	s.tcp = nil
+	s.udp = nil
+	return errors.Join(errs...)
+}
+
+func matches(network string, ip net.IP, port int, boundIP net.IP, boundPort int) bool {
+	is4 := network == "tcp4" || network == "udp4"
+	return port == boundPort && is4 == (boundIP.To4() != nil) && ip.Equal(boundIP)
+}
@@ -0,0 +1,69 @@
+package activation
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSockets(t *testing.T) {
+	tl, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	uc, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	tf, err := tl.File()
+	require.NoError(t, err)
+	uf, err := uc.File()
+	require.NoError(t, err)
+	tcpAddr := tl.Addr().(*net.TCPAddr)
+	udpAddr := uc.LocalAddr().(*net.UDPAddr)
+	// the files hold duplicated file descriptors
+	tl.Close()
+	uc.Close()
+
+	s, err := New(tf, uf)
+	require.NoError(t, err)
+	defer s.Close()
+	require.Equal(t, 2, s.Len())
+
+	require.Nil(t, s.TakeTCP("tcp4", &net.TCPAddr{IP: tcpAddr.IP}))
+	require.Nil(t, s.TakeTCP("tcp6", tcpAddr))
+	require.Nil(t, s.TakeTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2), Port: tcpAddr.Port}))
+	l := s.TakeTCP("tcp4", tcpAddr)
+	require.NotNil(t, l)
+	defer l.Close()
+	require.Nil(t, s.TakeTCP("tcp4", tcpAddr))
+	require.Equal(t, 1, s.Len())
+
+	c := s.TakeUDP("udp4", udpAddr)
+	require.NotNil(t, c)
+	defer c.Close()
+	require.Zero(t, s.Len())
+
+	// the sockets are still usable
+	conn, err := net.Dial("tcp4", tcpAddr.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	accepted, err := l.Accept()
+	require.NoError(t, err)
+	accepted.Close()
+}
+
+func TestNewUnsupportedFile(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "file")
+	require.NoError(t, err)
+	_, err = New(f)
+	require.Error(t, err)
+}
+
+func TestFromEnvOtherProcess(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	s, err := FromEnv()
+	require.NoError(t, err)
+	require.Zero(t, s.Len())
+	_, ok := os.LookupEnv("LISTEN_FDS")
+	require.False(t, ok)
+}
@@ -35,7 +35,7 @@ type BasicConnMgr struct {
 	segments segments
 
 	plk       sync.RWMutex
-	protected map[peer.ID]map[string]struct{}
+	protected map[peer.ID]map[string]protection
 
 	// channel-based semaphore that enforces only a single trim is in progress
 	trimMutex sync.Mutex
@@ -47,6 +47,9 @@ type BasicConnMgr struct {
 	lastTrimMu sync.RWMutex
 	lastTrim   time.Time
 
+	// maintainer is nil unless WithMaintainer was used.
+	maintainer *maintainer
+
 	refCount                sync.WaitGroup
 	ctx                     context.Context
 	cancel                  func()
@@ -54,8 +57,9 @@ type BasicConnMgr struct {
 }
 
 var (
-	_ connmgr.ConnManager = (*BasicConnMgr)(nil)
-	_ connmgr.Decayer     = (*BasicConnMgr)(nil)
+	_ connmgr.ConnManager       = (*BasicConnMgr)(nil)
+	_ connmgr.Decayer           = (*BasicConnMgr)(nil)
+	_ connmgr.ExpiringProtector = (*BasicConnMgr)(nil)
 )
 
 type segment struct {
@@ -107,6 +111,8 @@ func (s *segment) tagInfoFor(p peer.ID, now time.Time) *peerInfo {
 // lo and hi are watermarks governing the number of connections that'll be maintained.
 // When the peer count exceeds the 'high watermark', as many peers will be pruned (and
 // their connections terminated) until 'low watermark' peers remain.
+// With WithMaintainer, new peers are dialed when the connection count drops
+// below the 'low watermark'.
 func NewConnManager(low, hi int, opts ...Option) (*BasicConnMgr, error) {
 	cfg := &config{
 		highWater:     hi,
@@ -129,7 +135,7 @@ func NewConnManager(low, hi int, opts ...Option) (*BasicConnMgr, error) {
 	cm := &BasicConnMgr{
 		cfg:       cfg,
 		clock:     cfg.clock,
-		protected: make(map[peer.ID]map[string]struct{}, 16),
+		protected: make(map[peer.ID]map[string]protection, 16),
 		segments:  segments{},
 	}
 
@@ -139,6 +145,16 @@ func NewConnManager(low, hi int, opts ...Option) (*BasicConnMgr, error) {
 		}
 	}
 
+	if cfg.maintainer != nil {
+		if cfg.maintainer.Target == 0 {
+			cfg.maintainer.Target = low
+		}
+		if err := cfg.maintainer.validate(low, hi); err != nil {
+			return nil, err
+		}
+		cm.maintainer = &maintainer{cfg: cfg.maintainer, failed: make(map[peer.ID]time.Time)}
+	}
+
 	cm.ctx, cm.cancel = context.WithCancel(context.Background())
 
 	if cfg.emergencyTrim {
@@ -174,7 +190,7 @@ func (cm *BasicConnMgr) memoryEmergency() {
 
 	// Trim connections without paying attention to the silence period.
 	for _, c := range cm.getConnsToCloseEmergency(target) {
-		log.Infow("low on memory. closing conn", "peer", c.RemotePeer())
+		log.Infow("low on memory. closing conn", "peer", c.RemotePeer().Short())
 		c.Close()
 	}
 
@@ -196,16 +212,36 @@ func (cm *BasicConnMgr) Close() error {
 	return nil
 }
 
+// protection is a protection placed on a peer under some tag.
+type protection struct {
+	reason  string
+	expires time.Time // zero if the protection doesn't expire
+}
+
+func (p protection) expired(now time.Time) bool {
+	return !p.expires.IsZero() && !now.Before(p.expires)
+}
+
 func (cm *BasicConnMgr) Protect(id peer.ID, tag string) {
+	cm.protect(id, tag, protection{})
+}
+
+// ProtectFor protects a peer under the tag until ttl has passed, see
+// connmgr.ExpiringProtector.
+func (cm *BasicConnMgr) ProtectFor(id peer.ID, tag string, reason string, ttl time.Duration) {
+	cm.protect(id, tag, protection{reason: reason, expires: cm.clock.Now().Add(ttl)})
+}
+
+func (cm *BasicConnMgr) protect(id peer.ID, tag string, p protection) {
 	cm.plk.Lock()
 	defer cm.plk.Unlock()
 
 	tags, ok := cm.protected[id]
 	if !ok {
-		tags = make(map[string]struct{}, 2)
+		tags = make(map[string]protection, 2)
 		cm.protected[id] = tags
 	}
-	tags[tag] = struct{}{}
+	tags[tag] = p
 }
 
 func (cm *BasicConnMgr) Unprotect(id peer.ID, tag string) (protected bool) {
@@ -220,24 +256,87 @@ func (cm *BasicConnMgr) Unprotect(id peer.ID, tag string) (protected bool) {
 		delete(cm.protected, id)
 		return false
 	}
-	return true
+	return cm.isProtectedLocked(id, cm.clock.Now())
 }
 
 func (cm *BasicConnMgr) IsProtected(id peer.ID, tag string) (protected bool) {
-	cm.plk.Lock()
-	defer cm.plk.Unlock()
+	cm.plk.RLock()
+	defer cm.plk.RUnlock()
 
-	tags, ok := cm.protected[id]
-	if !ok {
-		return false
+	now := cm.clock.Now()
+	if tag == "" {
+		return cm.isProtectedLocked(id, now)
 	}
 
-	if tag == "" {
-		return true
+	p, ok := cm.protected[id][tag]
+	return ok && !p.expired(now)
+}
+
+// isProtectedLocked returns true if id has a protection that hasn't expired.
+// Must be called with plk held.
+func (cm *BasicConnMgr) isProtectedLocked(id peer.ID, now time.Time) bool {
+	for _, p := range cm.protected[id] {
+		if !p.expired(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Protections returns the protections currently placed on a peer, see
+// connmgr.ExpiringProtector.
+func (cm *BasicConnMgr) Protections(id peer.ID) []connmgr.Protection {
+	cm.plk.RLock()
+	defer cm.plk.RUnlock()
+
+	return cm.protectionsLocked(id, cm.clock.Now())
+}
+
+// ProtectedPeers returns the protections currently placed on all peers, see
+// connmgr.ExpiringProtector.
+func (cm *BasicConnMgr) ProtectedPeers() map[peer.ID][]connmgr.Protection {
+	cm.plk.RLock()
+	defer cm.plk.RUnlock()
+
+	now := cm.clock.Now()
+	res := make(map[peer.ID][]connmgr.Protection, len(cm.protected))
+	for id := range cm.protected {
+		if ps := cm.protectionsLocked(id, now); len(ps) > 0 {
+			res[id] = ps
+		}
 	}
+	return res
+}
+
+func (cm *BasicConnMgr) protectionsLocked(id peer.ID, now time.Time) []connmgr.Protection {
+	var res []connmgr.Protection
+	for tag, p := range cm.protected[id] {
+		if p.expired(now) {
+			continue
+		}
+		res = append(res, connmgr.Protection{Tag: tag, Reason: p.reason, Expires: p.expires})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Tag < res[j].Tag })
+	return res
+}
+
+// pruneProtections removes expired protections.
+func (cm *BasicConnMgr) pruneProtections() {
+	cm.plk.Lock()
+	defer cm.plk.Unlock()
 
-	_, protected = tags[tag]
-	return protected
+	now := cm.clock.Now()
+	for id, tags := range cm.protected {
+		for tag, p := range tags {
+			if p.expired(now) {
+				log.Debugw("protection expired", "peer", id.Short(), "tag", tag, "reason", p.reason)
+				delete(tags, tag)
+			}
+		}
+		if len(tags) == 0 {
+			delete(cm.protected, id)
+		}
+	}
 }
 
 func (cm *BasicConnMgr) CheckLimit(systemLimit connmgr.GetConnLimiter) error {
@@ -315,6 +414,14 @@ func (p peerInfos) SortByValueAndStreams(segments *segments, sortByMoreStreams b
 		if rightStreams != leftStreams && (leftStreams == 0 || rightStreams == 0) {
 			return leftStreams < rightStreams
 		}
+		// among inactive connections, prefer closing the ones that have been
+		// idle for longer
+		if leftStreams == 0 && rightStreams == 0 {
+			leftActive, rightActive := lastActive(left.conns), lastActive(right.conns)
+			if !leftActive.Equal(rightActive) {
+				return leftActive.Before(rightActive)
+			}
+		}
 		// incoming connections are preferred for pruning
 		if leftIncoming != rightIncoming {
 			return leftIncoming
@@ -329,6 +436,26 @@ func (p peerInfos) SortByValueAndStreams(segments *segments, sortByMoreStreams b
 	})
 }
 
+// activityConn is implemented by connections that know when a protocol was
+// last active on them, like the swarm's connections.
+type activityConn interface {
+	LastActive() time.Time
+}
+
+// lastActive returns the last time a protocol was active on one of conns. It
+// is zero if none of them know.
+func lastActive(conns map[network.Conn]time.Time) time.Time {
+	var last time.Time
+	for c := range conns {
+		if ac, ok := c.(activityConn); ok {
+			if t := ac.LastActive(); t.After(last) {
+				last = t
+			}
+		}
+	}
+	return last
+}
+
 // TrimOpenConns closes the connections of as many peers as needed to make the peer count
 // equal the low watermark. Peers are sorted in ascending order based on their total value,
 // pruning those peers with the lowest scores first, as long as they are not within their
@@ -358,6 +485,7 @@ func (cm *BasicConnMgr) background() {
 	for {
 		select {
 		case <-ticker.C:
+			cm.pruneProtections()
 			if cm.connCount.Load() < int32(cm.cfg.highWater) {
 				// Below high water, skip.
 				continue
@@ -387,7 +515,7 @@ func (cm *BasicConnMgr) doTrim() {
 func (cm *BasicConnMgr) trim() {
 	// do the actual trim.
 	for _, c := range cm.getConnsToClose() {
-		log.Debugw("closing conn", "peer", c.RemotePeer())
+		log.Debugw("closing conn", "peer", c.RemotePeer().Short())
 		c.Close()
 	}
 }
@@ -395,11 +523,12 @@ func (cm *BasicConnMgr) trim() {
 func (cm *BasicConnMgr) getConnsToCloseEmergency(target int) []network.Conn {
 	candidates := make(peerInfos, 0, cm.segments.countPeers())
 
+	now := cm.clock.Now()
 	cm.plk.RLock()
 	for _, s := range cm.segments.buckets {
 		s.Lock()
 		for id, inf := range s.peers {
-			if _, ok := cm.protected[id]; ok {
+			if cm.isProtectedLocked(id, now) {
 				// skip over protected peer.
 				continue
 			}
@@ -475,13 +604,14 @@ func (cm *BasicConnMgr) getConnsToClose() []network.Conn {
 
 	candidates := make(peerInfos, 0, cm.segments.countPeers())
 	var ncandidates int
-	gracePeriodStart := cm.clock.Now().Add(-cm.cfg.gracePeriod)
+	now := cm.clock.Now()
+	gracePeriodStart := now.Add(-cm.cfg.gracePeriod)
 
 	cm.plk.RLock()
 	for _, s := range cm.segments.buckets {
 		s.Lock()
 		for id, inf := range s.peers {
-			if _, ok := cm.protected[id]; ok {
+			if cm.isProtectedLocked(id, now) {
 				// skip over protected peer.
 				continue
 			}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/benbjohnson/clock"
+	"github.com/libp2p/go-libp2p/core/connmgr"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -696,6 +697,52 @@ func TestPeerProtectionIdempotent(t *testing.T) {
 	}
 }
 
+func TestPeerProtectionExpiry(t *testing.T) {
+	mockClock := clock.NewMock()
+	cm, err := NewConnManager(2, 3, WithGracePeriod(0), WithSilencePeriod(time.Hour), WithClock(mockClock))
+	require.NoError(t, err)
+	defer cm.Close()
+
+	not := cm.Notifee()
+	conns := make([]network.Conn, 5)
+	for i := range conns {
+		conns[i] = randConn(t, not.Disconnected)
+		not.Connected(nil, conns[i])
+	}
+	for _, c := range conns[2:] {
+		// prefer trimming p0 once its protection expired
+		cm.TagPeer(c.RemotePeer(), "test", 10)
+	}
+	p0, p1 := conns[0].RemotePeer(), conns[1].RemotePeer()
+	cm.ProtectFor(p0, "sync", "during sync session", time.Minute)
+	cm.ProtectFor(p1, "sync", "during sync session", 2*time.Minute)
+	cm.Protect(p1, "global")
+
+	require.True(t, cm.IsProtected(p0, "sync"))
+	require.Equal(t, []connmgr.Protection{
+		{Tag: "global"},
+		{Tag: "sync", Reason: "during sync session", Expires: mockClock.Now().Add(2 * time.Minute)},
+	}, cm.Protections(p1))
+
+	mockClock.Add(time.Minute)
+	require.False(t, cm.IsProtected(p0, ""))
+	require.Empty(t, cm.Protections(p0))
+	require.Len(t, cm.ProtectedPeers(), 1)
+	require.True(t, cm.Unprotect(p1, "global"), "expected the peer to remain protected by the sync tag")
+
+	// The expired protection doesn't prevent trimming.
+	cm.TrimOpenConns(context.Background())
+	require.True(t, conns[0].(*tconn).isClosed())
+	require.False(t, conns[1].(*tconn).isClosed())
+
+	mockClock.Add(time.Minute)
+	require.False(t, cm.IsProtected(p1, "sync"))
+	require.Empty(t, cm.ProtectedPeers())
+
+	cm.pruneProtections()
+	require.Empty(t, cm.protected)
+}
+
 func TestUpsertTag(t *testing.T) {
 	cm, err := NewConnManager(1, 1, WithGracePeriod(10*time.Minute))
 	require.NoError(t, err)
@@ -790,7 +837,8 @@ func TestConcurrentCleanupAndTagging(t *testing.T) {
 }
 
 type mockConn struct {
-	stats network.ConnStats
+	stats      network.ConnStats
+	lastActive time.Time
 }
 
 func (m mockConn) Close() error                                          { panic("implement me") }
@@ -806,6 +854,7 @@ func (m mockConn) NewStream(ctx context.Context) (network.Stream, error) { panic
 func (m mockConn) GetStreams() []network.Stream                          { panic("implement me") }
 func (m mockConn) Scope() network.ConnScope                              { panic("implement me") }
 func (m mockConn) ConnState() network.ConnectionState                    { return network.ConnectionState{} }
+func (m mockConn) LastActive() time.Time                                 { return m.lastActive }
 
 func makeSegmentsWithPeerInfos(peerInfos peerInfos) *segments {
 	var s = func() *segments {
@@ -861,6 +910,29 @@ func TestPeerInfoSorting(t *testing.T) {
 		require.Equal(t, peerInfos{p1, p2}, pis)
 	})
 
+	t.Run("prefer peers that have been idle for longer", func(t *testing.T) {
+		now := time.Now()
+		p1 := &peerInfo{id: peer.ID("peer1"),
+			conns: map[network.Conn]time.Time{
+				&mockConn{lastActive: now.Add(-time.Minute)}: now,
+				&mockConn{lastActive: now}:                   now,
+			},
+		}
+		p2 := &peerInfo{id: peer.ID("peer2"),
+			conns: map[network.Conn]time.Time{
+				&mockConn{lastActive: now.Add(-time.Hour)}: now,
+			},
+		}
+		p3 := &peerInfo{id: peer.ID("peer3"),
+			conns: map[network.Conn]time.Time{
+				&mockConn{lastActive: now.Add(-time.Minute)}: now,
+			},
+		}
+		pis := peerInfos{p1, p3, p2}
+		pis.SortByValueAndStreams(makeSegmentsWithPeerInfos(pis), false)
+		require.Equal(t, peerInfos{p2, p3, p1}, pis)
+	})
+
 	t.Run("in a memory emergency, starts with incoming connections and higher streams", func(t *testing.T) {
 		incoming := network.ConnStats{}
 		incoming.Direction = network.DirInbound
@@ -0,0 +1,192 @@
+package connmgr
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+)
+
+// PeerSource returns a channel of candidates to dial when the connection count
+// is below the low watermark, e.g. from a bootstrap cache or peer discovery.
+// num is the number of connections missing, the source may return more or
+// fewer candidates. The source must close the channel when it has no more
+// candidates, or when ctx is done.
+type PeerSource func(ctx context.Context, num int) <-chan peer.AddrInfo
+
+// MaintainerCfg is the configuration of the connection maintainer. When the
+// number of connections drops below the low watermark, the maintainer asks
+// the PeerSource for candidates and dials them, until the Target is reached.
+type MaintainerCfg struct {
+	// PeerSource provides the candidates to dial. It is required.
+	PeerSource PeerSource
+	// Target is the number of connections the maintainer dials up to. It
+	// defaults to the low watermark, and must lie between the low and the
+	// high watermark.
+	Target int
+	// Interval is the interval between two checks of the connection count.
+	Interval time.Duration
+	// Jitter is the maximum random duration added to the Interval, so that
+	// many nodes restarted at the same time don't dial in lockstep.
+	Jitter time.Duration
+	// MaxDials is the maximum number of dials started per check.
+	MaxDials int
+	// DialTimeout is the timeout of a single check, including querying the
+	// PeerSource and dialing the candidates.
+	DialTimeout time.Duration
+	// FailureBackoff is the time a candidate isn't dialed again after a failed
+	// dial.
+	FailureBackoff time.Duration
+}
+
+// WithDefaults writes the default values on this MaintainerCfg instance,
+// and returns itself for chainability.
+//
+//	cfg := (&MaintainerCfg{PeerSource: src}).WithDefaults()
+//	cfg.MaxDials = 4
+//	cm, err := NewConnManager(low, hi, WithMaintainer(cfg))
+func (cfg *MaintainerCfg) WithDefaults() *MaintainerCfg {
+	cfg.Interval = 30 * time.Second
+	cfg.Jitter = 10 * time.Second
+	cfg.MaxDials = 8
+	cfg.DialTimeout = 30 * time.Second
+	cfg.FailureBackoff = 5 * time.Minute
+	return cfg
+}
+
+func (cfg *MaintainerCfg) validate(low, high int) error {
+	if cfg.PeerSource == nil {
+		return errors.New("maintainer requires a peer source")
+	}
+	if cfg.Target < low || cfg.Target > high {
+		return errors.New("maintainer target must lie between the low and the high watermark")
+	}
+	if cfg.Interval <= 0 || cfg.DialTimeout <= 0 {
+		return errors.New("maintainer interval and dial timeout must be positive")
+	}
+	if cfg.Jitter < 0 || cfg.FailureBackoff < 0 {
+		return errors.New("maintainer jitter and failure backoff must be non-negative")
+	}
+	if cfg.MaxDials <= 0 {
+		return errors.New("maintainer must be allowed to dial at least one peer")
+	}
+	return nil
+}
+
+// maintainer keeps track of the candidates that recently failed to dial.
+type maintainer struct {
+	cfg *MaintainerCfg
+
+	startOnce sync.Once
+
+	mu     sync.Mutex
+	failed map[peer.ID]time.Time // time of the last failed dial
+}
+
+// StartMaintainer starts dialing candidates on n when the connection count
+// drops below the low watermark. It is a no-op unless the connection manager
+// was configured with WithMaintainer, and if the maintainer was already
+// started. The maintainer stops when the connection manager is closed.
+//
+// The basic host calls StartMaintainer when it is started.
+func (cm *BasicConnMgr) StartMaintainer(n network.Network) {
+	m := cm.maintainer
+	if m == nil {
+		return
+	}
+	m.startOnce.Do(func() {
+		cm.refCount.Add(1)
+		go cm.maintain(n)
+	})
+}
+
+func (cm *BasicConnMgr) maintain(n network.Network) {
+	defer cm.refCount.Done()
+
+	cfg := cm.maintainer.cfg
+	// Check right away, we might have started without any connection.
+	timer := cm.clock.Timer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-cm.ctx.Done():
+			return
+		}
+		cm.maintainOnce(n)
+
+		d := cfg.Interval
+		if cfg.Jitter > 0 {
+			d += time.Duration(rand.Int63n(int64(cfg.Jitter)))
+		}
+		timer.Reset(d)
+	}
+}
+
+// maintainOnce dials up to MaxDials candidates if we're below the low
+// watermark, and waits for the dials to complete.
+func (cm *BasicConnMgr) maintainOnce(n network.Network) {
+	m := cm.maintainer
+	connCount := int(cm.connCount.Load())
+	if connCount >= cm.cfg.lowWater {
+		return
+	}
+	missing := min(m.cfg.Target-connCount, m.cfg.MaxDials)
+	log.Debugw("below low watermark, dialing candidates", "conns", connCount, "low watermark", cm.cfg.lowWater, "num", missing)
+
+	ctx, cancel := context.WithTimeout(cm.ctx, m.cfg.DialTimeout)
+	defer cancel()
+	// The source is canceled as soon as we have enough candidates, the dials
+	// continue until ctx is done.
+	srcCtx, srcCancel := context.WithCancel(ctx)
+	defer srcCancel()
+
+	now := cm.clock.Now()
+	m.mu.Lock()
+	for p, t := range m.failed {
+		if now.Sub(t) >= m.cfg.FailureBackoff {
+			delete(m.failed, p)
+		}
+	}
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	dialing := make(peer.IDSet, missing)
+	for pi := range m.cfg.PeerSource(srcCtx, missing) {
+		if dialing.Len() == missing {
+			srcCancel()
+			continue
+		}
+		if pi.ID == n.LocalPeer() || n.Connectedness(pi.ID) == network.Connected {
+			continue
+		}
+		if dialing.Contains(pi.ID) {
+			continue
+		}
+		m.mu.Lock()
+		_, failed := m.failed[pi.ID]
+		m.mu.Unlock()
+		if failed {
+			continue
+		}
+
+		dialing.Add(pi.ID)
+		wg.Add(1)
+		go func(pi peer.AddrInfo) {
+			defer wg.Done()
+			n.Peerstore().AddAddrs(pi.ID, pi.Addrs, peerstore.TempAddrTTL)
+			if _, err := n.DialPeer(ctx, pi.ID); err != nil {
+				log.Debugw("failed to dial candidate", "peer", pi.ID.Short(), "error", err)
+				m.mu.Lock()
+				m.failed[pi.ID] = cm.clock.Now()
+				m.mu.Unlock()
+			}
+		}(pi)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,152 @@
+package connmgr
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	tu "github.com/libp2p/go-libp2p/core/test"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+// mockDialNetwork "dials" peers by notifying the connection manager about a
+// new connection. Dials to the peers in fail return an error.
+type mockDialNetwork struct {
+	network.Network
+
+	cm    *BasicConnMgr
+	local peer.ID
+	ps    peerstore.Peerstore
+	fail  map[peer.ID]bool
+
+	mu     sync.Mutex
+	conns  map[peer.ID]network.Conn
+	dialed map[peer.ID]int
+}
+
+func newMockDialNetwork(t *testing.T, cm *BasicConnMgr) *mockDialNetwork {
+	ps, err := pstoremem.NewPeerstore()
+	require.NoError(t, err)
+	t.Cleanup(func() { ps.Close() })
+	return &mockDialNetwork{
+		cm:     cm,
+		local:  tu.RandPeerIDFatal(t),
+		ps:     ps,
+		fail:   make(map[peer.ID]bool),
+		conns:  make(map[peer.ID]network.Conn),
+		dialed: make(map[peer.ID]int),
+	}
+}
+
+func (n *mockDialNetwork) LocalPeer() peer.ID             { return n.local }
+func (n *mockDialNetwork) Peerstore() peerstore.Peerstore { return n.ps }
+
+func (n *mockDialNetwork) Connectedness(p peer.ID) network.Connectedness {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.conns[p]; ok {
+		return network.Connected
+	}
+	return network.NotConnected
+}
+
+func (n *mockDialNetwork) DialPeer(_ context.Context, p peer.ID) (network.Conn, error) {
+	n.mu.Lock()
+	n.dialed[p]++
+	n.mu.Unlock()
+	if len(n.ps.Addrs(p)) == 0 {
+		return nil, errors.New("no addresses")
+	}
+	if n.fail[p] {
+		return nil, errors.New("dial failed")
+	}
+	c := &tconn{peer: p}
+	n.mu.Lock()
+	n.conns[p] = c
+	n.mu.Unlock()
+	n.cm.Notifee().Connected(n, c)
+	return c, nil
+}
+
+func (n *mockDialNetwork) dialCount(p peer.ID) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.dialed[p]
+}
+
+func TestMaintainerConfig(t *testing.T) {
+	src := func(context.Context, int) <-chan peer.AddrInfo { return nil }
+
+	_, err := NewConnManager(2, 4, WithMaintainer((&MaintainerCfg{}).WithDefaults()))
+	require.Error(t, err)
+	_, err = NewConnManager(2, 4, WithMaintainer((&MaintainerCfg{PeerSource: src, Target: 5}).WithDefaults()))
+	require.Error(t, err)
+
+	cm, err := NewConnManager(2, 4, WithMaintainer((&MaintainerCfg{PeerSource: src}).WithDefaults()))
+	require.NoError(t, err)
+	defer cm.Close()
+	require.Equal(t, 2, cm.maintainer.cfg.Target)
+}
+
+func TestMaintainer(t *testing.T) {
+	candidates := make([]peer.AddrInfo, 6)
+	for i := range candidates {
+		candidates[i] = peer.AddrInfo{ID: tu.RandPeerIDFatal(t), Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/tcp/1234")}}
+	}
+	var queries sync.WaitGroup
+	src := func(ctx context.Context, num int) <-chan peer.AddrInfo {
+		ch := make(chan peer.AddrInfo)
+		queries.Add(1)
+		go func() {
+			defer queries.Done()
+			defer close(ch)
+			for _, pi := range candidates {
+				select {
+				case ch <- pi:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return ch
+	}
+
+	cfg := (&MaintainerCfg{PeerSource: src, Target: 3}).WithDefaults()
+	cfg.Interval = 10 * time.Millisecond
+	cfg.Jitter = 0
+	cfg.MaxDials = 2
+	cm, err := NewConnManager(2, 5, WithMaintainer(cfg), WithGracePeriod(0))
+	require.NoError(t, err)
+	defer cm.Close()
+
+	n := newMockDialNetwork(t, cm)
+	n.fail[candidates[0].ID] = true
+
+	cm.StartMaintainer(n)
+	cm.StartMaintainer(n) // no-op
+
+	// At most MaxDials dials per round, until the target is reached.
+	require.Eventually(t, func() bool { return cm.GetInfo().ConnCount == 3 }, 5*time.Second, 10*time.Millisecond)
+
+	// The failed candidate is in backoff, connected peers aren't dialed again.
+	require.Equal(t, 1, n.dialCount(candidates[0].ID))
+	for _, pi := range candidates[1:4] {
+		require.Equal(t, 1, n.dialCount(pi.ID))
+	}
+	require.Zero(t, n.dialCount(candidates[5].ID))
+
+	// Above the low watermark, nothing is dialed.
+	time.Sleep(50 * time.Millisecond)
+	require.Zero(t, n.dialCount(candidates[5].ID))
+
+	cm.Close()
+	queries.Wait()
+}
@@ -15,6 +15,7 @@ type config struct {
 	silencePeriod time.Duration
 	decayer       *DecayerCfg
 	emergencyTrim bool
+	maintainer    *MaintainerCfg
 	clock         clock.Clock
 }
 
@@ -29,6 +30,15 @@ func DecayerConfig(opts *DecayerCfg) Option {
 	}
 }
 
+// WithMaintainer enables dialing new peers when the number of connections
+// drops below the low watermark. See MaintainerCfg for details.
+func WithMaintainer(cfg *MaintainerCfg) Option {
+	return func(c *config) error {
+		c.maintainer = cfg
+		return nil
+	}
+}
+
 // WithClock sets the internal clock impl
 func WithClock(c clock.Clock) Option {
 	return func(cfg *config) error {
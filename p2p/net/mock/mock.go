@@ -7,8 +7,8 @@ import (
 var log = logging.Logger("mocknet")
 
 // WithNPeers constructs a Mocknet with N peers.
-func WithNPeers(n int) (Mocknet, error) {
-	m := New()
+func WithNPeers(n int, opts ...Option) (Mocknet, error) {
+	m := New(opts...)
 	for i := 0; i < n; i++ {
 		if _, err := m.GenPeer(); err != nil {
 			return nil, err
@@ -20,8 +20,8 @@ func WithNPeers(n int) (Mocknet, error) {
 // FullMeshLinked constructs a Mocknet with full mesh of Links.
 // This means that all the peers **can** connect to each other
 // (not that they already are connected. you can use m.ConnectAll())
-func FullMeshLinked(n int) (Mocknet, error) {
-	m, err := WithNPeers(n)
+func FullMeshLinked(n int, opts ...Option) (Mocknet, error) {
+	m, err := WithNPeers(n, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -35,8 +35,8 @@ func FullMeshLinked(n int) (Mocknet, error) {
 // FullMeshConnected constructs a Mocknet with full mesh of Connections.
 // This means that all the peers have dialed and are ready to talk to
 // each other.
-func FullMeshConnected(n int) (Mocknet, error) {
-	m, err := FullMeshLinked(n)
+func FullMeshConnected(n int, opts ...Option) (Mocknet, error) {
+	m, err := FullMeshLinked(n, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -16,6 +16,7 @@ import (
 	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
 	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
 	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+	"github.com/libp2p/go-libp2p/p2p/p2ptest"
 
 	ma "github.com/multiformats/go-multiaddr"
 )
@@ -37,17 +38,34 @@ type mocknet struct {
 
 	linkDefaults LinkOptions
 
+	// rand is used to generate the keys of the peers, if set
+	rand *p2ptest.Rand
+
 	ctxCancel context.CancelFunc
 	ctx       context.Context
 	sync.Mutex
 }
 
-func New() Mocknet {
+// Option is an option that can be passed when constructing a Mocknet.
+type Option func(*mocknet)
+
+// WithRand makes the Mocknet generate the keys of its peers using r, so that
+// the peer IDs (and addresses) are reproducible. The peers get Ed25519 keys.
+func WithRand(r *p2ptest.Rand) Option {
+	return func(mn *mocknet) {
+		mn.rand = r
+	}
+}
+
+func New(opts ...Option) Mocknet {
 	mn := &mocknet{
 		nets:  map[peer.ID]*peernet{},
 		hosts: map[peer.ID]host.Host{},
 		links: map[peer.ID]map[peer.ID]map[*link]struct{}{},
 	}
+	for _, opt := range opts {
+		opt(mn)
+	}
 	mn.ctx, mn.ctxCancel = context.WithCancel(context.Background())
 	return mn
 }
@@ -71,7 +89,7 @@ func (mn *mocknet) GenPeerWithOptions(opts PeerOptions) (host.Host, error) {
 	if err := mn.addDefaults(&opts); err != nil {
 		return nil, err
 	}
-	sk, _, err := ic.GenerateECDSAKeyPair(rand.Reader)
+	sk, err := mn.genKey()
 	if err != nil {
 		return nil, err
 	}
@@ -111,6 +129,16 @@ func (mn *mocknet) GenPeerWithOptions(opts PeerOptions) (host.Host, error) {
 	return h, nil
 }
 
+func (mn *mocknet) genKey() (ic.PrivKey, error) {
+	if mn.rand != nil {
+		// ECDSA key generation isn't deterministic.
+		sk, _, err := mn.rand.KeyPair(ic.Ed25519, -1)
+		return sk, err
+	}
+	sk, _, err := ic.GenerateECDSAKeyPair(rand.Reader)
+	return sk, err
+}
+
 func (mn *mocknet) AddPeer(k ic.PrivKey, a ma.Multiaddr) (host.Host, error) {
 	ps, err := pstoremem.NewPeerstore()
 	if err != nil {
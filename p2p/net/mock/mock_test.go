@@ -18,6 +18,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/p2p/net/conngater"
+	"github.com/libp2p/go-libp2p/p2p/p2ptest"
 	manet "github.com/multiformats/go-multiaddr/net"
 
 	"github.com/libp2p/go-libp2p-testing/ci"
@@ -304,6 +305,19 @@ func TestNetworkSetup(t *testing.T) {
 
 }
 
+func TestSeededPeers(t *testing.T) {
+	peerIDs := func(r *p2ptest.Rand) []peer.ID {
+		mn, err := FullMeshLinked(3, WithRand(r))
+		require.NoError(t, err)
+		defer mn.Close()
+		return mn.Peers()
+	}
+	ids := peerIDs(p2ptest.New(t, p2ptest.WithSeed(42)))
+	require.Len(t, ids, 3)
+	require.Equal(t, ids, peerIDs(p2ptest.New(t, p2ptest.WithSeed(42))))
+	require.NotEqual(t, ids, peerIDs(p2ptest.New(t, p2ptest.WithSeed(43))))
+}
+
 func TestStreams(t *testing.T) {
 	ctx := context.Background()
 
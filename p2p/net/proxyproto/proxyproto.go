@@ -0,0 +1,336 @@
+// Package proxyproto implements the receiving side of the PROXY protocol
+// (versions 1 and 2), which L4 load balancers and reverse proxies use to pass
+// the address of the client to the server they forward a TCP connection to.
+// See https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt.
+//
+// The TCP and WebSocket transports use it to see the addresses of clients
+// connecting through a load balancer, see tcp.WithProxyProtocol and
+// websocket.WithProxyProtocol.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("proxyproto")
+
+// HeaderTimeout is the time a connection has to send its PROXY header.
+var HeaderTimeout = 10 * time.Second
+
+var (
+	sigV1 = []byte("PROXY ")
+	sigV2 = []byte{0x0d, 0x0a, 0x0d, 0x0a, 0x00, 0x0d, 0x0a, 0x51, 0x55, 0x49, 0x54, 0x0a}
+)
+
+// maxV1HeaderLen is the maximum length of a version 1 header, including the CRLF.
+const maxV1HeaderLen = 107
+
+// ErrNoHeader is returned when a connection doesn't start with a PROXY header.
+var ErrNoHeader = errors.New("no PROXY protocol header")
+
+// Listener is a net.Listener that reads the PROXY header of the accepted
+// connections, and uses the source address from the header as the remote
+// address of the connection.
+//
+// Only connections from trusted addresses (i.e. from the load balancer) are
+// required to send a header. Connections from other addresses are accepted
+// unchanged, so the header can't be used to spoof the address. Connections
+// from trusted addresses that don't send a valid header within HeaderTimeout
+// are closed. The headers are read concurrently, so a slow connection doesn't
+// hold up the others.
+type Listener struct {
+	net.Listener
+	trusted []*net.IPNet
+
+	incoming  chan net.Conn
+	closeOnce sync.Once
+	closed    chan struct{}
+	err       error // set before incoming is closed
+
+	mu      sync.Mutex
+	pending map[net.Conn]struct{} // connections whose header is being read
+}
+
+// NewListener wraps l. Connections from the trusted networks must send a
+// PROXY header. If no trusted networks are given, all connections must.
+func NewListener(l net.Listener, trusted ...*net.IPNet) *Listener {
+	pl := &Listener{
+		Listener: l,
+		trusted:  trusted,
+		incoming: make(chan net.Conn),
+		closed:   make(chan struct{}),
+		pending:  make(map[net.Conn]struct{}),
+	}
+	go pl.acceptLoop()
+	return pl
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	if len(l.trusted) == 0 {
+		return true
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range l.trusted {
+		if n.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Listener) acceptLoop() {
+	var wg sync.WaitGroup
+	defer func() {
+		wg.Wait()
+		close(l.incoming)
+	}()
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			l.err = err
+			return
+		}
+		if !l.isTrusted(c.RemoteAddr()) {
+			l.deliver(c)
+			continue
+		}
+		l.mu.Lock()
+		if l.pending == nil { // closed
+			l.mu.Unlock()
+			c.Close()
+			continue
+		}
+		l.pending[c] = struct{}{}
+		l.mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pc, err := readHeader(c)
+			l.mu.Lock()
+			delete(l.pending, c)
+			l.mu.Unlock()
+			if err != nil {
+				log.Debugw("failed to read PROXY header", "remote", c.RemoteAddr(), "error", err)
+				c.Close()
+				return
+			}
+			l.deliver(pc)
+		}()
+	}
+}
+
+func (l *Listener) deliver(c net.Conn) {
+	select {
+	case l.incoming <- c:
+	case <-l.closed:
+		c.Close()
+	}
+}
+
+// Accept returns the next connection, after its PROXY header has been read.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, ok := <-l.incoming
+	if !ok {
+		return nil, l.err
+	}
+	return c, nil
+}
+
+// Close closes the listener, and the connections whose header hasn't been
+// read yet.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		l.mu.Lock()
+		for c := range l.pending {
+			c.Close()
+		}
+		l.pending = nil
+		l.mu.Unlock()
+	})
+	return l.Listener.Close()
+}
+
+// Conn is a connection that was forwarded by a proxy.
+type Conn struct {
+	net.Conn
+	r          *bufio.Reader
+	remoteAddr net.Addr
+}
+
+// Read reads from the connection, after the PROXY header.
+func (c *Conn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the client address sent by the proxy.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// ProxyAddr returns the address of the proxy.
+func (c *Conn) ProxyAddr() net.Addr {
+	return c.Conn.RemoteAddr()
+}
+
+// readHeader reads the PROXY header from c. The returned connection uses
+// the source address from the header as its remote address, or the address
+// of the proxy if the header doesn't contain an address (e.g. for health
+// checks).
+func readHeader(c net.Conn) (*Conn, error) {
+	if err := c.SetReadDeadline(time.Now().Add(HeaderTimeout)); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReaderSize(c, 256)
+	addr, err := parseHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	pc := &Conn{Conn: c, r: r, remoteAddr: c.RemoteAddr()}
+	if addr != nil {
+		pc.remoteAddr = addr
+	}
+	return pc, nil
+}
+
+// parseHeader parses a version 1 or version 2 header. It returns a nil
+// address if the header doesn't contain an address.
+func parseHeader(r *bufio.Reader) (*net.TCPAddr, error) {
+	b, err := r.Peek(len(sigV1))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(b, sigV1) {
+		return parseV1(r)
+	}
+	// don't wait for more data if this can't be a version 2 header
+	if !bytes.HasPrefix(sigV2, b) {
+		return nil, ErrNoHeader
+	}
+	b, err = r.Peek(len(sigV2))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(b, sigV2) {
+		return parseV2(r)
+	}
+	return nil, ErrNoHeader
+}
+
+// parseV1 parses a header like "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func parseV1(r *bufio.Reader) (*net.TCPAddr, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= maxV1HeaderLen {
+			return nil, errors.New("PROXY header too long")
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("PROXY header not terminated by CRLF")
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("invalid PROXY header: %q", line)
+	}
+	ip := net.ParseIP(fields[2])
+	if ip == nil {
+		return nil, fmt.Errorf("invalid source address: %q", fields[2])
+	}
+	switch fields[1] {
+	case "TCP4":
+		if ip.To4() == nil {
+			return nil, fmt.Errorf("invalid IPv4 source address: %q", fields[2])
+		}
+	case "TCP6":
+		if ip.To4() != nil {
+			return nil, fmt.Errorf("invalid IPv6 source address: %q", fields[2])
+		}
+	default:
+		return nil, fmt.Errorf("unsupported protocol: %q", fields[1])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source port: %q", fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+const (
+	cmdLocal   = 0x0
+	cmdProxy   = 0x1
+	famTCPv4   = 0x11
+	famTCPv6   = 0x21
+	v2AddrLen4 = 12
+	v2AddrLen6 = 36
+)
+
+// parseV2 parses a binary header.
+func parseV2(r *bufio.Reader) (*net.TCPAddr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if version := hdr[12] >> 4; version != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version: %d", version)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	switch cmd := hdr[12] & 0xf; cmd {
+	case cmdLocal:
+		return nil, nil
+	case cmdProxy:
+	default:
+		return nil, fmt.Errorf("unsupported PROXY command: %d", cmd)
+	}
+	switch hdr[13] {
+	case famTCPv4:
+		if len(payload) < v2AddrLen4 {
+			return nil, errors.New("PROXY header too short")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[:4]), Port: int(binary.BigEndian.Uint16(payload[8:]))}, nil
+	case famTCPv6:
+		if len(payload) < v2AddrLen6 {
+			return nil, errors.New("PROXY header too short")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[:16]), Port: int(binary.BigEndian.Uint16(payload[32:]))}, nil
+	default:
+		// UDP and Unix sockets aren't forwarded to us, and we can't represent
+		// unspecified addresses.
+		return nil, nil
+	}
+}
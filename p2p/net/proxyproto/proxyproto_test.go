@@ -0,0 +1,127 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func v2Header(cmd, fam byte, addrs []byte) []byte {
+	b := append([]byte{}, sigV2...)
+	b = append(b, 0x20|cmd, fam)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(addrs)))
+	return append(b, addrs...)
+}
+
+func TestParseHeader(t *testing.T) {
+	v4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb}
+	v6 := append(append(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")...), 0xdc, 0x04, 0x01, 0xbb)
+	for _, tc := range []struct {
+		name   string
+		header []byte
+		addr   string // empty if no address is expected
+		err    bool
+	}{
+		{name: "v1 TCP4", header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"), addr: "192.0.2.1:56324"},
+		{name: "v1 TCP6", header: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"), addr: "[2001:db8::1]:56324"},
+		{name: "v1 UNKNOWN", header: []byte("PROXY UNKNOWN\r\n")},
+		{name: "v1 family mismatch", header: []byte("PROXY TCP6 192.0.2.1 198.51.100.1 56324 443\r\n"), err: true},
+		{name: "v1 missing CRLF", header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\n"), err: true},
+		{name: "v1 too long", header: append([]byte("PROXY "), bytes.Repeat([]byte("x"), 200)...), err: true},
+		{name: "v2 TCP4", header: v2Header(cmdProxy, famTCPv4, v4), addr: "192.0.2.1:56324"},
+		{name: "v2 TCP6", header: v2Header(cmdProxy, famTCPv6, v6), addr: "[2001:db8::1]:56324"},
+		{name: "v2 TCP4 with TLVs", header: v2Header(cmdProxy, famTCPv4, append(v4, 0x04, 0x00, 0x01, 0x00)), addr: "192.0.2.1:56324"},
+		{name: "v2 LOCAL", header: v2Header(cmdLocal, 0, nil)},
+		{name: "v2 short", header: v2Header(cmdProxy, famTCPv4, v4[:8]), err: true},
+		{name: "no header", header: []byte("GET / HTTP/1.1\r\n"), err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := bufio.NewReader(io.MultiReader(bytes.NewReader(tc.header), bytes.NewReader([]byte("payload"))))
+			addr, err := parseHeader(r)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if tc.addr == "" {
+				require.Nil(t, addr)
+			} else {
+				require.Equal(t, tc.addr, addr.String())
+			}
+			rest, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, "payload", string(rest))
+		})
+	}
+}
+
+func TestListener(t *testing.T) {
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ln := NewListener(nl)
+	defer ln.Close()
+
+	dial := func(data string) net.Conn {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+		_, err = c.Write([]byte(data))
+		require.NoError(t, err)
+		return c
+	}
+
+	// a connection that doesn't send its header yet doesn't hold up the others
+	dial("PROX")
+	// a connection without a header is closed
+	invalid := dial("hello world")
+	dial("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nhello")
+
+	c, err := ln.Accept()
+	require.NoError(t, err)
+	defer c.Close()
+	require.Equal(t, "192.0.2.1:56324", c.RemoteAddr().String())
+	require.Equal(t, nl.Addr(), c.LocalAddr())
+	b := make([]byte, 5)
+	_, err = io.ReadFull(c, b)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+
+	invalid.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = invalid.Read(b)
+	require.ErrorIs(t, err, io.EOF)
+
+	ln.Close()
+	_, err = ln.Accept()
+	require.Error(t, err)
+}
+
+func TestListenerUntrusted(t *testing.T) {
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, trusted, err := net.ParseCIDR("192.0.2.0/24")
+	require.NoError(t, err)
+	ln := NewListener(nl, trusted)
+	defer ln.Close()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+	_, err = c.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"))
+	require.NoError(t, err)
+
+	// the header of connections from untrusted addresses isn't parsed
+	sc, err := ln.Accept()
+	require.NoError(t, err)
+	defer sc.Close()
+	require.Equal(t, c.LocalAddr().String(), sc.RemoteAddr().String())
+	b := make([]byte, 6)
+	_, err = io.ReadFull(sc, b)
+	require.NoError(t, err)
+	require.Equal(t, "PROXY ", string(b))
+}
@@ -2,6 +2,7 @@ package reuseport
 
 import (
 	"context"
+	"net"
 
 	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr/net"
@@ -20,6 +21,21 @@ func (t *Transport) Dial(raddr ma.Multiaddr) (manet.Conn, error) {
 
 // DialContext is like Dial but takes a context.
 func (t *Transport) DialContext(ctx context.Context, raddr ma.Multiaddr) (manet.Conn, error) {
+	return t.dial(ctx, raddr, nil)
+}
+
+// DialContextFrom is like DialContext, but dials from the local IP address
+// laddr (e.g. /ip4/1.2.3.4). If we're listening on that IP address (or on the
+// unspecified address), the listener's port is reused as the source port.
+func (t *Transport) DialContextFrom(ctx context.Context, raddr, laddr ma.Multiaddr) (manet.Conn, error) {
+	ip, err := manet.ToIP(laddr)
+	if err != nil {
+		return nil, err
+	}
+	return t.dial(ctx, raddr, ip)
+}
+
+func (t *Transport) dial(ctx context.Context, raddr ma.Multiaddr, srcIP net.IP) (manet.Conn, error) {
 	network, addr, err := manet.DialArgs(raddr)
 	if err != nil {
 		return nil, err
@@ -33,7 +49,12 @@ func (t *Transport) DialContext(ctx context.Context, raddr ma.Multiaddr) (manet.
 	default:
 		return nil, ErrWrongProto
 	}
-	conn, err := d.DialContext(ctx, network, addr)
+	var conn net.Conn
+	if srcIP != nil {
+		conn, err = d.DialContextFrom(ctx, network, addr, srcIP)
+	} else {
+		conn, err = d.DialContext(ctx, network, addr)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -91,6 +91,25 @@ func (d *dialer) DialContext(ctx context.Context, network, addr string) (net.Con
 	return dialer.DialContext(ctx, network, addr)
 }
 
+// DialContextFrom dials a target addr from the local IP address srcIP.
+//
+// If we're listening on srcIP, we'll use that listener's port as the source port.
+// Otherwise, if we're listening on an unspecified address, we'll use that listener's port.
+func (d *dialer) DialContextFrom(ctx context.Context, network, addr string, srcIP net.IP) (net.Conn, error) {
+	for _, addrs := range [][]*net.TCPAddr{d.specific, d.loopback} {
+		for _, optAddr := range addrs {
+			if optAddr.IP.Equal(srcIP) {
+				return reuseDial(ctx, optAddr, network, addr)
+			}
+		}
+	}
+	if unspecified := randAddr(d.unspecified); unspecified != nil {
+		return reuseDial(ctx, &net.TCPAddr{IP: srcIP, Port: unspecified.Port}, network, addr)
+	}
+	dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: srcIP}}
+	return dialer.DialContext(ctx, network, addr)
+}
+
 func newDialer(listeners map[*listener]struct{}) *dialer {
 	specific := make([]*net.TCPAddr, 0)
 	loopback := make([]*net.TCPAddr, 0)
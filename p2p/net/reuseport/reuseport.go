@@ -29,7 +29,13 @@ func reuseDial(ctx context.Context, laddr *net.TCPAddr, network, raddr string) (
 		// We could have an existing socket open or we could have one
 		// stuck in TIME-WAIT.
 		log.Debugf("failed to reuse port, will try again with a random port: %s", err)
-		con, err = fallbackDialer.DialContext(ctx, network, raddr)
+		if laddr.IP.IsUnspecified() {
+			con, err = fallbackDialer.DialContext(ctx, network, raddr)
+		} else {
+			// keep the source IP address
+			d := net.Dialer{LocalAddr: &net.TCPAddr{IP: laddr.IP}}
+			con, err = d.DialContext(ctx, network, raddr)
+		}
 	}
 	return con, err
 }
@@ -275,3 +275,73 @@ func TestDuplicateGlobal(t *testing.T) {
 		dialOne(t, &trB, listenerA, port)
 	}
 }
+
+func TestDialFrom(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("127.0.0.2 is only routed to the loopback interface by default on Linux")
+	}
+	var trA Transport
+	var trB Transport
+	listenerA, err := trA.Listen(loopbackV4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listenerA.Close()
+
+	listenerB1, err := trB.Listen(loopbackV4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listenerB1.Close()
+	listenerB2, err := trB.Listen(ma.StringCast("/ip4/127.0.0.2/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listenerB2.Close()
+
+	for i := 0; i < 5; i++ {
+		done := acceptOne(t, listenerA)
+		c, err := trB.DialContextFrom(context.Background(), listenerA.Multiaddr(), ma.StringCast("/ip4/127.0.0.2"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		setLingerZero(c)
+		laddr := c.LocalAddr().(*net.TCPAddr)
+		if !laddr.IP.Equal(net.ParseIP("127.0.0.2")) {
+			t.Errorf("expected to dial from 127.0.0.2, got %s", laddr)
+		}
+		if laddr.Port != listenerB2.Addr().(*net.TCPAddr).Port {
+			t.Errorf("expected to reuse the port of the listener on 127.0.0.2, got %d", laddr.Port)
+		}
+		c.Close()
+		if conn := <-done; conn != nil {
+			conn.Close()
+		}
+	}
+}
+
+func TestDialFromNoListener(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("127.0.0.2 is only routed to the loopback interface by default on Linux")
+	}
+	var trA Transport
+	var trB Transport
+	listenerA, err := trA.Listen(loopbackV4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listenerA.Close()
+
+	done := acceptOne(t, listenerA)
+	c, err := trB.DialContextFrom(context.Background(), listenerA.Multiaddr(), ma.StringCast("/ip4/127.0.0.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if ip := c.LocalAddr().(*net.TCPAddr).IP; !ip.Equal(net.ParseIP("127.0.0.2")) {
+		t.Errorf("expected to dial from 127.0.0.2, got %s", ip)
+	}
+	if conn := <-done; conn != nil {
+		conn.Close()
+	}
+}
@@ -0,0 +1,352 @@
+package substream
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+var (
+	// ErrWriteClosed is returned when writing to a channel after closing it for writing.
+	ErrWriteClosed = errors.New("write on closed channel")
+	// ErrReadClosed is returned when reading from a channel after closing it for reading.
+	ErrReadClosed = errors.New("read on closed channel")
+	// ErrStopSending is returned when writing to a channel that the peer closed for reading.
+	ErrStopSending = errors.New("peer stopped reading from channel")
+)
+
+// A Channel is a logical, bidirectional, flow-controlled byte stream within a Session.
+// Channels are reliable and ordered, but don't preserve message boundaries.
+type Channel struct {
+	sess *Session
+	id   uint64
+
+	// writeMx serializes writes, and makes sure that no data is sent after the FIN.
+	writeMx sync.Mutex
+
+	mx sync.Mutex
+	// err is set when the channel is reset, or the session is closed
+	err error
+
+	readBuf [][]byte
+	// recvOutstanding is the number of bytes received that we haven't
+	// returned to the peer in a window update yet.
+	recvOutstanding uint64
+	// consumed is the number of bytes read by the application since the last window update.
+	consumed          uint64
+	readClosed        bool
+	remoteWriteClosed bool
+	readDeadline      time.Time
+	readNotify        chan struct{}
+
+	sendWindow    uint64
+	writeClosed   bool
+	remoteStopped bool
+	writeDeadline time.Time
+	writeNotify   chan struct{}
+}
+
+func newChannel(s *Session, id uint64) *Channel {
+	return &Channel{
+		sess:        s,
+		id:          id,
+		sendWindow:  uint64(s.windowSize),
+		readNotify:  make(chan struct{}, 1),
+		writeNotify: make(chan struct{}, 1),
+	}
+}
+
+// ID returns the ID of the channel. IDs are unique within a Session,
+// and are the same for both peers.
+func (c *Channel) ID() uint64 {
+	return c.id
+}
+
+// Read reads data from the channel.
+func (c *Channel) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	for {
+		c.mx.Lock()
+		if c.err != nil {
+			c.mx.Unlock()
+			return 0, c.err
+		}
+		if c.readClosed {
+			c.mx.Unlock()
+			return 0, ErrReadClosed
+		}
+		if len(c.readBuf) > 0 {
+			n := copy(b, c.readBuf[0])
+			if n == len(c.readBuf[0]) {
+				c.readBuf[0] = nil
+				c.readBuf = c.readBuf[1:]
+			} else {
+				c.readBuf[0] = c.readBuf[0][n:]
+			}
+			c.consumed += uint64(n)
+			// Only send a window update once the application consumed half of the window,
+			// so we don't send a window update frame for every tiny message.
+			var credit uint64
+			if !c.remoteWriteClosed && c.consumed >= uint64(c.sess.windowSize)/2 {
+				credit = c.consumed
+				c.consumed = 0
+				c.recvOutstanding -= credit
+			}
+			c.mx.Unlock()
+			if credit > 0 {
+				c.sess.writeFrameWithLength(frameWindowUpdate, c.id, credit, nil)
+			}
+			return n, nil
+		}
+		if c.remoteWriteClosed {
+			c.mx.Unlock()
+			return 0, io.EOF
+		}
+		deadline := c.readDeadline
+		c.mx.Unlock()
+
+		if err := wait(c.readNotify, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write writes data to the channel. It blocks until the peer has granted
+// enough send window.
+func (c *Channel) Write(b []byte) (int, error) {
+	c.writeMx.Lock()
+	defer c.writeMx.Unlock()
+
+	var written int
+	for len(b) > 0 {
+		n, err := c.reserveSendWindow(len(b))
+		if err != nil {
+			return written, err
+		}
+		if err := c.sess.writeFrame(frameData, c.id, b[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// reserveSendWindow waits until there's send window available,
+// and reserves up to n bytes of it.
+func (c *Channel) reserveSendWindow(n int) (int, error) {
+	for {
+		c.mx.Lock()
+		if c.err != nil {
+			c.mx.Unlock()
+			return 0, c.err
+		}
+		if c.writeClosed {
+			c.mx.Unlock()
+			return 0, ErrWriteClosed
+		}
+		if c.remoteStopped {
+			c.mx.Unlock()
+			return 0, ErrStopSending
+		}
+		if c.sendWindow > 0 {
+			n = min(n, maxFrameSize, int(min(c.sendWindow, maxFrameSize)))
+			c.sendWindow -= uint64(n)
+			c.mx.Unlock()
+			return n, nil
+		}
+		deadline := c.writeDeadline
+		c.mx.Unlock()
+
+		if err := wait(c.writeNotify, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// CloseWrite closes the channel for writing. The peer reads an io.EOF once
+// it has consumed all data.
+func (c *Channel) CloseWrite() error {
+	c.writeMx.Lock()
+	defer c.writeMx.Unlock()
+
+	c.mx.Lock()
+	if c.err != nil || c.writeClosed || c.remoteStopped {
+		c.mx.Unlock()
+		return nil
+	}
+	c.writeClosed = true
+	c.mx.Unlock()
+	notify(c.writeNotify)
+
+	err := c.sess.writeFrame(frameClose, c.id, nil)
+	c.maybeRemove()
+	return err
+}
+
+// CloseRead closes the channel for reading. Buffered data is discarded,
+// and the peer is asked to stop sending.
+func (c *Channel) CloseRead() error {
+	c.mx.Lock()
+	if c.err != nil || c.readClosed {
+		c.mx.Unlock()
+		return nil
+	}
+	c.readClosed = true
+	c.readBuf = nil
+	sendStop := !c.remoteWriteClosed
+	c.mx.Unlock()
+	notify(c.readNotify)
+
+	var err error
+	if sendStop {
+		err = c.sess.writeFrame(frameStopSending, c.id, nil)
+	}
+	c.maybeRemove()
+	return err
+}
+
+// Close closes the channel for both reading and writing.
+func (c *Channel) Close() error {
+	return errors.Join(c.CloseWrite(), c.CloseRead())
+}
+
+// Reset aborts the channel in both directions.
+func (c *Channel) Reset() error {
+	c.mx.Lock()
+	if c.err != nil {
+		c.mx.Unlock()
+		return nil
+	}
+	c.err = network.ErrReset
+	c.readBuf = nil
+	c.mx.Unlock()
+	notify(c.readNotify)
+	notify(c.writeNotify)
+
+	c.sess.removeChannel(c.id)
+	return c.sess.writeFrame(frameReset, c.id, nil)
+}
+
+// SetDeadline sets both the read and the write deadline.
+func (c *Channel) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *Channel) SetReadDeadline(t time.Time) error {
+	c.mx.Lock()
+	c.readDeadline = t
+	c.mx.Unlock()
+	notify(c.readNotify)
+	return nil
+}
+
+func (c *Channel) SetWriteDeadline(t time.Time) error {
+	c.mx.Lock()
+	c.writeDeadline = t
+	c.mx.Unlock()
+	notify(c.writeNotify)
+	return nil
+}
+
+// maybeRemove removes the channel from the session once both directions are done.
+func (c *Channel) maybeRemove() {
+	c.mx.Lock()
+	done := (c.writeClosed || c.remoteStopped) && (c.readClosed || c.remoteWriteClosed)
+	c.mx.Unlock()
+	if done {
+		c.sess.removeChannel(c.id)
+	}
+}
+
+func (c *Channel) pushData(data []byte) error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.remoteWriteClosed {
+		return errors.New("peer sent data after closing the channel")
+	}
+	c.recvOutstanding += uint64(len(data))
+	if c.recvOutstanding > uint64(c.sess.windowSize) {
+		return errors.New("peer exceeded the flow control window")
+	}
+	if c.err != nil || c.readClosed {
+		// We're not interested in this data any more.
+		return nil
+	}
+	c.readBuf = append(c.readBuf, data)
+	notify(c.readNotify)
+	return nil
+}
+
+func (c *Channel) addSendWindow(n uint64) {
+	c.mx.Lock()
+	c.sendWindow += n
+	c.mx.Unlock()
+	notify(c.writeNotify)
+}
+
+func (c *Channel) remoteClosedWrite() {
+	c.mx.Lock()
+	c.remoteWriteClosed = true
+	c.mx.Unlock()
+	notify(c.readNotify)
+	c.maybeRemove()
+}
+
+func (c *Channel) remoteStoppedSending() {
+	c.mx.Lock()
+	c.remoteStopped = true
+	c.mx.Unlock()
+	notify(c.writeNotify)
+	c.maybeRemove()
+}
+
+func (c *Channel) remoteReset() {
+	c.closeWithError(network.ErrReset)
+	c.sess.removeChannel(c.id)
+}
+
+func (c *Channel) closeWithError(err error) {
+	c.mx.Lock()
+	if c.err == nil {
+		c.err = err
+		c.readBuf = nil
+	}
+	c.mx.Unlock()
+	notify(c.readNotify)
+	notify(c.writeNotify)
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// wait waits for a notification on ch, or until the deadline is reached.
+func wait(ch <-chan struct{}, deadline time.Time) error {
+	if deadline.IsZero() {
+		<-ch
+		return nil
+	}
+	d := time.Until(deadline)
+	if d <= 0 {
+		return os.ErrDeadlineExceeded
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ch:
+		return nil
+	case <-t.C:
+		return os.ErrDeadlineExceeded
+	}
+}
@@ -0,0 +1,344 @@
+// Package substream multiplexes lightweight logical channels over a single
+// libp2p stream.
+//
+// Opening a libp2p stream requires a round of protocol negotiation (and, on
+// some transports, a round trip). Protocols that exchange many tiny,
+// correlated messages can instead open one stream, wrap it in a Session, and
+// open as many channels on top of it as they like. Opening a channel is free:
+// it doesn't require a round trip.
+//
+// On the wire, every frame consists of a one byte frame type, the channel ID
+// and the length of the payload (both uvarints), followed by the payload.
+// Every channel is flow controlled individually: a peer may only send as
+// much data on a channel as the receiver has granted it (see WithWindowSize).
+package substream
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/multiformats/go-varint"
+)
+
+var log = logging.Logger("substream")
+
+// ErrClosed is returned when using a Session (or one of its channels) after it was closed.
+var ErrClosed = errors.New("session closed")
+
+const (
+	// DefaultWindowSize is the default receive window of a channel.
+	DefaultWindowSize = 64 << 10 // 64 KiB
+	// DefaultAcceptBacklog is the default number of channels opened by the
+	// peer that can be waiting for Accept.
+	DefaultAcceptBacklog = 64
+
+	// maxFrameSize is the maximum payload size of a data frame.
+	maxFrameSize = 16 << 10 // 16 KiB
+	// maxHeaderSize is the maximum size of a frame header:
+	// one byte for the type, and two uvarints.
+	maxHeaderSize = 1 + 2*varint.MaxLenUvarint63
+)
+
+type frameType uint8
+
+const (
+	// frameOpen opens a new channel.
+	frameOpen frameType = iota
+	// frameData carries data on a channel.
+	frameData
+	// frameWindowUpdate grants the peer more send window.
+	// The length field carries the credit, the frame doesn't have a payload.
+	frameWindowUpdate
+	// frameClose closes the sender's write side of a channel.
+	frameClose
+	// frameStopSending asks the peer to stop sending on a channel.
+	frameStopSending
+	// frameReset aborts a channel in both directions.
+	frameReset
+)
+
+type Option func(*Session) error
+
+// WithWindowSize sets the receive window of every channel, i.e. how many bytes
+// the peer can send on a channel before the application has to read them.
+// Both peers have to use the same window size.
+func WithWindowSize(size uint32) Option {
+	return func(s *Session) error {
+		if size < maxFrameSize {
+			return fmt.Errorf("window size must be at least %d bytes", maxFrameSize)
+		}
+		s.windowSize = size
+		return nil
+	}
+}
+
+// WithAcceptBacklog sets the number of channels opened by the peer that can
+// be waiting for Accept. Channels opened beyond that are reset.
+func WithAcceptBacklog(n int) Option {
+	return func(s *Session) error {
+		if n < 1 {
+			return errors.New("accept backlog must be positive")
+		}
+		s.acceptBacklog = n
+		return nil
+	}
+}
+
+// A Session multiplexes channels over a single stream.
+type Session struct {
+	rwc       io.ReadWriteCloser
+	initiator bool
+
+	windowSize    uint32
+	acceptBacklog int
+
+	writeMx  sync.Mutex
+	writeBuf []byte
+
+	mx        sync.Mutex
+	channels  map[uint64]*Channel
+	nextID    uint64
+	acceptCh  chan *Channel
+	closeErr  error
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSession creates a new session on top of rwc, which is usually a
+// network.Stream. The Session takes ownership of rwc: it must not be used
+// by the application any more, and will be closed when the Session is closed.
+//
+// One side of the stream has to be the initiator, and the other side the
+// responder. Usually, the peer that opened the stream is the initiator.
+func NewSession(rwc io.ReadWriteCloser, initiator bool, opts ...Option) (*Session, error) {
+	s := &Session{
+		rwc:           rwc,
+		initiator:     initiator,
+		windowSize:    DefaultWindowSize,
+		acceptBacklog: DefaultAcceptBacklog,
+		channels:      make(map[uint64]*Channel),
+		closed:        make(chan struct{}),
+		writeBuf:      make([]byte, maxHeaderSize+maxFrameSize),
+	}
+	// The initiator uses odd channel IDs, the responder even ones.
+	if initiator {
+		s.nextID = 1
+	} else {
+		s.nextID = 2
+	}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	s.acceptCh = make(chan *Channel, s.acceptBacklog)
+	go s.readLoop()
+	return s, nil
+}
+
+// Open opens a new channel. This doesn't wait for the peer:
+// data can be written to the channel right away.
+func (s *Session) Open() (*Channel, error) {
+	s.mx.Lock()
+	if s.closeErr != nil {
+		s.mx.Unlock()
+		return nil, s.closeErr
+	}
+	id := s.nextID
+	s.nextID += 2
+	c := newChannel(s, id)
+	s.channels[id] = c
+	s.mx.Unlock()
+
+	if err := s.writeFrame(frameOpen, id, nil); err != nil {
+		s.removeChannel(id)
+		return nil, err
+	}
+	return c, nil
+}
+
+// Accept waits for the peer to open a channel.
+func (s *Session) Accept() (*Channel, error) {
+	select {
+	case c := <-s.acceptCh:
+		return c, nil
+	case <-s.closed:
+		// Channels might have been queued right before the session was closed.
+		select {
+		case c := <-s.acceptCh:
+			return c, nil
+		default:
+		}
+		return nil, s.err()
+	}
+}
+
+// NumChannels returns the number of open channels.
+func (s *Session) NumChannels() int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return len(s.channels)
+}
+
+// Close closes the session and the underlying stream.
+// All channels are reset.
+func (s *Session) Close() error {
+	s.closeWithError(ErrClosed)
+	return nil
+}
+
+func (s *Session) err() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.closeErr
+}
+
+func (s *Session) closeWithError(err error) {
+	s.closeOnce.Do(func() {
+		s.mx.Lock()
+		s.closeErr = err
+		channels := s.channels
+		s.channels = make(map[uint64]*Channel)
+		s.mx.Unlock()
+
+		close(s.closed)
+		s.rwc.Close()
+		for _, c := range channels {
+			c.closeWithError(err)
+		}
+	})
+}
+
+func (s *Session) removeChannel(id uint64) {
+	s.mx.Lock()
+	delete(s.channels, id)
+	s.mx.Unlock()
+}
+
+func (s *Session) writeFrame(typ frameType, id uint64, payload []byte) error {
+	return s.writeFrameWithLength(typ, id, uint64(len(payload)), payload)
+}
+
+// writeFrameWithLength writes a frame. For window updates, length is the credit.
+func (s *Session) writeFrameWithLength(typ frameType, id uint64, length uint64, payload []byte) error {
+	s.writeMx.Lock()
+	defer s.writeMx.Unlock()
+
+	select {
+	case <-s.closed:
+		return s.err()
+	default:
+	}
+
+	buf := s.writeBuf[:1]
+	buf[0] = byte(typ)
+	buf = buf[:1+varint.PutUvarint(buf[1:cap(buf)], id)]
+	buf = buf[:len(buf)+varint.PutUvarint(buf[len(buf):cap(buf)], length)]
+	buf = append(buf, payload...)
+	if _, err := s.rwc.Write(buf); err != nil {
+		s.closeWithError(err)
+		return err
+	}
+	return nil
+}
+
+func (s *Session) readLoop() {
+	r := bufio.NewReader(s.rwc)
+	for {
+		if err := s.readFrame(r); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = ErrClosed
+			} else {
+				log.Debugw("reading frame failed", "error", err)
+			}
+			s.closeWithError(err)
+			return
+		}
+	}
+}
+
+func (s *Session) readFrame(r *bufio.Reader) error {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	id, err := varint.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	length, err := varint.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+
+	switch frameType(typ) {
+	case frameOpen:
+		return s.handleOpen(id)
+	case frameData:
+		if length > maxFrameSize {
+			return fmt.Errorf("frame too large: %d bytes", length)
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		if c := s.getChannel(id); c != nil {
+			return c.pushData(data)
+		}
+	case frameWindowUpdate:
+		if c := s.getChannel(id); c != nil {
+			c.addSendWindow(length)
+		}
+	case frameClose:
+		if c := s.getChannel(id); c != nil {
+			c.remoteClosedWrite()
+		}
+	case frameStopSending:
+		if c := s.getChannel(id); c != nil {
+			c.remoteStoppedSending()
+		}
+	case frameReset:
+		if c := s.getChannel(id); c != nil {
+			c.remoteReset()
+		}
+	default:
+		return fmt.Errorf("unknown frame type: %d", typ)
+	}
+	// Frames for unknown channels belong to channels that were already closed.
+	return nil
+}
+
+func (s *Session) handleOpen(id uint64) error {
+	// The peer uses odd channel IDs if we're the responder, and even ones if we're the initiator.
+	if id == 0 || (id%2 == 1) == s.initiator {
+		return fmt.Errorf("peer opened channel with invalid ID %d", id)
+	}
+
+	s.mx.Lock()
+	if _, ok := s.channels[id]; ok {
+		s.mx.Unlock()
+		return fmt.Errorf("peer opened channel %d twice", id)
+	}
+	c := newChannel(s, id)
+	s.channels[id] = c
+	s.mx.Unlock()
+
+	select {
+	case s.acceptCh <- c:
+	default:
+		log.Debugw("accept backlog full, resetting channel", "channel", id)
+		// Don't block the read loop on writing the reset.
+		go c.Reset()
+	}
+	return nil
+}
+
+func (s *Session) getChannel(id uint64) *Channel {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.channels[id]
+}
@@ -0,0 +1,285 @@
+package substream
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newSessionPair(t *testing.T, opts ...Option) (*Session, *Session) {
+	t.Helper()
+	c1, c2 := net.Pipe()
+	s1, err := NewSession(c1, true, opts...)
+	require.NoError(t, err)
+	s2, err := NewSession(c2, false, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		s1.Close()
+		s2.Close()
+	})
+	return s1, s2
+}
+
+func TestManyChannels(t *testing.T) {
+	s1, s2 := newSessionPair(t)
+
+	const num = 50
+	go func() {
+		for {
+			c, err := s2.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, num)
+	for i := 0; i < num; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c, err := s1.Open()
+			if err != nil {
+				errs <- err
+				return
+			}
+			msg := []byte(fmt.Sprintf("message %d", i))
+			if _, err := c.Write(msg); err != nil {
+				errs <- err
+				return
+			}
+			c.CloseWrite()
+			b, err := io.ReadAll(c)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !bytes.Equal(msg, b) {
+				errs <- fmt.Errorf("expected %q, got %q", msg, b)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.Eventually(t, func() bool { return s1.NumChannels() == 0 && s2.NumChannels() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestChannelIDs(t *testing.T) {
+	s1, s2 := newSessionPair(t)
+
+	c1, err := s1.Open()
+	require.NoError(t, err)
+	c2, err := s2.Open()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), c1.ID())
+	require.Equal(t, uint64(2), c2.ID())
+
+	a2, err := s2.Accept()
+	require.NoError(t, err)
+	require.Equal(t, c1.ID(), a2.ID())
+	a1, err := s1.Accept()
+	require.NoError(t, err)
+	require.Equal(t, c2.ID(), a1.ID())
+}
+
+func TestFlowControl(t *testing.T) {
+	const windowSize = 32 << 10
+	s1, s2 := newSessionPair(t, WithWindowSize(windowSize))
+
+	c1, err := s1.Open()
+	require.NoError(t, err)
+	c2, err := s2.Accept()
+	require.NoError(t, err)
+
+	// Without the receiver reading, we can only send a full window.
+	data := make([]byte, 3*windowSize)
+	rand.Read(data)
+	c1.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	n, err := c1.Write(data)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	require.Equal(t, windowSize, n)
+
+	// A different channel is not blocked.
+	other, err := s1.Open()
+	require.NoError(t, err)
+	_, err = other.Write([]byte("foobar"))
+	require.NoError(t, err)
+
+	// Once the receiver reads, the window opens up again.
+	c1.SetWriteDeadline(time.Time{})
+	done := make(chan error, 1)
+	go func() {
+		_, err := c1.Write(data[n:])
+		c1.CloseWrite()
+		done <- err
+	}()
+	b, err := io.ReadAll(c2)
+	require.NoError(t, err)
+	require.Equal(t, data, b)
+	require.NoError(t, <-done)
+}
+
+func TestCloseRead(t *testing.T) {
+	s1, s2 := newSessionPair(t)
+
+	c1, err := s1.Open()
+	require.NoError(t, err)
+	c2, err := s2.Accept()
+	require.NoError(t, err)
+
+	require.NoError(t, c2.CloseRead())
+	_, err = c2.Read(make([]byte, 1))
+	require.ErrorIs(t, err, ErrReadClosed)
+	require.Eventually(t, func() bool {
+		_, err := c1.Write([]byte("foobar"))
+		return err == ErrStopSending
+	}, time.Second, 10*time.Millisecond)
+
+	// The other direction still works.
+	_, err = c2.Write([]byte("foobar"))
+	require.NoError(t, err)
+	b := make([]byte, 6)
+	_, err = io.ReadFull(c1, b)
+	require.NoError(t, err)
+	require.Equal(t, "foobar", string(b))
+}
+
+func TestReset(t *testing.T) {
+	s1, s2 := newSessionPair(t)
+
+	c1, err := s1.Open()
+	require.NoError(t, err)
+	_, err = c1.Write([]byte("foobar"))
+	require.NoError(t, err)
+	c2, err := s2.Accept()
+	require.NoError(t, err)
+
+	require.NoError(t, c1.Reset())
+	_, err = c1.Write([]byte("foobar"))
+	require.ErrorIs(t, err, network.ErrReset)
+	require.Eventually(t, func() bool {
+		_, err := c2.Read(make([]byte, 10))
+		return err == network.ErrReset
+	}, time.Second, 10*time.Millisecond)
+	require.Zero(t, s1.NumChannels())
+	require.Zero(t, s2.NumChannels())
+}
+
+func TestReadDeadline(t *testing.T) {
+	s1, _ := newSessionPair(t)
+
+	c, err := s1.Open()
+	require.NoError(t, err)
+	c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = c.Read(make([]byte, 1))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestAcceptBacklog(t *testing.T) {
+	s1, s2 := newSessionPair(t, WithAcceptBacklog(1))
+
+	c1, err := s1.Open()
+	require.NoError(t, err)
+	c2, err := s1.Open()
+	require.NoError(t, err)
+
+	// The second channel doesn't fit into the backlog, and is reset.
+	_, err = c2.Read(make([]byte, 1))
+	require.ErrorIs(t, err, network.ErrReset)
+
+	a, err := s2.Accept()
+	require.NoError(t, err)
+	require.Equal(t, c1.ID(), a.ID())
+}
+
+func TestSessionClose(t *testing.T) {
+	s1, s2 := newSessionPair(t)
+
+	c1, err := s1.Open()
+	require.NoError(t, err)
+	c2, err := s2.Accept()
+	require.NoError(t, err)
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := c2.Read(make([]byte, 1))
+		readErr <- err
+	}()
+
+	require.NoError(t, s1.Close())
+	_, err = c1.Write([]byte("foobar"))
+	require.ErrorIs(t, err, ErrClosed)
+	_, err = s1.Open()
+	require.ErrorIs(t, err, ErrClosed)
+	select {
+	case err := <-readErr:
+		require.ErrorIs(t, err, ErrClosed)
+	case <-time.After(time.Second):
+		t.Fatal("read didn't unblock")
+	}
+	_, err = s2.Accept()
+	require.ErrorIs(t, err, ErrClosed)
+}
+
+func TestOverStream(t *testing.T) {
+	mn, err := mocknet.FullMeshConnected(2)
+	require.NoError(t, err)
+	defer mn.Close()
+	h1, h2 := mn.Hosts()[0], mn.Hosts()[1]
+
+	const proto = "/substream-test"
+	h2.SetStreamHandler(proto, func(str network.Stream) {
+		sess, err := NewSession(str, false)
+		if err != nil {
+			str.Reset()
+			return
+		}
+		for {
+			c, err := sess.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	})
+
+	str, err := h1.NewStream(context.Background(), h2.ID(), proto)
+	require.NoError(t, err)
+	sess, err := NewSession(str, true)
+	require.NoError(t, err)
+	defer sess.Close()
+
+	for i := 0; i < 10; i++ {
+		c, err := sess.Open()
+		require.NoError(t, err)
+		_, err = c.Write([]byte("foobar"))
+		require.NoError(t, err)
+		require.NoError(t, c.CloseWrite())
+		b, err := io.ReadAll(c)
+		require.NoError(t, err)
+		require.Equal(t, "foobar", string(b))
+	}
+}
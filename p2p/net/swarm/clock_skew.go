@@ -0,0 +1,92 @@
+package swarm
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// clockSkewMinPeers is the number of peers whose dials must have failed
+	// because of a clock skew in the same direction before it is reported. A
+	// single peer might just as well have a wrong clock itself.
+	clockSkewMinPeers = 3
+	// clockSkewObservationTTL is the time an observation is taken into account.
+	clockSkewObservationTTL = time.Hour
+	// clockSkewReportInterval is the minimum interval between two reports.
+	clockSkewReportInterval = time.Hour
+)
+
+type clockSkewObservation struct {
+	skew time.Duration
+	at   time.Time
+}
+
+// clockSkewDetector collects the clock skews reported by failed dials (see
+// transport.ClockSkewError), and decides when the local clock is likely wrong.
+type clockSkewDetector struct {
+	mu           sync.Mutex
+	observations map[peer.ID]clockSkewObservation
+	lastReport   time.Time
+}
+
+// record records that dialing p failed because of skew. If enough peers agree
+// that the local clock is wrong, it returns the event to emit.
+func (d *clockSkewDetector) record(p peer.ID, skew time.Duration, now time.Time) (event.EvtClockSkewDetected, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.observations == nil {
+		d.observations = make(map[peer.ID]clockSkewObservation)
+	}
+	d.observations[p] = clockSkewObservation{skew: skew, at: now}
+	for p, o := range d.observations {
+		if now.Sub(o.at) > clockSkewObservationTTL {
+			delete(d.observations, p)
+		}
+	}
+	if !d.lastReport.IsZero() && now.Sub(d.lastReport) < clockSkewReportInterval {
+		return event.EvtClockSkewDetected{}, false
+	}
+
+	// Every observation is a lower bound of the skew, so the one closest to
+	// zero is reported.
+	var ahead, behind int
+	var minAhead, minBehind time.Duration
+	for _, o := range d.observations {
+		switch {
+		case o.skew > 0:
+			if ahead == 0 || o.skew < minAhead {
+				minAhead = o.skew
+			}
+			ahead++
+		case o.skew < 0:
+			if behind == 0 || o.skew > minBehind {
+				minBehind = o.skew
+			}
+			behind++
+		}
+	}
+	var evt event.EvtClockSkewDetected
+	switch {
+	case ahead >= clockSkewMinPeers && ahead >= behind:
+		evt = event.EvtClockSkewDetected{Skew: minAhead, Peers: ahead}
+	case behind >= clockSkewMinPeers:
+		evt = event.EvtClockSkewDetected{Skew: minBehind, Peers: behind}
+	default:
+		return event.EvtClockSkewDetected{}, false
+	}
+	d.lastReport = now
+	return evt, true
+}
+
+func (s *Swarm) recordClockSkew(p peer.ID, skew time.Duration) {
+	evt, ok := s.clockSkew.record(p, skew, s.clock.Now())
+	if !ok {
+		return
+	}
+	log.Warnf("dials to %d peers failed because of the local clock, which seems to be off by at least %s", evt.Peers, evt.Skew)
+	s.skewEmitter.Emit(evt)
+}
@@ -0,0 +1,45 @@
+package swarm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClockSkewDetector(t *testing.T) {
+	var d clockSkewDetector
+	now := time.Now()
+
+	_, ok := d.record("p1", -3*time.Hour, now)
+	require.False(t, ok)
+	// a single peer failing repeatedly doesn't count
+	_, ok = d.record("p1", -3*time.Hour, now)
+	require.False(t, ok)
+	// skews in the other direction don't count either
+	_, ok = d.record("p2", time.Hour, now)
+	require.False(t, ok)
+	_, ok = d.record("p3", -2*time.Hour, now)
+	require.False(t, ok)
+
+	evt, ok := d.record("p4", -4*time.Hour, now)
+	require.True(t, ok)
+	require.Equal(t, event.EvtClockSkewDetected{Skew: -2 * time.Hour, Peers: 3}, evt)
+
+	// reports are rate limited
+	_, ok = d.record("p5", -4*time.Hour, now.Add(time.Minute))
+	require.False(t, ok)
+
+	// old observations expire
+	now = now.Add(clockSkewReportInterval + clockSkewObservationTTL)
+	for i, p := range []peer.ID{"p6", "p7"} {
+		_, ok = d.record(p, time.Duration(i+1)*time.Hour, now)
+		require.False(t, ok)
+	}
+	evt, ok = d.record("p8", 3*time.Hour, now)
+	require.True(t, ok)
+	require.Equal(t, event.EvtClockSkewDetected{Skew: time.Hour, Peers: 3}, evt)
+}
@@ -5,6 +5,8 @@ import (
 	"os"
 	"testing"
 
+	"github.com/libp2p/go-libp2p/core/network"
+
 	ma "github.com/multiformats/go-multiaddr"
 	"github.com/stretchr/testify/require"
 )
@@ -20,6 +22,7 @@ func TestDialError(t *testing.T) {
 	require.ErrorIs(t, de, ErrGaterDisallowedConnection,
 		"DialError Unwrap should handle DialError.Cause")
 	require.ErrorIs(t, de, de, "DialError Unwrap should handle match to self")
+	require.ErrorIs(t, de, network.ErrGated, "ErrGaterDisallowedConnection should match network.ErrGated")
 
 	aa := ma.StringCast("/ip4/1.2.3.4/tcp/1234")
 	ab := ma.StringCast("/ip6/1::1/udp/1234/quic-v1")
@@ -0,0 +1,163 @@
+package swarm
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const (
+	// maxDialHistoryPeers is the maximum number of peers we keep a dial history for.
+	maxDialHistoryPeers = 1024
+	// maxDialHistoryAddrs is the maximum number of addresses we keep a dial history for, per peer.
+	maxDialHistoryAddrs = 64
+)
+
+// PeerDialRanker provides a schedule of dialing the provided addresses of a peer.
+// Unlike a network.DialRanker, it gets the peer's latency and dial history, and
+// the context of the dial. Applications can pass signals for a single dial
+// using network.WithConnValue, and get them using network.GetConnValues(ctx).
+//
+// Application-provided data sources, e.g. a geo or latency database lookup, are
+// typically captured by the ranker when constructing it:
+//
+//	swarm.WithPeerDialRanker(func(ctx context.Context, info swarm.DialRankInfo, addrs []ma.Multiaddr) []network.AddrDelay {
+//		ranking := swarm.DefaultDialRanker(addrs)
+//		for i := range ranking {
+//			// delay dialing addresses that are far away
+//			ranking[i].Delay += geoDB.Delay(ranking[i].Addr)
+//		}
+//		return ranking
+//	})
+type PeerDialRanker func(ctx context.Context, info DialRankInfo, addrs []ma.Multiaddr) []network.AddrDelay
+
+// DialRankInfo is the information about a peer passed to a PeerDialRanker.
+type DialRankInfo struct {
+	// Peer is the peer being dialed.
+	Peer peer.ID
+	// Latency is the latency to the peer recorded in the peerstore, or 0 if unknown.
+	// It averages the measurements over all transports, see Latencies.
+	Latency time.Duration
+	// Latencies holds the latency statistics of the peer per transport, keyed
+	// by the transport names returned by LatencyTransport of the
+	// p2p/host/peerstore package. It is nil if the peerstore doesn't track
+	// latencies per transport, or if there were no measurements.
+	Latencies map[string]peerstore.LatencyStats
+	// History holds the stats of previous dials to the peer's addresses, keyed
+	// by the string representation of the address. Addresses that were never
+	// dialed, or not recently, are missing.
+	History map[string]AddrDialStats
+	// Intent is the intent of the dial, if the caller set one using
+	// network.WithIntent.
+	Intent network.Intent
+}
+
+// AddrDialStats holds the stats of previous dials to an address.
+type AddrDialStats struct {
+	// Successes is the number of dials that resulted in a connection.
+	Successes int
+	// Failures is the number of dials that failed. Dials that were canceled
+	// since another dial succeeded are not counted.
+	Failures int
+	// LastSuccess is the time of the last successful dial.
+	LastSuccess time.Time
+	// LastFailure is the time of the last failed dial.
+	LastFailure time.Time
+	// LastDuration is the time it took to establish a connection on the last successful dial.
+	LastDuration time.Duration
+}
+
+func (s AddrDialStats) lastDial() time.Time {
+	if s.LastSuccess.After(s.LastFailure) {
+		return s.LastSuccess
+	}
+	return s.LastFailure
+}
+
+// dialHistory records the outcome of dials, for use by a PeerDialRanker.
+// The number of peers and addresses is bounded, the least recently dialed are
+// evicted first.
+type dialHistory struct {
+	mx    sync.Mutex
+	peers map[peer.ID]*peerDialHistory
+}
+
+type peerDialHistory struct {
+	lastDial time.Time
+	addrs    map[string]AddrDialStats
+}
+
+func newDialHistory() *dialHistory {
+	return &dialHistory{peers: make(map[peer.ID]*peerDialHistory)}
+}
+
+// Record records the outcome of a dial to addr that started at start.
+// It's a no-op on a nil dialHistory.
+func (h *dialHistory) Record(p peer.ID, addr ma.Multiaddr, start time.Time, err error) {
+	if h == nil {
+		return
+	}
+	now := time.Now()
+
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	ph, ok := h.peers[p]
+	if !ok {
+		if len(h.peers) >= maxDialHistoryPeers {
+			var oldest peer.ID
+			var oldestTime time.Time
+			for id, e := range h.peers {
+				if oldest == "" || e.lastDial.Before(oldestTime) {
+					oldest, oldestTime = id, e.lastDial
+				}
+			}
+			delete(h.peers, oldest)
+		}
+		ph = &peerDialHistory{addrs: make(map[string]AddrDialStats)}
+		h.peers[p] = ph
+	}
+	ph.lastDial = now
+
+	key := addr.String()
+	st, ok := ph.addrs[key]
+	if !ok && len(ph.addrs) >= maxDialHistoryAddrs {
+		var oldest string
+		var oldestTime time.Time
+		for a, e := range ph.addrs {
+			if oldest == "" || e.lastDial().Before(oldestTime) {
+				oldest, oldestTime = a, e.lastDial()
+			}
+		}
+		delete(ph.addrs, oldest)
+	}
+	if err == nil {
+		st.Successes++
+		st.LastSuccess = now
+		st.LastDuration = now.Sub(start)
+	} else {
+		st.Failures++
+		st.LastFailure = now
+	}
+	ph.addrs[key] = st
+}
+
+// Get returns a copy of the dial history of p.
+func (h *dialHistory) Get(p peer.ID) map[string]AddrDialStats {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	ph, ok := h.peers[p]
+	if !ok {
+		return nil
+	}
+	addrs := make(map[string]AddrDialStats, len(ph.addrs))
+	for a, st := range ph.addrs {
+		addrs[a] = st
+	}
+	return addrs
+}
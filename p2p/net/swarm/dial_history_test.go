@@ -0,0 +1,125 @@
+package swarm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/test"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialHistory(t *testing.T) {
+	h := newDialHistory()
+	p := test.RandPeerIDFatal(t)
+	addr := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	require.Nil(t, h.Get(p))
+
+	start := time.Now()
+	h.Record(p, addr, start, nil)
+	h.Record(p, addr, start, errors.New("dial failed"))
+	h.Record(p, addr, start, nil)
+	st := h.Get(p)[addr.String()]
+	require.Equal(t, 2, st.Successes)
+	require.Equal(t, 1, st.Failures)
+	require.False(t, st.LastSuccess.Before(st.LastFailure))
+	require.NotZero(t, st.LastDuration)
+
+	// A nil history doesn't record anything.
+	var nilHistory *dialHistory
+	nilHistory.Record(p, addr, start, nil)
+}
+
+func TestDialHistoryEviction(t *testing.T) {
+	h := newDialHistory()
+	p := test.RandPeerIDFatal(t)
+	for i := 0; i < maxDialHistoryAddrs+1; i++ {
+		h.Record(p, ma.StringCast(fmt.Sprintf("/ip4/1.2.3.4/tcp/%d", i+1)), time.Now(), nil)
+	}
+	addrs := h.Get(p)
+	require.Len(t, addrs, maxDialHistoryAddrs)
+	require.NotContains(t, addrs, "/ip4/1.2.3.4/tcp/1")
+
+	for i := 0; i < maxDialHistoryPeers; i++ {
+		h.Record(test.RandPeerIDFatal(t), ma.StringCast("/ip4/1.2.3.4/tcp/1"), time.Now(), nil)
+	}
+	require.Len(t, h.peers, maxDialHistoryPeers)
+	require.Nil(t, h.Get(p))
+}
+
+func TestPeerDialRanker(t *testing.T) {
+	type signalKey struct{}
+
+	// get the address of a closed TCP port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	unreachable, err := manet.FromNetAddr(l.Addr())
+	require.NoError(t, err)
+	l.Close()
+
+	infos := make(chan DialRankInfo, 1)
+	signals := make(chan interface{}, 1)
+	s1 := makeSwarmWithNoListenAddrs(t, WithPeerDialRanker(func(ctx context.Context, info DialRankInfo, addrs []ma.Multiaddr) []network.AddrDelay {
+		signals <- network.GetConnValues(ctx)[signalKey{}]
+		infos <- info
+		// dial the unreachable address first
+		res := make([]network.AddrDelay, 0, len(addrs))
+		for _, a := range addrs {
+			delay := time.Second
+			if a.Equal(unreachable) {
+				delay = 0
+			}
+			res = append(res, network.AddrDelay{Addr: a, Delay: delay})
+		}
+		return res
+	}))
+	defer s1.Close()
+	s2 := makeSwarm(t)
+	defer s2.Close()
+
+	// Only pass in TCP addresses, otherwise we might end up with a QUIC connection dialed.
+	s2Addr := s2.ListenAddresses()[0]
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), []ma.Multiaddr{unreachable, s2Addr}, peerstore.PermanentAddrTTL)
+
+	dial := func(signal string) DialRankInfo {
+		t.Helper()
+		c, err := s1.DialPeer(network.WithConnValue(context.Background(), signalKey{}, signal), s2.LocalPeer())
+		require.NoError(t, err)
+		require.Equal(t, s2Addr, c.RemoteMultiaddr())
+		require.NoError(t, s1.ClosePeer(s2.LocalPeer()))
+		require.Equal(t, signal, <-signals)
+		return <-infos
+	}
+
+	info := dial("first")
+	require.Equal(t, s2.LocalPeer(), info.Peer)
+	require.Zero(t, info.Latency)
+	require.Empty(t, info.History)
+
+	s1.Peerstore().RecordLatency(s2.LocalPeer(), 42*time.Millisecond)
+	info = dial("second")
+	require.Equal(t, 42*time.Millisecond, info.Latency)
+	require.Len(t, info.History, 2)
+	require.Equal(t, 1, info.History[s2Addr.String()].Successes)
+	require.NotZero(t, info.History[s2Addr.String()].LastDuration)
+	require.Equal(t, 1, info.History[unreachable.String()].Failures)
+	require.Zero(t, info.History[unreachable.String()].Successes)
+
+	intent := network.Intent{Protocols: []protocol.ID{"/foo"}, Bandwidth: network.BandwidthHigh}
+	c, err := s1.DialPeer(network.WithIntent(context.Background(), intent), s2.LocalPeer())
+	require.NoError(t, err)
+	<-signals
+	require.Equal(t, intent, (<-infos).Intent)
+	connIntent, ok := network.ConnIntent(c)
+	require.True(t, ok)
+	require.Equal(t, intent, connIntent)
+}
@@ -16,10 +16,11 @@ type dialWorkerFunc func(peer.ID, <-chan dialRequest)
 var errConcurrentDialSuccessful = errors.New("concurrent dial successful")
 
 // newDialSync constructs a new dialSync
-func newDialSync(worker dialWorkerFunc) *dialSync {
+func newDialSync(worker dialWorkerFunc, metricsTracer MetricsTracer) *dialSync {
 	return &dialSync{
-		dials:      make(map[peer.ID]*activeDial),
-		dialWorker: worker,
+		dials:         make(map[peer.ID]*activeDial),
+		dialWorker:    worker,
+		metricsTracer: metricsTracer,
 	}
 }
 
@@ -29,6 +30,8 @@ type dialSync struct {
 	mutex      sync.Mutex
 	dials      map[peer.ID]*activeDial
 	dialWorker dialWorkerFunc
+
+	metricsTracer MetricsTracer
 }
 
 type activeDial struct {
@@ -49,6 +52,12 @@ func (ad *activeDial) dial(ctx context.Context) (*Conn, error) {
 	if simConnect, isClient, reason := network.GetSimultaneousConnect(ctx); simConnect {
 		dialCtx = network.WithSimultaneousConnect(dialCtx, isClient, reason)
 	}
+	if match, reason := network.GetDialAddrFilter(ctx); match != nil {
+		dialCtx = network.WithDialAddrFilter(dialCtx, match, reason)
+	}
+	for k, v := range network.GetConnValues(ctx) {
+		dialCtx = network.WithConnValue(dialCtx, k, v)
+	}
 
 	resch := make(chan dialResponse, 1)
 	select {
@@ -65,12 +74,16 @@ func (ad *activeDial) dial(ctx context.Context) (*Conn, error) {
 	}
 }
 
-func (ds *dialSync) getActiveDial(p peer.ID) (*activeDial, error) {
+func (ds *dialSync) getActiveDial(ctx context.Context, p peer.ID) (*activeDial, error) {
 	ds.mutex.Lock()
 	defer ds.mutex.Unlock()
 
 	actd, ok := ds.dials[p]
-	if !ok {
+	if ok {
+		if mt, ok := ds.metricsTracer.(CoalescedDialMetricsTracer); ok {
+			mt.CoalescedDial(isRelayLegDial(ctx))
+		}
+	} else {
 		// This code intentionally uses the background context. Otherwise, if the first call
 		// to Dial is canceled, subsequent dial calls will also be canceled.
 		ctx, cancel := context.WithCancelCause(context.Background())
@@ -88,9 +101,10 @@ func (ds *dialSync) getActiveDial(p peer.ID) (*activeDial, error) {
 }
 
 // Dial initiates a dial to the given peer if there are none in progress
-// then waits for the dial to that peer to complete.
+// then waits for the dial to that peer to complete. Concurrent calls for the
+// same peer share the result of a single dial.
 func (ds *dialSync) Dial(ctx context.Context, p peer.ID) (*Conn, error) {
-	ad, err := ds.getActiveDial(p)
+	ad, err := ds.getActiveDial(ctx, p)
 	if err != nil {
 		return nil, err
 	}
@@ -34,7 +34,7 @@ func getMockDialFunc() (dialWorkerFunc, func(), context.Context, <-chan struct{}
 
 func TestBasicDialSync(t *testing.T) {
 	df, done, _, callsch := getMockDialFunc()
-	dsync := newDialSync(df)
+	dsync := newDialSync(df, nil)
 	p := peer.ID("testpeer")
 
 	finished := make(chan struct{}, 2)
@@ -67,7 +67,7 @@ func TestBasicDialSync(t *testing.T) {
 func TestDialSyncCancel(t *testing.T) {
 	df, done, _, dcall := getMockDialFunc()
 
-	dsync := newDialSync(df)
+	dsync := newDialSync(df, nil)
 
 	p := peer.ID("testpeer")
 
@@ -118,7 +118,7 @@ func TestDialSyncCancel(t *testing.T) {
 func TestDialSyncAllCancel(t *testing.T) {
 	df, done, dctx, _ := getMockDialFunc()
 
-	dsync := newDialSync(df)
+	dsync := newDialSync(df, nil)
 	p := peer.ID("testpeer")
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -181,7 +181,7 @@ func TestFailFirst(t *testing.T) {
 		}()
 	}
 
-	ds := newDialSync(f)
+	ds := newDialSync(f, nil)
 	p := peer.ID("testing")
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
@@ -206,7 +206,7 @@ func TestStressActiveDial(t *testing.T) {
 				req.resch <- dialResponse{}
 			}
 		}()
-	})
+	}, nil)
 
 	wg := sync.WaitGroup{}
 
@@ -226,3 +226,56 @@ func TestStressActiveDial(t *testing.T) {
 
 	wg.Wait()
 }
+
+type coalescedDialTracer struct {
+	MetricsTracer
+
+	mu    sync.Mutex
+	dials []bool
+}
+
+func (t *coalescedDialTracer) CoalescedDial(relay bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dials = append(t.dials, relay)
+}
+
+func (t *coalescedDialTracer) coalesced() []bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]bool(nil), t.dials...)
+}
+
+func TestDialSyncCoalescedMetrics(t *testing.T) {
+	df, done, _, callsch := getMockDialFunc()
+	tracer := &coalescedDialTracer{}
+	dsync := newDialSync(df, tracer)
+	p := peer.ID("testpeer")
+
+	var wg sync.WaitGroup
+	dial := func(ctx context.Context) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := dsync.Dial(ctx, p)
+			require.NoError(t, err)
+		}()
+	}
+
+	dial(context.Background())
+	<-callsch
+	require.Empty(t, tracer.coalesced())
+
+	dial(context.Background())
+	dial(context.WithValue(context.Background(), relayLegDialKey{}, struct{}{}))
+	require.Eventually(t, func() bool { return len(tracer.coalesced()) == 2 }, time.Second, 10*time.Millisecond)
+	require.ElementsMatch(t, []bool{false, true}, tracer.coalesced())
+
+	done()
+	wg.Wait()
+	select {
+	case <-callsch:
+		t.Fatal("expected a single dial")
+	default:
+	}
+}
@@ -2,12 +2,14 @@ package swarm
 
 import (
 	"context"
+	"errors"
 	"math"
 	"sync"
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
 	tpt "github.com/libp2p/go-libp2p/core/transport"
 
 	ma "github.com/multiformats/go-multiaddr"
@@ -64,6 +66,8 @@ type addrDial struct {
 	createdAt time.Time
 	// dialRankingDelay is the delay in dialing this address introduced by the ranking logic
 	dialRankingDelay time.Duration
+	// dialedAt is the time the dial to the address was started
+	dialedAt time.Time
 	// expectedTCPUpgradeTime is the expected time by which security upgrade will complete
 	expectedTCPUpgradeTime time.Time
 }
@@ -190,7 +194,7 @@ loop:
 
 			// get the delays to dial these addrs from the swarms dialRanker
 			simConnect, _, _ := network.GetSimultaneousConnect(req.ctx)
-			addrRanking := w.rankAddrs(addrs, simConnect)
+			addrRanking := w.rankAddrs(req.ctx, addrs, simConnect)
 			addrDelay := make(map[string]time.Duration, len(addrRanking))
 
 			// create the pending request object
@@ -295,6 +299,7 @@ loop:
 				}
 				ad.dialed = true
 				ad.dialRankingDelay = now.Sub(ad.createdAt)
+				ad.dialedAt = now
 				err := w.s.dialNextAddr(ad.ctx, w.peer, ad.addr, w.resch)
 				if err != nil {
 					// Errored without attempting a dial. This happens in case of
@@ -338,9 +343,18 @@ loop:
 			}
 			dialsInFlight--
 			ad.expectedTCPUpgradeTime = time.Time{}
+			if !errors.Is(res.Err, context.Canceled) {
+				w.s.dialHistory.Record(w.peer, res.Addr, ad.dialedAt, res.Err)
+			}
 			if res.Conn != nil {
-				// we got a connection, add it to the swarm
-				conn, err := w.s.addConn(res.Conn, network.DirOutbound)
+				// we got a connection, size its resource scope for the
+				// intent of the dial and add it to the swarm
+				if intent, ok := network.GetIntent(ad.ctx); ok {
+					if is, ok := res.Conn.Scope().(network.IntentScope); ok {
+						is.SetIntent(intent)
+					}
+				}
+				conn, err := w.s.addConn(res.Conn, network.DirOutbound, network.GetConnValues(ad.ctx))
 				if err != nil {
 					// oops no, we failed to add it to the swarm
 					res.Conn.Close()
@@ -368,11 +382,11 @@ loop:
 
 			// it must be an error -- add backoff if applicable and dispatch
 			// ErrDialRefusedBlackHole shouldn't end up here, just a safety check
-			if res.Err != ErrDialRefusedBlackHole && res.Err != context.Canceled && !w.connected {
+			if !errors.Is(res.Err, ErrDialRefusedBlackHole) && !errors.Is(res.Err, context.Canceled) && !w.connected {
 				// we only add backoff if there has not been a successful connection
 				// for consistency with the old dialer behavior.
 				w.s.backf.AddBackoff(w.peer, res.Addr)
-			} else if res.Err == ErrDialRefusedBlackHole {
+			} else if errors.Is(res.Err, ErrDialRefusedBlackHole) {
 				log.Errorf("SWARM BUG: unexpected ErrDialRefusedBlackHole while dialing peer %s to addr %s",
 					w.peer, res.Addr)
 			}
@@ -421,10 +435,23 @@ func (w *dialWorker) dispatchError(ad *addrDial, err error) {
 
 // rankAddrs ranks addresses for dialing. if it's a simConnect request we
 // dial all addresses immediately without any delay
-func (w *dialWorker) rankAddrs(addrs []ma.Multiaddr, isSimConnect bool) []network.AddrDelay {
+func (w *dialWorker) rankAddrs(ctx context.Context, addrs []ma.Multiaddr, isSimConnect bool) []network.AddrDelay {
 	if isSimConnect {
 		return NoDelayDialRanker(addrs)
 	}
+	if w.s.peerDialRanker != nil {
+		intent, _ := network.GetIntent(ctx)
+		info := DialRankInfo{
+			Peer:    w.peer,
+			Latency: w.s.peers.LatencyEWMA(w.peer),
+			History: w.s.dialHistory.Get(w.peer),
+			Intent:  intent,
+		}
+		if lb, ok := peerstore.GetTransportLatencyBook(w.s.peers); ok {
+			info.Latencies = lb.LatencyStats(w.peer)
+		}
+		return w.s.peerDialRanker(ctx, info, addrs)
+	}
 	return w.s.dialRanker(addrs)
 }
 
@@ -0,0 +1,151 @@
+package swarm
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	asnutil "github.com/libp2p/go-libp2p-asn-util"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// ErrDiversityLimited is returned for addresses that weren't dialed because
+// too many of the outbound connections already share their origin, see
+// WithDiversityConstraints.
+var ErrDiversityLimited = errors.New("too many outbound connections with the same origin")
+
+// ASNResolver returns the autonomous system number the ip belongs to, or 0 if
+// it's unknown.
+type ASNResolver func(ip net.IP) uint32
+
+// DiversityConfig configures the diversity constraints for outbound
+// connections.
+type DiversityConfig struct {
+	// MaxShare is the maximum fraction, in (0, 1], of the outbound connections
+	// that may share an origin.
+	MaxShare float64
+	// MinConns is the number of outbound connections below which the
+	// constraints aren't enforced. With only a few connections, any origin
+	// exceeds a small share.
+	MinConns int
+	// Resolver resolves the ASN of an IP address. It defaults to the embedded
+	// database of go-libp2p-asn-util, which only covers IPv6.
+	Resolver ASNResolver
+}
+
+// WithDiversityConstraints configures swarm to enforce the diversity of the
+// origins of its outbound connections, to make eclipse attacks harder: an
+// address isn't dialed if the connection would make more than MaxShare of
+// the outbound connections share its origin.
+//
+// The origin of an address is its ASN, if known, and its /16 (IPv4) or /32
+// (IPv6) prefix otherwise. Addresses without an IP, like those of the memory
+// transport, aren't constrained. The constraints are checked when the dial
+// starts, so concurrent dials may exceed them briefly.
+func WithDiversityConstraints(cfg DiversityConfig) Option {
+	return func(s *Swarm) error {
+		if cfg.MaxShare <= 0 || cfg.MaxShare > 1 {
+			return fmt.Errorf("swarm: max share must be in (0, 1], got %f", cfg.MaxShare)
+		}
+		if cfg.MinConns < 0 {
+			return errors.New("swarm: min conns must not be negative")
+		}
+		if cfg.Resolver == nil {
+			cfg.Resolver = asnutil.AsnForIPv6
+		}
+		s.diversity = newDiversityFilter(cfg)
+		return nil
+	}
+}
+
+// diversityFilter counts the outbound connections per origin, as they are
+// added to and removed from the swarm, so that dials don't need to look at
+// every connection.
+type diversityFilter struct {
+	cfg DiversityConfig
+
+	mx sync.Mutex
+	// total is the number of outbound connections, including those without
+	// an origin.
+	total  int
+	counts map[string]int
+}
+
+func newDiversityFilter(cfg DiversityConfig) *diversityFilter {
+	return &diversityFilter{cfg: cfg, counts: make(map[string]int)}
+}
+
+// origin returns the origin of a, and false if a isn't IP based.
+func (f *diversityFilter) origin(a ma.Multiaddr) (string, bool) {
+	ip, err := manet.ToIP(a)
+	if err != nil {
+		return "", false
+	}
+	if asn := f.cfg.Resolver(ip); asn != 0 {
+		return fmt.Sprintf("asn:%d", asn), true
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(16, 32)).String() + "/16", true
+	}
+	return ip.Mask(net.CIDRMask(32, 128)).String() + "/32", true
+}
+
+// addConn counts an outbound connection to the remote address a.
+func (f *diversityFilter) addConn(a ma.Multiaddr) {
+	o, ok := f.origin(a)
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.total++
+	if ok {
+		f.counts[o]++
+	}
+}
+
+// removeConn removes an outbound connection counted by addConn.
+func (f *diversityFilter) removeConn(a ma.Multiaddr) {
+	o, ok := f.origin(a)
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.total--
+	if ok {
+		if f.counts[o]--; f.counts[o] <= 0 {
+			delete(f.counts, o)
+		}
+	}
+}
+
+// filter removes the addresses that would violate the constraints, given the
+// current outbound connections.
+func (f *diversityFilter) filter(addrs []ma.Multiaddr) (goodAddrs []ma.Multiaddr, addrErrs []TransportError) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	// the share is evaluated including the new connection
+	total := f.total + 1
+	if total < f.cfg.MinConns {
+		return addrs, nil
+	}
+	// Every origin is allowed at least one connection, otherwise no
+	// connection could be established with a small MaxShare.
+	limit := max(1, f.cfg.MaxShare*float64(total))
+	goodAddrs = ma.FilterAddrs(addrs, func(a ma.Multiaddr) bool {
+		o, ok := f.origin(a)
+		if !ok || float64(f.counts[o]+1) <= limit {
+			return true
+		}
+		addrErrs = append(addrErrs, TransportError{Address: a, Cause: ErrDiversityLimited})
+		return false
+	})
+	return goodAddrs, addrErrs
+}
+
+// filterByDiversity removes the addresses that the diversity constraints don't
+// allow dialing.
+func (s *Swarm) filterByDiversity(addrs []ma.Multiaddr) (goodAddrs []ma.Multiaddr, addrErrs []TransportError) {
+	if s.diversity == nil {
+		return addrs, nil
+	}
+	return s.diversity.filter(addrs)
+}
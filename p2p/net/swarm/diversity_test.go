@@ -0,0 +1,91 @@
+package swarm
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/test"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiversityFilter(t *testing.T) {
+	f := newDiversityFilter(DiversityConfig{
+		MaxShare: 0.25,
+		Resolver: func(ip net.IP) uint32 {
+			if ip.Equal(net.ParseIP("2001:db8::1")) || ip.Equal(net.ParseIP("5.6.7.8")) {
+				return 1234
+			}
+			return 0
+		},
+	})
+	outbound := []ma.Multiaddr{
+		ma.StringCast("/ip4/1.2.3.4/tcp/1"),
+		ma.StringCast("/ip4/1.2.100.100/udp/1/quic-v1"),
+		ma.StringCast("/ip6/2001:db8::1/tcp/1"),
+		ma.StringCast("/ip4/9.9.9.9/tcp/1"),
+		ma.StringCast("/ip4/10.0.0.1/tcp/1"),
+		ma.StringCast("/ip4/10.1.0.1/tcp/1"),
+		ma.StringCast("/ip4/10.2.0.1/tcp/1"),
+	}
+	sameSlash16 := ma.StringCast("/ip4/1.2.5.6/tcp/1")
+	sameASN := ma.StringCast("/ip4/5.6.7.8/tcp/1")
+	other := ma.StringCast("/ip4/8.8.8.8/tcp/1")
+	nonIP := ma.StringCast("/dns4/example.com/tcp/1")
+
+	// every origin is allowed one connection
+	good, errs := f.filter([]ma.Multiaddr{other})
+	require.Equal(t, []ma.Multiaddr{other}, good)
+	require.Empty(t, errs)
+
+	for _, a := range outbound {
+		f.addConn(a)
+	}
+	// With 8 connections, 2 may share an origin.
+	good, errs = f.filter([]ma.Multiaddr{sameSlash16, sameASN, other, nonIP})
+	require.Equal(t, []ma.Multiaddr{sameASN, other, nonIP}, good)
+	require.Len(t, errs, 1)
+	require.Equal(t, sameSlash16, errs[0].Address)
+	require.ErrorIs(t, errs[0].Cause, ErrDiversityLimited)
+
+	// no constraints below MinConns
+	f.cfg.MinConns = 9
+	good, errs = f.filter([]ma.Multiaddr{sameSlash16})
+	require.Equal(t, []ma.Multiaddr{sameSlash16}, good)
+	require.Empty(t, errs)
+	f.cfg.MinConns = 0
+
+	// closed connections don't count
+	f.removeConn(outbound[0])
+	f.addConn(other)
+	good, errs = f.filter([]ma.Multiaddr{sameSlash16})
+	require.Equal(t, []ma.Multiaddr{sameSlash16}, good)
+	require.Empty(t, errs)
+	f.removeConn(other)
+	for _, a := range outbound[1:] {
+		f.removeConn(a)
+	}
+	require.Zero(t, f.total)
+	require.Empty(t, f.counts)
+}
+
+func TestDiversityConstraintsOption(t *testing.T) {
+	for _, share := range []float64{0, -0.5, 1.5} {
+		err := WithDiversityConstraints(DiversityConfig{MaxShare: share})(&Swarm{})
+		require.Error(t, err)
+	}
+
+	s := newTestSwarmWithResolver(t, nil)
+	defer s.Close()
+	require.NoError(t, WithDiversityConstraints(DiversityConfig{MaxShare: 0.5})(s))
+
+	otherPeer := test.RandPeerIDFatal(t)
+	s.Peerstore().AddAddr(otherPeer, ma.StringCast("/ip4/1.2.3.4/tcp/1"), time.Hour)
+	mas, addrErrs, err := s.addrsForDial(context.Background(), otherPeer)
+	require.NoError(t, err)
+	require.Len(t, mas, 1)
+	require.Empty(t, addrErrs)
+}
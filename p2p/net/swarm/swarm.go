@@ -10,6 +10,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/libp2p/go-libp2p/core/connmgr"
 	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/metrics"
@@ -17,8 +18,11 @@ import (
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	"github.com/libp2p/go-libp2p/p2p/metricshelper"
 	"golang.org/x/exp/slices"
 
+	ds "github.com/ipfs/go-datastore"
 	logging "github.com/ipfs/go-log/v2"
 	ma "github.com/multiformats/go-multiaddr"
 	madns "github.com/multiformats/go-multiaddr-dns"
@@ -57,6 +61,28 @@ func WithConnectionGater(gater connmgr.ConnectionGater) Option {
 	}
 }
 
+// WithConnManager sets the connection manager of the host. It is used by
+// Introspect to report the protections of the peers.
+func WithConnManager(cm connmgr.ConnManager) Option {
+	return func(s *Swarm) error {
+		s.cmgr = cm
+		return nil
+	}
+}
+
+// WithConnComparator sets the order in which the swarm prefers the connections
+// to a peer for new streams: cmp returns a negative number if a is better than
+// b. Draining connections are avoided regardless. By default, direct
+// connections are preferred, and then connections with more streams. See
+// conndedup.Comparator for the order of the connections a
+// conndedup.Deduplicator keeps.
+func WithConnComparator(cmp func(a, b network.Conn) int) Option {
+	return func(s *Swarm) error {
+		s.connCmp = cmp
+		return nil
+	}
+}
+
 // WithMultiaddrResolver sets a custom multiaddress resolver
 func WithMultiaddrResolver(maResolver *madns.Resolver) Option {
 	return func(s *Swarm) error {
@@ -112,6 +138,64 @@ func WithDialRanker(d network.DialRanker) Option {
 	}
 }
 
+// WithPeerDialRanker configures swarm to use r to rank the addresses of a peer
+// when dialing it, instead of the DialRanker. In addition to the addresses, r
+// gets the context of the dial, and the peer's latency and dial history.
+func WithPeerDialRanker(r PeerDialRanker) Option {
+	return func(s *Swarm) error {
+		if r == nil {
+			return errors.New("swarm: peer dial ranker cannot be nil")
+		}
+		s.peerDialRanker = r
+		return nil
+	}
+}
+
+// WithDialAddrProvenanceFilter configures swarm to only dial the addresses of a
+// peer for which allow returns true, based on where the address was learned
+// from. For example, to never dial private addresses learned from the DHT:
+//
+//	swarm.WithDialAddrProvenanceFilter(func(_ peer.ID, a ma.Multiaddr, prov peerstore.AddrProvenance) bool {
+//		return prov.Source != peerstore.AddrSourceDHT || !manet.IsPrivateAddr(a)
+//	})
+//
+// This requires a peerstore that records the provenance of addresses, see
+// peerstore.SourcedAddrBook. Addresses without a recorded provenance are
+// always dialed.
+func WithDialAddrProvenanceFilter(allow func(p peer.ID, addr ma.Multiaddr, prov peerstore.AddrProvenance) bool) Option {
+	return func(s *Swarm) error {
+		s.dialProvenanceFilter = allow
+		return nil
+	}
+}
+
+// WithMinDialAddrTrust configures swarm to only dial the addresses of a peer
+// that are trusted at least min, see peerstore.AddrsWithMinTrust. For example,
+// with peerstore.AddrTrustMedium, addresses only learned from the DHT are
+// never dialed. It can be combined with WithDialAddrProvenanceFilter.
+//
+// This requires a peerstore that records the provenance of addresses, see
+// peerstore.SourcedAddrBook.
+func WithMinDialAddrTrust(min peerstore.AddrTrust) Option {
+	return func(s *Swarm) error {
+		s.minDialAddrTrust = min
+		return nil
+	}
+}
+
+// WithClock configures swarm to use cl as the time source of its dial
+// backoffs and clock skew detection.
+func WithClock(cl clock.Clock) Option {
+	return func(s *Swarm) error {
+		if cl == nil {
+			return errors.New("swarm: clock cannot be nil")
+		}
+		s.clock = cl
+		s.backf.clock = cl
+		return nil
+	}
+}
+
 // WithUDPBlackHoleConfig configures swarm to use c as the config for UDP black hole detection
 // n is the size of the sliding window used to evaluate black hole state
 // min is the minimum number of successes out of n required to not block requests
@@ -144,7 +228,10 @@ type Swarm struct {
 	// down before continuing.
 	refs sync.WaitGroup
 
-	emitter event.Emitter
+	emitter     event.Emitter
+	skewEmitter event.Emitter
+	clock       clock.Clock
+	clockSkew   clockSkewDetector
 
 	rcmgr network.ResourceManager
 
@@ -165,7 +252,8 @@ type Swarm struct {
 		ifaceListenAddres []ma.Multiaddr
 		cacheEOL          time.Time
 
-		m map[transport.Listener]struct{}
+		// m maps listeners to the address passed to Listen.
+		m map[transport.Listener]ma.Multiaddr
 	}
 
 	notifs struct {
@@ -194,18 +282,35 @@ type Swarm struct {
 	limiter *dialLimiter
 	gater   connmgr.ConnectionGater
 
+	cmgr connmgr.ConnManager
+
 	closeOnce sync.Once
 	ctx       context.Context // is canceled when Close is called
 	ctxCancel context.CancelFunc
 
 	bwc           metrics.Reporter
+	bwcTransport  metrics.TransportReporter
 	metricsTracer MetricsTracer
 
 	dialRanker network.DialRanker
+	// peerDialRanker takes precedence over dialRanker if set.
+	peerDialRanker PeerDialRanker
+	// dialHistory is only recorded if peerDialRanker is set.
+	dialHistory *dialHistory
+
+	dialProvenanceFilter func(peer.ID, ma.Multiaddr, peerstore.AddrProvenance) bool
+	minDialAddrTrust     peerstore.AddrTrust
+	diversity            *diversityFilter
+
+	// connCmp is the order of connections set with WithConnComparator
+	connCmp func(a, b network.Conn) int
 
 	udpBlackHoleConfig  blackHoleConfig
 	ipv6BlackHoleConfig blackHoleConfig
 	bhd                 *blackHoleDetector
+
+	stateDatastore ds.Datastore
+	recentPeers    recentPeers
 }
 
 // NewSwarm constructs a Swarm.
@@ -214,11 +319,24 @@ func NewSwarm(local peer.ID, peers peerstore.Peerstore, eventBus event.Bus, opts
 	if err != nil {
 		return nil, err
 	}
+	skewEmitter, err := eventBus.Emitter(new(event.EvtClockSkewDetected))
+	if err != nil {
+		emitter.Close()
+		return nil, err
+	}
+	identifySub, err := eventBus.Subscribe(new(event.EvtPeerIdentificationCompleted), eventbus.Name("swarm"))
+	if err != nil {
+		emitter.Close()
+		skewEmitter.Close()
+		return nil, err
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	s := &Swarm{
 		local:            local,
 		peers:            peers,
 		emitter:          emitter,
+		skewEmitter:      skewEmitter,
+		clock:            clock.New(),
 		ctx:              ctx,
 		ctxCancel:        cancel,
 		dialTimeout:      defaultDialTimeout,
@@ -234,30 +352,69 @@ func NewSwarm(local peer.ID, peers peerstore.Peerstore, eventBus event.Bus, opts
 	}
 
 	s.conns.m = make(map[peer.ID][]*Conn)
-	s.listeners.m = make(map[transport.Listener]struct{})
+	s.listeners.m = make(map[transport.Listener]ma.Multiaddr)
 	s.transports.m = make(map[int]transport.Transport)
 	s.notifs.m = make(map[network.Notifiee]struct{})
 	s.directConnNotifs.m = make(map[peer.ID][]chan struct{})
 
 	for _, opt := range opts {
 		if err := opt(s); err != nil {
+			identifySub.Close()
 			return nil, err
 		}
 	}
 	if s.rcmgr == nil {
 		s.rcmgr = &network.NullResourceManager{}
 	}
+	s.bwcTransport, _ = s.bwc.(metrics.TransportReporter)
+	if s.peerDialRanker != nil {
+		s.dialHistory = newDialHistory()
+	}
 
-	s.dsync = newDialSync(s.dialWorkerLoop)
+	s.dsync = newDialSync(s.dialWorkerLoop, s.metricsTracer)
 
 	s.limiter = newDialLimiter(s.dialAddr)
 	s.backf.init(s.ctx)
 
 	s.bhd = newBlackHoleDetector(s.udpBlackHoleConfig, s.ipv6BlackHoleConfig, s.metricsTracer)
 
+	if s.stateDatastore != nil {
+		if err := s.restoreState(); err != nil {
+			log.Warnf("failed to restore swarm state: %s", err)
+		}
+		s.refs.Add(1)
+		go s.persistState()
+	}
+
+	s.refs.Add(1)
+	go s.handleIdentification(identifySub)
+
 	return s, nil
 }
 
+// handleIdentification marks the connections to the identified peers as
+// knowing the peer's protocols, so that their new streams aren't early.
+func (s *Swarm) handleIdentification(sub event.Subscription) {
+	defer s.refs.Done()
+	defer sub.Close()
+	for {
+		select {
+		case e, ok := <-sub.Out():
+			if !ok {
+				return
+			}
+			evt := e.(event.EvtPeerIdentificationCompleted)
+			s.conns.RLock()
+			for _, c := range s.conns.m[evt.Peer] {
+				c.protocolsKnown.Store(true)
+			}
+			s.conns.RUnlock()
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
 func (s *Swarm) Close() error {
 	s.closeOnce.Do(s.close)
 	return nil
@@ -271,7 +428,16 @@ func (s *Swarm) Done() <-chan struct{} {
 func (s *Swarm) close() {
 	s.ctxCancel()
 
+	if s.stateDatastore != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := s.saveState(ctx); err != nil {
+			log.Warnf("failed to save swarm state: %s", err)
+		}
+		cancel()
+	}
+
 	s.emitter.Close()
+	s.skewEmitter.Close()
 
 	// Prevents new connections and/or listeners from being added to the swarm.
 	s.listeners.Lock()
@@ -337,7 +503,7 @@ func (s *Swarm) close() {
 	wg.Wait()
 }
 
-func (s *Swarm) addConn(tc transport.CapableConn, dir network.Direction) (*Conn, error) {
+func (s *Swarm) addConn(tc transport.CapableConn, dir network.Direction, values map[interface{}]interface{}) (*Conn, error) {
 	var (
 		p    = tc.RemotePeer()
 		addr = tc.RemoteMultiaddr()
@@ -350,13 +516,42 @@ func (s *Swarm) addConn(tc transport.CapableConn, dir network.Direction) (*Conn,
 	}
 	stat.Direction = dir
 	stat.Opened = time.Now()
+	if len(values) > 0 {
+		// Don't modify the map of the underlying connection.
+		extra := make(map[interface{}]interface{}, len(stat.Extra)+len(values))
+		for k, v := range stat.Extra {
+			extra[k] = v
+		}
+		for k, v := range values {
+			extra[k] = v
+		}
+		stat.Extra = extra
+	}
+
+	// Connections wrapped for metrics already got an ID, so that it can be
+	// attached to the handshake latency.
+	var id uint64
+	if mc, ok := tc.(connWithMetrics); ok {
+		id = mc.id
+	} else {
+		id = s.nextConnID.Add(1)
+	}
 
 	// Wrap and register the connection.
 	c := &Conn{
 		conn:  tc,
 		swarm: s,
 		stat:  stat,
-		id:    s.nextConnID.Add(1),
+		id:    id,
+	}
+	if s.bwcTransport != nil {
+		c.transport = metricshelper.GetTransport(addr)
+	}
+
+	// Streams are early until the peer's protocols are known, see
+	// handleIdentification.
+	if protos, err := s.peers.GetProtocols(p); err == nil && len(protos) > 0 {
+		c.protocolsKnown.Store(true)
 	}
 
 	// we ONLY check upgraded connections here so we can send them a Disconnect message.
@@ -380,6 +575,8 @@ func (s *Swarm) addConn(tc transport.CapableConn, dir network.Direction) (*Conn,
 	// Clear any backoffs
 	s.backf.Clear(p)
 
+	log.Debugw("connection opened", "conn", c.ID(), "peer", p.Short(), "addr", addr, "dir", dir)
+
 	// Finally, add the peer.
 	s.conns.Lock()
 	// Check if we're still online
@@ -392,6 +589,9 @@ func (s *Swarm) addConn(tc transport.CapableConn, dir network.Direction) (*Conn,
 	c.streams.m = make(map[*Stream]struct{})
 	isFirstConnection := len(s.conns.m[p]) == 0
 	s.conns.m[p] = append(s.conns.m[p], c)
+	if s.diversity != nil && c.stat.Direction == network.DirOutbound {
+		s.diversity.addConn(c.RemoteMultiaddr())
+	}
 
 	// Add two swarm refs:
 	// * One will be decremented after the close notifications fire in Conn.doClose
@@ -556,7 +756,7 @@ func (s *Swarm) waitForDirectConn(ctx context.Context, p peer.ID) (*Conn, error)
 			return nil, network.ErrNoConn
 		}
 		if c.Stat().Transient {
-			return nil, network.ErrTransientConn
+			return nil, network.ErrLimitedConn
 		}
 		return c, nil
 	}
@@ -577,6 +777,13 @@ func (s *Swarm) ConnsToPeer(p peer.ID) []network.Conn {
 }
 
 func isBetterConn(a, b *Conn) bool {
+	// If one is draining and not the other, prefer the connection that isn't draining.
+	aDraining := a.IsDraining()
+	bDraining := b.IsDraining()
+	if aDraining != bDraining {
+		return !aDraining
+	}
+
 	// If one is transient and not the other, prefer the non-transient connection.
 	aTransient := a.Stat().Transient
 	bTransient := b.Stat().Transient
@@ -608,6 +815,18 @@ func isBetterConn(a, b *Conn) bool {
 	return true
 }
 
+// isBetterConn returns true if a is better than b, using the order set with
+// WithConnComparator if any.
+func (s *Swarm) isBetterConn(a, b *Conn) bool {
+	if s.connCmp == nil {
+		return isBetterConn(a, b)
+	}
+	if aDraining, bDraining := a.IsDraining(), b.IsDraining(); aDraining != bDraining {
+		return !aDraining
+	}
+	return s.connCmp(a, b) < 0
+}
+
 // bestConnToPeer returns the best connection to peer.
 func (s *Swarm) bestConnToPeer(p peer.ID) *Conn {
 
@@ -623,7 +842,7 @@ func (s *Swarm) bestConnToPeer(p peer.ID) *Conn {
 			// We *will* garbage collect this soon anyways.
 			continue
 		}
-		if best == nil || isBetterConn(c, best) {
+		if best == nil || s.isBetterConn(c, best) {
 			best = c
 		}
 	}
@@ -632,9 +851,15 @@ func (s *Swarm) bestConnToPeer(p peer.ID) *Conn {
 
 // bestAcceptableConnToPeer returns the best acceptable connection, considering the passed in ctx.
 // If network.WithForceDirectDial is used, it only returns a direct connections, ignoring
-// any transient (relayed) connections to the peer.
+// any transient (relayed) connections to the peer. If network.WithDialAddrFilter is used,
+// it only returns connections whose remote address matches the filter.
 func (s *Swarm) bestAcceptableConnToPeer(ctx context.Context, p peer.ID) *Conn {
-	conn := s.bestConnToPeer(p)
+	var conn *Conn
+	if match, _ := network.GetDialAddrFilter(ctx); match != nil {
+		conn = s.bestConnToPeerMatching(p, match)
+	} else {
+		conn = s.bestConnToPeer(p)
+	}
 
 	forceDirect, _ := network.GetForceDirectDial(ctx)
 	if forceDirect && !isDirectConn(conn) {
@@ -643,6 +868,23 @@ func (s *Swarm) bestAcceptableConnToPeer(ctx context.Context, p peer.ID) *Conn {
 	return conn
 }
 
+// bestConnToPeerMatching returns the best connection to peer whose remote address matches.
+func (s *Swarm) bestConnToPeerMatching(p peer.ID, match func(ma.Multiaddr) bool) *Conn {
+	s.conns.RLock()
+	defer s.conns.RUnlock()
+
+	var best *Conn
+	for _, c := range s.conns.m[p] {
+		if c.conn.IsClosed() || !match(c.RemoteMultiaddr()) {
+			continue
+		}
+		if best == nil || s.isBetterConn(c, best) {
+			best = c
+		}
+	}
+	return best
+}
+
 func isDirectConn(c *Conn) bool {
 	return c != nil && !c.conn.Transport().Proxy()
 }
@@ -749,6 +991,9 @@ func (s *Swarm) StopNotify(f network.Notifiee) {
 
 func (s *Swarm) removeConn(c *Conn) {
 	p := c.RemotePeer()
+	if s.diversity != nil && c.stat.Direction == network.DirOutbound {
+		s.diversity.removeConn(c.RemoteMultiaddr())
+	}
 
 	s.conns.Lock()
 
@@ -758,6 +1003,13 @@ func (s *Swarm) removeConn(c *Conn) {
 		delete(s.conns.m, p)
 		s.conns.Unlock()
 
+		if s.stateDatastore != nil {
+			s.recentPeers.record(p, time.Now())
+		}
+		if pr, ok := s.bwc.(metrics.PeerReporter); ok {
+			pr.RemovePeer(p)
+		}
+
 		// Emit event after releasing `s.conns` lock so that a consumer can still
 		// use swarm methods that need the `s.conns` lock.
 		s.emitter.Emit(event.EvtPeerConnectednessChanged{
@@ -794,26 +1046,36 @@ func (s *Swarm) ResourceManager() network.ResourceManager {
 // Swarm is a Network.
 var _ network.Network = (*Swarm)(nil)
 var _ transport.TransportNetwork = (*Swarm)(nil)
+var _ transport.CapabilityResolver = (*Swarm)(nil)
 
 type connWithMetrics struct {
 	transport.CapableConn
 	opened        time.Time
 	dir           network.Direction
+	id            uint64
 	metricsTracer MetricsTracer
 }
 
-func wrapWithMetrics(capableConn transport.CapableConn, metricsTracer MetricsTracer, opened time.Time, dir network.Direction) connWithMetrics {
-	c := connWithMetrics{CapableConn: capableConn, opened: opened, dir: dir, metricsTracer: metricsTracer}
+func (s *Swarm) wrapWithMetrics(capableConn transport.CapableConn, opened time.Time, dir network.Direction) connWithMetrics {
+	c := connWithMetrics{CapableConn: capableConn, opened: opened, dir: dir, id: s.nextConnID.Add(1), metricsTracer: s.metricsTracer}
 	c.metricsTracer.OpenedConnection(c.dir, capableConn.RemotePublicKey(), capableConn.ConnState(), capableConn.LocalMultiaddr())
 	return c
 }
 
 func (c connWithMetrics) completedHandshake() {
+	if mt, ok := c.metricsTracer.(ConnIDMetricsTracer); ok {
+		mt.CompletedHandshakeWithID(time.Since(c.opened), c.ConnState(), c.LocalMultiaddr(), formatConnID(c.RemotePeer(), c.id))
+		return
+	}
 	c.metricsTracer.CompletedHandshake(time.Since(c.opened), c.ConnState(), c.LocalMultiaddr())
 }
 
 func (c connWithMetrics) Close() error {
-	c.metricsTracer.ClosedConnection(c.dir, time.Since(c.opened), c.ConnState(), c.LocalMultiaddr())
+	if mt, ok := c.metricsTracer.(ConnIDMetricsTracer); ok {
+		mt.ClosedConnectionWithID(c.dir, time.Since(c.opened), c.ConnState(), c.LocalMultiaddr(), formatConnID(c.RemotePeer(), c.id))
+	} else {
+		c.metricsTracer.ClosedConnection(c.dir, time.Since(c.opened), c.ConnState(), c.LocalMultiaddr())
+	}
 	return c.CapableConn.Close()
 }
 
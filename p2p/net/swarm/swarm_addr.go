@@ -23,6 +23,19 @@ func (s *Swarm) listenAddressesNoLock() []ma.Multiaddr {
 	return addrs
 }
 
+// ConfiguredListenAddr returns the address passed to Listen for the listener
+// listening on listenAddr, e.g. /ip4/0.0.0.0/tcp/0 for /ip4/0.0.0.0/tcp/4001.
+func (s *Swarm) ConfiguredListenAddr(listenAddr ma.Multiaddr) (ma.Multiaddr, bool) {
+	s.listeners.RLock()
+	defer s.listeners.RUnlock()
+	for l, configured := range s.listeners.m {
+		if l.Multiaddr().Equal(listenAddr) {
+			return configured, true
+		}
+	}
+	return nil, false
+}
+
 const ifaceAddrsCacheDuration = 1 * time.Minute
 
 // InterfaceListenAddresses returns a list of addresses at which this swarm
@@ -5,11 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	ic "github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/core/transport"
 
 	ma "github.com/multiformats/go-multiaddr"
@@ -35,9 +37,57 @@ type Conn struct {
 	streams struct {
 		sync.Mutex
 		m map[*Stream]struct{}
+		// protocols holds the activity of the closed streams, per protocol.
+		protocols map[protocol.ID]*ProtocolActivity
 	}
 
 	stat network.ConnStats
+
+	draining atomic.Bool
+
+	// protocolsKnown is set if the remote peer's protocols were in the
+	// peerstore when the connection was established, or once the peer was
+	// identified, see network.Stats.Early.
+	protocolsKnown atomic.Bool
+
+	streamResets atomic.Uint64
+	writeStalls  atomic.Uint64
+
+	// transport is the transport name passed to a metrics.TransportReporter.
+	transport string
+
+	negFailures struct {
+		sync.Mutex
+		l []network.NegotiationFailure
+	}
+}
+
+// maxNegotiationFailures is the number of negotiation failures kept per
+// connection.
+const maxNegotiationFailures = 8
+
+// ConnHealth contains the counters the swarm keeps about the health of a connection.
+type ConnHealth struct {
+	// StreamResets is the number of streams that were reset by the remote peer.
+	StreamResets uint64
+	// WriteStalls is the number of stream writes that blocked for longer than 5 seconds
+	// while writing less than 16 KiB per second, usually because the flow control window
+	// was exhausted and the peer stopped reading.
+	WriteStalls uint64
+}
+
+// ProtocolActivity describes how a protocol was used on a connection.
+type ProtocolActivity struct {
+	// Streams is the number of streams opened for the protocol.
+	Streams uint64
+	// OpenStreams is the number of streams of the protocol that are still open.
+	OpenStreams int
+	// BytesIn and BytesOut are the bytes read from and written to the streams
+	// of the protocol.
+	BytesIn, BytesOut uint64
+	// LastActive is the last time a stream of the protocol was opened, or
+	// data was read from or written to one.
+	LastActive time.Time
 }
 
 var _ network.Conn = &Conn{}
@@ -46,9 +96,150 @@ func (c *Conn) IsClosed() bool {
 	return c.conn.IsClosed()
 }
 
+// StartDraining marks the connection as draining: as long as there are other
+// connections to the peer, the swarm doesn't use it for new streams.
+// Existing streams are not affected.
+func (c *Conn) StartDraining() {
+	c.draining.Store(true)
+}
+
+// StopDraining reverts StartDraining, e.g. when the connection recovered.
+func (c *Conn) StopDraining() {
+	c.draining.Store(false)
+}
+
+// IsDraining returns true if StartDraining was called on the connection,
+// and StopDraining wasn't called since.
+func (c *Conn) IsDraining() bool {
+	return c.draining.Load()
+}
+
+// Health returns the health counters of the connection.
+func (c *Conn) Health() ConnHealth {
+	return ConnHealth{
+		StreamResets: c.streamResets.Load(),
+		WriteStalls:  c.writeStalls.Load(),
+	}
+}
+
+// ProtocolActivity returns the activity of every protocol that was used on
+// the connection, including protocols without open streams. Streams that
+// don't have a protocol set are not included.
+func (c *Conn) ProtocolActivity() map[protocol.ID]ProtocolActivity {
+	c.streams.Lock()
+	defer c.streams.Unlock()
+
+	res := make(map[protocol.ID]ProtocolActivity, len(c.streams.protocols))
+	for p, a := range c.streams.protocols {
+		res[p] = *a
+	}
+	for s := range c.streams.m {
+		p := s.Protocol()
+		if p == "" {
+			continue
+		}
+		a := res[p]
+		a.OpenStreams++
+		s.addActivity(&a)
+		res[p] = a
+	}
+	return res
+}
+
+// LastActive returns the last time a protocol was active on the connection,
+// see ProtocolActivity. It is zero if no protocol was used on the connection.
+func (c *Conn) LastActive() time.Time {
+	c.streams.Lock()
+	defer c.streams.Unlock()
+
+	var a ProtocolActivity
+	for _, pa := range c.streams.protocols {
+		if pa.LastActive.After(a.LastActive) {
+			a.LastActive = pa.LastActive
+		}
+	}
+	for s := range c.streams.m {
+		if s.Protocol() != "" {
+			s.addActivity(&a)
+		}
+	}
+	return a.LastActive
+}
+
+// recordProtocolStream counts a new stream for protocol p.
+func (c *Conn) recordProtocolStream(p protocol.ID) {
+	c.streams.Lock()
+	defer c.streams.Unlock()
+	c.protocolActivityLocked(p).Streams++
+}
+
+func (c *Conn) protocolActivityLocked(p protocol.ID) *ProtocolActivity {
+	if c.streams.protocols == nil {
+		c.streams.protocols = make(map[protocol.ID]*ProtocolActivity)
+	}
+	a, ok := c.streams.protocols[p]
+	if !ok {
+		a = &ProtocolActivity{}
+		c.streams.protocols[p] = a
+	}
+	return a
+}
+
+var _ network.ObservedAddrConn = &Conn{}
+
+// NotifyObservedAddr calls f with our address as observed by the remote peer,
+// if the transport of the connection reports it.
+func (c *Conn) NotifyObservedAddr(f func(ma.Multiaddr)) error {
+	oc, ok := c.conn.(network.ObservedAddrConn)
+	if !ok {
+		return network.ErrObservedAddrNotSupported
+	}
+	return oc.NotifyObservedAddr(f)
+}
+
+var _ network.NegotiationFailureConn = &Conn{}
+
+// RecordNegotiationFailure records a failed negotiation on a stream of the
+// connection. Only the most recent failures are kept.
+func (c *Conn) RecordNegotiationFailure(f network.NegotiationFailure) {
+	c.negFailures.Lock()
+	defer c.negFailures.Unlock()
+	if len(c.negFailures.l) >= maxNegotiationFailures {
+		c.negFailures.l = append(c.negFailures.l[:0], c.negFailures.l[1:]...)
+	}
+	c.negFailures.l = append(c.negFailures.l, f)
+}
+
+// NegotiationFailures returns the most recent failed negotiations on the
+// streams of the connection, oldest first.
+func (c *Conn) NegotiationFailures() []network.NegotiationFailure {
+	c.negFailures.Lock()
+	defer c.negFailures.Unlock()
+	return append([]network.NegotiationFailure(nil), c.negFailures.l...)
+}
+
+var _ network.KeepAliveConn = &Conn{}
+
+// SetKeepAliveInterval changes the keep-alive interval of the connection, if
+// its muxer sends keep-alives.
+func (c *Conn) SetKeepAliveInterval(d time.Duration) error {
+	kc, ok := c.conn.(network.KeepAliveConn)
+	if !ok {
+		return network.ErrKeepAliveNotSupported
+	}
+	return kc.SetKeepAliveInterval(d)
+}
+
 func (c *Conn) ID() string {
-	// format: <first 10 chars of peer id>-<global conn ordinal>
-	return fmt.Sprintf("%s-%d", c.RemotePeer().String()[:10], c.id)
+	return formatConnID(c.RemotePeer(), c.id)
+}
+
+// formatConnID returns the ID of the connection to p with the given ordinal.
+// It's stable for the lifetime of the connection, and used to correlate logs
+// and metrics.
+func formatConnID(p peer.ID, ordinal uint64) string {
+	// format: <short peer id>-<global conn ordinal>
+	return fmt.Sprintf("%s-%d", p.Short(), ordinal)
 }
 
 // Close closes this connection.
@@ -64,6 +255,7 @@ func (c *Conn) Close() error {
 
 func (c *Conn) doClose() {
 	c.swarm.removeConn(c)
+	log.Debugw("closing connection", "conn", c.ID(), "peer", c.RemotePeer().Short())
 
 	// Prevent new streams from opening.
 	c.streams.Lock()
@@ -96,8 +288,12 @@ func (c *Conn) removeStream(s *Stream) {
 	c.streams.Lock()
 	c.stat.NumStreams--
 	delete(c.streams.m, s)
+	if p := s.Protocol(); p != "" {
+		s.addActivity(c.protocolActivityLocked(p))
+	}
 	c.streams.Unlock()
 	s.scope.Done()
+	log.Debugw("stream closed", "stream", s.ID())
 }
 
 // listens for new streams.
@@ -121,7 +317,7 @@ func (c *Conn) start() {
 			}
 			c.swarm.refs.Add(1)
 			go func() {
-				s, err := c.addStream(ts, network.DirInbound, scope)
+				s, err := c.addStream(ts, network.DirInbound, scope, nil)
 
 				// Don't defer this. We don't want to block
 				// swarm shutdown on the connection handler.
@@ -195,7 +391,7 @@ func (c *Conn) Stat() network.ConnStats {
 func (c *Conn) NewStream(ctx context.Context) (network.Stream, error) {
 	if c.Stat().Transient {
 		if useTransient, _ := network.GetUseTransient(ctx); !useTransient {
-			return nil, network.ErrTransientConn
+			return nil, network.ErrLimitedConn
 		}
 	}
 
@@ -217,10 +413,12 @@ func (c *Conn) openAndAddStream(ctx context.Context, scope network.StreamManagem
 	if err != nil {
 		return nil, err
 	}
-	return c.addStream(ts, network.DirOutbound, scope)
+	return c.addStream(ts, network.DirOutbound, scope, network.GetStreamValues(ctx))
 }
 
-func (c *Conn) addStream(ts network.MuxedStream, dir network.Direction, scope network.StreamManagementScope) (*Stream, error) {
+func (c *Conn) addStream(ts network.MuxedStream, dir network.Direction, scope network.StreamManagementScope, extra map[interface{}]interface{}) (*Stream, error) {
+	early := !c.protocolsKnown.Load()
+
 	c.streams.Lock()
 	// Are we still online?
 	if c.streams.m == nil {
@@ -237,6 +435,9 @@ func (c *Conn) addStream(ts network.MuxedStream, dir network.Direction, scope ne
 		stat: network.Stats{
 			Direction: dir,
 			Opened:    time.Now(),
+			Transient: c.stat.Transient,
+			Early:     early,
+			Extra:     extra,
 		},
 		id:                             c.swarm.nextStreamID.Add(1),
 		acceptStreamGoroutineCompleted: dir != network.DirInbound,
@@ -249,6 +450,7 @@ func (c *Conn) addStream(ts network.MuxedStream, dir network.Direction, scope ne
 	c.swarm.refs.Add(1)
 
 	c.streams.Unlock()
+	log.Debugw("stream opened", "stream", s.ID(), "dir", dir)
 	return s, nil
 }
 
@@ -9,8 +9,10 @@ import (
 	"sync"
 	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/libp2p/go-libp2p/core/canonicallog"
 	"github.com/libp2p/go-libp2p/core/network"
+	neterrors "github.com/libp2p/go-libp2p/core/network/errors"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/core/transport"
@@ -55,16 +57,21 @@ var (
 	ErrAllDialsFailed = errors.New("all dials failed")
 
 	// ErrNoAddresses is returned when we fail to find any addresses for a
-	// peer we're trying to dial.
-	ErrNoAddresses = errors.New("no addresses")
+	// peer we're trying to dial. It matches network.ErrNoRemoteAddrs.
+	ErrNoAddresses = neterrors.Classify(neterrors.ErrNoRemoteAddrs, errors.New("no addresses"))
 
 	// ErrNoGoodAddresses is returned when we find addresses for a peer but
-	// can't use any of them.
-	ErrNoGoodAddresses = errors.New("no good addresses")
+	// can't use any of them. It matches network.ErrNoRemoteAddrs.
+	ErrNoGoodAddresses = neterrors.Classify(neterrors.ErrNoRemoteAddrs, errors.New("no good addresses"))
 
 	// ErrGaterDisallowedConnection is returned when the gater prevents us from
-	// forming a connection with a peer.
-	ErrGaterDisallowedConnection = errors.New("gater disallows connection to peer")
+	// forming a connection with a peer. It matches network.ErrGated.
+	ErrGaterDisallowedConnection = neterrors.Classify(neterrors.ErrGated, errors.New("gater disallows connection to peer"))
+
+	// ErrAddrProvenanceFiltered is returned for addresses that weren't dialed
+	// because of their provenance, see WithDialAddrProvenanceFilter and
+	// WithMinDialAddrTrust.
+	ErrAddrProvenanceFiltered = errors.New("address filtered by provenance")
 )
 
 // ErrQUICDraft29 wraps ErrNoTransport and provide a more meaningful error message
@@ -106,6 +113,8 @@ var DefaultPerPeerRateLimit = 8
 type DialBackoff struct {
 	entries map[peer.ID]map[string]*backoffAddr
 	lock    sync.RWMutex
+	// clock defaults to the system clock if nil.
+	clock clock.Clock
 }
 
 type backoffAddr struct {
@@ -113,6 +122,13 @@ type backoffAddr struct {
 	until time.Time
 }
 
+func (db *DialBackoff) now() time.Time {
+	if db.clock == nil {
+		return time.Now()
+	}
+	return db.clock.Now()
+}
+
 func (db *DialBackoff) init(ctx context.Context) {
 	if db.entries == nil {
 		db.entries = make(map[peer.ID]map[string]*backoffAddr)
@@ -140,7 +156,7 @@ func (db *DialBackoff) Backoff(p peer.ID, addr ma.Multiaddr) (backoff bool) {
 	defer db.lock.RUnlock()
 
 	ap, found := db.entries[p][string(addr.Bytes())]
-	return found && time.Now().Before(ap.until)
+	return found && db.now().Before(ap.until)
 }
 
 // BackoffBase is the base amount of time to backoff (default: 5s).
@@ -173,7 +189,7 @@ func (db *DialBackoff) AddBackoff(p peer.ID, addr ma.Multiaddr) {
 	if !ok {
 		bp[saddr] = &backoffAddr{
 			tries: 1,
-			until: time.Now().Add(BackoffBase),
+			until: db.now().Add(BackoffBase),
 		}
 		return
 	}
@@ -182,7 +198,7 @@ func (db *DialBackoff) AddBackoff(p peer.ID, addr ma.Multiaddr) {
 	if backoffTime > BackoffMax {
 		backoffTime = BackoffMax
 	}
-	ba.until = time.Now().Add(backoffTime)
+	ba.until = db.now().Add(backoffTime)
 	ba.tries++
 }
 
@@ -197,7 +213,7 @@ func (db *DialBackoff) Clear(p peer.ID) {
 func (db *DialBackoff) cleanup() {
 	db.lock.Lock()
 	defer db.lock.Unlock()
-	now := time.Now()
+	now := db.now()
 	for p, e := range db.entries {
 		good := false
 		for _, backoff := range e {
@@ -300,6 +316,12 @@ func (s *Swarm) addrsForDial(ctx context.Context, p peer.ID) (goodAddrs []ma.Mul
 	if len(peerAddrs) == 0 {
 		return nil, nil, ErrNoAddresses
 	}
+	// Filter by provenance before resolving, since only the addresses stored
+	// in the peerstore have a provenance.
+	peerAddrs, addrErrs = s.filterByProvenance(p, peerAddrs)
+	if len(peerAddrs) == 0 {
+		return nil, addrErrs, ErrNoGoodAddresses
+	}
 
 	// Resolve dns or dnsaddrs
 	resolved, err := s.resolveAddrs(ctx, peer.AddrInfo{ID: p, Addrs: peerAddrs})
@@ -308,10 +330,23 @@ func (s *Swarm) addrsForDial(ctx context.Context, p peer.ID) (goodAddrs []ma.Mul
 	}
 
 	goodAddrs = ma.Unique(resolved)
-	goodAddrs, addrErrs = s.filterKnownUndialables(p, goodAddrs)
+	goodAddrs, undialableErrs := s.filterKnownUndialables(p, goodAddrs)
+	addrErrs = append(addrErrs, undialableErrs...)
+	goodAddrs, diversityErrs := s.filterByDiversity(goodAddrs)
+	addrErrs = append(addrErrs, diversityErrs...)
 	if forceDirect, _ := network.GetForceDirectDial(ctx); forceDirect {
 		goodAddrs = ma.FilterAddrs(goodAddrs, s.nonProxyAddr)
 	}
+	// Simultaneous connects are only possible on transports that support hole punching.
+	if simConnect, _, _ := network.GetSimultaneousConnect(ctx); simConnect {
+		goodAddrs = ma.FilterAddrs(goodAddrs, func(a ma.Multiaddr) bool {
+			caps, _ := s.TransportCapabilities(a)
+			return caps.Has(transport.CapHolePunching)
+		})
+	}
+	if match, _ := network.GetDialAddrFilter(ctx); match != nil {
+		goodAddrs = ma.FilterAddrs(goodAddrs, match)
+	}
 
 	if len(goodAddrs) == 0 {
 		return nil, addrErrs, ErrNoGoodAddresses
@@ -322,6 +357,44 @@ func (s *Swarm) addrsForDial(ctx context.Context, p peer.ID) (goodAddrs []ma.Mul
 	return goodAddrs, addrErrs, nil
 }
 
+// filterByProvenance removes the addresses that aren't trusted enough, or that
+// the dial address provenance filter doesn't allow. Addresses without a
+// recorded provenance are kept.
+func (s *Swarm) filterByProvenance(p peer.ID, addrs []ma.Multiaddr) (goodAddrs []ma.Multiaddr, addrErrs []TransportError) {
+	if s.dialProvenanceFilter == nil && s.minDialAddrTrust == peerstore.AddrTrustLow {
+		return addrs, nil
+	}
+	sab, ok := peerstore.GetSourcedAddrBook(s.peers)
+	if !ok {
+		return addrs, nil
+	}
+	var trusted map[string]struct{}
+	if s.minDialAddrTrust > peerstore.AddrTrustLow {
+		trustedAddrs := peerstore.AddrsWithMinTrust(s.peers, p, s.minDialAddrTrust)
+		trusted = make(map[string]struct{}, len(trustedAddrs))
+		for _, a := range trustedAddrs {
+			trusted[string(a.Bytes())] = struct{}{}
+		}
+	}
+	allowed := func(a ma.Multiaddr, prov peerstore.AddrProvenance) bool {
+		if trusted != nil {
+			if _, ok := trusted[string(a.Bytes())]; !ok {
+				return false
+			}
+		}
+		return s.dialProvenanceFilter == nil || s.dialProvenanceFilter(p, a, prov)
+	}
+	goodAddrs = ma.FilterAddrs(addrs, func(a ma.Multiaddr) bool {
+		prov, ok := sab.AddrProvenance(p, a)
+		if !ok || allowed(a, prov) {
+			return true
+		}
+		addrErrs = append(addrErrs, TransportError{Address: a, Cause: ErrAddrProvenanceFiltered})
+		return false
+	})
+	return goodAddrs, addrErrs
+}
+
 func (s *Swarm) resolveAddrs(ctx context.Context, pi peer.AddrInfo) ([]ma.Multiaddr, error) {
 	p2paddr, err := ma.NewMultiaddr("/" + ma.ProtocolWithCode(ma.P_P2P).Name + "/" + pi.ID.String())
 	if err != nil {
@@ -536,6 +609,12 @@ func (s *Swarm) dialAddr(ctx context.Context, p peer.ID, addr ma.Multiaddr, updC
 		return nil, ErrNoTransport
 	}
 
+	if isRelayAddr(addr) {
+		// The relay transport dials the relay through the swarm. Mark these
+		// dials, so that they can be told apart when they are coalesced.
+		ctx = context.WithValue(ctx, relayLegDialKey{}, struct{}{})
+	}
+
 	start := time.Now()
 	var connC transport.CapableConn
 	var err error
@@ -554,11 +633,15 @@ func (s *Swarm) dialAddr(ctx context.Context, p peer.ID, addr ma.Multiaddr, updC
 		if s.metricsTracer != nil {
 			s.metricsTracer.FailedDialing(addr, err, context.Cause(ctx))
 		}
+		var skewErr transport.ClockSkewError
+		if errors.As(err, &skewErr) {
+			s.recordClockSkew(p, skewErr.ClockSkew())
+		}
 		return nil, err
 	}
 	canonicallog.LogPeerStatus(100, connC.RemotePeer(), connC.RemoteMultiaddr(), "connection_status", "established", "dir", "outbound")
 	if s.metricsTracer != nil {
-		connWithMetrics := wrapWithMetrics(connC, s.metricsTracer, start, network.DirOutbound)
+		connWithMetrics := s.wrapWithMetrics(connC, start, network.DirOutbound)
 		connWithMetrics.completedHandshake()
 		connC = connWithMetrics
 	}
@@ -575,6 +658,15 @@ func (s *Swarm) dialAddr(ctx context.Context, p peer.ID, addr ma.Multiaddr, updC
 	return connC, nil
 }
 
+type relayLegDialKey struct{}
+
+// isRelayLegDial returns true if the dial was started by a relay transport, to
+// connect to the relay of a relayed dial.
+func isRelayLegDial(ctx context.Context) bool {
+	_, ok := ctx.Value(relayLegDialKey{}).(struct{})
+	return ok
+}
+
 // TODO We should have a `IsFdConsuming() bool` method on the `Transport` interface in go-libp2p/core/transport.
 // This function checks if any of the transport protocols in the address requires a file descriptor.
 // For now:
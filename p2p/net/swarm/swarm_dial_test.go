@@ -10,11 +10,13 @@ import (
 	"testing"
 	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/libp2p/go-libp2p/core/transport"
 	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
 	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
 	libp2pquic "github.com/libp2p/go-libp2p/p2p/transport/quic"
@@ -72,6 +74,62 @@ func TestAddrsForDial(t *testing.T) {
 	require.NotZero(t, len(mas))
 }
 
+func TestAddrsForDialProvenanceFilter(t *testing.T) {
+	s := newTestSwarmWithResolver(t, nil)
+	defer s.Close()
+	s.dialProvenanceFilter = func(_ peer.ID, _ ma.Multiaddr, prov peerstore.AddrProvenance) bool {
+		return prov.Source != peerstore.AddrSourceDHT
+	}
+
+	otherPeer := test.RandPeerIDFatal(t)
+	fromDHT := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	fromIdentify := ma.StringCast("/ip4/1.2.3.4/tcp/2")
+	unknown := ma.StringCast("/ip4/1.2.3.4/tcp/3")
+	peerstore.AddAddrsFromSource(s.Peerstore(), otherPeer, []ma.Multiaddr{fromDHT}, time.Hour, peerstore.AddrSourceDHT)
+	peerstore.AddAddrsFromSource(s.Peerstore(), otherPeer, []ma.Multiaddr{fromIdentify}, time.Hour, peerstore.AddrSourceIdentify)
+	s.Peerstore().AddAddr(otherPeer, unknown, time.Hour)
+
+	mas, addrErrs, err := s.addrsForDial(context.Background(), otherPeer)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []ma.Multiaddr{fromIdentify, unknown}, mas)
+	require.Len(t, addrErrs, 1)
+	require.Equal(t, fromDHT, addrErrs[0].Address)
+	require.ErrorIs(t, addrErrs[0].Cause, ErrAddrProvenanceFiltered)
+
+	// all addresses filtered
+	s.Peerstore().ClearAddrs(otherPeer)
+	peerstore.AddAddrsFromSource(s.Peerstore(), otherPeer, []ma.Multiaddr{fromDHT}, time.Hour, peerstore.AddrSourceDHT)
+	_, addrErrs, err = s.addrsForDial(context.Background(), otherPeer)
+	require.ErrorIs(t, err, ErrNoGoodAddresses)
+	require.Len(t, addrErrs, 1)
+}
+
+func TestAddrsForDialMinTrust(t *testing.T) {
+	s := newTestSwarmWithResolver(t, nil)
+	defer s.Close()
+	s.minDialAddrTrust = peerstore.AddrTrustMedium
+
+	otherPeer := test.RandPeerIDFatal(t)
+	fromDHT := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	fromMDNS := ma.StringCast("/ip4/1.2.3.4/tcp/2")
+	manual := ma.StringCast("/ip4/1.2.3.4/tcp/3")
+	peerstore.AddAddrsFromSource(s.Peerstore(), otherPeer, []ma.Multiaddr{fromDHT}, time.Hour, peerstore.AddrSourceDHT)
+	peerstore.AddAddrsFromSource(s.Peerstore(), otherPeer, []ma.Multiaddr{fromMDNS}, time.Hour, peerstore.AddrSourceMDNS)
+	peerstore.AddAddrsFromSource(s.Peerstore(), otherPeer, []ma.Multiaddr{manual}, time.Hour, peerstore.AddrSourceManual)
+
+	mas, addrErrs, err := s.addrsForDial(context.Background(), otherPeer)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []ma.Multiaddr{fromMDNS, manual}, mas)
+	require.Len(t, addrErrs, 1)
+	require.Equal(t, fromDHT, addrErrs[0].Address)
+	require.ErrorIs(t, addrErrs[0].Cause, ErrAddrProvenanceFiltered)
+
+	s.minDialAddrTrust = peerstore.AddrTrustHigh
+	mas, _, err = s.addrsForDial(context.Background(), otherPeer)
+	require.NoError(t, err)
+	require.Equal(t, []ma.Multiaddr{manual}, mas)
+}
+
 func TestDedupAddrsForDial(t *testing.T) {
 	mockResolver := madns.MockResolver{IP: make(map[string][]net.IPAddr)}
 	ipaddr, err := net.ResolveIPAddr("ip4", "1.2.3.4")
@@ -278,6 +336,53 @@ func TestAddrResolutionRecursiveTransportSpecific(t *testing.T) {
 	require.Equal(t, "/ip4/1.2.3.4/tcp/443/tls/sni/sub.example.com/ws", addrs[0].String())
 }
 
+func TestTransportCapabilities(t *testing.T) {
+	s := newTestSwarmWithResolver(t, nil)
+
+	quicCaps := transport.CapHolePunching | transport.CapDatagrams | transport.CapSharedUDPPort
+	wtCaps := transport.CapBrowser | transport.CapCerthash | transport.CapSharedUDPPort
+	for _, tc := range []struct {
+		addr string
+		caps transport.Capabilities
+	}{
+		{"/ip4/1.2.3.4/tcp/1234", transport.CapHolePunching},
+		{"/ip4/1.2.3.4/tcp/1234/ws", transport.CapBrowser},
+		{"/ip4/1.2.3.4/udp/1234/quic-v1", quicCaps},
+		{"/ip4/1.2.3.4/udp/1234/quic-v1/webtransport/certhash/uEiDDq4_xNyDorZBH3TlGazyJdOWSwvo4PUo5YHFMrvDE8g", wtCaps},
+		// can't be dialed without a certhash, but we know the transport
+		{"/ip4/1.2.3.4/udp/1234/quic-v1/webtransport", wtCaps},
+	} {
+		caps, ok := s.TransportCapabilities(ma.StringCast(tc.addr))
+		require.True(t, ok, tc.addr)
+		require.Equal(t, tc.caps, caps, tc.addr)
+	}
+
+	_, ok := s.TransportCapabilities(ma.StringCast("/ip4/1.2.3.4/udp/1234/webrtc-direct"))
+	require.False(t, ok)
+	require.True(t, transport.NeedsCerthash(s, ma.StringCast("/ip4/1.2.3.4/udp/1234/quic-v1/webtransport")))
+	require.False(t, transport.NeedsCerthash(s, ma.StringCast("/ip4/1.2.3.4/tcp/1234")))
+}
+
+func TestAddrsForDialSimultaneousConnect(t *testing.T) {
+	s := newTestSwarmWithResolver(t, nil)
+
+	otherPeer := test.RandPeerIDFatal(t)
+	tcpAddr := ma.StringCast("/ip4/1.2.3.4/tcp/1234")
+	quicAddr := ma.StringCast("/ip4/1.2.3.4/udp/1235/quic-v1")
+	wsAddr := ma.StringCast("/ip4/1.2.3.4/tcp/1236/ws")
+	s.Peerstore().AddAddrs(otherPeer, []ma.Multiaddr{tcpAddr, quicAddr, wsAddr}, time.Hour)
+
+	addrs, _, err := s.addrsForDial(context.Background(), otherPeer)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []ma.Multiaddr{tcpAddr, quicAddr, wsAddr}, addrs)
+
+	// only transports supporting hole punching are used for simultaneous connects
+	ctx := network.WithSimultaneousConnect(context.Background(), true, "test")
+	addrs, _, err = s.addrsForDial(ctx, otherPeer)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []ma.Multiaddr{tcpAddr, quicAddr}, addrs)
+}
+
 func TestAddrsForDialFiltering(t *testing.T) {
 	q1 := ma.StringCast("/ip4/1.2.3.4/udp/1/quic-v1")
 	q1v1 := ma.StringCast("/ip4/1.2.3.4/udp/1/quic-v1")
@@ -397,3 +502,19 @@ func TestBlackHoledAddrBlocked(t *testing.T) {
 	}
 	require.ErrorIs(t, err, ErrDialRefusedBlackHole)
 }
+
+func TestDialBackoffClock(t *testing.T) {
+	cl := clock.NewMock()
+	s := makeSwarmWithNoListenAddrs(t, WithClock(cl))
+	defer s.Close()
+
+	p := test.RandPeerIDFatal(t)
+	addr := ma.StringCast("/ip4/1.2.3.4/tcp/1234")
+	s.backf.AddBackoff(p, addr)
+	require.True(t, s.backf.Backoff(p, addr))
+
+	cl.Add(BackoffBase - time.Millisecond)
+	require.True(t, s.backf.Backoff(p, addr))
+	cl.Add(time.Millisecond)
+	require.False(t, s.backf.Backoff(p, addr))
+}
@@ -0,0 +1,108 @@
+package swarm
+
+import (
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ConnInfo describes an open connection, see Swarm.Introspect.
+type ConnInfo struct {
+	// ID is the ID of the connection, as used in logs and metrics exemplars.
+	ID         string
+	Peer       peer.ID
+	LocalAddr  ma.Multiaddr
+	RemoteAddr ma.Multiaddr
+	Direction  network.Direction
+	Opened     time.Time
+	Transient  bool
+	// Transport, Security and Muxer are the transport, the security protocol
+	// and the stream multiplexer of the connection, see
+	// network.ConnectionState.
+	Transport string
+	Security  protocol.ID
+	Muxer     protocol.ID
+	// TLS are the details of the TLS session, for the connections secured
+	// using TLS (the TLS security protocol, QUIC and WebTransport), and nil
+	// otherwise.
+	TLS *network.TLSConnectionState
+	// Protections are the protections of the peer, if the connection manager
+	// set with WithConnManager supports expiring protections.
+	Protections []connmgr.Protection
+	// Protocols is the activity of every protocol used on the connection,
+	// see Conn.ProtocolActivity.
+	Protocols map[protocol.ID]ProtocolActivity
+	// NegotiationFailures are the most recent failed protocol negotiations
+	// on the connection, oldest first, see Conn.NegotiationFailures.
+	NegotiationFailures []network.NegotiationFailure
+	// Streams are the open streams of the connection, oldest first.
+	Streams []StreamInfo
+}
+
+// StreamInfo describes an open stream, see Swarm.Introspect.
+type StreamInfo struct {
+	// ID is the ID of the stream, as used in logs.
+	ID        string
+	Protocol  protocol.ID
+	Direction network.Direction
+	Opened    time.Time
+}
+
+// Introspect returns the open connections, oldest first, with the protocols
+// used on them and their open streams. The IDs of the connections and streams
+// are the IDs used in logs and in the exemplars of the swarm metrics, so that a
+// slow handshake or a log line can be tied to a connection that is still open.
+func (s *Swarm) Introspect() []ConnInfo {
+	var conns []*Conn
+	s.conns.RLock()
+	for _, cs := range s.conns.m {
+		conns = append(conns, cs...)
+	}
+	s.conns.RUnlock()
+
+	ep, _ := connmgr.SupportsExpiringProtection(s.cmgr)
+	infos := make([]ConnInfo, 0, len(conns))
+	for _, c := range conns {
+		stat := c.Stat()
+		state := c.ConnState()
+		info := ConnInfo{
+			ID:         c.ID(),
+			Peer:       c.RemotePeer(),
+			LocalAddr:  c.LocalMultiaddr(),
+			RemoteAddr: c.RemoteMultiaddr(),
+			Direction:  stat.Direction,
+			Opened:     stat.Opened,
+			Transient:  stat.Transient,
+			Transport:  state.Transport,
+			Security:   state.Security,
+			Muxer:      state.StreamMultiplexer,
+			TLS:        state.TLS,
+			Protocols:  c.ProtocolActivity(),
+
+			NegotiationFailures: c.NegotiationFailures(),
+		}
+		if ep != nil {
+			info.Protections = ep.Protections(info.Peer)
+		}
+		c.streams.Lock()
+		for str := range c.streams.m {
+			info.Streams = append(info.Streams, StreamInfo{
+				ID:        str.ID(),
+				Protocol:  str.Protocol(),
+				Direction: str.stat.Direction,
+				Opened:    str.stat.Opened,
+			})
+		}
+		c.streams.Unlock()
+		sort.Slice(info.Streams, func(i, j int) bool { return info.Streams[i].Opened.Before(info.Streams[j].Opened) })
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Opened.Before(infos[j].Opened) })
+	return infos
+}
@@ -93,7 +93,7 @@ func (s *Swarm) AddListenAddr(a ma.Multiaddr) error {
 		return ErrSwarmClosed
 	}
 	s.refs.Add(1)
-	s.listeners.m[list] = struct{}{}
+	s.listeners.m[list] = a
 	s.listeners.cacheEOL = time.Time{}
 	s.listeners.Unlock()
 
@@ -135,14 +135,14 @@ func (s *Swarm) AddListenAddr(a ma.Multiaddr) error {
 			}
 			canonicallog.LogPeerStatus(100, c.RemotePeer(), c.RemoteMultiaddr(), "connection_status", "established", "dir", "inbound")
 			if s.metricsTracer != nil {
-				c = wrapWithMetrics(c, s.metricsTracer, time.Now(), network.DirInbound)
+				c = s.wrapWithMetrics(c, time.Now(), network.DirInbound)
 			}
 
 			log.Debugf("swarm listener accepted connection: %s <-> %s", c.LocalMultiaddr(), c.RemoteMultiaddr())
 			s.refs.Add(1)
 			go func() {
 				defer s.refs.Done()
-				_, err := s.addConn(c, network.DirInbound)
+				_, err := s.addConn(c, network.DirInbound, nil)
 				switch err {
 				case nil:
 				case ErrSwarmClosed:
@@ -18,6 +18,27 @@ import (
 
 const metricNamespace = "libp2p_swarm"
 
+var (
+	connLabels         = []string{"dir", "transport", "security", "muxer", "early_muxer", "ip_version"}
+	keyTypeLabels      = []string{"dir", "key_type"}
+	dialErrorLabels    = []string{"transport", "error", "ip_version"}
+	handshakeLabels    = []string{"transport", "security", "muxer", "early_muxer", "ip_version"}
+	dialsPerPeerLabels = []string{"outcome", "num_dials"}
+	blackHoleLabels    = []string{"name"}
+	coalescedLabels    = []string{"kind"}
+)
+
+var (
+	connDurationBuckets         = prometheus.ExponentialBuckets(1.0/16, 2, 25) // up to 24 days
+	connHandshakeLatencyBuckets = prometheus.ExponentialBuckets(0.001, 1.3, 35)
+
+	// exemplarInterval is the minimum time between two connection ID exemplars
+	// in the same bucket of a histogram.
+	exemplarInterval              = 10 * time.Second
+	connDurationExemplars         = metricshelper.NewExemplarSampler(connDurationBuckets, exemplarInterval)
+	connHandshakeLatencyExemplars = metricshelper.NewExemplarSampler(connHandshakeLatencyBuckets, exemplarInterval)
+)
+
 var (
 	connsOpened = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -25,7 +46,7 @@ var (
 			Name:      "connections_opened_total",
 			Help:      "Connections Opened",
 		},
-		[]string{"dir", "transport", "security", "muxer", "early_muxer", "ip_version"},
+		connLabels,
 	)
 	keyTypes = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -33,7 +54,7 @@ var (
 			Name:      "key_types_total",
 			Help:      "key type",
 		},
-		[]string{"dir", "key_type"},
+		keyTypeLabels,
 	)
 	connsClosed = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -41,7 +62,7 @@ var (
 			Name:      "connections_closed_total",
 			Help:      "Connections Closed",
 		},
-		[]string{"dir", "transport", "security", "muxer", "early_muxer", "ip_version"},
+		connLabels,
 	)
 	dialError = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -49,25 +70,25 @@ var (
 			Name:      "dial_errors_total",
 			Help:      "Dial Error",
 		},
-		[]string{"transport", "error", "ip_version"},
+		dialErrorLabels,
 	)
 	connDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: metricNamespace,
 			Name:      "connection_duration_seconds",
 			Help:      "Duration of a Connection",
-			Buckets:   prometheus.ExponentialBuckets(1.0/16, 2, 25), // up to 24 days
+			Buckets:   connDurationBuckets,
 		},
-		[]string{"dir", "transport", "security", "muxer", "early_muxer", "ip_version"},
+		connLabels,
 	)
 	connHandshakeLatency = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: metricNamespace,
 			Name:      "handshake_latency_seconds",
 			Help:      "Duration of the libp2p Handshake",
-			Buckets:   prometheus.ExponentialBuckets(0.001, 1.3, 35),
+			Buckets:   connHandshakeLatencyBuckets,
 		},
-		[]string{"transport", "security", "muxer", "early_muxer", "ip_version"},
+		handshakeLabels,
 	)
 	dialsPerPeer = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -75,7 +96,7 @@ var (
 			Name:      "dials_per_peer_total",
 			Help:      "Number of addresses dialed per peer",
 		},
-		[]string{"outcome", "num_dials"},
+		dialsPerPeerLabels,
 	)
 	dialRankingDelay = prometheus.NewHistogram(
 		prometheus.HistogramOpts{
@@ -91,7 +112,7 @@ var (
 			Name:      "black_hole_filter_state",
 			Help:      "State of the black hole filter",
 		},
-		[]string{"name"},
+		blackHoleLabels,
 	)
 	blackHoleFilterSuccessFraction = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -99,7 +120,7 @@ var (
 			Name:      "black_hole_filter_success_fraction",
 			Help:      "Fraction of successful dials among the last n requests",
 		},
-		[]string{"name"},
+		blackHoleLabels,
 	)
 	blackHoleFilterNextRequestAllowedAfter = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -107,7 +128,15 @@ var (
 			Name:      "black_hole_filter_next_request_allowed_after",
 			Help:      "Number of requests after which the next request will be allowed",
 		},
-		[]string{"name"},
+		blackHoleLabels,
+	)
+	dialsCoalesced = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "dials_coalesced_total",
+			Help:      "Dials that joined a dial already in progress",
+		},
+		coalescedLabels,
 	)
 	collectors = []prometheus.Collector{
 		connsOpened,
@@ -121,6 +150,7 @@ var (
 		blackHoleFilterSuccessFraction,
 		blackHoleFilterState,
 		blackHoleFilterNextRequestAllowedAfter,
+		dialsCoalesced,
 	}
 )
 
@@ -134,12 +164,34 @@ type MetricsTracer interface {
 	UpdatedBlackHoleFilterState(name string, state blackHoleState, nextProbeAfter int, successFraction float64)
 }
 
-type metricsTracer struct{}
+// ConnIDMetricsTracer is an optional interface implemented by MetricsTracers
+// that attach the ID of the connection (see network.Conn.ID) as an exemplar to
+// the observed durations. If implemented, the swarm calls its methods instead
+// of ClosedConnection and CompletedHandshake.
+type ConnIDMetricsTracer interface {
+	ClosedConnectionWithID(dir network.Direction, d time.Duration, cs network.ConnectionState, laddr ma.Multiaddr, connID string)
+	CompletedHandshakeWithID(d time.Duration, cs network.ConnectionState, laddr ma.Multiaddr, connID string)
+}
+
+// CoalescedDialMetricsTracer is an optional interface implemented by
+// MetricsTracers that count the dials coalesced into a dial to the same peer
+// that is already in progress.
+type CoalescedDialMetricsTracer interface {
+	// CoalescedDial is called when a dial joins a dial to the same peer that
+	// is already in progress. relay is true if the dial was started by a relay
+	// transport to connect to the relay of a relayed dial.
+	CoalescedDial(relay bool)
+}
+
+type metricsTracer struct {
+	labels metricshelper.LabelFilter
+}
 
 var _ MetricsTracer = &metricsTracer{}
 
 type metricsTracerSetting struct {
-	reg prometheus.Registerer
+	reg            prometheus.Registerer
+	disabledLabels []string
 }
 
 type MetricsTracerOption func(*metricsTracerSetting)
@@ -152,13 +204,21 @@ func WithRegisterer(reg prometheus.Registerer) MetricsTracerOption {
 	}
 }
 
+// WithDisabledLabels drops the given labels (e.g. "transport" or "ip_version")
+// from all metrics, to limit their cardinality. See metricshelper.LabelFilter.
+func WithDisabledLabels(labels ...string) MetricsTracerOption {
+	return func(s *metricsTracerSetting) {
+		s.disabledLabels = append(s.disabledLabels, labels...)
+	}
+}
+
 func NewMetricsTracer(opts ...MetricsTracerOption) MetricsTracer {
 	setting := &metricsTracerSetting{reg: prometheus.DefaultRegisterer}
 	for _, opt := range opts {
 		opt(setting)
 	}
 	metricshelper.RegisterCollectors(setting.reg, collectors...)
-	return &metricsTracer{}
+	return &metricsTracer{labels: metricshelper.NewLabelFilter(setting.disabledLabels...)}
 }
 
 func appendConnectionState(tags []string, cs network.ConnectionState) []string {
@@ -188,32 +248,52 @@ func (m *metricsTracer) OpenedConnection(dir network.Direction, p crypto.PubKey,
 	*tags = append(*tags, metricshelper.GetDirection(dir))
 	*tags = appendConnectionState(*tags, cs)
 	*tags = append(*tags, metricshelper.GetIPVersion(laddr))
+	m.labels.Apply(connLabels, *tags)
 	connsOpened.WithLabelValues(*tags...).Inc()
 
+	if p == nil {
+		return
+	}
 	*tags = (*tags)[:0]
 	*tags = append(*tags, metricshelper.GetDirection(dir))
 	*tags = append(*tags, p.Type().String())
+	m.labels.Apply(keyTypeLabels, *tags)
 	keyTypes.WithLabelValues(*tags...).Inc()
 }
 
+var (
+	_ ConnIDMetricsTracer        = &metricsTracer{}
+	_ CoalescedDialMetricsTracer = &metricsTracer{}
+)
+
 func (m *metricsTracer) ClosedConnection(dir network.Direction, duration time.Duration, cs network.ConnectionState, laddr ma.Multiaddr) {
+	m.ClosedConnectionWithID(dir, duration, cs, laddr, "")
+}
+
+func (m *metricsTracer) ClosedConnectionWithID(dir network.Direction, duration time.Duration, cs network.ConnectionState, laddr ma.Multiaddr, connID string) {
 	tags := metricshelper.GetStringSlice()
 	defer metricshelper.PutStringSlice(tags)
 
 	*tags = append(*tags, metricshelper.GetDirection(dir))
 	*tags = appendConnectionState(*tags, cs)
 	*tags = append(*tags, metricshelper.GetIPVersion(laddr))
+	m.labels.Apply(connLabels, *tags)
 	connsClosed.WithLabelValues(*tags...).Inc()
-	connDuration.WithLabelValues(*tags...).Observe(duration.Seconds())
+	connDurationExemplars.Observe(connDuration.WithLabelValues(*tags...), duration.Seconds(), "conn_id", connID)
 }
 
 func (m *metricsTracer) CompletedHandshake(t time.Duration, cs network.ConnectionState, laddr ma.Multiaddr) {
+	m.CompletedHandshakeWithID(t, cs, laddr, "")
+}
+
+func (m *metricsTracer) CompletedHandshakeWithID(t time.Duration, cs network.ConnectionState, laddr ma.Multiaddr, connID string) {
 	tags := metricshelper.GetStringSlice()
 	defer metricshelper.PutStringSlice(tags)
 
 	*tags = appendConnectionState(*tags, cs)
 	*tags = append(*tags, metricshelper.GetIPVersion(laddr))
-	connHandshakeLatency.WithLabelValues(*tags...).Observe(t.Seconds())
+	m.labels.Apply(handshakeLabels, *tags)
+	connHandshakeLatencyExemplars.Observe(connHandshakeLatency.WithLabelValues(*tags...), t.Seconds(), "conn_id", connID)
 }
 
 func (m *metricsTracer) FailedDialing(addr ma.Multiaddr, dialErr error, cause error) {
@@ -247,6 +327,7 @@ func (m *metricsTracer) FailedDialing(addr ma.Multiaddr, dialErr error, cause er
 
 	*tags = append(*tags, transport, e)
 	*tags = append(*tags, metricshelper.GetIPVersion(addr))
+	m.labels.Apply(dialErrorLabels, *tags)
 	dialError.WithLabelValues(*tags...).Inc()
 }
 
@@ -267,6 +348,7 @@ func (m *metricsTracer) DialCompleted(success bool, totalDials int) {
 		numDials = numDialLabels[len(numDialLabels)-1]
 	}
 	*tags = append(*tags, numDials)
+	m.labels.Apply(dialsPerPeerLabels, *tags)
 	dialsPerPeer.WithLabelValues(*tags...).Inc()
 }
 
@@ -274,12 +356,26 @@ func (m *metricsTracer) DialRankingDelay(d time.Duration) {
 	dialRankingDelay.Observe(d.Seconds())
 }
 
+func (m *metricsTracer) CoalescedDial(relay bool) {
+	tags := metricshelper.GetStringSlice()
+	defer metricshelper.PutStringSlice(tags)
+
+	if relay {
+		*tags = append(*tags, "relay")
+	} else {
+		*tags = append(*tags, "peer")
+	}
+	m.labels.Apply(coalescedLabels, *tags)
+	dialsCoalesced.WithLabelValues(*tags...).Inc()
+}
+
 func (m *metricsTracer) UpdatedBlackHoleFilterState(name string, state blackHoleState,
 	nextProbeAfter int, successFraction float64) {
 	tags := metricshelper.GetStringSlice()
 	defer metricshelper.PutStringSlice(tags)
 
 	*tags = append(*tags, name)
+	m.labels.Apply(blackHoleLabels, *tags)
 
 	blackHoleFilterState.WithLabelValues(*tags...).Set(float64(state))
 	blackHoleFilterSuccessFraction.WithLabelValues(*tags...).Set(successFraction)
@@ -81,6 +81,10 @@ func TestMetricsNoAllocNoCover(t *testing.T) {
 	bhfNames := []string{"udp", "ipv6", "tcp", "icmp"}
 	bhfState := []blackHoleState{blackHoleStateAllowed, blackHoleStateBlocked}
 
+	connIDs := []string{"12D3KooWAb-1", "12D3KooWAb-2", "12D3KooWCd-3"}
+	idTracer := mt.(ConnIDMetricsTracer)
+	coalescedTracer := mt.(CoalescedDialMetricsTracer)
+
 	tests := map[string]func(){
 		"OpenedConnection": func() {
 			mt.OpenedConnection(randItem(directions), randItem(keys), randItem(connections), randItem(addrs))
@@ -91,9 +95,16 @@ func TestMetricsNoAllocNoCover(t *testing.T) {
 		"CompletedHandshake": func() {
 			mt.CompletedHandshake(time.Duration(mrand.Intn(100))*time.Second, randItem(connections), randItem(addrs))
 		},
+		"ClosedConnectionWithID": func() {
+			idTracer.ClosedConnectionWithID(randItem(directions), time.Duration(mrand.Intn(100))*time.Second, randItem(connections), randItem(addrs), randItem(connIDs))
+		},
+		"CompletedHandshakeWithID": func() {
+			idTracer.CompletedHandshakeWithID(time.Duration(mrand.Intn(100))*time.Second, randItem(connections), randItem(addrs), randItem(connIDs))
+		},
 		"FailedDialing":    func() { mt.FailedDialing(randItem(addrs), randItem(errors), randItem(errors)) },
 		"DialCompleted":    func() { mt.DialCompleted(mrand.Intn(2) == 1, mrand.Intn(10)) },
 		"DialRankingDelay": func() { mt.DialRankingDelay(time.Duration(mrand.Intn(1e10))) },
+		"CoalescedDial":    func() { coalescedTracer.CoalescedDial(mrand.Intn(2) == 1) },
 		"UpdatedBlackHoleFilterState": func() {
 			mt.UpdatedBlackHoleFilterState(
 				randItem(bhfNames),
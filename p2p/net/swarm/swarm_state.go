@@ -0,0 +1,247 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	ds "github.com/ipfs/go-datastore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+var stateKey = ds.NewKey("/libp2p/swarm/state")
+
+const (
+	// statePersistInterval is the interval at which the state is saved, in
+	// addition to when the swarm is closed.
+	statePersistInterval = 5 * time.Minute
+	// maxBlackHoleStateAge is the maximum age of a saved black hole detector
+	// state. Older states are ignored, the network may have changed since.
+	maxBlackHoleStateAge = time.Hour
+	// maxRecentPeers is the maximum number of recently connected peers saved.
+	maxRecentPeers = 1000
+)
+
+// WithStateDatastore makes the swarm save its dial backoffs, black hole
+// detector state and recently connected peers to d, and restore them when the
+// swarm is created. This prevents a restarting node from redialing peers that
+// recently failed, and from probing a black holed network again.
+func WithStateDatastore(d ds.Datastore) Option {
+	return func(s *Swarm) error {
+		if d == nil {
+			return errors.New("swarm: state datastore cannot be nil")
+		}
+		s.stateDatastore = d
+		return nil
+	}
+}
+
+type savedBackoff struct {
+	Peer  peer.ID
+	Addr  []byte
+	Tries int
+	Until time.Time
+}
+
+type savedBlackHoleFilter struct {
+	DialResults []bool
+}
+
+type savedRecentPeer struct {
+	Peer          peer.ID
+	LastConnected time.Time
+}
+
+type savedState struct {
+	SavedAt     time.Time
+	Backoffs    []savedBackoff                  `json:",omitempty"`
+	BlackHoles  map[string]savedBlackHoleFilter `json:",omitempty"`
+	RecentPeers []savedRecentPeer               `json:",omitempty"`
+}
+
+// recentPeers tracks the last time we were connected to peers.
+type recentPeers struct {
+	mu sync.Mutex
+	m  map[peer.ID]time.Time
+}
+
+func (r *recentPeers) record(p peer.ID, t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.m == nil {
+		r.m = make(map[peer.ID]time.Time)
+	}
+	if t.After(r.m[p]) {
+		r.m[p] = t
+	}
+}
+
+// sorted returns the peers, most recently connected first.
+func (r *recentPeers) sorted() []savedRecentPeer {
+	r.mu.Lock()
+	peers := make([]savedRecentPeer, 0, len(r.m))
+	for p, t := range r.m {
+		peers = append(peers, savedRecentPeer{Peer: p, LastConnected: t})
+	}
+	r.mu.Unlock()
+	sort.Slice(peers, func(i, j int) bool { return peers[i].LastConnected.After(peers[j].LastConnected) })
+	return peers
+}
+
+// prune removes all but the n most recently connected peers.
+func (r *recentPeers) prune(n int) {
+	peers := r.sorted()
+	if len(peers) <= n {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, rp := range peers[n:] {
+		delete(r.m, rp.Peer)
+	}
+}
+
+// RecentlyConnectedPeers returns the peers we were recently connected to,
+// including the ones restored from the state datastore, most recent first. It
+// is only tracked if the swarm was created WithStateDatastore.
+func (s *Swarm) RecentlyConnectedPeers() []peer.ID {
+	peers := s.recentPeers.sorted()
+	ids := make([]peer.ID, 0, len(peers))
+	for _, rp := range peers {
+		ids = append(ids, rp.Peer)
+	}
+	return ids
+}
+
+func (s *Swarm) restoreState() error {
+	b, err := s.stateDatastore.Get(s.ctx, stateKey)
+	if errors.Is(err, ds.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var st savedState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, b := range st.Backoffs {
+		if !b.Until.After(now) {
+			continue
+		}
+		addr, err := ma.NewMultiaddrBytes(b.Addr)
+		if err != nil {
+			continue
+		}
+		s.backf.restore(b.Peer, addr, b.Tries, b.Until)
+	}
+	if now.Sub(st.SavedAt) < maxBlackHoleStateAge {
+		if f, ok := st.BlackHoles["UDP"]; ok && s.bhd.udp != nil {
+			s.bhd.udp.restore(f.DialResults)
+		}
+		if f, ok := st.BlackHoles["IPv6"]; ok && s.bhd.ipv6 != nil {
+			s.bhd.ipv6.restore(f.DialResults)
+		}
+	}
+	for _, rp := range st.RecentPeers {
+		s.recentPeers.record(rp.Peer, rp.LastConnected)
+	}
+	return nil
+}
+
+func (s *Swarm) saveState(ctx context.Context) error {
+	now := time.Now()
+	for _, p := range s.Peers() {
+		s.recentPeers.record(p, now)
+	}
+	s.recentPeers.prune(maxRecentPeers)
+
+	st := savedState{
+		SavedAt:     now,
+		Backoffs:    s.backf.saved(now),
+		BlackHoles:  make(map[string]savedBlackHoleFilter, 2),
+		RecentPeers: s.recentPeers.sorted(),
+	}
+	if s.bhd.udp != nil {
+		st.BlackHoles["UDP"] = s.bhd.udp.saved()
+	}
+	if s.bhd.ipv6 != nil {
+		st.BlackHoles["IPv6"] = s.bhd.ipv6.saved()
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return s.stateDatastore.Put(ctx, stateKey, b)
+}
+
+func (s *Swarm) persistState() {
+	defer s.refs.Done()
+	t := time.NewTicker(statePersistInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := s.saveState(s.ctx); err != nil {
+				log.Warnf("failed to save swarm state: %s", err)
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (db *DialBackoff) restore(p peer.ID, addr ma.Multiaddr, tries int, until time.Time) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	bp, ok := db.entries[p]
+	if !ok {
+		bp = make(map[string]*backoffAddr, 1)
+		db.entries[p] = bp
+	}
+	bp[string(addr.Bytes())] = &backoffAddr{tries: tries, until: until}
+}
+
+func (db *DialBackoff) saved(now time.Time) []savedBackoff {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	var backoffs []savedBackoff
+	for p, e := range db.entries {
+		for a, ba := range e {
+			if ba.until.After(now) {
+				backoffs = append(backoffs, savedBackoff{Peer: p, Addr: []byte(a), Tries: ba.tries, Until: ba.until})
+			}
+		}
+	}
+	return backoffs
+}
+
+func (b *blackHoleFilter) restore(dialResults []bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(dialResults) > b.n {
+		dialResults = dialResults[len(dialResults)-b.n:]
+	}
+	b.dialResults = append(b.dialResults[:0], dialResults...)
+	b.successes = 0
+	for _, r := range b.dialResults {
+		if r {
+			b.successes++
+		}
+	}
+	b.updateState()
+	b.trackMetrics()
+}
+
+func (b *blackHoleFilter) saved() savedBlackHoleFilter {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return savedBlackHoleFilter{DialResults: append([]bool(nil), b.dialResults...)}
+}
@@ -0,0 +1,66 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistState(t *testing.T) {
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+
+	s := makeSwarmWithNoListenAddrs(t, WithStateDatastore(d))
+	deadPeer := test.RandPeerIDFatal(t)
+	deadAddr := ma.StringCast("/ip4/1.2.3.4/tcp/1234")
+	s.backf.AddBackoff(deadPeer, deadAddr)
+	s.backf.AddBackoff(deadPeer, deadAddr)
+	udpAddr := ma.StringCast("/ip4/1.2.3.4/udp/1234/quic-v1")
+	for i := 0; i < 100; i++ {
+		s.bhd.RecordResult(udpAddr, false)
+	}
+	require.Equal(t, blackHoleStateBlocked, s.bhd.udp.state)
+	recentPeer := test.RandPeerIDFatal(t)
+	s.recentPeers.record(recentPeer, time.Now())
+	s.Close()
+
+	s = makeSwarmWithNoListenAddrs(t, WithStateDatastore(d))
+	defer s.Close()
+	require.True(t, s.backf.Backoff(deadPeer, deadAddr))
+	require.Equal(t, 2, s.backf.entries[deadPeer][string(deadAddr.Bytes())].tries)
+	require.Equal(t, blackHoleStateBlocked, s.bhd.udp.state)
+	require.Equal(t, blackHoleStateProbing, s.bhd.ipv6.state)
+	require.Equal(t, []peer.ID{recentPeer}, s.RecentlyConnectedPeers())
+}
+
+func TestPersistStateIgnoresStaleBlackHoleState(t *testing.T) {
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	s := makeSwarmWithNoListenAddrs(t, WithStateDatastore(d))
+	udpAddr := ma.StringCast("/ip4/1.2.3.4/udp/1234/quic-v1")
+	for i := 0; i < 100; i++ {
+		s.bhd.RecordResult(udpAddr, false)
+	}
+	s.Close()
+
+	// make the saved state older than maxBlackHoleStateAge
+	b, err := d.Get(context.Background(), stateKey)
+	require.NoError(t, err)
+	var st savedState
+	require.NoError(t, json.Unmarshal(b, &st))
+	st.SavedAt = st.SavedAt.Add(-2 * maxBlackHoleStateAge)
+	b, err = json.Marshal(st)
+	require.NoError(t, err)
+	require.NoError(t, d.Put(context.Background(), stateKey, b))
+
+	s = makeSwarmWithNoListenAddrs(t, WithStateDatastore(d))
+	defer s.Close()
+	require.Equal(t, blackHoleStateProbing, s.bhd.udp.state)
+}
@@ -1,15 +1,26 @@
 package swarm
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/libp2p/go-libp2p/core/metrics"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/protocol"
 )
 
+// A Write is counted as a write stall on the connection if it blocks for
+// longer than writeStallThreshold, while writing less than minWriteRate bytes
+// per second. Large writes slowed down by backpressure, but still making
+// progress, are not stalls.
+const (
+	writeStallThreshold = 5 * time.Second
+	minWriteRate        = 16 << 10
+)
+
 // Validate Stream conforms to the go-libp2p-net Stream interface
 var _ network.Stream = &Stream{}
 
@@ -29,11 +40,19 @@ type Stream struct {
 
 	protocol atomic.Pointer[protocol.ID]
 
+	// resetSeen is set once the stream was reset, locally or by the remote peer.
+	resetSeen atomic.Bool
+
+	// bytesIn, bytesOut and lastActive (in unix nanoseconds) are added to the
+	// protocol activity of the connection, see Conn.ProtocolActivity.
+	bytesIn, bytesOut atomic.Uint64
+	lastActive        atomic.Int64
+
 	stat network.Stats
 }
 
 func (s *Stream) ID() string {
-	// format: <first 10 chars of peer id>-<global conn ordinal>-<global stream ordinal>
+	// format: <short peer id>-<global conn ordinal>-<global stream ordinal>
 	return fmt.Sprintf("%s-%d", s.conn.ID(), s.id)
 }
 
@@ -56,21 +75,45 @@ func (s *Stream) Conn() network.Conn {
 // Read reads bytes from a stream.
 func (s *Stream) Read(p []byte) (int, error) {
 	n, err := s.stream.Read(p)
+	if n > 0 {
+		s.bytesIn.Add(uint64(n))
+		s.lastActive.Store(time.Now().UnixNano())
+	}
+	if err != nil && errors.Is(err, network.ErrReset) {
+		s.recordRemoteReset()
+	}
 	// TODO: push this down to a lower level for better accuracy.
 	if s.conn.swarm.bwc != nil {
 		s.conn.swarm.bwc.LogRecvMessage(int64(n))
 		s.conn.swarm.bwc.LogRecvMessageStream(int64(n), s.Protocol(), s.Conn().RemotePeer())
+		if tr := s.conn.swarm.bwcTransport; tr != nil {
+			tr.LogRecvMessageTransport(int64(n), s.conn.transport)
+		}
 	}
 	return n, err
 }
 
 // Write writes bytes to a stream, flushing for each call.
 func (s *Stream) Write(p []byte) (int, error) {
+	start := time.Now()
 	n, err := s.stream.Write(p)
+	if d := time.Since(start); d > writeStallThreshold && float64(n) < minWriteRate*d.Seconds() {
+		s.conn.writeStalls.Add(1)
+	}
+	if n > 0 {
+		s.bytesOut.Add(uint64(n))
+		s.lastActive.Store(start.UnixNano())
+	}
+	if err != nil && errors.Is(err, network.ErrReset) {
+		s.recordRemoteReset()
+	}
 	// TODO: push this down to a lower level for better accuracy.
 	if s.conn.swarm.bwc != nil {
 		s.conn.swarm.bwc.LogSentMessage(int64(n))
 		s.conn.swarm.bwc.LogSentMessageStream(int64(n), s.Protocol(), s.Conn().RemotePeer())
+		if tr := s.conn.swarm.bwcTransport; tr != nil {
+			tr.LogSentMessageTransport(int64(n), s.conn.transport)
+		}
 	}
 	return n, err
 }
@@ -86,11 +129,21 @@ func (s *Stream) Close() error {
 // Reset resets the stream, signaling an error on both ends and freeing all
 // associated resources.
 func (s *Stream) Reset() error {
+	// Local resets don't say anything about the health of the connection.
+	s.resetSeen.Store(true)
 	err := s.stream.Reset()
 	s.closeAndRemoveStream()
 	return err
 }
 
+// recordRemoteReset counts the stream towards the connection's stream resets,
+// unless it was already reset.
+func (s *Stream) recordRemoteReset() {
+	if s.resetSeen.CompareAndSwap(false, true) {
+		s.conn.streamResets.Add(1)
+	}
+}
+
 func (s *Stream) closeAndRemoveStream() {
 	s.closeMx.Lock()
 	defer s.closeMx.Unlock()
@@ -151,9 +204,26 @@ func (s *Stream) SetProtocol(p protocol.ID) error {
 	}
 
 	s.protocol.Store(&p)
+	s.lastActive.Store(time.Now().UnixNano())
+	s.conn.recordProtocolStream(p)
+	if sr, ok := s.conn.swarm.bwc.(metrics.StreamReporter); ok {
+		sr.LogStream(p, s.conn.RemotePeer())
+	}
 	return nil
 }
 
+// addActivity adds the bytes transferred on the stream to a, and updates
+// a.LastActive.
+func (s *Stream) addActivity(a *ProtocolActivity) {
+	a.BytesIn += s.bytesIn.Load()
+	a.BytesOut += s.bytesOut.Load()
+	if ns := s.lastActive.Load(); ns != 0 {
+		if t := time.Unix(0, ns); t.After(a.LastActive) {
+			a.LastActive = t
+		}
+	}
+}
+
 // SetDeadline sets the read and write deadlines for this stream.
 func (s *Stream) SetDeadline(t time.Time) error {
 	return s.stream.SetDeadline(t)
@@ -177,3 +247,15 @@ func (s *Stream) Stat() network.Stats {
 func (s *Stream) Scope() network.StreamScope {
 	return s.scope
 }
+
+var _ network.NoDelayStream = &Stream{}
+
+// SetNoDelay disables write coalescing for the stream, if the muxer of the
+// connection coalesces writes.
+func (s *Stream) SetNoDelay(noDelay bool) error {
+	ns, ok := s.stream.(network.NoDelayStream)
+	if !ok {
+		return network.ErrNoDelayNotSupported
+	}
+	return ns.SetNoDelay(noDelay)
+}
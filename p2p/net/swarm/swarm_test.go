@@ -12,18 +12,25 @@ import (
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/metrics"
 	"github.com/libp2p/go-libp2p/core/network"
 	mocknetwork "github.com/libp2p/go-libp2p/core/network/mocks"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/host/bandwidth"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
 	"github.com/libp2p/go-libp2p/p2p/net/swarm"
 	. "github.com/libp2p/go-libp2p/p2p/net/swarm/testing"
 
 	logging "github.com/ipfs/go-log/v2"
 	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
 )
@@ -568,3 +575,444 @@ func TestListenCloseCount(t *testing.T) {
 	_, err := remainingAddrs[0].ValueForProtocol(ma.P_TCP)
 	require.NoError(t, err, "expected the TCP address to still be present")
 }
+
+func TestDialAddrFilterAndDraining(t *testing.T) {
+	swarms := makeSwarms(t, 2)
+	s1, s2 := swarms[0], swarms[1]
+	defer s1.Close()
+	defer s2.Close()
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), s2.ListenAddresses(), peerstore.PermanentAddrTTL)
+
+	hasProto := func(code int) func(ma.Multiaddr) bool {
+		return func(a ma.Multiaddr) bool {
+			_, err := a.ValueForProtocol(code)
+			return err == nil
+		}
+	}
+	isTCP := hasProto(ma.P_TCP)
+	isQUIC := func(a ma.Multiaddr) bool {
+		return hasProto(ma.P_QUIC_V1)(a) && !hasProto(ma.P_WEBTRANSPORT)(a)
+	}
+
+	ctx := context.Background()
+	tcpConn, err := s1.DialPeer(network.WithDialAddrFilter(ctx, isTCP, "test"), s2.LocalPeer())
+	require.NoError(t, err)
+	require.True(t, isTCP(tcpConn.RemoteMultiaddr()))
+
+	// An existing connection that doesn't match the filter is not reused.
+	quicConn, err := s1.DialPeer(network.WithDialAddrFilter(ctx, isQUIC, "test"), s2.LocalPeer())
+	require.NoError(t, err)
+	require.True(t, isQUIC(quicConn.RemoteMultiaddr()))
+	require.Len(t, s1.ConnsToPeer(s2.LocalPeer()), 2)
+
+	// Draining connections are only used if there's no other connection.
+	quicConn.(*swarm.Conn).StartDraining()
+	for i := 0; i < 5; i++ {
+		str, err := s1.NewStream(ctx, s2.LocalPeer())
+		require.NoError(t, err)
+		require.Equal(t, tcpConn, str.Conn())
+		str.Close()
+	}
+	tcpConn.(*swarm.Conn).StartDraining()
+	str, err := s1.NewStream(ctx, s2.LocalPeer())
+	require.NoError(t, err)
+	str.Close()
+}
+
+func TestConnAndStreamValues(t *testing.T) {
+	type tenantKey struct{}
+	type traceKey struct{}
+
+	swarms := makeSwarms(t, 2, OptDisableQUIC)
+	s1, s2 := swarms[0], swarms[1]
+	defer s1.Close()
+	defer s2.Close()
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), s2.ListenAddresses(), peerstore.PermanentAddrTTL)
+
+	// The values are available when the connection is announced.
+	connected := make(chan interface{}, 1)
+	s1.Notify(&network.NotifyBundle{
+		ConnectedF: func(_ network.Network, c network.Conn) {
+			connected <- network.ConnValue(c, tenantKey{})
+		},
+	})
+
+	ctx := network.WithConnValue(context.Background(), tenantKey{}, "tenant")
+	c, err := s1.DialPeer(ctx, s2.LocalPeer())
+	require.NoError(t, err)
+	require.Equal(t, "tenant", network.ConnValue(c, tenantKey{}))
+	require.Equal(t, "tenant", <-connected)
+	require.Nil(t, network.ConnValue(c, traceKey{}))
+
+	str, err := s1.NewStream(network.WithStreamValue(context.Background(), traceKey{}, "trace"), s2.LocalPeer())
+	require.NoError(t, err)
+	defer str.Close()
+	require.Equal(t, "trace", network.StreamValue(str, traceKey{}))
+	require.Equal(t, "tenant", network.StreamValue(str, tenantKey{}))
+	require.Nil(t, network.ConnValue(str.Conn(), traceKey{}))
+
+	// Inbound connections don't carry the values.
+	require.Eventually(t, func() bool { return len(s2.ConnsToPeer(s1.LocalPeer())) > 0 }, 5*time.Second, 10*time.Millisecond)
+	require.Nil(t, network.ConnValue(s2.ConnsToPeer(s1.LocalPeer())[0], tenantKey{}))
+}
+
+func TestStreamState(t *testing.T) {
+	bus := eventbus.NewBus()
+	s1 := GenSwarm(t, OptDisableQUIC, EventBus(bus))
+	s2 := GenSwarm(t, OptDisableQUIC)
+	s2.SetStreamHandler(EchoStreamHandler)
+	defer s1.Close()
+	defer s2.Close()
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), s2.ListenAddresses(), peerstore.PermanentAddrTTL)
+
+	str, err := s1.NewStream(context.Background(), s2.LocalPeer())
+	require.NoError(t, err)
+	defer str.Close()
+	require.Equal(t, network.StreamState{
+		Direction:     network.DirOutbound,
+		ConnDirection: network.DirOutbound,
+		Early:         true,
+	}, network.GetStreamState(str))
+
+	// Once the peer is identified, streams are no longer early.
+	em, err := bus.Emitter(new(event.EvtPeerIdentificationCompleted))
+	require.NoError(t, err)
+	defer em.Close()
+	require.NoError(t, em.Emit(event.EvtPeerIdentificationCompleted{Peer: s2.LocalPeer(), Conn: str.Conn()}))
+	require.Eventually(t, func() bool {
+		str2, err := s1.NewStream(context.Background(), s2.LocalPeer())
+		require.NoError(t, err)
+		defer str2.Close()
+		require.Equal(t, str.Conn(), str2.Conn())
+		return !network.GetStreamState(str2).Early
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Streams on new connections aren't early if the peer's protocols are in
+	// the peerstore.
+	require.NoError(t, s1.Peerstore().AddProtocols(s2.LocalPeer(), "/test"))
+	require.NoError(t, s1.ClosePeer(s2.LocalPeer()))
+	str3, err := s1.NewStream(context.Background(), s2.LocalPeer())
+	require.NoError(t, err)
+	defer str3.Close()
+	require.NotEqual(t, str.Conn(), str3.Conn())
+	require.False(t, network.GetStreamState(str3).Early)
+}
+
+type connIDRecordingTracer struct {
+	swarm.MetricsTracer
+	handshakes chan string
+}
+
+func (t *connIDRecordingTracer) CompletedHandshakeWithID(d time.Duration, cs network.ConnectionState, laddr ma.Multiaddr, connID string) {
+	t.MetricsTracer.(swarm.ConnIDMetricsTracer).CompletedHandshakeWithID(d, cs, laddr, connID)
+	t.handshakes <- connID
+}
+
+func (t *connIDRecordingTracer) ClosedConnectionWithID(dir network.Direction, d time.Duration, cs network.ConnectionState, laddr ma.Multiaddr, connID string) {
+	t.MetricsTracer.(swarm.ConnIDMetricsTracer).ClosedConnectionWithID(dir, d, cs, laddr, connID)
+}
+
+func TestConnIDsInMetrics(t *testing.T) {
+	tracer := &connIDRecordingTracer{
+		MetricsTracer: swarm.NewMetricsTracer(swarm.WithRegisterer(prometheus.NewRegistry())),
+		handshakes:    make(chan string, 1),
+	}
+	s1 := GenSwarm(t, OptDisableQUIC, WithSwarmOpts(swarm.WithMetricsTracer(tracer)))
+	s2 := GenSwarm(t, OptDisableQUIC)
+	defer s1.Close()
+	defer s2.Close()
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), s2.ListenAddresses(), peerstore.PermanentAddrTTL)
+
+	c, err := s1.DialPeer(context.Background(), s2.LocalPeer())
+	require.NoError(t, err)
+	require.Equal(t, c.ID(), <-tracer.handshakes)
+	require.True(t, strings.HasPrefix(c.ID(), s2.LocalPeer().Short()+"-"))
+
+	str, err := c.NewStream(context.Background())
+	require.NoError(t, err)
+	defer str.Close()
+	require.True(t, strings.HasPrefix(str.ID(), c.ID()+"-"))
+}
+
+func TestIntrospect(t *testing.T) {
+	s1 := GenSwarm(t, OptDisableQUIC)
+	s2 := GenSwarm(t, OptDisableQUIC)
+	defer s1.Close()
+	defer s2.Close()
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), s2.ListenAddresses(), peerstore.PermanentAddrTTL)
+
+	c, err := s1.DialPeer(context.Background(), s2.LocalPeer())
+	require.NoError(t, err)
+	str, err := c.NewStream(context.Background())
+	require.NoError(t, err)
+	defer str.Close()
+
+	infos := s1.Introspect()
+	require.Len(t, infos, 1)
+	require.Equal(t, c.ID(), infos[0].ID)
+	require.Equal(t, s2.LocalPeer(), infos[0].Peer)
+	require.Equal(t, network.DirOutbound, infos[0].Direction)
+	require.Equal(t, "tcp", infos[0].Transport)
+	require.Equal(t, c.ConnState().Security, infos[0].Security)
+	require.Equal(t, c.ConnState().StreamMultiplexer, infos[0].Muxer)
+	require.Nil(t, infos[0].TLS, "the test swarms don't use TLS")
+	require.Len(t, infos[0].Streams, 1)
+	require.Equal(t, str.ID(), infos[0].Streams[0].ID)
+	require.Equal(t, network.DirOutbound, infos[0].Streams[0].Direction)
+
+	str.Reset()
+	require.Empty(t, s1.Introspect()[0].Streams)
+}
+
+type intentScope struct {
+	network.NullScope
+	intents chan network.Intent
+}
+
+func (s *intentScope) SetIntent(intent network.Intent) { s.intents <- intent }
+
+type intentResourceManager struct {
+	network.NullResourceManager
+	scope *intentScope
+}
+
+func (m *intentResourceManager) OpenConnection(network.Direction, bool, ma.Multiaddr) (network.ConnManagementScope, error) {
+	return m.scope, nil
+}
+
+func TestDialIntentScope(t *testing.T) {
+	rcmgr := &intentResourceManager{scope: &intentScope{intents: make(chan network.Intent, 1)}}
+	s1 := GenSwarm(t, OptDisableQUIC, OptResourceManager(rcmgr))
+	s2 := GenSwarm(t, OptDisableQUIC)
+	defer s1.Close()
+	defer s2.Close()
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), s2.ListenAddresses(), peerstore.PermanentAddrTTL)
+
+	intent := network.Intent{Bandwidth: network.BandwidthLow}
+	_, err := s1.DialPeer(network.WithIntent(context.Background(), intent), s2.LocalPeer())
+	require.NoError(t, err)
+	select {
+	case i := <-rcmgr.scope.intents:
+		require.Equal(t, intent, i)
+	default:
+		t.Fatal("the intent was not passed to the connection scope")
+	}
+}
+
+func TestIntrospectProtections(t *testing.T) {
+	cm, err := connmgr.NewConnManager(10, 20)
+	require.NoError(t, err)
+	defer cm.Close()
+	s1 := GenSwarm(t, OptDisableQUIC, WithSwarmOpts(swarm.WithConnManager(cm)))
+	s2 := GenSwarm(t, OptDisableQUIC)
+	defer s1.Close()
+	defer s2.Close()
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), s2.ListenAddresses(), peerstore.PermanentAddrTTL)
+
+	_, err = s1.DialPeer(context.Background(), s2.LocalPeer())
+	require.NoError(t, err)
+	require.Empty(t, s1.Introspect()[0].Protections)
+
+	cm.ProtectFor(s2.LocalPeer(), "foo", "testing", time.Hour)
+	protections := s1.Introspect()[0].Protections
+	require.Len(t, protections, 1)
+	require.Equal(t, "foo", protections[0].Tag)
+	require.Equal(t, "testing", protections[0].Reason)
+}
+
+func TestConnHealthStreamResets(t *testing.T) {
+	swarms := makeSwarms(t, 2)
+	s1, s2 := swarms[0], swarms[1]
+	defer s1.Close()
+	defer s2.Close()
+	s2.SetStreamHandler(func(s network.Stream) { s.Reset() })
+	connectSwarms(t, context.Background(), swarms)
+
+	var c *swarm.Conn
+	for i := 0; i < 3; i++ {
+		str, err := s1.NewStream(context.Background(), s2.LocalPeer())
+		require.NoError(t, err)
+		c = str.Conn().(*swarm.Conn)
+		_, err = str.Write([]byte("foobar"))
+		require.NoError(t, err)
+		_, err = str.Read(make([]byte, 1))
+		require.ErrorIs(t, err, network.ErrReset)
+		// subsequent errors on the same stream are not counted again
+		_, err = str.Read(make([]byte, 1))
+		require.Error(t, err)
+		str.Reset()
+	}
+	require.Equal(t, uint64(3), c.Health().StreamResets)
+
+	// local resets are not counted
+	str, err := s1.NewStream(context.Background(), s2.LocalPeer())
+	require.NoError(t, err)
+	str.Reset()
+	_, err = str.Read(make([]byte, 1))
+	require.Error(t, err)
+	require.Equal(t, uint64(3), str.Conn().(*swarm.Conn).Health().StreamResets)
+}
+
+func TestConnProtocolActivity(t *testing.T) {
+	swarms := makeSwarms(t, 2, OptDisableQUIC)
+	s1, s2 := swarms[0], swarms[1]
+	defer s1.Close()
+	defer s2.Close()
+	s2.SetStreamHandler(func(s network.Stream) {
+		defer s.Close()
+		io.Copy(s, s)
+	})
+	connectSwarms(t, context.Background(), swarms)
+
+	start := time.Now()
+	echo := func(p protocol.ID) network.Stream {
+		str, err := s1.NewStream(context.Background(), s2.LocalPeer())
+		require.NoError(t, err)
+		require.NoError(t, str.SetProtocol(p))
+		_, err = str.Write([]byte("foobar"))
+		require.NoError(t, err)
+		_, err = io.ReadFull(str, make([]byte, 6))
+		require.NoError(t, err)
+		return str
+	}
+	str := echo("/foo")
+	c := str.Conn().(*swarm.Conn)
+	require.NoError(t, str.Close())
+	open := echo("/bar")
+	defer open.Close()
+	echo("/bar").Close()
+	// streams without a protocol are not included
+	untagged, err := s1.NewStream(context.Background(), s2.LocalPeer())
+	require.NoError(t, err)
+	defer untagged.Close()
+
+	activity := c.ProtocolActivity()
+	require.Len(t, activity, 2)
+	foo := activity["/foo"]
+	require.Equal(t, uint64(1), foo.Streams)
+	require.Zero(t, foo.OpenStreams)
+	require.Equal(t, uint64(6), foo.BytesIn)
+	require.Equal(t, uint64(6), foo.BytesOut)
+	require.False(t, foo.LastActive.Before(start))
+	bar := activity["/bar"]
+	require.Equal(t, uint64(2), bar.Streams)
+	require.Equal(t, 1, bar.OpenStreams)
+	require.Equal(t, uint64(12), bar.BytesIn)
+	require.False(t, bar.LastActive.Before(foo.LastActive))
+	require.Equal(t, bar.LastActive, c.LastActive())
+
+	infos := s1.Introspect()
+	require.Len(t, infos, 1)
+	require.Equal(t, activity, infos[0].Protocols)
+}
+
+// relayDialingTransport fails all dials, after dialing the relay through the
+// swarm, like the relay transport does.
+type relayDialingTransport struct {
+	s     *swarm.Swarm
+	relay peer.ID
+
+	mu        sync.Mutex
+	relayErrs []error
+}
+
+var _ transport.Transport = &relayDialingTransport{}
+
+func (t *relayDialingTransport) Dial(ctx context.Context, _ ma.Multiaddr, _ peer.ID) (transport.CapableConn, error) {
+	_, err := t.s.DialPeer(ctx, t.relay)
+	t.mu.Lock()
+	t.relayErrs = append(t.relayErrs, err)
+	t.mu.Unlock()
+	return nil, errors.New("not implemented")
+}
+
+func (t *relayDialingTransport) CanDial(a ma.Multiaddr) bool {
+	_, err := a.ValueForProtocol(ma.P_CIRCUIT)
+	return err == nil
+}
+
+func (t *relayDialingTransport) Listen(ma.Multiaddr) (transport.Listener, error) {
+	return nil, errors.New("not implemented")
+}
+func (t *relayDialingTransport) Protocols() []int { return []int{ma.P_CIRCUIT} }
+func (t *relayDialingTransport) Proxy() bool      { return true }
+
+func TestRelayedDialsShareRelayConn(t *testing.T) {
+	relay := GenSwarm(t, OptDisableQUIC)
+	s := GenSwarm(t, OptDisableQUIC, OptDialOnly)
+	defer relay.Close()
+	defer s.Close()
+
+	tpt := &relayDialingTransport{s: s, relay: relay.LocalPeer()}
+	require.NoError(t, s.AddTransport(tpt))
+	s.Peerstore().AddAddrs(relay.LocalPeer(), relay.ListenAddresses(), peerstore.PermanentAddrTTL)
+
+	relayAddr := ma.StringCast("/p2p/" + relay.LocalPeer().String() + "/p2p-circuit")
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		p := test.RandPeerIDFatal(t)
+		s.Peerstore().AddAddr(p, relayAddr, peerstore.PermanentAddrTTL)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.DialPeer(context.Background(), p)
+			require.Error(t, err)
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, []error{nil, nil, nil}, tpt.relayErrs)
+	require.Len(t, s.ConnsToPeer(relay.LocalPeer()), 1)
+}
+
+func TestStreamUsage(t *testing.T) {
+	uc, err := metrics.NewUsageCounter(nil)
+	require.NoError(t, err)
+	s1 := GenSwarm(t, OptDisableQUIC, WithSwarmOpts(swarm.WithMetrics(uc)))
+	s2 := GenSwarm(t, OptDisableQUIC)
+	defer s1.Close()
+	defer s2.Close()
+	s2.SetStreamHandler(func(s network.Stream) {
+		defer s.Close()
+		io.Copy(io.Discard, s)
+	})
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), s2.ListenAddresses(), peerstore.PermanentAddrTTL)
+
+	for i := 0; i < 2; i++ {
+		str, err := s1.NewStream(context.Background(), s2.LocalPeer())
+		require.NoError(t, err)
+		require.NoError(t, str.SetProtocol("/test"))
+		_, err = str.Write([]byte("foobar"))
+		require.NoError(t, err)
+		str.Close()
+	}
+	require.Equal(t, metrics.Usage{Streams: 2, BytesOut: 12}, uc.GetUsage(s2.LocalPeer(), "/test", time.Minute))
+
+	// the usage is removed when the peer disconnects
+	require.NoError(t, s1.ClosePeer(s2.LocalPeer()))
+	require.Eventually(t, func() bool {
+		return uc.GetUsage(s2.LocalPeer(), "/test", time.Minute) == metrics.Usage{}
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestTransportBandwidth(t *testing.T) {
+	bwc, err := bandwidth.New()
+	require.NoError(t, err)
+	defer bwc.Close()
+	s1 := GenSwarm(t, OptDisableQUIC, WithSwarmOpts(swarm.WithMetrics(bwc)))
+	s2 := GenSwarm(t, OptDisableQUIC)
+	defer s1.Close()
+	defer s2.Close()
+	s2.SetStreamHandler(func(s network.Stream) {
+		defer s.Close()
+		io.Copy(io.Discard, s)
+	})
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), s2.ListenAddresses(), peerstore.PermanentAddrTTL)
+
+	str, err := s1.NewStream(context.Background(), s2.LocalPeer())
+	require.NoError(t, err)
+	_, err = str.Write([]byte("foobar"))
+	require.NoError(t, err)
+	str.Close()
+	require.Equal(t, int64(6), bwc.ForTransport("tcp").TotalOut)
+}
@@ -28,6 +28,11 @@ func (s *Swarm) TransportForDialing(a ma.Multiaddr) transport.Transport {
 		return nil
 	}
 	if isRelayAddr(a) {
+		// Private-to-private WebRTC addresses are relay addresses with
+		// /webrtc appended. The relay is only used for signaling.
+		if t, ok := s.transports.m[ma.P_WEBRTC]; ok && t.CanDial(a) {
+			return t
+		}
 		return s.transports.m[ma.P_CIRCUIT]
 	}
 	for _, t := range s.transports.m {
@@ -69,6 +74,20 @@ func (s *Swarm) TransportForListening(a ma.Multiaddr) transport.Transport {
 	return selected
 }
 
+// TransportCapabilities returns the capabilities of the transport used to
+// dial a, or, if no transport can dial a (e.g. because a certhash is
+// missing), of the transport used to listen on a.
+func (s *Swarm) TransportCapabilities(a ma.Multiaddr) (transport.Capabilities, bool) {
+	t := s.TransportForDialing(a)
+	if t == nil {
+		t = s.TransportForListening(a)
+	}
+	if t == nil {
+		return 0, false
+	}
+	return transport.CapabilitiesOf(t), true
+}
+
 // AddTransport adds a transport to this swarm.
 //
 // Satisfies the Network interface from go-libp2p-transport.
@@ -2,6 +2,7 @@ package testing
 
 import (
 	"crypto/rand"
+	"fmt"
 	"testing"
 	"time"
 
@@ -21,6 +22,7 @@ import (
 	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
 	"github.com/libp2p/go-libp2p/p2p/net/swarm"
 	tptu "github.com/libp2p/go-libp2p/p2p/net/upgrader"
+	"github.com/libp2p/go-libp2p/p2p/p2ptest"
 	libp2pquic "github.com/libp2p/go-libp2p/p2p/transport/quic"
 	"github.com/libp2p/go-libp2p/p2p/transport/quicreuse"
 	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
@@ -36,9 +38,11 @@ type config struct {
 	disableTCP       bool
 	disableQUIC      bool
 	connectionGater  connmgr.ConnectionGater
+	rcmgr            network.ResourceManager
 	sk               crypto.PrivKey
 	swarmOpts        []swarm.Option
 	eventBus         event.Bus
+	rand             *p2ptest.Rand
 	clock
 }
 
@@ -95,6 +99,14 @@ func OptConnGater(cg connmgr.ConnectionGater) Option {
 	}
 }
 
+// OptResourceManager configures the resource manager used by the swarm, the
+// upgrader and the transports.
+func OptResourceManager(rcmgr network.ResourceManager) Option {
+	return func(_ *testing.T, c *config) {
+		c.rcmgr = rcmgr
+	}
+}
+
 // OptPeerPrivateKey configures the peer private key which is then used to derive the public key and peer ID.
 func OptPeerPrivateKey(sk crypto.PrivKey) Option {
 	return func(_ *testing.T, c *config) {
@@ -108,17 +120,42 @@ func EventBus(b event.Bus) Option {
 	}
 }
 
+// WithRand makes the test swarm generate its key pair (an Ed25519 key) and
+// pick its listen ports using r, so that they are reproducible.
+func WithRand(r *p2ptest.Rand) Option {
+	return func(_ *testing.T, c *config) {
+		c.rand = r
+	}
+}
+
 // GenUpgrader creates a new connection upgrader for use with this swarm.
 func GenUpgrader(t *testing.T, n *swarm.Swarm, connGater connmgr.ConnectionGater, opts ...tptu.Option) transport.Upgrader {
+	return genUpgrader(t, n, connGater, nil, opts...)
+}
+
+func genUpgrader(t *testing.T, n *swarm.Swarm, connGater connmgr.ConnectionGater, rcmgr network.ResourceManager, opts ...tptu.Option) transport.Upgrader {
 	id := n.LocalPeer()
 	pk := n.Peerstore().PrivKey(id)
 	st := insecure.NewWithIdentity(insecure.ID, id, pk)
 
-	u, err := tptu.New([]sec.SecureTransport{st}, []tptu.StreamMuxer{{ID: yamux.ID, Muxer: yamux.DefaultTransport}}, nil, nil, connGater, opts...)
+	u, err := tptu.New([]sec.SecureTransport{st}, []tptu.StreamMuxer{{ID: yamux.ID, Muxer: yamux.DefaultTransport}}, nil, rcmgr, connGater, opts...)
 	require.NoError(t, err)
 	return u
 }
 
+// listen makes s listen on the address format, with the port formatted as %d:
+// any port, unless a Rand is set, in which case the port is picked using it.
+func (c *config) listen(t *testing.T, s *swarm.Swarm, format string) {
+	if c.rand == nil {
+		require.NoError(t, s.Listen(ma.StringCast(fmt.Sprintf(format, 0))))
+		return
+	}
+	_, err := c.rand.ListenPort(func(port int) error {
+		return s.Listen(ma.StringCast(fmt.Sprintf(format, port)))
+	})
+	require.NoError(t, err)
+}
+
 // GenSwarm generates a new test swarm.
 func GenSwarm(t *testing.T, opts ...Option) *swarm.Swarm {
 	var cfg config
@@ -130,7 +167,11 @@ func GenSwarm(t *testing.T, opts ...Option) *swarm.Swarm {
 	var priv crypto.PrivKey
 	if cfg.sk == nil {
 		var err error
-		priv, _, err = crypto.GenerateEd25519Key(rand.Reader)
+		if cfg.rand != nil {
+			priv, _, err = cfg.rand.KeyPair(crypto.Ed25519, -1)
+		} else {
+			priv, _, err = crypto.GenerateEd25519Key(rand.Reader)
+		}
 		require.NoError(t, err)
 	} else {
 		priv = cfg.sk
@@ -144,11 +185,14 @@ func GenSwarm(t *testing.T, opts ...Option) *swarm.Swarm {
 	ps.AddPrivKey(id, priv)
 	t.Cleanup(func() { ps.Close() })
 
-	swarmOpts := cfg.swarmOpts
-	swarmOpts = append(swarmOpts, swarm.WithMetrics(metrics.NewBandwidthCounter()))
+	// the metrics reporter can be replaced using WithSwarmOpts
+	swarmOpts := append([]swarm.Option{swarm.WithMetrics(metrics.NewBandwidthCounter())}, cfg.swarmOpts...)
 	if cfg.connectionGater != nil {
 		swarmOpts = append(swarmOpts, swarm.WithConnectionGater(cfg.connectionGater))
 	}
+	if cfg.rcmgr != nil {
+		swarmOpts = append(swarmOpts, swarm.WithResourceManager(cfg.rcmgr))
+	}
 
 	eventBus := cfg.eventBus
 	if eventBus == nil {
@@ -157,22 +201,20 @@ func GenSwarm(t *testing.T, opts ...Option) *swarm.Swarm {
 	s, err := swarm.NewSwarm(id, ps, eventBus, swarmOpts...)
 	require.NoError(t, err)
 
-	upgrader := GenUpgrader(t, s, cfg.connectionGater)
+	upgrader := genUpgrader(t, s, cfg.connectionGater, cfg.rcmgr)
 
 	if !cfg.disableTCP {
 		var tcpOpts []tcp.Option
 		if cfg.disableReuseport {
 			tcpOpts = append(tcpOpts, tcp.DisableReuseport())
 		}
-		tcpTransport, err := tcp.NewTCPTransport(upgrader, nil, tcpOpts...)
+		tcpTransport, err := tcp.NewTCPTransport(upgrader, cfg.rcmgr, tcpOpts...)
 		require.NoError(t, err)
 		if err := s.AddTransport(tcpTransport); err != nil {
 			t.Fatal(err)
 		}
 		if !cfg.dialOnly {
-			if err := s.Listen(ma.StringCast("/ip4/127.0.0.1/tcp/0")); err != nil {
-				t.Fatal(err)
-			}
+			cfg.listen(t, s, "/ip4/127.0.0.1/tcp/%d")
 		}
 	}
 	if !cfg.disableQUIC {
@@ -180,7 +222,7 @@ func GenSwarm(t *testing.T, opts ...Option) *swarm.Swarm {
 		if err != nil {
 			t.Fatal(err)
 		}
-		quicTransport, err := libp2pquic.NewTransport(priv, reuse, nil, cfg.connectionGater, nil)
+		quicTransport, err := libp2pquic.NewTransport(priv, reuse, nil, cfg.connectionGater, cfg.rcmgr)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -188,9 +230,7 @@ func GenSwarm(t *testing.T, opts ...Option) *swarm.Swarm {
 			t.Fatal(err)
 		}
 		if !cfg.dialOnly {
-			if err := s.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1")); err != nil {
-				t.Fatal(err)
-			}
+			cfg.listen(t, s, "/ip4/127.0.0.1/udp/%d/quic-v1")
 		}
 	}
 	if !cfg.dialOnly {
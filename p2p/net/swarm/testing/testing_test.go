@@ -1,7 +1,13 @@
 package testing
 
 import (
+	"context"
 	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"github.com/libp2p/go-libp2p/p2p/p2ptest"
 
 	"github.com/stretchr/testify/require"
 )
@@ -11,3 +17,34 @@ func TestGenSwarm(t *testing.T) {
 	require.NoError(t, swarm.Close())
 	GenUpgrader(t, swarm, nil)
 }
+
+func TestGenSwarmWithRand(t *testing.T) {
+	s1 := GenSwarm(t, WithRand(p2ptest.New(t, p2ptest.WithSeed(42))))
+	defer s1.Close()
+	s2 := GenSwarm(t, WithRand(p2ptest.New(t, p2ptest.WithSeed(42))), OptDialOnly)
+	defer s2.Close()
+	require.Equal(t, s1.LocalPeer(), s2.LocalPeer())
+}
+
+func TestGenSwarmWithResourceManager(t *testing.T) {
+	rm, err := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(rcmgr.DefaultLimits.AutoScale()))
+	require.NoError(t, err)
+	s1 := GenSwarm(t, OptResourceManager(rm))
+	defer s1.Close()
+	require.Equal(t, rm, s1.ResourceManager())
+
+	s2 := GenSwarm(t, OptDialOnly)
+	defer s2.Close()
+	s2.Peerstore().AddAddrs(s1.LocalPeer(), s1.ListenAddresses(), time.Hour)
+	_, err = s2.DialPeer(context.Background(), s1.LocalPeer())
+	require.NoError(t, err)
+	// the inbound connection is accounted for by the resource manager
+	require.Eventually(t, func() bool {
+		var stat network.ScopeStat
+		rm.ViewSystem(func(s network.ResourceScope) error {
+			stat = s.Stat()
+			return nil
+		})
+		return stat.NumConnsInbound == 1
+	}, time.Second, 10*time.Millisecond)
+}
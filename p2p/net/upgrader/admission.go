@@ -0,0 +1,287 @@
+package upgrader
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"net"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/sec"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-varint"
+)
+
+// ErrAdmissionDenied is returned when the server rejects the response to its
+// admission challenge.
+var ErrAdmissionDenied = errors.New("admission denied")
+
+// ChallengeType is the type of an admission challenge.
+type ChallengeType uint8
+
+const (
+	// ChallengeNone admits the connection without a challenge.
+	ChallengeNone ChallengeType = iota
+	// ChallengeProofOfWork requires the client to solve a hash puzzle.
+	ChallengeProofOfWork
+	// ChallengeToken requires the client to present an admission token, e.g.
+	// one signed by the operator of the network.
+	ChallengeToken
+)
+
+// Challenge is the challenge an inbound connection has to solve before it is
+// admitted.
+type Challenge struct {
+	Type ChallengeType
+	// Difficulty is the number of leading zero bits of the proof of work, see
+	// ChallengeProofOfWork. Each additional bit doubles the expected work.
+	Difficulty uint8
+}
+
+// AdmissionPolicy returns the challenge the inbound connection from p at addr
+// has to solve. It's called for every inbound connection once the security
+// handshake completed. Typically, it only returns a challenge while the node
+// is under load.
+type AdmissionPolicy func(p peer.ID, addr ma.Multiaddr) Challenge
+
+// Admission configures the admission step, see WithAdmission.
+type Admission struct {
+	// Policy selects the challenge for inbound connections. If nil, inbound
+	// connections are admitted without a challenge.
+	Policy AdmissionPolicy
+	// VerifyToken verifies the token presented by p in response to a
+	// ChallengeToken. Tokens are rejected if nil.
+	VerifyToken func(p peer.ID, token []byte) bool
+	// Token returns the token presented to server in response to a
+	// ChallengeToken. Outbound connections challenged for a token fail if nil.
+	Token func(server peer.ID) ([]byte, error)
+	// MaxDifficulty is the highest proof of work difficulty that outbound
+	// connections solve. It defaults to 24.
+	MaxDifficulty uint8
+}
+
+// The admission protocol runs on the secured connection, right after the
+// security handshake, and before any data of the stream multiplexer:
+//
+//  1. The server sends a challenge: a type byte, followed by the difficulty
+//     (1 byte) and a random nonce (32 bytes) for ChallengeProofOfWork. For
+//     ChallengeNone, the connection is admitted, and the protocol ends here.
+//  2. The client responds: for ChallengeProofOfWork with an 8 byte solution,
+//     such that the SHA-256 hash of powDomain, the nonce, the client's peer ID
+//     and the solution starts with difficulty zero bits; for ChallengeToken
+//     with the uvarint length prefixed token.
+//  3. The server sends its verdict: 0 if the connection is admitted, 1 if it
+//     is rejected.
+const (
+	powDomain    = "libp2p-admission-pow:"
+	nonceSize    = 32
+	maxTokenSize = 4096
+
+	verdictAdmitted byte = 0
+	verdictRejected byte = 1
+
+	defaultMaxDifficulty = 24
+)
+
+// runAdmission runs the admission protocol on sconn, obeying the context and
+// the negotiate timeout.
+func (u *upgrader) runAdmission(ctx context.Context, sconn sec.SecureConn, addr ma.Multiaddr, isServer bool) error {
+	deadline := time.Now().Add(u.negotiateTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := sconn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { sconn.SetDeadline(time.Now()) })
+	var err error
+	if isServer {
+		err = u.admitInbound(sconn, addr)
+	} else {
+		err = u.admitOutbound(ctx, sconn)
+	}
+	if !stop() && err != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+	return sconn.SetDeadline(time.Time{})
+}
+
+func (u *upgrader) admitInbound(sconn sec.SecureConn, addr ma.Multiaddr) error {
+	ch := Challenge{Type: ChallengeNone}
+	if u.admission.Policy != nil {
+		ch = u.admission.Policy(sconn.RemotePeer(), addr)
+	}
+
+	switch ch.Type {
+	case ChallengeNone:
+		_, err := sconn.Write([]byte{byte(ChallengeNone)})
+		return err
+	case ChallengeProofOfWork:
+		msg := make([]byte, 2+nonceSize)
+		msg[0] = byte(ChallengeProofOfWork)
+		msg[1] = ch.Difficulty
+		nonce := msg[2:]
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		if _, err := sconn.Write(msg); err != nil {
+			return err
+		}
+		var solution [8]byte
+		if _, err := io.ReadFull(sconn, solution[:]); err != nil {
+			return err
+		}
+		return writeVerdict(sconn, checkProofOfWork(nonce, sconn.RemotePeer(), solution[:], ch.Difficulty))
+	case ChallengeToken:
+		if _, err := sconn.Write([]byte{byte(ChallengeToken)}); err != nil {
+			return err
+		}
+		token, err := readToken(sconn)
+		if err != nil {
+			return err
+		}
+		return writeVerdict(sconn, u.admission.VerifyToken != nil && u.admission.VerifyToken(sconn.RemotePeer(), token))
+	default:
+		return fmt.Errorf("unknown challenge type: %d", ch.Type)
+	}
+}
+
+func writeVerdict(conn net.Conn, admitted bool) error {
+	verdict := verdictAdmitted
+	if !admitted {
+		verdict = verdictRejected
+	}
+	if _, err := conn.Write([]byte{verdict}); err != nil {
+		return err
+	}
+	if !admitted {
+		return ErrAdmissionDenied
+	}
+	return nil
+}
+
+func readToken(r io.Reader) ([]byte, error) {
+	l, err := varint.ReadUvarint(byteReader{r})
+	if err != nil {
+		return nil, err
+	}
+	if l > maxTokenSize {
+		return nil, fmt.Errorf("admission token too large: %d bytes", l)
+	}
+	token := make([]byte, l)
+	_, err = io.ReadFull(r, token)
+	return token, err
+}
+
+func (u *upgrader) admitOutbound(ctx context.Context, sconn sec.SecureConn) error {
+	var typ [1]byte
+	if _, err := io.ReadFull(sconn, typ[:]); err != nil {
+		return err
+	}
+
+	switch ChallengeType(typ[0]) {
+	case ChallengeNone:
+		return nil
+	case ChallengeProofOfWork:
+		msg := make([]byte, 1+nonceSize)
+		if _, err := io.ReadFull(sconn, msg); err != nil {
+			return err
+		}
+		difficulty, nonce := msg[0], msg[1:]
+		maxDifficulty := u.admission.MaxDifficulty
+		if maxDifficulty == 0 {
+			maxDifficulty = defaultMaxDifficulty
+		}
+		if difficulty > maxDifficulty {
+			return fmt.Errorf("proof of work difficulty too high: %d > %d", difficulty, maxDifficulty)
+		}
+		solution, err := solveProofOfWork(ctx, nonce, sconn.LocalPeer(), difficulty)
+		if err != nil {
+			return err
+		}
+		if _, err := sconn.Write(solution); err != nil {
+			return err
+		}
+	case ChallengeToken:
+		if u.admission.Token == nil {
+			return errors.New("challenged for an admission token, but none is configured")
+		}
+		token, err := u.admission.Token(sconn.RemotePeer())
+		if err != nil {
+			return fmt.Errorf("failed to get admission token: %w", err)
+		}
+		msg := append(varint.ToUvarint(uint64(len(token))), token...)
+		if _, err := sconn.Write(msg); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown challenge type: %d", typ[0])
+	}
+
+	var verdict [1]byte
+	if _, err := io.ReadFull(sconn, verdict[:]); err != nil {
+		return err
+	}
+	if verdict[0] != verdictAdmitted {
+		return ErrAdmissionDenied
+	}
+	return nil
+}
+
+func powHash(nonce []byte, p peer.ID, solution []byte) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write([]byte(powDomain))
+	h.Write(nonce)
+	h.Write([]byte(p))
+	h.Write(solution)
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+func leadingZeroBits(b []byte) int {
+	var n int
+	for _, x := range b {
+		if x != 0 {
+			return n + bits.LeadingZeros8(x)
+		}
+		n += 8
+	}
+	return n
+}
+
+func checkProofOfWork(nonce []byte, p peer.ID, solution []byte, difficulty uint8) bool {
+	sum := powHash(nonce, p, solution)
+	return leadingZeroBits(sum[:]) >= int(difficulty)
+}
+
+func solveProofOfWork(ctx context.Context, nonce []byte, p peer.ID, difficulty uint8) ([]byte, error) {
+	solution := make([]byte, 8)
+	for i := uint64(0); ; i++ {
+		if i%4096 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		binary.BigEndian.PutUint64(solution, i)
+		if checkProofOfWork(nonce, p, solution, difficulty) {
+			return solution, nil
+		}
+	}
+}
+
+type byteReader struct{ io.Reader }
+
+func (r byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(r.Reader, b[:])
+	return b[0], err
+}
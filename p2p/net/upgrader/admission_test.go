@@ -0,0 +1,68 @@
+package upgrader_test
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/net/upgrader"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmission(t *testing.T) {
+	var challenge atomic.Value
+	challenge.Store(upgrader.Challenge{Type: upgrader.ChallengeNone})
+	serverID, server := createUpgraderWithOpts(t, upgrader.WithAdmission(upgrader.Admission{
+		Policy: func(peer.ID, ma.Multiaddr) upgrader.Challenge { return challenge.Load().(upgrader.Challenge) },
+		VerifyToken: func(_ peer.ID, token []byte) bool {
+			return bytes.Equal(token, []byte("valid"))
+		},
+	}))
+	ln := createListener(t, server)
+	defer ln.Close()
+
+	newClient := func(t *testing.T, token string) transport.Upgrader {
+		_, u := createUpgraderWithOpts(t, upgrader.WithAdmission(upgrader.Admission{
+			Token:         func(peer.ID) ([]byte, error) { return []byte(token), nil },
+			MaxDifficulty: 12,
+		}))
+		return u
+	}
+	admitted := func(t *testing.T, client transport.Upgrader) {
+		t.Helper()
+		cconn, err := dial(t, client, ln.Multiaddr(), serverID, &network.NullScope{})
+		require.NoError(t, err)
+		defer cconn.Close()
+		sconn, err := ln.Accept()
+		require.NoError(t, err)
+		defer sconn.Close()
+		testConn(t, cconn, sconn)
+	}
+	rejected := func(t *testing.T, client transport.Upgrader, msg string) {
+		t.Helper()
+		_, err := dial(t, client, ln.Multiaddr(), serverID, &network.NullScope{})
+		require.ErrorContains(t, err, msg)
+	}
+
+	t.Run("no challenge", func(t *testing.T) {
+		admitted(t, newClient(t, ""))
+	})
+
+	t.Run("proof of work", func(t *testing.T) {
+		challenge.Store(upgrader.Challenge{Type: upgrader.ChallengeProofOfWork, Difficulty: 8})
+		admitted(t, newClient(t, ""))
+		challenge.Store(upgrader.Challenge{Type: upgrader.ChallengeProofOfWork, Difficulty: 20})
+		rejected(t, newClient(t, ""), "difficulty too high")
+	})
+
+	t.Run("token", func(t *testing.T) {
+		challenge.Store(upgrader.Challenge{Type: upgrader.ChallengeToken})
+		admitted(t, newClient(t, "valid"))
+		rejected(t, newClient(t, "invalid"), upgrader.ErrAdmissionDenied.Error())
+	})
+}
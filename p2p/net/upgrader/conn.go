@@ -2,6 +2,7 @@ package upgrader
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/protocol"
@@ -19,9 +20,14 @@ type transportConn struct {
 	muxer                     protocol.ID
 	security                  protocol.ID
 	usedEarlyMuxerNegotiation bool
+	tls                       *network.TLSConnectionState
+	identifySnapshot          []byte
 }
 
-var _ transport.CapableConn = &transportConn{}
+var (
+	_ transport.CapableConn = &transportConn{}
+	_ network.KeepAliveConn = &transportConn{}
+)
 
 func (t *transportConn) Transport() transport.Transport {
 	return t.transport
@@ -61,5 +67,17 @@ func (t *transportConn) ConnState() network.ConnectionState {
 		Security:                  t.security,
 		Transport:                 "tcp",
 		UsedEarlyMuxerNegotiation: t.usedEarlyMuxerNegotiation,
+		TLS:                       t.tls,
+		IdentifySnapshot:          t.identifySnapshot,
+	}
+}
+
+// SetKeepAliveInterval changes the keep-alive interval of the muxer, if it
+// sends keep-alives.
+func (t *transportConn) SetKeepAliveInterval(d time.Duration) error {
+	kc, ok := t.MuxedConn.(network.KeepAliveConn)
+	if !ok {
+		return network.ErrKeepAliveNotSupported
 	}
+	return kc.SetKeepAliveInterval(d)
 }
@@ -0,0 +1,117 @@
+package upgrader
+
+import (
+	"context"
+	"sync"
+)
+
+// handshakeLimiter bounds the number of inbound connections that are upgraded
+// concurrently. Up to workers upgrades run at the same time, and up to
+// queueLen more wait for a worker. Connections arriving while the queue is full
+// are shed: they are closed before any handshake work is done.
+type handshakeLimiter struct {
+	workers  chan struct{}
+	queueLen int
+	tracer   MetricsTracer
+
+	mu      sync.Mutex
+	pending int // number of admitted upgrades, running or queued
+}
+
+func newHandshakeLimiter(workers, queueLen int, tracer MetricsTracer) *handshakeLimiter {
+	return &handshakeLimiter{
+		workers:  make(chan struct{}, workers),
+		queueLen: queueLen,
+		tracer:   tracer,
+	}
+}
+
+// admit reserves a place for an upgrade, either with a worker or in the queue.
+// It returns false if the queue is full. If it returns true, the caller must
+// call acquire.
+func (l *handshakeLimiter) admit() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.pending >= cap(l.workers)+l.queueLen {
+		if l.tracer != nil {
+			l.tracer.HandshakeShed()
+		}
+		return false
+	}
+	l.pending++
+	return true
+}
+
+// acquire waits for a worker. If it returns nil, the caller must call release
+// once the upgrade is done. If the context is canceled first, the place
+// reserved by admit is freed.
+func (l *handshakeLimiter) acquire(ctx context.Context) error {
+	select {
+	case l.workers <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if l.tracer != nil {
+		l.tracer.HandshakeQueued()
+		defer l.tracer.HandshakeDequeued()
+	}
+	select {
+	case l.workers <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		l.cancel()
+		return ctx.Err()
+	}
+}
+
+// release frees the worker acquired by acquire.
+func (l *handshakeLimiter) release() {
+	<-l.workers
+	l.cancel()
+}
+
+// cancel frees the place reserved by admit.
+func (l *handshakeLimiter) cancel() {
+	l.mu.Lock()
+	l.pending--
+	l.mu.Unlock()
+}
+
+// handshakeLimiters applies multiple limiters, e.g. the listener's and the
+// upgrader's, in order. Since workers are acquired one limiter after the
+// other, an upgrade waits in at most one queue at a time.
+type handshakeLimiters []*handshakeLimiter
+
+func (ls handshakeLimiters) admit() bool {
+	for i, l := range ls {
+		if !l.admit() {
+			ls[:i].cancel()
+			return false
+		}
+	}
+	return true
+}
+
+func (ls handshakeLimiters) acquire(ctx context.Context) error {
+	for i, l := range ls {
+		if err := l.acquire(ctx); err != nil {
+			ls[:i].release()
+			ls[i+1:].cancel()
+			return err
+		}
+	}
+	return nil
+}
+
+func (ls handshakeLimiters) release() {
+	for _, l := range ls {
+		l.release()
+	}
+}
+
+func (ls handshakeLimiters) cancel() {
+	for _, l := range ls {
+		l.cancel()
+	}
+}
@@ -28,6 +28,9 @@ type listener struct {
 
 	// Used for backpressure
 	threshold *threshold
+	// limiters bound the number of concurrent upgrades of this listener, and
+	// of all listeners of the upgrader
+	limiters handshakeLimiters
 
 	// Canceling this context isn't sufficient to tear down the listener.
 	// Call close.
@@ -57,6 +60,10 @@ func (l *listener) Close() error {
 //  2. It stops accepting new connections once AcceptQueueLength connections have
 //     been fully negotiated but not accepted. This gives us a basic backpressure
 //     mechanism while still allowing us to negotiate connections in parallel.
+//  3. If handshake limits are configured (for the upgrader, or per listener), it
+//     closes new connections right away while a handshake queue is full, and
+//     runs at most the configured number of upgrades at the same time. The
+//     accept loop never waits for a handshake worker.
 func (l *listener) handleIncoming() {
 	var wg sync.WaitGroup
 	defer func() {
@@ -94,9 +101,16 @@ func (l *listener) handleIncoming() {
 			continue
 		}
 
+		if !l.limiters.admit() {
+			log.Debugw("too many pending handshakes, shedding incoming connection", "remote", maconn.RemoteMultiaddr())
+			maconn.Close()
+			continue
+		}
+
 		connScope, err := l.rcmgr.OpenConnection(network.DirInbound, true, maconn.RemoteMultiaddr())
 		if err != nil {
 			log.Debugw("resource manager blocked accept of new connection", "error", err)
+			l.limiters.cancel()
 			if err := maconn.Close(); err != nil {
 				log.Warnf("failed to incoming connection rejected by resource manager: %s", err)
 			}
@@ -119,7 +133,14 @@ func (l *listener) handleIncoming() {
 			ctx, cancel := context.WithTimeout(l.ctx, l.upgrader.acceptTimeout)
 			defer cancel()
 
+			if err := l.limiters.acquire(ctx); err != nil {
+				log.Debugw("timed out waiting for a handshake worker", "remote", maconn.RemoteMultiaddr())
+				maconn.Close()
+				connScope.Done()
+				return
+			}
 			conn, err := l.upgrader.Upgrade(ctx, l.transport, maconn, network.DirInbound, "", connScope)
+			l.limiters.release()
 			if err != nil {
 				// Don't bother bubbling this up. We just failed
 				// to completely negotiate the connection.
@@ -127,6 +148,9 @@ func (l *listener) handleIncoming() {
 					err,
 					maconn.LocalMultiaddr(),
 					maconn.RemoteMultiaddr())
+				for _, o := range l.upgrader.handshakeObservers {
+					o.InboundHandshakeFailed(maconn.RemoteMultiaddr(), err)
+				}
 				connScope.Done()
 				return
 			}
@@ -289,6 +289,99 @@ func TestAcceptQueueBacklogged(t *testing.T) {
 	require.Eventually(func() bool { return int(counter.Load()) == upgrader.AcceptQueueLength+1 }, 2*time.Second, 50*time.Millisecond)
 }
 
+type handshakeTracer struct {
+	shed   atomic.Int32
+	queued atomic.Int32
+}
+
+func (t *handshakeTracer) HandshakeShed()     { t.shed.Add(1) }
+func (t *handshakeTracer) HandshakeQueued()   { t.queued.Add(1) }
+func (t *handshakeTracer) HandshakeDequeued() { t.queued.Add(-1) }
+
+func TestHandshakeLimits(t *testing.T) {
+	require := require.New(t)
+
+	tracer := &handshakeTracer{}
+	id, u := createUpgraderWithOpts(t, upgrader.WithHandshakeLimits(1, 1), upgrader.WithMetricsTracer(tracer))
+	ln := createListener(t, u)
+	defer ln.Close()
+
+	// These connections never start the handshake. The first one occupies the
+	// worker, the second one waits in the queue.
+	stalled := make([]manet.Conn, 2)
+	for i := range stalled {
+		c, err := manet.Dial(ln.Multiaddr())
+		require.NoError(err)
+		defer c.Close()
+		stalled[i] = c
+	}
+	require.Eventually(func() bool { return tracer.queued.Load() == 1 }, time.Second, 10*time.Millisecond)
+
+	// The queue is full, so the next connection is closed right away.
+	shed, err := manet.Dial(ln.Multiaddr())
+	require.NoError(err)
+	defer shed.Close()
+	shed.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = shed.Read(make([]byte, 1))
+	require.ErrorIs(err, io.EOF)
+	require.Equal(int32(1), tracer.shed.Load())
+
+	// Once the stalled connections are gone, handshakes proceed.
+	for _, c := range stalled {
+		c.Close()
+	}
+	require.Eventually(func() bool { return tracer.queued.Load() == 0 }, time.Second, 10*time.Millisecond)
+	cconn, err := dial(t, u, ln.Multiaddr(), id, &network.NullScope{})
+	require.NoError(err)
+	defer cconn.Close()
+	sconn, err := ln.Accept()
+	require.NoError(err)
+	defer sconn.Close()
+}
+
+func TestListenerUpgradeLimits(t *testing.T) {
+	require := require.New(t)
+
+	tracer := &handshakeTracer{}
+	id, u := createUpgraderWithOpts(t, upgrader.WithListenerUpgradeLimits(1, 0), upgrader.WithMetricsTracer(tracer))
+	ln1 := createListener(t, u)
+	defer ln1.Close()
+	ln2 := createListener(t, u)
+	defer ln2.Close()
+
+	// occupy the only worker of the first listener
+	stalled, err := manet.Dial(ln1.Multiaddr())
+	require.NoError(err)
+	defer stalled.Close()
+
+	// the first listener sheds new connections
+	shed, err := manet.Dial(ln1.Multiaddr())
+	require.NoError(err)
+	defer shed.Close()
+	shed.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = shed.Read(make([]byte, 1))
+	require.ErrorIs(err, io.EOF)
+	require.Equal(int32(1), tracer.shed.Load())
+
+	// the second listener isn't affected
+	cconn, err := dial(t, u, ln2.Multiaddr(), id, &network.NullScope{})
+	require.NoError(err)
+	defer cconn.Close()
+	sconn, err := ln2.Accept()
+	require.NoError(err)
+	defer sconn.Close()
+}
+
+func TestHandshakeLimitsOptions(t *testing.T) {
+	id, priv := newPeer(t)
+	_, err := upgrader.New([]sec.SecureTransport{insecure.NewWithIdentity(insecure.ID, id, priv)}, nil, nil, nil, nil, upgrader.WithHandshakeLimits(0, 1))
+	require.Error(t, err)
+	_, err = upgrader.New([]sec.SecureTransport{insecure.NewWithIdentity(insecure.ID, id, priv)}, nil, nil, nil, nil, upgrader.WithHandshakeLimits(1, -1))
+	require.Error(t, err)
+	_, err = upgrader.New([]sec.SecureTransport{insecure.NewWithIdentity(insecure.ID, id, priv)}, nil, nil, nil, nil, upgrader.WithListenerUpgradeLimits(0, 1))
+	require.Error(t, err)
+}
+
 func TestListenerConnectionGater(t *testing.T) {
 	require := require.New(t)
 
@@ -0,0 +1,81 @@
+package upgrader
+
+import (
+	"github.com/libp2p/go-libp2p/p2p/metricshelper"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricNamespace = "libp2p_upgrader"
+
+var (
+	handshakesShed = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "handshakes_shed_total",
+			Help:      "Inbound connections closed before the handshake because the handshake queue was full",
+		},
+	)
+	handshakeQueueLength = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Name:      "handshake_queue_length",
+			Help:      "Inbound handshakes waiting for a worker",
+		},
+	)
+	collectors = []prometheus.Collector{
+		handshakesShed,
+		handshakeQueueLength,
+	}
+)
+
+// MetricsTracer tracks the admission of inbound handshakes, see
+// WithHandshakeLimits.
+type MetricsTracer interface {
+	// HandshakeShed is called when an inbound connection is closed because
+	// the handshake queue is full.
+	HandshakeShed()
+	// HandshakeQueued is called when an inbound handshake starts waiting for
+	// a worker, HandshakeDequeued when it stops waiting.
+	HandshakeQueued()
+	HandshakeDequeued()
+}
+
+type metricsTracer struct{}
+
+var _ MetricsTracer = &metricsTracer{}
+
+type metricsTracerSetting struct {
+	reg prometheus.Registerer
+}
+
+type MetricsTracerOption func(*metricsTracerSetting)
+
+func WithRegisterer(reg prometheus.Registerer) MetricsTracerOption {
+	return func(s *metricsTracerSetting) {
+		if reg != nil {
+			s.reg = reg
+		}
+	}
+}
+
+func NewMetricsTracer(opts ...MetricsTracerOption) MetricsTracer {
+	setting := &metricsTracerSetting{reg: prometheus.DefaultRegisterer}
+	for _, opt := range opts {
+		opt(setting)
+	}
+	metricshelper.RegisterCollectors(setting.reg, collectors...)
+	return &metricsTracer{}
+}
+
+func (m *metricsTracer) HandshakeShed() {
+	handshakesShed.Inc()
+}
+
+func (m *metricsTracer) HandshakeQueued() {
+	handshakeQueueLength.Inc()
+}
+
+func (m *metricsTracer) HandshakeDequeued() {
+	handshakeQueueLength.Dec()
+}
@@ -1,3 +1,7 @@
+// Package upgrader upgrades raw network connections to libp2p connections, by
+// negotiating a security protocol and a stream multiplexer. Transports that
+// don't provide encryption and multiplexing themselves, like TCP and
+// WebSocket, use it to create their connections. See NewUpgrader.
 package upgrader
 
 import (
@@ -8,14 +12,18 @@ import (
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/network"
+	neterrors "github.com/libp2p/go-libp2p/core/network/errors"
 	"github.com/libp2p/go-libp2p/core/peer"
 	ipnet "github.com/libp2p/go-libp2p/core/pnet"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/core/sec"
+	"github.com/libp2p/go-libp2p/core/sec/insecure"
 	"github.com/libp2p/go-libp2p/core/transport"
 	"github.com/libp2p/go-libp2p/p2p/net/pnet"
 
+	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr/net"
 	mss "github.com/multiformats/go-multistream"
 )
@@ -32,8 +40,55 @@ const (
 	defaultNegotiateTimeout = 60 * time.Second
 )
 
+// Option is an option for the upgrader.
 type Option func(*upgrader) error
 
+// WithSecurity sets the security transports, in order of preference.
+func WithSecurity(security ...sec.SecureTransport) Option {
+	return func(u *upgrader) error {
+		u.security = security
+		return nil
+	}
+}
+
+// WithMuxers sets the stream multiplexers, in order of preference.
+func WithMuxers(muxers ...StreamMuxer) Option {
+	return func(u *upgrader) error {
+		u.muxers = muxers
+		return nil
+	}
+}
+
+// WithPSK sets the pre-shared key of the private network. Connections are
+// protected with the key before the security protocol is negotiated.
+func WithPSK(psk ipnet.PSK) Option {
+	return func(u *upgrader) error {
+		u.psk = psk
+		return nil
+	}
+}
+
+// WithResourceManager sets the resource manager the peer scope of the
+// connection is set on, and that the stream multiplexers account to.
+func WithResourceManager(rcmgr network.ResourceManager) Option {
+	return func(u *upgrader) error {
+		u.rcmgr = rcmgr
+		return nil
+	}
+}
+
+// WithConnectionGater sets the connection gater that is asked to accept
+// the connection once the remote peer is authenticated.
+func WithConnectionGater(gater connmgr.ConnectionGater) Option {
+	return func(u *upgrader) error {
+		u.connGater = gater
+		return nil
+	}
+}
+
+// WithAcceptTimeout sets the maximum duration an Accept is allowed to take,
+// including the negotiation of the security protocol, the handshake and the
+// negotiation of the stream multiplexer. It defaults to 15s.
 func WithAcceptTimeout(t time.Duration) Option {
 	return func(u *upgrader) error {
 		u.acceptTimeout = t
@@ -41,6 +96,116 @@ func WithAcceptTimeout(t time.Duration) Option {
 	}
 }
 
+// WithNegotiateTimeout sets the maximum duration of the negotiation of the
+// stream multiplexer. It defaults to 60s.
+func WithNegotiateTimeout(t time.Duration) Option {
+	return func(u *upgrader) error {
+		if t <= 0 {
+			return errors.New("negotiate timeout must be positive")
+		}
+		u.negotiateTimeout = t
+		return nil
+	}
+}
+
+// DisallowInsecure makes New fail if one of the security transports is the
+// insecure (plaintext) transport, so that connections can never fall back to
+// plaintext.
+func DisallowInsecure() Option {
+	return func(u *upgrader) error {
+		u.disallowInsecure = true
+		return nil
+	}
+}
+
+// WithEventBus sets the event bus used to emit an event.EvtSecurityDowngrade
+// when the negotiation of the security protocol looks like a downgrade.
+func WithEventBus(bus event.Bus) Option {
+	return func(u *upgrader) error {
+		u.eventBus = bus
+		return nil
+	}
+}
+
+// WithHandshakeLimits limits the number of inbound connections that are
+// upgraded concurrently to workers, so that a flood of handshakes can't
+// saturate all cores and starve established connections. Up to queueLen more
+// connections wait for a worker, for at most the accept timeout. Connections
+// arriving while the queue is full are closed right after they are accepted.
+// By default, the number of concurrent upgrades is only limited by the
+// resource manager.
+func WithHandshakeLimits(workers, queueLen int) Option {
+	return func(u *upgrader) error {
+		if workers <= 0 {
+			return errors.New("need at least one handshake worker")
+		}
+		if queueLen < 0 {
+			return errors.New("handshake queue length must not be negative")
+		}
+		u.handshakeWorkers = workers
+		u.handshakeQueueLen = queueLen
+		return nil
+	}
+}
+
+// WithListenerUpgradeLimits limits the number of inbound connections of each
+// listener that are upgraded concurrently to workers, with up to queueLen more
+// waiting for a worker. Unlike WithHandshakeLimits, the limit applies to every
+// listener separately, so that a handshake storm on one listener doesn't
+// affect the others. Both limits can be combined. The resource manager's
+// connection limits apply independently.
+func WithListenerUpgradeLimits(workers, queueLen int) Option {
+	return func(u *upgrader) error {
+		if workers <= 0 {
+			return errors.New("need at least one upgrade worker per listener")
+		}
+		if queueLen < 0 {
+			return errors.New("upgrade queue length must not be negative")
+		}
+		u.listenerUpgradeWorkers = workers
+		u.listenerUpgradeQueueLen = queueLen
+		return nil
+	}
+}
+
+// WithAdmission enables the admission step: after the security handshake,
+// and before the stream multiplexer is set up, inbound connections may be
+// challenged to solve a proof of work or to present an admission token, see
+// Admission. The step isn't negotiated: since it changes the wire protocol, it
+// must be enabled on all nodes of the network. Transports that don't use the
+// upgrader, like QUIC, aren't affected, so libp2p.New refuses to combine them
+// with admission.
+func WithAdmission(a Admission) Option {
+	return func(u *upgrader) error {
+		u.admission = &a
+		return nil
+	}
+}
+
+// WithMetricsTracer sets the tracer used to track the admission of inbound
+// handshakes.
+func WithMetricsTracer(mt MetricsTracer) Option {
+	return func(u *upgrader) error {
+		u.metricsTracer = mt
+		return nil
+	}
+}
+
+// HandshakeObserver is notified of inbound connections that failed to be
+// upgraded, e.g. because the security handshake failed or timed out.
+type HandshakeObserver interface {
+	InboundHandshakeFailed(raddr ma.Multiaddr, err error)
+}
+
+// WithHandshakeObserver registers an observer of failed inbound handshakes.
+// It is called synchronously, and must not block.
+func WithHandshakeObserver(o HandshakeObserver) Option {
+	return func(u *upgrader) error {
+		u.handshakeObservers = append(u.handshakeObservers, o)
+		return nil
+	}
+}
+
 type StreamMuxer struct {
 	ID    protocol.ID
 	Muxer network.Multiplexer
@@ -67,20 +232,60 @@ type upgrader struct {
 	//
 	// If unset, the default value (15s) is used.
 	acceptTimeout time.Duration
+	// negotiateTimeout is the maximum duration of the muxer negotiation.
+	negotiateTimeout time.Duration
+
+	disallowInsecure bool
+	eventBus         event.Bus
+
+	handshakeWorkers  int
+	handshakeQueueLen int
+	// handshakeLimiter is shared by all listeners. It's nil if
+	// WithHandshakeLimits wasn't used.
+	handshakeLimiter *handshakeLimiter
+	metricsTracer    MetricsTracer
+
+	listenerUpgradeWorkers  int
+	listenerUpgradeQueueLen int
+
+	// admission is nil if WithAdmission wasn't used.
+	admission *Admission
+
+	handshakeObservers []HandshakeObserver
 }
 
 var _ transport.Upgrader = &upgrader{}
 
+// New creates an upgrader using the given security transports, stream
+// multiplexers, private network key, resource manager and connection gater.
+// Any of them may also be set using opts, see NewUpgrader.
 func New(security []sec.SecureTransport, muxers []StreamMuxer, psk ipnet.PSK, rcmgr network.ResourceManager, connGater connmgr.ConnectionGater, opts ...Option) (transport.Upgrader, error) {
+	return NewUpgrader(append([]Option{
+		WithSecurity(security...),
+		WithMuxers(muxers...),
+		WithPSK(psk),
+		WithResourceManager(rcmgr),
+		WithConnectionGater(connGater),
+	}, opts...)...)
+}
+
+// NewUpgrader creates an upgrader configured by opts. It's the upgrader used
+// by the bundled TCP and WebSocket transports, and is meant to be used by
+// custom transports that establish raw, unencrypted connections as well.
+// Such transports pass each connection to Upgrade (when dialing) or their
+// listener to UpgradeListener, and receive connections that are secured,
+// multiplexed, gated and accounted to the resource manager exactly like the
+// connections of the bundled transports.
+//
+// Connections can only be upgraded if at least one security transport and one
+// stream multiplexer are configured. Use the transport testsuite (p2p/transport/testsuite) to check that a
+// transport integrates correctly.
+func NewUpgrader(opts ...Option) (transport.Upgrader, error) {
 	u := &upgrader{
-		acceptTimeout: defaultAcceptTimeout,
-		rcmgr:         rcmgr,
-		connGater:     connGater,
-		psk:           psk,
-		muxerMuxer:    mss.NewMultistreamMuxer[protocol.ID](),
-		muxers:        muxers,
-		security:      security,
-		securityMuxer: mss.NewMultistreamMuxer[protocol.ID](),
+		acceptTimeout:    defaultAcceptTimeout,
+		negotiateTimeout: defaultNegotiateTimeout,
+		muxerMuxer:       mss.NewMultistreamMuxer[protocol.ID](),
+		securityMuxer:    mss.NewMultistreamMuxer[protocol.ID](),
 	}
 	for _, opt := range opts {
 		if err := opt(u); err != nil {
@@ -90,13 +295,19 @@ func New(security []sec.SecureTransport, muxers []StreamMuxer, psk ipnet.PSK, rc
 	if u.rcmgr == nil {
 		u.rcmgr = &network.NullResourceManager{}
 	}
-	u.muxerIDs = make([]protocol.ID, 0, len(muxers))
-	for _, m := range muxers {
+	if u.handshakeWorkers > 0 {
+		u.handshakeLimiter = newHandshakeLimiter(u.handshakeWorkers, u.handshakeQueueLen, u.metricsTracer)
+	}
+	u.muxerIDs = make([]protocol.ID, 0, len(u.muxers))
+	for _, m := range u.muxers {
 		u.muxerMuxer.AddHandler(m.ID, nil)
 		u.muxerIDs = append(u.muxerIDs, m.ID)
 	}
-	u.securityIDs = make([]protocol.ID, 0, len(security))
-	for _, s := range security {
+	u.securityIDs = make([]protocol.ID, 0, len(u.security))
+	for _, s := range u.security {
+		if u.disallowInsecure && s.ID() == insecure.ID {
+			return nil, fmt.Errorf("security transport %s is insecure, but insecure connections are disallowed", s.ID())
+		}
 		u.securityMuxer.AddHandler(s.ID(), nil)
 		u.securityIDs = append(u.securityIDs, s.ID())
 	}
@@ -116,6 +327,12 @@ func (u *upgrader) UpgradeListener(t transport.Transport, list manet.Listener) t
 		cancel:    cancel,
 		ctx:       ctx,
 	}
+	if u.listenerUpgradeWorkers > 0 {
+		l.limiters = append(l.limiters, newHandshakeLimiter(u.listenerUpgradeWorkers, u.listenerUpgradeQueueLen, u.metricsTracer))
+	}
+	if u.handshakeLimiter != nil {
+		l.limiters = append(l.limiters, u.handshakeLimiter)
+	}
 	go l.handleIncoming()
 	return l
 }
@@ -156,16 +373,24 @@ func (u *upgrader) upgrade(ctx context.Context, t transport.Transport, maconn ma
 	sconn, security, err := u.setupSecurity(ctx, conn, p, isServer)
 	if err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("failed to negotiate security protocol: %w", err)
+		return nil, neterrors.Negotiation(fmt.Errorf("failed to negotiate security protocol: %w", err))
 	}
+	u.checkDowngrade(dir, sconn.RemotePeer(), maconn.RemoteMultiaddr(), security)
 
 	// call the connection gater, if one is registered.
 	if u.connGater != nil && !u.connGater.InterceptSecured(dir, sconn.RemotePeer(), maconn) {
 		if err := maconn.Close(); err != nil {
 			log.Errorw("failed to close connection", "peer", p, "addr", maconn.RemoteMultiaddr(), "error", err)
 		}
-		return nil, fmt.Errorf("gater rejected connection with peer %s and addr %s with direction %d",
-			sconn.RemotePeer(), maconn.RemoteMultiaddr(), dir)
+		return nil, neterrors.Classify(neterrors.ErrGated, fmt.Errorf("gater rejected connection with peer %s and addr %s with direction %d",
+			sconn.RemotePeer(), maconn.RemoteMultiaddr(), dir))
+	}
+	if u.admission != nil {
+		if err := u.runAdmission(ctx, sconn, maconn.RemoteMultiaddr(), isServer); err != nil {
+			sconn.Close()
+			return nil, fmt.Errorf("failed to admit connection with peer %s and addr %s with direction %d: %w",
+				sconn.RemotePeer(), maconn.RemoteMultiaddr(), dir, err)
+		}
 	}
 	// Only call SetPeer if it hasn't already been set -- this can happen when we don't know
 	// the peer in advance and in some bug scenarios.
@@ -175,15 +400,15 @@ func (u *upgrader) upgrade(ctx context.Context, t transport.Transport, maconn ma
 			if err := maconn.Close(); err != nil {
 				log.Errorw("failed to close connection", "peer", p, "addr", maconn.RemoteMultiaddr(), "error", err)
 			}
-			return nil, fmt.Errorf("resource manager connection with peer %s and addr %s with direction %d",
-				sconn.RemotePeer(), maconn.RemoteMultiaddr(), dir)
+			return nil, fmt.Errorf("resource manager connection with peer %s and addr %s with direction %d: %w",
+				sconn.RemotePeer(), maconn.RemoteMultiaddr(), dir, err)
 		}
 	}
 
 	muxer, smconn, err := u.setupMuxer(ctx, sconn, isServer, connScope.PeerScope())
 	if err != nil {
 		sconn.Close()
-		return nil, fmt.Errorf("failed to negotiate stream multiplexer: %w", err)
+		return nil, neterrors.Negotiation(fmt.Errorf("failed to negotiate stream multiplexer: %w", err))
 	}
 
 	tc := &transportConn{
@@ -196,6 +421,8 @@ func (u *upgrader) upgrade(ctx context.Context, t transport.Transport, maconn ma
 		muxer:                     muxer,
 		security:                  security,
 		usedEarlyMuxerNegotiation: sconn.ConnState().UsedEarlyMuxerNegotiation,
+		tls:                       sconn.ConnState().TLS,
+		identifySnapshot:          sconn.ConnState().IdentifySnapshot,
 	}
 	return tc, nil
 }
@@ -214,7 +441,7 @@ func (u *upgrader) setupSecurity(ctx context.Context, conn net.Conn, p peer.ID,
 }
 
 func (u *upgrader) negotiateMuxer(nc net.Conn, isServer bool) (*StreamMuxer, error) {
-	if err := nc.SetDeadline(time.Now().Add(defaultNegotiateTimeout)); err != nil {
+	if err := nc.SetDeadline(time.Now().Add(u.negotiateTimeout)); err != nil {
 		return nil, err
 	}
 
@@ -341,3 +568,45 @@ func (u *upgrader) negotiateSecurity(ctx context.Context, insecure net.Conn, ser
 		return nil, ctx.Err()
 	}
 }
+
+// checkDowngrade logs and emits an event if the negotiation of the security
+// protocol looks like a downgrade, see event.EvtSecurityDowngrade.
+func (u *upgrader) checkDowngrade(dir network.Direction, p peer.ID, raddr ma.Multiaddr, selected protocol.ID) {
+	var preferred protocol.ID
+	for _, id := range u.securityIDs {
+		if id != insecure.ID {
+			preferred = id
+			break
+		}
+	}
+	switch {
+	case preferred == "":
+		// We only support insecure connections.
+		return
+	case selected == insecure.ID:
+		log.Warnw("negotiated insecure connection, although secure protocols are supported", "peer", p, "addr", raddr, "direction", dir)
+	case dir == network.DirOutbound && selected != preferred:
+		// On inbound connections, the dialer's preference decides.
+		log.Debugw("peer rejected preferred security protocol", "peer", p, "addr", raddr, "preferred", preferred, "selected", selected)
+	default:
+		return
+	}
+	if u.eventBus == nil {
+		return
+	}
+	// Downgrades are rare. Create the emitter when needed, so that it doesn't
+	// outlive the upgrader, which isn't closed.
+	emitter, err := u.eventBus.Emitter(new(event.EvtSecurityDowngrade))
+	if err != nil {
+		log.Debugw("failed to create emitter", "error", err)
+		return
+	}
+	defer emitter.Close()
+	emitter.Emit(event.EvtSecurityDowngrade{
+		Peer:       p,
+		RemoteAddr: raddr,
+		Direction:  dir,
+		Preferred:  preferred,
+		Selected:   selected,
+	})
+}
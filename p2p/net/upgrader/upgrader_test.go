@@ -5,16 +5,21 @@ import (
 	"crypto/rand"
 	"errors"
 	"net"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/libp2p/go-libp2p/core/connmgr"
 	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/network"
 	mocknetwork "github.com/libp2p/go-libp2p/core/network/mocks"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/core/sec"
 	"github.com/libp2p/go-libp2p/core/sec/insecure"
 	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
 	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
 	"github.com/libp2p/go-libp2p/p2p/net/upgrader"
 
@@ -158,6 +163,7 @@ func TestOutboundConnectionGating(t *testing.T) {
 	conn, err = dial(t, dialUpgrader, ln.Multiaddr(), id, &network.NullScope{})
 	require.Error(err)
 	require.Contains(err.Error(), "gater rejected connection")
+	require.ErrorIs(err, network.ErrGated)
 	require.Nil(conn)
 }
 
@@ -202,3 +208,113 @@ func TestOutboundResourceManagement(t *testing.T) {
 		require.Error(t, err)
 	})
 }
+
+func TestDisallowInsecure(t *testing.T) {
+	id, priv := newPeer(t)
+	muxers := []upgrader.StreamMuxer{{ID: "negotiate", Muxer: &negotiatingMuxer{}}}
+	_, err := upgrader.New([]sec.SecureTransport{insecure.NewWithIdentity(insecure.ID, id, priv)}, muxers, nil, nil, nil, upgrader.DisallowInsecure())
+	require.ErrorContains(t, err, "insecure connections are disallowed")
+
+	_, err = upgrader.New([]sec.SecureTransport{insecure.NewWithIdentity("/not-plaintext", id, priv)}, muxers, nil, nil, nil, upgrader.DisallowInsecure())
+	require.NoError(t, err)
+}
+
+func TestNewUpgrader(t *testing.T) {
+	_, err := upgrader.NewUpgrader(upgrader.WithNegotiateTimeout(0))
+	require.Error(t, err)
+
+	id, u := createUpgrader(t)
+	ln := createListener(t, u)
+	defer ln.Close()
+
+	// The option-based constructor configures the upgrader like New.
+	dialID, dialPriv := newPeer(t)
+	testGater := &testGater{}
+	dialUpgrader, err := upgrader.NewUpgrader(
+		upgrader.WithSecurity(insecure.NewWithIdentity(insecure.ID, dialID, dialPriv)),
+		upgrader.WithMuxers(upgrader.StreamMuxer{ID: "negotiate", Muxer: &negotiatingMuxer{}}),
+		upgrader.WithConnectionGater(testGater),
+		upgrader.WithNegotiateTimeout(time.Second),
+	)
+	require.NoError(t, err)
+
+	conn, err := dial(t, dialUpgrader, ln.Multiaddr(), id, &network.NullScope{})
+	require.NoError(t, err)
+	require.Equal(t, protocol.ID("negotiate"), conn.ConnState().StreamMultiplexer)
+	require.Equal(t, dialID, conn.LocalPeer())
+	conn.Close()
+
+	testGater.BlockSecured(true)
+	_, err = dial(t, dialUpgrader, ln.Multiaddr(), id, &network.NullScope{})
+	require.ErrorIs(t, err, network.ErrGated)
+}
+
+func TestSecurityDowngradeEvent(t *testing.T) {
+	muxers := []upgrader.StreamMuxer{{ID: "negotiate", Muxer: &negotiatingMuxer{}}}
+
+	testCases := []struct {
+		name           string
+		client, server []protocol.ID
+		downgrade      bool
+		selected       protocol.ID
+	}{
+		{name: "preferred protocol", client: []protocol.ID{"/sec1", "/sec2"}, server: []protocol.ID{"/sec1", "/sec2"}, selected: "/sec1"},
+		{name: "preferred protocol rejected", client: []protocol.ID{"/sec1", "/sec2"}, server: []protocol.ID{"/sec2"}, downgrade: true, selected: "/sec2"},
+		{name: "insecure selected", client: []protocol.ID{"/sec1", insecure.ID}, server: []protocol.ID{insecure.ID}, downgrade: true, selected: insecure.ID},
+		{name: "only insecure", client: []protocol.ID{insecure.ID}, server: []protocol.ID{insecure.ID}, selected: insecure.ID},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			newUpgrader := func(protos []protocol.ID, opts ...upgrader.Option) (peer.ID, transport.Upgrader) {
+				id, priv := newPeer(t)
+				var secs []sec.SecureTransport
+				for _, p := range protos {
+					secs = append(secs, insecure.NewWithIdentity(p, id, priv))
+				}
+				u, err := upgrader.New(secs, muxers, nil, nil, nil, opts...)
+				require.NoError(t, err)
+				return id, u
+			}
+
+			serverID, serverUpgrader := newUpgrader(tc.server)
+			ln := createListener(t, serverUpgrader)
+			defer ln.Close()
+
+			bus := eventbus.NewBus()
+			sub, err := bus.Subscribe(new(event.EvtSecurityDowngrade))
+			require.NoError(t, err)
+			closeSub := sync.OnceFunc(func() { sub.Close() })
+			defer closeSub()
+			_, clientUpgrader := newUpgrader(tc.client, upgrader.WithEventBus(bus))
+
+			conn, err := dial(t, clientUpgrader, ln.Multiaddr(), serverID, &network.NullScope{})
+			require.NoError(t, err)
+			defer conn.Close()
+			require.Equal(t, tc.selected, conn.ConnState().Security)
+
+			if !tc.downgrade {
+				select {
+				case e := <-sub.Out():
+					t.Fatalf("didn't expect a downgrade event: %+v", e)
+				case <-time.After(50 * time.Millisecond):
+				}
+				return
+			}
+			select {
+			case e := <-sub.Out():
+				evt := e.(event.EvtSecurityDowngrade)
+				require.Equal(t, serverID, evt.Peer)
+				require.Equal(t, network.DirOutbound, evt.Direction)
+				require.Equal(t, protocol.ID("/sec1"), evt.Preferred)
+				require.Equal(t, tc.selected, evt.Selected)
+				require.True(t, evt.RemoteAddr.Equal(ln.Multiaddr()))
+			case <-time.After(time.Second):
+				t.Fatal("expected a downgrade event")
+			}
+			// the emitter is closed after emitting the event
+			closeSub()
+			require.Empty(t, bus.GetAllEventTypes())
+		})
+	}
+}
@@ -0,0 +1,150 @@
+// Package p2ptest provides a deterministic source of randomness for tests.
+//
+// Test helpers that generate key material, pick ports or build topologies
+// (e.g. mocknet and the swarm testing package) can draw their randomness from
+// a Rand. When a test fails, the seed is logged, and rerunning the test with
+// the LIBP2P_TEST_SEED environment variable set to that seed (or with
+// WithSeed) reproduces the exact key material and topology.
+package p2ptest
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	mh "github.com/multiformats/go-multihash"
+)
+
+// SeedEnv is the environment variable used to set the seed.
+const SeedEnv = "LIBP2P_TEST_SEED"
+
+const (
+	minPort = 20000
+	maxPort = 60000
+	// maxPortAttempts is the number of ports ListenPort tries before giving
+	// up.
+	maxPortAttempts = 100
+)
+
+type config struct {
+	seed    int64
+	hasSeed bool
+}
+
+// Option is an option that can be passed when constructing a Rand.
+type Option func(*config)
+
+// WithSeed sets the seed. It takes precedence over the seed set using SeedEnv.
+func WithSeed(seed int64) Option {
+	return func(c *config) {
+		c.seed = seed
+		c.hasSeed = true
+	}
+}
+
+// Rand is a deterministic source of randomness. It is safe for concurrent use,
+// but the values are only reproducible if it's used from a single goroutine.
+type Rand struct {
+	seed int64
+
+	mx   sync.Mutex
+	rand *rand.Rand
+}
+
+// New creates a Rand for the test t. If no seed is configured, either using
+// WithSeed or using SeedEnv, a random seed is used. The seed is logged if the
+// test fails.
+func New(t testing.TB, opts ...Option) *Rand {
+	t.Helper()
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if !cfg.hasSeed {
+		if s := os.Getenv(SeedEnv); s != "" {
+			seed, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				t.Fatalf("invalid %s: %s", SeedEnv, err)
+			}
+			cfg.seed = seed
+		} else {
+			cfg.seed = time.Now().UnixNano()
+		}
+	}
+	t.Cleanup(func() {
+		if t.Failed() {
+			t.Logf("to reproduce, run with %s=%d", SeedEnv, cfg.seed)
+		}
+	})
+	return NewWithSeed(cfg.seed)
+}
+
+// NewWithSeed creates a Rand using the given seed.
+func NewWithSeed(seed int64) *Rand {
+	return &Rand{seed: seed, rand: rand.New(rand.NewSource(seed))}
+}
+
+// Seed returns the seed.
+func (r *Rand) Seed() int64 {
+	return r.seed
+}
+
+// Read fills p with random bytes. It implements io.Reader.
+func (r *Rand) Read(p []byte) (int, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.rand.Read(p)
+}
+
+// Intn returns a random number in [0, n).
+func (r *Rand) Intn(n int) int {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.rand.Intn(n)
+}
+
+// Shuffle shuffles n elements, see math/rand.Shuffle.
+func (r *Rand) Shuffle(n int, swap func(i, j int)) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.rand.Shuffle(n, swap)
+}
+
+// KeyPair generates a key pair.
+//
+// Note that only Ed25519 keys are reproducible: the standard library
+// deliberately adds randomness when generating RSA and ECDSA keys, and
+// Secp256k1 keys are generated using the system's randomness.
+func (r *Rand) KeyPair(typ, bits int) (ic.PrivKey, ic.PubKey, error) {
+	return ic.GenerateKeyPairWithReader(typ, bits, r)
+}
+
+// PeerID generates a peer ID. There's no key associated with it.
+func (r *Rand) PeerID() peer.ID {
+	buf := make([]byte, 16)
+	r.Read(buf)
+	h, _ := mh.Sum(buf, mh.SHA2_256, -1)
+	return peer.ID(h)
+}
+
+// ListenPort calls listen with ports picked in a reproducible order, until it
+// succeeds, and returns the port listen succeeded with. listen binds the
+// port, so that no other process can take it between the choice of the port
+// and its use.
+func (r *Rand) ListenPort(listen func(port int) error) (int, error) {
+	var err error
+	for i := 0; i < maxPortAttempts; i++ {
+		port := minPort + r.Intn(maxPort-minPort)
+		if err = listen(port); err == nil {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("failed to listen after %d attempts: %w", maxPortAttempts, err)
+}
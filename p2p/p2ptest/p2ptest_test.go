@@ -0,0 +1,60 @@
+package p2ptest
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeterministic(t *testing.T) {
+	r1 := New(t, WithSeed(42))
+	r2 := New(t, WithSeed(42))
+	require.Equal(t, int64(42), r1.Seed())
+
+	k1, _, err := r1.KeyPair(ic.Ed25519, -1)
+	require.NoError(t, err)
+	k2, _, err := r2.KeyPair(ic.Ed25519, -1)
+	require.NoError(t, err)
+	require.True(t, k1.Equals(k2))
+	require.Equal(t, r1.PeerID(), r2.PeerID())
+	require.Equal(t, r1.Intn(1000), r2.Intn(1000))
+
+	r3 := New(t, WithSeed(43))
+	require.NotEqual(t, r1.PeerID(), r3.PeerID())
+}
+
+func TestSeedFromEnv(t *testing.T) {
+	t.Setenv(SeedEnv, "1234")
+	require.Equal(t, int64(1234), New(t).Seed())
+	// WithSeed takes precedence
+	require.Equal(t, int64(1), New(t, WithSeed(1)).Seed())
+}
+
+func TestListenPort(t *testing.T) {
+	listen := func(ln *net.Listener) func(port int) error {
+		return func(port int) error {
+			var err error
+			*ln, err = net.Listen("tcp4", fmt.Sprintf("127.0.0.1:%d", port))
+			return err
+		}
+	}
+
+	var ln1 net.Listener
+	port, err := New(t, WithSeed(42)).ListenPort(listen(&ln1))
+	require.NoError(t, err)
+	defer ln1.Close()
+	require.GreaterOrEqual(t, port, minPort)
+	require.Less(t, port, maxPort)
+	require.Equal(t, port, ln1.Addr().(*net.TCPAddr).Port)
+
+	// the same seed picks the same port first, which is in use
+	var ln2 net.Listener
+	port2, err := New(t, WithSeed(42)).ListenPort(listen(&ln2))
+	require.NoError(t, err)
+	defer ln2.Close()
+	require.NotEqual(t, port, port2)
+}
@@ -0,0 +1,482 @@
+// Package attestation implements a protocol to exchange signed address
+// attestations (see peer.AddrAttestation).
+//
+// When a host dials a peer, and both peers run the Service, the dialing side
+// signs an attestation stating that it successfully dialed the peer at the
+// address of the connection, and sends it to the peer. The peer verifies the
+// attestation, and collects it if the address is one of its own addresses.
+// Collected attestations can be included in the peer's signed PeerRecord as
+// evidence that the address is dialable by others, which is more reliable than
+// the peer's own report of its addresses.
+//
+// The protocol is not enabled by default; create a Service to handle it, or
+// use the libp2p.EnableAddrAttestation option.
+package attestation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	"github.com/libp2p/go-msgio"
+
+	ma "github.com/multiformats/go-multiaddr"
+	msmux "github.com/multiformats/go-multistream"
+)
+
+var log = logging.Logger("attestation")
+
+const (
+	// ID is the protocol ID of the address attestation protocol.
+	ID protocol.ID = "/libp2p/addr-attestation/1.0.0"
+
+	ServiceName = "libp2p.attestation"
+
+	// maxMsgSize is the maximum size of a signed attestation.
+	maxMsgSize = 4096
+	// maxClockSkew is the maximum time an attestation may be dated in the future.
+	maxClockSkew = 5 * time.Minute
+
+	streamTimeout = time.Minute
+
+	// attestInterval is the minimum duration between two attestations sent to
+	// the same peer. Identify runs on every connection, and again on every
+	// identify push.
+	attestInterval = time.Hour
+)
+
+const (
+	defaultMaxAge      = 24 * time.Hour
+	defaultMaxPerAddr  = 3
+	defaultUpdateDelay = 30 * time.Second
+)
+
+const (
+	// maxRecordAttestations is the maximum number of attestations returned by
+	// Attestations, i.e. included in a peer record.
+	maxRecordAttestations = 16
+	// maxRecordAttestationsSize is the maximum total size of the attestations
+	// returned by Attestations. Identify limits signed peer records to 8 KB,
+	// this leaves enough room for the addresses.
+	maxRecordAttestationsSize = 4096
+)
+
+type Option func(*Service) error
+
+// WithMaxAge sets the maximum age of collected attestations. Older attestations
+// are dropped.
+func WithMaxAge(d time.Duration) Option {
+	return func(s *Service) error {
+		if d <= 0 {
+			return errors.New("max age must be positive")
+		}
+		s.maxAge = d
+		return nil
+	}
+}
+
+// WithMaxAttestationsPerAddr sets the maximum number of attestations collected
+// per address. Only the most recent attestation of every peer is kept, and if
+// there are more than n, the oldest ones are dropped.
+func WithMaxAttestationsPerAddr(n int) Option {
+	return func(s *Service) error {
+		if n <= 0 {
+			return errors.New("max attestations per address must be positive")
+		}
+		s.maxPerAddr = n
+		return nil
+	}
+}
+
+// WithUpdateDelay sets the time new attestations are collected for before
+// notifying the channel returned by Updated. Every notification causes a new
+// peer record to be signed and pushed to all peers.
+func WithUpdateDelay(d time.Duration) Option {
+	return func(s *Service) error {
+		if d < 0 {
+			return errors.New("update delay must not be negative")
+		}
+		s.updateDelay = d
+		return nil
+	}
+}
+
+type collectedAttestation struct {
+	attester  peer.ID
+	timestamp time.Time
+	envelope  *record.Envelope
+	// size is the size of the marshalled envelope
+	size int
+}
+
+// Service attests the addresses of peers we dial, and collects the
+// attestations of our own addresses sent by other peers.
+type Service struct {
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+
+	host    host.Host
+	privKey crypto.PrivKey
+
+	maxAge      time.Duration
+	maxPerAddr  int
+	updateDelay time.Duration
+
+	mx sync.Mutex
+	// attestations is keyed by the binary representation of the attested address
+	attestations map[string][]collectedAttestation
+	// attested holds the time we last sent an attestation to a peer
+	attested map[peer.ID]time.Time
+
+	updated chan struct{}
+	// updateTimer is running while new attestations are collected before
+	// notifying updated.
+	updateTimer *time.Timer
+
+	// envelopes verifies the attestations received concurrently in batches.
+	envelopes *record.EnvelopeBatcher
+
+	refCount sync.WaitGroup
+}
+
+// NewService creates a new Service, and registers the stream handler.
+func NewService(h host.Host, opts ...Option) (*Service, error) {
+	privKey := h.Peerstore().PrivKey(h.ID())
+	if privKey == nil {
+		return nil, errors.New("missing private key of the host")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Service{
+		ctx:          ctx,
+		ctxCancel:    cancel,
+		host:         h,
+		privKey:      privKey,
+		maxAge:       defaultMaxAge,
+		maxPerAddr:   defaultMaxPerAddr,
+		updateDelay:  defaultUpdateDelay,
+		attestations: make(map[string][]collectedAttestation),
+		attested:     make(map[peer.ID]time.Time),
+		updated:      make(chan struct{}, 1),
+		envelopes:    record.NewEnvelopeBatcher(peer.AddrAttestationEnvelopeDomain),
+	}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	sub, err := h.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted), eventbus.Name("attestation"))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	h.SetStreamHandler(ID, s.handleNewStream)
+
+	s.refCount.Add(1)
+	go s.background(sub)
+	return s, nil
+}
+
+// Close removes the stream handler, and stops all attestations in progress.
+func (s *Service) Close() error {
+	s.host.RemoveStreamHandler(ID)
+	s.ctxCancel()
+	s.refCount.Wait()
+	s.mx.Lock()
+	if s.updateTimer != nil {
+		s.updateTimer.Stop()
+	}
+	s.mx.Unlock()
+	return nil
+}
+
+// Updated returns a channel that receives a value when attestations from new
+// peers were collected. Attestations collected in quick succession are
+// coalesced into a single notification, see WithUpdateDelay. Newer
+// attestations from the same peers replace the previous ones silently.
+func (s *Service) Updated() <-chan struct{} {
+	return s.updated
+}
+
+// Attestations returns the collected attestations of addrs that haven't
+// expired, e.g. to include them in our PeerRecord. At most 16 attestations
+// with a total size of 4 KB are returned, dropping the oldest ones first.
+func (s *Service) Attestations(addrs []ma.Multiaddr) []*record.Envelope {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.removeExpiredLocked()
+	var all []collectedAttestation
+	for _, a := range addrs {
+		all = append(all, s.attestations[string(a.Bytes())]...)
+	}
+	if len(all) == 0 {
+		return nil
+	}
+
+	// Keep the most recent attestations that fit, in the order of addrs.
+	byAge := make([]int, len(all))
+	for i := range byAge {
+		byAge[i] = i
+	}
+	sort.SliceStable(byAge, func(i, j int) bool {
+		return all[byAge[i]].timestamp.After(all[byAge[j]].timestamp)
+	})
+	keep := make([]bool, len(all))
+	n, size := 0, 0
+	for _, i := range byAge {
+		if n == maxRecordAttestations {
+			break
+		}
+		if size+all[i].size > maxRecordAttestationsSize {
+			continue
+		}
+		keep[i] = true
+		n++
+		size += all[i].size
+	}
+	res := make([]*record.Envelope, 0, n)
+	for i, ca := range all {
+		if keep[i] {
+			res = append(res, ca.envelope)
+		}
+	}
+	return res
+}
+
+func (s *Service) removeExpiredLocked() {
+	now := time.Now()
+	for k, as := range s.attestations {
+		n := 0
+		for _, ca := range as {
+			if now.Sub(ca.timestamp) < s.maxAge {
+				as[n] = ca
+				n++
+			}
+		}
+		if n == 0 {
+			delete(s.attestations, k)
+		} else {
+			s.attestations[k] = as[:n]
+		}
+	}
+}
+
+func (s *Service) background(sub event.Subscription) {
+	defer s.refCount.Done()
+	defer sub.Close()
+
+	for {
+		select {
+		case e, ok := <-sub.Out():
+			if !ok {
+				return
+			}
+			evt := e.(event.EvtPeerIdentificationCompleted)
+			if !s.shouldAttest(evt) {
+				continue
+			}
+			s.refCount.Add(1)
+			go func() {
+				defer s.refCount.Done()
+				if err := s.Attest(s.ctx, evt.Conn); err != nil {
+					log.Debugw("failed to send attestation", "peer", evt.Peer, "addr", evt.Conn.RemoteMultiaddr(), "error", err)
+				}
+			}()
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// shouldAttest returns true for direct outbound connections to peers that run
+// the Service.
+func (s *Service) shouldAttest(evt event.EvtPeerIdentificationCompleted) bool {
+	c := evt.Conn
+	if c.Stat().Direction != network.DirOutbound || c.Stat().Transient || isRelayAddr(c.RemoteMultiaddr()) {
+		return false
+	}
+	supported := false
+	for _, p := range evt.Protocols {
+		if p == ID {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return false
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	now := time.Now()
+	for p, t := range s.attested {
+		if now.Sub(t) >= attestInterval {
+			delete(s.attested, p)
+		}
+	}
+	if _, ok := s.attested[evt.Peer]; ok {
+		return false
+	}
+	s.attested[evt.Peer] = now
+	return true
+}
+
+// Attest sends an attestation stating that we dialed the remote peer of c at
+// its remote address to the peer.
+func (s *Service) Attest(ctx context.Context, c network.Conn) error {
+	att := &peer.AddrAttestation{
+		Subject:   c.RemotePeer(),
+		Addr:      c.RemoteMultiaddr(),
+		Timestamp: time.Now(),
+	}
+	env, err := record.Seal(att, s.privKey)
+	if err != nil {
+		return err
+	}
+	b, err := env.Marshal()
+	if err != nil {
+		return err
+	}
+
+	str, err := c.NewStream(ctx)
+	if err != nil {
+		return err
+	}
+	defer str.Close()
+	str.SetDeadline(time.Now().Add(streamTimeout))
+
+	if err := str.SetProtocol(ID); err != nil {
+		str.Reset()
+		return err
+	}
+	if err := str.Scope().SetService(ServiceName); err != nil {
+		str.Reset()
+		return err
+	}
+	if err := msmux.SelectProtoOrFail(ID, str); err != nil {
+		str.Reset()
+		return err
+	}
+	if err := msgio.NewVarintWriter(str).WriteMsg(b); err != nil {
+		str.Reset()
+		return err
+	}
+	return nil
+}
+
+// handleNewStream runs the receiving side of the protocol.
+func (s *Service) handleNewStream(str network.Stream) {
+	if err := str.Scope().SetService(ServiceName); err != nil {
+		log.Debugf("error attaching stream to attestation service: %s", err)
+		str.Reset()
+		return
+	}
+	str.SetDeadline(time.Now().Add(streamTimeout))
+
+	mr := msgio.NewVarintReaderSize(str, maxMsgSize)
+	msg, err := mr.ReadMsg()
+	if err != nil {
+		log.Debugf("error reading attestation: %s", err)
+		str.Reset()
+		return
+	}
+	str.Close()
+	// The message is not released to the buffer pool, since the envelope
+	// references it.
+
+	if err := s.collect(str.Conn().RemotePeer(), msg); err != nil {
+		log.Debugw("rejected attestation", "peer", str.Conn().RemotePeer(), "error", err)
+	}
+}
+
+// collect validates a signed attestation sent by p, and stores it.
+func (s *Service) collect(p peer.ID, msg []byte) error {
+	env, rec, err := s.envelopes.ConsumeEnvelope(msg)
+	if err != nil {
+		return err
+	}
+	att, ok := rec.(*peer.AddrAttestation)
+	if !ok {
+		return fmt.Errorf("unexpected record type: %T", rec)
+	}
+	attester, err := peer.IDFromPublicKey(env.PublicKey)
+	if err != nil {
+		return err
+	}
+	if attester != p {
+		return fmt.Errorf("attestation signed by %s", attester)
+	}
+	if att.Subject != s.host.ID() {
+		return fmt.Errorf("attestation for %s", att.Subject)
+	}
+	if age := time.Since(att.Timestamp); age < -maxClockSkew || age >= s.maxAge {
+		return fmt.Errorf("invalid timestamp: %s", att.Timestamp)
+	}
+	known := false
+	for _, a := range s.host.Addrs() {
+		if a.Equal(att.Addr) {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return fmt.Errorf("unknown address: %s", att.Addr)
+	}
+
+	s.mx.Lock()
+	key := string(att.Addr.Bytes())
+	as := s.attestations[key]
+	isNew := true
+	for i, ca := range as {
+		if ca.attester == attester {
+			as = append(as[:i], as[i+1:]...)
+			isNew = false
+			break
+		}
+	}
+	as = append(as, collectedAttestation{attester: attester, timestamp: att.Timestamp, envelope: env, size: len(msg)})
+	if len(as) > s.maxPerAddr {
+		oldest := 0
+		for i, ca := range as {
+			if ca.timestamp.Before(as[oldest].timestamp) {
+				oldest = i
+			}
+		}
+		as = append(as[:oldest], as[oldest+1:]...)
+	}
+	s.attestations[key] = as
+	if isNew && s.updateTimer == nil {
+		s.updateTimer = time.AfterFunc(s.updateDelay, s.notifyUpdated)
+	}
+	s.mx.Unlock()
+	return nil
+}
+
+func (s *Service) notifyUpdated() {
+	s.mx.Lock()
+	s.updateTimer = nil
+	s.mx.Unlock()
+	select {
+	case s.updated <- struct{}{}:
+	default:
+	}
+}
+
+func isRelayAddr(a ma.Multiaddr) bool {
+	_, err := a.ValueForProtocol(ma.P_CIRCUIT)
+	return err == nil
+}
@@ -0,0 +1,102 @@
+package attestation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/libp2p/go-libp2p/core/test"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/blank"
+	swarmt "github.com/libp2p/go-libp2p/p2p/net/swarm/testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollect(t *testing.T) {
+	h := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	defer h.Close()
+	s, err := NewService(h, WithMaxAttestationsPerAddr(2), WithMaxAge(time.Hour))
+	require.NoError(t, err)
+	defer s.Close()
+	addr := h.Addrs()[0]
+
+	sign := func(att *peer.AddrAttestation) (peer.ID, []byte) {
+		priv, _, err := test.RandTestKeyPair(crypto.Ed25519, 256)
+		require.NoError(t, err)
+		id, err := peer.IDFromPrivateKey(priv)
+		require.NoError(t, err)
+		env, err := record.Seal(att, priv)
+		require.NoError(t, err)
+		b, err := env.Marshal()
+		require.NoError(t, err)
+		return id, b
+	}
+
+	p, b := sign(&peer.AddrAttestation{Subject: test.RandPeerIDFatal(t), Addr: addr, Timestamp: time.Now()})
+	require.ErrorContains(t, s.collect(p, b), "attestation for")
+	p, b = sign(&peer.AddrAttestation{Subject: h.ID(), Addr: ma.StringCast("/ip4/1.2.3.4/tcp/1"), Timestamp: time.Now()})
+	require.ErrorContains(t, s.collect(p, b), "unknown address")
+	p, b = sign(&peer.AddrAttestation{Subject: h.ID(), Addr: addr, Timestamp: time.Now().Add(-2 * time.Hour)})
+	require.ErrorContains(t, s.collect(p, b), "invalid timestamp")
+	_, b = sign(&peer.AddrAttestation{Subject: h.ID(), Addr: addr, Timestamp: time.Now()})
+	require.ErrorContains(t, s.collect(test.RandPeerIDFatal(t), b), "signed by")
+	require.Empty(t, s.Attestations(h.Addrs()))
+
+	// only the most recent attestations are kept
+	for i := 0; i < 3; i++ {
+		p, b = sign(&peer.AddrAttestation{Subject: h.ID(), Addr: addr, Timestamp: time.Now().Add(time.Duration(i) * time.Second)})
+		require.NoError(t, s.collect(p, b))
+	}
+	atts := s.Attestations(h.Addrs())
+	require.Len(t, atts, 2)
+	attester, _, err := peer.AddrAttestationFromEnvelope(atts[1])
+	require.NoError(t, err)
+	require.Equal(t, p, attester)
+}
+
+func TestAttestationsLimit(t *testing.T) {
+	h := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	defer h.Close()
+	s, err := NewService(h, WithMaxAttestationsPerAddr(100), WithUpdateDelay(100*time.Millisecond))
+	require.NoError(t, err)
+	defer s.Close()
+	addr := h.Addrs()[0]
+
+	var attesters []peer.ID
+	for i := 0; i < maxRecordAttestations+4; i++ {
+		priv, _, err := test.RandTestKeyPair(crypto.Ed25519, 256)
+		require.NoError(t, err)
+		id, err := peer.IDFromPrivateKey(priv)
+		require.NoError(t, err)
+		env, err := record.Seal(&peer.AddrAttestation{Subject: h.ID(), Addr: addr, Timestamp: time.Now().Add(time.Duration(i) * time.Second)}, priv)
+		require.NoError(t, err)
+		b, err := env.Marshal()
+		require.NoError(t, err)
+		require.NoError(t, s.collect(id, b))
+		attesters = append(attesters, id)
+	}
+
+	// the oldest attestations are dropped
+	atts := s.Attestations(h.Addrs())
+	require.Len(t, atts, maxRecordAttestations)
+	for i, env := range atts {
+		attester, _, err := peer.AddrAttestationFromEnvelope(env)
+		require.NoError(t, err)
+		require.Equal(t, attesters[i+4], attester)
+	}
+
+	// all attestations result in a single update
+	select {
+	case <-s.Updated():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an update")
+	}
+	select {
+	case <-s.Updated():
+		t.Fatal("expected a single update")
+	case <-time.After(300 * time.Millisecond):
+	}
+}
@@ -0,0 +1,77 @@
+package attestation_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/libp2p/go-libp2p/p2p/protocol/attestation"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newHost(t *testing.T, opts ...libp2p.Option) host.Host {
+	t.Helper()
+	opts = append([]libp2p.Option{libp2p.Transport(tcp.NewTCPTransport), libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0")}, opts...)
+	h, err := libp2p.New(opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+// ownAttestations returns the attestations in the signed peer record of h.
+func ownAttestations(t *testing.T, h host.Host) []*record.Envelope {
+	cab, ok := peerstore.GetCertifiedAddrBook(h.Peerstore())
+	require.True(t, ok)
+	env := cab.GetPeerRecord(h.ID())
+	if env == nil {
+		return nil
+	}
+	rec, err := env.Record()
+	require.NoError(t, err)
+	return rec.(*peer.PeerRecord).Attestations
+}
+
+func TestAttestationInPeerRecord(t *testing.T) {
+	h1 := newHost(t, libp2p.EnableAddrAttestation(attestation.WithUpdateDelay(100*time.Millisecond)))
+	h2 := newHost(t, libp2p.EnableAddrAttestation(attestation.WithUpdateDelay(100*time.Millisecond)))
+	sub, err := h1.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted))
+	require.NoError(t, err)
+	defer sub.Close()
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+	dialed := h1.Network().ConnsToPeer(h2.ID())[0].RemoteMultiaddr()
+
+	// h2 collects the attestation, and includes it in its signed peer record
+	require.Eventually(t, func() bool { return len(ownAttestations(t, h2)) == 1 }, 5*time.Second, 50*time.Millisecond)
+	attester, att, err := peer.AddrAttestationFromEnvelope(ownAttestations(t, h2)[0])
+	require.NoError(t, err)
+	require.Equal(t, h1.ID(), attester)
+	require.Equal(t, h2.ID(), att.Subject)
+	require.True(t, att.Addr.Equal(dialed))
+
+	// the updated record is pushed to h1
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-sub.Out():
+			evt := e.(event.EvtPeerIdentificationCompleted)
+			if evt.SignedPeerRecord == nil {
+				continue
+			}
+			rec, err := evt.SignedPeerRecord.Record()
+			require.NoError(t, err)
+			if len(rec.(*peer.PeerRecord).Attestations) == 1 {
+				return
+			}
+		case <-timeout:
+			t.Fatal("didn't receive the peer record with the attestation")
+		}
+	}
+}
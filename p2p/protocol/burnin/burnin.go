@@ -0,0 +1,414 @@
+// Package burnin implements a protocol to soak-test a connection path.
+//
+// The client sends a stream of messages at a controlled rate, and the server
+// echoes every message back. Every message carries a sequence number and a
+// checksum, which both sides verify, so that data corruption, reordering and
+// loss are detected. The client measures the round trip time of every message.
+//
+// The protocol is not enabled by default; create a BurninService to handle it.
+package burnin
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	pool "github.com/libp2p/go-buffer-pool"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var log = logging.Logger("burnin")
+
+const (
+	ID = "/burnin/1.0.0"
+
+	ServiceName = "libp2p.burnin"
+
+	// MaxMessageSize is the maximum payload size of a single message.
+	MaxMessageSize = 64 << 10 // 64 KiB
+
+	// header: sequence number (8 bytes) and payload length (4 bytes)
+	headerSize = 8 + 4
+	// trailer: CRC32 checksum over header and payload
+	trailerSize = 4
+
+	streamTimeout = time.Minute
+)
+
+// ErrCorrupted is returned when a message fails verification.
+var ErrCorrupted = errors.New("burn-in message corrupted")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Stats are the statistics collected by the BurninService.
+type Stats struct {
+	Streams  uint64
+	Messages uint64
+	Bytes    uint64
+	// Corrupted is the number of streams that were aborted because a message
+	// failed verification.
+	Corrupted uint64
+}
+
+type BurninService struct {
+	Host host.Host
+
+	mx    sync.Mutex
+	stats Stats
+}
+
+// NewBurninService creates a new BurninService, and registers the stream handler.
+func NewBurninService(h host.Host) *BurninService {
+	bs := &BurninService{Host: h}
+	h.SetStreamHandler(ID, bs.BurninHandler)
+	return bs
+}
+
+// Close removes the stream handler.
+func (bs *BurninService) Close() error {
+	bs.Host.RemoveStreamHandler(ID)
+	return nil
+}
+
+// Stats returns the statistics of all burn-in streams handled so far.
+func (bs *BurninService) Stats() Stats {
+	bs.mx.Lock()
+	defer bs.mx.Unlock()
+	return bs.stats
+}
+
+func (bs *BurninService) BurninHandler(s network.Stream) {
+	if err := s.Scope().SetService(ServiceName); err != nil {
+		log.Debugf("error attaching stream to burn-in service: %s", err)
+		s.Reset()
+		return
+	}
+
+	const bufSize = headerSize + MaxMessageSize + trailerSize
+	if err := s.Scope().ReserveMemory(bufSize, network.ReservationPriorityAlways); err != nil {
+		log.Debugf("error reserving memory for burn-in stream: %s", err)
+		s.Reset()
+		return
+	}
+	defer s.Scope().ReleaseMemory(bufSize)
+
+	buf := pool.Get(bufSize)
+	defer pool.Put(buf)
+
+	bs.mx.Lock()
+	bs.stats.Streams++
+	bs.mx.Unlock()
+
+	var expectedSeq uint64
+	for {
+		s.SetDeadline(time.Now().Add(streamTimeout))
+		msg, seq, err := readMessage(s, buf)
+		if err == io.EOF {
+			s.Close()
+			return
+		}
+		if err != nil {
+			if errors.Is(err, ErrCorrupted) {
+				bs.mx.Lock()
+				bs.stats.Corrupted++
+				bs.mx.Unlock()
+			}
+			log.Debugf("error reading burn-in message: %s", err)
+			s.Reset()
+			return
+		}
+		if seq != expectedSeq {
+			bs.mx.Lock()
+			bs.stats.Corrupted++
+			bs.mx.Unlock()
+			log.Debugf("unexpected burn-in sequence number: %d, expected: %d", seq, expectedSeq)
+			s.Reset()
+			return
+		}
+		expectedSeq++
+
+		if _, err := s.Write(msg); err != nil {
+			log.Debugf("error writing burn-in message: %s", err)
+			s.Reset()
+			return
+		}
+		bs.mx.Lock()
+		bs.stats.Messages++
+		bs.stats.Bytes += uint64(len(msg))
+		bs.mx.Unlock()
+	}
+}
+
+// Result is the result of a burn-in run.
+type Result struct {
+	// Sent is the number of messages sent.
+	Sent uint64
+	// Received is the number of messages that were echoed back and verified.
+	Received uint64
+	// BytesSent and BytesReceived count the payload bytes.
+	BytesSent     uint64
+	BytesReceived uint64
+
+	MinRTT time.Duration
+	MaxRTT time.Duration
+	AvgRTT time.Duration
+
+	// Duration is the time from sending the first message until receiving the last one.
+	Duration time.Duration
+}
+
+type config struct {
+	rate        float64
+	messageSize int
+	duration    time.Duration
+	count       uint64
+	onMessage   func(seq uint64, rtt time.Duration)
+}
+
+type Option func(*config) error
+
+// WithRate sets the number of messages sent per second. Defaults to 10.
+func WithRate(msgsPerSecond float64) Option {
+	return func(c *config) error {
+		if msgsPerSecond <= 0 {
+			return errors.New("rate must be positive")
+		}
+		c.rate = msgsPerSecond
+		return nil
+	}
+}
+
+// WithMessageSize sets the payload size of every message. Defaults to 1 KiB.
+func WithMessageSize(size int) Option {
+	return func(c *config) error {
+		if size < 0 || size > MaxMessageSize {
+			return fmt.Errorf("message size must be between 0 and %d bytes", MaxMessageSize)
+		}
+		c.messageSize = size
+		return nil
+	}
+}
+
+// WithDuration sets for how long messages are sent. Defaults to 10s.
+// Run stops when either the duration or the message count (see WithCount) is reached.
+// A duration of 0 disables the time limit.
+func WithDuration(d time.Duration) Option {
+	return func(c *config) error {
+		c.duration = d
+		return nil
+	}
+}
+
+// WithCount sets the number of messages to send. By default, the number of messages is not limited.
+func WithCount(n uint64) Option {
+	return func(c *config) error {
+		c.count = n
+		return nil
+	}
+}
+
+// WithMessageCallback sets a callback that is called for every message that was echoed back.
+// This allows observing the progress of long-running burn-in tests.
+func WithMessageCallback(f func(seq uint64, rtt time.Duration)) Option {
+	return func(c *config) error {
+		c.onMessage = f
+		return nil
+	}
+}
+
+func (bs *BurninService) Run(ctx context.Context, p peer.ID, opts ...Option) (*Result, error) {
+	return Run(ctx, bs.Host, p, opts...)
+}
+
+// Run runs a burn-in test against the remote peer. It returns once all
+// messages have been sent and echoed back, or once an error occurs.
+// The Result is returned even if an error occurs, and contains the
+// statistics collected until then.
+func Run(ctx context.Context, h host.Host, p peer.ID, opts ...Option) (*Result, error) {
+	cfg := &config{
+		rate:        10,
+		messageSize: 1 << 10,
+		duration:    10 * time.Second,
+	}
+	for _, opt := range opts {
+		if err := opt(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	res := &Result{}
+	s, err := h.NewStream(network.WithUseTransient(ctx, "burnin"), p, ID)
+	if err != nil {
+		return res, err
+	}
+	defer s.Close()
+
+	if err := s.Scope().SetService(ServiceName); err != nil {
+		log.Debugf("error attaching stream to burn-in service: %s", err)
+		s.Reset()
+		return res, err
+	}
+	bufSize := headerSize + cfg.messageSize + trailerSize
+	if err := s.Scope().ReserveMemory(2*bufSize, network.ReservationPriorityAlways); err != nil {
+		log.Debugf("error reserving memory for burn-in stream: %s", err)
+		s.Reset()
+		return res, err
+	}
+	defer s.Scope().ReleaseMemory(2 * bufSize)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { s.Reset() })
+	defer stop()
+
+	// send times of the messages in flight, indexed by sequence number
+	var sentMx sync.Mutex
+	sentTimes := make(map[uint64]time.Time)
+	var start time.Time
+
+	writeErr := make(chan error, 1)
+	go func() {
+		writeErr <- func() error {
+			wbuf := pool.Get(bufSize)
+			defer pool.Put(wbuf)
+
+			// Very high rates would round the interval down to 0, which NewTicker doesn't accept.
+			ticker := time.NewTicker(max(time.Duration(float64(time.Second)/cfg.rate), time.Nanosecond))
+			defer ticker.Stop()
+			deadline := time.Now().Add(cfg.duration)
+			for seq := uint64(0); cfg.count == 0 || seq < cfg.count; seq++ {
+				now := time.Now()
+				if cfg.duration > 0 && !now.Before(deadline) {
+					break
+				}
+				sentMx.Lock()
+				if seq == 0 {
+					start = now
+				}
+				sentTimes[seq] = now
+				sentMx.Unlock()
+				if _, err := s.Write(writeMessage(wbuf, seq)); err != nil {
+					return err
+				}
+				sentMx.Lock()
+				res.Sent++
+				res.BytesSent += uint64(cfg.messageSize)
+				sentMx.Unlock()
+
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return s.CloseWrite()
+		}()
+	}()
+
+	rbuf := pool.Get(bufSize)
+	defer pool.Put(rbuf)
+	var totalRTT time.Duration
+	for expectedSeq := uint64(0); ; expectedSeq++ {
+		s.SetReadDeadline(time.Now().Add(streamTimeout))
+		msg, seq, err := readMessage(s, rbuf)
+		if err == io.EOF {
+			break
+		}
+		if err == nil && (seq != expectedSeq || len(msg) != bufSize || !verifyPayload(msg[headerSize:len(msg)-trailerSize], seq)) {
+			err = ErrCorrupted
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			cancel()
+			<-writeErr
+			return res, err
+		}
+
+		now := time.Now()
+		sentMx.Lock()
+		rtt := now.Sub(sentTimes[seq])
+		delete(sentTimes, seq)
+		res.Duration = now.Sub(start)
+		sentMx.Unlock()
+
+		res.Received++
+		res.BytesReceived += uint64(cfg.messageSize)
+		totalRTT += rtt
+		res.AvgRTT = totalRTT / time.Duration(res.Received)
+		if res.MinRTT == 0 || rtt < res.MinRTT {
+			res.MinRTT = rtt
+		}
+		if rtt > res.MaxRTT {
+			res.MaxRTT = rtt
+		}
+		if cfg.onMessage != nil {
+			cfg.onMessage(seq, rtt)
+		}
+	}
+
+	if err := <-writeErr; err != nil {
+		return res, err
+	}
+	if res.Received != res.Sent {
+		return res, fmt.Errorf("sent %d messages, but only received %d", res.Sent, res.Received)
+	}
+	return res, nil
+}
+
+// writeMessage encodes the message with the given sequence number into buf,
+// filling the payload with pseudo-random data derived from the sequence number.
+func writeMessage(buf []byte, seq uint64) []byte {
+	payload := buf[headerSize : len(buf)-trailerSize]
+	binary.BigEndian.PutUint64(buf, seq)
+	binary.BigEndian.PutUint32(buf[8:], uint32(len(payload)))
+	fillPayload(payload, seq)
+	binary.BigEndian.PutUint32(buf[len(buf)-trailerSize:], crc32.Checksum(buf[:len(buf)-trailerSize], crcTable))
+	return buf
+}
+
+func fillPayload(payload []byte, seq uint64) {
+	r := rand.New(rand.NewSource(int64(seq)))
+	r.Read(payload)
+}
+
+func verifyPayload(payload []byte, seq uint64) bool {
+	expected := make([]byte, len(payload))
+	fillPayload(expected, seq)
+	return string(expected) == string(payload)
+}
+
+// readMessage reads a message into buf, and verifies its checksum.
+// It returns the full message (including header and trailer), and the sequence number.
+func readMessage(r io.Reader, buf []byte) ([]byte, uint64, error) {
+	if _, err := io.ReadFull(r, buf[:headerSize]); err != nil {
+		return nil, 0, err
+	}
+	seq := binary.BigEndian.Uint64(buf)
+	length := int(binary.BigEndian.Uint32(buf[8:]))
+	if length > len(buf)-headerSize-trailerSize {
+		return nil, 0, fmt.Errorf("%w: message too large: %d bytes", ErrCorrupted, length)
+	}
+	msg := buf[:headerSize+length+trailerSize]
+	if _, err := io.ReadFull(r, msg[headerSize:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	checksum := binary.BigEndian.Uint32(msg[len(msg)-trailerSize:])
+	if crc32.Checksum(msg[:len(msg)-trailerSize], crcTable) != checksum {
+		return nil, 0, fmt.Errorf("%w: checksum mismatch", ErrCorrupted)
+	}
+	return msg, seq, nil
+}
@@ -0,0 +1,137 @@
+package burnin
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	swarmt "github.com/libp2p/go-libp2p/p2p/net/swarm/testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBurnin(t *testing.T) {
+	h1, err := bhost.NewHost(swarmt.GenSwarm(t), nil)
+	require.NoError(t, err)
+	defer h1.Close()
+	h1.Start()
+	h2, err := bhost.NewHost(swarmt.GenSwarm(t), nil)
+	require.NoError(t, err)
+	defer h2.Close()
+	h2.Start()
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+
+	bs := NewBurninService(h2)
+	defer bs.Close()
+
+	var callbacks int
+	res, err := Run(context.Background(), h1, h2.ID(),
+		WithRate(200),
+		WithCount(50),
+		WithMessageSize(4096),
+		WithMessageCallback(func(uint64, time.Duration) { callbacks++ }),
+	)
+	require.NoError(t, err)
+	require.Equal(t, uint64(50), res.Sent)
+	require.Equal(t, uint64(50), res.Received)
+	require.Equal(t, uint64(50*4096), res.BytesReceived)
+	require.Equal(t, 50, callbacks)
+	require.NotZero(t, res.MinRTT)
+	require.LessOrEqual(t, res.MinRTT, res.AvgRTT)
+	require.LessOrEqual(t, res.AvgRTT, res.MaxRTT)
+
+	require.Eventually(t, func() bool {
+		stats := bs.Stats()
+		return stats.Streams == 1 && stats.Messages == 50
+	}, time.Second, 10*time.Millisecond)
+	require.Zero(t, bs.Stats().Corrupted)
+}
+
+func TestBurninDuration(t *testing.T) {
+	h1, err := bhost.NewHost(swarmt.GenSwarm(t), nil)
+	require.NoError(t, err)
+	defer h1.Close()
+	h1.Start()
+	h2, err := bhost.NewHost(swarmt.GenSwarm(t), nil)
+	require.NoError(t, err)
+	defer h2.Close()
+	h2.Start()
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+	NewBurninService(h2)
+
+	start := time.Now()
+	res, err := Run(context.Background(), h1, h2.ID(), WithRate(100), WithDuration(200*time.Millisecond))
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), time.Second)
+	require.NotZero(t, res.Received)
+	require.Equal(t, res.Sent, res.Received)
+}
+
+func TestBurninHighRate(t *testing.T) {
+	h1, err := bhost.NewHost(swarmt.GenSwarm(t), nil)
+	require.NoError(t, err)
+	defer h1.Close()
+	h1.Start()
+	h2, err := bhost.NewHost(swarmt.GenSwarm(t), nil)
+	require.NoError(t, err)
+	defer h2.Close()
+	h2.Start()
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+	NewBurninService(h2)
+
+	// the interval between two messages rounds down to 0
+	res, err := Run(context.Background(), h1, h2.ID(), WithRate(1e10), WithCount(10))
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), res.Received)
+}
+
+func TestBurninDetectsCorruption(t *testing.T) {
+	h1, err := bhost.NewHost(swarmt.GenSwarm(t), nil)
+	require.NoError(t, err)
+	defer h1.Close()
+	h1.Start()
+	h2, err := bhost.NewHost(swarmt.GenSwarm(t), nil)
+	require.NoError(t, err)
+	defer h2.Close()
+	h2.Start()
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+
+	// a server that flips a bit in every message
+	h2.SetStreamHandler(ID, func(s network.Stream) {
+		buf := make([]byte, headerSize+MaxMessageSize+trailerSize)
+		for {
+			msg, _, err := readMessage(s, buf)
+			if err != nil {
+				s.Reset()
+				return
+			}
+			msg[headerSize] ^= 1
+			if _, err := s.Write(msg); err != nil {
+				return
+			}
+		}
+	})
+
+	res, err := Run(context.Background(), h1, h2.ID(), WithRate(100), WithCount(10))
+	require.ErrorIs(t, err, ErrCorrupted)
+	require.Zero(t, res.Received)
+}
+
+func TestMessageEncoding(t *testing.T) {
+	buf := make([]byte, headerSize+100+trailerSize)
+	msg := writeMessage(buf, 42)
+	rbuf := make([]byte, len(buf))
+	decoded, seq, err := readMessage(bytes.NewReader(msg), rbuf)
+	require.NoError(t, err)
+	require.Equal(t, uint64(42), seq)
+	require.True(t, verifyPayload(decoded[headerSize:len(decoded)-trailerSize], 42))
+	require.False(t, verifyPayload(decoded[headerSize:len(decoded)-trailerSize], 43))
+
+	msg[len(msg)-1] ^= 1
+	_, _, err = readMessage(bytes.NewReader(msg), rbuf)
+	require.ErrorIs(t, err, ErrCorrupted)
+}
@@ -3,6 +3,8 @@ package client
 import (
 	"fmt"
 	"net"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/network"
@@ -24,12 +26,28 @@ var (
 	StatLimitData     = statLimitData{}
 )
 
+// Conn is a connection relayed through a circuit relay. It's backed by a
+// stream to the relay, and supports deadlines as well as closing each
+// direction separately: the relay propagates the half-close to the other end.
 type Conn struct {
 	stream network.Stream
 	remote peer.AddrInfo
 	stat   network.ConnStats
 
 	client *Client
+
+	closeOnce sync.Once
+	closeErr  error
+
+	// Concurrent writes to a stream may exceed its flow control window.
+	writeMx sync.Mutex
+
+	// The stream's deadlines only apply to blocking operations. They're
+	// tracked here, so that a deadline in the past fails every operation,
+	// as required by net.Conn.
+	deadlineMx    sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
 }
 
 type NetAddr struct {
@@ -50,31 +68,72 @@ func (n *NetAddr) String() string {
 // Conn interface
 var _ manet.Conn = (*Conn)(nil)
 
+// Close closes the connection. Data written before Close is still delivered
+// to the remote peer, which then reads io.EOF.
 func (c *Conn) Close() error {
-	c.untagHop()
-	return c.stream.Reset()
+	c.closeOnce.Do(func() {
+		c.untagHop()
+		c.closeErr = c.stream.Close()
+	})
+	return c.closeErr
+}
+
+// CloseWrite closes the connection for writing. The remote peer reads io.EOF
+// once it has read all data written before, and can still write to this side.
+func (c *Conn) CloseWrite() error {
+	return c.stream.CloseWrite()
+}
+
+// CloseRead closes the connection for reading. Data written to it by the
+// remote peer is discarded.
+func (c *Conn) CloseRead() error {
+	return c.stream.CloseRead()
 }
 
 func (c *Conn) Read(buf []byte) (int, error) {
+	if c.deadlineExceeded(&c.readDeadline) {
+		return 0, os.ErrDeadlineExceeded
+	}
 	return c.stream.Read(buf)
 }
 
 func (c *Conn) Write(buf []byte) (int, error) {
+	c.writeMx.Lock()
+	defer c.writeMx.Unlock()
+	if c.deadlineExceeded(&c.writeDeadline) {
+		return 0, os.ErrDeadlineExceeded
+	}
 	return c.stream.Write(buf)
 }
 
 func (c *Conn) SetDeadline(t time.Time) error {
+	c.deadlineMx.Lock()
+	c.readDeadline = t
+	c.writeDeadline = t
+	c.deadlineMx.Unlock()
 	return c.stream.SetDeadline(t)
 }
 
 func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.deadlineMx.Lock()
+	c.readDeadline = t
+	c.deadlineMx.Unlock()
 	return c.stream.SetReadDeadline(t)
 }
 
 func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.deadlineMx.Lock()
+	c.writeDeadline = t
+	c.deadlineMx.Unlock()
 	return c.stream.SetWriteDeadline(t)
 }
 
+func (c *Conn) deadlineExceeded(deadline *time.Time) bool {
+	c.deadlineMx.Lock()
+	defer c.deadlineMx.Unlock()
+	return !deadline.IsZero() && !time.Now().Before(*deadline)
+}
+
 // TODO: is it okay to cast c.Conn().RemotePeer() into a multiaddr? might be "user input"
 func (c *Conn) RemoteMultiaddr() ma.Multiaddr {
 	// TODO: We should be able to do this directly without converting to/from a string.
@@ -92,13 +151,32 @@ func (c *Conn) LocalMultiaddr() ma.Multiaddr {
 	return c.stream.Conn().LocalMultiaddr()
 }
 
+// LocalAddr returns the local address of the connection to the relay. Only the
+// IP and TCP / UDP part of that address is used, so that it can be converted
+// for any transport, e.g. QUIC or WebTransport. Connections to the relay over
+// transports that aren't IP based are identified by the relay and the local
+// peer instead.
 func (c *Conn) LocalAddr() net.Addr {
-	na, err := manet.ToNetAddr(c.stream.Conn().LocalMultiaddr())
-	if err != nil {
-		log.Error("failed to convert local multiaddr to net addr:", err)
-		return nil
+	if na, err := manet.ToNetAddr(thinWaist(c.stream.Conn().LocalMultiaddr())); err == nil {
+		return na
+	}
+	return &NetAddr{
+		Relay:  c.stream.Conn().RemotePeer().String(),
+		Remote: c.stream.Conn().LocalPeer().String(),
 	}
-	return na
+}
+
+// thinWaist returns the IP and TCP / UDP part of a.
+func thinWaist(a ma.Multiaddr) ma.Multiaddr {
+	var found bool
+	tw, _ := ma.SplitFunc(a, func(c ma.Component) bool {
+		if found {
+			return true
+		}
+		found = c.Protocol().Code == ma.P_TCP || c.Protocol().Code == ma.P_UDP
+		return false
+	})
+	return tw
 }
 
 func (c *Conn) RemoteAddr() net.Addr {
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/blank"
+	swarmt "github.com/libp2p/go-libp2p/p2p/net/swarm/testing"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	ttransport "github.com/libp2p/go-libp2p/p2p/transport/testsuite"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnSemantics(t *testing.T) {
+	hr := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	ha := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	hb := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	defer hr.Close()
+	defer ha.Close()
+	defer hb.Close()
+
+	r, err := relay.New(hr, relay.WithInfiniteLimits())
+	require.NoError(t, err)
+	defer r.Close()
+
+	rinfo := peer.AddrInfo{ID: hr.ID(), Addrs: hr.Addrs()}
+	require.NoError(t, ha.Connect(context.Background(), rinfo))
+	require.NoError(t, hb.Connect(context.Background(), rinfo))
+
+	ca, err := New(ha, nil)
+	require.NoError(t, err)
+	ca.Start()
+	defer ca.Close()
+	cb, err := New(hb, nil)
+	require.NoError(t, err)
+	defer cb.Close()
+
+	_, err = Reserve(context.Background(), ha, rinfo)
+	require.NoError(t, err)
+
+	// Dials that fail don't reach the listener, so a single goroutine
+	// accepting all connections keeps dialed and accepted connections in step.
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		for {
+			c, err := ca.Listener().Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+
+	raddr := ma.Join(hr.Addrs()[0], ma.StringCast("/p2p/"+hr.ID().String()+"/p2p-circuit"))
+	ttransport.SubtestConn(t, func() (c1, c2 net.Conn, stop func(), err error) {
+		dialed, err := cb.dial(context.Background(), raddr, ha.ID())
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		c1 = dialed
+		c2 = <-accepted
+		stop = func() {
+			c1.Close()
+			c2.Close()
+		}
+		return c1, c2, stop, nil
+	})
+}
+
+func TestThinWaist(t *testing.T) {
+	for in, out := range map[string]string{
+		"/ip4/1.2.3.4/tcp/1234":                    "/ip4/1.2.3.4/tcp/1234",
+		"/ip4/1.2.3.4/tcp/1234/ws":                 "/ip4/1.2.3.4/tcp/1234",
+		"/ip6/::1/udp/1234/quic-v1/webtransport":   "/ip6/::1/udp/1234",
+		"/dns4/example.com/udp/1234/webrtc-direct": "/dns4/example.com/udp/1234",
+		"/unix/tmp/relay.sock":                     "/unix/tmp/relay.sock",
+	} {
+		require.Equal(t, out, thinWaist(ma.StringCast(in)).String())
+	}
+}
@@ -118,7 +118,7 @@ func (c *Client) dialPeer(ctx context.Context, relay, dest peer.AddrInfo) (*Conn
 	log.Debugf("dialing peer %s through relay %s", dest.ID, relay.ID)
 
 	if len(relay.Addrs) > 0 {
-		c.host.Peerstore().AddAddrs(relay.ID, relay.Addrs, peerstore.TempAddrTTL)
+		peerstore.AddAddrsFromSource(c.host.Peerstore(), relay.ID, relay.Addrs, peerstore.TempAddrTTL, peerstore.AddrSourceRelay)
 	}
 
 	dialCtx, cancel := context.WithTimeout(ctx, DialRelayTimeout)
@@ -62,7 +62,7 @@ func (re ReservationError) Unwrap() error {
 // Clients must reserve slots in order for the relay to relay connections to them.
 func Reserve(ctx context.Context, h host.Host, ai peer.AddrInfo) (*Reservation, error) {
 	if len(ai.Addrs) > 0 {
-		h.Peerstore().AddAddrs(ai.ID, ai.Addrs, peerstore.TempAddrTTL)
+		peerstore.AddAddrsFromSource(h.Peerstore(), ai.ID, ai.Addrs, peerstore.TempAddrTTL, peerstore.AddrSourceRelay)
 	}
 
 	s, err := h.NewStream(ctx, ai.ID, proto.ProtoIDv2Hop)
@@ -147,6 +147,9 @@ func Reserve(ctx context.Context, h host.Host, ai peer.AddrInfo) (*Reservation,
 			}
 		}
 		result.Voucher = voucher
+		if voucher.Relay == ai.ID && voucher.Peer == h.ID() {
+			peerstore.AddAddrsFromSource(h.Peerstore(), ai.ID, result.Addrs, time.Until(result.Expiration), peerstore.AddrSourceRelayVoucher)
+		}
 	}
 
 	limit := msg.GetLimit()
@@ -4,3 +4,7 @@ const (
 	ProtoIDv2Hop  = "/libp2p/circuit/relay/0.2.0/hop"
 	ProtoIDv2Stop = "/libp2p/circuit/relay/0.2.0/stop"
 )
+
+// RelayNamespace is the discovery namespace that relays advertise themselves
+// under.
+const RelayNamespace = "/libp2p/relay"
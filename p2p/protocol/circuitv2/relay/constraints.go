@@ -5,6 +5,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/benbjohnson/clock"
 	asnutil "github.com/libp2p/go-libp2p-asn-util"
 	"github.com/libp2p/go-libp2p/core/peer"
 
@@ -23,7 +24,8 @@ var (
 
 // constraints implements various reservation constraints
 type constraints struct {
-	rc *Resources
+	rc    *Resources
+	clock clock.Clock
 
 	mutex sync.Mutex
 	total []time.Time
@@ -35,9 +37,10 @@ type constraints struct {
 // newConstraints creates a new constraints object.
 // The methods are *not* thread-safe; an external lock must be held if synchronization
 // is required.
-func newConstraints(rc *Resources) *constraints {
+func newConstraints(rc *Resources, cl clock.Clock) *constraints {
 	return &constraints{
 		rc:    rc,
+		clock: cl,
 		peers: make(map[peer.ID][]time.Time),
 		ips:   make(map[string][]time.Time),
 		asns:  make(map[uint32][]time.Time),
@@ -46,27 +49,32 @@ func newConstraints(rc *Resources) *constraints {
 
 // AddReservation adds a reservation for a given peer with a given multiaddr.
 // If adding this reservation violates IP constraints, an error is returned.
+// Reservations over transports that aren't IP based, like the memory
+// transport, are only subject to the total and per-peer limits.
 func (c *constraints) AddReservation(p peer.ID, a ma.Multiaddr) error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	now := time.Now()
+	now := c.clock.Now()
 	c.cleanup(now)
 
 	if len(c.total) >= c.rc.MaxReservations {
 		return errTooManyReservations
 	}
 
-	ip, err := manet.ToIP(a)
-	if err != nil {
-		return errors.New("no IP address associated with peer")
-	}
-
 	peerReservations := c.peers[p]
 	if len(peerReservations) >= c.rc.MaxReservationsPerPeer {
 		return errTooManyReservationsForPeer
 	}
 
+	ip, err := manet.ToIP(a)
+	if err != nil {
+		expiry := now.Add(validity)
+		c.total = append(c.total, expiry)
+		c.peers[p] = append(peerReservations, expiry)
+		return nil
+	}
+
 	ipReservations := c.ips[ip.String()]
 	if len(ipReservations) >= c.rc.MaxReservationsPerIP {
 		return errTooManyReservationsForIP
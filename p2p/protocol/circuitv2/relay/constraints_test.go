@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/libp2p/go-libp2p/core/test"
 
 	ma "github.com/multiformats/go-multiaddr"
@@ -38,7 +39,7 @@ func TestConstraints(t *testing.T) {
 	t.Run("total reservations", func(t *testing.T) {
 		res := infResources()
 		res.MaxReservations = limit
-		c := newConstraints(res)
+		c := newConstraints(res, clock.New())
 		for i := 0; i < limit; i++ {
 			if err := c.AddReservation(test.RandPeerIDFatal(t), randomIPv4Addr(t)); err != nil {
 				t.Fatal(err)
@@ -53,7 +54,7 @@ func TestConstraints(t *testing.T) {
 		p := test.RandPeerIDFatal(t)
 		res := infResources()
 		res.MaxReservationsPerPeer = limit
-		c := newConstraints(res)
+		c := newConstraints(res, clock.New())
 		for i := 0; i < limit; i++ {
 			if err := c.AddReservation(p, randomIPv4Addr(t)); err != nil {
 				t.Fatal(err)
@@ -71,7 +72,7 @@ func TestConstraints(t *testing.T) {
 		ip := randomIPv4Addr(t)
 		res := infResources()
 		res.MaxReservationsPerIP = limit
-		c := newConstraints(res)
+		c := newConstraints(res, clock.New())
 		for i := 0; i < limit; i++ {
 			if err := c.AddReservation(test.RandPeerIDFatal(t), ip); err != nil {
 				t.Fatal(err)
@@ -97,7 +98,7 @@ func TestConstraints(t *testing.T) {
 
 		res := infResources()
 		res.MaxReservationsPerASN = limit
-		c := newConstraints(res)
+		c := newConstraints(res, clock.New())
 		const ipv6Prefix = "2a03:2880:f003:c07:face:b00c::"
 		for i := 0; i < limit; i++ {
 			addr := getAddr(t, net.ParseIP(fmt.Sprintf("%s%d", ipv6Prefix, i+1)))
@@ -112,6 +113,22 @@ func TestConstraints(t *testing.T) {
 			t.Fatalf("expected reservation for different IP to be possible, got %v", err)
 		}
 	})
+
+	t.Run("addresses without IP", func(t *testing.T) {
+		addr := ma.StringCast("/unix/tmp/relay.sock")
+		res := infResources()
+		res.MaxReservationsPerIP = 1
+		res.MaxReservations = limit
+		c := newConstraints(res, clock.New())
+		for i := 0; i < limit; i++ {
+			if err := c.AddReservation(test.RandPeerIDFatal(t), addr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := c.AddReservation(test.RandPeerIDFatal(t), addr); err != errTooManyReservations {
+			t.Fatalf("expected to run into total reservation limit, got %v", err)
+		}
+	})
 }
 
 func TestConstraintsCleanup(t *testing.T) {
@@ -126,7 +143,8 @@ func TestConstraintsCleanup(t *testing.T) {
 		MaxReservationsPerIP:   math.MaxInt32,
 		MaxReservationsPerASN:  math.MaxInt32,
 	}
-	c := newConstraints(res)
+	cl := clock.NewMock()
+	c := newConstraints(res, cl)
 	for i := 0; i < limit; i++ {
 		if err := c.AddReservation(test.RandPeerIDFatal(t), randomIPv4Addr(t)); err != nil {
 			t.Fatal(err)
@@ -136,7 +154,7 @@ func TestConstraintsCleanup(t *testing.T) {
 		t.Fatalf("expected to run into total reservation limit, got %v", err)
 	}
 
-	time.Sleep(validity + time.Millisecond)
+	cl.Add(validity + time.Millisecond)
 	if err := c.AddReservation(test.RandPeerIDFatal(t), randomIPv4Addr(t)); err != nil {
 		t.Fatalf("expected old reservations to have been garbage collected, %v", err)
 	}
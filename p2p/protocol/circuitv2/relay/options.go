@@ -1,5 +1,7 @@
 package relay
 
+import "github.com/benbjohnson/clock"
+
 type Option func(*Relay) error
 
 // WithResources is a Relay option that sets specific relay resources for the relay.
@@ -34,6 +36,15 @@ func WithACL(acl ACLFilter) Option {
 	}
 }
 
+// WithClock is a Relay option that sets the clock used for the expiry of
+// reservations.
+func WithClock(cl clock.Clock) Option {
+	return func(r *Relay) error {
+		r.clock = cl
+		return nil
+	}
+}
+
 // WithMetricsTracer is a Relay option that supplies a MetricsTracer for metrics
 func WithMetricsTracer(mt MetricsTracer) Option {
 	return func(r *Relay) error {
@@ -9,6 +9,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -60,6 +61,8 @@ type Relay struct {
 	selfAddr ma.Multiaddr
 
 	metricsTracer MetricsTracer
+
+	clock clock.Clock
 }
 
 // New constructs a new limited relay that can provide relay services in the given host.
@@ -74,6 +77,7 @@ func New(h host.Host, opts ...Option) (*Relay, error) {
 		acl:    nil,
 		rsvp:   make(map[peer.ID]time.Time),
 		conns:  make(map[peer.ID]int),
+		clock:  clock.New(),
 	}
 
 	for _, opt := range opts {
@@ -94,7 +98,7 @@ func New(h host.Host, opts ...Option) (*Relay, error) {
 		return nil, err
 	}
 
-	r.constraints = newConstraints(&r.rc)
+	r.constraints = newConstraints(&r.rc, r.clock)
 	r.selfAddr = ma.StringCast(fmt.Sprintf("/p2p/%s", h.ID()))
 
 	h.SetStreamHandler(proto.ProtoIDv2Hop, r.handleStream)
@@ -201,7 +205,7 @@ func (r *Relay) handleReserve(s network.Stream) pbv2.Status {
 		r.handleError(s, pbv2.Status_PERMISSION_DENIED)
 		return pbv2.Status_PERMISSION_DENIED
 	}
-	now := time.Now()
+	now := r.clock.Now()
 
 	_, exists := r.rsvp[p]
 	if !exists {
@@ -623,7 +627,7 @@ func (r *Relay) makeLimitMsg(p peer.ID) *pbv2.Limit {
 }
 
 func (r *Relay) background() {
-	ticker := time.NewTicker(time.Minute)
+	ticker := r.clock.Ticker(time.Minute)
 	defer ticker.Stop()
 
 	for {
@@ -640,7 +644,7 @@ func (r *Relay) gc() {
 	r.mx.Lock()
 	defer r.mx.Unlock()
 
-	now := time.Now()
+	now := r.clock.Now()
 	cnt := 0
 	for p, expire := range r.rsvp {
 		if r.closed || expire.Before(now) {
@@ -22,12 +22,19 @@ import (
 	swarmt "github.com/libp2p/go-libp2p/p2p/net/swarm/testing"
 	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
 	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	"github.com/libp2p/go-libp2p/p2p/transport/memory"
 	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
 
 	ma "github.com/multiformats/go-multiaddr"
 )
 
 func getNetHosts(t *testing.T, ctx context.Context, n int) (hosts []host.Host, upgraders []transport.Upgrader) {
+	return getNetHostsWithTransport(t, n, ma.StringCast("/ip4/127.0.0.1/tcp/0"), func(u transport.Upgrader) (transport.Transport, error) {
+		return tcp.NewTCPTransport(u, nil)
+	})
+}
+
+func getNetHostsWithTransport(t *testing.T, n int, laddr ma.Multiaddr, newTransport func(transport.Upgrader) (transport.Transport, error)) (hosts []host.Host, upgraders []transport.Upgrader) {
 	for i := 0; i < n; i++ {
 		privk, pubk, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
 		if err != nil {
@@ -57,7 +64,7 @@ func getNetHosts(t *testing.T, ctx context.Context, n int) (hosts []host.Host, u
 		upgrader := swarmt.GenUpgrader(t, netw, nil)
 		upgraders = append(upgraders, upgrader)
 
-		tpt, err := tcp.NewTCPTransport(upgrader, nil)
+		tpt, err := newTransport(upgrader)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -65,7 +72,7 @@ func getNetHosts(t *testing.T, ctx context.Context, n int) (hosts []host.Host, u
 			t.Fatal(err)
 		}
 
-		err = netw.Listen(ma.StringCast("/ip4/127.0.0.1/tcp/0"))
+		err = netw.Listen(laddr)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -179,6 +186,67 @@ func TestBasicRelay(t *testing.T) {
 	}
 }
 
+// TestRelayOverMemoryTransport checks that circuits work over transports that
+// aren't IP based, which aren't subject to the IP reservation constraints.
+func TestRelayOverMemoryTransport(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts, upgraders := getNetHostsWithTransport(t, 3, ma.StringCast("/memory/0"), func(u transport.Upgrader) (transport.Transport, error) {
+		return memory.NewMemoryTransport(u, nil)
+	})
+	addTransport(t, hosts[0], upgraders[0])
+	addTransport(t, hosts[2], upgraders[2])
+
+	hosts[0].SetStreamHandler("test", func(s network.Stream) {
+		defer s.Close()
+		io.Copy(s, s)
+	})
+
+	r, err := relay.New(hosts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	connect(t, hosts[0], hosts[1])
+
+	rinfo := hosts[1].Peerstore().PeerInfo(hosts[1].ID())
+	if _, err := client.Reserve(ctx, hosts[0], rinfo); err != nil {
+		t.Fatal(err)
+	}
+
+	raddr := hosts[1].Addrs()[0].Encapsulate(ma.StringCast(fmt.Sprintf("/p2p/%s/p2p-circuit/p2p/%s", hosts[1].ID(), hosts[0].ID())))
+	if err := hosts[2].Connect(ctx, peer.AddrInfo{ID: hosts[0].ID(), Addrs: []ma.Multiaddr{raddr}}); err != nil {
+		t.Fatal(err)
+	}
+
+	conns := hosts[2].Network().ConnsToPeer(hosts[0].ID())
+	if len(conns) != 1 {
+		t.Fatalf("expected 1 connection, but got %d", len(conns))
+	}
+	if conns[0].RemoteMultiaddr().String() != raddr.Decapsulate(ma.StringCast("/p2p/"+hosts[0].ID().String())).String() {
+		t.Fatalf("unexpected remote address %s", conns[0].RemoteMultiaddr())
+	}
+
+	s, err := hosts[2].NewStream(network.WithUseTransient(ctx, "test"), hosts[0].ID(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("relay works!")
+	if _, err := s.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	s.CloseWrite()
+	got, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg, got) {
+		t.Fatalf("Wrong echo; expected %s but got %s", string(msg), string(got))
+	}
+}
+
 func TestRelayLimitTime(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
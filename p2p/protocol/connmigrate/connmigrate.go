@@ -0,0 +1,429 @@
+// Package connmigrate implements a protocol to migrate a peer connection from
+// TCP to QUIC.
+//
+// When a host dials a peer over TCP, and both peers run the Service, the
+// dialing side opens a connmigrate stream on the TCP connection. The remote
+// peer responds with the QUIC addresses it is willing to migrate to. The
+// dialing side then dials one of these addresses, and reports the result.
+// If the QUIC connection was established, both sides mark the TCP connection
+// as draining: new streams are opened on the QUIC connection, and the TCP
+// connection is closed once all its streams have been closed (or after the
+// drain timeout).
+//
+// The protocol is not enabled by default; create a Service to handle it.
+package connmigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	"github.com/libp2p/go-msgio"
+
+	ma "github.com/multiformats/go-multiaddr"
+	msmux "github.com/multiformats/go-multistream"
+)
+
+var log = logging.Logger("connmigrate")
+
+const (
+	// ID is the protocol ID of the connection migration protocol.
+	ID protocol.ID = "/libp2p/connmigrate/1.0.0"
+
+	ServiceName = "libp2p.connmigrate"
+
+	// maxAddrs is the maximum number of addresses a peer may offer.
+	maxAddrs = 16
+	// maxMsgSize is the maximum size of a single address.
+	maxMsgSize = 1024
+
+	streamTimeout = time.Minute
+
+	resultSuccess byte = 1
+	resultFailure byte = 0
+)
+
+const (
+	defaultDrainTimeout  = time.Minute
+	defaultRetryInterval = 10 * time.Minute
+	drainPollInterval    = 100 * time.Millisecond
+)
+
+// ErrNoQUICAddrs is returned when the remote peer didn't offer any QUIC address.
+var ErrNoQUICAddrs = errors.New("peer didn't offer any QUIC address")
+
+type Option func(*Service) error
+
+// WithDrainTimeout sets the maximum duration a migrated TCP connection is kept
+// open while it still has streams. After the timeout, the connection is closed,
+// resetting the remaining streams.
+func WithDrainTimeout(d time.Duration) Option {
+	return func(s *Service) error {
+		if d <= 0 {
+			return errors.New("drain timeout must be positive")
+		}
+		s.drainTimeout = d
+		return nil
+	}
+}
+
+// WithRetryInterval sets the minimum duration between two migration attempts
+// to the same peer.
+func WithRetryInterval(d time.Duration) Option {
+	return func(s *Service) error {
+		s.retryInterval = d
+		return nil
+	}
+}
+
+// Service migrates outbound TCP connections to QUIC, and handles migration
+// requests from other peers.
+type Service struct {
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+
+	host host.Host
+
+	drainTimeout  time.Duration
+	retryInterval time.Duration
+
+	mx sync.Mutex
+	// attempts holds the time of the last failed (or ongoing) migration
+	// attempt per connected peer.
+	attempts map[peer.ID]time.Time
+
+	refCount sync.WaitGroup
+}
+
+// NewService creates a new Service, and registers the stream handler.
+func NewService(h host.Host, opts ...Option) (*Service, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Service{
+		ctx:           ctx,
+		ctxCancel:     cancel,
+		host:          h,
+		drainTimeout:  defaultDrainTimeout,
+		retryInterval: defaultRetryInterval,
+		attempts:      make(map[peer.ID]time.Time),
+	}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	sub, err := h.EventBus().Subscribe([]interface{}{
+		new(event.EvtPeerIdentificationCompleted),
+		new(event.EvtPeerConnectednessChanged),
+	}, eventbus.Name("connmigrate"))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	h.SetStreamHandler(ID, s.handleNewStream)
+
+	s.refCount.Add(1)
+	go s.background(sub)
+	return s, nil
+}
+
+// Close removes the stream handler, and stops all migrations in progress.
+// Connections that are already draining are not closed.
+func (s *Service) Close() error {
+	s.host.RemoveStreamHandler(ID)
+	s.ctxCancel()
+	s.refCount.Wait()
+	return nil
+}
+
+func (s *Service) background(sub event.Subscription) {
+	defer s.refCount.Done()
+	defer sub.Close()
+
+	for {
+		select {
+		case e, ok := <-sub.Out():
+			if !ok {
+				return
+			}
+			if evt, ok := e.(event.EvtPeerConnectednessChanged); ok {
+				if evt.Connectedness == network.NotConnected {
+					s.forgetAttempt(evt.Peer)
+				}
+				continue
+			}
+			evt := e.(event.EvtPeerIdentificationCompleted)
+			if !s.shouldMigrate(evt) {
+				continue
+			}
+			s.refCount.Add(1)
+			go func() {
+				defer s.refCount.Done()
+				if err := s.migrate(evt.Conn); err != nil {
+					log.Debugw("failed to migrate connection", "peer", evt.Peer, "addr", evt.Conn.RemoteMultiaddr(), "error", err)
+				}
+			}()
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Service) shouldMigrate(evt event.EvtPeerIdentificationCompleted) bool {
+	c := evt.Conn
+	if c.Stat().Direction != network.DirOutbound || c.Stat().Transient || !isTCP(c.RemoteMultiaddr()) {
+		return false
+	}
+	if !supportsProtocol(evt.Protocols, ID) {
+		return false
+	}
+	for _, other := range s.host.Network().ConnsToPeer(evt.Peer) {
+		if isQUIC(other.RemoteMultiaddr()) {
+			return false
+		}
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if last, ok := s.attempts[evt.Peer]; ok && time.Since(last) < s.retryInterval {
+		return false
+	}
+	s.attempts[evt.Peer] = time.Now()
+	return true
+}
+
+// forgetAttempt removes the migration attempt to p, once p migrated or
+// disconnected.
+func (s *Service) forgetAttempt(p peer.ID) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	delete(s.attempts, p)
+}
+
+// migrate runs the initiator side of the protocol on the TCP connection c.
+func (s *Service) migrate(c network.Conn) error {
+	str, err := c.NewStream(s.ctx)
+	if err != nil {
+		return err
+	}
+	defer str.Close()
+	str.SetDeadline(time.Now().Add(streamTimeout))
+
+	if err := str.SetProtocol(ID); err != nil {
+		str.Reset()
+		return err
+	}
+	if err := str.Scope().SetService(ServiceName); err != nil {
+		str.Reset()
+		return err
+	}
+	if err := msmux.SelectProtoOrFail(ID, str); err != nil {
+		str.Reset()
+		return err
+	}
+
+	addrs, err := readAddrs(str)
+	if err != nil {
+		str.Reset()
+		return err
+	}
+	if len(addrs) == 0 {
+		str.Write([]byte{resultFailure})
+		return ErrNoQUICAddrs
+	}
+
+	p := c.RemotePeer()
+	s.host.Peerstore().AddAddrs(p, addrs, peerstore.TempAddrTTL)
+	ctx, cancel := context.WithTimeout(s.ctx, streamTimeout)
+	defer cancel()
+	ctx = network.WithDialAddrFilter(ctx, isQUIC, "connmigrate")
+	qc, err := s.host.Network().DialPeer(ctx, p)
+	if err == nil && !isQUIC(qc.RemoteMultiaddr()) {
+		err = fmt.Errorf("unexpected connection to %s", qc.RemoteMultiaddr())
+	}
+	if err != nil {
+		str.Write([]byte{resultFailure})
+		return err
+	}
+	if _, err := str.Write([]byte{resultSuccess}); err != nil {
+		str.Reset()
+		return err
+	}
+	str.Close()
+	s.forgetAttempt(p)
+
+	log.Debugw("migrated connection", "peer", p, "from", c.RemoteMultiaddr(), "to", qc.RemoteMultiaddr())
+	s.drain(c)
+	return nil
+}
+
+// handleNewStream runs the responder side of the protocol.
+func (s *Service) handleNewStream(str network.Stream) {
+	if err := str.Scope().SetService(ServiceName); err != nil {
+		log.Debugf("error attaching stream to connmigrate service: %s", err)
+		str.Reset()
+		return
+	}
+	str.SetDeadline(time.Now().Add(streamTimeout))
+
+	if err := writeAddrs(str, s.quicAddrs()); err != nil {
+		log.Debugf("error writing addresses: %s", err)
+		str.Reset()
+		return
+	}
+	if err := str.CloseWrite(); err != nil {
+		str.Reset()
+		return
+	}
+
+	result := make([]byte, 1)
+	if _, err := io.ReadFull(str, result); err != nil {
+		log.Debugf("error reading migration result: %s", err)
+		str.Reset()
+		return
+	}
+	str.Close()
+	if result[0] != resultSuccess {
+		return
+	}
+
+	c := str.Conn()
+	// Only drain the connection if the peer actually established a QUIC connection.
+	for _, other := range s.host.Network().ConnsToPeer(c.RemotePeer()) {
+		if isQUIC(other.RemoteMultiaddr()) {
+			s.refCount.Add(1)
+			go func() {
+				defer s.refCount.Done()
+				s.drain(c)
+			}()
+			return
+		}
+	}
+}
+
+// drain marks c as draining, and closes it once it doesn't have any streams left.
+func (s *Service) drain(c network.Conn) {
+	d, ok := c.(interface{ StartDraining() })
+	if !ok {
+		log.Debugf("connection to %s doesn't support draining", c.RemotePeer())
+		return
+	}
+	d.StartDraining()
+
+	timer := time.NewTimer(s.drainTimeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for len(c.GetStreams()) > 0 {
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			log.Debugw("drain timeout, closing connection", "peer", c.RemotePeer(), "streams", len(c.GetStreams()))
+			c.Close()
+			return
+		case <-s.ctx.Done():
+			return
+		}
+	}
+	c.Close()
+}
+
+// quicAddrs returns our QUIC listen addresses.
+func (s *Service) quicAddrs() []ma.Multiaddr {
+	addrs := ma.FilterAddrs(s.host.Addrs(), isQUIC)
+	if len(addrs) > maxAddrs {
+		addrs = addrs[:maxAddrs]
+	}
+	return addrs
+}
+
+func writeAddrs(w io.Writer, addrs []ma.Multiaddr) error {
+	mw := msgio.NewVarintWriter(w)
+	for _, a := range addrs {
+		if err := mw.WriteMsg(a.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readAddrs reads the QUIC addresses offered by the peer until EOF.
+// Addresses that are not QUIC addresses are ignored.
+func readAddrs(r io.Reader) ([]ma.Multiaddr, error) {
+	mr := msgio.NewVarintReaderSize(r, maxMsgSize)
+	var addrs []ma.Multiaddr
+	for i := 0; ; i++ {
+		msg, err := mr.ReadMsg()
+		if err == io.EOF {
+			return addrs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if i >= maxAddrs {
+			mr.ReleaseMsg(msg)
+			return nil, errors.New("peer offered too many addresses")
+		}
+		a, err := ma.NewMultiaddrBytes(msg)
+		mr.ReleaseMsg(msg)
+		if err != nil {
+			log.Debugf("invalid address: %s", err)
+			continue
+		}
+		if isQUIC(a) {
+			addrs = append(addrs, a)
+		}
+	}
+}
+
+func isTCP(a ma.Multiaddr) bool {
+	isTCP := false
+	ma.ForEach(a, func(c ma.Component) bool {
+		switch c.Protocol().Code {
+		case ma.P_TCP:
+			isTCP = true
+		case ma.P_CIRCUIT:
+			isTCP = false
+			return false
+		}
+		return true
+	})
+	return isTCP
+}
+
+// isQUIC returns true for direct QUIC v1 addresses, excluding WebTransport.
+func isQUIC(a ma.Multiaddr) bool {
+	isQUIC := false
+	ma.ForEach(a, func(c ma.Component) bool {
+		switch c.Protocol().Code {
+		case ma.P_QUIC_V1:
+			isQUIC = true
+		case ma.P_WEBTRANSPORT, ma.P_WEBRTC_DIRECT, ma.P_CIRCUIT:
+			isQUIC = false
+			return false
+		}
+		return true
+	})
+	return isQUIC
+}
+
+func supportsProtocol(protos []protocol.ID, p protocol.ID) bool {
+	for _, proto := range protos {
+		if proto == p {
+			return true
+		}
+	}
+	return false
+}
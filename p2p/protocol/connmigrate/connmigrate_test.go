@@ -0,0 +1,138 @@
+package connmigrate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func newHost(t *testing.T, opts ...libp2p.Option) host.Host {
+	t.Helper()
+	h, err := libp2p.New(opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func newTCPAndQUICHost(t *testing.T) host.Host {
+	return newHost(t, libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0", "/ip4/127.0.0.1/udp/0/quic-v1"))
+}
+
+func newTCPHost(t *testing.T) host.Host {
+	return newHost(t, libp2p.Transport(tcp.NewTCPTransport), libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+}
+
+func newService(t *testing.T, h host.Host, opts ...Option) *Service {
+	t.Helper()
+	s, err := NewService(h, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// connectTCP connects h1 to h2, using only h2's TCP addresses.
+func connectTCP(t *testing.T, h1, h2 host.Host) {
+	t.Helper()
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{
+		ID:    h2.ID(),
+		Addrs: ma.FilterAddrs(h2.Addrs(), isTCP),
+	}))
+	conns := h1.Network().ConnsToPeer(h2.ID())
+	require.Len(t, conns, 1)
+	require.True(t, isTCP(conns[0].RemoteMultiaddr()))
+}
+
+func onlyQUICConns(h1, h2 host.Host) bool {
+	conns := h1.Network().ConnsToPeer(h2.ID())
+	return len(conns) == 1 && isQUIC(conns[0].RemoteMultiaddr())
+}
+
+func TestMigrate(t *testing.T) {
+	h1 := newTCPAndQUICHost(t)
+	h2 := newTCPAndQUICHost(t)
+	newService(t, h1)
+	newService(t, h2)
+
+	connectTCP(t, h1, h2)
+	require.Eventually(t, func() bool { return onlyQUICConns(h1, h2) && onlyQUICConns(h2, h1) }, 10*time.Second, 50*time.Millisecond)
+}
+
+func TestNoMigrationWithoutQUICAddrs(t *testing.T) {
+	h1 := newTCPAndQUICHost(t)
+	h2 := newTCPHost(t)
+	newService(t, h1)
+	newService(t, h2)
+
+	connectTCP(t, h1, h2)
+	require.Never(t, func() bool {
+		conns := h1.Network().ConnsToPeer(h2.ID())
+		return len(conns) != 1 || !isTCP(conns[0].RemoteMultiaddr())
+	}, 500*time.Millisecond, 50*time.Millisecond)
+}
+
+func TestForgetAttemptsOnDisconnect(t *testing.T) {
+	h1 := newTCPHost(t)
+	h2 := newTCPHost(t)
+	s := newService(t, h1)
+	newService(t, h2)
+
+	hasAttempt := func() bool {
+		s.mx.Lock()
+		defer s.mx.Unlock()
+		_, ok := s.attempts[h2.ID()]
+		return ok
+	}
+	connectTCP(t, h1, h2)
+	require.Eventually(t, hasAttempt, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, h1.Network().ClosePeer(h2.ID()))
+	require.Eventually(t, func() bool { return !hasAttempt() }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestDrain(t *testing.T) {
+	h1 := newTCPHost(t)
+	h2 := newTCPHost(t)
+	h2.SetStreamHandler("/test", func(s network.Stream) {})
+
+	t.Run("waits for streams", func(t *testing.T) {
+		connectTCP(t, h1, h2)
+		str, err := h1.NewStream(context.Background(), h2.ID(), "/test")
+		require.NoError(t, err)
+		c := str.Conn()
+
+		s := newService(t, h1, WithDrainTimeout(time.Hour))
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			s.drain(c)
+		}()
+		require.Never(t, c.IsClosed, 300*time.Millisecond, 50*time.Millisecond)
+		str.Close()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("drain didn't complete")
+		}
+		require.True(t, c.IsClosed())
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		connectTCP(t, h1, h2)
+		str, err := h1.NewStream(context.Background(), h2.ID(), "/test")
+		require.NoError(t, err)
+		defer str.Close()
+		c := str.Conn()
+
+		s := newService(t, h1, WithDrainTimeout(100*time.Millisecond))
+		s.drain(c)
+		require.True(t, c.IsClosed())
+	})
+}
@@ -0,0 +1,160 @@
+// Package echo implements a simple echo protocol: the server writes back
+// everything it reads on a stream, until the client closes its write side.
+//
+// This is useful to validate stream correctness end-to-end, for example when
+// debugging middleboxes or new transports. The protocol is not enabled by
+// default; create an EchoService to handle it.
+package echo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	pool "github.com/libp2p/go-buffer-pool"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var log = logging.Logger("echo")
+
+const (
+	ID = "/echo/1.0.0"
+
+	ServiceName = "libp2p.echo"
+
+	bufSize = 4096
+	// MaxSize is the maximum number of bytes the EchoService echoes on a single stream.
+	MaxSize = 16 << 20 // 16 MiB
+	// streamTimeout is the maximum time the EchoService waits for reading or writing data on a stream.
+	streamTimeout = time.Minute
+)
+
+type EchoService struct {
+	Host host.Host
+}
+
+// NewEchoService creates a new EchoService, and registers the stream handler.
+func NewEchoService(h host.Host) *EchoService {
+	es := &EchoService{h}
+	h.SetStreamHandler(ID, es.EchoHandler)
+	return es
+}
+
+// Close removes the stream handler.
+func (es *EchoService) Close() error {
+	es.Host.RemoveStreamHandler(ID)
+	return nil
+}
+
+func (es *EchoService) EchoHandler(s network.Stream) {
+	if err := s.Scope().SetService(ServiceName); err != nil {
+		log.Debugf("error attaching stream to echo service: %s", err)
+		s.Reset()
+		return
+	}
+
+	if err := s.Scope().ReserveMemory(bufSize, network.ReservationPriorityAlways); err != nil {
+		log.Debugf("error reserving memory for echo stream: %s", err)
+		s.Reset()
+		return
+	}
+	defer s.Scope().ReleaseMemory(bufSize)
+
+	buf := pool.Get(bufSize)
+	defer pool.Put(buf)
+
+	var total int
+	for {
+		s.SetDeadline(time.Now().Add(streamTimeout))
+		n, err := s.Read(buf)
+		if n > 0 {
+			total += n
+			if total > MaxSize {
+				log.Debugf("peer %s exceeded the echo size limit", s.Conn().RemotePeer())
+				s.Reset()
+				return
+			}
+			if _, err := s.Write(buf[:n]); err != nil {
+				log.Debugf("error writing echo: %s", err)
+				s.Reset()
+				return
+			}
+		}
+		if err == io.EOF {
+			s.Close()
+			return
+		}
+		if err != nil {
+			log.Debugf("error reading from echo stream: %s", err)
+			s.Reset()
+			return
+		}
+	}
+}
+
+func (es *EchoService) Echo(ctx context.Context, p peer.ID, data []byte) (time.Duration, error) {
+	return Echo(ctx, es.Host, p, data)
+}
+
+// Echo sends data to the remote peer, and verifies that it is echoed back
+// unchanged. It returns the time it took until the last byte was received.
+func Echo(ctx context.Context, h host.Host, p peer.ID, data []byte) (time.Duration, error) {
+	if len(data) > MaxSize {
+		return 0, fmt.Errorf("echo data too large: %d bytes (max: %d)", len(data), MaxSize)
+	}
+	s, err := h.NewStream(network.WithUseTransient(ctx, "echo"), p, ID)
+	if err != nil {
+		return 0, err
+	}
+	defer s.Close()
+
+	if err := s.Scope().SetService(ServiceName); err != nil {
+		log.Debugf("error attaching stream to echo service: %s", err)
+		s.Reset()
+		return 0, err
+	}
+	if err := s.Scope().ReserveMemory(len(data), network.ReservationPriorityAlways); err != nil {
+		log.Debugf("error reserving memory for echo stream: %s", err)
+		s.Reset()
+		return 0, err
+	}
+	defer s.Scope().ReleaseMemory(len(data))
+
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { s.Reset() })
+	defer stop()
+
+	start := time.Now()
+	// Write concurrently, so we don't deadlock once the flow control windows are full.
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := s.Write(data)
+		if err == nil {
+			err = s.CloseWrite()
+		}
+		writeErr <- err
+	}()
+
+	resp := make([]byte, len(data))
+	if _, err := io.ReadFull(s, resp); err != nil {
+		s.Reset()
+		<-writeErr
+		return 0, fmt.Errorf("failed to read echo: %w", err)
+	}
+	rtt := time.Since(start)
+	if err := <-writeErr; err != nil {
+		return 0, fmt.Errorf("failed to write echo: %w", err)
+	}
+	if !bytes.Equal(data, resp) {
+		return 0, errors.New("echo response didn't match")
+	}
+	return rtt, nil
+}
@@ -0,0 +1,42 @@
+package echo
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	swarmt "github.com/libp2p/go-libp2p/p2p/net/swarm/testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEcho(t *testing.T) {
+	h1, err := bhost.NewHost(swarmt.GenSwarm(t), nil)
+	require.NoError(t, err)
+	defer h1.Close()
+	h1.Start()
+	h2, err := bhost.NewHost(swarmt.GenSwarm(t), nil)
+	require.NoError(t, err)
+	defer h2.Close()
+	h2.Start()
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+
+	es := NewEchoService(h2)
+	defer es.Close()
+
+	for _, size := range []int{0, 1, 1000, 1 << 20} {
+		data := make([]byte, size)
+		rand.Read(data)
+		_, err := Echo(context.Background(), h1, h2.ID(), data)
+		require.NoError(t, err)
+	}
+
+	_, err = Echo(context.Background(), h1, h2.ID(), make([]byte, MaxSize+1))
+	require.Error(t, err)
+
+	es.Close()
+	_, err = Echo(context.Background(), h1, h2.ID(), []byte("foobar"))
+	require.Error(t, err)
+}
@@ -79,6 +79,14 @@ func (s *mockIDService) OwnObservedAddrs() []ma.Multiaddr {
 	return append(s.IDService.OwnObservedAddrs(), ma.StringCast("/ip4/1.1.1.1/tcp/1234"))
 }
 
+type symmetricNATIDService struct {
+	identify.IDService
+}
+
+func (s *symmetricNATIDService) OwnNATDeviceType(network.NATTransportProtocol) network.NATDeviceType {
+	return network.NATDeviceTypeSymmetric
+}
+
 func TestNoHolePunchIfDirectConnExists(t *testing.T) {
 	tr := &mockEventTracer{}
 	h1, hps := mkHostWithHolePunchSvc(t, holepunch.WithTracer(tr))
@@ -250,6 +258,32 @@ func TestFailuresOnInitiator(t *testing.T) {
 	}
 }
 
+func TestNoHolePunchBehindSymmetricNAT(t *testing.T) {
+	tr := &mockEventTracer{}
+	h1, h2, relay, _ := makeRelayedHosts(t, nil, nil, false)
+	defer h1.Close()
+	defer h2.Close()
+	defer relay.Close()
+
+	ids := &symmetricNATIDService{IDService: newMockIDService(t, h2)}
+	hps, err := holepunch.NewService(h2, ids, holepunch.WithTracer(tr))
+	require.NoError(t, err)
+	defer hps.Close()
+
+	require.ErrorIs(t, hps.DirectConnect(h1.ID()), holepunch.ErrSymmetricNAT)
+	require.Empty(t, tr.getEvents())
+	require.Nil(t, getDirectConn(h2, h1.ID()))
+}
+
+func getDirectConn(h host.Host, p peer.ID) network.Conn {
+	for _, c := range h.Network().ConnsToPeer(p) {
+		if _, err := c.RemoteMultiaddr().ValueForProtocol(ma.P_CIRCUIT); err != nil {
+			return c
+		}
+	}
+	return nil
+}
+
 func addrsToBytes(as []ma.Multiaddr) [][]byte {
 	bzs := make([][]byte, 0, len(as))
 	for _, a := range as {
@@ -22,6 +22,10 @@ import (
 // ErrHolePunchActive is returned from DirectConnect when another hole punching attempt is currently running
 var ErrHolePunchActive = errors.New("another hole punching attempt to this peer is active")
 
+// ErrSymmetricNAT is returned from DirectConnect when we're behind a Symmetric NAT,
+// and a hole punch would be futile. The relayed connection should be used instead.
+var ErrSymmetricNAT = errors.New("not hole punching from behind a symmetric NAT")
+
 const (
 	dialTimeout = 5 * time.Second
 	maxRetries  = 3
@@ -130,6 +134,11 @@ func (hp *holePuncher) directConnect(rp peer.ID) error {
 
 	log.Debugw("got inbound proxy conn", "peer", rp)
 
+	if isBehindSymmetricNAT(hp.ids) {
+		log.Debugw("behind a symmetric NAT, keeping the relayed connection", "peer", rp)
+		return ErrSymmetricNAT
+	}
+
 	// hole punch
 	for i := 1; i <= maxRetries; i++ {
 		addrs, obsAddrs, rtt, err := hp.initiateHolePunch(rp)
@@ -208,13 +217,17 @@ func (hp *holePuncher) initiateHolePunchImpl(str network.Stream) ([]ma.Multiaddr
 	str.SetDeadline(time.Now().Add(StreamTimeout))
 
 	// send a CONNECT and start RTT measurement.
-	obsAddrs := removeRelayAddrs(hp.ids.OwnObservedAddrs())
+	obsAddrs := holePunchableAddrs(hp.host.Network(), hp.ids.OwnObservedAddrs())
 	if hp.filter != nil {
 		obsAddrs = hp.filter.FilterLocal(str.Conn().RemotePeer(), obsAddrs)
 	}
 	if len(obsAddrs) == 0 {
 		return nil, nil, 0, errors.New("aborting hole punch initiation as we have no public address")
 	}
+	obsAddrs = removeSymmetricNATAddrs(hp.ids, obsAddrs)
+	if len(obsAddrs) == 0 {
+		return nil, nil, 0, ErrSymmetricNAT
+	}
 
 	start := time.Now()
 	if err := w.WriteMsg(&pb.HolePunch{
@@ -235,7 +248,7 @@ func (hp *holePuncher) initiateHolePunchImpl(str network.Stream) ([]ma.Multiaddr
 		return nil, nil, 0, fmt.Errorf("expect CONNECT message, got %s", t)
 	}
 
-	addrs := removeRelayAddrs(addrsFromBytes(msg.ObsAddrs))
+	addrs := removeSymmetricNATAddrs(hp.ids, holePunchableAddrs(hp.host.Network(), addrsFromBytes(msg.ObsAddrs)))
 	if hp.filter != nil {
 		addrs = hp.filter.FilterRemote(str.Conn().RemotePeer(), addrs)
 	}
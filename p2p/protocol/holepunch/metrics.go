@@ -9,6 +9,12 @@ import (
 
 const metricNamespace = "libp2p_holepunch"
 
+var (
+	directDialLabels     = []string{"outcome"}
+	addressOutcomeLabels = []string{"side", "num_attempts", "ipv", "transport", "outcome"}
+	outcomeLabels        = []string{"side", "num_attempts", "outcome"}
+)
+
 var (
 	directDialsTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -16,7 +22,7 @@ var (
 			Name:      "direct_dials_total",
 			Help:      "Direct Dials Total",
 		},
-		[]string{"outcome"},
+		directDialLabels,
 	)
 	hpAddressOutcomesTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -24,7 +30,7 @@ var (
 			Name:      "address_outcomes_total",
 			Help:      "Hole Punch outcomes by Transport",
 		},
-		[]string{"side", "num_attempts", "ipv", "transport", "outcome"},
+		addressOutcomeLabels,
 	)
 	hpOutcomesTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -32,7 +38,7 @@ var (
 			Name:      "outcomes_total",
 			Help:      "Hole Punch outcomes overall",
 		},
-		[]string{"side", "num_attempts", "outcome"},
+		outcomeLabels,
 	)
 
 	collectors = []prometheus.Collector{
@@ -47,12 +53,15 @@ type MetricsTracer interface {
 	DirectDialFinished(success bool)
 }
 
-type metricsTracer struct{}
+type metricsTracer struct {
+	labels metricshelper.LabelFilter
+}
 
 var _ MetricsTracer = &metricsTracer{}
 
 type metricsTracerSetting struct {
-	reg prometheus.Registerer
+	reg            prometheus.Registerer
+	disabledLabels []string
 }
 
 type MetricsTracerOption func(*metricsTracerSetting)
@@ -65,30 +74,43 @@ func WithRegisterer(reg prometheus.Registerer) MetricsTracerOption {
 	}
 }
 
+// WithDisabledLabels drops the given labels (e.g. "transport" or "ipv") from
+// all metrics, to limit their cardinality. See metricshelper.LabelFilter.
+func WithDisabledLabels(labels ...string) MetricsTracerOption {
+	return func(s *metricsTracerSetting) {
+		s.disabledLabels = append(s.disabledLabels, labels...)
+	}
+}
+
 func NewMetricsTracer(opts ...MetricsTracerOption) MetricsTracer {
 	setting := &metricsTracerSetting{reg: prometheus.DefaultRegisterer}
 	for _, opt := range opts {
 		opt(setting)
 	}
 	metricshelper.RegisterCollectors(setting.reg, collectors...)
+	labels := metricshelper.NewLabelFilter(setting.disabledLabels...)
 	// initialise metrics's labels so that the first data point is handled correctly
 	for _, side := range []string{"initiator", "receiver"} {
 		for _, numAttempts := range []string{"1", "2", "3", "4"} {
 			for _, outcome := range []string{"success", "failed", "cancelled", "no_suitable_address"} {
 				for _, ipv := range []string{"ip4", "ip6"} {
 					for _, transport := range []string{"quic", "quic-v1", "tcp", "webtransport"} {
-						hpAddressOutcomesTotal.WithLabelValues(side, numAttempts, ipv, transport, outcome)
+						values := []string{side, numAttempts, ipv, transport, outcome}
+						labels.Apply(addressOutcomeLabels, values)
+						hpAddressOutcomesTotal.WithLabelValues(values...)
 					}
 				}
 				if outcome == "cancelled" {
 					// not a valid outcome for the overall holepunch metric
 					continue
 				}
-				hpOutcomesTotal.WithLabelValues(side, numAttempts, outcome)
+				values := []string{side, numAttempts, outcome}
+				labels.Apply(outcomeLabels, values)
+				hpOutcomesTotal.WithLabelValues(values...)
 			}
 		}
 	}
-	return &metricsTracer{}
+	return &metricsTracer{labels: labels}
 }
 
 // HolePunchFinished tracks metrics completion of a holepunch. Metrics are tracked on
@@ -143,6 +165,7 @@ func (mt *metricsTracer) HolePunchFinished(side string, numAttempts int,
 					// no connection was made
 					*tags = append(*tags, "failed")
 				}
+				mt.labels.Apply(addressOutcomeLabels, *tags)
 				hpAddressOutcomesTotal.WithLabelValues(*tags...).Inc()
 				*tags = (*tags)[:2] // 2 because we want to keep (side, numAttempts)
 				break
@@ -150,6 +173,7 @@ func (mt *metricsTracer) HolePunchFinished(side string, numAttempts int,
 		}
 		if !matchingAddress {
 			*tags = append(*tags, lipv, ltransport, "no_suitable_address")
+			mt.labels.Apply(addressOutcomeLabels, *tags)
 			hpAddressOutcomesTotal.WithLabelValues(*tags...).Inc()
 			*tags = (*tags)[:2] // 2 because we want to keep (side, numAttempts)
 		}
@@ -164,6 +188,7 @@ func (mt *metricsTracer) HolePunchFinished(side string, numAttempts int,
 	}
 
 	*tags = append(*tags, outcome)
+	mt.labels.Apply(outcomeLabels, *tags)
 	hpOutcomesTotal.WithLabelValues(*tags...).Inc()
 }
 
@@ -183,5 +208,6 @@ func (mt *metricsTracer) DirectDialFinished(success bool) {
 	} else {
 		*tags = append(*tags, "failed")
 	}
+	mt.labels.Apply(directDialLabels, *tags)
 	directDialsTotal.WithLabelValues(*tags...).Inc()
 }
@@ -171,7 +171,7 @@ func (s *Service) incomingHolePunch(str network.Stream) (rtt time.Duration, remo
 	if !isRelayAddress(str.Conn().RemoteMultiaddr()) {
 		return 0, nil, nil, fmt.Errorf("received hole punch stream: %s", str.Conn().RemoteMultiaddr())
 	}
-	ownAddrs = removeRelayAddrs(s.ids.OwnObservedAddrs())
+	ownAddrs = holePunchableAddrs(s.host.Network(), s.ids.OwnObservedAddrs())
 	if s.filter != nil {
 		ownAddrs = s.filter.FilterLocal(str.Conn().RemotePeer(), ownAddrs)
 	}
@@ -180,6 +180,10 @@ func (s *Service) incomingHolePunch(str network.Stream) (rtt time.Duration, remo
 	if len(ownAddrs) == 0 {
 		return 0, nil, nil, errors.New("rejecting hole punch request, as we don't have any public addresses")
 	}
+	ownAddrs = removeSymmetricNATAddrs(s.ids, ownAddrs)
+	if len(ownAddrs) == 0 {
+		return 0, nil, nil, errors.New("rejecting hole punch request, as we're behind a symmetric NAT")
+	}
 
 	if err := str.Scope().ReserveMemory(maxMsgSize, network.ReservationPriorityAlways); err != nil {
 		log.Debugf("error reserving memory for stream: %s", err)
@@ -202,7 +206,7 @@ func (s *Service) incomingHolePunch(str network.Stream) (rtt time.Duration, remo
 		return 0, nil, nil, fmt.Errorf("expected CONNECT message from initiator but got %d", t)
 	}
 
-	obsDial := removeRelayAddrs(addrsFromBytes(msg.ObsAddrs))
+	obsDial := removeSymmetricNATAddrs(s.ids, holePunchableAddrs(s.host.Network(), addrsFromBytes(msg.ObsAddrs)))
 	if s.filter != nil {
 		obsDial = s.filter.FilterRemote(str.Conn().RemotePeer(), obsDial)
 	}
@@ -6,6 +6,8 @@ import (
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
 
 	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr/net"
@@ -31,6 +33,59 @@ func removeRelayAddrs(addrs []ma.Multiaddr) []ma.Multiaddr {
 	return result
 }
 
+// holePunchableAddrs returns the addresses of transports that support hole
+// punching. If n doesn't know the capabilities of its transports, only relay
+// addresses are removed.
+func holePunchableAddrs(n network.Network, addrs []ma.Multiaddr) []ma.Multiaddr {
+	r, ok := n.(transport.CapabilityResolver)
+	if !ok {
+		return removeRelayAddrs(addrs)
+	}
+	result := make([]ma.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		if caps, _ := r.TransportCapabilities(addr); caps.Has(transport.CapHolePunching) {
+			result = append(result, addr)
+		}
+	}
+	return result
+}
+
+// removeSymmetricNATAddrs removes all addresses using a transport protocol for
+// which we're behind a Symmetric NAT. Hole punching these would be futile.
+func removeSymmetricNATAddrs(ids identify.IDService, addrs []ma.Multiaddr) []ma.Multiaddr {
+	tcpSymmetric := ownNATDeviceType(ids, network.NATTransportTCP) == network.NATDeviceTypeSymmetric
+	udpSymmetric := ownNATDeviceType(ids, network.NATTransportUDP) == network.NATDeviceTypeSymmetric
+	if !tcpSymmetric && !udpSymmetric {
+		return addrs
+	}
+	result := make([]ma.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		if _, err := addr.ValueForProtocol(ma.P_TCP); err == nil && tcpSymmetric {
+			continue
+		}
+		if _, err := addr.ValueForProtocol(ma.P_UDP); err == nil && udpSymmetric {
+			continue
+		}
+		result = append(result, addr)
+	}
+	return result
+}
+
+// isBehindSymmetricNAT returns true if we're behind a Symmetric NAT for both TCP and UDP.
+func isBehindSymmetricNAT(ids identify.IDService) bool {
+	return ownNATDeviceType(ids, network.NATTransportTCP) == network.NATDeviceTypeSymmetric &&
+		ownNATDeviceType(ids, network.NATTransportUDP) == network.NATDeviceTypeSymmetric
+}
+
+// ownNATDeviceType returns the type of the NAT device we're behind, if the
+// IDService implements identify.NATDeviceTypeReporter.
+func ownNATDeviceType(ids identify.IDService, proto network.NATTransportProtocol) network.NATDeviceType {
+	if r, ok := ids.(identify.NATDeviceTypeReporter); ok {
+		return r.OwnNATDeviceType(proto)
+	}
+	return network.NATDeviceTypeUnknown
+}
+
 func isRelayAddress(a ma.Multiaddr) bool {
 	_, err := a.ValueForProtocol(ma.P_CIRCUIT)
 	return err == nil
@@ -0,0 +1,79 @@
+package identify
+
+import (
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/sec"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify/pb"
+
+	manet "github.com/multiformats/go-multiaddr/net"
+	"google.golang.org/protobuf/proto"
+)
+
+// HandshakeSnapshotProvider is an optional interface implemented by IDServices
+// that support fast identify. The snapshot is passed to the security
+// transports implementing sec.IdentifySnapshotter.
+type HandshakeSnapshotProvider interface {
+	// HandshakeSnapshot returns the identify message to embed in security
+	// handshakes. It returns nil if fast identify is disabled.
+	HandshakeSnapshot() []byte
+}
+
+var _ HandshakeSnapshotProvider = (*idService)(nil)
+
+// HandshakeSnapshot returns the current snapshot of our identify message,
+// without the connection specific fields.
+func (ids *idService) HandshakeSnapshot() []byte {
+	ids.currentSnapshot.Lock()
+	defer ids.currentSnapshot.Unlock()
+	return ids.currentSnapshot.handshake
+}
+
+// marshalHandshakeSnapshot creates the identify message embedded in security
+// handshakes. Since it's sent to all peers, it doesn't contain the observed
+// address, and loopback addresses are omitted. The signed peer record is
+// dropped if the message would exceed sec.MaxIdentifySnapshotSize.
+func (ids *idService) marshalHandshakeSnapshot(snapshot *identifySnapshot) []byte {
+	mes := &pb.Identify{
+		Protocols:        protocol.ConvertToStrings(snapshot.protocols),
+		ProtocolVersion:  &ids.ProtocolVersion,
+		AgentVersion:     &ids.UserAgent,
+		SignedPeerRecord: ids.getSignedRecord(snapshot),
+	}
+	for _, addr := range snapshot.addrs {
+		if !manet.IsIPLoopback(addr) {
+			mes.ListenAddrs = append(mes.ListenAddrs, addr.Bytes())
+		}
+	}
+	if mes.SignedPeerRecord != nil && proto.Size(mes) > sec.MaxIdentifySnapshotSize {
+		mes.SignedPeerRecord = nil
+	}
+	if proto.Size(mes) > sec.MaxIdentifySnapshotSize {
+		log.Debugw("identify snapshot too large for the security handshake", "size", proto.Size(mes))
+		return nil
+	}
+	b, err := proto.Marshal(mes)
+	if err != nil {
+		log.Errorw("failed to marshal identify snapshot", "error", err)
+		return nil
+	}
+	return b
+}
+
+// consumeHandshakeSnapshot consumes the identify message the peer embedded in
+// the security handshake of c. It returns false if fast identify is disabled,
+// or if the peer didn't send a (valid) snapshot.
+func (ids *idService) consumeHandshakeSnapshot(c network.Conn) bool {
+	b := c.ConnState().IdentifySnapshot
+	if !ids.fastIdentify || len(b) == 0 {
+		return false
+	}
+	mes := &pb.Identify{}
+	if err := proto.Unmarshal(b, mes); err != nil {
+		log.Debugw("failed to parse identify snapshot from handshake", "peer", c.RemotePeer(), "error", err)
+		return false
+	}
+	log.Debugw("consuming identify snapshot from handshake", "peer", c.RemotePeer(), "protocols", len(mes.Protocols), "addrs", len(mes.ListenAddrs))
+	ids.consumeMessage(mes, c, false)
+	return true
+}
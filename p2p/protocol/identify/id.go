@@ -7,18 +7,22 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/exp/slices"
 
+	"github.com/benbjohnson/clock"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/panics"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/libp2p/go-libp2p/p2p/host/addrcheck"
 	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
 	"github.com/libp2p/go-libp2p/p2p/protocol/identify/pb"
 
@@ -44,7 +48,13 @@ const (
 
 const ServiceName = "libp2p.identify"
 
-const maxPushConcurrency = 32
+const (
+	defaultPushConcurrency  = 32
+	defaultPushRate         = 500
+	defaultPushCoalesceTime = 100 * time.Millisecond
+
+	pushTimeout = 5 * time.Second
+)
 
 var Timeout = 60 * time.Second // timeout on all incoming Identify interactions
 
@@ -107,6 +117,16 @@ type IDService interface {
 	io.Closer
 }
 
+// NATDeviceTypeReporter is an optional interface implemented by IDServices
+// that infer the type of the NAT device we're behind.
+type NATDeviceTypeReporter interface {
+	// OwnNATDeviceType returns the type of the NAT device we're behind for
+	// the given transport protocol, as inferred from our observed addresses.
+	OwnNATDeviceType(network.NATTransportProtocol) network.NATDeviceType
+}
+
+var _ NATDeviceTypeReporter = (*idService)(nil)
+
 type identifyPushSupport uint8
 
 const (
@@ -141,6 +161,7 @@ type idService struct {
 	ProtocolVersion string
 
 	metricsTracer MetricsTracer
+	clock         clock.Clock
 
 	setupCompleted chan struct{} // is closed when Start has finished setting up
 	ctx            context.Context
@@ -149,6 +170,27 @@ type idService struct {
 	refCount sync.WaitGroup
 
 	disableSignedPeerRecord bool
+	// peerRecords verifies the signed peer records received concurrently on
+	// many connections in batches.
+	peerRecords *record.EnvelopeBatcher
+	limits      Limits
+
+	pushConcurrency  int
+	pushInterval     time.Duration // minimum interval between starting two pushes, 0 if not rate limited
+	pushCoalesceTime time.Duration
+
+	// serviceName is the resource manager service scope of identify streams.
+	serviceName string
+	// reservationPriority is used for the memory reservations of identify
+	// streams.
+	reservationPriority uint8
+
+	disablePush          bool
+	disableObservedAddrs bool
+	// readOnly disables recording any address sent by other peers.
+	readOnly bool
+	// fastIdentify enables exchanging identify snapshots in the security handshake.
+	fastIdentify bool
 
 	connsMu sync.RWMutex
 	// The conns map contains all connections we're currently handling.
@@ -165,18 +207,31 @@ type idService struct {
 		evtPeerProtocolsUpdated        event.Emitter
 		evtPeerIdentificationCompleted event.Emitter
 		evtPeerIdentificationFailed    event.Emitter
+		evtPeerMetadataChanged         event.Emitter
 	}
 
 	currentSnapshot struct {
 		sync.Mutex
 		snapshot identifySnapshot
+		// handshake is the snapshot embedded in security handshakes, if fast identify is enabled.
+		handshake []byte
 	}
+
+	// pushMinInterval is the minimum interval between two rounds of pushes, in nanoseconds
+	pushMinInterval atomic.Int64
 }
 
 // NewIDService constructs a new *idService and activates it by
 // attaching its stream handler to the given host.Host.
 func NewIDService(h host.Host, opts ...Option) (*idService, error) {
-	var cfg config
+	cfg := config{
+		pushConcurrency:     defaultPushConcurrency,
+		pushRate:            defaultPushRate,
+		pushCoalesceTime:    defaultPushCoalesceTime,
+		serviceName:         ServiceName,
+		reservationPriority: network.ReservationPriorityAlways,
+		clock:               clock.New(),
+	}
 	for _, opt := range opts {
 		opt(&cfg)
 	}
@@ -185,6 +240,10 @@ func NewIDService(h host.Host, opts ...Option) (*idService, error) {
 	if cfg.userAgent != "" {
 		userAgent = cfg.userAgent
 	}
+	var pushInterval time.Duration
+	if cfg.pushRate > 0 {
+		pushInterval = time.Duration(float64(time.Second) / cfg.pushRate)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	s := &idService{
@@ -195,11 +254,23 @@ func NewIDService(h host.Host, opts ...Option) (*idService, error) {
 		ctxCancel:               cancel,
 		conns:                   make(map[network.Conn]entry),
 		disableSignedPeerRecord: cfg.disableSignedPeerRecord,
+		peerRecords:             record.NewEnvelopeBatcher(peer.PeerRecordEnvelopeDomain),
 		setupCompleted:          make(chan struct{}),
 		metricsTracer:           cfg.metricsTracer,
-	}
-
-	observedAddrs, err := NewObservedAddrManager(h)
+		limits:                  cfg.limits,
+		pushConcurrency:         max(cfg.pushConcurrency, 1),
+		pushInterval:            pushInterval,
+		pushCoalesceTime:        cfg.pushCoalesceTime,
+		serviceName:             cfg.serviceName,
+		reservationPriority:     cfg.reservationPriority,
+		disablePush:             cfg.disablePush,
+		disableObservedAddrs:    cfg.disableObservedAddrs || cfg.readOnly,
+		readOnly:                cfg.readOnly,
+		fastIdentify:            cfg.fastIdentify,
+		clock:                   cfg.clock,
+	}
+
+	observedAddrs, err := newObservedAddrManager(h, cfg.clock)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create observed address manager: %s", err)
 	}
@@ -217,13 +288,19 @@ func NewIDService(h host.Host, opts ...Option) (*idService, error) {
 	if err != nil {
 		log.Warnf("identify service not emitting identification failed events; err: %s", err)
 	}
+	s.emitters.evtPeerMetadataChanged, err = h.EventBus().Emitter(&event.EvtPeerMetadataChanged{})
+	if err != nil {
+		log.Warnf("identify service not emitting peer metadata changed events; err: %s", err)
+	}
 	return s, nil
 }
 
 func (ids *idService) Start() {
 	ids.Host.Network().Notify((*netNotifiee)(ids))
 	ids.Host.SetStreamHandler(ID, ids.handleIdentifyRequest)
-	ids.Host.SetStreamHandler(IDPush, ids.handlePush)
+	if !ids.disablePush {
+		ids.Host.SetStreamHandler(IDPush, ids.handlePush)
+	}
 	ids.updateSnapshot()
 	close(ids.setupCompleted)
 
@@ -235,7 +312,7 @@ func (ids *idService) loop(ctx context.Context) {
 	defer ids.refCount.Done()
 
 	sub, err := ids.Host.EventBus().Subscribe(
-		[]any{&event.EvtLocalProtocolsUpdated{}, &event.EvtLocalAddressesUpdated{}},
+		[]any{&event.EvtLocalProtocolsUpdated{}, &event.EvtLocalAddressesUpdated{}, &event.EvtLocalPeerRecordUpdated{}, &event.EvtLocalPowerStateChanged{}},
 		eventbus.BufSize(256),
 		eventbus.Name("identify (loop)"),
 	)
@@ -259,8 +336,35 @@ func (ids *idService) loop(ctx context.Context) {
 			case <-ctx.Done():
 				return
 			case <-triggerPush:
+				// Coalesce rapid successive changes into a single push.
+				if ids.pushCoalesceTime > 0 {
+					t := time.NewTimer(ids.pushCoalesceTime)
+					select {
+					case <-t.C:
+					case <-ctx.Done():
+						t.Stop()
+						return
+					}
+					select {
+					case <-triggerPush:
+						if t, ok := ids.metricsTracer.(PushMetricsTracer); ok {
+							t.PushesCoalesced()
+						}
+					default:
+					}
+				}
 				ids.sendPushes(ctx)
 			}
+			// In low power mode, coalesce all changes within the min push interval into a single push.
+			if d := time.Duration(ids.pushMinInterval.Load()); d > 0 {
+				t := time.NewTimer(d)
+				select {
+				case <-t.C:
+				case <-ctx.Done():
+					t.Stop()
+					return
+				}
+			}
 		}
 	}()
 
@@ -270,7 +374,11 @@ func (ids *idService) loop(ctx context.Context) {
 			if !ok {
 				return
 			}
-			if updated := ids.updateSnapshot(); !updated {
+			if evt, ok := e.(event.EvtLocalPowerStateChanged); ok {
+				ids.pushMinInterval.Store(int64(evt.Profile.IdentifyPushMinInterval))
+				continue
+			}
+			if updated := ids.updateSnapshot(); !updated || ids.disablePush {
 				continue
 			}
 			if ids.metricsTracer != nil {
@@ -279,6 +387,9 @@ func (ids *idService) loop(ctx context.Context) {
 			select {
 			case triggerPush <- struct{}{}:
 			default: // we already have one more push queued, no need to queue another one
+				if t, ok := ids.metricsTracer.(PushMetricsTracer); ok {
+					t.PushesCoalesced()
+				}
 			}
 		case <-ctx.Done():
 			return
@@ -286,6 +397,9 @@ func (ids *idService) loop(ctx context.Context) {
 	}
 }
 
+// sendPushes sends the current snapshot to all peers that haven't received it
+// yet. Pushes are sent by a pool of workers, and started at most at the
+// configured rate, to avoid a spike of streams on hosts with many connections.
 func (ids *idService) sendPushes(ctx context.Context) {
 	ids.connsMu.RLock()
 	conns := make([]network.Conn, 0, len(ids.conns))
@@ -297,9 +411,32 @@ func (ids *idService) sendPushes(ctx context.Context) {
 		}
 	}
 	ids.connsMu.RUnlock()
+	if len(conns) == 0 {
+		return
+	}
+
+	var rateLimit <-chan time.Time
+	if ids.pushInterval > 0 {
+		t := time.NewTicker(ids.pushInterval)
+		defer t.Stop()
+		rateLimit = t.C
+	}
 
-	sem := make(chan struct{}, maxPushConcurrency)
+	work := make(chan network.Conn)
 	var wg sync.WaitGroup
+	for i := 0; i < min(ids.pushConcurrency, len(conns)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range work {
+				ids.sendPush(ctx, c)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(work)
+
+	var started int
 	for _, c := range conns {
 		// check if the connection is still alive
 		ids.connsMu.RLock()
@@ -317,25 +454,52 @@ func (ids *idService) sendPushes(ctx context.Context) {
 			continue
 		}
 		// we haven't, send it now
-		sem <- struct{}{}
-		wg.Add(1)
-		go func(c network.Conn) {
-			defer wg.Done()
-			defer func() { <-sem }()
-			ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-			defer cancel()
-			str, err := ids.Host.NewStream(ctx, c.RemotePeer(), IDPush)
-			if err != nil { // connection might have been closed recently
-				return
-			}
-			// TODO: find out if the peer supports push if we didn't have any information about push support
-			if err := ids.sendIdentifyResp(str, true); err != nil {
-				log.Debugw("failed to send identify push", "peer", c.RemotePeer(), "error", err)
+		if rateLimit != nil && started > 0 {
+			select {
+			case <-rateLimit:
+			case <-ctx.Done():
 				return
 			}
-		}(c)
+		}
+		select {
+		case work <- c:
+			started++
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (ids *idService) sendPush(ctx context.Context, c network.Conn) {
+	var str network.Stream
+	// A panic only resets the push stream, the other workers keep going.
+	defer panics.Recover("identify", c.RemotePeer(), func() {
+		if str != nil {
+			str.Reset()
+		}
+	})
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, pushTimeout)
+	defer cancel()
+	str, err := ids.Host.NewStream(ctx, c.RemotePeer(), IDPush)
+	if err != nil { // connection might have been closed recently
+		if t, ok := ids.metricsTracer.(PushMetricsTracer); ok {
+			t.PushFailed("stream")
+		}
+		return
+	}
+	// TODO: find out if the peer supports push if we didn't have any information about push support
+	if err := ids.sendIdentifyResp(str, true); err != nil {
+		log.Debugw("failed to send identify push", "peer", c.RemotePeer(), "error", err)
+		if t, ok := ids.metricsTracer.(PushMetricsTracer); ok {
+			t.PushFailed("write")
+		}
+		return
+	}
+	if t, ok := ids.metricsTracer.(PushMetricsTracer); ok {
+		t.PushSent(time.Since(start))
 	}
-	wg.Wait()
 }
 
 // Close shuts down the idService
@@ -354,6 +518,10 @@ func (ids *idService) ObservedAddrsFor(local ma.Multiaddr) []ma.Multiaddr {
 	return ids.observedAddrs.AddrsFor(local)
 }
 
+func (ids *idService) OwnNATDeviceType(proto network.NATTransportProtocol) network.NATDeviceType {
+	return ids.observedAddrs.NATDeviceType(proto)
+}
+
 // IdentifyConn runs the Identify protocol on a connection.
 // It returns when we've received the peer's Identify message (or the request fails).
 // If successful, the peer store will contain the peer's addresses and supported protocols.
@@ -394,7 +562,18 @@ func (ids *idService) IdentifyWait(c network.Conn) <-chan struct{} {
 	// already, but that doesn't really matter. We'll fail to open a
 	// stream then forget the connection.
 	go func() {
-		defer close(e.IdentifyWaitChan)
+		waitChan := e.IdentifyWaitChan
+		defer func() {
+			if waitChan != nil {
+				close(waitChan)
+			}
+		}()
+		if ids.consumeHandshakeSnapshot(c) {
+			// We already know the peer's protocols and addresses. Don't make
+			// the callers wait for the identify round trip.
+			close(waitChan)
+			waitChan = nil
+		}
 		if err := ids.identifyConn(c); err != nil {
 			log.Warnf("failed to identify %s: %s", c.RemotePeer(), err)
 			ids.emitters.evtPeerIdentificationFailed.Emit(event.EvtPeerIdentificationFailed{Peer: c.RemotePeer(), Reason: err})
@@ -441,7 +620,7 @@ func (ids *idService) handleIdentifyRequest(s network.Stream) {
 }
 
 func (ids *idService) sendIdentifyResp(s network.Stream, isPush bool) error {
-	if err := s.Scope().SetService(ServiceName); err != nil {
+	if err := s.Scope().SetService(ids.serviceName); err != nil {
 		s.Reset()
 		return fmt.Errorf("failed to attaching stream to identify service: %w", err)
 	}
@@ -481,13 +660,13 @@ func (ids *idService) sendIdentifyResp(s network.Stream, isPush bool) error {
 }
 
 func (ids *idService) handleIdentifyResponse(s network.Stream, isPush bool) error {
-	if err := s.Scope().SetService(ServiceName); err != nil {
+	if err := s.Scope().SetService(ids.serviceName); err != nil {
 		log.Warnf("error attaching stream to identify service: %s", err)
 		s.Reset()
 		return err
 	}
 
-	if err := s.Scope().ReserveMemory(signedIDSize, network.ReservationPriorityAlways); err != nil {
+	if err := s.Scope().ReserveMemory(signedIDSize, ids.reservationPriority); err != nil {
 		log.Warnf("error reserving memory for identify stream: %s", err)
 		s.Reset()
 		return err
@@ -561,6 +740,7 @@ func (ids *idService) updateSnapshot() (updated bool) {
 		addrs:     addrs,
 		protocols: protos,
 	}
+	elidedProtocols, elidedAddrs := ids.truncateSnapshot(&snapshot)
 
 	if !ids.disableSignedPeerRecord {
 		if cab, ok := peerstore.GetCertifiedAddrBook(ids.Host.Peerstore()); ok {
@@ -577,8 +757,17 @@ func (ids *idService) updateSnapshot() (updated bool) {
 
 	snapshot.seq = ids.currentSnapshot.snapshot.seq + 1
 	ids.currentSnapshot.snapshot = snapshot
+	if ids.fastIdentify {
+		ids.currentSnapshot.handshake = ids.marshalHandshakeSnapshot(&snapshot)
+	}
 
 	log.Debugw("updating snapshot", "seq", snapshot.seq, "addrs", snapshot.addrs)
+	if elidedProtocols > 0 || elidedAddrs > 0 {
+		log.Debugw("truncated snapshot", "elided protocols", elidedProtocols, "elided addrs", elidedAddrs)
+	}
+	if t, ok := ids.metricsTracer.(SnapshotMetricsTracer); ok {
+		t.SnapshotUpdated(elidedProtocols, elidedAddrs)
+	}
 	return true
 }
 
@@ -715,7 +904,7 @@ func (ids *idService) consumeMessage(mes *pb.Identify, c network.Conn, isPush bo
 		obsAddr = nil
 	}
 
-	if obsAddr != nil {
+	if obsAddr != nil && !ids.disableObservedAddrs {
 		// TODO refactor this to use the emitted events instead of having this func call explicitly.
 		ids.observedAddrs.Record(c, obsAddr)
 	}
@@ -743,11 +932,48 @@ func (ids *idService) consumeMessage(mes *pb.Identify, c network.Conn, isPush bo
 
 	// add certified addresses for the peer, if they sent us a signed peer record
 	// otherwise use the unsigned addresses.
-	signedPeerRecord, err := signedPeerRecordFromMessage(mes)
+	signedPeerRecord, err := ids.signedPeerRecordFromMessage(mes)
 	if err != nil {
 		log.Errorf("error getting peer record from Identify message: %v", err)
 	}
 
+	if ids.readOnly {
+		log.Debugf("%s not recording listen addrs of %s: read-only mode", c.LocalPeer(), p)
+	} else {
+		signedPeerRecord = ids.consumeAddrs(c, signedPeerRecord, lmaddrs)
+	}
+
+	log.Debugf("%s received listen addrs for %s: %s", c.LocalPeer(), c.RemotePeer(), lmaddrs)
+
+	// get protocol versions
+	pv := mes.GetProtocolVersion()
+	av := mes.GetAgentVersion()
+
+	ids.updatePeerMetadata(p, pv, av)
+
+	// get the key from the other side. we may not have it (no-auth transport)
+	ids.consumeReceivedPubKey(c, mes.PublicKey)
+
+	ids.emitters.evtPeerIdentificationCompleted.Emit(event.EvtPeerIdentificationCompleted{
+		Peer:             c.RemotePeer(),
+		Conn:             c,
+		ListenAddrs:      lmaddrs,
+		Protocols:        mesProtocols,
+		SignedPeerRecord: signedPeerRecord,
+		ObservedAddr:     obsAddr,
+		ProtocolVersion:  pv,
+		AgentVersion:     av,
+	})
+
+}
+
+// consumeAddrs adds the addresses sent by the peer on c to the peerstore. The
+// addresses of the signed peer record are used if there is one, otherwise the
+// unsigned lmaddrs. It returns the signed peer record, or nil if it was
+// invalid.
+func (ids *idService) consumeAddrs(c network.Conn, signedPeerRecord *record.Envelope, lmaddrs []ma.Multiaddr) *record.Envelope {
+	p := c.RemotePeer()
+
 	// Extend the TTLs on the known (probably) good addresses.
 	// Taking the lock ensures that we don't concurrently process a disconnect.
 	ids.addrMu.Lock()
@@ -776,35 +1002,34 @@ func (ids *idService) consumeMessage(mes *pb.Identify, c network.Conn, isPush bo
 	} else {
 		addrs = lmaddrs
 	}
-	ids.Host.Peerstore().AddAddrs(p, filterAddrs(addrs, c.RemoteMultiaddr()), ttl)
+	// A valid signature doesn't make the addresses well-formed.
+	addrs = addrcheck.Filter(addrcheck.BoundaryIdentify, addrs)
+	peerstore.AddAddrsFromSource(ids.Host.Peerstore(), p, filterAddrs(addrs, c.RemoteMultiaddr()), ttl, peerstore.AddrSourceIdentify)
 
 	// Finally, expire all temporary addrs.
 	ids.Host.Peerstore().UpdateAddrs(p, peerstore.TempAddrTTL, 0)
 	ids.addrMu.Unlock()
+	return signedPeerRecord
+}
 
-	log.Debugf("%s received listen addrs for %s: %s", c.LocalPeer(), c.RemotePeer(), lmaddrs)
-
-	// get protocol versions
-	pv := mes.GetProtocolVersion()
-	av := mes.GetAgentVersion()
-
-	ids.Host.Peerstore().Put(p, "ProtocolVersion", pv)
-	ids.Host.Peerstore().Put(p, "AgentVersion", av)
-
-	// get the key from the other side. we may not have it (no-auth transport)
-	ids.consumeReceivedPubKey(c, mes.PublicKey)
-
-	ids.emitters.evtPeerIdentificationCompleted.Emit(event.EvtPeerIdentificationCompleted{
-		Peer:             c.RemotePeer(),
-		Conn:             c,
-		ListenAddrs:      lmaddrs,
-		Protocols:        mesProtocols,
-		SignedPeerRecord: signedPeerRecord,
-		ObservedAddr:     obsAddr,
-		ProtocolVersion:  pv,
-		AgentVersion:     av,
-	})
+// updatePeerMetadata stores the peer's metadata learned via identify, and
+// emits an EvtPeerMetadataChanged if any of the values changed.
+func (ids *idService) updatePeerMetadata(p peer.ID, pv, av string) {
+	ps := ids.Host.Peerstore()
+	var changed []string
+	if old, err := peerstore.GetProtocolVersion(ps, p); err != nil || old != pv {
+		changed = append(changed, peerstore.ProtocolVersionKey)
+	}
+	if old, err := peerstore.GetAgentVersion(ps, p); err != nil || old != av {
+		changed = append(changed, peerstore.AgentVersionKey)
+	}
+	peerstore.PutProtocolVersion(ps, p, pv)
+	peerstore.PutAgentVersion(ps, p, av)
+	peerstore.PutLastHandshake(ps, p, ids.clock.Now())
 
+	if len(changed) > 0 {
+		ids.emitters.evtPeerMetadataChanged.Emit(event.EvtPeerMetadataChanged{Peer: p, Keys: changed})
+	}
 }
 
 func (ids *idService) consumeSignedPeerRecord(p peer.ID, signedPeerRecord *record.Envelope) ([]ma.Multiaddr, error) {
@@ -947,11 +1172,11 @@ func (ids *idService) addConnWithLock(c network.Conn) {
 	}
 }
 
-func signedPeerRecordFromMessage(msg *pb.Identify) (*record.Envelope, error) {
+func (ids *idService) signedPeerRecordFromMessage(msg *pb.Identify) (*record.Envelope, error) {
 	if msg.SignedPeerRecord == nil || len(msg.SignedPeerRecord) == 0 {
 		return nil, nil
 	}
-	env, _, err := record.ConsumeEnvelope(msg.SignedPeerRecord, peer.PeerRecordEnvelopeDomain)
+	env, _, err := ids.peerRecords.ConsumeEnvelope(msg.SignedPeerRecord)
 	return env, err
 }
 
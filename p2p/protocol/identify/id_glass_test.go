@@ -3,18 +3,21 @@ package identify
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
 	recordPb "github.com/libp2p/go-libp2p/core/record/pb"
 	blhost "github.com/libp2p/go-libp2p/p2p/host/blank"
 	swarmt "github.com/libp2p/go-libp2p/p2p/net/swarm/testing"
 	ma "github.com/multiformats/go-multiaddr"
 	"google.golang.org/protobuf/proto"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -206,3 +209,48 @@ func TestIncomingAddrFilter(t *testing.T) {
 		})
 	}
 }
+
+type pushCountingTracer struct {
+	MetricsTracer
+	sent, coalesced atomic.Int32
+}
+
+func (t *pushCountingTracer) PushSent(time.Duration) { t.sent.Add(1) }
+func (t *pushCountingTracer) PushFailed(string)      {}
+func (t *pushCountingTracer) PushesCoalesced()       { t.coalesced.Add(1) }
+
+func TestPushCoalescing(t *testing.T) {
+	tr := &pushCountingTracer{MetricsTracer: NewMetricsTracer(WithRegisterer(prometheus.NewRegistry()))}
+	h1 := blhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	defer h1.Close()
+	ids1, err := NewIDService(h1, WithMetricsTracer(tr), WithPushCoalesceTime(500*time.Millisecond), WithPushRate(10))
+	require.NoError(t, err)
+	defer ids1.Close()
+	ids1.Start()
+
+	h2 := blhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	defer h2.Close()
+	ids2, err := NewIDService(h2)
+	require.NoError(t, err)
+	defer ids2.Close()
+	ids2.Start()
+
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+	ids1.IdentifyConn(h1.Network().ConnsToPeer(h2.ID())[0])
+	ids2.IdentifyConn(h2.Network().ConnsToPeer(h1.ID())[0])
+
+	// rapid successive changes are sent in a single push
+	protos := []protocol.ID{"/test/1", "/test/2", "/test/3"}
+	for _, p := range protos {
+		h1.SetStreamHandler(p, func(network.Stream) {})
+		time.Sleep(50 * time.Millisecond)
+	}
+	require.Eventually(t, func() bool {
+		sup, err := h2.Peerstore().SupportsProtocols(h1.ID(), protos...)
+		return err == nil && len(sup) == len(protos)
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool { return tr.sent.Load() == 1 }, time.Second, 10*time.Millisecond)
+	require.NotZero(t, tr.coalesced.Load())
+	time.Sleep(200 * time.Millisecond)
+	require.EqualValues(t, 1, tr.sent.Load())
+}
@@ -523,15 +523,47 @@ func TestUserAgent(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	av, err := h1.Peerstore().Get(h2.ID(), "AgentVersion")
+	av, err := peerstore.GetAgentVersion(h1.Peerstore(), h2.ID())
 	if err != nil {
 		t.Fatal(err)
 	}
-	if ver, ok := av.(string); !ok || ver != "bar" {
+	if av != "bar" {
 		t.Errorf("expected agent version %q, got %q", "bar", av)
 	}
 }
 
+func TestPeerMetadataChangedEvent(t *testing.T) {
+	h1 := blhost.NewBlankHost(swarmt.GenSwarm(t))
+	h2 := blhost.NewBlankHost(swarmt.GenSwarm(t))
+	defer h1.Close()
+	defer h2.Close()
+
+	ids1, err := identify.NewIDService(h1)
+	require.NoError(t, err)
+	defer ids1.Close()
+	ids1.Start()
+	ids2, err := identify.NewIDService(h2, identify.UserAgent("foobar"))
+	require.NoError(t, err)
+	defer ids2.Close()
+	ids2.Start()
+
+	sub, err := h1.EventBus().Subscribe(new(event.EvtPeerMetadataChanged))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+	select {
+	case e := <-sub.Out():
+		evt := e.(event.EvtPeerMetadataChanged)
+		require.Equal(t, h2.ID(), evt.Peer)
+		require.ElementsMatch(t, []string{peerstore.AgentVersionKey, peerstore.ProtocolVersionKey}, evt.Keys)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a peer metadata changed event")
+	}
+	_, err = peerstore.GetLastHandshake(h1.Peerstore(), h2.ID())
+	require.NoError(t, err)
+}
+
 func TestNotListening(t *testing.T) {
 	// Make sure we don't panic if we're not listening on any addresses.
 	//
@@ -598,6 +630,79 @@ func TestSendPush(t *testing.T) {
 	}, time.Second, 10*time.Millisecond)
 }
 
+func TestDisablePush(t *testing.T) {
+	h1 := blhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	h2 := blhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	defer h2.Close()
+	defer h1.Close()
+
+	ids1, err := identify.NewIDService(h1, identify.DisablePush())
+	require.NoError(t, err)
+	defer ids1.Close()
+	ids1.Start()
+
+	ids2, err := identify.NewIDService(h2)
+	require.NoError(t, err)
+	defer ids2.Close()
+	ids2.Start()
+
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+	<-ids1.IdentifyWait(h1.Network().ConnsToPeer(h2.ID())[0])
+	<-ids2.IdentifyWait(h2.Network().ConnsToPeer(h1.ID())[0])
+
+	// h1 doesn't accept pushes
+	sup, err := h2.Peerstore().SupportsProtocols(h1.ID(), identify.IDPush)
+	require.NoError(t, err)
+	require.Empty(t, sup)
+
+	// h1 doesn't push its updates
+	h1.SetStreamHandler("rand", func(network.Stream) {})
+	require.Never(t, func() bool {
+		sup, err := h2.Peerstore().SupportsProtocols(h1.ID(), "rand")
+		return err != nil || len(sup) > 0
+	}, 500*time.Millisecond, 10*time.Millisecond)
+}
+
+func TestReadOnly(t *testing.T) {
+	h1 := blhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	h2 := blhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	defer h2.Close()
+	defer h1.Close()
+
+	ids1, err := identify.NewIDService(h1, identify.ReadOnly())
+	require.NoError(t, err)
+	defer ids1.Close()
+	ids1.Start()
+
+	ids2, err := identify.NewIDService(h2, identify.UserAgent("foobar"))
+	require.NoError(t, err)
+	defer ids2.Close()
+	ids2.Start()
+
+	// h2 connects to h1, so h1 doesn't know any address of h2 other than
+	// those sent via identify.
+	require.NoError(t, h2.Connect(context.Background(), peer.AddrInfo{ID: h1.ID(), Addrs: h1.Addrs()}))
+	<-ids2.IdentifyWait(h2.Network().ConnsToPeer(h1.ID())[0])
+	<-ids1.IdentifyWait(h1.Network().ConnsToPeer(h2.ID())[0])
+
+	// h1 still answers identify requests
+	require.ElementsMatch(t, h1.Addrs(), h2.Peerstore().Addrs(h1.ID()))
+
+	// but doesn't record any addresses of h2
+	require.Empty(t, h1.Peerstore().Addrs(h2.ID()))
+	cab, ok := peerstore.GetCertifiedAddrBook(h1.Peerstore())
+	require.True(t, ok)
+	require.Nil(t, cab.GetPeerRecord(h2.ID()))
+
+	// other information is recorded
+	av, err := h1.Peerstore().Get(h2.ID(), "AgentVersion")
+	require.NoError(t, err)
+	require.Equal(t, "foobar", av)
+	sup, err := h1.Peerstore().SupportsProtocols(h2.ID(), identify.ID)
+	require.NoError(t, err)
+	require.Equal(t, []protocol.ID{identify.ID}, sup)
+}
+
 func TestLargeIdentifyMessage(t *testing.T) {
 	if race.WithRace() {
 		t.Skip("setting peerstore.RecentlyConnectedAddrTTL is racy")
@@ -951,3 +1056,41 @@ func waitForDisconnectNotification(swarm *swarm.Swarm) <-chan struct{} {
 
 	return done
 }
+
+func TestSignedPeerRecordAddrsFiltered(t *testing.T) {
+	h1 := blhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	h2 := blhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	defer h1.Close()
+	defer h2.Close()
+
+	// h2 signs a record containing a malformed address
+	malformed := ma.StringCast("/ip4/1.2.3.4/udp/1234/tcp/1234")
+	rec := peer.PeerRecordFromAddrInfo(peer.AddrInfo{ID: h2.ID(), Addrs: append(h2.Addrs(), malformed)})
+	signed, err := record.Seal(rec, h2.Peerstore().PrivKey(h2.ID()))
+	require.NoError(t, err)
+	cab, ok := peerstore.GetCertifiedAddrBook(h2.Peerstore())
+	require.True(t, ok)
+	_, err = cab.ConsumePeerRecord(signed, peerstore.PermanentAddrTTL)
+	require.NoError(t, err)
+
+	ids1, err := identify.NewIDService(h1)
+	require.NoError(t, err)
+	defer ids1.Close()
+	ids1.Start()
+	ids2, err := identify.NewIDService(h2)
+	require.NoError(t, err)
+	defer ids2.Close()
+	ids2.Start()
+
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+	select {
+	case <-ids1.IdentifyWait(h1.Network().ConnsToPeer(h2.ID())[0]):
+	case <-time.After(5 * time.Second):
+		t.Fatal("identify timed out")
+	}
+	addrs := h1.Peerstore().Addrs(h2.ID())
+	require.NotEmpty(t, addrs)
+	for _, a := range addrs {
+		require.False(t, a.Equal(malformed), "malformed address in the peerstore")
+	}
+}
@@ -0,0 +1,184 @@
+package identify
+
+import (
+	"bytes"
+	"slices"
+	"sort"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify/pb"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxObservedAddrSize is the size reserved for the observed address when
+// computing the size of the identify message.
+const maxObservedAddrSize = 64
+
+// Limits bounds the size of the identify messages we send. Large nodes with
+// hundreds of protocols or addresses may otherwise send messages that peers
+// reject.
+//
+// If a limit is exceeded, the protocols and addresses sent are truncated
+// deterministically:
+//   - the signed peer record is never truncated, it's sent in a separate message
+//   - protocols are prioritized by the number of connected peers that support
+//     them, so rarely used protocols are elided first. The identify protocols
+//     are always kept.
+//   - addresses are prioritized by type: public addresses first, then relay
+//     addresses, private addresses, and loopback addresses.
+//
+// When the message is too large, protocols are elided before addresses.
+type Limits struct {
+	// MaxProtocols is the maximum number of protocols sent. If 0, the number
+	// of protocols isn't limited.
+	MaxProtocols int
+	// MaxAddrs is the maximum number of (unsigned) listen addresses sent. If 0,
+	// the number of addresses isn't limited.
+	MaxAddrs int
+	// MaxMessageSize is the maximum size of the identify message, excluding the
+	// signed peer record. If 0, the maximum size accepted by other peers is used.
+	MaxMessageSize int
+}
+
+func (l Limits) maxMessageSize() int {
+	if l.MaxMessageSize > 0 {
+		return l.MaxMessageSize
+	}
+	return signedIDSize
+}
+
+// truncateSnapshot applies the limits to the snapshot. It returns the number
+// of protocols and addresses that were elided.
+func (ids *idService) truncateSnapshot(s *identifySnapshot) (elidedProtocols, elidedAddrs int) {
+	l := ids.limits
+	maxSize := l.maxMessageSize()
+	protoSize := func(p protocol.ID) int { return 1 + protowire.SizeBytes(len(p)) }
+	addrSize := func(a ma.Multiaddr) int { return 1 + protowire.SizeBytes(len(a.Bytes())) }
+
+	size := ids.baseMessageSize()
+	for _, p := range s.protocols {
+		size += protoSize(p)
+	}
+	for _, a := range s.addrs {
+		size += addrSize(a)
+	}
+	if size <= maxSize &&
+		(l.MaxProtocols <= 0 || len(s.protocols) <= l.MaxProtocols) &&
+		(l.MaxAddrs <= 0 || len(s.addrs) <= l.MaxAddrs) {
+		return 0, 0
+	}
+
+	protos := ids.prioritizeProtocols(s.protocols)
+	addrs := prioritizeAddrs(s.addrs)
+	numRequired := 0
+	for _, p := range protos {
+		if p == ID || p == IDPush {
+			numRequired++
+		}
+	}
+	dropProtocol := func() {
+		size -= protoSize(protos[len(protos)-1])
+		protos = protos[:len(protos)-1]
+	}
+	dropAddr := func() {
+		size -= addrSize(addrs[len(addrs)-1])
+		addrs = addrs[:len(addrs)-1]
+	}
+	for l.MaxProtocols > 0 && len(protos) > max(l.MaxProtocols, numRequired) {
+		dropProtocol()
+	}
+	for l.MaxAddrs > 0 && len(addrs) > l.MaxAddrs {
+		dropAddr()
+	}
+	for size > maxSize {
+		if len(protos) > numRequired {
+			dropProtocol()
+		} else if len(addrs) > 0 {
+			dropAddr()
+		} else {
+			break
+		}
+	}
+
+	elidedProtocols = len(s.protocols) - len(protos)
+	elidedAddrs = len(s.addrs) - len(addrs)
+	slices.Sort(protos)
+	slices.SortFunc(addrs, func(a, b ma.Multiaddr) int { return bytes.Compare(a.Bytes(), b.Bytes()) })
+	s.protocols = protos
+	s.addrs = addrs
+	return elidedProtocols, elidedAddrs
+}
+
+// baseMessageSize returns the size of the identify message without the
+// protocols and listen addresses.
+func (ids *idService) baseMessageSize() int {
+	mes := &pb.Identify{
+		ObservedAddr:    make([]byte, maxObservedAddrSize),
+		ProtocolVersion: &ids.ProtocolVersion,
+		AgentVersion:    &ids.UserAgent,
+	}
+	if pk := ids.Host.Peerstore().PubKey(ids.Host.ID()); pk != nil {
+		mes.PublicKey, _ = crypto.MarshalPublicKey(pk)
+	}
+	return proto.Size(mes)
+}
+
+// prioritizeProtocols returns a copy of protos, sorted by priority: the
+// identify protocols first, then by the number of connected peers supporting
+// them, then lexicographically.
+func (ids *idService) prioritizeProtocols(protos []protocol.ID) []protocol.ID {
+	support := make(map[protocol.ID]int, len(protos))
+	for _, p := range ids.Host.Network().Peers() {
+		supported, err := ids.Host.Peerstore().SupportsProtocols(p, protos...)
+		if err != nil {
+			continue
+		}
+		for _, proto := range supported {
+			support[proto]++
+		}
+	}
+	isRequired := func(p protocol.ID) bool { return p == ID || p == IDPush }
+
+	res := slices.Clone(protos)
+	sort.SliceStable(res, func(i, j int) bool {
+		if isRequired(res[i]) != isRequired(res[j]) {
+			return isRequired(res[i])
+		}
+		if support[res[i]] != support[res[j]] {
+			return support[res[i]] > support[res[j]]
+		}
+		return res[i] < res[j]
+	})
+	return res
+}
+
+// addrPriority returns the priority of an address, lower is better.
+func addrPriority(a ma.Multiaddr) int {
+	switch {
+	case isRelayAddr(a):
+		return 1
+	case manet.IsPublicAddr(a):
+		return 0
+	case manet.IsIPLoopback(a):
+		return 3
+	default:
+		return 2
+	}
+}
+
+// prioritizeAddrs returns a copy of addrs, sorted by priority.
+func prioritizeAddrs(addrs []ma.Multiaddr) []ma.Multiaddr {
+	res := slices.Clone(addrs)
+	sort.SliceStable(res, func(i, j int) bool { return addrPriority(res[i]) < addrPriority(res[j]) })
+	return res
+}
+
+func isRelayAddr(a ma.Multiaddr) bool {
+	_, err := a.ValueForProtocol(ma.P_CIRCUIT)
+	return err == nil
+}
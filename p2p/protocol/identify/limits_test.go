@@ -0,0 +1,93 @@
+package identify
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+	blhost "github.com/libp2p/go-libp2p/p2p/host/blank"
+	swarmt "github.com/libp2p/go-libp2p/p2p/net/swarm/testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTruncateSnapshot(t *testing.T) {
+	h := blhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	defer h.Close()
+
+	newSnapshot := func() *identifySnapshot {
+		s := &identifySnapshot{
+			protocols: []protocol.ID{ID, IDPush},
+			addrs: []ma.Multiaddr{
+				ma.StringCast("/ip4/127.0.0.1/tcp/1234"),
+				ma.StringCast("/ip4/192.168.1.1/tcp/1234"),
+				ma.StringCast("/ip4/1.2.3.4/tcp/1234/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC/p2p-circuit"),
+				ma.StringCast("/ip4/1.2.3.4/tcp/1234"),
+			},
+		}
+		for i := 0; i < 20; i++ {
+			s.protocols = append(s.protocols, protocol.ID(fmt.Sprintf("/test/%02d", i)))
+		}
+		return s
+	}
+
+	t.Run("no limits", func(t *testing.T) {
+		ids, err := NewIDService(h)
+		require.NoError(t, err)
+		defer ids.Close()
+
+		s := newSnapshot()
+		elidedProtocols, elidedAddrs := ids.truncateSnapshot(s)
+		require.Zero(t, elidedProtocols)
+		require.Zero(t, elidedAddrs)
+		require.Len(t, s.protocols, 22)
+		require.Len(t, s.addrs, 4)
+	})
+
+	t.Run("count limits", func(t *testing.T) {
+		ids, err := NewIDService(h, WithLimits(Limits{MaxProtocols: 5, MaxAddrs: 2}))
+		require.NoError(t, err)
+		defer ids.Close()
+
+		s := newSnapshot()
+		elidedProtocols, elidedAddrs := ids.truncateSnapshot(s)
+		require.Equal(t, 17, elidedProtocols)
+		require.Equal(t, 2, elidedAddrs)
+		require.Equal(t, []protocol.ID{ID, IDPush, "/test/00", "/test/01", "/test/02"}, s.protocols)
+		require.ElementsMatch(t, []ma.Multiaddr{
+			ma.StringCast("/ip4/1.2.3.4/tcp/1234"),
+			ma.StringCast("/ip4/1.2.3.4/tcp/1234/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC/p2p-circuit"),
+		}, s.addrs)
+	})
+
+	t.Run("identify protocols are always kept", func(t *testing.T) {
+		ids, err := NewIDService(h, WithLimits(Limits{MaxProtocols: 1}))
+		require.NoError(t, err)
+		defer ids.Close()
+
+		s := newSnapshot()
+		ids.truncateSnapshot(s)
+		require.Equal(t, []protocol.ID{ID, IDPush}, s.protocols)
+	})
+
+	t.Run("message size", func(t *testing.T) {
+		ids, err := NewIDService(h)
+		require.NoError(t, err)
+		base := ids.baseMessageSize()
+		ids.Close()
+
+		// leave enough space for the identify protocols and the public address
+		maxSize := base + 2 + len(ID) + 2 + len(IDPush) + 2 + len(ma.StringCast("/ip4/1.2.3.4/tcp/1234").Bytes())
+		ids, err = NewIDService(h, WithLimits(Limits{MaxMessageSize: maxSize}))
+		require.NoError(t, err)
+		defer ids.Close()
+
+		s := newSnapshot()
+		elidedProtocols, elidedAddrs := ids.truncateSnapshot(s)
+		require.Equal(t, 20, elidedProtocols)
+		require.Equal(t, 3, elidedAddrs)
+		require.Equal(t, []protocol.ID{ID, IDPush}, s.protocols)
+		require.Equal(t, []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/tcp/1234")}, s.addrs)
+	})
+}
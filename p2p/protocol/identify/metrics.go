@@ -1,6 +1,8 @@
 package identify
 
 import (
+	"time"
+
 	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/p2p/metricshelper"
@@ -73,6 +75,45 @@ var (
 			Buckets:   buckets,
 		},
 	)
+	snapshotTruncated = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "snapshot_truncated_total",
+			Help:      "Number of times protocols or addresses were elided from our identify snapshot",
+		},
+		[]string{"field"},
+	)
+	snapshotElided = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Name:      "snapshot_elided",
+			Help:      "Number of protocols or addresses elided from our current identify snapshot",
+		},
+		[]string{"field"},
+	)
+	pushDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: metricNamespace,
+			Name:      "push_duration_seconds",
+			Help:      "Time taken to send an identify push",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 1.5, 20), // 1ms to ~2s
+		},
+	)
+	pushFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "push_failures_total",
+			Help:      "Identify pushes that failed",
+		},
+		[]string{"reason"},
+	)
+	pushesCoalesced = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "pushes_coalesced_total",
+			Help:      "Triggered pushes coalesced into an already pending push",
+		},
+	)
 	collectors = []prometheus.Collector{
 		pushesTriggered,
 		identify,
@@ -82,6 +123,11 @@ var (
 		addrsCount,
 		numProtocolsReceived,
 		numAddrsReceived,
+		snapshotTruncated,
+		snapshotElided,
+		pushDuration,
+		pushFailures,
+		pushesCoalesced,
 	}
 	// 1 to 20 and then up to 100 in steps of 5
 	buckets = append(
@@ -104,9 +150,35 @@ type MetricsTracer interface {
 	IdentifySent(isPush bool, numProtocols int, numAddrs int)
 }
 
+// SnapshotMetricsTracer is an optional interface implemented by
+// MetricsTracers that track the truncation of snapshots, see Limits.
+type SnapshotMetricsTracer interface {
+	// SnapshotUpdated tracks the number of protocols and addresses elided from
+	// a new snapshot
+	SnapshotUpdated(elidedProtocols int, elidedAddrs int)
+}
+
+// PushMetricsTracer is an optional interface implemented by MetricsTracers
+// that track the identify pushes sent to peers.
+type PushMetricsTracer interface {
+	// PushSent tracks the time taken to send an identify push
+	PushSent(d time.Duration)
+
+	// PushFailed counts identify pushes that failed, by the step that failed
+	PushFailed(reason string)
+
+	// PushesCoalesced counts triggered pushes that were coalesced into an
+	// already pending push
+	PushesCoalesced()
+}
+
 type metricsTracer struct{}
 
-var _ MetricsTracer = &metricsTracer{}
+var (
+	_ MetricsTracer         = &metricsTracer{}
+	_ SnapshotMetricsTracer = &metricsTracer{}
+	_ PushMetricsTracer     = &metricsTracer{}
+)
 
 type metricsTracerSetting struct {
 	reg prometheus.Registerer
@@ -141,6 +213,8 @@ func (t *metricsTracer) TriggeredPushes(ev any) {
 		typ = "protocols_updated"
 	case event.EvtLocalAddressesUpdated:
 		typ = "addresses_updated"
+	case event.EvtLocalPeerRecordUpdated:
+		typ = "peer_record_updated"
 	}
 	*tags = append(*tags, typ)
 	pushesTriggered.WithLabelValues(*tags...).Inc()
@@ -186,6 +260,38 @@ func (t *metricsTracer) IdentifyReceived(isPush bool, numProtocols int, numAddrs
 	numAddrsReceived.Observe(float64(numAddrs))
 }
 
+func (t *metricsTracer) SnapshotUpdated(elidedProtocols int, elidedAddrs int) {
+	tags := metricshelper.GetStringSlice()
+	defer metricshelper.PutStringSlice(tags)
+
+	for _, f := range []struct {
+		name   string
+		elided int
+	}{{"protocols", elidedProtocols}, {"addrs", elidedAddrs}} {
+		*tags = append((*tags)[:0], f.name)
+		if f.elided > 0 {
+			snapshotTruncated.WithLabelValues(*tags...).Inc()
+		}
+		snapshotElided.WithLabelValues(*tags...).Set(float64(f.elided))
+	}
+}
+
+func (t *metricsTracer) PushSent(d time.Duration) {
+	pushDuration.Observe(d.Seconds())
+}
+
+func (t *metricsTracer) PushFailed(reason string) {
+	tags := metricshelper.GetStringSlice()
+	defer metricshelper.PutStringSlice(tags)
+
+	*tags = append(*tags, reason)
+	pushFailures.WithLabelValues(*tags...).Inc()
+}
+
+func (t *metricsTracer) PushesCoalesced() {
+	pushesCoalesced.Inc()
+}
+
 func (t *metricsTracer) ConnPushSupport(support identifyPushSupport) {
 	tags := metricshelper.GetStringSlice()
 	defer metricshelper.PutStringSlice(tags)
@@ -5,6 +5,7 @@ package identify
 import (
 	"math/rand"
 	"testing"
+	"time"
 
 	"github.com/libp2p/go-libp2p/core/event"
 )
@@ -22,12 +23,18 @@ func TestMetricsNoAllocNoCover(t *testing.T) {
 		identifyPushUnsupported,
 	}
 
+	failures := []string{"stream", "write"}
+
 	tr := NewMetricsTracer()
 	tests := map[string]func(){
 		"TriggeredPushes":  func() { tr.TriggeredPushes(events[rand.Intn(len(events))]) },
 		"ConnPushSupport":  func() { tr.ConnPushSupport(pushSupport[rand.Intn(len(pushSupport))]) },
 		"IdentifyReceived": func() { tr.IdentifyReceived(rand.Intn(2) == 0, rand.Intn(20), rand.Intn(20)) },
 		"IdentifySent":     func() { tr.IdentifySent(rand.Intn(2) == 0, rand.Intn(20), rand.Intn(20)) },
+		"SnapshotUpdated":  func() { tr.(SnapshotMetricsTracer).SnapshotUpdated(rand.Intn(3), rand.Intn(3)) },
+		"PushSent":         func() { tr.(PushMetricsTracer).PushSent(time.Duration(rand.Intn(1000)) * time.Millisecond) },
+		"PushFailed":       func() { tr.(PushMetricsTracer).PushFailed(failures[rand.Intn(len(failures))]) },
+		"PushesCoalesced":  func() { tr.(PushMetricsTracer).PushesCoalesced() },
 	}
 	for method, f := range tests {
 		allocs := testing.AllocsPerRun(1000, f)
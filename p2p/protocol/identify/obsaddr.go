@@ -8,6 +8,7 @@ import (
 
 	"golang.org/x/exp/slices"
 
+	"github.com/benbjohnson/clock"
 	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
@@ -93,11 +94,14 @@ func (oa *observedAddr) groupKey() string {
 type newObservation struct {
 	conn     network.Conn
 	observed ma.Multiaddr
+	// fromTransport is true if the observation was reported by the transport
+	fromTransport bool
 }
 
 // ObservedAddrManager keeps track of a ObservedAddrs.
 type ObservedAddrManager struct {
-	host host.Host
+	host  host.Host
+	clock clock.Clock
 
 	closeOnce sync.Once
 	refCount  sync.WaitGroup
@@ -109,6 +113,8 @@ type ObservedAddrManager struct {
 	activeConnsMu sync.Mutex
 	// active connection -> most recent observation
 	activeConns map[network.Conn]ma.Multiaddr
+	// active connections with an observation reported by the transport
+	transportObservedConns map[network.Conn]struct{}
 
 	mu     sync.RWMutex
 	closed bool
@@ -131,12 +137,19 @@ type ObservedAddrManager struct {
 // NewObservedAddrManager returns a new address manager using
 // peerstore.OwnObservedAddressTTL as the TTL.
 func NewObservedAddrManager(host host.Host) (*ObservedAddrManager, error) {
+	return newObservedAddrManager(host, clock.New())
+}
+
+func newObservedAddrManager(host host.Host, cl clock.Clock) (*ObservedAddrManager, error) {
 	oas := &ObservedAddrManager{
+		clock:       cl,
 		addrs:       make(map[string][]*observedAddr),
 		ttl:         peerstore.OwnObservedAddrTTL,
 		wch:         make(chan newObservation, observedAddrManagerWorkerChannelSize),
 		host:        host,
 		activeConns: make(map[network.Conn]ma.Multiaddr),
+
+		transportObservedConns: make(map[network.Conn]struct{}),
 		// refresh every ttl/2 so we don't forget observations from connected peers
 		refreshTimer: time.NewTimer(peerstore.OwnObservedAddrTTL / 2),
 	}
@@ -196,7 +209,7 @@ func (oas *ObservedAddrManager) Addrs() []ma.Multiaddr {
 
 func (oas *ObservedAddrManager) filter(observedAddrs []*observedAddr) []ma.Multiaddr {
 	pmap := make(map[string][]*observedAddr)
-	now := time.Now()
+	now := oas.clock.Now()
 
 	for i := range observedAddrs {
 		a := observedAddrs[i]
@@ -247,6 +260,29 @@ func (oas *ObservedAddrManager) Record(conn network.Conn, observed ma.Multiaddr)
 	}
 }
 
+// RecordFromTransport records an address observation reported by the
+// transport of the connection (see network.ObservedAddrConn), if valid.
+// The transport has a more accurate view of the connection than identify,
+// so for the same connection, observations reported by the transport take
+// precedence over observations recorded using Record.
+//
+// Observations are recorded automatically for all connections that implement
+// network.ObservedAddrConn.
+func (oas *ObservedAddrManager) RecordFromTransport(conn network.Conn, observed ma.Multiaddr) {
+	select {
+	case oas.wch <- newObservation{
+		conn:          conn,
+		observed:      observed,
+		fromTransport: true,
+	}:
+	default:
+		log.Debugw("dropping address observation due to full buffer",
+			"from", conn.RemoteMultiaddr(),
+			"observed", observed,
+		)
+	}
+}
+
 func (oas *ObservedAddrManager) worker() {
 	defer oas.refCount.Done()
 
@@ -264,7 +300,7 @@ func (oas *ObservedAddrManager) worker() {
 			ev := evt.(event.EvtLocalReachabilityChanged)
 			oas.reachability = ev.Reachability
 		case obs := <-oas.wch:
-			oas.maybeRecordObservation(obs.conn, obs.observed)
+			oas.maybeRecordObservation(obs.conn, obs.observed, obs.fromTransport)
 		case <-ticker.C:
 			oas.gc()
 		case <-oas.refreshTimer.C:
@@ -299,7 +335,7 @@ func (oas *ObservedAddrManager) gc() {
 	oas.mu.Lock()
 	defer oas.mu.Unlock()
 
-	now := time.Now()
+	now := oas.clock.Now()
 	for local, observedAddrs := range oas.addrs {
 		filteredAddrs := observedAddrs[:0]
 		for _, a := range observedAddrs {
@@ -326,7 +362,7 @@ func (oas *ObservedAddrManager) gc() {
 	}
 }
 
-func (oas *ObservedAddrManager) addConn(conn network.Conn, observed ma.Multiaddr) {
+func (oas *ObservedAddrManager) addConn(conn network.Conn, observed ma.Multiaddr, fromTransport bool) {
 	oas.activeConnsMu.Lock()
 	defer oas.activeConnsMu.Unlock()
 
@@ -339,6 +375,9 @@ func (oas *ObservedAddrManager) addConn(conn network.Conn, observed ma.Multiaddr
 	for _, c := range oas.host.Network().ConnsToPeer(conn.RemotePeer()) {
 		if c == conn {
 			oas.activeConns[conn] = observed
+			if fromTransport {
+				oas.transportObservedConns[conn] = struct{}{}
+			}
 			return
 		}
 	}
@@ -353,6 +392,7 @@ func (oas *ObservedAddrManager) removeConn(conn network.Conn) {
 
 	oas.activeConnsMu.Lock()
 	delete(oas.activeConns, conn)
+	delete(oas.transportObservedConns, conn)
 	oas.activeConnsMu.Unlock()
 }
 
@@ -440,12 +480,23 @@ func shouldRecordObservation(host addrsProvider, network listenAddrsProvider, co
 	return true
 }
 
-func (oas *ObservedAddrManager) maybeRecordObservation(conn network.Conn, observed ma.Multiaddr) {
+func (oas *ObservedAddrManager) hasTransportObservation(conn network.Conn) bool {
+	oas.activeConnsMu.Lock()
+	defer oas.activeConnsMu.Unlock()
+	_, ok := oas.transportObservedConns[conn]
+	return ok
+}
+
+func (oas *ObservedAddrManager) maybeRecordObservation(conn network.Conn, observed ma.Multiaddr, fromTransport bool) {
+	if !fromTransport && oas.hasTransportObservation(conn) {
+		log.Debugw("ignoring observation, the transport already reported the observed addr", "from", conn.RemoteMultiaddr(), "observed", observed)
+		return
+	}
 	shouldRecord := shouldRecordObservation(oas.host, oas.host.Network(), conn, observed)
 	if shouldRecord {
 		// Ok, the observation is good, record it.
-		log.Debugw("added own observed listen addr", "observed", observed)
-		defer oas.addConn(conn, observed)
+		log.Debugw("added own observed listen addr", "observed", observed, "fromTransport", fromTransport)
+		defer oas.addConn(conn, observed, fromTransport)
 
 		oas.mu.Lock()
 		defer oas.mu.Unlock()
@@ -458,7 +509,7 @@ func (oas *ObservedAddrManager) maybeRecordObservation(conn network.Conn, observ
 }
 
 func (oas *ObservedAddrManager) recordObservationUnlocked(conn network.Conn, observed ma.Multiaddr) {
-	now := time.Now()
+	now := oas.clock.Now()
 	observerString := observerGroup(conn.RemoteMultiaddr())
 	localString := string(conn.LocalMultiaddr().Bytes())
 	ob := observation{
@@ -505,23 +556,18 @@ func (oas *ObservedAddrManager) recordObservationUnlocked(conn network.Conn, obs
 // With regards to RFC 3489, this could be either a Full Cone NAT, a Restricted Cone NAT or a
 // Port Restricted Cone NAT. However, we do NOT differentiate between them here and simply classify all such NATs as a Cone NAT.
 //
-// 2. If four different peers observe a different address for us on outbound connections, we
-// are MOST probably behind a Symmetric NAT.
+// 2. If four different peers observe a different address for the same local address on outbound
+// connections, the NAT is using an endpoint-dependent mapping, and we are MOST probably behind a Symmetric NAT.
 //
 // Please see the documentation on the enumerations for `network.NATDeviceType` for more details about these NAT Device types
 // and how they relate to NAT traversal via Hole Punching.
 func (oas *ObservedAddrManager) emitAllNATTypes() {
-	var allObserved []*observedAddr
-	for _, addrs := range oas.addrs {
-		allObserved = append(allObserved, addrs...)
-	}
-
-	hasChanged, natType := oas.emitSpecificNATType(allObserved, ma.P_TCP, network.NATTransportTCP, oas.currentTCPNATDeviceType)
+	hasChanged, natType := oas.emitSpecificNATType(ma.P_TCP, network.NATTransportTCP, oas.currentTCPNATDeviceType)
 	if hasChanged {
 		oas.currentTCPNATDeviceType = natType
 	}
 
-	hasChanged, natType = oas.emitSpecificNATType(allObserved, ma.P_UDP, network.NATTransportUDP, oas.currentUDPNATDeviceType)
+	hasChanged, natType = oas.emitSpecificNATType(ma.P_UDP, network.NATTransportUDP, oas.currentUDPNATDeviceType)
 	if hasChanged {
 		oas.currentUDPNATDeviceType = natType
 	}
@@ -529,54 +575,74 @@ func (oas *ObservedAddrManager) emitAllNATTypes() {
 
 // returns true along with the new NAT device type if the NAT device type for the given protocol has changed.
 // returns false otherwise.
-func (oas *ObservedAddrManager) emitSpecificNATType(addrs []*observedAddr, protoCode int, transportProto network.NATTransportProtocol,
+func (oas *ObservedAddrManager) emitSpecificNATType(protoCode int, transportProto network.NATTransportProtocol,
 	currentNATType network.NATDeviceType) (bool, network.NATDeviceType) {
-	now := time.Now()
-	seenBy := make(map[string]struct{})
-	cnt := 0
-
-	for _, oa := range addrs {
-		_, err := oa.addr.ValueForProtocol(protoCode)
-		if err != nil {
-			continue
-		}
+	natType := oas.classifyNAT(protoCode)
+	if natType == network.NATDeviceTypeUnknown || natType == currentNATType {
+		return false, 0
+	}
+	oas.emitNATDeviceTypeChanged.Emit(event.EvtNATDeviceTypeChanged{
+		TransportProtocol: transportProto,
+		NatDeviceType:     natType,
+	})
+	return true, natType
+}
 
-		// if we have an activated addresses, it's a Cone NAT.
-		if now.Sub(oa.lastSeen) <= oas.ttl && oa.activated() {
-			if currentNATType != network.NATDeviceTypeCone {
-				oas.emitNATDeviceTypeChanged.Emit(event.EvtNATDeviceTypeChanged{
-					TransportProtocol: transportProto,
-					NatDeviceType:     network.NATDeviceTypeCone,
-				})
-				return true, network.NATDeviceTypeCone
+// classifyNAT determines the NAT device type from the observations for the given protocol.
+// It must be called with the lock held.
+func (oas *ObservedAddrManager) classifyNAT(protoCode int) network.NATDeviceType {
+	now := oas.clock.Now()
+	var isSymmetric bool
+	for _, addrs := range oas.addrs {
+		seenBy := make(map[string]struct{})
+		cnt := 0
+		for _, oa := range addrs {
+			if _, err := oa.addr.ValueForProtocol(protoCode); err != nil {
+				continue
+			}
+			if now.Sub(oa.lastSeen) > oas.ttl {
+				continue
 			}
 
-			// our current NAT Device Type is already CONE, nothing to do here.
-			return false, 0
-		}
+			// if we have an activated addresses, it's a Cone NAT.
+			if oa.activated() {
+				return network.NATDeviceTypeCone
+			}
 
-		// An observed address on an outbound connection that has ONLY been seen by one peer
-		if now.Sub(oa.lastSeen) <= oas.ttl && oa.numInbound == 0 && len(oa.seenBy) == 1 {
-			cnt++
-			for s := range oa.seenBy {
-				seenBy[s] = struct{}{}
+			// An observed address on an outbound connection that has ONLY been seen by one peer
+			if oa.numInbound == 0 && len(oa.seenBy) == 1 {
+				cnt++
+				for s := range oa.seenBy {
+					seenBy[s] = struct{}{}
+				}
 			}
 		}
-	}
-
-	// If four different peers observe a different address for us on each of four outbound connections, we
-	// are MOST probably behind a Symmetric NAT.
-	if cnt >= ActivationThresh && len(seenBy) >= ActivationThresh {
-		if currentNATType != network.NATDeviceTypeSymmetric {
-			oas.emitNATDeviceTypeChanged.Emit(event.EvtNATDeviceTypeChanged{
-				TransportProtocol: transportProto,
-				NatDeviceType:     network.NATDeviceTypeSymmetric,
-			})
-			return true, network.NATDeviceTypeSymmetric
+		// If four different peers observe a different mapping of this local address on each of four
+		// outbound connections, the mapping is endpoint-dependent.
+		if cnt >= ActivationThresh && len(seenBy) >= ActivationThresh {
+			isSymmetric = true
 		}
 	}
+	if isSymmetric {
+		return network.NATDeviceTypeSymmetric
+	}
+	return network.NATDeviceTypeUnknown
+}
 
-	return false, 0
+// NATDeviceType returns the type of the NAT device we're behind for the given transport protocol,
+// as inferred from the observed addresses.
+// This is only meaningful if our reachability is private.
+func (oas *ObservedAddrManager) NATDeviceType(proto network.NATTransportProtocol) network.NATDeviceType {
+	oas.mu.RLock()
+	defer oas.mu.RUnlock()
+	switch proto {
+	case network.NATTransportTCP:
+		return oas.currentTCPNATDeviceType
+	case network.NATTransportUDP:
+		return oas.currentUDPNATDeviceType
+	default:
+		return network.NATDeviceTypeUnknown
+	}
 }
 
 func (oas *ObservedAddrManager) Close() error {
@@ -634,7 +700,17 @@ type obsAddrNotifiee ObservedAddrManager
 
 func (on *obsAddrNotifiee) Listen(n network.Network, a ma.Multiaddr)      {}
 func (on *obsAddrNotifiee) ListenClose(n network.Network, a ma.Multiaddr) {}
-func (on *obsAddrNotifiee) Connected(n network.Network, v network.Conn)   {}
+func (on *obsAddrNotifiee) Connected(n network.Network, v network.Conn) {
+	oc, ok := v.(network.ObservedAddrConn)
+	if !ok {
+		return
+	}
+	oas := (*ObservedAddrManager)(on)
+	// RecordFromTransport doesn't block. Observations reported after Close are dropped.
+	_ = oc.NotifyObservedAddr(func(observed ma.Multiaddr) {
+		oas.RecordFromTransport(v, observed)
+	})
+}
 func (on *obsAddrNotifiee) Disconnected(n network.Network, v network.Conn) {
 	(*ObservedAddrManager)(on).removeConn(v)
 }
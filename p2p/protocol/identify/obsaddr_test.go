@@ -369,6 +369,54 @@ func TestEmitNATDeviceTypeSymmetric(t *testing.T) {
 	case <-time.After(5 * time.Second):
 		t.Fatal("did not get Symmetric NAT event")
 	}
+	require.Equal(t, network.NATDeviceTypeSymmetric, harness.oas.NATDeviceType(network.NATTransportTCP))
+	require.Equal(t, network.NATDeviceTypeUnknown, harness.oas.NATDeviceType(network.NATTransportUDP))
+}
+
+// localAddrConn overrides the local address of a connection.
+type localAddrConn struct {
+	network.Conn
+	local ma.Multiaddr
+}
+
+func (c *localAddrConn) LocalMultiaddr() ma.Multiaddr { return c.local }
+
+func TestNATDeviceTypeNotSymmetricAcrossLocalAddrs(t *testing.T) {
+	harness := newHarness(t)
+	emitter, err := harness.host.EventBus().Emitter(new(event.EvtLocalReachabilityChanged), eventbus.Stateful)
+	require.NoError(t, err)
+	require.NoError(t, emitter.Emit(event.EvtLocalReachabilityChanged{Reachability: network.ReachabilityPrivate}))
+
+	// Every observer sees a different mapping, but each of them observes a different local address.
+	locals := []ma.Multiaddr{
+		ma.StringCast("/ip4/127.0.0.1/tcp/10086"),
+		ma.StringCast("/ip4/127.0.0.1/tcp/10087"),
+		ma.StringCast("/ip4/127.0.0.1/tcp/10088"),
+		ma.StringCast("/ip4/127.0.0.1/tcp/10089"),
+	}
+	require.NoError(t, harness.host.Network().Listen(locals...))
+
+	observed := []ma.Multiaddr{
+		ma.StringCast("/ip4/1.2.3.4/tcp/1231"),
+		ma.StringCast("/ip4/1.2.3.4/tcp/1232"),
+		ma.StringCast("/ip4/1.2.3.4/tcp/1233"),
+		ma.StringCast("/ip4/1.2.3.4/tcp/1234"),
+	}
+	observers := []ma.Multiaddr{
+		ma.StringCast("/ip4/1.2.3.6/tcp/1236"),
+		ma.StringCast("/ip4/1.2.3.7/tcp/1237"),
+		ma.StringCast("/ip4/1.2.3.8/tcp/1237"),
+		ma.StringCast("/ip4/1.2.3.9/tcp/1237"),
+	}
+	for i := range observers {
+		c := harness.conn(harness.add(observers[i]))
+		harness.oas.Record(&localAddrConn{Conn: c, local: locals[i]}, observed[i])
+	}
+	time.Sleep(200 * time.Millisecond) // let the worker run
+	for i, l := range locals {
+		require.Len(t, harness.oas.AddrsFor(l), 0, "observation %d shouldn't be activated", i)
+	}
+	require.Equal(t, network.NATDeviceTypeUnknown, harness.oas.NATDeviceType(network.NATTransportTCP))
 }
 
 func TestEmitNATDeviceTypeCone(t *testing.T) {
@@ -410,6 +458,7 @@ func TestEmitNATDeviceTypeCone(t *testing.T) {
 	case <-time.After(5 * time.Second):
 		t.Fatal("did not get Cone NAT event")
 	}
+	require.Equal(t, network.NATDeviceTypeCone, harness.oas.NATDeviceType(network.NATTransportTCP))
 }
 
 func TestObserveWebtransport(t *testing.T) {
@@ -433,3 +482,29 @@ func TestObserveWebtransport(t *testing.T) {
 	require.Len(t, harness.oas.Addrs(), 1)
 	require.Equal(t, "/ip4/1.2.3.4/udp/1231/quic-v1/webtransport", harness.oas.Addrs()[0].String())
 }
+
+func TestObservationsFromTransportTakePrecedence(t *testing.T) {
+	listenAddr := ma.StringCast("/ip4/1.2.3.4/udp/9999/quic-v1")
+	harness := newHarnessWithMa(t, listenAddr)
+
+	fromTransport := ma.StringCast("/ip4/1.2.3.4/udp/1231/quic-v1")
+	fromIdentify := ma.StringCast("/ip4/1.2.3.4/udp/1232/quic-v1")
+	observers := []ma.Multiaddr{
+		ma.StringCast("/ip4/1.2.3.6/udp/1236/quic-v1"),
+		ma.StringCast("/ip4/1.2.3.7/udp/1237/quic-v1"),
+		ma.StringCast("/ip4/1.2.3.8/udp/1237/quic-v1"),
+		ma.StringCast("/ip4/1.2.3.9/udp/1237/quic-v1"),
+	}
+	for _, o := range observers {
+		c := harness.conn(harness.add(o))
+		harness.oas.RecordFromTransport(c, fromTransport)
+		time.Sleep(50 * time.Millisecond) // let the worker run
+		// identify reports a different address for the same connection
+		harness.oas.Record(c, fromIdentify)
+	}
+	time.Sleep(200 * time.Millisecond) // let the worker run
+
+	addrs := harness.oas.Addrs()
+	require.Len(t, addrs, 1)
+	require.True(t, addrs[0].Equal(fromTransport))
+}
@@ -1,10 +1,29 @@
 package identify
 
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"github.com/benbjohnson/clock"
+)
+
 type config struct {
 	protocolVersion         string
 	userAgent               string
 	disableSignedPeerRecord bool
 	metricsTracer           MetricsTracer
+	limits                  Limits
+	pushConcurrency         int
+	pushRate                float64
+	pushCoalesceTime        time.Duration
+	serviceName             string
+	reservationPriority     uint8
+	disablePush             bool
+	disableObservedAddrs    bool
+	readOnly                bool
+	fastIdentify            bool
+	clock                   clock.Clock
 }
 
 // Option is an option function for identify.
@@ -25,6 +44,14 @@ func UserAgent(ua string) Option {
 	}
 }
 
+// WithClock sets the clock used to timestamp observed addresses and
+// handshakes.
+func WithClock(cl clock.Clock) Option {
+	return func(cfg *config) {
+		cfg.clock = cl
+	}
+}
+
 // DisableSignedPeerRecord disables populating signed peer records on the outgoing Identify response
 // and ONLY sends the unsigned addresses.
 func DisableSignedPeerRecord() Option {
@@ -38,3 +65,91 @@ func WithMetricsTracer(tr MetricsTracer) Option {
 		cfg.metricsTracer = tr
 	}
 }
+
+// WithLimits sets the limits of the identify messages we send. See Limits for
+// how protocols and addresses are truncated.
+func WithLimits(l Limits) Option {
+	return func(cfg *config) {
+		cfg.limits = l
+	}
+}
+
+// WithPushConcurrency sets the maximum number of identify pushes sent
+// concurrently when our addresses or protocols change.
+func WithPushConcurrency(n int) Option {
+	return func(cfg *config) {
+		cfg.pushConcurrency = n
+	}
+}
+
+// WithPushRate sets the maximum number of identify pushes started per second.
+// If 0, the rate is not limited.
+func WithPushRate(perSecond float64) Option {
+	return func(cfg *config) {
+		cfg.pushRate = perSecond
+	}
+}
+
+// WithPushCoalesceTime sets the time we wait after a change of our addresses
+// or protocols before sending pushes, so that rapid successive changes are
+// sent in a single push.
+func WithPushCoalesceTime(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.pushCoalesceTime = d
+	}
+}
+
+// WithServiceScope attaches identify streams to the resource manager service
+// scope named service instead of ServiceName, and uses priority for their
+// memory reservations. This allows limiting identify independently from the
+// other services. A priority of 0 means network.ReservationPriorityAlways.
+func WithServiceScope(service string, priority uint8) Option {
+	return func(cfg *config) {
+		if priority == 0 {
+			priority = network.ReservationPriorityAlways
+		}
+		cfg.serviceName = service
+		cfg.reservationPriority = priority
+	}
+}
+
+// DisablePush disables identify push. We neither push our own updates to
+// other peers, nor accept pushes from them. Identify requests are still sent
+// and answered.
+func DisablePush() Option {
+	return func(cfg *config) {
+		cfg.disablePush = true
+	}
+}
+
+// DisableObservedAddrs disables recording the addresses other peers observe
+// us at. Our own addresses are then never derived from what peers report.
+func DisableObservedAddrs() Option {
+	return func(cfg *config) {
+		cfg.disableObservedAddrs = true
+	}
+}
+
+// ReadOnly runs identify in read-only mode: identify requests are answered,
+// but addresses sent by other peers are never recorded, neither their listen
+// addresses and signed peer records, nor the addresses they observe us at.
+// This is meant for security-sensitive nodes that must not let other peers
+// influence their address book. Protocols and agent versions are still
+// recorded.
+func ReadOnly() Option {
+	return func(cfg *config) {
+		cfg.readOnly = true
+	}
+}
+
+// FastIdentify enables fast identify. A snapshot of our identify message is
+// made available to the security transports (see HandshakeSnapshotProvider),
+// which embed it in the handshake. Snapshots received in the handshake are
+// consumed as soon as the connection is established, so that IdentifyWait
+// returns without waiting for the identify round trip. Identify is still run
+// on the connection to learn the address the peer observes us at.
+func FastIdentify() Option {
+	return func(cfg *config) {
+		cfg.fastIdentify = true
+	}
+}
@@ -0,0 +1,53 @@
+package msgio
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// A Codec serializes messages.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	// Unmarshal deserializes data into v.
+	// It must not retain data: the buffer is pooled, and reused after Unmarshal returns.
+	Unmarshal(data []byte, v any) error
+}
+
+// Protobuf is the Codec for protobuf messages. Messages must implement proto.Message.
+var Protobuf Codec = protobufCodec{}
+
+type protobufCodec struct{}
+
+func (protobufCodec) Marshal(v any) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec: %T is not a proto.Message", v)
+	}
+	return proto.Marshal(msg)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v any) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec: %T is not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+// NewCodec creates a Codec from a pair of marshal and unmarshal functions.
+// As with Codec.Unmarshal, unmarshal must not retain the data passed to it.
+// This makes it easy to use any serialization library, for example, for CBOR:
+//
+//	codec := msgio.NewCodec(cbor.Marshal, cbor.Unmarshal)
+func NewCodec(marshal func(v any) ([]byte, error), unmarshal func(data []byte, v any) error) Codec {
+	return &funcCodec{marshal: marshal, unmarshal: unmarshal}
+}
+
+type funcCodec struct {
+	marshal   func(v any) ([]byte, error)
+	unmarshal func(data []byte, v any) error
+}
+
+func (c *funcCodec) Marshal(v any) ([]byte, error)      { return c.marshal(v) }
+func (c *funcCodec) Unmarshal(data []byte, v any) error { return c.unmarshal(data, v) }
@@ -0,0 +1,198 @@
+// Package msgio standardizes the exchange of length-delimited messages over streams.
+//
+// Every message is prefixed with its length (as an uvarint). This is the same
+// framing as used by go-msgio's pbio, so peers using either implementation can
+// talk to each other.
+//
+// Both the Reader and the Writer enforce a maximum message size. When used on
+// a network.Stream, they also reserve the memory for every message with the
+// stream's resource scope, and release it once the message has been processed.
+// Messages are serialized using a Codec (see Protobuf and NewCodec).
+package msgio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	pool "github.com/libp2p/go-buffer-pool"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/multiformats/go-varint"
+)
+
+// ErrMsgTooLarge is returned when reading or writing a message larger than the maximum message size.
+var ErrMsgTooLarge = errors.New("message too large")
+
+type config struct {
+	reservationPriority uint8
+}
+
+type Option func(*config) error
+
+// WithReservationPriority sets the priority used when reserving memory for a message.
+// Defaults to network.ReservationPriorityAlways.
+func WithReservationPriority(prio uint8) Option {
+	return func(c *config) error {
+		c.reservationPriority = prio
+		return nil
+	}
+}
+
+func newConfig(maxMsgSize int, opts []Option) (*config, error) {
+	if maxMsgSize <= 0 {
+		return nil, errors.New("max message size must be positive")
+	}
+	cfg := &config{reservationPriority: network.ReservationPriorityAlways}
+	for _, opt := range opts {
+		if err := opt(cfg); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// scopeOf returns the resource scope of s, if s is a network.Stream.
+func scopeOf(s any) network.ResourceScope {
+	if str, ok := s.(interface{ Scope() network.StreamScope }); ok {
+		return str.Scope()
+	}
+	return nil
+}
+
+// A Reader reads length-delimited messages.
+//
+// The Reader doesn't buffer: it never consumes more data from the underlying
+// reader than the message it is reading. This makes it safe to switch to a
+// different protocol on the same stream after reading a message.
+type Reader struct {
+	r          io.Reader
+	scope      network.ResourceScope
+	codec      Codec
+	maxMsgSize int
+	cfg        *config
+
+	br byteReader
+}
+
+// byteReader reads single bytes from an io.Reader, without buffering.
+type byteReader struct {
+	r io.Reader
+	b [1]byte
+}
+
+func (br *byteReader) ReadByte() (byte, error) {
+	if _, err := io.ReadFull(br.r, br.b[:]); err != nil {
+		return 0, err
+	}
+	return br.b[0], nil
+}
+
+// NewReader creates a Reader that reads messages of up to maxMsgSize bytes from r.
+func NewReader(r io.Reader, codec Codec, maxMsgSize int, opts ...Option) (*Reader, error) {
+	cfg, err := newConfig(maxMsgSize, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{
+		r:          r,
+		scope:      scopeOf(r),
+		codec:      codec,
+		maxMsgSize: maxMsgSize,
+		cfg:        cfg,
+		br:         byteReader{r: r},
+	}, nil
+}
+
+// ReadMsg reads the next message, and unmarshals it into v.
+func (r *Reader) ReadMsg(v any) error {
+	length, err := varint.ReadUvarint(&r.br)
+	if err != nil {
+		return err
+	}
+	if length > uint64(r.maxMsgSize) {
+		return fmt.Errorf("%w: %d bytes (max: %d)", ErrMsgTooLarge, length, r.maxMsgSize)
+	}
+
+	if r.scope != nil {
+		if err := r.scope.ReserveMemory(int(length), r.cfg.reservationPriority); err != nil {
+			return err
+		}
+		defer r.scope.ReleaseMemory(int(length))
+	}
+
+	buf := pool.Get(int(length))
+	defer pool.Put(buf)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return err
+	}
+	return r.codec.Unmarshal(buf, v)
+}
+
+// A Writer writes length-delimited messages.
+type Writer struct {
+	w          io.Writer
+	scope      network.ResourceScope
+	codec      Codec
+	maxMsgSize int
+	cfg        *config
+}
+
+// NewWriter creates a Writer that writes messages of up to maxMsgSize bytes to w.
+func NewWriter(w io.Writer, codec Codec, maxMsgSize int, opts ...Option) (*Writer, error) {
+	cfg, err := newConfig(maxMsgSize, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{
+		w:          w,
+		scope:      scopeOf(w),
+		codec:      codec,
+		maxMsgSize: maxMsgSize,
+		cfg:        cfg,
+	}, nil
+}
+
+// WriteMsg marshals v, and writes it as a single message.
+func (w *Writer) WriteMsg(v any) error {
+	data, err := w.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(data) > w.maxMsgSize {
+		return fmt.Errorf("%w: %d bytes (max: %d)", ErrMsgTooLarge, len(data), w.maxMsgSize)
+	}
+
+	size := varint.UvarintSize(uint64(len(data))) + len(data)
+	if w.scope != nil {
+		if err := w.scope.ReserveMemory(size, w.cfg.reservationPriority); err != nil {
+			return err
+		}
+		defer w.scope.ReleaseMemory(size)
+	}
+
+	buf := pool.Get(size)
+	defer pool.Put(buf)
+	n := varint.PutUvarint(buf, uint64(len(data)))
+	copy(buf[n:], data)
+	_, err = w.w.Write(buf)
+	return err
+}
+
+// A ReadWriter reads and writes length-delimited messages.
+type ReadWriter struct {
+	*Reader
+	*Writer
+}
+
+// NewReadWriter creates a ReadWriter for rw. This is useful to exchange messages on a network.Stream.
+func NewReadWriter(rw io.ReadWriter, codec Codec, maxMsgSize int, opts ...Option) (*ReadWriter, error) {
+	r, err := NewReader(rw, codec, maxMsgSize, opts...)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWriter(rw, codec, maxMsgSize, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &ReadWriter{Reader: r, Writer: w}, nil
+}
@@ -0,0 +1,132 @@
+package msgio
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify/pb"
+	"github.com/libp2p/go-msgio/pbio"
+
+	"github.com/stretchr/testify/require"
+)
+
+type mockScope struct {
+	network.StreamScope
+
+	fail     bool
+	reserved int
+	peak     int
+}
+
+func (s *mockScope) ReserveMemory(size int, _ uint8) error {
+	if s.fail {
+		return network.ErrResourceLimitExceeded
+	}
+	s.reserved += size
+	s.peak = max(s.peak, s.reserved)
+	return nil
+}
+
+func (s *mockScope) ReleaseMemory(size int) { s.reserved -= size }
+
+type mockStream struct {
+	bytes.Buffer
+	scope *mockScope
+}
+
+func (s *mockStream) Scope() network.StreamScope { return s.scope }
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	rw, err := NewReadWriter(&buf, Protobuf, 1024)
+	require.NoError(t, err)
+
+	for _, v := range []string{"foo", "bar", "baz"} {
+		require.NoError(t, rw.WriteMsg(&pb.Identify{AgentVersion: &v}))
+	}
+	for _, v := range []string{"foo", "bar", "baz"} {
+		var msg pb.Identify
+		require.NoError(t, rw.ReadMsg(&msg))
+		require.Equal(t, v, msg.GetAgentVersion())
+	}
+	require.Zero(t, buf.Len())
+}
+
+func TestPbioCompatibility(t *testing.T) {
+	agent := "agent"
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, Protobuf, 1024)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteMsg(&pb.Identify{AgentVersion: &agent}))
+	var msg pb.Identify
+	require.NoError(t, pbio.NewDelimitedReader(&buf, 1024).ReadMsg(&msg))
+	require.Equal(t, agent, msg.GetAgentVersion())
+
+	require.NoError(t, pbio.NewDelimitedWriter(&buf).WriteMsg(&pb.Identify{AgentVersion: &agent}))
+	r, err := NewReader(&buf, Protobuf, 1024)
+	require.NoError(t, err)
+	msg.Reset()
+	require.NoError(t, r.ReadMsg(&msg))
+	require.Equal(t, agent, msg.GetAgentVersion())
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	large := string(make([]byte, 100))
+	var buf bytes.Buffer
+
+	w, err := NewWriter(&buf, Protobuf, 50)
+	require.NoError(t, err)
+	require.ErrorIs(t, w.WriteMsg(&pb.Identify{AgentVersion: &large}), ErrMsgTooLarge)
+	require.Zero(t, buf.Len())
+
+	w, err = NewWriter(&buf, Protobuf, 1024)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteMsg(&pb.Identify{AgentVersion: &large}))
+	r, err := NewReader(&buf, Protobuf, 50)
+	require.NoError(t, err)
+	require.ErrorIs(t, r.ReadMsg(&pb.Identify{}), ErrMsgTooLarge)
+}
+
+func TestMemoryReservation(t *testing.T) {
+	scope := &mockScope{}
+	str := &mockStream{scope: scope}
+	rw, err := NewReadWriter(str, Protobuf, 1024)
+	require.NoError(t, err)
+
+	agent := "agent"
+	require.NoError(t, rw.WriteMsg(&pb.Identify{AgentVersion: &agent}))
+	msgLen := str.Len()
+	require.Equal(t, msgLen, scope.peak)
+	require.Zero(t, scope.reserved)
+
+	scope.peak = 0
+	require.NoError(t, rw.ReadMsg(&pb.Identify{}))
+	require.Equal(t, msgLen-1, scope.peak) // the length prefix is not part of the reservation
+	require.Zero(t, scope.reserved)
+
+	scope.fail = true
+	require.ErrorIs(t, rw.WriteMsg(&pb.Identify{AgentVersion: &agent}), network.ErrResourceLimitExceeded)
+	require.Zero(t, str.Len())
+}
+
+func TestCustomCodec(t *testing.T) {
+	type msg struct{ Foo string }
+
+	var buf bytes.Buffer
+	rw, err := NewReadWriter(&buf, NewCodec(json.Marshal, json.Unmarshal), 1024)
+	require.NoError(t, err)
+	require.NoError(t, rw.WriteMsg(&msg{Foo: "bar"}))
+	var m msg
+	require.NoError(t, rw.ReadMsg(&m))
+	require.Equal(t, "bar", m.Foo)
+
+	require.Error(t, rw.WriteMsg(make(chan int)))
+}
+
+func TestProtobufCodecRejectsNonProto(t *testing.T) {
+	_, err := Protobuf.Marshal("foobar")
+	require.Error(t, err)
+	require.Error(t, Protobuf.Unmarshal(nil, "foobar"))
+}
@@ -15,6 +15,11 @@ import (
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	pstore "github.com/libp2p/go-libp2p/p2p/host/peerstore"
+
+	msmux "github.com/multiformats/go-multistream"
 )
 
 var log = logging.Logger("ping")
@@ -30,22 +35,49 @@ const (
 
 type PingService struct {
 	Host host.Host
+
+	serviceName         string
+	reservationPriority uint8
+}
+
+// Option is an option for the PingService.
+type Option func(*PingService)
+
+// WithServiceScope attaches inbound ping streams to the resource manager
+// service scope named service instead of ServiceName, and uses priority for
+// their memory reservations. This allows limiting ping independently from the
+// other services. A priority of 0 means network.ReservationPriorityAlways.
+func WithServiceScope(service string, priority uint8) Option {
+	return func(ps *PingService) {
+		if priority == 0 {
+			priority = network.ReservationPriorityAlways
+		}
+		ps.serviceName = service
+		ps.reservationPriority = priority
+	}
 }
 
-func NewPingService(h host.Host) *PingService {
-	ps := &PingService{h}
+func NewPingService(h host.Host, opts ...Option) *PingService {
+	ps := &PingService{Host: h}
+	for _, opt := range opts {
+		opt(ps)
+	}
 	h.SetStreamHandler(ID, ps.PingHandler)
 	return ps
 }
 
 func (p *PingService) PingHandler(s network.Stream) {
-	if err := s.Scope().SetService(ServiceName); err != nil {
+	service, priority := ServiceName, network.ReservationPriorityAlways
+	if p.serviceName != "" {
+		service, priority = p.serviceName, p.reservationPriority
+	}
+	if err := s.Scope().SetService(service); err != nil {
 		log.Debugf("error attaching stream to ping service: %s", err)
 		s.Reset()
 		return
 	}
 
-	if err := s.Scope().ReserveMemory(PingSize, network.ReservationPriorityAlways); err != nil {
+	if err := s.Scope().ReserveMemory(PingSize, priority); err != nil {
 		log.Debugf("error reserving memory for ping stream: %s", err)
 		s.Reset()
 		return
@@ -129,6 +161,7 @@ func Ping(ctx context.Context, h host.Host, p peer.ID) <-chan Result {
 		return pingError(err)
 	}
 	ra := mrand.New(mrand.NewSource(int64(binary.BigEndian.Uint64(b))))
+	transport := pstore.LatencyTransport(s.Conn().RemoteMultiaddr())
 
 	ctx, cancel := context.WithCancel(ctx)
 
@@ -148,7 +181,11 @@ func Ping(ctx context.Context, h host.Host, p peer.ID) <-chan Result {
 
 			// No error, record the RTT.
 			if res.Error == nil {
-				h.Peerstore().RecordLatency(p, res.RTT)
+				if lb, ok := peerstore.GetTransportLatencyBook(h.Peerstore()); ok {
+					lb.RecordTransportLatency(p, transport, res.RTT)
+				} else {
+					h.Peerstore().RecordLatency(p, res.RTT)
+				}
 			}
 
 			select {
@@ -166,6 +203,43 @@ func Ping(ctx context.Context, h host.Host, p peer.ID) <-chan Result {
 	return out
 }
 
+// PingConn sends a single ping on the connection c, and returns the RTT.
+// Unlike Ping, it doesn't record the latency in the peerstore, since the
+// RTT of a single connection may differ from the RTT to the peer.
+func PingConn(ctx context.Context, c network.Conn) (time.Duration, error) {
+	s, err := c.NewStream(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer s.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { s.Reset() })
+	defer stop()
+
+	if err := s.SetProtocol(ID); err != nil {
+		s.Reset()
+		return 0, err
+	}
+	if err := s.Scope().SetService(ServiceName); err != nil {
+		log.Debugf("error attaching stream to ping service: %s", err)
+		s.Reset()
+		return 0, err
+	}
+	if err := msmux.SelectProtoOrFail(protocol.ID(ID), s); err != nil {
+		s.Reset()
+		return 0, err
+	}
+	rtt, err := ping(s, rand.Reader)
+	if err != nil {
+		s.Reset()
+		return 0, err
+	}
+	return rtt, nil
+}
+
 func ping(s network.Stream, randReader io.Reader) (time.Duration, error) {
 	if err := s.Scope().ReserveMemory(2*PingSize, network.ReservationPriorityAlways); err != nil {
 		log.Debugf("error reserving memory for ping stream: %s", err)
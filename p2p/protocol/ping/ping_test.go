@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
 	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
 	swarmt "github.com/libp2p/go-libp2p/p2p/net/swarm/testing"
 	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
@@ -55,3 +56,47 @@ func testPing(t *testing.T, ps *ping.PingService, p peer.ID) {
 	}
 
 }
+
+func TestPingConn(t *testing.T) {
+	h1, err := bhost.NewHost(swarmt.GenSwarm(t), nil)
+	require.NoError(t, err)
+	defer h1.Close()
+	h1.Start()
+	h2, err := bhost.NewHost(swarmt.GenSwarm(t), nil)
+	require.NoError(t, err)
+	defer h2.Close()
+	h2.Start()
+	ping.NewPingService(h2)
+
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+	conns := h1.Network().ConnsToPeer(h2.ID())
+	require.NotEmpty(t, conns)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rtt, err := ping.PingConn(ctx, conns[0])
+	require.NoError(t, err)
+	require.Greater(t, rtt, time.Duration(0))
+}
+
+func TestPingRecordsTransportLatency(t *testing.T) {
+	h1, err := bhost.NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h1.Close()
+	h1.Start()
+	h2, err := bhost.NewHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC), nil)
+	require.NoError(t, err)
+	defer h2.Close()
+	h2.Start()
+
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+	ping.NewPingService(h2)
+	testPing(t, ping.NewPingService(h1), h2.ID())
+
+	lb, ok := peerstore.GetTransportLatencyBook(h1.Peerstore())
+	require.True(t, ok)
+	stats := lb.LatencyStats(h2.ID())
+	require.Contains(t, stats, "tcp")
+	require.GreaterOrEqual(t, stats["tcp"].Samples, 5)
+	require.Greater(t, stats["tcp"].P50, time.Duration(0))
+}
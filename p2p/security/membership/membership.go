@@ -0,0 +1,198 @@
+// Package membership implements certificates that admit peers to a
+// permissioned network.
+//
+// A network is defined by an authority key. The authority issues every member
+// a Certificate for its peer ID, and members present their certificate during
+// the security handshake (see the WithMembership options of the Noise and TLS
+// security transports). Connections to and from peers that don't present a
+// valid certificate for the same network are rejected before the stream muxer
+// is set up.
+//
+// The QUIC, WebTransport and WebRTC transports run their own handshakes, and
+// take a WithMembership option as well. It has to be passed to every
+// transport that is enabled, e.g.
+//
+//	libp2p.Transport(libp2pquic.NewTransport, libp2pquic.WithMembership(m))
+package membership
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/libp2p/go-libp2p/p2p/security/membership/pb"
+
+	"google.golang.org/protobuf/proto"
+)
+
+//go:generate protoc --go_out=. --go_opt=Mpb/membership.proto=./pb pb/membership.proto
+
+var _ record.Record = (*Certificate)(nil)
+
+func init() {
+	record.RegisterType(&Certificate{})
+}
+
+// CertificateEnvelopeDomain is the domain string used for membership certificates contained in an Envelope.
+const CertificateEnvelopeDomain = "libp2p-network-membership"
+
+// CertificateEnvelopePayloadType is the type hint used to identify membership certificates in an Envelope.
+// This is the varint encoding of 0x300001, in the private use range of the multicodec table.
+var CertificateEnvelopePayloadType = []byte{0x81, 0x80, 0xc0, 0x01}
+
+// ErrNoCertificate is returned when a peer didn't present a membership certificate.
+var ErrNoCertificate = errors.New("peer did not present a membership certificate")
+
+// Certificate admits Peer to Network until NotAfter.
+// It is signed by the network authority using Issue.
+type Certificate struct {
+	Network  string
+	Peer     peer.ID
+	NotAfter time.Time
+}
+
+// Domain is used when signing and validating Certificates contained in Envelopes.
+// It is constant for all Certificate instances.
+func (c *Certificate) Domain() string {
+	return CertificateEnvelopeDomain
+}
+
+// Codec is a binary identifier for the Certificate type. It is constant for all Certificate instances.
+func (c *Certificate) Codec() []byte {
+	return CertificateEnvelopePayloadType
+}
+
+// UnmarshalRecord parses a Certificate from a byte slice.
+func (c *Certificate) UnmarshalRecord(b []byte) error {
+	if c == nil {
+		return fmt.Errorf("cannot unmarshal Certificate to nil receiver")
+	}
+	var msg pb.MembershipCertificate
+	if err := proto.Unmarshal(b, &msg); err != nil {
+		return err
+	}
+	var id peer.ID
+	if err := id.UnmarshalBinary(msg.PeerId); err != nil {
+		return err
+	}
+	if msg.NotAfter == 0 {
+		return errors.New("missing expiry")
+	}
+	*c = Certificate{
+		Network:  msg.Network,
+		Peer:     id,
+		NotAfter: time.Unix(0, int64(msg.NotAfter)),
+	}
+	return nil
+}
+
+// MarshalRecord serializes a Certificate to a byte slice.
+func (c *Certificate) MarshalRecord() ([]byte, error) {
+	idBytes, err := c.Peer.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	if c.NotAfter.IsZero() {
+		return nil, errors.New("missing expiry")
+	}
+	return proto.Marshal(&pb.MembershipCertificate{
+		Network:  c.Network,
+		PeerId:   idBytes,
+		NotAfter: uint64(c.NotAfter.UnixNano()),
+	})
+}
+
+// Issue signs cert with the key of the network authority, and returns the
+// certificate in the format expected by New and presented in the handshake.
+func Issue(authority crypto.PrivKey, cert *Certificate) ([]byte, error) {
+	env, err := record.Seal(cert, authority)
+	if err != nil {
+		return nil, err
+	}
+	return env.Marshal()
+}
+
+// Option is an option for a Membership.
+type Option func(*Membership) error
+
+// WithClock sets the clock used to check the expiry of certificates.
+func WithClock(cl clock.Clock) Option {
+	return func(m *Membership) error {
+		m.clock = cl
+		return nil
+	}
+}
+
+// Membership holds the certificate of the local peer, and verifies the
+// certificates of remote peers.
+type Membership struct {
+	authority crypto.PubKey
+	network   string
+	cert      []byte
+	clock     clock.Clock
+}
+
+// New creates a Membership of the network of the authority, presenting cert
+// (as created by Issue) to remote peers. Remote peers are only accepted if
+// they present a certificate for the same network, signed by the same
+// authority.
+func New(authority crypto.PubKey, cert []byte, opts ...Option) (*Membership, error) {
+	m := &Membership{
+		authority: authority,
+		cert:      cert,
+		clock:     clock.New(),
+	}
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+	c, err := m.open(cert)
+	if err != nil {
+		return nil, fmt.Errorf("invalid local certificate: %w", err)
+	}
+	m.network = c.Network
+	return m, nil
+}
+
+// Certificate returns the certificate of the local peer.
+func (m *Membership) Certificate() []byte {
+	return m.cert
+}
+
+// Verify checks that cert is a valid membership certificate for p.
+func (m *Membership) Verify(p peer.ID, cert []byte) error {
+	if len(cert) == 0 {
+		return ErrNoCertificate
+	}
+	c, err := m.open(cert)
+	if err != nil {
+		return fmt.Errorf("invalid membership certificate: %w", err)
+	}
+	if c.Network != m.network {
+		return fmt.Errorf("membership certificate is for network %q, expected %q", c.Network, m.network)
+	}
+	if c.Peer != p {
+		return fmt.Errorf("membership certificate is for peer %s, not %s", c.Peer, p)
+	}
+	if now := m.clock.Now(); now.After(c.NotAfter) {
+		return fmt.Errorf("membership certificate of %s expired at %s", p, c.NotAfter)
+	}
+	return nil
+}
+
+func (m *Membership) open(cert []byte) (*Certificate, error) {
+	var c Certificate
+	env, err := record.ConsumeTypedEnvelope(cert, &c)
+	if err != nil {
+		return nil, err
+	}
+	if !env.PublicKey.Equals(m.authority) {
+		return nil, errors.New("not signed by the network authority")
+	}
+	return &c, nil
+}
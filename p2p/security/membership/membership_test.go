@@ -0,0 +1,57 @@
+package membership
+
+import (
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/test"
+
+	"github.com/stretchr/testify/require"
+)
+
+func issue(t *testing.T, authority crypto.PrivKey, c *Certificate) []byte {
+	t.Helper()
+	cert, err := Issue(authority, c)
+	require.NoError(t, err)
+	return cert
+}
+
+func TestVerify(t *testing.T) {
+	authority, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	other, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	cl := clock.NewMock()
+	expiry := cl.Now().Add(time.Hour)
+
+	local, remote := test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)
+	m, err := New(authority.GetPublic(), issue(t, authority, &Certificate{Network: "net", Peer: local, NotAfter: expiry}), WithClock(cl))
+	require.NoError(t, err)
+
+	cert := issue(t, authority, &Certificate{Network: "net", Peer: remote, NotAfter: expiry})
+	require.NoError(t, m.Verify(remote, cert))
+	require.ErrorContains(t, m.Verify(local, cert), "is for peer")
+	require.ErrorIs(t, m.Verify(remote, nil), ErrNoCertificate)
+	require.ErrorContains(t, m.Verify(remote, issue(t, other, &Certificate{Network: "net", Peer: remote, NotAfter: expiry})), "not signed by the network authority")
+	require.ErrorContains(t, m.Verify(remote, issue(t, authority, &Certificate{Network: "other", Peer: remote, NotAfter: expiry})), "is for network")
+	require.Error(t, m.Verify(remote, []byte("foobar")))
+
+	cl.Add(2 * time.Hour)
+	require.ErrorContains(t, m.Verify(remote, cert), "expired")
+}
+
+func TestNewInvalidCertificate(t *testing.T) {
+	authority, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	other, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+
+	cert := issue(t, other, &Certificate{Network: "net", Peer: test.RandPeerIDFatal(t), NotAfter: time.Now().Add(time.Hour)})
+	_, err = New(authority.GetPublic(), cert)
+	require.ErrorContains(t, err, "invalid local certificate")
+
+	_, err = Issue(authority, &Certificate{Network: "net", Peer: test.RandPeerIDFatal(t)})
+	require.ErrorContains(t, err, "missing expiry")
+}
@@ -0,0 +1,169 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.32.0
+// 	protoc        v3.21.12
+// source: pb/membership.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// MembershipCertificate messages admit a peer to a permissioned network.
+//
+// MembershipCertificates are designed to be serialized to bytes and placed
+// inside of SignedEnvelopes, signed by the authority of the network.
+type MembershipCertificate struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// network is the name of the network the peer is admitted to.
+	Network string `protobuf:"bytes,1,opt,name=network,proto3" json:"network,omitempty"`
+	// peer_id contains the libp2p peer id of the admitted peer in its binary representation.
+	PeerId []byte `protobuf:"bytes,2,opt,name=peer_id,json=peerId,proto3" json:"peer_id,omitempty"`
+	// not_after is the expiry of the certificate, in nanoseconds since the Unix epoch.
+	NotAfter uint64 `protobuf:"varint,3,opt,name=not_after,json=notAfter,proto3" json:"not_after,omitempty"`
+}
+
+func (x *MembershipCertificate) Reset() {
+	*x = MembershipCertificate{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pb_membership_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MembershipCertificate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MembershipCertificate) ProtoMessage() {}
+
+func (x *MembershipCertificate) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_membership_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MembershipCertificate.ProtoReflect.Descriptor instead.
+func (*MembershipCertificate) Descriptor() ([]byte, []int) {
+	return file_pb_membership_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *MembershipCertificate) GetNetwork() string {
+	if x != nil {
+		return x.Network
+	}
+	return ""
+}
+
+func (x *MembershipCertificate) GetPeerId() []byte {
+	if x != nil {
+		return x.PeerId
+	}
+	return nil
+}
+
+func (x *MembershipCertificate) GetNotAfter() uint64 {
+	if x != nil {
+		return x.NotAfter
+	}
+	return 0
+}
+
+var File_pb_membership_proto protoreflect.FileDescriptor
+
+var file_pb_membership_proto_rawDesc = []byte{
+	0x0a, 0x13, 0x70, 0x62, 0x2f, 0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x0d, 0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69,
+	0x70, 0x2e, 0x70, 0x62, 0x22, 0x67, 0x0a, 0x15, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68,
+	0x69, 0x70, 0x43, 0x65, 0x72, 0x74, 0x69, 0x66, 0x69, 0x63, 0x61, 0x74, 0x65, 0x12, 0x18, 0x0a,
+	0x07, 0x6e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07,
+	0x6e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x12, 0x17, 0x0a, 0x07, 0x70, 0x65, 0x65, 0x72, 0x5f,
+	0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x70, 0x65, 0x65, 0x72, 0x49, 0x64,
+	0x12, 0x1b, 0x0a, 0x09, 0x6e, 0x6f, 0x74, 0x5f, 0x61, 0x66, 0x74, 0x65, 0x72, 0x18, 0x03, 0x20,
+	0x01, 0x28, 0x04, 0x52, 0x08, 0x6e, 0x6f, 0x74, 0x41, 0x66, 0x74, 0x65, 0x72, 0x62, 0x06, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_pb_membership_proto_rawDescOnce sync.Once
+	file_pb_membership_proto_rawDescData = file_pb_membership_proto_rawDesc
+)
+
+func file_pb_membership_proto_rawDescGZIP() []byte {
+	file_pb_membership_proto_rawDescOnce.Do(func() {
+		file_pb_membership_proto_rawDescData = protoimpl.X.CompressGZIP(file_pb_membership_proto_rawDescData)
+	})
+	return file_pb_membership_proto_rawDescData
+}
+
+var file_pb_membership_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_pb_membership_proto_goTypes = []interface{}{
+	(*MembershipCertificate)(nil), // 0: membership.pb.MembershipCertificate
+}
+var file_pb_membership_proto_depIdxs = []int32{
+	0, // [0:0] is the sub-list for method output_type
+	0, // [0:0] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_pb_membership_proto_init() }
+func file_pb_membership_proto_init() {
+	if File_pb_membership_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_pb_membership_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MembershipCertificate); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_pb_membership_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_pb_membership_proto_goTypes,
+		DependencyIndexes: file_pb_membership_proto_depIdxs,
+		MessageInfos:      file_pb_membership_proto_msgTypes,
+	}.Build()
+	File_pb_membership_proto = out.File
+	file_pb_membership_proto_rawDesc = nil
+	file_pb_membership_proto_goTypes = nil
+	file_pb_membership_proto_depIdxs = nil
+}
@@ -233,6 +233,21 @@ func (s *secureSession) generateHandshakePayload(localStatic noise.DHKey, ext *p
 		return nil, fmt.Errorf("error sigining handshake payload: %w", err)
 	}
 
+	if s.membership != nil {
+		if ext == nil {
+			ext = &pb.NoiseExtensions{}
+		}
+		ext.MembershipCertificate = s.membership.Certificate()
+	}
+	if s.identifySnapshot != nil {
+		if snapshot := s.identifySnapshot(); len(snapshot) > 0 && len(snapshot) <= sec.MaxIdentifySnapshotSize {
+			if ext == nil {
+				ext = &pb.NoiseExtensions{}
+			}
+			ext.IdentifySnapshot = snapshot
+		}
+	}
+
 	// create payload
 	payloadEnc, err := proto.Marshal(&pb.NoiseHandshakePayload{
 		IdentityKey: localKeyRaw,
@@ -281,8 +296,18 @@ func (s *secureSession) handleRemoteHandshakePayload(payload []byte, remoteStati
 		return nil, fmt.Errorf("handshake signature invalid")
 	}
 
+	// check that the remote peer is a member of our network, if required
+	if s.membership != nil {
+		if err := s.membership.Verify(id, nhp.GetExtensions().GetMembershipCertificate()); err != nil {
+			return nil, err
+		}
+	}
+
 	// set remote peer key and id
 	s.remoteID = id
 	s.remoteKey = remotePubKey
+	if snapshot := nhp.GetExtensions().GetIdentifySnapshot(); len(snapshot) <= sec.MaxIdentifySnapshotSize {
+		s.connectionState.IdentifySnapshot = snapshot
+	}
 	return nhp.Extensions, nil
 }
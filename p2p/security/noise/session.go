@@ -13,6 +13,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/security/membership"
 )
 
 type secureSession struct {
@@ -43,6 +44,9 @@ type secureSession struct {
 
 	initiatorEarlyDataHandler, responderEarlyDataHandler EarlyDataHandler
 
+	membership       *membership.Membership
+	identifySnapshot func() []byte
+
 	// ConnectionState holds state information releated to the secureSession entity.
 	connectionState network.ConnectionState
 }
@@ -61,6 +65,10 @@ func newSecureSession(tpt *Transport, ctx context.Context, insecure net.Conn, re
 		initiatorEarlyDataHandler: initiatorEDH,
 		responderEarlyDataHandler: responderEDH,
 		checkPeerID:               checkPeerID,
+		membership:                tpt.membership,
+	}
+	if f := tpt.identifySnapshot.Load(); f != nil {
+		s.identifySnapshot = *f
 	}
 
 	// the go-routine we create to run the handshake will
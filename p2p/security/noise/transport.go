@@ -3,6 +3,7 @@ package noise
 import (
 	"context"
 	"net"
+	"sync/atomic"
 
 	"github.com/libp2p/go-libp2p/core/canonicallog"
 	"github.com/libp2p/go-libp2p/core/crypto"
@@ -10,6 +11,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/core/sec"
 	tptu "github.com/libp2p/go-libp2p/p2p/net/upgrader"
+	"github.com/libp2p/go-libp2p/p2p/security/membership"
 	"github.com/libp2p/go-libp2p/p2p/security/noise/pb"
 
 	manet "github.com/multiformats/go-multiaddr/net"
@@ -24,13 +26,32 @@ type Transport struct {
 	localID    peer.ID
 	privateKey crypto.PrivKey
 	muxers     []protocol.ID
+	membership *membership.Membership
+
+	identifySnapshot atomic.Pointer[func() []byte]
 }
 
-var _ sec.SecureTransport = &Transport{}
+var (
+	_ sec.SecureTransport     = &Transport{}
+	_ sec.IdentifySnapshotter = &Transport{}
+)
+
+// Option is an option for the Noise transport.
+type Option func(*Transport) error
+
+// WithMembership requires remote peers to present a certificate of the
+// network of m in the handshake, and presents the local certificate to them.
+// Connections with peers that aren't members of the network fail the handshake.
+func WithMembership(m *membership.Membership) Option {
+	return func(t *Transport) error {
+		t.membership = m
+		return nil
+	}
+}
 
 // New creates a new Noise transport using the given private key as its
 // libp2p identity key.
-func New(id protocol.ID, privkey crypto.PrivKey, muxers []tptu.StreamMuxer) (*Transport, error) {
+func New(id protocol.ID, privkey crypto.PrivKey, muxers []tptu.StreamMuxer, opts ...Option) (*Transport, error) {
 	localID, err := peer.IDFromPrivateKey(privkey)
 	if err != nil {
 		return nil, err
@@ -41,12 +62,18 @@ func New(id protocol.ID, privkey crypto.PrivKey, muxers []tptu.StreamMuxer) (*Tr
 		muxerIDs = append(muxerIDs, m.ID)
 	}
 
-	return &Transport{
+	t := &Transport{
 		protocolID: id,
 		localID:    localID,
 		privateKey: privkey,
 		muxers:     muxerIDs,
-	}, nil
+	}
+	for _, opt := range opts {
+		if err := opt(t); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
 }
 
 // SecureInbound runs the Noise handshake as the responder.
@@ -73,6 +100,12 @@ func (t *Transport) SecureOutbound(ctx context.Context, insecure net.Conn, p pee
 	return SessionWithConnState(c, initiatorEDH.MatchMuxers(true)), err
 }
 
+// SetIdentifySnapshot sets the function returning the identify snapshot that
+// is sent in the handshake payload.
+func (t *Transport) SetIdentifySnapshot(f func() []byte) {
+	t.identifySnapshot.Store(&f)
+}
+
 func (t *Transport) WithSessionOptions(opts ...SessionOption) (*SessionTransport, error) {
 	st := &SessionTransport{t: t, protocolID: t.protocolID}
 	for _, opt := range opts {
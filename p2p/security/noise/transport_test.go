@@ -8,6 +8,7 @@ import (
 	"io"
 	"math/rand"
 	"net"
+	"sync"
 	"testing"
 	"time"
 
@@ -17,6 +18,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/core/sec"
+	"github.com/libp2p/go-libp2p/p2p/security/membership"
 	"github.com/libp2p/go-libp2p/p2p/security/noise/pb"
 
 	"github.com/stretchr/testify/assert"
@@ -241,6 +243,86 @@ func TestPeerIDMismatchInboundFailsHandshake(t *testing.T) {
 	<-done
 }
 
+func newTestMembership(t *testing.T, authority crypto.PrivKey, p peer.ID) *membership.Membership {
+	t.Helper()
+	cert, err := membership.Issue(authority, &membership.Certificate{Network: "test", Peer: p, NotAfter: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	m, err := membership.New(authority.GetPublic(), cert)
+	require.NoError(t, err)
+	return m
+}
+
+func TestMembership(t *testing.T) {
+	authority, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+
+	t.Run("both members", func(t *testing.T) {
+		initTransport := newTestTransport(t, crypto.Ed25519, 2048)
+		initTransport.membership = newTestMembership(t, authority, initTransport.localID)
+		respTransport := newTestTransport(t, crypto.Ed25519, 2048)
+		respTransport.membership = newTestMembership(t, authority, respTransport.localID)
+		initConn, respConn := connect(t, initTransport, respTransport)
+		initConn.Close()
+		respConn.Close()
+	})
+
+	t.Run("initiator not a member", func(t *testing.T) {
+		initTransport := newTestTransport(t, crypto.Ed25519, 2048)
+		respTransport := newTestTransport(t, crypto.Ed25519, 2048)
+		respTransport.membership = newTestMembership(t, authority, respTransport.localID)
+		init, resp := newConnPair(t)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			conn, err := initTransport.SecureOutbound(context.Background(), init, respTransport.localID)
+			assert.NoError(t, err)
+			_, err = conn.Read([]byte{0})
+			assert.Error(t, err)
+		}()
+		_, err := respTransport.SecureInbound(context.Background(), resp, "")
+		require.ErrorIs(t, err, membership.ErrNoCertificate)
+		<-done
+	})
+
+	t.Run("responder not a member", func(t *testing.T) {
+		initTransport := newTestTransport(t, crypto.Ed25519, 2048)
+		initTransport.membership = newTestMembership(t, authority, initTransport.localID)
+		respTransport := newTestTransport(t, crypto.Ed25519, 2048)
+		init, resp := newConnPair(t)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, err := respTransport.SecureInbound(context.Background(), resp, "")
+			assert.Error(t, err)
+		}()
+		_, err := initTransport.SecureOutbound(context.Background(), init, respTransport.localID)
+		require.ErrorIs(t, err, membership.ErrNoCertificate)
+		<-done
+	})
+}
+
+func TestIdentifySnapshot(t *testing.T) {
+	initTransport := newTestTransport(t, crypto.Ed25519, 2048)
+	initTransport.SetIdentifySnapshot(func() []byte { return []byte("initiator") })
+	respTransport := newTestTransport(t, crypto.Ed25519, 2048)
+	respTransport.SetIdentifySnapshot(func() []byte { return []byte("responder") })
+	initConn, respConn := connect(t, initTransport, respTransport)
+	defer initConn.Close()
+	defer respConn.Close()
+	require.Equal(t, []byte("responder"), initConn.ConnState().IdentifySnapshot)
+	require.Equal(t, []byte("initiator"), respConn.ConnState().IdentifySnapshot)
+
+	// snapshots exceeding the maximum size aren't sent
+	initTransport.SetIdentifySnapshot(func() []byte { return make([]byte, sec.MaxIdentifySnapshotSize+1) })
+	initConn, respConn = connect(t, initTransport, newTestTransport(t, crypto.Ed25519, 2048))
+	defer initConn.Close()
+	defer respConn.Close()
+	require.Nil(t, initConn.ConnState().IdentifySnapshot)
+	require.Nil(t, respConn.ConnState().IdentifySnapshot)
+}
+
 func TestPeerIDInboundCheckDisabled(t *testing.T) {
 	initTransport := newTestTransport(t, crypto.Ed25519, 2048)
 	respTransport := newTestTransport(t, crypto.Ed25519, 2048)
@@ -476,6 +558,60 @@ func TestPrologueDoesNotMatchFailsHandshake(t *testing.T) {
 	<-done
 }
 
+// recordingConn records everything written to the connection.
+type recordingConn struct {
+	net.Conn
+	mx      sync.Mutex
+	written bytes.Buffer
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.mx.Lock()
+	c.written.Write(b)
+	c.mx.Unlock()
+	return c.Conn.Write(b)
+}
+
+func (c *recordingConn) Written() []byte {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return append([]byte(nil), c.written.Bytes()...)
+}
+
+func TestHandshakeReplayFails(t *testing.T) {
+	initTransport := newTestTransport(t, crypto.Ed25519, 2048)
+	respTransport := newTestTransport(t, crypto.Ed25519, 2048)
+
+	// record the messages the initiator sends during a successful handshake
+	init, resp := newConnPair(t)
+	recConn := &recordingConn{Conn: init}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := respTransport.SecureInbound(context.Background(), resp, "")
+		assert.NoError(t, err)
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	conn, err := initTransport.SecureOutbound(context.Background(), recConn, respTransport.localID)
+	require.NoError(t, err)
+	<-done
+	conn.Close()
+
+	// replay them to a new session: the responder uses a new ephemeral key,
+	// so the replayed messages can't be decrypted
+	init, resp = newConnPair(t)
+	defer init.Close()
+	_, err = init.Write(recConn.Written())
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = respTransport.SecureInbound(ctx, resp, "")
+	require.Error(t, err)
+	require.NotErrorIs(t, err, context.DeadlineExceeded)
+}
+
 type earlyDataHandler struct {
 	send     func(context.Context, net.Conn, peer.ID) *pb.NoiseExtensions
 	received func(context.Context, net.Conn, *pb.NoiseExtensions) error
@@ -1,6 +1,7 @@
 package libp2ptls
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
@@ -15,20 +16,34 @@ import (
 	"math/big"
 	"os"
 	"runtime/debug"
+	"slices"
+	"sync"
 	"time"
 
 	ic "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/sec"
+	"github.com/libp2p/go-libp2p/p2p/security/membership"
 )
 
 const certValidityPeriod = 100 * 365 * 24 * time.Hour // ~100 years
 const certificatePrefix = "libp2p-tls-handshake:"
 const alpn string = "libp2p"
 
+// pkiALPN is the ALPN of bridge mode, which isn't interoperable with the libp2p
+// TLS handshake.
+const pkiALPN string = "libp2p-pki"
+
 var extensionID = getPrefixedExtensionID([]int{1, 1})
 var extensionCritical bool // so we can mark the extension critical in tests
 
+// membershipExtensionID is the extension carrying the membership certificate.
+var membershipExtensionID = getPrefixedExtensionID([]int{1, 2})
+
+// identifySnapshotExtensionID is the extension carrying the identify snapshot.
+var identifySnapshotExtensionID = getPrefixedExtensionID([]int{1, 3})
+
 type signedKey struct {
 	PubKey    []byte
 	Signature []byte
@@ -36,13 +51,26 @@ type signedKey struct {
 
 // Identity is used to secure connections
 type Identity struct {
-	config tls.Config
+	config     tls.Config
+	membership *membership.Membership
+	pki        *PKIConfig
+
+	privKey  ic.PrivKey
+	template *x509.Certificate // without the key extension
+
+	snapshotMx       sync.Mutex
+	identifySnapshot func() []byte
+	// certificate with the last identify snapshot, regenerated when the snapshot changes
+	snapshot     []byte
+	snapshotCert *tls.Certificate
 }
 
 // IdentityConfig is used to configure an Identity
 type IdentityConfig struct {
 	CertTemplate *x509.Certificate
 	KeyLogWriter io.Writer
+	Membership   *membership.Membership
+	PKI          *PKIConfig
 }
 
 // IdentityOption transforms an IdentityConfig to apply optional settings.
@@ -67,6 +95,15 @@ func WithKeyLogWriter(w io.Writer) IdentityOption {
 	}
 }
 
+// WithMembership requires remote peers to present a certificate of the
+// network of m in the handshake. The local certificate is included in an
+// extension of the TLS certificate.
+func WithMembership(m *membership.Membership) IdentityOption {
+	return func(c *IdentityConfig) {
+		c.Membership = m
+	}
+}
+
 // NewIdentity creates a new identity
 func NewIdentity(privKey ic.PrivKey, opts ...IdentityOption) (*Identity, error) {
 	config := IdentityConfig{}
@@ -74,32 +111,100 @@ func NewIdentity(privKey ic.PrivKey, opts ...IdentityOption) (*Identity, error)
 		opt(&config)
 	}
 
-	var err error
-	if config.CertTemplate == nil {
-		config.CertTemplate, err = certTemplate()
+	id := &Identity{
+		membership: config.Membership,
+		pki:        config.PKI,
+		privKey:    privKey,
+	}
+	var cert *tls.Certificate
+	protos := []string{alpn}
+	if config.PKI != nil {
+		protos = []string{pkiALPN}
+		if config.Membership != nil {
+			return nil, errors.New("membership can't be used with a PKI")
+		}
+		if err := config.PKI.validate(privKey); err != nil {
+			return nil, err
+		}
+		cert = &config.PKI.Certificate
+	} else {
+		var err error
+		if config.CertTemplate == nil {
+			config.CertTemplate, err = certTemplate()
+			if err != nil {
+				return nil, err
+			}
+		}
+		// Copy the template, so that the extensions appended below don't
+		// modify the template of the caller.
+		template := *config.CertTemplate
+		template.ExtraExtensions = slices.Clip(template.ExtraExtensions)
+		if config.Membership != nil {
+			value, err := asn1.Marshal(config.Membership.Certificate())
+			if err != nil {
+				return nil, err
+			}
+			template.ExtraExtensions = slices.Clip(append(template.ExtraExtensions, pkix.Extension{Id: membershipExtensionID, Value: value}))
+		}
+		id.template = &template
+		certTmpl := template
+		cert, err = keyToCertificate(privKey, &certTmpl)
 		if err != nil {
 			return nil, err
 		}
 	}
+	id.config = tls.Config{
+		MinVersion:         tls.VersionTLS13,
+		InsecureSkipVerify: true, // This is not insecure here. We will verify the cert chain ourselves.
+		ClientAuth:         tls.RequireAnyClientCert,
+		Certificates:       []tls.Certificate{*cert},
+		VerifyPeerCertificate: func(_ [][]byte, _ [][]*x509.Certificate) error {
+			panic("tls config not specialized for peer")
+		},
+		NextProtos:             protos,
+		SessionTicketsDisabled: true,
+		KeyLogWriter:           config.KeyLogWriter,
+	}
+	return id, nil
+}
+
+// SetIdentifySnapshot sets the function returning the identify snapshot that
+// is sent in an extension of the certificate. Since the certificate is signed,
+// a new certificate is generated every time the snapshot changes.
+func (i *Identity) SetIdentifySnapshot(f func() []byte) {
+	i.snapshotMx.Lock()
+	defer i.snapshotMx.Unlock()
+	i.identifySnapshot = f
+}
 
-	cert, err := keyToCertificate(privKey, config.CertTemplate)
+// certificate returns the certificate to present in the handshake.
+func (i *Identity) certificate() (*tls.Certificate, error) {
+	i.snapshotMx.Lock()
+	defer i.snapshotMx.Unlock()
+
+	var snapshot []byte
+	if i.identifySnapshot != nil {
+		snapshot = i.identifySnapshot()
+	}
+	if len(snapshot) == 0 || len(snapshot) > sec.MaxIdentifySnapshotSize {
+		return &i.config.Certificates[0], nil
+	}
+	if i.snapshotCert != nil && bytes.Equal(snapshot, i.snapshot) {
+		return i.snapshotCert, nil
+	}
+	value, err := asn1.Marshal(snapshot)
 	if err != nil {
 		return nil, err
 	}
-	return &Identity{
-		config: tls.Config{
-			MinVersion:         tls.VersionTLS13,
-			InsecureSkipVerify: true, // This is not insecure here. We will verify the cert chain ourselves.
-			ClientAuth:         tls.RequireAnyClientCert,
-			Certificates:       []tls.Certificate{*cert},
-			VerifyPeerCertificate: func(_ [][]byte, _ [][]*x509.Certificate) error {
-				panic("tls config not specialized for peer")
-			},
-			NextProtos:             []string{alpn},
-			SessionTicketsDisabled: true,
-			KeyLogWriter:           config.KeyLogWriter,
-		},
-	}, nil
+	template := *i.template
+	template.ExtraExtensions = append(template.ExtraExtensions, pkix.Extension{Id: identifySnapshotExtensionID, Value: value})
+	cert, err := keyToCertificate(i.privKey, &template)
+	if err != nil {
+		return nil, err
+	}
+	i.snapshot = snapshot
+	i.snapshotCert = cert
+	return cert, nil
 }
 
 // ConfigForPeer creates a new single-use tls.Config that verifies the peer's
@@ -114,6 +219,20 @@ func (i *Identity) ConfigForPeer(remote peer.ID) (*tls.Config, <-chan ic.PubKey)
 	// The tls.Config it is also used for listening, and we might also have concurrent dials.
 	// Clone it so we can check for the specific peer ID we're dialing here.
 	conf := i.config.Clone()
+	i.snapshotMx.Lock()
+	// The certificates issued by a PKI can't carry the identify snapshot.
+	hasSnapshot := i.identifySnapshot != nil && i.pki == nil
+	i.snapshotMx.Unlock()
+	if hasSnapshot {
+		// The server only calls GetCertificate if no certificates are set.
+		conf.Certificates = nil
+		conf.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return i.certificate()
+		}
+		conf.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return i.certificate()
+		}
+	}
 	// We're using InsecureSkipVerify, so the verifiedChains parameter will always be empty.
 	// We need to parse the certificates ourselves from the raw certs.
 	conf.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) (err error) {
@@ -136,7 +255,12 @@ func (i *Identity) ConfigForPeer(remote peer.ID) (*tls.Config, <-chan ic.PubKey)
 			chain[i] = cert
 		}
 
-		pubKey, err := PubKeyFromCertChain(chain)
+		var pubKey ic.PubKey
+		if i.pki != nil {
+			pubKey, err = i.pki.verify(chain)
+		} else {
+			pubKey, err = PubKeyFromCertChain(chain)
+		}
 		if err != nil {
 			return err
 		}
@@ -147,6 +271,11 @@ func (i *Identity) ConfigForPeer(remote peer.ID) (*tls.Config, <-chan ic.PubKey)
 			}
 			return sec.ErrPeerIDMismatch{Expected: remote, Actual: peerID}
 		}
+		if i.membership != nil {
+			if err := verifyMembership(i.membership, chain[0], pubKey); err != nil {
+				return err
+			}
+		}
 		keyCh <- pubKey
 		return nil
 	}
@@ -209,6 +338,41 @@ func PubKeyFromCertChain(chain []*x509.Certificate) (ic.PubKey, error) {
 	return pubKey, nil
 }
 
+// verifyMembership checks the membership certificate contained in the
+// extension of cert.
+func verifyMembership(m *membership.Membership, cert *x509.Certificate, pubKey ic.PubKey) error {
+	p, err := peer.IDFromPublicKey(pubKey)
+	if err != nil {
+		return err
+	}
+	var membershipCert []byte
+	for _, ext := range cert.Extensions {
+		if extensionIDEqual(ext.Id, membershipExtensionID) {
+			if _, err := asn1.Unmarshal(ext.Value, &membershipCert); err != nil {
+				return fmt.Errorf("unmarshalling membership extension failed: %s", err)
+			}
+			break
+		}
+	}
+	return m.Verify(p, membershipCert)
+}
+
+// identifySnapshotFromCert returns the identify snapshot contained in the
+// extension of cert, if any.
+func identifySnapshotFromCert(cert *x509.Certificate) []byte {
+	for _, ext := range cert.Extensions {
+		if !extensionIDEqual(ext.Id, identifySnapshotExtensionID) {
+			continue
+		}
+		var snapshot []byte
+		if _, err := asn1.Unmarshal(ext.Value, &snapshot); err != nil || len(snapshot) > sec.MaxIdentifySnapshotSize {
+			return nil
+		}
+		return snapshot
+	}
+	return nil
+}
+
 // GenerateSignedExtension uses the provided private key to sign the public key, and returns the
 // signature within a pkix.Extension.
 // This extension is included in a certificate to cryptographically tie it to the libp2p private key.
@@ -284,3 +448,27 @@ func certTemplate() (*x509.Certificate, error) {
 		Subject: pkix.Name{SerialNumber: subjectSN.String()},
 	}, nil
 }
+
+// NewTLSConnectionState returns the information about a TLS session that is
+// exposed in the network.ConnectionState of connections secured using TLS.
+func NewTLSConnectionState(cs tls.ConnectionState) *network.TLSConnectionState {
+	s := &network.TLSConnectionState{
+		Version:            cs.Version,
+		CipherSuite:        cs.CipherSuite,
+		NegotiatedProtocol: cs.NegotiatedProtocol,
+		PeerCertificates:   cs.PeerCertificates,
+	}
+	if len(cs.PeerCertificates) > 0 {
+		for _, ext := range cs.PeerCertificates[0].Extensions {
+			if !extensionIDEqual(ext.Id, extensionID) {
+				continue
+			}
+			var sk signedKey
+			if _, err := asn1.Unmarshal(ext.Value, &sk); err == nil {
+				s.PeerKeyExtension = &network.TLSKeyExtension{PublicKey: sk.PubKey, Signature: sk.Signature}
+			}
+			break
+		}
+	}
+	return s
+}
@@ -0,0 +1,157 @@
+package libp2ptls
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// PKIConfig configures authenticating peers using certificates issued by an
+// existing public key infrastructure, see WithPKI.
+type PKIConfig struct {
+	// Certificate is the certificate chain presented to remote peers. The
+	// leaf certificate must be issued for the public key of the host's
+	// private key.
+	Certificate tls.Certificate
+	// Roots are the certificate authorities the certificates of remote peers
+	// must chain to.
+	Roots *x509.CertPool
+	// PeerID maps the verified certificate chain of a remote peer to the
+	// peer ID it is expected to have, e.g. using the subject of the leaf
+	// certificate. This binds the identities of the PKI to peer IDs: the peer
+	// is rejected unless the public key of its leaf certificate is the key of
+	// the returned peer ID, or if PeerID returns an error. If nil, any peer
+	// ID is accepted.
+	PeerID func(chain []*x509.Certificate) (peer.ID, error)
+	// Approve is called with the verified certificate chain of a remote
+	// peer, and its peer ID. Returning an error rejects the peer. If nil, all
+	// peers with a valid certificate are accepted.
+	Approve func(chain []*x509.Certificate, p peer.ID) error
+}
+
+// WithPKI enables bridge mode: instead of the self-signed certificates of
+// the libp2p TLS handshake, peers authenticate using X.509 certificates issued
+// by the certificate authorities of cfg.Roots, e.g. those of a corporate PKI.
+//
+// The peer ID of a remote peer is derived from the public key of its leaf
+// certificate, so the certificates need to be issued for the libp2p keys of
+// the peers. Set cfg.PeerID to only accept the peer IDs the certificates map
+// to. RSA, ECDSA and Ed25519 keys are supported.
+//
+// Bridge mode isn't interoperable with the libp2p TLS handshake. It uses its
+// own protocol ID, PKIID, and ALPN, so that a peer using bridge mode never
+// completes a handshake with a peer using the libp2p TLS handshake. A host
+// that registers both security transports falls back to the libp2p TLS
+// handshake with peers that don't support bridge mode:
+//
+//	libp2p.Security(libp2ptls.PKIID, func(id protocol.ID, key crypto.PrivKey, muxers []tptu.StreamMuxer) (*libp2ptls.Transport, error) {
+//		return libp2ptls.New(id, key, muxers, libp2ptls.WithPKI(cfg))
+//	}),
+//	libp2p.Security(libp2ptls.ID, libp2ptls.New),
+//
+// It can't be combined with WithMembership.
+func WithPKI(cfg PKIConfig) IdentityOption {
+	return func(c *IdentityConfig) {
+		c.PKI = &cfg
+	}
+}
+
+// validate checks that the certificate of cfg was issued for privKey.
+func (cfg *PKIConfig) validate(privKey ic.PrivKey) error {
+	if cfg.Roots == nil {
+		return errors.New("PKI needs root certificates")
+	}
+	if len(cfg.Certificate.Certificate) == 0 {
+		return errors.New("PKI needs a certificate")
+	}
+	leaf := cfg.Certificate.Leaf
+	if leaf == nil {
+		var err error
+		leaf, err = x509.ParseCertificate(cfg.Certificate.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("parsing PKI certificate failed: %w", err)
+		}
+	}
+	pubKey, err := pubKeyFromCert(leaf)
+	if err != nil {
+		return err
+	}
+	if !pubKey.Equals(privKey.GetPublic()) {
+		return errors.New("PKI certificate wasn't issued for the host key")
+	}
+	return nil
+}
+
+// verify verifies the certificate chain of a remote peer, and returns its
+// public key.
+func (cfg *PKIConfig) verify(chain []*x509.Certificate) (ic.PubKey, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("expected a certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         cfg.Roots,
+		Intermediates: intermediates,
+		// Peers are both clients and servers.
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		// If we return an x509 error here, it will be sent on the wire.
+		// Wrap the error to avoid that.
+		return nil, fmt.Errorf("certificate verification failed: %s", err)
+	}
+	pubKey, err := pubKeyFromCert(chain[0])
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PeerID == nil && cfg.Approve == nil {
+		return pubKey, nil
+	}
+	p, err := peer.IDFromPublicKey(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PeerID != nil {
+		expected, err := cfg.PeerID(chains[0])
+		if err != nil {
+			return nil, fmt.Errorf("mapping certificate to peer ID failed: %s", err)
+		}
+		if expected != p {
+			return nil, fmt.Errorf("certificate of %s was issued for the key of %s", expected, p)
+		}
+	}
+	if cfg.Approve != nil {
+		if err := cfg.Approve(chains[0], p); err != nil {
+			return nil, fmt.Errorf("certificate not approved: %s", err)
+		}
+	}
+	return pubKey, nil
+}
+
+// pubKeyFromCert returns the public key of cert as a libp2p key.
+func pubKeyFromCert(cert *x509.Certificate) (ic.PubKey, error) {
+	switch k := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		return ic.ECDSAPublicKeyFromPubKey(*k)
+	case *rsa.PublicKey:
+		b, err := x509.MarshalPKIXPublicKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return ic.UnmarshalRsaPublicKey(b)
+	case ed25519.PublicKey:
+		return ic.UnmarshalEd25519PublicKey(k)
+	default:
+		return nil, fmt.Errorf("unsupported certificate key type %T", cert.PublicKey)
+	}
+}
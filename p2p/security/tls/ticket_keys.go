@@ -0,0 +1,187 @@
+package libp2ptls
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("libp2ptls")
+
+// SessionTicketKeyManager manages the keys used to encrypt and decrypt TLS
+// session tickets, for all tls.Configs registered with it. New tickets are
+// encrypted with the newest key, tickets encrypted with one of the older keys
+// are still accepted.
+//
+// Transports that use the libp2p TLS handshake (the TLS security transport and
+// QUIC) disable session tickets, since the peer's identity has to be verified
+// on every handshake. Session tickets are used by the WebTransport transport,
+// see its WithSessionTicketKeys option.
+type SessionTicketKeyManager struct {
+	clock    clock.Clock
+	interval time.Duration
+	maxKeys  int
+
+	mx      sync.Mutex
+	keys    [][32]byte
+	configs map[*tls.Config]struct{}
+	ticker  *clock.Ticker
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	done      chan struct{}
+}
+
+// TicketKeyOption configures a SessionTicketKeyManager.
+type TicketKeyOption func(*SessionTicketKeyManager) error
+
+// WithRotationInterval sets the interval at which a new key is generated. It
+// defaults to 24h.
+func WithRotationInterval(d time.Duration) TicketKeyOption {
+	return func(m *SessionTicketKeyManager) error {
+		if d <= 0 {
+			return errors.New("rotation interval must be positive")
+		}
+		m.interval = d
+		return nil
+	}
+}
+
+// WithMaxKeys sets the number of keys used to decrypt tickets, including the
+// current key. Tickets are therefore accepted for up to n rotation intervals.
+// It defaults to 7.
+func WithMaxKeys(n int) TicketKeyOption {
+	return func(m *SessionTicketKeyManager) error {
+		if n <= 0 {
+			return errors.New("need at least one key")
+		}
+		m.maxKeys = n
+		return nil
+	}
+}
+
+// WithTicketKeyClock sets the clock used to schedule rotations.
+func WithTicketKeyClock(cl clock.Clock) TicketKeyOption {
+	return func(m *SessionTicketKeyManager) error {
+		m.clock = cl
+		return nil
+	}
+}
+
+// NewSessionTicketKeyManager creates a SessionTicketKeyManager, and starts
+// rotating keys. Close must be called to stop the rotation.
+func NewSessionTicketKeyManager(opts ...TicketKeyOption) (*SessionTicketKeyManager, error) {
+	m := &SessionTicketKeyManager{
+		clock:    clock.New(),
+		interval: 24 * time.Hour,
+		maxKeys:  7,
+		configs:  make(map[*tls.Config]struct{}),
+		closed:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+	key, err := newTicketKey()
+	if err != nil {
+		return nil, err
+	}
+	m.keys = [][32]byte{key}
+	m.ticker = m.clock.Ticker(m.interval)
+	go m.background()
+	return m, nil
+}
+
+func newTicketKey() ([32]byte, error) {
+	var key [32]byte
+	_, err := rand.Read(key[:])
+	return key, err
+}
+
+func (m *SessionTicketKeyManager) background() {
+	defer close(m.done)
+	defer m.ticker.Stop()
+	for {
+		select {
+		case <-m.ticker.C:
+			if err := m.Rotate(); err != nil {
+				log.Errorw("failed to rotate session ticket keys", "error", err)
+			}
+		case <-m.closed:
+			return
+		}
+	}
+}
+
+// Register makes conf use the keys of the manager, until the returned function
+// is called.
+func (m *SessionTicketKeyManager) Register(conf *tls.Config) (unregister func()) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.configs[conf] = struct{}{}
+	conf.SetSessionTicketKeys(m.keys)
+	return func() {
+		m.mx.Lock()
+		delete(m.configs, conf)
+		m.mx.Unlock()
+	}
+}
+
+// Rotate generates a new key used to encrypt new tickets. Tickets encrypted
+// with the previous keys are still accepted, up to the configured number of
+// keys.
+func (m *SessionTicketKeyManager) Rotate() error {
+	key, err := newTicketKey()
+	if err != nil {
+		return err
+	}
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	keys := make([][32]byte, 0, m.maxKeys)
+	keys = append(keys, key)
+	for _, k := range m.keys {
+		if len(keys) == m.maxKeys {
+			break
+		}
+		keys = append(keys, k)
+	}
+	m.setKeysLocked(keys)
+	return nil
+}
+
+// Reset replaces all keys with a new one, so that all tickets issued so far are
+// rejected. Use it if a key might have been compromised. The rotation schedule
+// restarts.
+func (m *SessionTicketKeyManager) Reset() error {
+	key, err := newTicketKey()
+	if err != nil {
+		return err
+	}
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.setKeysLocked([][32]byte{key})
+	m.ticker.Reset(m.interval)
+	return nil
+}
+
+func (m *SessionTicketKeyManager) setKeysLocked(keys [][32]byte) {
+	m.keys = keys
+	for conf := range m.configs {
+		conf.SetSessionTicketKeys(keys)
+	}
+}
+
+// Close stops the rotation of keys. Registered configs keep using the current
+// keys.
+func (m *SessionTicketKeyManager) Close() error {
+	m.closeOnce.Do(func() { close(m.closed) })
+	<-m.done
+	return nil
+}
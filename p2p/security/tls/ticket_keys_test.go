@@ -0,0 +1,78 @@
+package libp2ptls
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/require"
+)
+
+// resumes runs a TLS handshake and returns true if the client resumed its
+// previous session.
+func resumes(t *testing.T, serverConf, clientConf *tls.Config) bool {
+	t.Helper()
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+
+	errChan := make(chan error, 1)
+	go func() {
+		server := tls.Server(s, serverConf)
+		if err := server.Handshake(); err != nil {
+			errChan <- err
+			return
+		}
+		_, err := server.Write([]byte("x"))
+		errChan <- err
+	}()
+	client := tls.Client(c, clientConf)
+	require.NoError(t, client.Handshake())
+	// reading processes the session ticket
+	_, err := client.Read(make([]byte, 1))
+	require.NoError(t, err)
+	require.NoError(t, <-errChan)
+	return client.ConnectionState().DidResume
+}
+
+func TestSessionTicketKeyManager(t *testing.T) {
+	_, key := createPeer(t)
+	id, err := NewIdentity(key)
+	require.NoError(t, err)
+
+	cl := clock.NewMock()
+	m, err := NewSessionTicketKeyManager(WithTicketKeyClock(cl), WithRotationInterval(time.Hour), WithMaxKeys(2))
+	require.NoError(t, err)
+	defer m.Close()
+
+	serverConf := &tls.Config{Certificates: id.config.Certificates}
+	unregister := m.Register(serverConf)
+	defer unregister()
+	clientConf := &tls.Config{InsecureSkipVerify: true, ClientSessionCache: tls.NewLRUClientSessionCache(1)}
+
+	require.False(t, resumes(t, serverConf, clientConf))
+	require.True(t, resumes(t, serverConf, clientConf))
+
+	// tickets encrypted with the previous key are accepted
+	require.NoError(t, m.Rotate())
+	require.True(t, resumes(t, serverConf, clientConf))
+
+	// after two scheduled rotations, the ticket's key is gone
+	cl.Add(time.Hour)
+	cl.Add(time.Hour)
+	require.Eventually(t, func() bool { return !resumes(t, serverConf, clientConf) }, time.Second, 10*time.Millisecond)
+
+	// a reset invalidates all tickets
+	require.True(t, resumes(t, serverConf, clientConf))
+	require.NoError(t, m.Reset())
+	require.False(t, resumes(t, serverConf, clientConf))
+}
+
+func TestSessionTicketKeyManagerOptions(t *testing.T) {
+	_, err := NewSessionTicketKeyManager(WithMaxKeys(0))
+	require.Error(t, err)
+	_, err = NewSessionTicketKeyManager(WithRotationInterval(0))
+	require.Error(t, err)
+}
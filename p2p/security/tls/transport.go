@@ -23,6 +23,11 @@ import (
 // ID is the protocol ID (used when negotiating with multistream)
 const ID = "/tls/1.0.0"
 
+// PKIID is the protocol ID of bridge mode, see WithPKI. Bridge mode uses a
+// different protocol ID, so that a host can offer both the libp2p TLS
+// handshake and bridge mode, and fall back to the one the remote peer supports.
+const PKIID = "/tls-pki/1.0.0"
+
 // Transport constructs secure communication sessions for a peer.
 type Transport struct {
 	identity *Identity
@@ -33,10 +38,14 @@ type Transport struct {
 	protocolID protocol.ID
 }
 
-var _ sec.SecureTransport = &Transport{}
+var (
+	_ sec.SecureTransport     = &Transport{}
+	_ sec.IdentifySnapshotter = &Transport{}
+)
 
-// New creates a TLS encrypted transport
-func New(id protocol.ID, key ci.PrivKey, muxers []tptu.StreamMuxer) (*Transport, error) {
+// New creates a TLS encrypted transport.
+// The options are used to configure the Identity of the transport.
+func New(id protocol.ID, key ci.PrivKey, muxers []tptu.StreamMuxer, opts ...IdentityOption) (*Transport, error) {
 	localPeer, err := peer.IDFromPrivateKey(key)
 	if err != nil {
 		return nil, err
@@ -52,14 +61,23 @@ func New(id protocol.ID, key ci.PrivKey, muxers []tptu.StreamMuxer) (*Transport,
 		muxers:     muxerIDs,
 	}
 
-	identity, err := NewIdentity(key)
+	identity, err := NewIdentity(key, opts...)
 	if err != nil {
 		return nil, err
 	}
+	if identity.pki != nil && id == ID {
+		return nil, fmt.Errorf("bridge mode can't use the protocol ID %s, use %s", ID, PKIID)
+	}
 	t.identity = identity
 	return t, nil
 }
 
+// SetIdentifySnapshot sets the function returning the identify snapshot that
+// is sent in an extension of the certificate.
+func (t *Transport) SetIdentifySnapshot(f func() []byte) {
+	t.identity.SetIdentifySnapshot(f)
+}
+
 // SecureInbound runs the TLS handshake as a server.
 // If p is empty, connections from any peer are accepted.
 func (t *Transport) SecureInbound(ctx context.Context, insecure net.Conn, p peer.ID) (sec.SecureConn, error) {
@@ -155,16 +173,22 @@ func (t *Transport) setupConn(tlsConn *tls.Conn, remotePubKey ci.PubKey) (sec.Se
 		return nil, err
 	}
 
-	nextProto := tlsConn.ConnectionState().NegotiatedProtocol
+	cs := tlsConn.ConnectionState()
+	nextProto := cs.NegotiatedProtocol
 	// The special ALPN extension value "libp2p" is used by libp2p versions
 	// that don't support early muxer negotiation. If we see this sepcial
 	// value selected, that means we are handshaking with a version that does
 	// not support early muxer negotiation. In this case return empty nextProto
 	// to indicate no muxer is selected.
-	if nextProto == "libp2p" {
+	if nextProto == alpn || nextProto == pkiALPN {
 		nextProto = ""
 	}
 
+	var identifySnapshot []byte
+	if len(cs.PeerCertificates) > 0 {
+		identifySnapshot = identifySnapshotFromCert(cs.PeerCertificates[0])
+	}
+
 	return &conn{
 		Conn:         tlsConn,
 		localPeer:    t.localPeer,
@@ -173,6 +197,8 @@ func (t *Transport) setupConn(tlsConn *tls.Conn, remotePubKey ci.PubKey) (sec.Se
 		connectionState: network.ConnectionState{
 			StreamMultiplexer:         protocol.ID(nextProto),
 			UsedEarlyMuxerNegotiation: nextProto != "",
+			TLS:                       NewTLSConnectionState(cs),
+			IdentifySnapshot:          identifySnapshot,
 		},
 	}, nil
 }
@@ -1,6 +1,7 @@
 package libp2ptls
 
 import (
+	"bytes"
 	"context"
 	"crypto"
 	"crypto/ecdsa"
@@ -15,8 +16,10 @@ import (
 	"math/big"
 	mrand "math/rand"
 	"net"
+	"os"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -25,6 +28,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/core/sec"
 	tptu "github.com/libp2p/go-libp2p/p2p/net/upgrader"
+	"github.com/libp2p/go-libp2p/p2p/security/membership"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -296,6 +300,102 @@ func (c *delayedConn) Read(b []byte) (int, error) {
 	return c.Conn.Read(b)
 }
 
+func TestConnectionStateTLS(t *testing.T) {
+	_, clientKey := createPeer(t)
+	serverID, serverKey := createPeer(t)
+	clientTransport, err := New(ID, clientKey, nil)
+	require.NoError(t, err)
+	serverTransport, err := New(ID, serverKey, nil)
+	require.NoError(t, err)
+
+	clientInsecureConn, serverInsecureConn := connect(t)
+	serverConnChan := make(chan sec.SecureConn, 1)
+	go func() {
+		serverConn, err := serverTransport.SecureInbound(context.Background(), serverInsecureConn, "")
+		assert.NoError(t, err)
+		serverConnChan <- serverConn
+	}()
+	clientConn, err := clientTransport.SecureOutbound(context.Background(), clientInsecureConn, serverID)
+	require.NoError(t, err)
+	defer clientConn.Close()
+	serverConn := <-serverConnChan
+	require.NotNil(t, serverConn)
+	defer serverConn.Close()
+
+	check := func(t *testing.T, conn sec.SecureConn, remoteKey ic.PubKey) {
+		state := conn.ConnState().TLS
+		require.NotNil(t, state)
+		require.Equal(t, uint16(tls.VersionTLS13), state.Version)
+		require.NotEmpty(t, tls.CipherSuiteName(state.CipherSuite))
+		require.Equal(t, "libp2p", state.NegotiatedProtocol)
+		require.Len(t, state.PeerCertificates, 1)
+		require.NotNil(t, state.PeerKeyExtension)
+		keyBytes, err := ic.MarshalPublicKey(remoteKey)
+		require.NoError(t, err)
+		require.Equal(t, keyBytes, state.PeerKeyExtension.PublicKey)
+		require.NotEmpty(t, state.PeerKeyExtension.Signature)
+	}
+	check(t, clientConn, serverKey.GetPublic())
+	check(t, serverConn, clientKey.GetPublic())
+}
+
+// recordingConn records everything written to the connection.
+type recordingConn struct {
+	net.Conn
+	mx      sync.Mutex
+	written bytes.Buffer
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.mx.Lock()
+	c.written.Write(b)
+	c.mx.Unlock()
+	return c.Conn.Write(b)
+}
+
+func (c *recordingConn) Written() []byte {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return append([]byte(nil), c.written.Bytes()...)
+}
+
+func TestHandshakeReplayFails(t *testing.T) {
+	_, clientKey := createPeer(t)
+	serverID, serverKey := createPeer(t)
+	clientTransport, err := New(ID, clientKey, nil)
+	require.NoError(t, err)
+	serverTransport, err := New(ID, serverKey, nil)
+	require.NoError(t, err)
+
+	// record the messages the client sends during a successful handshake
+	clientInsecureConn, serverInsecureConn := connect(t)
+	recConn := &recordingConn{Conn: clientInsecureConn}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := serverTransport.SecureInbound(context.Background(), serverInsecureConn, "")
+		assert.NoError(t, err)
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	conn, err := clientTransport.SecureOutbound(context.Background(), recConn, serverID)
+	require.NoError(t, err)
+	<-done
+	conn.Close()
+
+	// replay them to a new connection: the server derives new handshake keys,
+	// so the replayed Finished message doesn't verify
+	clientInsecureConn, serverInsecureConn = connect(t)
+	_, err = clientInsecureConn.Write(recConn.Written())
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = serverTransport.SecureInbound(ctx, serverInsecureConn, "")
+	require.Error(t, err)
+	require.NotErrorIs(t, err, context.DeadlineExceeded)
+}
+
 func TestHandshakeConnectionCancellations(t *testing.T) {
 	_, clientKey := createPeer(t)
 	serverID, serverKey := createPeer(t)
@@ -422,6 +522,85 @@ func TestPeerIDMismatch(t *testing.T) {
 	})
 }
 
+func TestMembership(t *testing.T) {
+	authority, _, err := ic.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	newTransport := func(t *testing.T, isMember bool) (peer.ID, *Transport) {
+		id, key := createPeer(t)
+		var opts []IdentityOption
+		if isMember {
+			cert, err := membership.Issue(authority, &membership.Certificate{Network: "test", Peer: id, NotAfter: time.Now().Add(time.Hour)})
+			require.NoError(t, err)
+			m, err := membership.New(authority.GetPublic(), cert)
+			require.NoError(t, err)
+			opts = append(opts, WithMembership(m))
+		}
+		tr, err := New(ID, key, nil, opts...)
+		require.NoError(t, err)
+		return id, tr
+	}
+
+	handshake := func(t *testing.T, clientIsMember, serverIsMember bool) (clientErr, serverErr error) {
+		_, clientTransport := newTransport(t, clientIsMember)
+		serverID, serverTransport := newTransport(t, serverIsMember)
+		clientInsecureConn, serverInsecureConn := connect(t)
+
+		errChan := make(chan error, 1)
+		go func() {
+			_, err := serverTransport.SecureInbound(context.Background(), serverInsecureConn, "")
+			errChan <- err
+		}()
+		conn, err := clientTransport.SecureOutbound(context.Background(), clientInsecureConn, serverID)
+		if err == nil {
+			// the client only learns about rejections by the server when reading
+			conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			if _, err = conn.Read([]byte{0}); os.IsTimeout(err) {
+				err = nil
+			}
+		}
+		select {
+		case serverErr = <-errChan:
+		case <-time.After(250 * time.Millisecond):
+			t.Fatal("expected handshake to return on the server side")
+		}
+		return err, serverErr
+	}
+
+	t.Run("both members", func(t *testing.T) {
+		clientErr, serverErr := handshake(t, true, true)
+		require.NoError(t, clientErr)
+		require.NoError(t, serverErr)
+	})
+
+	t.Run("client not a member", func(t *testing.T) {
+		clientErr, serverErr := handshake(t, false, true)
+		require.Error(t, clientErr)
+		require.ErrorIs(t, serverErr, membership.ErrNoCertificate)
+	})
+
+	t.Run("server not a member", func(t *testing.T) {
+		clientErr, serverErr := handshake(t, true, false)
+		require.ErrorIs(t, clientErr, membership.ErrNoCertificate)
+		require.Error(t, serverErr)
+	})
+
+	t.Run("certificate template", func(t *testing.T) {
+		id, key := createPeer(t)
+		cert, err := membership.Issue(authority, &membership.Certificate{Network: "test", Peer: id, NotAfter: time.Now().Add(time.Hour)})
+		require.NoError(t, err)
+		m, err := membership.New(authority.GetPublic(), cert)
+		require.NoError(t, err)
+		tmpl, err := certTemplate()
+		require.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			_, err := NewIdentity(key, WithCertTemplate(tmpl), WithMembership(m))
+			require.NoError(t, err)
+		}
+		// the template of the caller isn't modified
+		require.Empty(t, tmpl.ExtraExtensions)
+	})
+}
+
 func TestInvalidCerts(t *testing.T) {
 	_, clientKey := createPeer(t)
 	serverID, serverKey := createPeer(t)
@@ -709,3 +888,275 @@ func TestInvalidCerts(t *testing.T) {
 		})
 	}
 }
+
+func TestIdentifySnapshot(t *testing.T) {
+	clientID, clientKey := createPeer(t)
+	serverID, serverKey := createPeer(t)
+	clientTransport, err := New(ID, clientKey, nil)
+	require.NoError(t, err)
+	serverTransport, err := New(ID, serverKey, nil)
+	require.NoError(t, err)
+	serverSnapshot := []byte("server")
+	serverTransport.SetIdentifySnapshot(func() []byte { return serverSnapshot })
+	clientTransport.SetIdentifySnapshot(func() []byte { return []byte("client") })
+
+	handshake := func(t *testing.T) (clientConn, serverConn sec.SecureConn) {
+		clientInsecureConn, serverInsecureConn := connect(t)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			var err error
+			serverConn, err = serverTransport.SecureInbound(context.Background(), serverInsecureConn, clientID)
+			assert.NoError(t, err)
+		}()
+		clientConn, err := clientTransport.SecureOutbound(context.Background(), clientInsecureConn, serverID)
+		require.NoError(t, err)
+		<-done
+		t.Cleanup(func() {
+			clientConn.Close()
+			serverConn.Close()
+		})
+		return clientConn, serverConn
+	}
+
+	clientConn, serverConn := handshake(t)
+	require.Equal(t, []byte("server"), clientConn.ConnState().IdentifySnapshot)
+	require.Equal(t, []byte("client"), serverConn.ConnState().IdentifySnapshot)
+
+	// a new certificate is generated when the snapshot changes
+	serverSnapshot = []byte("updated")
+	clientConn, _ = handshake(t)
+	require.Equal(t, []byte("updated"), clientConn.ConnState().IdentifySnapshot)
+
+	// snapshots exceeding the maximum size aren't sent
+	serverSnapshot = make([]byte, sec.MaxIdentifySnapshotSize+1)
+	clientConn, _ = handshake(t)
+	require.Nil(t, clientConn.ConnState().IdentifySnapshot)
+}
+
+// newTestCA returns a self-signed certificate authority.
+func newTestCA(t *testing.T) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
+// issuePKICertificate issues a certificate for the libp2p key priv.
+func issuePKICertificate(t *testing.T, ca *x509.Certificate, caKey crypto.Signer, name string, priv ic.PrivKey) tls.Certificate {
+	t.Helper()
+	pub, err := ic.PubKeyToStdKey(priv.GetPublic())
+	require.NoError(t, err)
+	stdPriv, err := ic.PrivKeyToStdKey(priv)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(mrand.Int63()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, pub, caKey)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: stdPriv}
+}
+
+func TestPKI(t *testing.T) {
+	ca, caKey := newTestCA(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	newPeer := func(t *testing.T, name string, approve func([]*x509.Certificate, peer.ID) error) (peer.ID, *Transport) {
+		priv, _, err := ic.GenerateECDSAKeyPair(rand.Reader)
+		require.NoError(t, err)
+		id, err := peer.IDFromPrivateKey(priv)
+		require.NoError(t, err)
+		tr, err := New(PKIID, priv, nil, WithPKI(PKIConfig{
+			Certificate: issuePKICertificate(t, ca, caKey, name, priv),
+			Roots:       roots,
+			Approve:     approve,
+		}))
+		require.NoError(t, err)
+		return id, tr
+	}
+
+	handshake := func(t *testing.T, clientTransport, serverTransport *Transport, serverID peer.ID) (sec.SecureConn, sec.SecureConn, error, error) {
+		clientInsecureConn, serverInsecureConn := connect(t)
+		type result struct {
+			conn sec.SecureConn
+			err  error
+		}
+		serverChan := make(chan result, 1)
+		go func() {
+			conn, err := serverTransport.SecureInbound(context.Background(), serverInsecureConn, "")
+			serverChan <- result{conn, err}
+		}()
+		clientConn, clientErr := clientTransport.SecureOutbound(context.Background(), clientInsecureConn, serverID)
+		if clientErr == nil {
+			// the client only learns about rejections by the server when reading
+			clientConn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			if _, err := clientConn.Read([]byte{0}); err != nil && !os.IsTimeout(err) {
+				clientErr = err
+			}
+		}
+		var res result
+		select {
+		case res = <-serverChan:
+		case <-time.After(time.Second):
+			t.Fatal("expected handshake to return on the server side")
+		}
+		return clientConn, res.conn, clientErr, res.err
+	}
+
+	t.Run("approved", func(t *testing.T) {
+		var approved []string
+		var mx sync.Mutex
+		approve := func(chain []*x509.Certificate, p peer.ID) error {
+			mx.Lock()
+			defer mx.Unlock()
+			// the verified chain ends with the root
+			approved = append(approved, chain[0].Subject.CommonName+" by "+chain[len(chain)-1].Subject.CommonName)
+			return nil
+		}
+		clientID, clientTransport := newPeer(t, "client", approve)
+		serverID, serverTransport := newPeer(t, "server", approve)
+		clientConn, serverConn, clientErr, serverErr := handshake(t, clientTransport, serverTransport, serverID)
+		require.NoError(t, clientErr)
+		require.NoError(t, serverErr)
+		defer clientConn.Close()
+		defer serverConn.Close()
+		require.Equal(t, serverID, clientConn.RemotePeer())
+		require.Equal(t, clientID, serverConn.RemotePeer())
+		mx.Lock()
+		defer mx.Unlock()
+		require.ElementsMatch(t, []string{"client by test CA", "server by test CA"}, approved)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		_, clientTransport := newPeer(t, "client", nil)
+		serverID, serverTransport := newPeer(t, "server", func(chain []*x509.Certificate, _ peer.ID) error {
+			return fmt.Errorf("%s isn't allowed", chain[0].Subject.CommonName)
+		})
+		_, _, clientErr, serverErr := handshake(t, clientTransport, serverTransport, serverID)
+		require.Error(t, clientErr)
+		require.ErrorContains(t, serverErr, "client isn't allowed")
+	})
+
+	t.Run("unknown authority", func(t *testing.T) {
+		otherCA, otherCAKey := newTestCA(t)
+		priv, _, err := ic.GenerateECDSAKeyPair(rand.Reader)
+		require.NoError(t, err)
+		clientTransport, err := New(PKIID, priv, nil, WithPKI(PKIConfig{
+			Certificate: issuePKICertificate(t, otherCA, otherCAKey, "client", priv),
+			Roots:       roots,
+		}))
+		require.NoError(t, err)
+		serverID, serverTransport := newPeer(t, "server", nil)
+		_, _, clientErr, serverErr := handshake(t, clientTransport, serverTransport, serverID)
+		require.Error(t, clientErr)
+		require.ErrorContains(t, serverErr, "certificate verification failed")
+	})
+
+	t.Run("libp2p peer", func(t *testing.T) {
+		_, key := createPeer(t)
+		clientTransport, err := New(ID, key, nil)
+		require.NoError(t, err)
+		serverID, serverTransport := newPeer(t, "server", nil)
+		_, _, clientErr, serverErr := handshake(t, clientTransport, serverTransport, serverID)
+		require.Error(t, clientErr)
+		require.Error(t, serverErr)
+	})
+
+	t.Run("peer ID mapping", func(t *testing.T) {
+		ids := make(map[string]peer.ID)
+		var mx sync.Mutex
+		newMappedPeer := func(t *testing.T, name string) (peer.ID, *Transport) {
+			hostKey, _, err := ic.GenerateECDSAKeyPair(rand.Reader)
+			require.NoError(t, err)
+			id, err := peer.IDFromPrivateKey(hostKey)
+			require.NoError(t, err)
+			mx.Lock()
+			ids[name] = id
+			mx.Unlock()
+			tr, err := New(PKIID, hostKey, nil, WithPKI(PKIConfig{
+				Certificate: issuePKICertificate(t, ca, caKey, name, hostKey),
+				Roots:       roots,
+				PeerID: func(chain []*x509.Certificate) (peer.ID, error) {
+					mx.Lock()
+					defer mx.Unlock()
+					id, ok := ids[chain[0].Subject.CommonName]
+					if !ok {
+						return "", fmt.Errorf("unknown peer %s", chain[0].Subject.CommonName)
+					}
+					return id, nil
+				},
+			}))
+			require.NoError(t, err)
+			return id, tr
+		}
+		clientID, clientTransport := newMappedPeer(t, "mapped client")
+		serverID, serverTransport := newMappedPeer(t, "mapped server")
+		clientConn, serverConn, clientErr, serverErr := handshake(t, clientTransport, serverTransport, serverID)
+		require.NoError(t, clientErr)
+		require.NoError(t, serverErr)
+		defer clientConn.Close()
+		defer serverConn.Close()
+		require.Equal(t, serverID, clientConn.RemotePeer())
+		require.Equal(t, clientID, serverConn.RemotePeer())
+		require.True(t, clientID.MatchesPublicKey(serverConn.RemotePublicKey()))
+
+		// a peer without a mapping is rejected
+		_, clientTransport = newMappedPeer(t, "unknown client")
+		mx.Lock()
+		delete(ids, "unknown client")
+		mx.Unlock()
+		_, _, clientErr, serverErr = handshake(t, clientTransport, serverTransport, serverID)
+		require.Error(t, clientErr)
+		require.ErrorContains(t, serverErr, "unknown peer unknown client")
+
+		// a peer whose certificate maps to another peer ID is rejected
+		_, clientTransport = newMappedPeer(t, "impostor client")
+		mx.Lock()
+		ids["impostor client"] = clientID
+		mx.Unlock()
+		_, _, clientErr, serverErr = handshake(t, clientTransport, serverTransport, serverID)
+		require.Error(t, clientErr)
+		require.ErrorContains(t, serverErr, "was issued for the key of")
+	})
+
+	t.Run("libp2p protocol ID", func(t *testing.T) {
+		priv, _, err := ic.GenerateECDSAKeyPair(rand.Reader)
+		require.NoError(t, err)
+		_, err = New(ID, priv, nil, WithPKI(PKIConfig{
+			Certificate: issuePKICertificate(t, ca, caKey, "client", priv),
+			Roots:       roots,
+		}))
+		require.ErrorContains(t, err, "can't use the protocol ID")
+	})
+
+	t.Run("certificate for another key", func(t *testing.T) {
+		priv, _, err := ic.GenerateECDSAKeyPair(rand.Reader)
+		require.NoError(t, err)
+		other, _, err := ic.GenerateECDSAKeyPair(rand.Reader)
+		require.NoError(t, err)
+		_, err = New(PKIID, priv, nil, WithPKI(PKIConfig{
+			Certificate: issuePKICertificate(t, ca, caKey, "client", other),
+			Roots:       roots,
+		}))
+		require.ErrorContains(t, err, "wasn't issued for the host key")
+	})
+}
@@ -0,0 +1,96 @@
+package benchmark
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	stdtls "crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	tls "github.com/libp2p/go-libp2p/p2p/security/tls"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPKIHosts connects two hosts that authenticate each other using
+// certificates issued by a private CA, and map the certificates to peer IDs.
+func TestPKIHosts(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	require.NoError(t, err)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(crand.Reader, caTmpl, caTmpl, caKey.Public(), caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	ids := make(map[string]peer.ID)
+	newHost := func(t *testing.T, name string) host.Host {
+		priv, _, err := crypto.GenerateECDSAKeyPair(crand.Reader)
+		require.NoError(t, err)
+		id, err := peer.IDFromPrivateKey(priv)
+		require.NoError(t, err)
+		ids[name] = id
+
+		pub, err := crypto.PubKeyToStdKey(priv.GetPublic())
+		require.NoError(t, err)
+		stdPriv, err := crypto.PrivKeyToStdKey(priv)
+		require.NoError(t, err)
+		der, err := x509.CreateCertificate(crand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(int64(len(ids) + 1)),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		}, ca, pub, caKey)
+		require.NoError(t, err)
+
+		h, err := libp2p.New(
+			libp2p.Identity(priv),
+			libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+			libp2p.Security(tls.PKIID, tls.New, tls.WithPKI(tls.PKIConfig{
+				Certificate: stdtls.Certificate{Certificate: [][]byte{der}, PrivateKey: stdPriv},
+				Roots:       roots,
+				PeerID: func(chain []*x509.Certificate) (peer.ID, error) {
+					id, ok := ids[chain[0].Subject.CommonName]
+					if !ok {
+						return "", fmt.Errorf("unknown peer %s", chain[0].Subject.CommonName)
+					}
+					return id, nil
+				},
+			})),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+		return h
+	}
+	h1 := newHost(t, "host 1")
+	h2 := newHost(t, "host 2")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, h1.Connect(ctx, peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+	conns := h1.Network().ConnsToPeer(h2.ID())
+	require.NotEmpty(t, conns)
+	require.EqualValues(t, tls.PKIID, conns[0].ConnState().Security)
+	require.True(t, h2.ID().MatchesPublicKey(conns[0].RemotePublicKey()))
+}
@@ -74,6 +74,61 @@ func TestDialPeerTransientConnection(t *testing.T) {
 	require.Nil(t, conn)
 }
 
+func TestDialPeerAddrFilterForceDirect(t *testing.T) {
+	h1, err := libp2p.New(
+		libp2p.NoListenAddrs,
+		libp2p.EnableRelay(),
+	)
+	require.NoError(t, err)
+	defer h1.Close()
+
+	h2, err := libp2p.New(
+		libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		libp2p.EnableRelay(),
+	)
+	require.NoError(t, err)
+	defer h2.Close()
+
+	relay1, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer relay1.Close()
+
+	_, err = relay.New(relay1)
+	require.NoError(t, err)
+
+	relay1info := peer.AddrInfo{
+		ID:    relay1.ID(),
+		Addrs: relay1.Addrs(),
+	}
+	require.NoError(t, h1.Connect(context.Background(), relay1info))
+	require.NoError(t, h2.Connect(context.Background(), relay1info))
+
+	_, err = client.Reserve(context.Background(), h2, relay1info)
+	require.NoError(t, err)
+
+	relayaddr := ma.StringCast("/p2p/" + relay1info.ID.String() + "/p2p-circuit/p2p/" + h2.ID().String())
+	h1.Peerstore().AddAddr(h2.ID(), relayaddr, peerstore.TempAddrTTL)
+
+	isCircuit := func(a ma.Multiaddr) bool {
+		_, err := a.ValueForProtocol(ma.P_CIRCUIT)
+		return err == nil
+	}
+	ctx := network.WithDialAddrFilter(context.Background(), isCircuit, "test")
+	relayed, err := h1.Network().DialPeer(ctx, h2.ID())
+	require.NoError(t, err)
+	require.True(t, relayed.Stat().Transient)
+
+	direct := network.WithForceDirectDial(context.Background(), "test")
+	require.NoError(t, h1.Connect(direct, peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+	require.Len(t, h1.Network().ConnsToPeer(h2.ID()), 2)
+
+	// The only connection matching the filter is the relayed connection.
+	ctx = network.WithForceDirectDial(ctx, "test")
+	conn, err := h1.Network().DialPeer(ctx, h2.ID())
+	require.Error(t, err)
+	require.Nil(t, conn)
+}
+
 func TestNewStreamTransientConnection(t *testing.T) {
 	h1, err := libp2p.New(
 		libp2p.ListenAddrStrings("/ip4/127.0.0.1/udp/0/quic-v1"),
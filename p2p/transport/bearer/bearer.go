@@ -0,0 +1,275 @@
+// Package bearer adapts connection-oriented, frame-based links to libp2p
+// transports.
+//
+// It is the integration point for non-IP bearers such as Bluetooth LE L2CAP
+// channels or other local radios: a Bearer only needs to establish links and
+// send and receive frames of at most MTU bytes. The Transport turns links into
+// byte streams, and runs them through the upgrader, so the host uses the same
+// security and muxer stack as for any other transport.
+//
+// Bearers are identified by their multiaddrs. Bearers for address types that
+// are not part of go-multiaddr need to register their protocol (see
+// multiaddr.AddProtocol), using a code from the multicodec private use range
+// until a code is allocated.
+package bearer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/transport"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+var log = logging.Logger("bearer-tpt")
+
+// Link is a connection-oriented, reliable and ordered link that carries frames.
+//
+// A Link must preserve frame boundaries, and must not split, merge or reorder frames.
+type Link interface {
+	// ReadFrame reads the next frame. The returned slice is owned by the caller.
+	ReadFrame() ([]byte, error)
+	// WriteFrame writes a single frame of at most MTU bytes.
+	WriteFrame(frame []byte) error
+	// MTU is the maximum size of a frame.
+	MTU() int
+	// Close closes the link. Pending ReadFrame and WriteFrame calls return an error.
+	Close() error
+
+	// SetReadDeadline and SetWriteDeadline have the same semantics as the
+	// corresponding methods of net.Conn.
+	SetReadDeadline(time.Time) error
+	SetWriteDeadline(time.Time) error
+
+	LocalMultiaddr() ma.Multiaddr
+	RemoteMultiaddr() ma.Multiaddr
+}
+
+// LinkListener accepts incoming links.
+type LinkListener interface {
+	Accept() (Link, error)
+	Close() error
+	Multiaddr() ma.Multiaddr
+}
+
+// Bearer establishes links.
+type Bearer interface {
+	// Dial establishes a link to raddr.
+	Dial(ctx context.Context, raddr ma.Multiaddr) (Link, error)
+	// Listen listens for incoming links on laddr.
+	Listen(laddr ma.Multiaddr) (LinkListener, error)
+	// CanDial returns true if the bearer can dial addr.
+	CanDial(addr ma.Multiaddr) bool
+	// Protocols returns the multiaddr protocols handled by the bearer.
+	Protocols() []int
+}
+
+// Transport is a libp2p transport on top of a Bearer.
+type Transport struct {
+	bearer Bearer
+
+	// Connection upgrader for upgrading insecure stream connections to
+	// secure multiplex connections.
+	upgrader transport.Upgrader
+
+	rcmgr network.ResourceManager
+}
+
+var _ transport.Transport = &Transport{}
+
+// NewTransport creates a transport on top of the bearer b.
+//
+// To use it with libp2p.Transport, wrap it in a constructor:
+//
+//	libp2p.Transport(func(u transport.Upgrader, rcmgr network.ResourceManager) (*bearer.Transport, error) {
+//		return bearer.NewTransport(b, u, rcmgr)
+//	})
+func NewTransport(b Bearer, upgrader transport.Upgrader, rcmgr network.ResourceManager) (*Transport, error) {
+	if b == nil {
+		return nil, errors.New("bearer must not be nil")
+	}
+	if rcmgr == nil {
+		rcmgr = &network.NullResourceManager{}
+	}
+	return &Transport{bearer: b, upgrader: upgrader, rcmgr: rcmgr}, nil
+}
+
+// CanDial returns true if the bearer can dial the given multiaddr.
+func (t *Transport) CanDial(addr ma.Multiaddr) bool {
+	return t.bearer.CanDial(addr)
+}
+
+// Dial dials the peer at the remote address.
+func (t *Transport) Dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (transport.CapableConn, error) {
+	connScope, err := t.rcmgr.OpenConnection(network.DirOutbound, false, raddr)
+	if err != nil {
+		log.Debugw("resource manager blocked outgoing connection", "peer", p, "addr", raddr, "error", err)
+		return nil, err
+	}
+
+	c, err := t.dialWithScope(ctx, raddr, p, connScope)
+	if err != nil {
+		connScope.Done()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (t *Transport) dialWithScope(ctx context.Context, raddr ma.Multiaddr, p peer.ID, connScope network.ConnManagementScope) (transport.CapableConn, error) {
+	if err := connScope.SetPeer(p); err != nil {
+		log.Debugw("resource manager blocked outgoing connection for peer", "peer", p, "addr", raddr, "error", err)
+		return nil, err
+	}
+	link, err := t.bearer.Dial(ctx, raddr)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newConn(link)
+	if err != nil {
+		link.Close()
+		return nil, err
+	}
+	direction := network.DirOutbound
+	if ok, isClient, _ := network.GetSimultaneousConnect(ctx); ok && !isClient {
+		direction = network.DirInbound
+	}
+	return t.upgrader.Upgrade(ctx, t, c, direction, p, connScope)
+}
+
+// Listen listens on the given multiaddr.
+func (t *Transport) Listen(laddr ma.Multiaddr) (transport.Listener, error) {
+	ln, err := t.bearer.Listen(laddr)
+	if err != nil {
+		return nil, err
+	}
+	return t.upgrader.UpgradeListener(t, &listener{ln}), nil
+}
+
+// Protocols returns the list of terminal protocols this transport can dial.
+func (t *Transport) Protocols() []int {
+	return t.bearer.Protocols()
+}
+
+// Proxy always returns false for bearer transports.
+func (t *Transport) Proxy() bool {
+	return false
+}
+
+func (t *Transport) String() string {
+	if s, ok := t.bearer.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return "bearer"
+}
+
+// listener adapts a LinkListener to a manet.Listener.
+type listener struct {
+	LinkListener
+}
+
+var _ manet.Listener = &listener{}
+
+func (l *listener) Accept() (manet.Conn, error) {
+	for {
+		link, err := l.LinkListener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		c, err := newConn(link)
+		if err != nil {
+			log.Debugw("rejecting link", "addr", link.RemoteMultiaddr(), "error", err)
+			link.Close()
+			continue
+		}
+		return c, nil
+	}
+}
+
+func (l *listener) Addr() net.Addr {
+	return netAddr{l.Multiaddr()}
+}
+
+// conn adapts a Link to a manet.Conn, splitting writes into frames.
+type conn struct {
+	link Link
+	mtu  int
+
+	readMx sync.Mutex
+	// unread remainder of the last frame read
+	rbuf []byte
+
+	writeMx sync.Mutex
+}
+
+var _ manet.Conn = &conn{}
+
+func newConn(link Link) (*conn, error) {
+	mtu := link.MTU()
+	if mtu <= 0 {
+		return nil, fmt.Errorf("invalid MTU: %d", mtu)
+	}
+	return &conn{link: link, mtu: mtu}, nil
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	c.readMx.Lock()
+	defer c.readMx.Unlock()
+	for len(c.rbuf) == 0 {
+		frame, err := c.link.ReadFrame()
+		if err != nil {
+			return 0, err
+		}
+		c.rbuf = frame
+	}
+	n := copy(p, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	c.writeMx.Lock()
+	defer c.writeMx.Unlock()
+	var written int
+	for len(p) > 0 {
+		n := min(len(p), c.mtu)
+		if err := c.link.WriteFrame(p[:n]); err != nil {
+			return written, err
+		}
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (c *conn) Close() error { return c.link.Close() }
+
+func (c *conn) SetDeadline(t time.Time) error {
+	return errors.Join(c.link.SetReadDeadline(t), c.link.SetWriteDeadline(t))
+}
+func (c *conn) SetReadDeadline(t time.Time) error  { return c.link.SetReadDeadline(t) }
+func (c *conn) SetWriteDeadline(t time.Time) error { return c.link.SetWriteDeadline(t) }
+
+func (c *conn) LocalMultiaddr() ma.Multiaddr  { return c.link.LocalMultiaddr() }
+func (c *conn) RemoteMultiaddr() ma.Multiaddr { return c.link.RemoteMultiaddr() }
+func (c *conn) LocalAddr() net.Addr           { return netAddr{c.link.LocalMultiaddr()} }
+func (c *conn) RemoteAddr() net.Addr          { return netAddr{c.link.RemoteMultiaddr()} }
+
+// netAddr is a net.Addr for bearer addresses, which don't have a net.Addr representation.
+type netAddr struct {
+	maddr ma.Multiaddr
+}
+
+func (a netAddr) Network() string { return "bearer" }
+func (a netAddr) String() string  { return a.maddr.String() }
@@ -0,0 +1,364 @@
+package bearer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/sec"
+	"github.com/libp2p/go-libp2p/core/sec/insecure"
+	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
+	tptu "github.com/libp2p/go-libp2p/p2p/net/upgrader"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	ttransport "github.com/libp2p/go-libp2p/p2p/transport/testsuite"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+// P_RADIO is the multiaddr protocol of the test bearer, from the multicodec private use range.
+const P_RADIO = 0x300000
+
+func init() {
+	if err := ma.AddProtocol(ma.Protocol{
+		Name:  "x-radio-test",
+		Code:  P_RADIO,
+		VCode: ma.CodeToVarint(P_RADIO),
+		Size:  ma.LengthPrefixedVarSize,
+		Transcoder: ma.NewTranscoderFromFunctions(
+			func(s string) ([]byte, error) { return []byte(s), nil },
+			func(b []byte) (string, error) { return string(b), nil },
+			nil,
+		),
+	}); err != nil {
+		panic(err)
+	}
+}
+
+func radioAddr(name string) ma.Multiaddr {
+	return ma.StringCast("/x-radio-test/" + name)
+}
+
+// radio is a test bearer. All radios created by newRadio share the same medium.
+type radio struct {
+	mtu int
+
+	mx        sync.Mutex
+	listeners map[string]*radioListener
+	nextID    atomic.Uint64
+}
+
+var _ Bearer = &radio{}
+
+func newRadio(mtu int) *radio {
+	return &radio{mtu: mtu, listeners: make(map[string]*radioListener)}
+}
+
+func (r *radio) CanDial(addr ma.Multiaddr) bool {
+	_, err := addr.ValueForProtocol(P_RADIO)
+	return err == nil && len(addr.Protocols()) == 1
+}
+
+func (r *radio) Protocols() []int { return []int{P_RADIO} }
+func (r *radio) String() string   { return "radio" }
+
+func (r *radio) Dial(ctx context.Context, raddr ma.Multiaddr) (Link, error) {
+	name, err := raddr.ValueForProtocol(P_RADIO)
+	if err != nil {
+		return nil, err
+	}
+	r.mx.Lock()
+	l := r.listeners[name]
+	r.mx.Unlock()
+	if l == nil {
+		return nil, errors.New("connection refused")
+	}
+	local, remote := newLinkPair(radioAddr(fmt.Sprintf("dialer-%d", r.nextID.Add(1))), raddr, r.mtu)
+	select {
+	case l.links <- remote:
+		return local, nil
+	case <-l.closed:
+		return nil, errors.New("connection refused")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (r *radio) Listen(laddr ma.Multiaddr) (LinkListener, error) {
+	if !r.CanDial(laddr) {
+		return nil, fmt.Errorf("cannot listen on %s", laddr)
+	}
+	name, err := laddr.ValueForProtocol(P_RADIO)
+	if err != nil {
+		return nil, err
+	}
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if name == "0" {
+		name = strconv.FormatUint(r.nextID.Add(1), 10)
+	}
+	if _, ok := r.listeners[name]; ok {
+		return nil, fmt.Errorf("address %s already in use", laddr)
+	}
+	l := &radioListener{
+		radio:  r,
+		name:   name,
+		links:  make(chan *link),
+		closed: make(chan struct{}),
+	}
+	r.listeners[name] = l
+	return l, nil
+}
+
+type radioListener struct {
+	radio     *radio
+	name      string
+	links     chan *link
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (l *radioListener) Accept() (Link, error) {
+	select {
+	case c := <-l.links:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *radioListener) Close() error {
+	l.closeOnce.Do(func() {
+		l.radio.mx.Lock()
+		delete(l.radio.listeners, l.name)
+		l.radio.mx.Unlock()
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *radioListener) Multiaddr() ma.Multiaddr { return radioAddr(l.name) }
+
+// link is one end of an in-memory frame link.
+type link struct {
+	mtu                   int
+	in, out               chan []byte
+	localAddr, remoteAddr ma.Multiaddr
+
+	readDeadline, writeDeadline atomic.Pointer[time.Time]
+
+	closed, remoteClosed chan struct{}
+	closeOnce            *sync.Once
+}
+
+func newLinkPair(addr1, addr2 ma.Multiaddr, mtu int) (*link, *link) {
+	c1, c2 := make(chan []byte, 64), make(chan []byte, 64)
+	closed1, closed2 := make(chan struct{}), make(chan struct{})
+	l1 := &link{mtu: mtu, in: c1, out: c2, localAddr: addr1, remoteAddr: addr2, closed: closed1, remoteClosed: closed2, closeOnce: &sync.Once{}}
+	l2 := &link{mtu: mtu, in: c2, out: c1, localAddr: addr2, remoteAddr: addr1, closed: closed2, remoteClosed: closed1, closeOnce: &sync.Once{}}
+	return l1, l2
+}
+
+func deadlineTimer(d *atomic.Pointer[time.Time]) (<-chan time.Time, func()) {
+	t := d.Load()
+	if t == nil || t.IsZero() {
+		return nil, func() {}
+	}
+	timer := time.NewTimer(time.Until(*t))
+	return timer.C, func() { timer.Stop() }
+}
+
+func (l *link) ReadFrame() ([]byte, error) {
+	timeout, stop := deadlineTimer(&l.readDeadline)
+	defer stop()
+	select {
+	case f := <-l.in:
+		return f, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	case <-timeout:
+		return nil, os.ErrDeadlineExceeded
+	default:
+	}
+	select {
+	case f := <-l.in:
+		return f, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	case <-l.remoteClosed:
+		// drain frames sent before the remote closed the link
+		select {
+		case f := <-l.in:
+			return f, nil
+		default:
+			return nil, io.EOF
+		}
+	case <-timeout:
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (l *link) WriteFrame(frame []byte) error {
+	if len(frame) > l.mtu {
+		return fmt.Errorf("frame too large: %d > %d", len(frame), l.mtu)
+	}
+	timeout, stop := deadlineTimer(&l.writeDeadline)
+	defer stop()
+	f := append([]byte(nil), frame...)
+	select {
+	case <-l.closed:
+		return net.ErrClosed
+	case <-l.remoteClosed:
+		return io.ErrClosedPipe
+	default:
+	}
+	select {
+	case l.out <- f:
+		return nil
+	case <-l.closed:
+		return net.ErrClosed
+	case <-l.remoteClosed:
+		return io.ErrClosedPipe
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+func (l *link) MTU() int { return l.mtu }
+
+func (l *link) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *link) SetReadDeadline(t time.Time) error {
+	l.readDeadline.Store(&t)
+	return nil
+}
+
+func (l *link) SetWriteDeadline(t time.Time) error {
+	l.writeDeadline.Store(&t)
+	return nil
+}
+
+func (l *link) LocalMultiaddr() ma.Multiaddr  { return l.localAddr }
+func (l *link) RemoteMultiaddr() ma.Multiaddr { return l.remoteAddr }
+
+var muxers = []tptu.StreamMuxer{{ID: "/yamux", Muxer: yamux.DefaultTransport}}
+
+func makeTransport(t *testing.T, r *radio) (peer.ID, *Transport) {
+	t.Helper()
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+	u, err := tptu.New([]sec.SecureTransport{insecure.NewWithIdentity(insecure.ID, id, priv)}, muxers, nil, nil, nil)
+	require.NoError(t, err)
+	tr, err := NewTransport(r, u, nil)
+	require.NoError(t, err)
+	return id, tr
+}
+
+func TestBearerTransport(t *testing.T) {
+	r := newRadio(247)
+	peerA, ta := makeTransport(t, r)
+	_, tb := makeTransport(t, r)
+	ttransport.SubtestTransport(t, ta, tb, "/x-radio-test/0", peerA)
+}
+
+// framingLink records the size of the frames written.
+type framingLink struct {
+	*link
+	sizes []int
+}
+
+func (l *framingLink) WriteFrame(frame []byte) error {
+	l.sizes = append(l.sizes, len(frame))
+	return l.link.WriteFrame(frame)
+}
+
+func TestFraming(t *testing.T) {
+	l1, l2 := newLinkPair(radioAddr("a"), radioAddr("b"), 23)
+	fl := &framingLink{link: l1}
+	c1, err := newConn(fl)
+	require.NoError(t, err)
+	c2, err := newConn(l2)
+	require.NoError(t, err)
+
+	msg := make([]byte, 100)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	n, err := c1.Write(msg)
+	require.NoError(t, err)
+	require.Equal(t, len(msg), n)
+	require.Equal(t, []int{23, 23, 23, 23, 8}, fl.sizes)
+
+	// read with a buffer that doesn't line up with the frame boundaries
+	received := make([]byte, 0, len(msg))
+	buf := make([]byte, 10)
+	for len(received) < len(msg) {
+		n, err := c2.Read(buf)
+		require.NoError(t, err)
+		require.LessOrEqual(t, n, 10)
+		received = append(received, buf[:n]...)
+	}
+	require.Equal(t, msg, received)
+
+	require.NoError(t, c2.SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+	_, err = c2.Read(buf)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	require.NoError(t, c1.Close())
+	require.NoError(t, c2.SetReadDeadline(time.Time{}))
+	_, err = c2.Read(buf)
+	require.ErrorIs(t, err, io.EOF)
+}
+
+type zeroMTULink struct{ *link }
+
+func (zeroMTULink) MTU() int { return 0 }
+
+func TestInvalidMTU(t *testing.T) {
+	l, _ := newLinkPair(radioAddr("a"), radioAddr("b"), 23)
+	_, err := newConn(zeroMTULink{l})
+	require.Error(t, err)
+}
+
+func TestHost(t *testing.T) {
+	r := newRadio(247)
+	newHost := func() host.Host {
+		h, err := libp2p.New(
+			libp2p.Transport(func(u transport.Upgrader, rcmgr network.ResourceManager) (*Transport, error) {
+				return NewTransport(r, u, rcmgr)
+			}),
+			libp2p.ListenAddrStrings("/x-radio-test/0"),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+		require.Len(t, h.Addrs(), 1)
+		return h
+	}
+	h1 := newHost()
+	h2 := newHost()
+
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	res := <-ping.Ping(ctx, h1, h2.ID())
+	require.NoError(t, res.Error)
+}
@@ -0,0 +1,85 @@
+package bearer_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/transport/bearer"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// l2capChannel is the platform's L2CAP connection-oriented channel (e.g. BlueZ
+// on Linux, CoreBluetooth's CBL2CAPChannel on Apple platforms). L2CAP channels
+// in LE credit-based flow control mode are reliable and preserve SDU
+// boundaries, so they map directly to a bearer.Link.
+type l2capChannel interface {
+	ReceiveSDU() ([]byte, error)
+	SendSDU([]byte) error
+	// PeerMTU is the maximum SDU size the remote device accepts.
+	PeerMTU() int
+	SetReadDeadline(time.Time) error
+	SetWriteDeadline(time.Time) error
+	Close() error
+}
+
+// l2capLink adapts an L2CAP channel to a bearer.Link.
+type l2capLink struct {
+	ch            l2capChannel
+	local, remote ma.Multiaddr
+}
+
+func (l *l2capLink) ReadFrame() ([]byte, error)         { return l.ch.ReceiveSDU() }
+func (l *l2capLink) WriteFrame(frame []byte) error      { return l.ch.SendSDU(frame) }
+func (l *l2capLink) MTU() int                           { return l.ch.PeerMTU() }
+func (l *l2capLink) Close() error                       { return l.ch.Close() }
+func (l *l2capLink) SetReadDeadline(t time.Time) error  { return l.ch.SetReadDeadline(t) }
+func (l *l2capLink) SetWriteDeadline(t time.Time) error { return l.ch.SetWriteDeadline(t) }
+func (l *l2capLink) LocalMultiaddr() ma.Multiaddr       { return l.local }
+func (l *l2capLink) RemoteMultiaddr() ma.Multiaddr      { return l.remote }
+
+var errNotImplemented = errors.New("not implemented")
+
+// l2capBearer is the skeleton of a BLE L2CAP bearer. A real implementation
+// registers a multiaddr protocol for BLE addresses (device address and PSM),
+// connects to the device in Dial, and publishes an L2CAP PSM (and advertises
+// it, so that peers can discover it) in Listen.
+type l2capBearer struct{}
+
+var _ bearer.Bearer = l2capBearer{}
+
+func (l2capBearer) Dial(ctx context.Context, raddr ma.Multiaddr) (bearer.Link, error) {
+	// Parse the device address and PSM from raddr, open the L2CAP channel,
+	// and wrap it in an l2capLink.
+	return nil, errNotImplemented
+}
+
+func (l2capBearer) Listen(laddr ma.Multiaddr) (bearer.LinkListener, error) {
+	// Publish the PSM in laddr, and return a LinkListener that wraps every
+	// incoming channel in an l2capLink.
+	return nil, errNotImplemented
+}
+
+func (l2capBearer) CanDial(addr ma.Multiaddr) bool { return false }
+func (l2capBearer) Protocols() []int               { return nil }
+func (l2capBearer) String() string                 { return "ble-l2cap" }
+
+// This example shows how a BLE L2CAP bearer plugs into a libp2p host. The
+// host secures and multiplexes connections over the bearer like it does for
+// any other transport.
+func Example_l2cap() {
+	h, err := libp2p.New(
+		libp2p.Transport(func(u transport.Upgrader, rcmgr network.ResourceManager) (*bearer.Transport, error) {
+			return bearer.NewTransport(l2capBearer{}, u, rcmgr)
+		}),
+		libp2p.NoListenAddrs,
+	)
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+}
@@ -0,0 +1,289 @@
+// Package memory implements an in-process transport (/memory/<id>).
+//
+// Unlike mocknet, it is a regular transport: it is registered like any other
+// transport, and connections are upgraded (secured and multiplexed) by the
+// upgrader. This is useful for applications that embed multiple hosts in a
+// single process, such as simulations and gateways.
+//
+// All memory transports in a process share the same address space: a host
+// listening on /memory/1234 can be dialed by any other host in the process.
+// Listening on /memory/0 picks an unused address.
+package memory
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/transport"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+var log = logging.Logger("memory-tpt")
+
+// P_MEMORY is the multicodec of the memory protocol.
+const P_MEMORY = 0x0309
+
+func init() {
+	if ma.ProtocolWithCode(P_MEMORY).Code != 0 {
+		// already registered by go-multiaddr
+		return
+	}
+	if err := ma.AddProtocol(ma.Protocol{
+		Name:       "memory",
+		Code:       P_MEMORY,
+		VCode:      ma.CodeToVarint(P_MEMORY),
+		Size:       64,
+		Transcoder: ma.NewTranscoderFromFunctions(memoryStB, memoryBtS, memoryValidate),
+	}); err != nil {
+		panic(err)
+	}
+}
+
+func memoryStB(s string) ([]byte, error) {
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse memory addr: %s", err)
+	}
+	return binary.BigEndian.AppendUint64(nil, id), nil
+}
+
+func memoryBtS(b []byte) (string, error) {
+	if err := memoryValidate(b); err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(binary.BigEndian.Uint64(b), 10), nil
+}
+
+func memoryValidate(b []byte) error {
+	if len(b) != 8 {
+		return fmt.Errorf("invalid length for memory addr: %d", len(b))
+	}
+	return nil
+}
+
+// ErrConnectionRefused is returned when dialing an address nobody is listening on.
+var ErrConnectionRefused = errors.New("memory transport: connection refused")
+
+// addr is the net.Addr of a memory connection.
+type addr uint64
+
+func (a addr) Network() string { return "memory" }
+func (a addr) String() string  { return strconv.FormatUint(uint64(a), 10) }
+
+func (a addr) Multiaddr() ma.Multiaddr {
+	return ma.StringCast("/memory/" + a.String())
+}
+
+func toAddr(m ma.Multiaddr) (addr, error) {
+	s, err := m.ValueForProtocol(P_MEMORY)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return addr(id), nil
+}
+
+// listeners is the process-wide registry of memory listeners.
+var listeners = struct {
+	sync.Mutex
+	m map[addr]*listener
+}{m: make(map[addr]*listener)}
+
+// register registers l at a, or at an unused address if a is 0.
+func register(a addr, l *listener) (addr, error) {
+	listeners.Lock()
+	defer listeners.Unlock()
+	if a == 0 {
+		for a == 0 || listeners.m[a] != nil {
+			a = addr(rand.Uint64())
+		}
+	} else if listeners.m[a] != nil {
+		return 0, fmt.Errorf("memory transport: address %d already in use", a)
+	}
+	listeners.m[a] = l
+	return a, nil
+}
+
+func unregister(a addr) {
+	listeners.Lock()
+	defer listeners.Unlock()
+	delete(listeners.m, a)
+}
+
+func getListener(a addr) *listener {
+	listeners.Lock()
+	defer listeners.Unlock()
+	return listeners.m[a]
+}
+
+// newEphemeralAddr returns an address for the dialing side of a connection.
+// It is not registered, and can't be dialed.
+func newEphemeralAddr() addr {
+	listeners.Lock()
+	defer listeners.Unlock()
+	for {
+		if a := addr(rand.Uint64()); a != 0 && listeners.m[a] == nil {
+			return a
+		}
+	}
+}
+
+// conn is a memory connection.
+type conn struct {
+	net.Conn
+}
+
+var _ manet.Conn = &conn{}
+
+func (c *conn) LocalMultiaddr() ma.Multiaddr  { return c.LocalAddr().(addr).Multiaddr() }
+func (c *conn) RemoteMultiaddr() ma.Multiaddr { return c.RemoteAddr().(addr).Multiaddr() }
+
+// listener is a memory listener.
+type listener struct {
+	addr addr
+
+	conns     chan *conn
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+var _ manet.Listener = &listener{}
+
+func (l *listener) Accept() (manet.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *listener) Close() error {
+	l.closeOnce.Do(func() {
+		unregister(l.addr)
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *listener) Addr() net.Addr          { return l.addr }
+func (l *listener) Multiaddr() ma.Multiaddr { return l.addr.Multiaddr() }
+
+// MemoryTransport is the in-process transport.
+type MemoryTransport struct {
+	// Connection upgrader for upgrading insecure stream connections to
+	// secure multiplex connections.
+	upgrader transport.Upgrader
+
+	rcmgr network.ResourceManager
+}
+
+var _ transport.Transport = &MemoryTransport{}
+
+// NewMemoryTransport creates a memory transport.
+func NewMemoryTransport(upgrader transport.Upgrader, rcmgr network.ResourceManager) (*MemoryTransport, error) {
+	if rcmgr == nil {
+		rcmgr = &network.NullResourceManager{}
+	}
+	return &MemoryTransport{upgrader: upgrader, rcmgr: rcmgr}, nil
+}
+
+// CanDial returns true if this transport believes it can dial the given
+// multiaddr.
+func (t *MemoryTransport) CanDial(a ma.Multiaddr) bool {
+	_, err := toAddr(a)
+	return err == nil && len(a.Protocols()) == 1
+}
+
+// Dial dials the peer at the remote address.
+func (t *MemoryTransport) Dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (transport.CapableConn, error) {
+	connScope, err := t.rcmgr.OpenConnection(network.DirOutbound, false, raddr)
+	if err != nil {
+		log.Debugw("resource manager blocked outgoing connection", "peer", p, "addr", raddr, "error", err)
+		return nil, err
+	}
+
+	c, err := t.dialWithScope(ctx, raddr, p, connScope)
+	if err != nil {
+		connScope.Done()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (t *MemoryTransport) dialWithScope(ctx context.Context, raddr ma.Multiaddr, p peer.ID, connScope network.ConnManagementScope) (transport.CapableConn, error) {
+	if err := connScope.SetPeer(p); err != nil {
+		log.Debugw("resource manager blocked outgoing connection for peer", "peer", p, "addr", raddr, "error", err)
+		return nil, err
+	}
+	a, err := toAddr(raddr)
+	if err != nil {
+		return nil, err
+	}
+	l := getListener(a)
+	if l == nil {
+		return nil, ErrConnectionRefused
+	}
+	local, remote := newPipe(newEphemeralAddr(), a)
+	select {
+	case l.conns <- &conn{remote}:
+	case <-l.closed:
+		return nil, ErrConnectionRefused
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	direction := network.DirOutbound
+	if ok, isClient, _ := network.GetSimultaneousConnect(ctx); ok && !isClient {
+		direction = network.DirInbound
+	}
+	return t.upgrader.Upgrade(ctx, t, &conn{local}, direction, p, connScope)
+}
+
+// Listen listens on the given multiaddr. Listening on /memory/0 picks an unused address.
+func (t *MemoryTransport) Listen(laddr ma.Multiaddr) (transport.Listener, error) {
+	if !t.CanDial(laddr) {
+		return nil, fmt.Errorf("memory transport cannot listen on %q", laddr)
+	}
+	a, err := toAddr(laddr)
+	if err != nil {
+		return nil, err
+	}
+	l := &listener{
+		conns:  make(chan *conn),
+		closed: make(chan struct{}),
+	}
+	l.addr, err = register(a, l)
+	if err != nil {
+		return nil, err
+	}
+	return t.upgrader.UpgradeListener(t, l), nil
+}
+
+// Protocols returns the list of terminal protocols this transport can dial.
+func (t *MemoryTransport) Protocols() []int {
+	return []int{P_MEMORY}
+}
+
+// Proxy always returns false for the memory transport.
+func (t *MemoryTransport) Proxy() bool {
+	return false
+}
+
+func (t *MemoryTransport) String() string {
+	return "memory"
+}
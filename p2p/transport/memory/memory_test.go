@@ -0,0 +1,105 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/sec"
+	"github.com/libp2p/go-libp2p/core/sec/insecure"
+	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
+	tptu "github.com/libp2p/go-libp2p/p2p/net/upgrader"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	ttransport "github.com/libp2p/go-libp2p/p2p/transport/testsuite"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+var muxers = []tptu.StreamMuxer{{ID: "/yamux", Muxer: yamux.DefaultTransport}}
+
+func makeInsecureMuxer(t *testing.T) (peer.ID, []sec.SecureTransport) {
+	t.Helper()
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+	return id, []sec.SecureTransport{insecure.NewWithIdentity(insecure.ID, id, priv)}
+}
+
+func makeTransport(t *testing.T) (peer.ID, *MemoryTransport) {
+	t.Helper()
+	id, sec := makeInsecureMuxer(t)
+	u, err := tptu.New(sec, muxers, nil, nil, nil)
+	require.NoError(t, err)
+	tr, err := NewMemoryTransport(u, nil)
+	require.NoError(t, err)
+	return id, tr
+}
+
+func TestMemoryTransport(t *testing.T) {
+	peerA, ta := makeTransport(t)
+	_, tb := makeTransport(t)
+	ttransport.SubtestTransport(t, ta, tb, "/memory/0", peerA)
+}
+
+func TestMultiaddr(t *testing.T) {
+	maddr, err := ma.NewMultiaddr("/memory/1234")
+	require.NoError(t, err)
+	require.Equal(t, "/memory/1234", maddr.String())
+	a, err := toAddr(maddr)
+	require.NoError(t, err)
+	require.Equal(t, addr(1234), a)
+
+	_, err = ma.NewMultiaddr("/memory/foo")
+	require.Error(t, err)
+}
+
+func TestListen(t *testing.T) {
+	_, tr := makeTransport(t)
+	listenAddr := ma.StringCast("/memory/4242")
+	ln, err := tr.Listen(listenAddr)
+	require.NoError(t, err)
+	require.True(t, ln.Multiaddr().Equal(listenAddr))
+
+	// the address is in use
+	_, err = tr.Listen(listenAddr)
+	require.Error(t, err)
+
+	peerA, _ := makeInsecureMuxer(t)
+	ln.Close()
+	_, err = tr.Dial(context.Background(), listenAddr, peerA)
+	require.ErrorIs(t, err, ErrConnectionRefused)
+
+	// the address can be reused once the listener is closed
+	ln, err = tr.Listen(listenAddr)
+	require.NoError(t, err)
+	ln.Close()
+
+	require.False(t, tr.CanDial(ma.StringCast("/ip4/127.0.0.1/tcp/1234")))
+	require.False(t, tr.CanDial(ma.StringCast("/memory/1234/p2p-circuit")))
+}
+
+func TestHost(t *testing.T) {
+	newHost := func() host.Host {
+		h, err := libp2p.New(
+			libp2p.Transport(NewMemoryTransport),
+			libp2p.ListenAddrStrings("/memory/0"),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+		require.Len(t, h.Addrs(), 1)
+		return h
+	}
+	h1 := newHost()
+	h2 := newHost()
+
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	res := <-ping.Ping(ctx, h1, h2.ID())
+	require.NoError(t, res.Error)
+}
@@ -0,0 +1,232 @@
+package memory
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxBufferSize is the number of bytes a writer can buffer before it blocks
+// until the reader catches up.
+const maxBufferSize = 1 << 20 // 1 MiB
+
+// buffer is a unidirectional, buffered byte stream.
+type buffer struct {
+	mu           sync.Mutex
+	buf          bytes.Buffer
+	writerClosed bool
+	readerClosed bool
+	// changed is closed (and replaced) whenever the state of the buffer changes
+	changed chan struct{}
+}
+
+func newBuffer() *buffer {
+	return &buffer{changed: make(chan struct{})}
+}
+
+// signal wakes up all goroutines waiting for a state change. b.mu must be held.
+func (b *buffer) signal() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// wait waits until the state of the buffer changes or the deadline is reached.
+// b.mu must be held, and is held again when wait returns.
+func (b *buffer) wait(d *deadline) error {
+	changed := b.changed
+	b.mu.Unlock()
+	defer b.mu.Lock()
+	timeout, deadlineChanged := d.wait()
+	select {
+	case <-changed:
+		return nil
+	case <-deadlineChanged:
+		return nil
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+func (b *buffer) read(p []byte, d *deadline) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		if b.readerClosed {
+			return 0, net.ErrClosed
+		}
+		if b.buf.Len() > 0 {
+			n, _ := b.buf.Read(p)
+			b.signal()
+			return n, nil
+		}
+		if b.writerClosed {
+			return 0, io.EOF
+		}
+		if d.exceeded() {
+			return 0, os.ErrDeadlineExceeded
+		}
+		if err := b.wait(d); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (b *buffer) write(p []byte, d *deadline) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var written int
+	for len(p) > 0 {
+		if b.writerClosed {
+			return written, net.ErrClosed
+		}
+		if b.readerClosed {
+			return written, io.ErrClosedPipe
+		}
+		if d.exceeded() {
+			return written, os.ErrDeadlineExceeded
+		}
+		if free := maxBufferSize - b.buf.Len(); free > 0 {
+			n := min(free, len(p))
+			b.buf.Write(p[:n])
+			p = p[n:]
+			written += n
+			b.signal()
+			continue
+		}
+		if err := b.wait(d); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (b *buffer) closeWriter() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.writerClosed = true
+	b.signal()
+}
+
+func (b *buffer) closeReader() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.readerClosed = true
+	b.buf.Reset()
+	b.signal()
+}
+
+// deadline is a read or write deadline.
+type deadline struct {
+	mu    sync.Mutex
+	gen   uint64 // incremented every time the deadline is set
+	timer *time.Timer
+	// timeout is closed when the deadline is reached
+	timeout chan struct{}
+	// changed is closed (and replaced) when the deadline is changed
+	changed chan struct{}
+}
+
+func newDeadline() *deadline {
+	return &deadline{timeout: make(chan struct{}), changed: make(chan struct{})}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	select {
+	case <-d.timeout:
+		d.timeout = make(chan struct{})
+	default:
+	}
+	d.gen++
+	if !t.IsZero() {
+		if dur := time.Until(t); dur > 0 {
+			gen := d.gen
+			d.timer = time.AfterFunc(dur, func() {
+				d.mu.Lock()
+				defer d.mu.Unlock()
+				// The deadline might have been changed since the timer was started.
+				if d.gen == gen {
+					close(d.timeout)
+				}
+			})
+		} else {
+			close(d.timeout)
+		}
+	}
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+func (d *deadline) exceeded() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	select {
+	case <-d.timeout:
+		return true
+	default:
+		return false
+	}
+}
+
+func (d *deadline) wait() (timeout, changed <-chan struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.timeout, d.changed
+}
+
+// pipeConn is one end of an in-memory, buffered, full-duplex connection.
+type pipeConn struct {
+	r, w                  *buffer
+	readDeadline          *deadline
+	writeDeadline         *deadline
+	localAddr, remoteAddr net.Addr
+	closeOnce             sync.Once
+}
+
+var _ net.Conn = &pipeConn{}
+
+// newPipe creates a connected pair of connections.
+func newPipe(addr1, addr2 net.Addr) (*pipeConn, *pipeConn) {
+	b1, b2 := newBuffer(), newBuffer()
+	c1 := &pipeConn{r: b1, w: b2, readDeadline: newDeadline(), writeDeadline: newDeadline(), localAddr: addr1, remoteAddr: addr2}
+	c2 := &pipeConn{r: b2, w: b1, readDeadline: newDeadline(), writeDeadline: newDeadline(), localAddr: addr2, remoteAddr: addr1}
+	return c1, c2
+}
+
+func (c *pipeConn) Read(p []byte) (int, error)  { return c.r.read(p, c.readDeadline) }
+func (c *pipeConn) Write(p []byte) (int, error) { return c.w.write(p, c.writeDeadline) }
+
+func (c *pipeConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.w.closeWriter()
+		c.r.closeReader()
+	})
+	return nil
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.localAddr }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.remoteAddr }
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *pipeConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
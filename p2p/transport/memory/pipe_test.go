@@ -0,0 +1,86 @@
+package memory
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipe(t *testing.T) {
+	c1, c2 := newPipe(addr(1), addr(2))
+	require.Equal(t, addr(1), c1.LocalAddr())
+	require.Equal(t, addr(2), c1.RemoteAddr())
+
+	// writes don't block as long as the buffer isn't full
+	data := bytes.Repeat([]byte("foobar"), 1000)
+	_, err := c1.Write(data)
+	require.NoError(t, err)
+	_, err = c2.Write(data)
+	require.NoError(t, err)
+
+	buf := make([]byte, len(data))
+	_, err = io.ReadFull(c2, buf)
+	require.NoError(t, err)
+	require.Equal(t, data, buf)
+	_, err = io.ReadFull(c1, buf)
+	require.NoError(t, err)
+	require.Equal(t, data, buf)
+
+	// a large write only completes once the reader catches up
+	large := make([]byte, 3*maxBufferSize)
+	done := make(chan error, 1)
+	go func() {
+		_, err := c1.Write(large)
+		done <- err
+	}()
+	_, err = io.ReadFull(c2, make([]byte, len(large)))
+	require.NoError(t, err)
+	require.NoError(t, <-done)
+
+	c1.Close()
+	_, err = c2.Read(buf)
+	require.ErrorIs(t, err, io.EOF)
+	_, err = c2.Write(buf)
+	require.ErrorIs(t, err, io.ErrClosedPipe)
+	_, err = c1.Read(buf)
+	require.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestPipeDeadlines(t *testing.T) {
+	c1, c2 := newPipe(addr(1), addr(2))
+	defer c1.Close()
+	defer c2.Close()
+
+	c1.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err := c1.Read(make([]byte, 1))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	var nerr net.Error
+	require.ErrorAs(t, err, &nerr)
+	require.True(t, nerr.Timeout())
+
+	// reset the deadline
+	c1.SetReadDeadline(time.Time{})
+	go c2.Write([]byte("foo"))
+	_, err = c1.Read(make([]byte, 3))
+	require.NoError(t, err)
+
+	// setting a deadline in the past unblocks a pending read
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := c1.Read(make([]byte, 1))
+		errCh <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	c1.SetReadDeadline(time.Now())
+	require.ErrorIs(t, <-errCh, os.ErrDeadlineExceeded)
+
+	// write deadline
+	c1.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = c1.Write(make([]byte, 2*maxBufferSize))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
@@ -0,0 +1,223 @@
+package libp2pquic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/transport/quicreuse"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+// QUIC address discovery
+//
+// Both endpoints of a connection tell each other the address they observe
+// the other endpoint on, using the OBSERVED_ADDRESS frames defined by the
+// QUIC address discovery extension (draft-ietf-quic-address-discovery).
+// quic-go doesn't implement the extension (yet), so the frames are sent in
+// QUIC datagrams (RFC 9221), which are supported by all our connections.
+//
+// quic-go doesn't allow us to send custom transport parameters either, so
+// the extension is negotiated using ALPN: a client that supports it offers
+// alpnAddrDiscovery before "libp2p", and a server that supports it selects
+// it. The frames are only sent on connections that negotiated it.
+//
+// This allows us to learn our reflexive UDP address as soon as the handshake
+// completes, without STUN and independently of identify.
+
+// alpnAddrDiscovery is the ALPN offered by clients that support QUIC address
+// discovery. Apart from that, it's the same protocol as "libp2p".
+const alpnAddrDiscovery = "libp2p+address-discovery"
+
+// datagramQueueLen is the number of datagrams that are not OBSERVED_ADDRESS
+// frames, queued until they are received using ReceiveDatagram.
+const datagramQueueLen = 32
+
+const (
+	observedAddrIPv4FrameType = 0x9f81a6
+	observedAddrIPv6FrameType = 0x9f81a7
+)
+
+// observedAddrResendIntervals are the delays after which the OBSERVED_ADDRESS
+// frame is sent again, since datagrams are not retransmitted when they're lost.
+var observedAddrResendIntervals = []time.Duration{time.Second, 5 * time.Second}
+
+var errInvalidObservedAddrFrame = errors.New("invalid OBSERVED_ADDRESS frame")
+
+func appendObservedAddrFrame(b []byte, seq uint64, addr *net.UDPAddr) ([]byte, error) {
+	if ip := addr.IP.To4(); ip != nil {
+		b = quicvarint.Append(b, observedAddrIPv4FrameType)
+		b = quicvarint.Append(b, seq)
+		b = append(b, ip...)
+	} else if ip := addr.IP.To16(); ip != nil {
+		b = quicvarint.Append(b, observedAddrIPv6FrameType)
+		b = quicvarint.Append(b, seq)
+		b = append(b, ip...)
+	} else {
+		return nil, fmt.Errorf("invalid IP address: %s", addr.IP)
+	}
+	return append(b, byte(addr.Port>>8), byte(addr.Port)), nil
+}
+
+// isObservedAddrFrame says if the datagram b carries an OBSERVED_ADDRESS frame.
+func isObservedAddrFrame(b []byte) bool {
+	typ, err := quicvarint.Read(bytes.NewReader(b))
+	return err == nil && (typ == observedAddrIPv4FrameType || typ == observedAddrIPv6FrameType)
+}
+
+func parseObservedAddrFrame(b []byte) (seq uint64, addr *net.UDPAddr, err error) {
+	r := bytes.NewReader(b)
+	typ, err := quicvarint.Read(r)
+	if err != nil {
+		return 0, nil, errInvalidObservedAddrFrame
+	}
+	var ipLen int
+	switch typ {
+	case observedAddrIPv4FrameType:
+		ipLen = net.IPv4len
+	case observedAddrIPv6FrameType:
+		ipLen = net.IPv6len
+	default:
+		return 0, nil, errInvalidObservedAddrFrame
+	}
+	seq, err = quicvarint.Read(r)
+	if err != nil {
+		return 0, nil, errInvalidObservedAddrFrame
+	}
+	if r.Len() != ipLen+2 {
+		return 0, nil, errInvalidObservedAddrFrame
+	}
+	rest := b[len(b)-r.Len():]
+	ip := make(net.IP, ipLen)
+	copy(ip, rest)
+	port := int(rest[ipLen])<<8 | int(rest[ipLen+1])
+	return seq, &net.UDPAddr{IP: ip, Port: port}, nil
+}
+
+// startAddrDiscovery starts sending the address we observe the peer on, and
+// receiving the address the peer observes us on, if the peer negotiated QUIC
+// address discovery.
+func (c *conn) startAddrDiscovery() {
+	state := c.quicConn.ConnectionState()
+	if state.TLS.NegotiatedProtocol != alpnAddrDiscovery || !state.SupportsDatagrams {
+		return
+	}
+	c.addrDiscovery = true
+	c.datagrams = make(chan []byte, datagramQueueLen)
+	go c.sendObservedAddr()
+	go c.receiveDatagrams()
+}
+
+func (c *conn) sendObservedAddr() {
+	raddr, ok := c.quicConn.RemoteAddr().(*net.UDPAddr)
+	if !ok {
+		return
+	}
+	// quic-go doesn't support connection migration, so the remote address
+	// doesn't change during the lifetime of the connection.
+	frame, err := appendObservedAddrFrame(nil, 0, raddr)
+	if err != nil {
+		return
+	}
+	ctx := c.quicConn.Context()
+	for _, d := range append([]time.Duration{0}, observedAddrResendIntervals...) {
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return
+		}
+		if err := c.quicConn.SendDatagram(frame); err != nil {
+			log.Debugw("failed to send OBSERVED_ADDRESS frame", "peer", c.remotePeerID, "error", err)
+			return
+		}
+	}
+}
+
+// receiveDatagrams handles the OBSERVED_ADDRESS frames received on the
+// connection, and queues all other datagrams for ReceiveDatagram.
+func (c *conn) receiveDatagrams() {
+	defer close(c.datagrams)
+	version := c.quicConn.ConnectionState().Version
+	var gotFrame bool
+	var lastSeq uint64
+	for {
+		b, err := c.quicConn.ReceiveDatagram(c.quicConn.Context())
+		if err != nil {
+			return
+		}
+		if !isObservedAddrFrame(b) {
+			select {
+			case c.datagrams <- b:
+			default:
+				// Like quic-go, drop datagrams if nobody receives them.
+				log.Debugw("dropping datagram, queue full", "peer", c.remotePeerID)
+			}
+			continue
+		}
+		seq, addr, err := parseObservedAddrFrame(b)
+		if err != nil {
+			log.Debugw("ignoring OBSERVED_ADDRESS frame", "peer", c.remotePeerID, "error", err)
+			continue
+		}
+		// Frames with a lower sequence number than the last frame are outdated.
+		if gotFrame && seq <= lastSeq {
+			continue
+		}
+		observed, err := quicreuse.ToQuicMultiaddr(addr, version)
+		if err != nil {
+			continue
+		}
+		gotFrame = true
+		lastSeq = seq
+
+		c.observedAddrMx.Lock()
+		c.observedAddr = observed
+		handlers := c.observedAddrHandlers
+		c.observedAddrMx.Unlock()
+		for _, f := range handlers {
+			f(observed)
+		}
+	}
+}
+
+// ReceiveDatagram receives a datagram sent by the peer. On connections that
+// negotiated QUIC address discovery, OBSERVED_ADDRESS frames are never
+// returned.
+func (c *conn) ReceiveDatagram(ctx context.Context) ([]byte, error) {
+	if !c.addrDiscovery {
+		return c.quicConn.ReceiveDatagram(ctx)
+	}
+	select {
+	case b, ok := <-c.datagrams:
+		if !ok {
+			return nil, net.ErrClosed
+		}
+		return b, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+var _ network.ObservedAddrConn = &conn{}
+
+// NotifyObservedAddr calls f with our address as observed by the peer, every
+// time the peer reports a new one.
+func (c *conn) NotifyObservedAddr(f func(ma.Multiaddr)) error {
+	if !c.addrDiscovery {
+		return network.ErrObservedAddrNotSupported
+	}
+	c.observedAddrMx.Lock()
+	c.observedAddrHandlers = append(c.observedAddrHandlers, f)
+	observed := c.observedAddr
+	c.observedAddrMx.Unlock()
+	if observed != nil {
+		f(observed)
+	}
+	return nil
+}
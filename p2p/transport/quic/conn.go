@@ -2,11 +2,13 @@ package libp2pquic
 
 import (
 	"context"
+	"sync"
 
 	ic "github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	tpt "github.com/libp2p/go-libp2p/core/transport"
+	p2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
 
 	ma "github.com/multiformats/go-multiaddr"
 	"github.com/quic-go/quic-go"
@@ -23,6 +25,15 @@ type conn struct {
 	remotePeerID    peer.ID
 	remotePubKey    ic.PubKey
 	remoteMultiaddr ma.Multiaddr
+
+	// set if QUIC address discovery was negotiated
+	addrDiscovery bool
+	// datagrams that are not OBSERVED_ADDRESS frames
+	datagrams chan []byte
+
+	observedAddrMx       sync.Mutex
+	observedAddr         ma.Multiaddr
+	observedAddrHandlers []func(ma.Multiaddr)
 }
 
 var _ tpt.CapableConn = &conn{}
@@ -87,5 +98,8 @@ func (c *conn) ConnState() network.ConnectionState {
 	if _, err := c.LocalMultiaddr().ValueForProtocol(ma.P_QUIC); err == nil {
 		t = "quic"
 	}
-	return network.ConnectionState{Transport: t}
+	return network.ConnectionState{
+		Transport: t,
+		TLS:       p2ptls.NewTLSConnectionState(c.quicConn.ConnectionState().TLS),
+	}
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -18,6 +19,7 @@ import (
 	mocknetwork "github.com/libp2p/go-libp2p/core/network/mocks"
 	"github.com/libp2p/go-libp2p/core/peer"
 	tpt "github.com/libp2p/go-libp2p/core/transport"
+	tmux "github.com/libp2p/go-libp2p/p2p/muxer/testsuite"
 	"github.com/libp2p/go-libp2p/p2p/transport/quicreuse"
 
 	ma "github.com/multiformats/go-multiaddr"
@@ -109,6 +111,18 @@ func testHandshake(t *testing.T, tc *connTestCase) {
 		require.Equal(t, serverConn.LocalPeer(), serverID)
 		require.Equal(t, serverConn.RemotePeer(), clientID)
 		require.True(t, serverConn.RemotePublicKey().Equals(clientKey.GetPublic()), "remote public key doesn't match")
+
+		for _, c := range []tpt.CapableConn{conn, serverConn} {
+			state := c.ConnState().TLS
+			require.NotNil(t, state)
+			require.Equal(t, uint16(tls.VersionTLS13), state.Version)
+			require.Equal(t, "libp2p", state.NegotiatedProtocol)
+			require.Len(t, state.PeerCertificates, 1)
+			require.NotNil(t, state.PeerKeyExtension)
+			pubKey, err := ic.UnmarshalPublicKey(state.PeerKeyExtension.PublicKey)
+			require.NoError(t, err)
+			require.True(t, pubKey.Equals(c.RemotePublicKey()))
+		}
 	}
 
 	t.Run("on IPv4", func(t *testing.T) {
@@ -265,6 +279,30 @@ func testResourceManagerAcceptDenied(t *testing.T, tc *connTestCase) {
 	}
 }
 
+func TestStreamConformance(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+
+	serverTransport, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer serverTransport.(io.Closer).Close()
+	ln := runServer(t, serverTransport, "/ip4/127.0.0.1/udp/0/quic-v1")
+	defer ln.Close()
+	clientTransport, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer clientTransport.(io.Closer).Close()
+
+	tmux.SubtestStreamAll(t, func(t *testing.T) (network.MuxedStream, network.MuxedStream) {
+		conn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		serverConn, err := ln.Accept()
+		require.NoError(t, err)
+		t.Cleanup(func() { serverConn.Close() })
+		return tmux.OpenStreamPair(t, conn, serverConn)
+	})
+}
+
 func TestStreams(t *testing.T) {
 	for _, tc := range connTestCases {
 		t.Run(tc.Name, func(t *testing.T) {
@@ -674,3 +712,93 @@ func TestHolePunching(t *testing.T) {
 	<-done1
 	<-done2
 }
+
+func TestObservedAddr(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+
+	serverTransport, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer serverTransport.(io.Closer).Close()
+	ln := runServer(t, serverTransport, "/ip4/127.0.0.1/udp/0/quic-v1")
+	defer ln.Close()
+
+	dial := func(t *testing.T, opts ...Option) (clientConn, serverConn tpt.CapableConn) {
+		clientTransport, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil, opts...)
+		require.NoError(t, err)
+		t.Cleanup(func() { clientTransport.(io.Closer).Close() })
+		clientConn, err = clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		require.NoError(t, err)
+		t.Cleanup(func() { clientConn.Close() })
+		serverConn, err = ln.Accept()
+		require.NoError(t, err)
+		t.Cleanup(func() { serverConn.Close() })
+		return clientConn, serverConn
+	}
+	observedAddr := func(t *testing.T, c tpt.CapableConn) ma.Multiaddr {
+		ch := make(chan ma.Multiaddr, 1)
+		require.NoError(t, c.(network.ObservedAddrConn).NotifyObservedAddr(func(a ma.Multiaddr) {
+			select {
+			case ch <- a:
+			default:
+			}
+		}))
+		select {
+		case a := <-ch:
+			return a
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for the observed address")
+			return nil
+		}
+	}
+
+	t.Run("negotiated", func(t *testing.T) {
+		clientConn, serverConn := dial(t, EnableAddrDiscovery())
+		require.Equal(t, alpnAddrDiscovery, clientConn.ConnState().TLS.NegotiatedProtocol)
+		// the server observes the client on the remote address of its connection, and vice versa
+		observed := observedAddr(t, clientConn)
+		require.True(t, observed.Equal(serverConn.RemoteMultiaddr()), "expected %s, got %s", serverConn.RemoteMultiaddr(), observed)
+		observed = observedAddr(t, serverConn)
+		require.True(t, observed.Equal(ln.Multiaddr()), "expected %s, got %s", ln.Multiaddr(), observed)
+
+		// other datagrams are not consumed by address discovery
+		require.NoError(t, serverConn.(*conn).quicConn.SendDatagram([]byte("foobar")))
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		b, err := clientConn.(*conn).ReceiveDatagram(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []byte("foobar"), b)
+	})
+
+	t.Run("not offered", func(t *testing.T) {
+		clientConn, serverConn := dial(t)
+		for _, c := range []tpt.CapableConn{clientConn, serverConn} {
+			require.Equal(t, "libp2p", c.ConnState().TLS.NegotiatedProtocol)
+			err := c.(network.ObservedAddrConn).NotifyObservedAddr(func(ma.Multiaddr) {})
+			require.ErrorIs(t, err, network.ErrObservedAddrNotSupported)
+		}
+	})
+}
+
+func TestObservedAddrFrame(t *testing.T) {
+	for _, addr := range []*net.UDPAddr{
+		{IP: net.IPv4(1, 2, 3, 4), Port: 1234},
+		{IP: net.ParseIP("2001:db8::1"), Port: 65535},
+	} {
+		b, err := appendObservedAddrFrame(nil, 42, addr)
+		require.NoError(t, err)
+		seq, parsed, err := parseObservedAddrFrame(b)
+		require.NoError(t, err)
+		require.Equal(t, uint64(42), seq)
+		require.True(t, parsed.IP.Equal(addr.IP))
+		require.Equal(t, addr.Port, parsed.Port)
+
+		// truncated and padded frames are invalid
+		_, _, err = parseObservedAddrFrame(b[:len(b)-1])
+		require.ErrorIs(t, err, errInvalidObservedAddrFrame)
+		_, _, err = parseObservedAddrFrame(append(b, 0))
+		require.ErrorIs(t, err, errInvalidObservedAddrFrame)
+	}
+	_, _, err := parseObservedAddrFrame([]byte("foobar"))
+	require.ErrorIs(t, err, errInvalidObservedAddrFrame)
+}
@@ -113,3 +113,29 @@ func TestCorrectNumberOfVirtualListeners(t *testing.T) {
 	ln.Close()
 	require.Empty(t, tpt.listeners[udpAddr.String()])
 }
+
+func TestListenOnPortZeroMultipleTimes(t *testing.T) {
+	tr := newTransport(t, nil)
+	tpt := tr.(*transport)
+	defer tr.(io.Closer).Close()
+
+	localAddr := ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1")
+	ln1, err := tr.Listen(localAddr)
+	require.NoError(t, err)
+	ln2, err := tr.Listen(localAddr)
+	require.NoError(t, err)
+	require.False(t, ln1.Multiaddr().Equal(ln2.Multiaddr()), "expected listeners on different ports")
+
+	// listening on the same port and QUIC version again fails
+	_, err = tr.Listen(ln1.Multiaddr())
+	require.Error(t, err)
+
+	require.Len(t, tpt.connManager.ListenPacketConns(), 2)
+	require.NoError(t, ln1.Close())
+	// the socket of ln2 is still open
+	conns := tpt.connManager.ListenPacketConns()
+	require.Len(t, conns, 1)
+	require.Equal(t, ln2.(*virtualListener).Addr(), conns[0].LocalAddr())
+	require.NoError(t, ln2.Close())
+	require.Empty(t, tpt.listeners)
+}
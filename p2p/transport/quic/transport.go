@@ -16,6 +16,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/pnet"
 	tpt "github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/security/membership"
 	p2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
 	"github.com/libp2p/go-libp2p/p2p/transport/quicreuse"
 
@@ -39,6 +40,7 @@ type transport struct {
 	privKey     ic.PrivKey
 	localPeer   peer.ID
 	identity    *p2ptls.Identity
+	membership  *membership.Membership
 	connManager *quicreuse.ConnManager
 	gater       connmgr.ConnectionGater
 	rcmgr       network.ResourceManager
@@ -55,6 +57,9 @@ type transport struct {
 	listenersMu sync.Mutex
 	// map of UDPAddr as string to a virtualListeners
 	listeners map[string][]*virtualListener
+
+	// offer QUIC address discovery when dialing
+	addrDiscovery bool
 }
 
 var _ tpt.Transport = &transport{}
@@ -69,8 +74,31 @@ type activeHolePunch struct {
 	fulfilled bool
 }
 
+type Option func(*transport) error
+
+// EnableAddrDiscovery makes the transport offer QUIC address discovery when
+// dialing, so that the peers of outgoing connections report the address they
+// observe us on. Listeners always accept QUIC address discovery if the client
+// offers it.
+func EnableAddrDiscovery() Option {
+	return func(t *transport) error {
+		t.addrDiscovery = true
+		return nil
+	}
+}
+
+// WithMembership requires remote peers to present a certificate of the
+// network of m in the TLS handshake, like the WithMembership option of the
+// TLS security transport.
+func WithMembership(m *membership.Membership) Option {
+	return func(t *transport) error {
+		t.membership = m
+		return nil
+	}
+}
+
 // NewTransport creates a new QUIC transport
-func NewTransport(key ic.PrivKey, connManager *quicreuse.ConnManager, psk pnet.PSK, gater connmgr.ConnectionGater, rcmgr network.ResourceManager) (tpt.Transport, error) {
+func NewTransport(key ic.PrivKey, connManager *quicreuse.ConnManager, psk pnet.PSK, gater connmgr.ConnectionGater, rcmgr network.ResourceManager, opts ...Option) (tpt.Transport, error) {
 	if len(psk) > 0 {
 		log.Error("QUIC doesn't support private networks yet.")
 		return nil, errors.New("QUIC doesn't support private networks yet")
@@ -79,19 +107,13 @@ func NewTransport(key ic.PrivKey, connManager *quicreuse.ConnManager, psk pnet.P
 	if err != nil {
 		return nil, err
 	}
-	identity, err := p2ptls.NewIdentity(key)
-	if err != nil {
-		return nil, err
-	}
-
 	if rcmgr == nil {
 		rcmgr = &network.NullResourceManager{}
 	}
 
-	return &transport{
+	t := &transport{
 		privKey:      key,
 		localPeer:    localPeer,
-		identity:     identity,
 		connManager:  connManager,
 		gater:        gater,
 		rcmgr:        rcmgr,
@@ -100,7 +122,21 @@ func NewTransport(key ic.PrivKey, connManager *quicreuse.ConnManager, psk pnet.P
 		rnd:          *rand.New(rand.NewSource(time.Now().UnixNano())),
 
 		listeners: make(map[string][]*virtualListener),
-	}, nil
+	}
+	for _, o := range opts {
+		if err := o(t); err != nil {
+			return nil, err
+		}
+	}
+	var identityOpts []p2ptls.IdentityOption
+	if t.membership != nil {
+		identityOpts = append(identityOpts, p2ptls.WithMembership(t.membership))
+	}
+	t.identity, err = p2ptls.NewIdentity(key, identityOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
 }
 
 // Dial dials a new QUIC connection
@@ -130,6 +166,9 @@ func (t *transport) dialWithScope(ctx context.Context, raddr ma.Multiaddr, p pee
 	}
 
 	tlsConf, keyCh := t.identity.ConfigForPeer(p)
+	if t.addrDiscovery {
+		tlsConf.NextProtos = []string{alpnAddrDiscovery, "libp2p"}
+	}
 	pconn, err := t.connManager.DialQUIC(ctx, raddr, tlsConf, t.allowWindowIncrease)
 	if err != nil {
 		return nil, err
@@ -173,6 +212,7 @@ func (t *transport) addConn(conn quic.Connection, c *conn) {
 	t.connMx.Lock()
 	t.conns[conn] = c
 	t.connMx.Unlock()
+	c.startAddrDiscovery()
 }
 
 func (t *transport) removeConn(conn quic.Connection) {
@@ -285,9 +325,11 @@ func (t *transport) Listen(addr ma.Multiaddr) (tpt.Listener, error) {
 		// the peer ID calculated here, we don't actually receive the peer's public key
 		// from the key chan.
 		conf, _ := t.identity.ConfigForPeer("")
+		// Select QUIC address discovery if the client offers it.
+		conf.NextProtos = []string{alpnAddrDiscovery, "libp2p"}
 		return conf, nil
 	}
-	tlsConf.NextProtos = []string{"libp2p"}
+	tlsConf.NextProtos = []string{alpnAddrDiscovery, "libp2p"}
 	udpAddr, version, err := quicreuse.FromQuicMultiaddr(addr)
 	if err != nil {
 		return nil, err
@@ -298,7 +340,8 @@ func (t *transport) Listen(addr ma.Multiaddr) (tpt.Listener, error) {
 	listeners := t.listeners[udpAddr.String()]
 	var underlyingListener *listener
 	var acceptRunner *acceptLoopRunner
-	if len(listeners) != 0 {
+	// Every listen on port 0 binds a new port, so the underlying listener can't be shared.
+	if len(listeners) != 0 && udpAddr.Port != 0 {
 		// We already have an underlying listener, let's use it
 		underlyingListener = listeners[0].listener
 		acceptRunner = listeners[0].acceptRunnner
@@ -306,6 +349,9 @@ func (t *transport) Listen(addr ma.Multiaddr) (tpt.Listener, error) {
 		if _, ok := underlyingListener.localMultiaddrs[version]; !ok {
 			return nil, fmt.Errorf("can't listen on quic version %v, underlying listener doesn't support it", version)
 		}
+		if acceptRunner.hasVersion(version) {
+			return nil, fmt.Errorf("already listening on %s", addr)
+		}
 	} else {
 		ln, err := t.connManager.ListenQUIC(addr, &tlsConf, t.allowWindowIncrease)
 		if err != nil {
@@ -358,6 +404,11 @@ func (t *transport) Proxy() bool {
 	return false
 }
 
+// Capabilities returns the capabilities of the QUIC transport.
+func (t *transport) Capabilities() tpt.Capabilities {
+	return tpt.CapHolePunching | tpt.CapDatagrams | tpt.CapSharedUDPPort
+}
+
 // Protocols returns the set of protocols handled by this transport.
 func (t *transport) Protocols() []int {
 	return t.connManager.Protocols()
@@ -375,25 +426,26 @@ func (t *transport) CloseVirtualListener(l *virtualListener) error {
 	t.listenersMu.Lock()
 	defer t.listenersMu.Unlock()
 
-	var err error
 	listeners := t.listeners[l.udpAddr]
-	if len(listeners) == 1 {
-		// This is the last virtual listener here, so we can close the underlying listener
-		err = l.listener.Close()
-		delete(t.listeners, l.udpAddr)
-		return err
-	}
-
 	for i := 0; i < len(listeners); i++ {
 		// Swap remove
 		if l == listeners[i] {
 			listeners[i] = listeners[len(listeners)-1]
 			listeners = listeners[:len(listeners)-1]
-			t.listeners[l.udpAddr] = listeners
 			break
 		}
 	}
+	if len(listeners) == 0 {
+		delete(t.listeners, l.udpAddr)
+	} else {
+		t.listeners[l.udpAddr] = listeners
+	}
 
-	return nil
-
+	for _, vl := range listeners {
+		if vl.listener == l.listener {
+			return nil
+		}
+	}
+	// This was the last virtual listener using the underlying listener, so we can close it
+	return l.listener.Close()
 }
@@ -9,6 +9,7 @@ import (
 
 	ic "github.com/libp2p/go-libp2p/core/crypto"
 	tpt "github.com/libp2p/go-libp2p/core/transport"
+	ttransport "github.com/libp2p/go-libp2p/p2p/transport/testsuite"
 
 	ma "github.com/multiformats/go-multiaddr"
 	"github.com/stretchr/testify/require"
@@ -38,6 +39,19 @@ func TestQUICProtocol(t *testing.T) {
 	}
 }
 
+func TestQUICTransport(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+	ta, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer ta.(io.Closer).Close()
+	tb, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer tb.(io.Closer).Close()
+
+	ttransport.SubtestTransport(t, ta, tb, "/ip4/127.0.0.1/udp/0/quic-v1", serverID)
+}
+
 func TestCanDial(t *testing.T) {
 	tr := getTransport(t)
 	defer tr.(io.Closer).Close()
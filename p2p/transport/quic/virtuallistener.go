@@ -50,6 +50,13 @@ type acceptLoopRunner struct {
 	muxerClosed bool
 }
 
+func (r *acceptLoopRunner) hasVersion(v quic.VersionNumber) bool {
+	r.muxerMu.Lock()
+	defer r.muxerMu.Unlock()
+	_, ok := r.muxer[v]
+	return ok
+}
+
 func (r *acceptLoopRunner) AcceptForVersion(v quic.VersionNumber) chan acceptVal {
 	r.muxerMu.Lock()
 	defer r.muxerMu.Unlock()
@@ -4,9 +4,12 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"net"
 	"sync"
 
+	"github.com/libp2p/go-libp2p/p2p/net/activation"
+
 	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr/net"
 	"github.com/quic-go/quic-go"
@@ -27,6 +30,10 @@ type ConnManager struct {
 
 	srk      quic.StatelessResetKey
 	tokenKey quic.TokenGeneratorKey
+
+	inherited *activation.Sockets
+
+	dialSourceAddr func(raddr ma.Multiaddr) ma.Multiaddr
 }
 
 type quicListenerEntry struct {
@@ -63,6 +70,8 @@ func NewConnManager(statelessResetKey quic.StatelessResetKey, tokenKey quic.Toke
 	if cm.enableReuseport {
 		cm.reuseUDP4 = newReuse(&statelessResetKey, &tokenKey)
 		cm.reuseUDP6 = newReuse(&statelessResetKey, &tokenKey)
+		cm.reuseUDP4.listenUDP = cm.listenUDP
+		cm.reuseUDP6.listenUDP = cm.listenUDP
 	}
 	return cm, nil
 }
@@ -79,6 +88,20 @@ func (c *ConnManager) getReuse(network string) (*reuse, error) {
 }
 
 func (c *ConnManager) ListenQUIC(addr ma.Multiaddr, tlsConf *tls.Config, allowWindowIncrease func(conn quic.Connection, delta uint64) bool) (Listener, error) {
+	return c.ListenQUICWithConfig(addr, tlsConf, nil, allowWindowIncrease)
+}
+
+// ListenQUICWithConfig is like ListenQUIC, but uses quicConf instead of the
+// default server config. quicConf should be derived from ServerConfig, so that
+// it keeps the tracer.
+//
+// All protocols listening on the same address share a single QUIC listener,
+// and therefore a single quic.Config. Listening with a quicConf on an address
+// that is already used with a different config fails.
+func (c *ConnManager) ListenQUICWithConfig(addr ma.Multiaddr, tlsConf *tls.Config, quicConf *quic.Config, allowWindowIncrease func(conn quic.Connection, delta uint64) bool) (Listener, error) {
+	if quicConf == nil {
+		quicConf = c.serverConfig
+	}
 	netw, host, err := manet.DialArgs(addr)
 	if err != nil {
 		return nil, err
@@ -93,12 +116,15 @@ func (c *ConnManager) ListenQUIC(addr ma.Multiaddr, tlsConf *tls.Config, allowWi
 
 	key := laddr.String()
 	entry, ok := c.quicListeners[key]
+	if ok && entry.ln.config != quicConf {
+		return nil, fmt.Errorf("QUIC listener on %s already uses a different config", key)
+	}
 	if !ok {
 		tr, err := c.transportForListen(netw, laddr)
 		if err != nil {
 			return nil, err
 		}
-		ln, err := newQuicListener(tr, c.serverConfig)
+		ln, err := newQuicListener(tr, quicConf)
 		if err != nil {
 			return nil, err
 		}
@@ -117,6 +143,29 @@ func (c *ConnManager) ListenQUIC(addr ma.Multiaddr, tlsConf *tls.Config, allowWi
 	return l, nil
 }
 
+// PacketConn is the UDP socket of a QUIC listener. It can be used to send and
+// receive packets that are not QUIC packets (e.g. STUN), using the same NAT
+// mapping as the QUIC connections on the socket.
+type PacketConn interface {
+	// ReadNonQUICPacket reads the next packet received on the socket that is
+	// not a QUIC packet. It must not be called concurrently.
+	ReadNonQUICPacket(ctx context.Context, b []byte) (int, net.Addr, error)
+	WriteTo(b []byte, addr net.Addr) (int, error)
+	LocalAddr() net.Addr
+}
+
+// ListenPacketConns returns the sockets of all QUIC listeners.
+func (c *ConnManager) ListenPacketConns() []PacketConn {
+	c.quicListenersMu.Lock()
+	defer c.quicListenersMu.Unlock()
+
+	conns := make([]PacketConn, 0, len(c.quicListeners))
+	for _, entry := range c.quicListeners {
+		conns = append(conns, entry.ln.transport)
+	}
+	return conns
+}
+
 func (c *ConnManager) onListenerClosed(key string) {
 	c.quicListenersMu.Lock()
 	defer c.quicListenersMu.Unlock()
@@ -140,7 +189,7 @@ func (c *ConnManager) transportForListen(network string, laddr *net.UDPAddr) (re
 		return reuse.TransportForListen(network, laddr)
 	}
 
-	conn, err := net.ListenUDP(network, laddr)
+	conn, err := c.listenUDP(network, laddr)
 	if err != nil {
 		return nil, err
 	}
@@ -154,7 +203,26 @@ func (c *ConnManager) transportForListen(network string, laddr *net.UDPAddr) (re
 	}, nil
 }
 
+// listenUDP returns the inherited socket bound to laddr, if any, or creates a
+// new one.
+func (c *ConnManager) listenUDP(network string, laddr *net.UDPAddr) (*net.UDPConn, error) {
+	if conn := c.inherited.TakeUDP(network, laddr); conn != nil {
+		return conn, nil
+	}
+	return net.ListenUDP(network, laddr)
+}
+
 func (c *ConnManager) DialQUIC(ctx context.Context, raddr ma.Multiaddr, tlsConf *tls.Config, allowWindowIncrease func(conn quic.Connection, delta uint64) bool) (quic.Connection, error) {
+	return c.DialQUICWithConfig(ctx, raddr, tlsConf, nil, allowWindowIncrease)
+}
+
+// DialQUICWithConfig is like DialQUIC, but uses quicConf instead of the
+// default client config. quicConf should be derived from ClientConfig, so that
+// it keeps the tracer.
+func (c *ConnManager) DialQUICWithConfig(ctx context.Context, raddr ma.Multiaddr, tlsConf *tls.Config, quicConf *quic.Config, allowWindowIncrease func(conn quic.Connection, delta uint64) bool) (quic.Connection, error) {
+	if quicConf == nil {
+		quicConf = c.clientConfig
+	}
 	naddr, v, err := FromQuicMultiaddr(raddr)
 	if err != nil {
 		return nil, err
@@ -164,7 +232,7 @@ func (c *ConnManager) DialQUIC(ctx context.Context, raddr ma.Multiaddr, tlsConf
 		return nil, err
 	}
 
-	quicConf := c.clientConfig.Clone()
+	quicConf = quicConf.Clone()
 	quicConf.AllowConnectionWindowIncrease = allowWindowIncrease
 
 	if v == quic.Version1 {
@@ -174,7 +242,12 @@ func (c *ConnManager) DialQUIC(ctx context.Context, raddr ma.Multiaddr, tlsConf
 		return nil, errors.New("unknown QUIC version")
 	}
 
-	tr, err := c.TransportForDial(netw, naddr)
+	var tr refCountedQuicTransport
+	if src := c.sourceIP(raddr, naddr); src != nil {
+		tr, err = c.transportForDialFrom(netw, src)
+	} else {
+		tr, err = c.TransportForDial(netw, naddr)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -209,6 +282,44 @@ func (c *ConnManager) TransportForDial(network string, raddr *net.UDPAddr) (refC
 	return &singleOwnerTransport{Transport: quic.Transport{Conn: conn, StatelessResetKey: &c.srk}, packetConn: conn}, nil
 }
 
+// sourceIP returns the local IP address to dial raddr from, or nil if the
+// ConnManager should choose it.
+func (c *ConnManager) sourceIP(raddr ma.Multiaddr, naddr *net.UDPAddr) net.IP {
+	if c.dialSourceAddr == nil {
+		return nil
+	}
+	laddr := c.dialSourceAddr(raddr)
+	if laddr == nil {
+		return nil
+	}
+	ip, err := manet.ToIP(laddr)
+	if err != nil {
+		log.Debugw("invalid dial source address", "addr", laddr, "error", err)
+		return nil
+	}
+	if (ip.To4() == nil) != (naddr.IP.To4() == nil) {
+		return nil
+	}
+	return ip
+}
+
+// transportForDialFrom returns a transport to dial from the local IP address
+// src.
+func (c *ConnManager) transportForDialFrom(network string, src net.IP) (refCountedQuicTransport, error) {
+	if c.enableReuseport {
+		reuse, err := c.getReuse(network)
+		if err != nil {
+			return nil, err
+		}
+		return reuse.TransportForDialFrom(network, src)
+	}
+	conn, err := net.ListenUDP(network, &net.UDPAddr{IP: src})
+	if err != nil {
+		return nil, err
+	}
+	return &singleOwnerTransport{Transport: quic.Transport{Conn: conn, StatelessResetKey: &c.srk}, packetConn: conn}, nil
+}
+
 func (c *ConnManager) Protocols() []int {
 	return []int{ma.P_QUIC_V1}
 }
@@ -226,3 +337,7 @@ func (c *ConnManager) Close() error {
 func (c *ConnManager) ClientConfig() *quic.Config {
 	return c.clientConfig
 }
+
+func (c *ConnManager) ServerConfig() *quic.Config {
+	return c.serverConfig
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/net/activation"
 	libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
 
 	ma "github.com/multiformats/go-multiaddr"
@@ -77,6 +78,72 @@ func testListenOnSameProto(t *testing.T, enableReuseport bool) {
 	defer ln2.Close()
 }
 
+func TestListenWithConfig(t *testing.T) {
+	cm, err := NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{})
+	require.NoError(t, err)
+	defer checkClosed(t, cm)
+	defer cm.Close()
+
+	ln1, err := cm.ListenQUIC(ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1"), &tls.Config{NextProtos: []string{"proto1"}}, nil)
+	require.NoError(t, err)
+	defer ln1.Close()
+	addr := ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/udp/%d/quic-v1", ln1.Addr().(*net.UDPAddr).Port))
+
+	// the listener is shared, so it can't use a different config
+	conf := cm.ServerConfig().Clone()
+	conf.MaxIncomingStreams = 10
+	_, err = cm.ListenQUICWithConfig(addr, &tls.Config{NextProtos: []string{"proto2"}}, conf, nil)
+	require.Error(t, err)
+	ln2, err := cm.ListenQUICWithConfig(addr, &tls.Config{NextProtos: []string{"proto2"}}, nil, nil)
+	require.NoError(t, err)
+	defer ln2.Close()
+
+	// a listener on a different address uses the config
+	ln3, err := cm.ListenQUICWithConfig(ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1"), &tls.Config{NextProtos: []string{"proto1"}}, conf, nil)
+	require.NoError(t, err)
+	defer ln3.Close()
+	cm.quicListenersMu.Lock()
+	require.Same(t, conf, cm.quicListeners[ln3.Addr().String()].ln.config)
+	cm.quicListenersMu.Unlock()
+}
+
+func TestListenOnInheritedSocket(t *testing.T) {
+	t.Run("with reuseport", func(t *testing.T) {
+		testListenOnInheritedSocket(t, true)
+	})
+
+	t.Run("without reuseport", func(t *testing.T) {
+		testListenOnInheritedSocket(t, false)
+	})
+}
+
+func testListenOnInheritedSocket(t *testing.T, enableReuseport bool) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	f, err := conn.File()
+	require.NoError(t, err)
+	conn.Close()
+	sockets, err := activation.New(f)
+	require.NoError(t, err)
+	defer sockets.Close()
+
+	opts := []Option{WithInheritedSockets(sockets)}
+	if !enableReuseport {
+		opts = append(opts, DisableReuseport())
+	}
+	cm, err := NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{}, opts...)
+	require.NoError(t, err)
+	defer checkClosed(t, cm)
+	defer cm.Close()
+
+	laddr := conn.LocalAddr().(*net.UDPAddr)
+	ln, err := cm.ListenQUIC(ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/udp/%d/quic-v1", laddr.Port)), &tls.Config{NextProtos: []string{"proto"}}, nil)
+	require.NoError(t, err)
+	defer ln.Close()
+	require.Equal(t, laddr.String(), ln.Addr().String())
+	require.Zero(t, sockets.Len())
+}
+
 // The conn passed to quic-go should be a conn that quic-go can be
 // type-asserted to a UDPConn. That way, it can use all kinds of optimizations.
 func TestConnectionPassedToQUICForListening(t *testing.T) {
@@ -30,6 +30,7 @@ type protoConf struct {
 
 type quicListener struct {
 	l         *quic.Listener
+	config    *quic.Config // the config passed to newQuicListener
 	transport refCountedQuicTransport
 	running   chan struct{}
 	addrs     []ma.Multiaddr
@@ -48,6 +49,7 @@ func newQuicListener(tr refCountedQuicTransport, quicConfig *quic.Config) (*quic
 	cl := &quicListener{
 		protocols: map[string]protoConf{},
 		running:   make(chan struct{}),
+		config:    quicConfig,
 		transport: tr,
 		addrs:     localMultiaddrs,
 	}
@@ -1,5 +1,11 @@
 package quicreuse
 
+import (
+	"github.com/libp2p/go-libp2p/p2p/net/activation"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
 type Option func(*ConnManager) error
 
 func DisableReuseport() Option {
@@ -16,3 +22,29 @@ func EnableMetrics() Option {
 		return nil
 	}
 }
+
+// WithInheritedSockets makes the ConnManager use the UDP sockets inherited from
+// a parent process, e.g. using systemd socket activation, when listening on
+// their exact address. Addresses without a matching socket are bound as usual.
+func WithInheritedSockets(s *activation.Sockets) Option {
+	return func(m *ConnManager) error {
+		m.inherited = s
+		return nil
+	}
+}
+
+// WithDialSourceAddr sets a function that selects the local IP address to dial
+// raddr from, for example to use a different IP address depending on the
+// destination on a multi-homed host. The function returns an IP multiaddr
+// (e.g. /ip4/1.2.3.4), or nil to let the ConnManager choose the source
+// address. Addresses of a different IP family than raddr are ignored.
+//
+// This applies to the QUIC and the WebTransport transports. The dial uses the
+// socket of a listener bound to the selected IP address, if there is one, so
+// that peers observe the listening address.
+func WithDialSourceAddr(f func(raddr ma.Multiaddr) ma.Multiaddr) Option {
+	return func(m *ConnManager) error {
+		m.dialSourceAddr = f
+		return nil
+	}
+}
@@ -17,6 +17,8 @@ type refCountedQuicTransport interface {
 
 	// Used to send packets directly around QUIC. Useful for hole punching.
 	WriteTo([]byte, net.Addr) (int, error)
+	// Used to receive packets that are not QUIC packets, e.g. STUN responses.
+	ReadNonQUICPacket(context.Context, []byte) (int, net.Addr, error)
 
 	Close() error
 
@@ -124,6 +126,9 @@ type reuse struct {
 
 	statelessResetKey *quic.StatelessResetKey
 	tokenGeneratorKey *quic.TokenGeneratorKey
+
+	// listenUDP creates the sockets of listeners.
+	listenUDP func(network string, laddr *net.UDPAddr) (*net.UDPConn, error)
 }
 
 func newReuse(srk *quic.StatelessResetKey, tokenKey *quic.TokenGeneratorKey) *reuse {
@@ -135,6 +140,7 @@ func newReuse(srk *quic.StatelessResetKey, tokenKey *quic.TokenGeneratorKey) *re
 		gcStopChan:        make(chan struct{}),
 		statelessResetKey: srk,
 		tokenGeneratorKey: tokenKey,
+		listenUDP:         net.ListenUDP,
 	}
 	go r.gc()
 	return r
@@ -232,6 +238,37 @@ func (r *reuse) TransportForDial(network string, raddr *net.UDPAddr) (*refcounte
 	return tr, nil
 }
 
+// TransportForDialFrom returns a transport to dial from the local IP address
+// src. It reuses a transport bound to src, or binds a new one.
+func (r *reuse) TransportForDialFrom(network string, src net.IP) (*refcountedTransport, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, tr := range r.unicast[src.String()] {
+		tr.IncreaseCount()
+		return tr, nil
+	}
+
+	conn, err := net.ListenUDP(network, &net.UDPAddr{IP: src})
+	if err != nil {
+		return nil, err
+	}
+	tr := &refcountedTransport{Transport: quic.Transport{
+		Conn:              conn,
+		StatelessResetKey: r.statelessResetKey,
+		TokenGeneratorKey: r.tokenGeneratorKey,
+	}, packetConn: conn}
+	tr.IncreaseCount()
+	if _, ok := r.unicast[src.String()]; !ok {
+		r.unicast[src.String()] = make(map[int]*refcountedTransport)
+		// Assume the system's routes may have changed if we're adding a new unicast binding.
+		// Ignore the error, there's nothing we can do.
+		r.routes, _ = netroute.New()
+	}
+	r.unicast[src.String()][conn.LocalAddr().(*net.UDPAddr).Port] = tr
+	return tr, nil
+}
+
 func (r *reuse) transportForDialLocked(network string, source *net.IP) (*refcountedTransport, error) {
 	if source != nil {
 		// We already have at least one suitable transport...
@@ -310,7 +347,7 @@ func (r *reuse) TransportForListen(network string, laddr *net.UDPAddr) (*refcoun
 		}
 	}
 
-	conn, err := net.ListenUDP(network, laddr)
+	conn, err := r.listenUDP(network, laddr)
 	if err != nil {
 		return nil, err
 	}
@@ -238,3 +238,36 @@ func TestReuseGarbageCollect(t *testing.T) {
 	}
 	require.Eventually(t, func() bool { return numGlobals() == 0 }, 4*garbageCollectInterval, 10*time.Millisecond)
 }
+
+func TestReuseDialFrom(t *testing.T) {
+	reuse := newReuse(nil, nil)
+	cleanup(t, reuse)
+
+	// without a listener on the source address, a new transport is bound to it
+	tr, err := reuse.TransportForDialFrom("udp4", net.IPv4(127, 0, 0, 1))
+	require.NoError(t, err)
+	require.Equal(t, 1, tr.GetCount())
+	laddr := tr.LocalAddr().(*net.UDPAddr)
+	require.Equal(t, "127.0.0.1", laddr.IP.String())
+	require.NotEqual(t, 0, laddr.Port)
+
+	// the transport is reused for the next dial from the same address
+	tr2, err := reuse.TransportForDialFrom("udp4", net.IPv4(127, 0, 0, 1))
+	require.NoError(t, err)
+	require.Equal(t, tr, tr2)
+	require.Equal(t, 2, tr.GetCount())
+}
+
+func TestReuseDialFromListener(t *testing.T) {
+	reuse := newReuse(nil, nil)
+	cleanup(t, reuse)
+
+	addr, err := net.ResolveUDPAddr("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	lconn, err := reuse.TransportForListen("udp4", addr)
+	require.NoError(t, err)
+	tr, err := reuse.TransportForDialFrom("udp4", net.IPv4(127, 0, 0, 1))
+	require.NoError(t, err)
+	require.Equal(t, lconn, tr)
+	require.Equal(t, 2, tr.GetCount())
+}
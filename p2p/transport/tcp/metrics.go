@@ -7,6 +7,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/libp2p/go-libp2p/p2p/metricshelper"
+
 	"github.com/marten-seemann/tcp"
 	"github.com/mikioh/tcpinfo"
 	manet "github.com/multiformats/go-multiaddr/net"
@@ -35,7 +37,6 @@ func initMetrics() {
 	bytesRcvdDesc = prometheus.NewDesc("tcp_rcvd_bytes", "TCP bytes received", nil, nil)
 
 	collector = newAggregatingCollector()
-	prometheus.MustRegister(collector)
 
 	const direction = "direction"
 
@@ -46,7 +47,6 @@ func initMetrics() {
 		},
 		[]string{direction},
 	)
-	prometheus.MustRegister(newConns)
 	closedConns = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "tcp_connections_closed_total",
@@ -54,7 +54,13 @@ func initMetrics() {
 		},
 		[]string{direction},
 	)
-	prometheus.MustRegister(closedConns)
+}
+
+// registerMetrics registers the TCP metrics with reg. The metrics are shared by
+// all transports, so they can be registered with multiple registerers.
+func registerMetrics(reg prometheus.Registerer) {
+	initMetricsOnce.Do(func() { initMetrics() })
+	metricshelper.RegisterCollectors(reg, collector, newConns, closedConns)
 }
 
 type aggregatingCollector struct {
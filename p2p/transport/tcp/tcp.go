@@ -12,12 +12,15 @@ import (
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/net/activation"
+	"github.com/libp2p/go-libp2p/p2p/net/proxyproto"
 	"github.com/libp2p/go-libp2p/p2p/net/reuseport"
 
 	logging "github.com/ipfs/go-log/v2"
 	ma "github.com/multiformats/go-multiaddr"
 	mafmt "github.com/multiformats/go-multiaddr-fmt"
 	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 const defaultConnectTimeout = 5 * time.Second
@@ -113,18 +116,80 @@ func WithMetrics() Option {
 	}
 }
 
+// WithMetricsRegisterer enables metrics, and registers them with reg instead
+// of the default registerer.
+func WithMetricsRegisterer(reg prometheus.Registerer) Option {
+	return func(tr *TcpTransport) error {
+		if reg == nil {
+			return errors.New("registerer cannot be nil")
+		}
+		tr.enableMetrics = true
+		tr.metricsRegisterer = reg
+		return nil
+	}
+}
+
+// WithDialSourceAddr sets a function that selects the local IP address to dial
+// raddr from, for example to use a different IP address depending on the
+// destination on a multi-homed host. The function returns an IP multiaddr
+// (e.g. /ip4/1.2.3.4), or nil to let the transport choose the source address.
+// Addresses of a different IP family than raddr are ignored.
+//
+// To make sure peers observe (and we advertise) the correct address, listen on
+// each of the IP addresses instead of the unspecified address: the dial then
+// reuses the port of the listener bound to the selected IP address.
+func WithDialSourceAddr(f func(raddr ma.Multiaddr) ma.Multiaddr) Option {
+	return func(tr *TcpTransport) error {
+		tr.dialSourceAddr = f
+		return nil
+	}
+}
+
+// WithInheritedSockets makes the transport use the TCP listeners inherited
+// from a parent process, e.g. using systemd socket activation, when listening
+// on their exact address. Addresses without a matching socket are bound as
+// usual. Dials don't reuse the port of inherited listeners.
+func WithInheritedSockets(s *activation.Sockets) Option {
+	return func(tr *TcpTransport) error {
+		tr.inherited = s
+		return nil
+	}
+}
+
+// WithProxyProtocol makes listeners read the PROXY protocol header sent by
+// load balancers and reverse proxies, so that connections have the address of
+// the client as their remote address. Connections from the trusted networks
+// (i.e. from the load balancers) must send a header, connections from other
+// addresses are accepted as is. If no networks are given, all connections
+// must send a header. See the proxyproto package for details.
+func WithProxyProtocol(trusted ...*net.IPNet) Option {
+	return func(tr *TcpTransport) error {
+		tr.proxyProtocol = true
+		tr.proxyTrusted = trusted
+		return nil
+	}
+}
+
 // TcpTransport is the TCP transport.
 type TcpTransport struct {
 	// Connection upgrader for upgrading insecure stream connections to
 	// secure multiplex connections.
 	upgrader transport.Upgrader
 
-	disableReuseport bool // Explicitly disable reuseport.
-	enableMetrics    bool
+	disableReuseport  bool // Explicitly disable reuseport.
+	enableMetrics     bool
+	metricsRegisterer prometheus.Registerer
 
 	// TCP connect timeout
 	connectTimeout time.Duration
 
+	dialSourceAddr func(raddr ma.Multiaddr) ma.Multiaddr
+
+	inherited *activation.Sockets
+
+	proxyProtocol bool
+	proxyTrusted  []*net.IPNet
+
 	rcmgr network.ResourceManager
 
 	reuse reuseport.Transport
@@ -149,6 +214,12 @@ func NewTCPTransport(upgrader transport.Upgrader, rcmgr network.ResourceManager,
 			return nil, err
 		}
 	}
+	if tr.enableMetrics {
+		if tr.metricsRegisterer == nil {
+			tr.metricsRegisterer = prometheus.DefaultRegisterer
+		}
+		registerMetrics(tr.metricsRegisterer)
+	}
 	return tr, nil
 }
 
@@ -168,13 +239,46 @@ func (t *TcpTransport) maDial(ctx context.Context, raddr ma.Multiaddr) (manet.Co
 		defer cancel()
 	}
 
+	laddr := t.sourceAddr(raddr)
 	if t.UseReuseport() {
+		if laddr != nil {
+			return t.reuse.DialContextFrom(ctx, raddr, laddr)
+		}
 		return t.reuse.DialContext(ctx, raddr)
 	}
 	var d manet.Dialer
+	if laddr != nil {
+		d.LocalAddr = laddr.Encapsulate(ma.StringCast("/tcp/0"))
+	}
 	return d.DialContext(ctx, raddr)
 }
 
+// sourceAddr returns the local IP address to dial raddr from, or nil if the
+// transport should choose it.
+func (t *TcpTransport) sourceAddr(raddr ma.Multiaddr) ma.Multiaddr {
+	if t.dialSourceAddr == nil {
+		return nil
+	}
+	laddr := t.dialSourceAddr(raddr)
+	if laddr == nil {
+		return nil
+	}
+	lip, err := manet.ToIP(laddr)
+	if err != nil {
+		log.Debugw("invalid dial source address", "addr", laddr, "error", err)
+		return nil
+	}
+	rip, err := manet.ToIP(raddr)
+	if err != nil || (lip.To4() == nil) != (rip.To4() == nil) {
+		return nil
+	}
+	laddr, err = manet.FromIP(lip)
+	if err != nil {
+		return nil
+	}
+	return laddr
+}
+
 // Dial dials the peer at the remote address.
 func (t *TcpTransport) Dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (transport.CapableConn, error) {
 	return t.DialWithUpdates(ctx, raddr, p, nil)
@@ -237,12 +341,39 @@ func (t *TcpTransport) UseReuseport() bool {
 }
 
 func (t *TcpTransport) maListen(laddr ma.Multiaddr) (manet.Listener, error) {
+	if l, err := t.inheritedListener(laddr); l != nil || err != nil {
+		return l, err
+	}
 	if t.UseReuseport() {
 		return t.reuse.Listen(laddr)
 	}
 	return manet.Listen(laddr)
 }
 
+func (t *TcpTransport) inheritedListener(laddr ma.Multiaddr) (manet.Listener, error) {
+	if t.inherited == nil {
+		return nil, nil
+	}
+	netw, host, err := manet.DialArgs(laddr)
+	if err != nil {
+		return nil, err
+	}
+	tcpAddr, err := net.ResolveTCPAddr(netw, host)
+	if err != nil {
+		return nil, err
+	}
+	nl := t.inherited.TakeTCP(netw, tcpAddr)
+	if nl == nil {
+		return nil, nil
+	}
+	l, err := manet.WrapNetListener(nl)
+	if err != nil {
+		nl.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
 // Listen listens on the given multiaddr.
 func (t *TcpTransport) Listen(laddr ma.Multiaddr) (transport.Listener, error) {
 	list, err := t.maListen(laddr)
@@ -252,6 +383,12 @@ func (t *TcpTransport) Listen(laddr ma.Multiaddr) (transport.Listener, error) {
 	if t.enableMetrics {
 		list = newTracingListener(&tcpListener{list, 0})
 	}
+	if t.proxyProtocol {
+		list, err = manet.WrapNetListener(proxyproto.NewListener(manet.NetListener(list), t.proxyTrusted...))
+		if err != nil {
+			return nil, err
+		}
+	}
 	return t.upgrader.UpgradeListener(t, list), nil
 }
 
@@ -265,6 +402,12 @@ func (t *TcpTransport) Proxy() bool {
 	return false
 }
 
+// Capabilities returns the capabilities of the TCP transport. TCP connections
+// can be hole punched using TCP simultaneous open.
+func (t *TcpTransport) Capabilities() transport.Capabilities {
+	return transport.CapHolePunching
+}
+
 func (t *TcpTransport) String() string {
 	return "TCP"
 }
@@ -3,6 +3,9 @@ package tcp
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net"
+	"runtime"
 	"testing"
 
 	"github.com/libp2p/go-libp2p/core/crypto"
@@ -13,6 +16,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/sec/insecure"
 	"github.com/libp2p/go-libp2p/core/transport"
 	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
+	"github.com/libp2p/go-libp2p/p2p/net/activation"
 	tptu "github.com/libp2p/go-libp2p/p2p/net/upgrader"
 	ttransport "github.com/libp2p/go-libp2p/p2p/transport/testsuite"
 
@@ -39,7 +43,7 @@ func TestTcpTransport(t *testing.T) {
 		require.NoError(t, err)
 
 		zero := "/ip4/127.0.0.1/tcp/0"
-		ttransport.SubtestTransport(t, ta, tb, zero, peerA)
+		ttransport.SubtestUpgradedTransport(t, ta, tb, zero, peerA)
 
 		envReuseportVal = false
 	}
@@ -204,3 +208,125 @@ func makeInsecureMuxer(t *testing.T) (peer.ID, []sec.SecureTransport) {
 	require.NoError(t, err)
 	return id, []sec.SecureTransport{insecure.NewWithIdentity(insecure.ID, id, priv)}
 }
+
+func TestDialSourceAddr(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("127.0.0.2 is only routed to the loopback interface by default on Linux")
+	}
+	for _, reuse := range []bool{true, false} {
+		t.Run(fmt.Sprintf("reuseport %t", reuse), func(t *testing.T) {
+			envReuseportVal = reuse
+			defer func() { envReuseportVal = true }()
+
+			peerA, ia := makeInsecureMuxer(t)
+			_, ib := makeInsecureMuxer(t)
+			ua, err := tptu.New(ia, muxers, nil, nil, nil)
+			require.NoError(t, err)
+			ta, err := NewTCPTransport(ua, nil)
+			require.NoError(t, err)
+			ub, err := tptu.New(ib, muxers, nil, nil, nil)
+			require.NoError(t, err)
+			src := ma.StringCast("/ip4/127.0.0.2")
+			tb, err := NewTCPTransport(ub, nil, WithDialSourceAddr(func(raddr ma.Multiaddr) ma.Multiaddr {
+				if manet.IsIPLoopback(raddr) {
+					return src
+				}
+				return nil
+			}))
+			require.NoError(t, err)
+
+			ln, err := ta.Listen(ma.StringCast("/ip4/127.0.0.1/tcp/0"))
+			require.NoError(t, err)
+			defer ln.Close()
+			go func() {
+				c, err := ln.Accept()
+				if err == nil {
+					c.Close()
+				}
+			}()
+
+			c, err := tb.Dial(context.Background(), ln.Multiaddr(), peerA)
+			require.NoError(t, err)
+			defer c.Close()
+			ip, err := manet.ToIP(c.LocalMultiaddr())
+			require.NoError(t, err)
+			require.Equal(t, "127.0.0.2", ip.String())
+		})
+	}
+}
+
+func TestDialSourceAddrFamilyMismatch(t *testing.T) {
+	_, ia := makeInsecureMuxer(t)
+	ua, err := tptu.New(ia, muxers, nil, nil, nil)
+	require.NoError(t, err)
+	tr, err := NewTCPTransport(ua, nil, WithDialSourceAddr(func(ma.Multiaddr) ma.Multiaddr {
+		return ma.StringCast("/ip6/::1")
+	}))
+	require.NoError(t, err)
+	require.Nil(t, tr.sourceAddr(ma.StringCast("/ip4/127.0.0.1/tcp/1234")))
+	require.Equal(t, "/ip6/::1", tr.sourceAddr(ma.StringCast("/ip6/::1/tcp/1234")).String())
+}
+
+func TestListenOnInheritedSocket(t *testing.T) {
+	nl, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	f, err := nl.File()
+	require.NoError(t, err)
+	nl.Close()
+	sockets, err := activation.New(f)
+	require.NoError(t, err)
+	defer sockets.Close()
+
+	_, sm := makeInsecureMuxer(t)
+	u, err := tptu.New(sm, muxers, nil, nil, nil)
+	require.NoError(t, err)
+	tr, err := NewTCPTransport(u, nil, WithInheritedSockets(sockets))
+	require.NoError(t, err)
+
+	laddr, err := manet.FromNetAddr(nl.Addr())
+	require.NoError(t, err)
+	l, err := tr.Listen(laddr)
+	require.NoError(t, err)
+	defer l.Close()
+	require.Equal(t, laddr, l.Multiaddr())
+	require.Zero(t, sockets.Len())
+
+	// other addresses are bound as usual
+	l2, err := tr.Listen(ma.StringCast("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	l2.Close()
+}
+
+func TestProxyProtocol(t *testing.T) {
+	peerA, ia := makeInsecureMuxer(t)
+	_, ib := makeInsecureMuxer(t)
+	ua, err := tptu.New(ia, muxers, nil, nil, nil)
+	require.NoError(t, err)
+	ta, err := NewTCPTransport(ua, nil, WithProxyProtocol())
+	require.NoError(t, err)
+	ub, err := tptu.New(ib, muxers, nil, nil, nil)
+	require.NoError(t, err)
+	tb, err := NewTCPTransport(ub, nil)
+	require.NoError(t, err)
+
+	ln, err := ta.Listen(ma.StringCast("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	// connections without a header are dropped
+	_, err = tb.Dial(context.Background(), ln.Multiaddr(), peerA)
+	require.Error(t, err)
+
+	nc, err := manet.Dial(ln.Multiaddr())
+	require.NoError(t, err)
+	_, err = nc.Write([]byte("PROXY TCP4 192.0.2.1 127.0.0.1 56324 4001\r\n"))
+	require.NoError(t, err)
+	conn, err := ub.Upgrade(context.Background(), tb, nc, network.DirOutbound, peerA, &network.NullScope{})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	c, err := ln.Accept()
+	require.NoError(t, err)
+	defer c.Close()
+	require.Equal(t, "/ip4/192.0.2.1/tcp/56324", c.RemoteMultiaddr().String())
+}
@@ -0,0 +1,439 @@
+package ttransport
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/transport"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/stretchr/testify/require"
+)
+
+// LargeTransferSize is the number of bytes sent by SubtestLargeTransfer.
+// It can be set using the TEST_LARGE_TRANSFER_BYTES environment variable,
+// e.g. to 10737418240 to transfer 10 GiB.
+var LargeTransferSize int64 = 64 << 20
+
+func init() {
+	if size := os.Getenv("TEST_LARGE_TRANSFER_BYTES"); size != "" {
+		if v, err := strconv.ParseInt(size, 10, 64); err == nil && v > 0 {
+			LargeTransferSize = v
+		}
+	}
+}
+
+// socketAddr returns the address of the TCP or Unix socket that addr runs on,
+// and the remaining part of addr (e.g. /ws).
+func socketAddr(addr ma.Multiaddr) (sock, rest ma.Multiaddr, ok bool) {
+	if first, _ := ma.SplitFirst(addr); first != nil && first.Protocol().Code == ma.P_UNIX {
+		return addr, nil, true
+	}
+	head, tail := ma.SplitFunc(addr, func(c ma.Component) bool { return c.Protocol().Code == ma.P_TCP })
+	if head == nil || tail == nil {
+		return nil, nil, false
+	}
+	tcp, rest := ma.SplitFirst(tail)
+	return head.Encapsulate(tcp), rest, true
+}
+
+// abort closes c, resetting the connection if it's a TCP connection.
+func abort(c net.Conn) {
+	if tc, ok := c.(interface{ SetLinger(int) error }); ok {
+		tc.SetLinger(0)
+	}
+	c.Close()
+}
+
+// checkEcho dials the listener, and checks that it echoes data sent on a stream.
+func checkEcho(t *testing.T, tb transport.Transport, l transport.Listener, peerA peer.ID) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, err := tb.Dial(ctx, l.Multiaddr(), peerA)
+	require.NoError(t, err)
+	defer c.Close()
+	s, err := c.OpenStream(ctx)
+	require.NoError(t, err)
+	defer s.Close()
+	_, err = s.Write(testData)
+	require.NoError(t, err)
+	require.NoError(t, s.CloseWrite())
+	require.NoError(t, s.SetReadDeadline(time.Now().Add(10*time.Second)))
+	data, err := io.ReadAll(s)
+	require.NoError(t, err)
+	require.Equal(t, testData, data)
+}
+
+// SubtestListenerPeerKilledMidHandshake checks that a listener isn't affected
+// by peers that disappear while upgrading the connection.
+func SubtestListenerPeerKilledMidHandshake(t *testing.T, ta, tb transport.Transport, maddr ma.Multiaddr, peerA peer.ID) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	l, err := ta.Listen(maddr)
+	require.NoError(t, err)
+	defer l.Close()
+	sock, _, ok := socketAddr(l.Multiaddr())
+	if !ok {
+		t.Skip("transport doesn't run on a TCP or Unix socket")
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		serve(t, l)
+	}()
+
+	for _, msg := range [][]byte{
+		nil,
+		[]byte("\x13/multistream/1.0.0\n"),
+		[]byte("\x13/multistream/1.0.0\n\x0d/ins"),
+	} {
+		c, err := manet.Dial(sock)
+		require.NoError(t, err)
+		if len(msg) > 0 {
+			_, err = c.Write(msg)
+			require.NoError(t, err)
+		}
+		abort(c)
+	}
+	checkEcho(t, tb, l, peerA)
+}
+
+// SubtestDialerPeerKilledMidHandshake checks that Dial fails, instead of
+// hanging, when the listening peer disappears while upgrading the connection.
+func SubtestDialerPeerKilledMidHandshake(t *testing.T, ta, tb transport.Transport, maddr ma.Multiaddr, peerA peer.ID) {
+	sock, rest, ok := socketAddr(maddr)
+	if !ok {
+		t.Skip("transport doesn't run on a TCP or Unix socket")
+	}
+	l, err := manet.Listen(sock)
+	require.NoError(t, err)
+	defer l.Close()
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			// read the first bytes the dialer sends
+			c.SetReadDeadline(time.Now().Add(5 * time.Second))
+			c.Read(make([]byte, 8))
+			abort(c)
+		}
+	}()
+
+	raddr := l.Multiaddr()
+	if rest != nil {
+		raddr = raddr.Encapsulate(rest)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = tb.Dial(ctx, raddr, peerA)
+	require.Error(t, err)
+	require.NoError(t, ctx.Err(), "dial should fail before the context is canceled")
+}
+
+// SubtestHandshakeFailure checks that a dial to the wrong peer fails, and
+// that the listener keeps accepting connections afterwards.
+func SubtestHandshakeFailure(t *testing.T, ta, tb transport.Transport, maddr ma.Multiaddr, peerA peer.ID) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	l, err := ta.Listen(maddr)
+	require.NoError(t, err)
+	defer l.Close()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		serve(t, l)
+	}()
+
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	wrongPeer, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = tb.Dial(ctx, l.Multiaddr(), wrongPeer)
+	require.Error(t, err, "dial to the wrong peer should have failed")
+	require.NoError(t, ctx.Err(), "dial should fail before the context is canceled")
+
+	checkEcho(t, tb, l, peerA)
+}
+
+// SubtestDuplicatedReorderedPackets checks that a transport running on UDP
+// copes with packets being duplicated and reordered on the network.
+func SubtestDuplicatedReorderedPackets(t *testing.T, ta, tb transport.Transport, maddr ma.Multiaddr, peerA peer.ID) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	l, err := ta.Listen(maddr)
+	require.NoError(t, err)
+	defer l.Close()
+	head, tail := ma.SplitFunc(l.Multiaddr(), func(c ma.Component) bool { return c.Protocol().Code == ma.P_UDP })
+	if head == nil || tail == nil {
+		t.Skip("transport doesn't run on UDP")
+	}
+	udp, rest := ma.SplitFirst(tail)
+	serverAddr, err := manet.ToNetAddr(head.Encapsulate(udp))
+	require.NoError(t, err)
+
+	proxy, err := newUDPProxy(serverAddr.(*net.UDPAddr))
+	require.NoError(t, err)
+	defer proxy.Close()
+	proxyAddr, err := manet.FromNetAddr(proxy.LocalAddr())
+	require.NoError(t, err)
+	if rest != nil {
+		proxyAddr = proxyAddr.Encapsulate(rest)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		serve(t, l)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	c, err := tb.Dial(ctx, proxyAddr, peerA)
+	require.NoError(t, err)
+	defer c.Close()
+
+	var swg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		swg.Add(1)
+		go func() {
+			defer swg.Done()
+			s, err := c.OpenStream(ctx)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer s.Close()
+			msg := randBuf(100 << 10)
+			go func() {
+				s.Write(msg)
+				s.CloseWrite()
+			}()
+			s.SetReadDeadline(time.Now().Add(30 * time.Second))
+			data, err := io.ReadAll(s)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(msg, data) {
+				t.Error("received different data")
+			}
+		}()
+	}
+	swg.Wait()
+	require.NotZero(t, proxy.Mangled(), "expected the proxy to duplicate and reorder packets")
+}
+
+// SubtestLargeTransfer sends LargeTransferSize bytes on a single stream.
+func SubtestLargeTransfer(t *testing.T, ta, tb transport.Transport, maddr ma.Multiaddr, peerA peer.ID) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	l, err := ta.Listen(maddr)
+	require.NoError(t, err)
+	defer l.Close()
+
+	// The listener hashes all data it receives, and sends back the hash.
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer c.Close()
+		s, err := c.AcceptStream()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, s); err != nil {
+			t.Error(err)
+			s.Reset()
+			return
+		}
+		if _, err := s.Write(h.Sum(nil)); err != nil {
+			t.Error(err)
+		}
+		s.Close()
+		// Closing the connection right away could reset the stream before
+		// the dialer has read the hash.
+		<-done
+	}()
+	defer close(done)
+
+	c, err := tb.Dial(context.Background(), l.Multiaddr(), peerA)
+	require.NoError(t, err)
+	defer c.Close()
+	s, err := c.OpenStream(context.Background())
+	require.NoError(t, err)
+	defer s.Close()
+
+	h := sha256.New()
+	w := io.MultiWriter(s, h)
+	const chunkSize = 64 << 10
+	start := time.Now()
+	for sent := int64(0); sent < LargeTransferSize; {
+		chunk := randBuf(chunkSize)
+		if remaining := LargeTransferSize - sent; remaining < chunkSize {
+			chunk = chunk[:remaining]
+		}
+		_, err := w.Write(chunk)
+		require.NoError(t, err)
+		sent += int64(len(chunk))
+	}
+	require.NoError(t, s.CloseWrite())
+	sum, err := io.ReadAll(s)
+	require.NoError(t, err)
+	require.Equal(t, h.Sum(nil), sum, "received different data")
+	t.Logf("transferred %d bytes in %s", LargeTransferSize, time.Since(start))
+}
+
+// udpProxy forwards packets between clients and a server. It duplicates
+// some packets, and delays others so that they're reordered.
+type udpProxy struct {
+	conn   *net.UDPConn
+	server *net.UDPAddr
+
+	mx      sync.Mutex
+	clients map[string]*net.UDPConn
+	mangled int
+
+	wg sync.WaitGroup
+}
+
+func newUDPProxy(server *net.UDPAddr) (*udpProxy, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: server.IP})
+	if err != nil {
+		return nil, err
+	}
+	p := &udpProxy{conn: conn, server: server, clients: make(map[string]*net.UDPConn)}
+	p.wg.Add(1)
+	go p.run()
+	return p, nil
+}
+
+func (p *udpProxy) LocalAddr() net.Addr { return p.conn.LocalAddr() }
+
+// Mangled returns the number of packets that were duplicated or reordered.
+func (p *udpProxy) Mangled() int {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	return p.mangled
+}
+
+func (p *udpProxy) Close() error {
+	err := p.conn.Close()
+	p.mx.Lock()
+	for _, c := range p.clients {
+		c.Close()
+	}
+	p.mx.Unlock()
+	p.wg.Wait()
+	return err
+}
+
+func (p *udpProxy) run() {
+	defer p.wg.Done()
+	m := &mangler{p: p}
+	buf := make([]byte, 1<<16)
+	for {
+		n, client, err := p.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		p.mx.Lock()
+		upstream, ok := p.clients[client.String()]
+		if !ok {
+			upstream, err = net.DialUDP("udp", nil, p.server)
+			if err != nil {
+				p.mx.Unlock()
+				continue
+			}
+			p.clients[client.String()] = upstream
+			p.wg.Add(1)
+			go p.runUpstream(upstream, client)
+		}
+		p.mx.Unlock()
+		m.forward(append([]byte(nil), buf[:n]...), func(b []byte) { upstream.Write(b) })
+	}
+}
+
+func (p *udpProxy) runUpstream(upstream *net.UDPConn, client *net.UDPAddr) {
+	defer p.wg.Done()
+	m := &mangler{p: p}
+	buf := make([]byte, 1<<16)
+	for {
+		n, err := upstream.Read(buf)
+		if err != nil {
+			return
+		}
+		m.forward(append([]byte(nil), buf[:n]...), func(b []byte) { p.conn.WriteToUDP(b, client) })
+	}
+}
+
+// mangler duplicates every 5th packet, and sends every 7th packet after the
+// packet following it.
+type mangler struct {
+	p *udpProxy
+
+	mx    sync.Mutex
+	count int
+	held  []byte
+	timer *time.Timer
+}
+
+func (m *mangler) forward(pkt []byte, send func([]byte)) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.count++
+	switch {
+	case m.held != nil:
+		send(pkt)
+		send(m.held)
+		m.held = nil
+		m.timer.Stop()
+		m.p.mx.Lock()
+		m.p.mangled++
+		m.p.mx.Unlock()
+	case m.count%7 == 0:
+		m.held = pkt
+		// Don't hold back the packet forever if no other packet is sent.
+		m.timer = time.AfterFunc(10*time.Millisecond, func() {
+			m.mx.Lock()
+			defer m.mx.Unlock()
+			if m.held != nil {
+				send(m.held)
+				m.held = nil
+			}
+		})
+	case m.count%5 == 0:
+		send(pkt)
+		send(pkt)
+		m.p.mx.Lock()
+		m.p.mangled++
+		m.p.mx.Unlock()
+	default:
+		send(pkt)
+	}
+}
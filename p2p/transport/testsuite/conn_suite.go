@@ -0,0 +1,91 @@
+package ttransport
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"golang.org/x/net/nettest"
+)
+
+// halfCloser is implemented by connections that can be closed in one
+// direction, like *net.TCPConn.
+type halfCloser interface {
+	CloseWrite() error
+	CloseRead() error
+}
+
+// SubtestConn checks that the raw (not yet upgraded) connections returned by
+// makePipe behave like a net.Conn: data written before Close is delivered,
+// deadlines unblock pending reads and writes, and all methods are safe for
+// concurrent use. It uses the net.Conn test suite of golang.org/x/net/nettest.
+//
+// If the connections support half-closing, SubtestConn also checks that
+// CloseWrite is propagated to the other end.
+func SubtestConn(t *testing.T, makePipe nettest.MakePipe) {
+	t.Run("NetConn", func(t *testing.T) {
+		nettest.TestConn(t, makePipe)
+	})
+	t.Run("HalfClose", func(t *testing.T) {
+		subtestHalfClose(t, makePipe)
+	})
+}
+
+func subtestHalfClose(t *testing.T, makePipe nettest.MakePipe) {
+	c1, c2, stop, err := makePipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	hc1, ok := c1.(halfCloser)
+	if !ok {
+		t.Skipf("%T doesn't support half-closing", c1)
+	}
+	if err := c2.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	req := []byte("request")
+	resp := []byte("response")
+	errCh := make(chan error, 1)
+	go func() {
+		if _, err := c1.Write(req); err != nil {
+			errCh <- err
+			return
+		}
+		errCh <- hc1.CloseWrite()
+	}()
+
+	// c2 reads until EOF, and can still respond afterwards.
+	got, err := io.ReadAll(c2)
+	if err != nil {
+		t.Fatalf("expected io.EOF after the remote closed for writing, got %v", err)
+	}
+	if !bytes.Equal(got, req) {
+		t.Fatalf("expected %q, got %q", req, got)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c2.Write(resp); err != nil {
+		t.Fatalf("failed to write after the remote closed for writing: %v", err)
+	}
+	if err := c2.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c1.SetReadDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	got, err = io.ReadAll(c1)
+	if err != nil {
+		t.Fatalf("expected io.EOF after the remote closed, got %v", err)
+	}
+	if !bytes.Equal(got, resp) {
+		t.Fatalf("expected %q, got %q", resp, got)
+	}
+	if _, err := c1.Write(req); err == nil {
+		t.Fatal("expected writing to fail after CloseWrite")
+	}
+}
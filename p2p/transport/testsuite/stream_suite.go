@@ -443,6 +443,21 @@ func SubtestStress1Conn1000Stream10Msg(t *testing.T, ta, tb transport.Transport,
 	})
 }
 
+func SubtestStress1Conn10000Stream1Msg(t *testing.T, ta, tb transport.Transport, maddr ma.Multiaddr, peerA peer.ID) {
+	streams := 10000
+	if race.WithRace() {
+		// the race detector can only deal with 8128 simultaneous goroutines, so let's make sure we don't go overboard.
+		streams = 1000
+	}
+	SubtestStress(t, ta, tb, maddr, peerA, Options{
+		ConnNum:   1,
+		StreamNum: streams,
+		MsgNum:    1,
+		MsgMax:    100,
+		MsgMin:    100,
+	})
+}
+
 func SubtestStress1Conn100Stream100Msg10MB(t *testing.T, ta, tb transport.Transport, maddr ma.Multiaddr, peerA peer.ID) {
 	SubtestStress(t, ta, tb, maddr, peerA, Options{
 		ConnNum:   1,
@@ -0,0 +1,111 @@
+package ttransport
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/transport"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// UpgraderSubtests check that transports using the upgrader (see
+// p2p/net/upgrader) return connections that behave like the connections of
+// the bundled TCP and WebSocket transports.
+var UpgraderSubtests = []func(t *testing.T, ta, tb transport.Transport, maddr ma.Multiaddr, peerA peer.ID){
+	SubtestUpgradedConn,
+}
+
+// SubtestUpgradedTransport runs the Subtests as well as the UpgraderSubtests.
+// Transports that use the upgrader should be tested with it instead of
+// SubtestTransport.
+func SubtestUpgradedTransport(t *testing.T, ta, tb transport.Transport, addr string, peerA peer.ID) {
+	SubtestTransport(t, ta, tb, addr, peerA)
+
+	maddr, err := ma.NewMultiaddr(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range UpgraderSubtests {
+		t.Run(getFunctionName(f), func(t *testing.T) {
+			f(t, ta, tb, maddr, peerA)
+		})
+	}
+}
+
+// SubtestUpgradedConn checks that both ends of a connection are
+// authenticated and multiplexed, and are attached to their transport and a
+// resource manager scope.
+func SubtestUpgradedConn(t *testing.T, ta, tb transport.Transport, maddr ma.Multiaddr, peerA peer.ID) {
+	list, err := ta.Listen(maddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer list.Close()
+
+	accepted := make(chan transport.CapableConn, 1)
+	go func() {
+		c, err := list.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- c
+	}()
+
+	connB, err := tb.Dial(context.Background(), list.Multiaddr(), peerA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connB.Close()
+	connA := <-accepted
+	if connA == nil {
+		t.FailNow()
+	}
+	defer connA.Close()
+
+	if connB.RemotePeer() != peerA || connA.LocalPeer() != peerA {
+		t.Errorf("expected the listener to be %s, got %s (dialer) and %s (listener)", peerA, connB.RemotePeer(), connA.LocalPeer())
+	}
+	if connA.RemotePeer() != connB.LocalPeer() {
+		t.Errorf("expected the dialer to be %s, got %s", connB.LocalPeer(), connA.RemotePeer())
+	}
+
+	for _, c := range []struct {
+		name string
+		conn transport.CapableConn
+		tpt  transport.Transport
+	}{
+		{name: "listener", conn: connA, tpt: ta},
+		{name: "dialer", conn: connB, tpt: tb},
+	} {
+		if c.conn.RemotePublicKey() == nil {
+			t.Errorf("%s: missing remote public key", c.name)
+		} else if !c.conn.RemotePeer().MatchesPublicKey(c.conn.RemotePublicKey()) {
+			t.Errorf("%s: remote public key doesn't match the remote peer", c.name)
+		}
+		state := c.conn.ConnState()
+		if state.Security == "" {
+			t.Errorf("%s: security protocol not set", c.name)
+		}
+		if state.StreamMultiplexer == "" {
+			t.Errorf("%s: stream multiplexer not set", c.name)
+		}
+		if state.Transport == "" {
+			t.Errorf("%s: transport not set", c.name)
+		}
+		if c.conn.Transport() != c.tpt {
+			t.Errorf("%s: connection not attached to its transport", c.name)
+		}
+		if c.conn.Scope() == nil {
+			t.Errorf("%s: missing resource manager scope", c.name)
+		}
+	}
+
+	if err := connB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !connB.IsClosed() {
+		t.Error("expected the connection to be closed")
+	}
+}
@@ -24,8 +24,16 @@ var Subtests = []func(t *testing.T, ta, tb transport.Transport, maddr ma.Multiad
 	SubtestStressManyConn10Stream50Msg,
 	SubtestStress1Conn1000Stream10Msg,
 	SubtestStress1Conn100Stream100Msg10MB,
+	SubtestStress1Conn10000Stream1Msg,
 	SubtestStreamOpenStress,
 	SubtestStreamReset,
+
+	// Adversarial scenarios.
+	SubtestListenerPeerKilledMidHandshake,
+	SubtestDialerPeerKilledMidHandshake,
+	SubtestHandshakeFailure,
+	SubtestDuplicatedReorderedPackets,
+	SubtestLargeTransfer,
 }
 
 func getFunctionName(i interface{}) string {
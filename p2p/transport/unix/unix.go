@@ -0,0 +1,162 @@
+// Package unix implements a transport over Unix domain sockets (/unix/<path>).
+//
+// It allows processes on the same host to communicate over libp2p without the
+// overhead of loopback TCP. Access to the socket is controlled by the file
+// system permissions of the socket file, see WithSocketPermissions.
+package unix
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/transport"
+
+	ma "github.com/multiformats/go-multiaddr"
+	mafmt "github.com/multiformats/go-multiaddr-fmt"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+const defaultConnectTimeout = 5 * time.Second
+
+var log = logging.Logger("unix-tpt")
+
+type Option func(*UnixTransport) error
+
+// WithConnectionTimeout sets the timeout for connecting to a socket.
+func WithConnectionTimeout(d time.Duration) Option {
+	return func(tr *UnixTransport) error {
+		tr.connectTimeout = d
+		return nil
+	}
+}
+
+// WithSocketPermissions sets the file mode of the socket files created by
+// Listen. By default, the mode is determined by the process umask.
+// The mode is applied right after the socket is created: to avoid accepting
+// connections before that, create the socket in a directory that is only
+// accessible to the intended users.
+func WithSocketPermissions(mode os.FileMode) Option {
+	return func(tr *UnixTransport) error {
+		tr.socketMode = mode
+		return nil
+	}
+}
+
+// UnixTransport is the Unix domain socket transport.
+type UnixTransport struct {
+	// Connection upgrader for upgrading insecure stream connections to
+	// secure multiplex connections.
+	upgrader transport.Upgrader
+
+	connectTimeout time.Duration
+	socketMode     os.FileMode
+
+	rcmgr network.ResourceManager
+}
+
+var _ transport.Transport = &UnixTransport{}
+
+// NewUnixTransport creates a Unix domain socket transport.
+func NewUnixTransport(upgrader transport.Upgrader, rcmgr network.ResourceManager, opts ...Option) (*UnixTransport, error) {
+	if rcmgr == nil {
+		rcmgr = &network.NullResourceManager{}
+	}
+	tr := &UnixTransport{
+		upgrader:       upgrader,
+		connectTimeout: defaultConnectTimeout,
+		rcmgr:          rcmgr,
+	}
+	for _, o := range opts {
+		if err := o(tr); err != nil {
+			return nil, err
+		}
+	}
+	return tr, nil
+}
+
+var dialMatcher = mafmt.Base(ma.P_UNIX)
+
+// CanDial returns true if this transport believes it can dial the given
+// multiaddr.
+func (t *UnixTransport) CanDial(addr ma.Multiaddr) bool {
+	return dialMatcher.Matches(addr)
+}
+
+// Dial dials the peer at the remote address.
+func (t *UnixTransport) Dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (transport.CapableConn, error) {
+	connScope, err := t.rcmgr.OpenConnection(network.DirOutbound, true, raddr)
+	if err != nil {
+		log.Debugw("resource manager blocked outgoing connection", "peer", p, "addr", raddr, "error", err)
+		return nil, err
+	}
+
+	c, err := t.dialWithScope(ctx, raddr, p, connScope)
+	if err != nil {
+		connScope.Done()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (t *UnixTransport) dialWithScope(ctx context.Context, raddr ma.Multiaddr, p peer.ID, connScope network.ConnManagementScope) (transport.CapableConn, error) {
+	if err := connScope.SetPeer(p); err != nil {
+		log.Debugw("resource manager blocked outgoing connection for peer", "peer", p, "addr", raddr, "error", err)
+		return nil, err
+	}
+	if t.connectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.connectTimeout)
+		defer cancel()
+	}
+	var d manet.Dialer
+	conn, err := d.DialContext(ctx, raddr)
+	if err != nil {
+		return nil, err
+	}
+	direction := network.DirOutbound
+	if ok, isClient, _ := network.GetSimultaneousConnect(ctx); ok && !isClient {
+		direction = network.DirInbound
+	}
+	return t.upgrader.Upgrade(ctx, t, conn, direction, p, connScope)
+}
+
+// Listen listens on the given multiaddr.
+func (t *UnixTransport) Listen(laddr ma.Multiaddr) (transport.Listener, error) {
+	if !dialMatcher.Matches(laddr) {
+		return nil, fmt.Errorf("unix transport cannot listen on %q", laddr)
+	}
+	list, err := manet.Listen(laddr)
+	if err != nil {
+		return nil, err
+	}
+	if t.socketMode != 0 {
+		path, err := laddr.ValueForProtocol(ma.P_UNIX)
+		if err == nil {
+			err = os.Chmod(path, t.socketMode)
+		}
+		if err != nil {
+			list.Close()
+			return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+		}
+	}
+	return t.upgrader.UpgradeListener(t, list), nil
+}
+
+// Protocols returns the list of terminal protocols this transport can dial.
+func (t *UnixTransport) Protocols() []int {
+	return []int{ma.P_UNIX}
+}
+
+// Proxy always returns false for the Unix transport.
+func (t *UnixTransport) Proxy() bool {
+	return false
+}
+
+func (t *UnixTransport) String() string {
+	return "UNIX"
+}
@@ -0,0 +1,131 @@
+package unix
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/sec"
+	"github.com/libp2p/go-libp2p/core/sec/insecure"
+	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
+	tptu "github.com/libp2p/go-libp2p/p2p/net/upgrader"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	ttransport "github.com/libp2p/go-libp2p/p2p/transport/testsuite"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+var muxers = []tptu.StreamMuxer{{ID: "/yamux", Muxer: yamux.DefaultTransport}}
+
+func skipUnsupported(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix domain sockets are not supported on all Windows versions")
+	}
+}
+
+func socketAddr(t *testing.T) ma.Multiaddr {
+	return ma.StringCast("/unix" + filepath.ToSlash(filepath.Join(t.TempDir(), "libp2p.sock")))
+}
+
+func makeInsecureMuxer(t *testing.T) (peer.ID, []sec.SecureTransport) {
+	t.Helper()
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+	return id, []sec.SecureTransport{insecure.NewWithIdentity(insecure.ID, id, priv)}
+}
+
+func makeTransport(t *testing.T, opts ...Option) (peer.ID, *UnixTransport) {
+	t.Helper()
+	id, sec := makeInsecureMuxer(t)
+	u, err := tptu.New(sec, muxers, nil, nil, nil)
+	require.NoError(t, err)
+	tr, err := NewUnixTransport(u, nil, opts...)
+	require.NoError(t, err)
+	return id, tr
+}
+
+func TestUnixTransport(t *testing.T) {
+	skipUnsupported(t)
+	peerA, ta := makeTransport(t)
+	_, tb := makeTransport(t)
+
+	// Each subtest listens on a new socket. SubtestStressManyConn10Stream50Msg is
+	// skipped, since it listens on the same address multiple times concurrently.
+	subtests := map[string]func(t *testing.T, ta, tb transport.Transport, maddr ma.Multiaddr, peerA peer.ID){
+		"Protocols":                      ttransport.SubtestProtocols,
+		"Basic":                          ttransport.SubtestBasic,
+		"Cancel":                         ttransport.SubtestCancel,
+		"PingPong":                       ttransport.SubtestPingPong,
+		"Stress1Conn1Stream1Msg":         ttransport.SubtestStress1Conn1Stream1Msg,
+		"Stress1Conn1Stream100Msg":       ttransport.SubtestStress1Conn1Stream100Msg,
+		"Stress1Conn100Stream100Msg":     ttransport.SubtestStress1Conn100Stream100Msg,
+		"Stress1Conn1000Stream10Msg":     ttransport.SubtestStress1Conn1000Stream10Msg,
+		"Stress1Conn10000Stream1Msg":     ttransport.SubtestStress1Conn10000Stream1Msg,
+		"StreamOpenStress":               ttransport.SubtestStreamOpenStress,
+		"StreamReset":                    ttransport.SubtestStreamReset,
+		"ListenerPeerKilledMidHandshake": ttransport.SubtestListenerPeerKilledMidHandshake,
+		"DialerPeerKilledMidHandshake":   ttransport.SubtestDialerPeerKilledMidHandshake,
+		"HandshakeFailure":               ttransport.SubtestHandshakeFailure,
+		"LargeTransfer":                  ttransport.SubtestLargeTransfer,
+	}
+	for name, f := range subtests {
+		t.Run(name, func(t *testing.T) {
+			f(t, ta, tb, socketAddr(t), peerA)
+		})
+	}
+}
+
+func TestCanDial(t *testing.T) {
+	_, tr := makeTransport(t)
+	require.True(t, tr.CanDial(ma.StringCast("/unix/tmp/libp2p.sock")))
+	require.False(t, tr.CanDial(ma.StringCast("/ip4/127.0.0.1/tcp/1234")))
+}
+
+func TestSocketPermissions(t *testing.T) {
+	skipUnsupported(t)
+	_, tr := makeTransport(t, WithSocketPermissions(0o600))
+	addr := socketAddr(t)
+	ln, err := tr.Listen(addr)
+	require.NoError(t, err)
+	defer ln.Close()
+
+	path, err := addr.ValueForProtocol(ma.P_UNIX)
+	require.NoError(t, err)
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+}
+
+func TestHost(t *testing.T) {
+	skipUnsupported(t)
+	newHost := func() host.Host {
+		addr := socketAddr(t)
+		h, err := libp2p.New(
+			libp2p.Transport(NewUnixTransport),
+			libp2p.ListenAddrs(addr),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+		require.Len(t, h.Addrs(), 1)
+		require.True(t, h.Addrs()[0].Equal(addr))
+		return h
+	}
+	h1 := newHost()
+	h2 := newHost()
+
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	res := <-ping.Ping(ctx, h1, h2.ID())
+	require.NoError(t, res.Error)
+}
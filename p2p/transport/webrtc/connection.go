@@ -1,3 +1,5 @@
+//go:build !js
+
 package libp2pwebrtc
 
 import (
@@ -39,7 +41,18 @@ type dataChannel struct {
 type connection struct {
 	pc        *webrtc.PeerConnection
 	transport *WebRTCTransport
-	scope     network.ConnManagementScope
+	// private is set for private-to-private connections.
+	private *PrivateTransport
+	scope   network.ConnManagementScope
+	// signalingAddr is the relay address of the remote peer, the connection
+	// was signaled on. Only set for private-to-private connections.
+	signalingAddr ma.Multiaddr
+
+	// iceRestart is held while ICE is restarted, see PrivateTransport.restartICE.
+	iceRestart sync.Mutex
+	// remoteICERestart is set while an ICE restart of the remote peer waits
+	// for ours to be rejected.
+	remoteICERestart atomic.Bool
 
 	closeOnce sync.Once
 	closeErr  error
@@ -55,6 +68,8 @@ type connection struct {
 	streams      map[uint16]*stream
 	nextStreamID atomic.Int32
 
+	writeScheduler *writeScheduler
+
 	acceptQueue chan dataChannel
 
 	ctx    context.Context
@@ -93,6 +108,7 @@ func newConnection(
 
 		acceptQueue: incomingDataChannels,
 	}
+	c.writeScheduler = newWriteScheduler(transport.maxConnBufferedAmount, c.bufferedAmount, transport.metrics)
 	switch direction {
 	case network.DirInbound:
 		c.nextStreamID.Store(1)
@@ -107,7 +123,27 @@ func newConnection(
 
 // ConnState implements transport.CapableConn
 func (c *connection) ConnState() network.ConnectionState {
-	return network.ConnectionState{Transport: "webrtc-direct"}
+	if c.private != nil {
+		return network.ConnectionState{Transport: "webrtc", ICE: c.iceState()}
+	}
+	return network.ConnectionState{Transport: "webrtc-direct", ICE: c.iceState()}
+}
+
+// iceState returns the types of the candidates selected by ICE. The selected
+// candidate pair may change during the lifetime of the connection.
+func (c *connection) iceState() *network.ICEConnectionState {
+	sctp := c.pc.SCTP()
+	if sctp == nil || sctp.Transport() == nil || sctp.Transport().ICETransport() == nil {
+		return nil
+	}
+	cp, err := sctp.Transport().ICETransport().GetSelectedCandidatePair()
+	if err != nil || cp == nil || cp.Local == nil || cp.Remote == nil {
+		return nil
+	}
+	return &network.ICEConnectionState{
+		LocalCandidateType:  cp.Local.Typ.String(),
+		RemoteCandidateType: cp.Remote.Typ.String(),
+	}
 }
 
 // Close closes the underlying peerconnection.
@@ -132,6 +168,9 @@ func (c *connection) closeWithError(err error) {
 	for _, s := range streams {
 		s.closeForShutdown(err)
 	}
+	if c.private != nil {
+		c.private.removeConn(c)
+	}
 	c.scope.Done()
 }
 
@@ -158,7 +197,7 @@ func (c *connection) OpenStream(ctx context.Context) (network.MuxedStream, error
 		dc.Close()
 		return nil, fmt.Errorf("detach channel failed for stream(%d): %w", streamID, err)
 	}
-	str := newStream(dc, rwc, func() { c.removeStream(streamID) })
+	str := c.newStream(dc, rwc, network.DirOutbound)
 	if err := c.addStream(str); err != nil {
 		str.Reset()
 		return nil, fmt.Errorf("failed to add stream(%d) to connection: %w", streamID, err)
@@ -171,7 +210,7 @@ func (c *connection) AcceptStream() (network.MuxedStream, error) {
 	case <-c.ctx.Done():
 		return nil, c.closeErr
 	case dc := <-c.acceptQueue:
-		str := newStream(dc.channel, dc.stream, func() { c.removeStream(*dc.channel.ID()) })
+		str := c.newStream(dc.channel, dc.stream, network.DirInbound)
 		if err := c.addStream(str); err != nil {
 			str.Reset()
 			return nil, err
@@ -180,13 +219,32 @@ func (c *connection) AcceptStream() (network.MuxedStream, error) {
 	}
 }
 
+// newStream creates the stream of a data channel opened by us (outbound) or by
+// the remote peer (inbound).
+func (c *connection) newStream(dc *webrtc.DataChannel, rwc datachannel.ReadWriteCloser, dir network.Direction) *stream {
+	id := *dc.ID()
+	metrics := c.transport.metrics
+	metrics.dataChannelOpened(dir)
+	str := newStream(dc, rwc, c.transport.streamConfig, c.writeScheduler, func() {
+		c.removeStream(id)
+		metrics.dataChannelClosed(dir)
+	})
+	str.metrics = metrics
+	return str
+}
+
 func (c *connection) LocalPeer() peer.ID            { return c.localPeer }
 func (c *connection) RemotePeer() peer.ID           { return c.remotePeer }
 func (c *connection) RemotePublicKey() ic.PubKey    { return c.remoteKey }
 func (c *connection) LocalMultiaddr() ma.Multiaddr  { return c.localMultiaddr }
 func (c *connection) RemoteMultiaddr() ma.Multiaddr { return c.remoteMultiaddr }
 func (c *connection) Scope() network.ConnScope      { return c.scope }
-func (c *connection) Transport() tpt.Transport      { return c.transport }
+func (c *connection) Transport() tpt.Transport {
+	if c.private != nil {
+		return c.private
+	}
+	return c.transport
+}
 
 func (c *connection) addStream(str *stream) error {
 	c.m.Lock()
@@ -207,6 +265,18 @@ func (c *connection) removeStream(id uint16) {
 	delete(c.streams, id)
 }
 
+// bufferedAmount returns the total amount of data enqueued on the data
+// channels of the streams.
+func (c *connection) bufferedAmount() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+	var n int
+	for _, s := range c.streams {
+		n += int(s.dataChannel.BufferedAmount())
+	}
+	return n
+}
+
 func (c *connection) onConnectionStateChange(state webrtc.PeerConnectionState) {
 	if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
 		c.closeOnce.Do(func() {
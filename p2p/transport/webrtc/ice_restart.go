@@ -0,0 +1,404 @@
+//go:build !js
+
+package libp2pwebrtc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	"github.com/libp2p/go-libp2p/p2p/transport/webrtc/pb"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	msmux "github.com/multiformats/go-multistream"
+	"github.com/pion/webrtc/v3"
+)
+
+// errICERestartGlare is returned when both peers restart ICE at the same time,
+// and the restart of the remote peer wins.
+var errICERestartGlare = errors.New("remote peer is restarting ICE")
+
+func (t *PrivateTransport) addConn(c *connection) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.conns = append(t.conns, c)
+}
+
+func (t *PrivateTransport) removeConn(c *connection) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	for i, cc := range t.conns {
+		if cc == c {
+			t.conns = append(t.conns[:i], t.conns[i+1:]...)
+			return
+		}
+	}
+}
+
+// watchNetwork restarts ICE on the connections affected by changes of the
+// local interface addresses, e.g. when a mobile device switches from Wi-Fi to
+// cellular. Otherwise the connections would silently die, and only be closed
+// once the ICE failed timeout expires.
+//
+// /webrtc-direct connections have no signaling channel to restart ICE on. The
+// host closes them like connections of other transports.
+func (t *PrivateTransport) watchNetwork(sub *eventbus.TypedSubscription[event.EvtLocalInterfaceAddrsChanged]) {
+	defer t.wg.Done()
+	defer sub.Close()
+	for {
+		select {
+		case evt, ok := <-sub.Out():
+			if !ok {
+				return
+			}
+			t.onInterfaceAddrsChanged(evt)
+		case <-t.ctx.Done():
+			return
+		}
+	}
+}
+
+func (t *PrivateTransport) onInterfaceAddrsChanged(evt event.EvtLocalInterfaceAddrsChanged) {
+	// Added addresses don't break existing connections.
+	removed := make(map[string]struct{}, len(evt.Removed))
+	for _, a := range evt.Removed {
+		ip, err := manet.ToIP(a)
+		if err != nil {
+			continue
+		}
+		removed[ip.String()] = struct{}{}
+	}
+	if len(removed) == 0 {
+		return
+	}
+
+	t.mx.Lock()
+	conns := append([]*connection(nil), t.conns...)
+	t.mx.Unlock()
+	for _, c := range conns {
+		if !usesRemovedAddr(c.pc, removed) {
+			continue
+		}
+		t.wg.Add(1)
+		go func(c *connection) {
+			defer t.wg.Done()
+			t.restartICE(c)
+		}(c)
+	}
+}
+
+// usesRemovedAddr says if the local candidate selected by ICE on pc is bound
+// to one of the removed interface addresses.
+func usesRemovedAddr(pc *webrtc.PeerConnection, removed map[string]struct{}) bool {
+	sctp := pc.SCTP()
+	if sctp == nil || sctp.Transport() == nil || sctp.Transport().ICETransport() == nil {
+		return false
+	}
+	cp, err := sctp.Transport().ICETransport().GetSelectedCandidatePair()
+	if err != nil || cp == nil || cp.Local == nil {
+		return false
+	}
+	if cp.Local.Typ == webrtc.ICECandidateTypeRelay {
+		// We don't know which interface the TURN allocation uses.
+		return true
+	}
+	// The related address of server reflexive candidates is the local
+	// address they were discovered from.
+	for _, a := range []string{cp.Local.Address, cp.Local.RelatedAddress} {
+		if ip := net.ParseIP(a); ip != nil {
+			if _, ok := removed[ip.String()]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// restartICE restarts ICE on c, so that ICE gathers candidates on the new
+// network interfaces and selects a new candidate pair, while the DTLS and
+// SCTP sessions, and with them the streams, survive.
+//
+// Like when establishing the connection, the offer, the answer and the
+// candidates are exchanged on a signaling stream, using ICERestartProtocol. It
+// is opened on an existing connection to the peer that isn't a WebRTC
+// connection, or on a new relayed connection. The remote peer must be
+// listening on /webrtc to answer the restart.
+//
+// If the restart fails, c is closed. If it was the last connection to the
+// peer, the swarm emits an EvtPeerConnectednessChanged event, so that the
+// application can dial the peer again.
+func (t *PrivateTransport) restartICE(c *connection) {
+	if !c.iceRestart.TryLock() {
+		// already restarting
+		return
+	}
+	defer c.iceRestart.Unlock()
+
+	ctx, cancel := context.WithTimeout(c.ctx, candidateSetupTimeout)
+	defer cancel()
+	stop := context.AfterFunc(t.ctx, cancel)
+	defer stop()
+
+	err := t.offerICERestart(ctx, c)
+	if err != nil && c.remoteICERestart.Load() {
+		// Both peers restarted ICE at the same time, and the remote peer
+		// rejected our restart in favor of its own.
+		log.Debugw("ICE restart superseded by the remote peer", "peer", c.remotePeer)
+		return
+	}
+	if err != nil {
+		log.Debugw("ICE restart failed, closing connection", "peer", c.remotePeer, "error", err)
+		t.t.metrics.iceRestarted(false)
+		c.closeOnce.Do(func() { c.closeWithError(fmt.Errorf("ICE restart failed: %w", err)) })
+		return
+	}
+	log.Debugw("restarted ICE", "peer", c.remotePeer)
+	t.t.metrics.iceRestarted(true)
+}
+
+// offerICERestart runs the initiator side of an ICE restart of c.
+func (t *PrivateTransport) offerICERestart(ctx context.Context, c *connection) error {
+	s, err := t.openSignalingStream(ctx, c)
+	if err != nil {
+		return fmt.Errorf("failed to open signaling stream: %w", err)
+	}
+	defer s.Close()
+	stop := context.AfterFunc(ctx, func() { s.Reset() })
+	defer stop()
+
+	pc := c.pc
+	sig := newSignalingStream(s)
+	connected := iceConnected(pc)
+	pc.OnICECandidate(sig.sendCandidate)
+	if err := sig.restartOffer(pc); err != nil {
+		s.Reset()
+		return err
+	}
+	go sig.readCandidates(pc)
+
+	select {
+	case err := <-connected:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// openSignalingStream opens an ICERestartProtocol stream to the remote peer of
+// c, on a connection other than c.
+func (t *PrivateTransport) openSignalingStream(ctx context.Context, c *connection) (network.Stream, error) {
+	ctx = network.WithUseTransient(ctx, "webrtc signaling")
+	for _, nc := range t.host.Network().ConnsToPeer(c.remotePeer) {
+		if nc.ConnState().Transport == "webrtc" || nc.IsClosed() {
+			continue
+		}
+		s, err := newSignalingStreamOn(ctx, nc)
+		if err == nil {
+			return s, nil
+		}
+		log.Debugw("failed to open signaling stream", "peer", c.remotePeer, "addr", nc.RemoteMultiaddr(), "error", err)
+	}
+
+	// Relayed connections are limited, so the one the connection was
+	// signaled on is usually closed by now. Dial the peer via relay again,
+	// using the relay address of that connection, and the relay addresses the
+	// /webrtc addresses of the peer consist of.
+	ps := t.host.Peerstore()
+	if c.signalingAddr != nil {
+		ps.AddAddr(c.remotePeer, c.signalingAddr, peerstore.TempAddrTTL)
+	}
+	for _, a := range ps.Addrs(c.remotePeer) {
+		if t.CanDial(a) {
+			relayAddr, _ := ma.SplitLast(a)
+			ps.AddAddr(c.remotePeer, relayAddr, peerstore.TempAddrTTL)
+		}
+	}
+	dctx := network.WithDialAddrFilter(ctx, isCircuitAddr, "webrtc signaling")
+	nc, err := t.host.Network().DialPeer(dctx, c.remotePeer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s via relay: %w", c.remotePeer, err)
+	}
+	return newSignalingStreamOn(ctx, nc)
+}
+
+// newSignalingStreamOn opens an ICERestartProtocol stream on nc.
+func newSignalingStreamOn(ctx context.Context, nc network.Conn) (network.Stream, error) {
+	s, err := nc.NewStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.SetProtocol(ICERestartProtocol); err != nil {
+		s.Reset()
+		return nil, err
+	}
+	if err := s.Scope().SetService("webrtc-signaling"); err != nil {
+		s.Reset()
+		return nil, err
+	}
+	if err := msmux.SelectProtoOrFail(ICERestartProtocol, s); err != nil {
+		s.Reset()
+		return nil, err
+	}
+	return s, nil
+}
+
+// handleICERestartStream answers the ICE restart offered on an
+// ICERestartProtocol stream.
+func (l *privateListener) handleICERestartStream(s network.Stream) {
+	l.wg.Add(1)
+	defer l.wg.Done()
+
+	if l.ctx.Err() != nil {
+		s.Reset()
+		return
+	}
+	if err := s.Scope().SetService("webrtc-signaling"); err != nil {
+		s.Reset()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(l.ctx, candidateSetupTimeout)
+	defer cancel()
+	sig := newSignalingStream(s)
+	stop := context.AfterFunc(ctx, func() { s.Reset() })
+	offer, err := sig.read(pb.SignalingMessage_SDP_OFFER)
+	stop()
+	if err != nil {
+		log.Debugw("failed to read ICE restart offer", "peer", s.Conn().RemotePeer(), "error", err)
+		s.Reset()
+		return
+	}
+	if err := l.t.answerICERestart(ctx, sig, offer); err != nil {
+		log.Debugw("ICE restart failed", "peer", s.Conn().RemotePeer(), "error", err)
+		s.Reset()
+		return
+	}
+	s.Close()
+}
+
+// answerICERestart runs the responder side of an ICE restart. The restart
+// applies to the most recent connection to the remote peer of sig.
+func (t *PrivateTransport) answerICERestart(ctx context.Context, sig *signalingStream, offer string) error {
+	p := sig.s.Conn().RemotePeer()
+	var c *connection
+	t.mx.Lock()
+	for _, cc := range t.conns {
+		if cc.remotePeer == p {
+			c = cc
+		}
+	}
+	t.mx.Unlock()
+	if c == nil {
+		return errors.New("no connection to restart")
+	}
+
+	if !c.iceRestart.TryLock() {
+		// Both peers are restarting ICE. The restart of the peer with the
+		// lower peer ID wins, the other peer answers it once its own restart
+		// was rejected.
+		if t.host.ID() < p {
+			return errICERestartGlare
+		}
+		c.remoteICERestart.Store(true)
+		c.iceRestart.Lock()
+		c.remoteICERestart.Store(false)
+	}
+	defer c.iceRestart.Unlock()
+	if c.IsClosed() {
+		return c.closeErr
+	}
+
+	stop := context.AfterFunc(ctx, func() { sig.s.Reset() })
+	defer stop()
+
+	pc := c.pc
+	connected := iceConnected(pc)
+	pc.OnICECandidate(sig.sendCandidate)
+	err := sig.restartAnswer(pc, offer)
+	if err == nil {
+		go sig.readCandidates(pc)
+		select {
+		case err = <-connected:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	if err != nil {
+		c.closeOnce.Do(func() { c.closeWithError(fmt.Errorf("ICE restart failed: %w", err)) })
+	}
+	return err
+}
+
+// iceConnected returns a channel that is closed once ICE is connected, or
+// receives an error if ICE failed.
+func iceConnected(pc *webrtc.PeerConnection) <-chan error {
+	errC := make(chan error, 1)
+	var once sync.Once
+	pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		switch state {
+		case webrtc.ICEConnectionStateConnected, webrtc.ICEConnectionStateCompleted:
+			once.Do(func() { close(errC) })
+		case webrtc.ICEConnectionStateFailed, webrtc.ICEConnectionStateClosed:
+			once.Do(func() {
+				errC <- fmt.Errorf("ICE %s", state)
+				close(errC)
+			})
+		}
+	})
+	return errC
+}
+
+// restartOffer sends an offer restarting ICE on pc, and applies the answer of
+// the remote peer.
+func (ss *signalingStream) restartOffer(pc *webrtc.PeerConnection) error {
+	// Creating the offer restarts ICE, which starts gathering candidates.
+	ss.holdCandidates()
+	offer, err := pc.CreateOffer(&webrtc.OfferOptions{ICERestart: true})
+	if err != nil {
+		return fmt.Errorf("create offer: %w", err)
+	}
+	if err := ss.writeSDP(pb.SignalingMessage_SDP_OFFER, offer.SDP); err != nil {
+		return fmt.Errorf("send offer: %w", err)
+	}
+	sdp, err := ss.read(pb.SignalingMessage_SDP_ANSWER)
+	if err != nil {
+		return fmt.Errorf("read answer: %w", err)
+	}
+	// Only set the offer once it was answered. If the remote peer rejects
+	// it, because it's restarting ICE itself, the signaling state stays
+	// stable, so that we can answer the offer of the remote peer instead.
+	if err := pc.SetLocalDescription(offer); err != nil {
+		return fmt.Errorf("set local description: %w", err)
+	}
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: sdp}); err != nil {
+		return fmt.Errorf("set remote description: %w", err)
+	}
+	return nil
+}
+
+// restartAnswer applies an offer restarting ICE, and sends the answer of pc.
+func (ss *signalingStream) restartAnswer(pc *webrtc.PeerConnection, offer string) error {
+	// Applying an offer with new ICE credentials restarts ICE.
+	ss.holdCandidates()
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		return fmt.Errorf("set remote description: %w", err)
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return fmt.Errorf("create answer: %w", err)
+	}
+	if err := ss.writeSDP(pb.SignalingMessage_SDP_ANSWER, answer.SDP); err != nil {
+		return fmt.Errorf("send answer: %w", err)
+	}
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return fmt.Errorf("set local description: %w", err)
+	}
+	return nil
+}
@@ -1,3 +1,5 @@
+//go:build !js
+
 package libp2pwebrtc
 
 import (
@@ -12,6 +14,7 @@ import (
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/panics"
 	"github.com/libp2p/go-libp2p/core/peer"
 	tpt "github.com/libp2p/go-libp2p/core/transport"
 	"github.com/libp2p/go-libp2p/p2p/transport/webrtc/udpmux"
@@ -125,6 +128,7 @@ func (l *listener) listen() {
 
 		go func() {
 			defer func() { <-inFlightSemaphore }()
+			defer panics.Recover("webrtc", "", func() { l.mux.RemoveConnByUfrag(candidate.Ufrag) })
 
 			ctx, cancel := context.WithTimeout(l.ctx, candidateSetupTimeout)
 			defer cancel()
@@ -164,8 +168,10 @@ func (l *listener) handleCandidate(ctx context.Context, candidate udpmux.Candida
 	if err != nil {
 		return nil, err
 	}
+	start := time.Now()
 	conn, err := l.setupConnection(ctx, scope, remoteMultiaddr, candidate)
 	if err != nil {
+		l.transport.metrics.connectionFailed("webrtc-direct", network.DirInbound)
 		scope.Done()
 		return nil, err
 	}
@@ -173,6 +179,7 @@ func (l *listener) handleCandidate(ctx context.Context, candidate udpmux.Candida
 		conn.Close()
 		return nil, errors.New("connection gated")
 	}
+	l.transport.metrics.connectionEstablished(conn.ConnState(), network.DirInbound, time.Since(start))
 	return conn, nil
 }
 
@@ -221,6 +228,9 @@ func (l *listener) setupConnection(
 
 	errC := addOnConnectionStateChangeCallback(w.PeerConnection)
 	// Infer the client SDP from the incoming STUN message by setting the ice-ufrag.
+	// The source address of the message is the only candidate of the client
+	// we need, and as an ICE lite agent we don't gather any candidates, so
+	// there's nothing to trickle.
 	if err := w.PeerConnection.SetRemoteDescription(webrtc.SessionDescription{
 		SDP:  createClientSDP(candidate.Addr, candidate.Ufrag),
 		Type: webrtc.SDPTypeOffer,
@@ -249,7 +259,7 @@ func (l *listener) setupConnection(
 	if err != nil {
 		return nil, err
 	}
-	handshakeChannel := newStream(w.HandshakeDataChannel, rwc, func() {})
+	handshakeChannel := newStream(w.HandshakeDataChannel, rwc, defaultStreamConfig, nil, func() {})
 	// we do not yet know A's peer ID so accept any inbound
 	remotePubKey, err := l.transport.noiseHandshake(ctx, w.PeerConnection, handshakeChannel, "", crypto.SHA256, true)
 	if err != nil {
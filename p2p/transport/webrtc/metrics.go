@@ -0,0 +1,159 @@
+package libp2pwebrtc
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/metricshelper"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricNamespace = "libp2p_webrtc"
+
+var (
+	connEstablishment = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricNamespace,
+			Name:      "connection_establishment_seconds",
+			Help:      "Time to establish a connection, from the start of the dial or of the inbound connection attempt",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+		},
+		[]string{"transport", "dir"},
+	)
+	connFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "connections_failed_total",
+			Help:      "Connection attempts that failed",
+		},
+		[]string{"transport", "dir"},
+	)
+	iceCandidatePairs = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "ice_candidate_pairs_total",
+			Help:      "Types of the candidates selected by ICE for established connections",
+		},
+		[]string{"transport", "local", "remote"},
+	)
+	dataChannelsOpened = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "datachannels_opened_total",
+			Help:      "Data channels opened for streams",
+		},
+		[]string{"dir"},
+	)
+	dataChannelsClosed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "datachannels_closed_total",
+			Help:      "Data channels of streams closed",
+		},
+		[]string{"dir"},
+	)
+	connBuffered = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: metricNamespace,
+			Name:      "connection_buffered_bytes",
+			Help:      "Data buffered on all the data channels of a connection when a stream writes a message",
+			Buckets:   prometheus.ExponentialBuckets(1<<10, 2, 15),
+		},
+	)
+	writeStalls = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: metricNamespace,
+			Name:      "write_stall_seconds",
+			Help:      "Time writes were blocked waiting for space in the send buffer",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+		},
+	)
+	iceRestarts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "ice_restarts_total",
+			Help:      "ICE restarts of private-to-private connections after a change of the local network",
+		},
+		[]string{"result"},
+	)
+	collectors = []prometheus.Collector{
+		connEstablishment,
+		connFailures,
+		iceCandidatePairs,
+		dataChannelsOpened,
+		dataChannelsClosed,
+		connBuffered,
+		writeStalls,
+		iceRestarts,
+	}
+)
+
+// metricsTracer records the metrics of a transport. A nil *metricsTracer
+// records nothing.
+type metricsTracer struct{}
+
+func newMetricsTracer(reg prometheus.Registerer) *metricsTracer {
+	metricshelper.RegisterCollectors(reg, collectors...)
+	return &metricsTracer{}
+}
+
+// connectionEstablished is called when a connection is ready to be used. d is
+// the time since the connection attempt started.
+func (m *metricsTracer) connectionEstablished(state network.ConnectionState, dir network.Direction, d time.Duration) {
+	if m == nil {
+		return
+	}
+	connEstablishment.WithLabelValues(state.Transport, metricshelper.GetDirection(dir)).Observe(d.Seconds())
+	if state.ICE != nil {
+		iceCandidatePairs.WithLabelValues(state.Transport, state.ICE.LocalCandidateType, state.ICE.RemoteCandidateType).Inc()
+	}
+}
+
+func (m *metricsTracer) connectionFailed(transport string, dir network.Direction) {
+	if m == nil {
+		return
+	}
+	connFailures.WithLabelValues(transport, metricshelper.GetDirection(dir)).Inc()
+}
+
+func (m *metricsTracer) dataChannelOpened(dir network.Direction) {
+	if m == nil {
+		return
+	}
+	dataChannelsOpened.WithLabelValues(metricshelper.GetDirection(dir)).Inc()
+}
+
+func (m *metricsTracer) dataChannelClosed(dir network.Direction) {
+	if m == nil {
+		return
+	}
+	dataChannelsClosed.WithLabelValues(metricshelper.GetDirection(dir)).Inc()
+}
+
+func (m *metricsTracer) connectionBuffered(n int) {
+	if m == nil {
+		return
+	}
+	connBuffered.Observe(float64(n))
+}
+
+func (m *metricsTracer) writeStalled(d time.Duration) {
+	if m == nil {
+		return
+	}
+	writeStalls.Observe(d.Seconds())
+}
+
+// iceRestarted is called when an ICE restart initiated by us completed. Failed
+// restarts close the connection.
+func (m *metricsTracer) iceRestarted(success bool) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if !success {
+		result = "failure"
+	}
+	iceRestarts.WithLabelValues(result).Inc()
+}
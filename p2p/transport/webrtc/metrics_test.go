@@ -0,0 +1,95 @@
+//go:build !js
+
+package libp2pwebrtc
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// sampleCount returns the number of observations of the histograms of c.
+func sampleCount(t *testing.T, c prometheus.Collector) uint64 {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	require.NoError(t, reg.Register(c))
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	var n uint64
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			n += m.GetHistogram().GetSampleCount()
+		}
+	}
+	return n
+}
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	tr, listeningPeer := getTransport(t, WithMetricsRegisterer(reg))
+	tr1, _ := getTransport(t, WithMetricsRegisterer(reg))
+
+	established := sampleCount(t, connEstablishment)
+	stalls := sampleCount(t, writeStalls)
+	opened := testutil.ToFloat64(dataChannelsOpened.WithLabelValues("outbound"))
+	closed := testutil.ToFloat64(dataChannelsClosed.WithLabelValues("outbound"))
+
+	listener, err := tr.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/webrtc-direct"))
+	require.NoError(t, err)
+	defer listener.Close()
+
+	accepted := make(chan error, 1)
+	go func() {
+		lconn, err := listener.Accept()
+		if err != nil {
+			accepted <- err
+			return
+		}
+		defer lconn.Close()
+		str, err := lconn.AcceptStream()
+		if err != nil {
+			accepted <- err
+			return
+		}
+		_, err = io.Copy(io.Discard, str)
+		str.Close()
+		accepted <- err
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := tr1.Dial(ctx, listener.Multiaddr(), listeningPeer)
+	require.NoError(t, err)
+	defer conn.Close()
+	str, err := conn.OpenStream(ctx)
+	require.NoError(t, err)
+	_, err = str.Write(make([]byte, 256<<10))
+	require.NoError(t, err)
+	require.NoError(t, str.Close())
+	require.NoError(t, <-accepted)
+
+	// both the dialer and the listener
+	require.Equal(t, established+2, sampleCount(t, connEstablishment))
+	require.Equal(t, opened+1, testutil.ToFloat64(dataChannelsOpened.WithLabelValues("outbound")))
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(dataChannelsClosed.WithLabelValues("outbound")) == closed+1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NotZero(t, testutil.ToFloat64(iceCandidatePairs.WithLabelValues("webrtc-direct", "host", "host")))
+	// the write is larger than the send buffer of the stream
+	require.Greater(t, sampleCount(t, writeStalls), stalls)
+
+	names := make(map[string]bool)
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	for _, mf := range mfs {
+		names[mf.GetName()] = true
+	}
+	require.True(t, names["libp2p_webrtc_connection_establishment_seconds"])
+	require.True(t, names["libp2p_webrtc_connection_buffered_bytes"])
+}
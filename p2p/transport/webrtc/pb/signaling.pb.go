@@ -0,0 +1,221 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.32.0
+// 	protoc        v3.21.12
+// source: signaling.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type SignalingMessage_Type int32
+
+const (
+	SignalingMessage_SDP_OFFER     SignalingMessage_Type = 0
+	SignalingMessage_SDP_ANSWER    SignalingMessage_Type = 1
+	SignalingMessage_ICE_CANDIDATE SignalingMessage_Type = 2
+)
+
+// Enum value maps for SignalingMessage_Type.
+var (
+	SignalingMessage_Type_name = map[int32]string{
+		0: "SDP_OFFER",
+		1: "SDP_ANSWER",
+		2: "ICE_CANDIDATE",
+	}
+	SignalingMessage_Type_value = map[string]int32{
+		"SDP_OFFER":     0,
+		"SDP_ANSWER":    1,
+		"ICE_CANDIDATE": 2,
+	}
+)
+
+func (x SignalingMessage_Type) Enum() *SignalingMessage_Type {
+	p := new(SignalingMessage_Type)
+	*p = x
+	return p
+}
+
+func (x SignalingMessage_Type) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (SignalingMessage_Type) Descriptor() protoreflect.EnumDescriptor {
+	return file_signaling_proto_enumTypes[0].Descriptor()
+}
+
+func (SignalingMessage_Type) Type() protoreflect.EnumType {
+	return &file_signaling_proto_enumTypes[0]
+}
+
+func (x SignalingMessage_Type) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Do not use.
+func (x *SignalingMessage_Type) UnmarshalJSON(b []byte) error {
+	num, err := protoimpl.X.UnmarshalJSONEnum(x.Descriptor(), b)
+	if err != nil {
+		return err
+	}
+	*x = SignalingMessage_Type(num)
+	return nil
+}
+
+// Deprecated: Use SignalingMessage_Type.Descriptor instead.
+func (SignalingMessage_Type) EnumDescriptor() ([]byte, []int) {
+	return file_signaling_proto_rawDescGZIP(), []int{0, 0}
+}
+
+type SignalingMessage struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Type *SignalingMessage_Type `protobuf:"varint,1,opt,name=type,enum=SignalingMessage_Type" json:"type,omitempty"`
+	Data *string                `protobuf:"bytes,2,opt,name=data" json:"data,omitempty"`
+}
+
+func (x *SignalingMessage) Reset() {
+	*x = SignalingMessage{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_signaling_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SignalingMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SignalingMessage) ProtoMessage() {}
+
+func (x *SignalingMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_signaling_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SignalingMessage.ProtoReflect.Descriptor instead.
+func (*SignalingMessage) Descriptor() ([]byte, []int) {
+	return file_signaling_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SignalingMessage) GetType() SignalingMessage_Type {
+	if x != nil && x.Type != nil {
+		return *x.Type
+	}
+	return SignalingMessage_SDP_OFFER
+}
+
+func (x *SignalingMessage) GetData() string {
+	if x != nil && x.Data != nil {
+		return *x.Data
+	}
+	return ""
+}
+
+var File_signaling_proto protoreflect.FileDescriptor
+
+var file_signaling_proto_rawDesc = []byte{
+	0x0a, 0x0f, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x6c, 0x69, 0x6e, 0x67, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x22, 0x8c, 0x01, 0x0a, 0x10, 0x53, 0x69, 0x67, 0x6e, 0x61, 0x6c, 0x69, 0x6e, 0x67, 0x4d,
+	0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x2a, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0e, 0x32, 0x16, 0x2e, 0x53, 0x69, 0x67, 0x6e, 0x61, 0x6c, 0x69, 0x6e, 0x67,
+	0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x2e, 0x54, 0x79, 0x70, 0x65, 0x52, 0x04, 0x74, 0x79,
+	0x70, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x22, 0x38, 0x0a, 0x04, 0x54, 0x79, 0x70, 0x65, 0x12, 0x0d,
+	0x0a, 0x09, 0x53, 0x44, 0x50, 0x5f, 0x4f, 0x46, 0x46, 0x45, 0x52, 0x10, 0x00, 0x12, 0x0e, 0x0a,
+	0x0a, 0x53, 0x44, 0x50, 0x5f, 0x41, 0x4e, 0x53, 0x57, 0x45, 0x52, 0x10, 0x01, 0x12, 0x11, 0x0a,
+	0x0d, 0x49, 0x43, 0x45, 0x5f, 0x43, 0x41, 0x4e, 0x44, 0x49, 0x44, 0x41, 0x54, 0x45, 0x10, 0x02,
+	0x42, 0x35, 0x5a, 0x33, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6c,
+	0x69, 0x62, 0x70, 0x32, 0x70, 0x2f, 0x67, 0x6f, 0x2d, 0x6c, 0x69, 0x62, 0x70, 0x32, 0x70, 0x2f,
+	0x70, 0x32, 0x70, 0x2f, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x70, 0x6f, 0x72, 0x74, 0x2f, 0x77, 0x65,
+	0x62, 0x72, 0x74, 0x63, 0x2f, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x32,
+}
+
+var (
+	file_signaling_proto_rawDescOnce sync.Once
+	file_signaling_proto_rawDescData = file_signaling_proto_rawDesc
+)
+
+func file_signaling_proto_rawDescGZIP() []byte {
+	file_signaling_proto_rawDescOnce.Do(func() {
+		file_signaling_proto_rawDescData = protoimpl.X.CompressGZIP(file_signaling_proto_rawDescData)
+	})
+	return file_signaling_proto_rawDescData
+}
+
+var file_signaling_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_signaling_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_signaling_proto_goTypes = []interface{}{
+	(SignalingMessage_Type)(0), // 0: SignalingMessage.Type
+	(*SignalingMessage)(nil),   // 1: SignalingMessage
+}
+var file_signaling_proto_depIdxs = []int32{
+	0, // 0: SignalingMessage.type:type_name -> SignalingMessage.Type
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_signaling_proto_init() }
+func file_signaling_proto_init() {
+	if File_signaling_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_signaling_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SignalingMessage); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_signaling_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_signaling_proto_goTypes,
+		DependencyIndexes: file_signaling_proto_depIdxs,
+		EnumInfos:         file_signaling_proto_enumTypes,
+		MessageInfos:      file_signaling_proto_msgTypes,
+	}.Build()
+	File_signaling_proto = out.File
+	file_signaling_proto_rawDesc = nil
+	file_signaling_proto_goTypes = nil
+	file_signaling_proto_depIdxs = nil
+}
@@ -0,0 +1,660 @@
+//go:build !js
+
+package libp2pwebrtc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	tpt "github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	"github.com/libp2p/go-libp2p/p2p/transport/webrtc/pb"
+
+	"github.com/libp2p/go-msgio/pbio"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/pion/webrtc/v3"
+)
+
+// SignalingProtocol is the protocol of the stream the SDP offer and answer,
+// and the ICE candidates, of a private-to-private connection are exchanged
+// on.
+const SignalingProtocol protocol.ID = "/webrtc-signaling/0.0.1"
+
+// ICERestartProtocol is the protocol of the stream ICE is restarted on, on an
+// established private-to-private connection. The messages are the same as on
+// SignalingProtocol streams: an SDP offer restarting ICE, the answer, and the
+// ICE candidates.
+const ICERestartProtocol protocol.ID = "/webrtc-signaling/ice-restart/0.0.1"
+
+// maxSignalingMessageSize is the maximum size of a message on the signaling
+// stream. SDPs are usually a few kB.
+const maxSignalingMessageSize = 16 << 10
+
+var privateListenAddr = ma.StringCast("/webrtc")
+
+// PrivateTransport establishes private-to-private WebRTC connections (/webrtc),
+// e.g. between two peers behind NATs. The peers exchange the SDP offer and
+// answer over a relayed connection (see SignalingProtocol), after which ICE
+// establishes a direct connection, using STUN and TURN servers if configured.
+//
+// The DTLS certificate fingerprints are exchanged over the authenticated
+// relayed connection, so unlike /webrtc-direct, no Noise handshake is needed.
+//
+// When the local network changes, the transport restarts ICE on the affected
+// connections, see restartICE.
+type PrivateTransport struct {
+	t    *WebRTCTransport
+	host host.Host
+
+	mx       sync.Mutex
+	listener *privateListener
+	// conns are the established connections, oldest first.
+	conns []*connection
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+var (
+	_ tpt.Transport = &PrivateTransport{}
+	_ io.Closer     = &PrivateTransport{}
+)
+
+// AddPrivateTransport adds a PrivateTransport to the network of h, and listens
+// for private-to-private connections. h must be able to dial and accept
+// relayed connections, see libp2p.EnableRelay.
+//
+// Peers dial us using our relay addresses with /webrtc appended, e.g.
+// /ip4/198.51.100.1/udp/4001/quic-v1/p2p/<relay>/p2p-circuit/webrtc.
+// Autorelay advertises these addresses once the transport listens.
+func AddPrivateTransport(h host.Host, opts ...Option) (*PrivateTransport, error) {
+	n, ok := h.Network().(tpt.TransportNetwork)
+	if !ok {
+		return nil, fmt.Errorf("%v is not a transport network", h.Network())
+	}
+	t, err := NewPrivateTransport(h, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := n.AddTransport(t); err != nil {
+		return nil, fmt.Errorf("error adding WebRTC transport: %w", err)
+	}
+	if err := n.Listen(privateListenAddr); err != nil {
+		return nil, fmt.Errorf("error listening on %s: %w", privateListenAddr, err)
+	}
+	return t, nil
+}
+
+// NewPrivateTransport creates a PrivateTransport. The options configure the
+// peer connections like they do for the /webrtc-direct transport.
+func NewPrivateTransport(h host.Host, opts ...Option) (*PrivateTransport, error) {
+	privKey := h.Peerstore().PrivKey(h.ID())
+	if privKey == nil {
+		return nil, errors.New("missing private key of the host")
+	}
+	t, err := New(privKey, nil, nil, h.Network().ResourceManager(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := eventbus.SubscribeTyped[event.EvtLocalInterfaceAddrsChanged](h.EventBus(), eventbus.Name("webrtc"))
+	if err != nil {
+		return nil, err
+	}
+	pt := &PrivateTransport{t: t, host: h}
+	pt.ctx, pt.cancel = context.WithCancel(context.Background())
+	pt.wg.Add(1)
+	go pt.watchNetwork(sub)
+	return pt, nil
+}
+
+// Close stops restarting ICE on network changes. It's called by the swarm
+// when it's closed.
+func (t *PrivateTransport) Close() error {
+	t.cancel()
+	t.wg.Wait()
+	return nil
+}
+
+func (t *PrivateTransport) Protocols() []int {
+	return []int{ma.P_WEBRTC}
+}
+
+func (t *PrivateTransport) Proxy() bool {
+	return false
+}
+
+func (t *PrivateTransport) Capabilities() tpt.Capabilities {
+	return tpt.CapBrowser | tpt.CapDatagrams | tpt.CapNetworkMigration
+}
+
+// CanDial returns true for relay addresses with /webrtc appended.
+func (t *PrivateTransport) CanDial(addr ma.Multiaddr) bool {
+	relayAddr, last := ma.SplitLast(addr)
+	if last == nil || last.Protocol().Code != ma.P_WEBRTC || relayAddr == nil {
+		return false
+	}
+	_, err := relayAddr.ValueForProtocol(ma.P_CIRCUIT)
+	return err == nil
+}
+
+// Listen listens for private-to-private connections. The only supported
+// address is /webrtc.
+func (t *PrivateTransport) Listen(addr ma.Multiaddr) (tpt.Listener, error) {
+	if !addr.Equal(privateListenAddr) {
+		return nil, fmt.Errorf("can only listen on %s", privateListenAddr)
+	}
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if t.listener != nil {
+		return nil, errors.New("already listening")
+	}
+	l := newPrivateListener(t)
+	t.listener = l
+	t.host.SetStreamHandler(SignalingProtocol, l.handleSignalingStream)
+	t.host.SetStreamHandler(ICERestartProtocol, l.handleICERestartStream)
+	return l, nil
+}
+
+func (t *PrivateTransport) removeListener(l *privateListener) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if t.listener == l {
+		t.host.RemoveStreamHandler(SignalingProtocol)
+		t.host.RemoveStreamHandler(ICERestartProtocol)
+		t.listener = nil
+	}
+}
+
+func (t *PrivateTransport) Dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (tpt.CapableConn, error) {
+	if !t.CanDial(raddr) {
+		return nil, fmt.Errorf("can't dial %s", raddr)
+	}
+	scope, err := t.t.rcmgr.OpenConnection(network.DirOutbound, false, raddr)
+	if err != nil {
+		return nil, err
+	}
+	if err := scope.SetPeer(p); err != nil {
+		scope.Done()
+		return nil, err
+	}
+	start := time.Now()
+	conn, err := t.dial(ctx, scope, raddr, p)
+	if err != nil {
+		t.t.metrics.connectionFailed("webrtc", network.DirOutbound)
+		scope.Done()
+		return nil, err
+	}
+	t.t.metrics.connectionEstablished(conn.ConnState(), network.DirOutbound, time.Since(start))
+	return conn, nil
+}
+
+func (t *PrivateTransport) dial(ctx context.Context, scope network.ConnManagementScope, raddr ma.Multiaddr, p peer.ID) (tpt.CapableConn, error) {
+	relayAddr, _ := ma.SplitLast(raddr)
+	// The signaling stream runs over a relayed connection, which is limited.
+	ctx = network.WithUseTransient(ctx, "webrtc signaling")
+	if len(t.host.Network().ConnsToPeer(p)) == 0 {
+		t.host.Peerstore().AddAddr(p, relayAddr, peerstore.TempAddrTTL)
+		dctx := network.WithDialAddrFilter(ctx, isCircuitAddr, "webrtc signaling")
+		if _, err := t.host.Network().DialPeer(dctx, p); err != nil {
+			return nil, fmt.Errorf("failed to connect to %s via relay: %w", p, err)
+		}
+	}
+	s, err := t.host.NewStream(ctx, p, SignalingProtocol)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open signaling stream: %w", err)
+	}
+	defer s.Close()
+	if err := s.Scope().SetService("webrtc-signaling"); err != nil {
+		s.Reset()
+		return nil, err
+	}
+	return t.connect(ctx, scope, newSignalingStream(s), network.DirOutbound, "")
+}
+
+// connect establishes a peer connection with the remote peer of sig,
+// exchanging the SDP and the ICE candidates on sig. The outbound side sends the
+// offer, the inbound side passes the offer it read as remoteOffer.
+// Candidates are trickled: both sides send them as they're gathered, and the
+// connectivity checks start with the first candidate pair, without waiting
+// for gathering to complete.
+func (t *PrivateTransport) connect(ctx context.Context, scope network.ConnManagementScope, sig *signalingStream, dir network.Direction, remoteOffer string) (tConn tpt.CapableConn, err error) {
+	s := sig.s
+	var w webRTCConnection
+	defer func() {
+		if err != nil {
+			s.Reset()
+			if w.PeerConnection != nil {
+				_ = w.PeerConnection.Close()
+			}
+		}
+	}()
+	stop := context.AfterFunc(ctx, func() { s.Reset() })
+	defer stop()
+
+	settingEngine := webrtc.SettingEngine{LoggerFactory: pionLoggerFactory}
+	// The offerer is the DTLS client, and uses even data channel IDs, like
+	// the dialer of a /webrtc-direct connection.
+	settingEngine.SetAnsweringDTLSRole(webrtc.DTLSRoleServer)
+	settingEngine.DetachDataChannels()
+	settingEngine.SetICETimeouts(
+		t.t.peerConnectionTimeouts.Disconnect,
+		t.t.peerConnectionTimeouts.Failed,
+		t.t.peerConnectionTimeouts.Keepalive,
+	)
+	settingEngine.SetIncludeLoopbackCandidate(true)
+	settingEngine.SetSCTPMaxReceiveBufferSize(sctpReceiveBufferSize)
+	if err := scope.ReserveMemory(sctpReceiveBufferSize, network.ReservationPriorityMedium); err != nil {
+		return nil, err
+	}
+
+	w, err = newWebRTCConnection(settingEngine, t.t.dialConfig(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("instantiating peer connection failed: %w", err)
+	}
+	errC := addOnConnectionStateChangeCallback(w.PeerConnection)
+
+	w.PeerConnection.OnICECandidate(sig.sendCandidate)
+	if dir == network.DirOutbound {
+		err = sig.offer(w.PeerConnection)
+	} else {
+		err = sig.answer(w.PeerConnection, remoteOffer)
+	}
+	if err != nil {
+		return nil, err
+	}
+	go sig.readCandidates(w.PeerConnection)
+
+	select {
+	case err := <-errC:
+		if err != nil {
+			return nil, err
+		}
+	case <-ctx.Done():
+		return nil, fmt.Errorf("peerconnection opening timed out: %w", ctx.Err())
+	}
+
+	localAddr, remoteAddr, err := selectedCandidateAddrs(w.PeerConnection)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := newConnection(
+		dir,
+		w.PeerConnection,
+		t.t,
+		scope,
+		t.t.localPeerId,
+		localAddr,
+		s.Conn().RemotePeer(),
+		s.Conn().RemotePublicKey(),
+		remoteAddr,
+		w.IncomingDataChannels,
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.private = t
+	if isCircuitAddr(s.Conn().RemoteMultiaddr()) {
+		conn.signalingAddr = s.Conn().RemoteMultiaddr()
+	}
+	t.addConn(conn)
+	return conn, nil
+}
+
+// selectedCandidateAddrs returns the /webrtc multiaddrs of the candidate pair
+// selected by ICE.
+func selectedCandidateAddrs(pc *webrtc.PeerConnection) (local, remote ma.Multiaddr, err error) {
+	sctp := pc.SCTP()
+	if sctp == nil || sctp.Transport() == nil || sctp.Transport().ICETransport() == nil {
+		return nil, nil, errors.New("no ICE transport")
+	}
+	cp, err := sctp.Transport().ICETransport().GetSelectedCandidatePair()
+	if err != nil {
+		return nil, nil, fmt.Errorf("ice connection did not have selected candidate pair: error: %w", err)
+	}
+	if cp == nil {
+		return nil, nil, errors.New("ice connection did not have selected candidate pair: nil result")
+	}
+	local, err = candidateMultiaddr(cp.Local)
+	if err != nil {
+		return nil, nil, err
+	}
+	remote, err = candidateMultiaddr(cp.Remote)
+	if err != nil {
+		return nil, nil, err
+	}
+	return local, remote, nil
+}
+
+func candidateMultiaddr(c *webrtc.ICECandidate) (ma.Multiaddr, error) {
+	var addr net.Addr = &net.UDPAddr{IP: net.ParseIP(c.Address), Port: int(c.Port)}
+	if c.Protocol == webrtc.ICEProtocolTCP {
+		addr = &net.TCPAddr{IP: net.ParseIP(c.Address), Port: int(c.Port)}
+	}
+	a, err := manet.FromNetAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	return a.Encapsulate(privateListenAddr), nil
+}
+
+// signalingStream exchanges the messages of SignalingProtocol. ICE candidates
+// are sent from pion's callbacks, so writes are synchronized.
+type signalingStream struct {
+	s network.Stream
+	r pbio.Reader
+
+	wmx sync.Mutex
+	w   pbio.Writer
+	// holding is set while candidates are held back until the SDP is sent,
+	// see holdCandidates.
+	holding bool
+	held    []string
+}
+
+func newSignalingStream(s network.Stream) *signalingStream {
+	return &signalingStream{
+		s: s,
+		r: pbio.NewDelimitedReader(s, maxSignalingMessageSize),
+		w: pbio.NewDelimitedWriter(s),
+	}
+}
+
+func (ss *signalingStream) write(typ pb.SignalingMessage_Type, data string) error {
+	ss.wmx.Lock()
+	defer ss.wmx.Unlock()
+	return ss.w.WriteMsg(&pb.SignalingMessage{Type: typ.Enum(), Data: &data})
+}
+
+// holdCandidates holds back the candidates gathered until the SDP is sent
+// using writeSDP. This is needed when gathering starts before the SDP is
+// known, e.g. when restarting ICE.
+func (ss *signalingStream) holdCandidates() {
+	ss.wmx.Lock()
+	defer ss.wmx.Unlock()
+	ss.holding = true
+}
+
+// writeSDP sends an SDP, followed by the candidates held back.
+func (ss *signalingStream) writeSDP(typ pb.SignalingMessage_Type, sdp string) error {
+	ss.wmx.Lock()
+	defer ss.wmx.Unlock()
+	ss.holding = false
+	if err := ss.w.WriteMsg(&pb.SignalingMessage{Type: typ.Enum(), Data: &sdp}); err != nil {
+		return err
+	}
+	for _, c := range ss.held {
+		if err := ss.w.WriteMsg(&pb.SignalingMessage{Type: pb.SignalingMessage_ICE_CANDIDATE.Enum(), Data: &c}); err != nil {
+			return err
+		}
+	}
+	ss.held = nil
+	return nil
+}
+
+func (ss *signalingStream) readMsg() (*pb.SignalingMessage, error) {
+	var msg pb.SignalingMessage
+	if err := ss.r.ReadMsg(&msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (ss *signalingStream) read(typ pb.SignalingMessage_Type) (string, error) {
+	msg, err := ss.readMsg()
+	if err != nil {
+		return "", err
+	}
+	if msg.GetType() != typ {
+		return "", fmt.Errorf("expected %s message, got %s", typ, msg.GetType())
+	}
+	return msg.GetData(), nil
+}
+
+// offer sends the offer of pc, and applies the answer of the remote peer.
+func (ss *signalingStream) offer(pc *webrtc.PeerConnection) error {
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		return fmt.Errorf("create offer: %w", err)
+	}
+	// Send the offer before setting it, which starts gathering candidates,
+	// so that the candidates are sent after the offer.
+	if err := ss.write(pb.SignalingMessage_SDP_OFFER, offer.SDP); err != nil {
+		return fmt.Errorf("send offer: %w", err)
+	}
+	if err := pc.SetLocalDescription(offer); err != nil {
+		return fmt.Errorf("set local description: %w", err)
+	}
+	sdp, err := ss.read(pb.SignalingMessage_SDP_ANSWER)
+	if err != nil {
+		return fmt.Errorf("read answer: %w", err)
+	}
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: sdp}); err != nil {
+		return fmt.Errorf("set remote description: %w", err)
+	}
+	return nil
+}
+
+// answer applies the offer of the remote peer, and sends the answer of pc.
+func (ss *signalingStream) answer(pc *webrtc.PeerConnection, offer string) error {
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		return fmt.Errorf("set remote description: %w", err)
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return fmt.Errorf("create answer: %w", err)
+	}
+	if err := ss.write(pb.SignalingMessage_SDP_ANSWER, answer.SDP); err != nil {
+		return fmt.Errorf("send answer: %w", err)
+	}
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return fmt.Errorf("set local description: %w", err)
+	}
+	return nil
+}
+
+// sendCandidate sends a local ICE candidate. A nil candidate signals the end
+// of the candidates.
+func (ss *signalingStream) sendCandidate(c *webrtc.ICECandidate) {
+	var data string
+	if c != nil {
+		b, err := json.Marshal(c.ToJSON())
+		if err != nil {
+			log.Warnw("failed to marshal ICE candidate", "error", err)
+			return
+		}
+		data = string(b)
+	}
+	ss.wmx.Lock()
+	defer ss.wmx.Unlock()
+	if ss.holding {
+		ss.held = append(ss.held, data)
+		return
+	}
+	// The stream is closed once the connection is established, candidates
+	// gathered later are no longer needed.
+	_ = ss.w.WriteMsg(&pb.SignalingMessage{Type: pb.SignalingMessage_ICE_CANDIDATE.Enum(), Data: &data})
+}
+
+// readCandidates adds the ICE candidates of the remote peer to pc, until the
+// end of the candidates or until the stream is closed.
+func (ss *signalingStream) readCandidates(pc *webrtc.PeerConnection) {
+	for {
+		data, err := ss.read(pb.SignalingMessage_ICE_CANDIDATE)
+		if err != nil {
+			return
+		}
+		if data == "" {
+			return
+		}
+		var c webrtc.ICECandidateInit
+		if err := json.Unmarshal([]byte(data), &c); err != nil {
+			log.Debugw("invalid ICE candidate", "peer", ss.s.Conn().RemotePeer(), "error", err)
+			ss.s.Reset()
+			return
+		}
+		if err := pc.AddICECandidate(c); err != nil {
+			log.Debugw("failed to add ICE candidate", "peer", ss.s.Conn().RemotePeer(), "error", err)
+		}
+	}
+}
+
+// privateListener accepts private-to-private connections signaled on
+// SignalingProtocol streams.
+type privateListener struct {
+	t *PrivateTransport
+
+	acceptQueue chan tpt.CapableConn
+	inFlight    chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+var _ tpt.Listener = &privateListener{}
+
+func newPrivateListener(t *PrivateTransport) *privateListener {
+	l := &privateListener{
+		t:           t,
+		acceptQueue: make(chan tpt.CapableConn),
+		inFlight:    make(chan struct{}, t.t.maxInFlightConnections),
+	}
+	l.ctx, l.cancel = context.WithCancel(context.Background())
+	return l
+}
+
+func (l *privateListener) handleSignalingStream(s network.Stream) {
+	// Like the /webrtc-direct listener, we limit the number of connections
+	// being set up.
+	select {
+	case l.inFlight <- struct{}{}:
+	default:
+		log.Debugw("rejecting WebRTC connection: too many in-flight connections", "peer", s.Conn().RemotePeer())
+		s.Reset()
+		return
+	}
+	l.wg.Add(1)
+	defer l.wg.Done()
+	defer func() { <-l.inFlight }()
+
+	if l.ctx.Err() != nil {
+		s.Reset()
+		return
+	}
+	if err := s.Scope().SetService("webrtc-signaling"); err != nil {
+		s.Reset()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(l.ctx, candidateSetupTimeout)
+	defer cancel()
+	sig := newSignalingStream(s)
+	stop := context.AfterFunc(ctx, func() { s.Reset() })
+	msg, err := sig.readMsg()
+	stop()
+	if err != nil {
+		log.Debugw("failed to read signaling message", "peer", s.Conn().RemotePeer(), "error", err)
+		s.Reset()
+		return
+	}
+	switch msg.GetType() {
+	case pb.SignalingMessage_SDP_OFFER:
+		l.accept(ctx, sig, msg.GetData())
+	default:
+		log.Debugw("unexpected signaling message", "peer", s.Conn().RemotePeer(), "type", msg.GetType())
+		s.Reset()
+	}
+}
+
+// accept sets up the connection offered on sig, and queues it to be accepted.
+func (l *privateListener) accept(ctx context.Context, sig *signalingStream, offer string) {
+	s := sig.s
+	conn, err := l.setupConnection(ctx, sig, offer)
+	if err != nil {
+		log.Debugw("could not accept WebRTC connection", "peer", s.Conn().RemotePeer(), "error", err)
+		return
+	}
+	s.Close()
+
+	select {
+	case <-ctx.Done():
+		log.Warn("could not push connection: ctx done")
+		conn.Close()
+	case l.acceptQueue <- conn:
+	}
+}
+
+func (l *privateListener) setupConnection(ctx context.Context, sig *signalingStream, offer string) (tpt.CapableConn, error) {
+	s := sig.s
+	scope, err := l.t.t.rcmgr.OpenConnection(network.DirInbound, false, s.Conn().RemoteMultiaddr())
+	if err != nil {
+		s.Reset()
+		return nil, err
+	}
+	if err := scope.SetPeer(s.Conn().RemotePeer()); err != nil {
+		s.Reset()
+		scope.Done()
+		return nil, err
+	}
+	start := time.Now()
+	conn, err := l.t.connect(ctx, scope, sig, network.DirInbound, offer)
+	if err != nil {
+		l.t.t.metrics.connectionFailed("webrtc", network.DirInbound)
+		scope.Done()
+		return nil, err
+	}
+	l.t.t.metrics.connectionEstablished(conn.ConnState(), network.DirInbound, time.Since(start))
+	return conn, nil
+}
+
+func (l *privateListener) Accept() (tpt.CapableConn, error) {
+	select {
+	case <-l.ctx.Done():
+		return nil, tpt.ErrListenerClosed
+	case conn := <-l.acceptQueue:
+		return conn, nil
+	}
+}
+
+func (l *privateListener) Close() error {
+	l.t.removeListener(l)
+	l.cancel()
+	l.wg.Wait()
+	return nil
+}
+
+func (l *privateListener) Addr() net.Addr {
+	return privateNetAddr{}
+}
+
+func (l *privateListener) Multiaddr() ma.Multiaddr {
+	return privateListenAddr
+}
+
+// privateNetAddr is the net.Addr of the private listener, which doesn't
+// listen on a socket.
+type privateNetAddr struct{}
+
+func (privateNetAddr) Network() string { return "webrtc" }
+func (privateNetAddr) String() string  { return "webrtc" }
+
+// isCircuitAddr returns true for relay addresses that are dialed using the
+// relay transport, i.e. without /webrtc.
+func isCircuitAddr(a ma.Multiaddr) bool {
+	_, last := ma.SplitLast(a)
+	return last != nil && last.Protocol().Code == ma.P_CIRCUIT
+}
@@ -0,0 +1,288 @@
+//go:build !js
+
+package libp2pwebrtc
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/pion/webrtc/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func newPrivateTestHost(t *testing.T, opts ...libp2p.Option) host.Host {
+	t.Helper()
+	opts = append([]libp2p.Option{
+		libp2p.NoTransports,
+		libp2p.Transport(tcp.NewTCPTransport),
+		libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+	}, opts...)
+	h, err := libp2p.New(opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+// connectPrivate connects dialer to listener via relay, and establishes a
+// private-to-private connection.
+func connectPrivate(t *testing.T, ctx context.Context, relay, listener, dialer host.Host) (network.Conn, *PrivateTransport, *PrivateTransport) {
+	t.Helper()
+	relayInfo := peer.AddrInfo{ID: relay.ID(), Addrs: relay.Addrs()}
+	require.NoError(t, listener.Connect(ctx, relayInfo))
+	_, err := client.Reserve(ctx, listener, relayInfo)
+	require.NoError(t, err)
+
+	reg := prometheus.NewRegistry()
+	ltr, err := AddPrivateTransport(listener, WithMetricsRegisterer(reg))
+	require.NoError(t, err)
+	dtr, err := AddPrivateTransport(dialer, WithMetricsRegisterer(reg))
+	require.NoError(t, err)
+	require.Contains(t, listener.Network().ListenAddresses(), privateListenAddr)
+
+	raddr := ma.Join(
+		relay.Addrs()[0],
+		ma.StringCast("/p2p/"+relay.ID().String()+"/p2p-circuit/webrtc"),
+	)
+	dialer.Peerstore().AddAddr(listener.ID(), raddr, peerstore.TempAddrTTL)
+	// Only dial the /webrtc address.
+	dctx := network.WithDialAddrFilter(ctx, func(a ma.Multiaddr) bool { return a.Equal(raddr) }, "test")
+	c, err := dialer.Network().DialPeer(dctx, listener.ID())
+	require.NoError(t, err)
+	return c, ltr, dtr
+}
+
+func TestPrivateToPrivate(t *testing.T) {
+	relay := newPrivateTestHost(t, libp2p.EnableRelayService(), libp2p.ForceReachabilityPublic())
+	listener := newPrivateTestHost(t, libp2p.EnableRelay())
+	dialer := newPrivateTestHost(t, libp2p.EnableRelay())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	const proto = "/echo"
+	listener.SetStreamHandler(proto, func(s network.Stream) {
+		defer s.Close()
+		io.Copy(s, s)
+	})
+
+	c, _, _ := connectPrivate(t, ctx, relay, listener, dialer)
+	require.Equal(t, "webrtc", c.ConnState().Transport)
+	require.False(t, c.Stat().Transient)
+	_, err := c.RemoteMultiaddr().ValueForProtocol(ma.P_CIRCUIT)
+	require.Error(t, err, "expected a direct connection, got %s", c.RemoteMultiaddr())
+	require.Equal(t, listener.ID(), c.RemotePeer())
+
+	s, err := dialer.NewStream(ctx, listener.ID(), proto)
+	require.NoError(t, err)
+	require.Equal(t, "webrtc", s.Conn().ConnState().Transport)
+	_, err = s.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, s.CloseWrite())
+	b, err := io.ReadAll(s)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+
+	require.Eventually(t, func() bool {
+		for _, c := range listener.Network().ConnsToPeer(dialer.ID()) {
+			if c.ConnState().Transport == "webrtc" && c.Stat().Direction == network.DirInbound {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+// removeSelectedAddr emits an EvtLocalInterfaceAddrsChanged event on the bus
+// of h, removing the local address used by the only connection of tr.
+func removeSelectedAddr(t *testing.T, h host.Host, tr *PrivateTransport) *connection {
+	t.Helper()
+	tr.mx.Lock()
+	require.Len(t, tr.conns, 1)
+	c := tr.conns[0]
+	tr.mx.Unlock()
+	cp, err := c.pc.SCTP().Transport().ICETransport().GetSelectedCandidatePair()
+	require.NoError(t, err)
+	ip := cp.Local.Address
+	if cp.Local.Typ != webrtc.ICECandidateTypeHost {
+		ip = cp.Local.RelatedAddress
+	}
+	addr, err := manet.FromIP(net.ParseIP(ip))
+	require.NoError(t, err)
+
+	em, err := h.EventBus().Emitter(new(event.EvtLocalInterfaceAddrsChanged))
+	require.NoError(t, err)
+	defer em.Close()
+	require.NoError(t, em.Emit(event.EvtLocalInterfaceAddrsChanged{Removed: []ma.Multiaddr{addr}}))
+	return c
+}
+
+func iceUfrag(t *testing.T, pc *webrtc.PeerConnection) string {
+	t.Helper()
+	desc := pc.CurrentLocalDescription()
+	require.NotNil(t, desc)
+	for _, l := range strings.Split(desc.SDP, "\r\n") {
+		if ufrag, ok := strings.CutPrefix(l, "a=ice-ufrag:"); ok {
+			return ufrag
+		}
+	}
+	t.Fatal("no ice-ufrag in local description")
+	return ""
+}
+
+func TestICERestart(t *testing.T) {
+	relay := newPrivateTestHost(t, libp2p.EnableRelayService(), libp2p.ForceReachabilityPublic())
+	listener := newPrivateTestHost(t, libp2p.EnableRelay())
+	dialer := newPrivateTestHost(t, libp2p.EnableRelay())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	const proto = "/echo"
+	listener.SetStreamHandler(proto, func(s network.Stream) {
+		defer s.Close()
+		io.Copy(s, s)
+	})
+	nc, _, dtr := connectPrivate(t, ctx, relay, listener, dialer)
+	s, err := dialer.NewStream(ctx, listener.ID(), proto)
+	require.NoError(t, err)
+	require.Equal(t, nc, s.Conn())
+	_, err = s.Write([]byte("hello"))
+	require.NoError(t, err)
+	b := make([]byte, 5)
+	_, err = io.ReadFull(s, b)
+	require.NoError(t, err)
+
+	restarts := testutil.ToFloat64(iceRestarts.WithLabelValues("success"))
+	c := removeSelectedAddr(t, dialer, dtr)
+	ufrag := iceUfrag(t, c.pc)
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(iceRestarts.WithLabelValues("success")) == restarts+1
+	}, 15*time.Second, 50*time.Millisecond)
+	require.False(t, c.IsClosed())
+	require.NotEqual(t, ufrag, iceUfrag(t, c.pc))
+
+	// the stream survived the restart
+	_, err = s.Write([]byte("world"))
+	require.NoError(t, err)
+	require.NoError(t, s.CloseWrite())
+	b, err = io.ReadAll(s)
+	require.NoError(t, err)
+	require.Equal(t, "world", string(b))
+}
+
+func TestICERestartGlare(t *testing.T) {
+	relay := newPrivateTestHost(t, libp2p.EnableRelayService(), libp2p.ForceReachabilityPublic())
+	listener := newPrivateTestHost(t, libp2p.EnableRelay())
+	dialer := newPrivateTestHost(t, libp2p.EnableRelay())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	const proto = "/echo"
+	listener.SetStreamHandler(proto, func(s network.Stream) {
+		defer s.Close()
+		io.Copy(s, s)
+	})
+	_, ltr, dtr := connectPrivate(t, ctx, relay, listener, dialer)
+	require.Eventually(t, func() bool {
+		ltr.mx.Lock()
+		defer ltr.mx.Unlock()
+		return len(ltr.conns) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Both peers restart ICE at the same time. Only one of the restarts
+	// succeeds, the other peer answers it.
+	ltr.mx.Lock()
+	lc := ltr.conns[0]
+	ltr.mx.Unlock()
+	dtr.mx.Lock()
+	dc := dtr.conns[0]
+	dtr.mx.Unlock()
+	restarts := testutil.ToFloat64(iceRestarts.WithLabelValues("success"))
+	go ltr.restartICE(lc)
+	go dtr.restartICE(dc)
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(iceRestarts.WithLabelValues("success")) == restarts+1
+	}, 15*time.Second, 50*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return lc.pc.SignalingState() == webrtc.SignalingStateStable && dc.pc.SignalingState() == webrtc.SignalingStateStable &&
+			lc.pc.ICEConnectionState() == webrtc.ICEConnectionStateConnected && dc.pc.ICEConnectionState() == webrtc.ICEConnectionStateConnected
+	}, 15*time.Second, 50*time.Millisecond)
+	require.False(t, lc.IsClosed())
+	require.False(t, dc.IsClosed())
+
+	s, err := dialer.NewStream(ctx, listener.ID(), proto)
+	require.NoError(t, err)
+	_, err = s.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, s.CloseWrite())
+	b, err := io.ReadAll(s)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+}
+
+func TestICERestartFailure(t *testing.T) {
+	relay := newPrivateTestHost(t, libp2p.EnableRelayService(), libp2p.ForceReachabilityPublic())
+	listener := newPrivateTestHost(t, libp2p.EnableRelay())
+	dialer := newPrivateTestHost(t, libp2p.EnableRelay())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, _, dtr := connectPrivate(t, ctx, relay, listener, dialer)
+	sub, err := dialer.EventBus().Subscribe(new(event.EvtPeerConnectednessChanged))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	// Without the relay, the restart can't be signaled.
+	require.NoError(t, relay.Close())
+	require.Eventually(t, func() bool {
+		return len(dialer.Network().ConnsToPeer(listener.ID())) == 1
+	}, 5*time.Second, 50*time.Millisecond)
+
+	failures := testutil.ToFloat64(iceRestarts.WithLabelValues("failure"))
+	c := removeSelectedAddr(t, dialer, dtr)
+	for {
+		select {
+		case e := <-sub.Out():
+			evt := e.(event.EvtPeerConnectednessChanged)
+			if evt.Peer != listener.ID() || evt.Connectedness != network.NotConnected {
+				continue
+			}
+		case <-ctx.Done():
+			t.Fatal("expected the peer to be disconnected")
+		}
+		break
+	}
+	require.True(t, c.IsClosed())
+	require.Equal(t, failures+1, testutil.ToFloat64(iceRestarts.WithLabelValues("failure")))
+}
+
+func TestPrivateTransportCanDial(t *testing.T) {
+	tr := &PrivateTransport{}
+	for addr, ok := range map[string]bool{
+		"/ip4/1.2.3.4/tcp/1/p2p/12D3KooWGPWBWNUDGYtpwQNHhmvrRj4ER7Ua5d7eMN89o9AuW4PG/p2p-circuit/webrtc": true,
+		"/ip4/1.2.3.4/tcp/1/p2p/12D3KooWGPWBWNUDGYtpwQNHhmvrRj4ER7Ua5d7eMN89o9AuW4PG/p2p-circuit":        false,
+		"/ip4/1.2.3.4/udp/1/webrtc":        false,
+		"/ip4/1.2.3.4/udp/1/webrtc-direct": false,
+	} {
+		require.Equal(t, ok, tr.CanDial(ma.StringCast(addr)), addr)
+	}
+}
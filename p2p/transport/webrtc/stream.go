@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/panics"
 	"github.com/libp2p/go-libp2p/p2p/transport/webrtc/pb"
 	"github.com/libp2p/go-msgio/pbio"
 
@@ -17,13 +18,12 @@ import (
 const (
 	// maxMessageSize is the maximum message size of the Protobuf message we send / receive.
 	maxMessageSize = 16384
-	// maxSendBuffer is the maximum data we enqueue on the underlying data channel for writes.
-	// The underlying SCTP layer has an unbounded buffer for writes. We limit the amount enqueued
-	// per stream is limited to avoid a single stream monopolizing the entire connection.
-	maxSendBuffer = 2 * maxMessageSize
-	// sendBufferLowThreshold is the threshold below which we write more data on the underlying
-	// data channel. We want a notification as soon as we can write 1 full sized message.
-	sendBufferLowThreshold = maxSendBuffer - maxMessageSize
+	// defaultMaxSendBuffer is the default maximum data we enqueue on the underlying data channel
+	// for writes, see streamConfig.maxSendBuffer.
+	defaultMaxSendBuffer = 2 * maxMessageSize
+	// defaultMaxConnBufferedAmount is the default maximum data we enqueue on all the data
+	// channels of a connection, see writeScheduler.
+	defaultMaxConnBufferedAmount = 4 * defaultMaxSendBuffer
 	// maxTotalControlMessagesSize is the maximum total size of all control messages we will
 	// write on this stream.
 	// 4 control messages of size 10 bytes + 10 bytes buffer. This number doesn't need to be
@@ -45,6 +45,28 @@ const (
 	maxFINACKWait = 10 * time.Second
 )
 
+// streamConfig configures how much data a stream sends at once.
+type streamConfig struct {
+	// maxSendBuffer is the maximum data we enqueue on the underlying data channel for writes.
+	// The underlying SCTP layer has an unbounded buffer for writes. We limit the amount enqueued
+	// per stream to avoid a single stream monopolizing the entire connection.
+	maxSendBuffer int
+	// messageChunkSize is the maximum size of the messages we send. It can't be larger than
+	// maxMessageSize, since the remote peer rejects larger messages.
+	messageChunkSize int
+}
+
+var defaultStreamConfig = streamConfig{
+	maxSendBuffer:    defaultMaxSendBuffer,
+	messageChunkSize: maxMessageSize,
+}
+
+// sendBufferLowThreshold is the threshold below which we write more data on the underlying
+// data channel. We want a notification as soon as we can write 1 full sized message.
+func (c streamConfig) sendBufferLowThreshold() int {
+	return c.maxSendBuffer - c.messageChunkSize
+}
+
 type receiveState uint8
 
 const (
@@ -79,6 +101,9 @@ type stream struct {
 	receiveState receiveState
 
 	writer            pbio.Writer // concurrent writes prevented by mx
+	config            streamConfig
+	scheduler         *writeScheduler // nil for streams that don't share a connection
+	metrics           *metricsTracer
 	writeStateChanged chan struct{}
 	sendState         sendState
 	writeDeadline     time.Time
@@ -103,19 +128,26 @@ var _ network.MuxedStream = &stream{}
 func newStream(
 	channel *webrtc.DataChannel,
 	rwc datachannel.ReadWriteCloser,
+	config streamConfig,
+	scheduler *writeScheduler,
 	onDone func(),
 ) *stream {
 	s := &stream{
 		reader:            pbio.NewDelimitedReader(rwc, maxMessageSize),
 		writer:            pbio.NewDelimitedWriter(rwc),
+		config:            config,
+		scheduler:         scheduler,
 		writeStateChanged: make(chan struct{}, 1),
 		id:                *channel.ID(),
 		dataChannel:       rwc.(*datachannel.DataChannel),
 		onDone:            onDone,
 	}
-	s.dataChannel.SetBufferedAmountLowThreshold(sendBufferLowThreshold)
+	s.dataChannel.SetBufferedAmountLowThreshold(uint64(config.sendBufferLowThreshold()))
 	s.dataChannel.OnBufferedAmountLow(func() {
 		s.notifyWriteStateChanged()
+		if s.scheduler != nil {
+			s.scheduler.notify()
+		}
 
 	})
 	return s
@@ -216,6 +248,10 @@ func (s *stream) spawnControlMessageReader() {
 	s.controlMessageReaderOnce.Do(func() {
 		// Spawn a goroutine to ensure that we're not holding any locks
 		go func() {
+			// A panic only tears down this stream. This runs after the deferred
+			// calls below, which close the data channel.
+			defer panics.Recover("webrtc", "", s.cleanup)
+
 			// cleanup the sctp deadline timer goroutine
 			defer s.setDataChannelReadDeadline(time.Time{})
 
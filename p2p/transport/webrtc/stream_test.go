@@ -1,3 +1,5 @@
+//go:build !js
+
 package libp2pwebrtc
 
 import (
@@ -14,6 +16,7 @@ import (
 	"google.golang.org/protobuf/proto"
 
 	"github.com/libp2p/go-libp2p/core/network"
+	tmux "github.com/libp2p/go-libp2p/p2p/muxer/testsuite"
 
 	"github.com/pion/datachannel"
 	"github.com/pion/sctp"
@@ -148,8 +151,8 @@ func TestStreamSimpleReadWriteClose(t *testing.T) {
 	client, server := getDetachedDataChannels(t)
 
 	var clientDone, serverDone atomic.Bool
-	clientStr := newStream(client.dc, client.rwc, func() { clientDone.Store(true) })
-	serverStr := newStream(server.dc, server.rwc, func() { serverDone.Store(true) })
+	clientStr := newStream(client.dc, client.rwc, defaultStreamConfig, nil, func() { clientDone.Store(true) })
+	serverStr := newStream(server.dc, server.rwc, defaultStreamConfig, nil, func() { serverDone.Store(true) })
 
 	// send a foobar from the client
 	n, err := clientStr.Write([]byte("foobar"))
@@ -194,8 +197,8 @@ func TestStreamSimpleReadWriteClose(t *testing.T) {
 func TestStreamPartialReads(t *testing.T) {
 	client, server := getDetachedDataChannels(t)
 
-	clientStr := newStream(client.dc, client.rwc, func() {})
-	serverStr := newStream(server.dc, server.rwc, func() {})
+	clientStr := newStream(client.dc, client.rwc, defaultStreamConfig, nil, func() {})
+	serverStr := newStream(server.dc, server.rwc, defaultStreamConfig, nil, func() {})
 
 	_, err := serverStr.Write([]byte("foobar"))
 	require.NoError(t, err)
@@ -217,8 +220,8 @@ func TestStreamPartialReads(t *testing.T) {
 func TestStreamSkipEmptyFrames(t *testing.T) {
 	client, server := getDetachedDataChannels(t)
 
-	clientStr := newStream(client.dc, client.rwc, func() {})
-	serverStr := newStream(server.dc, server.rwc, func() {})
+	clientStr := newStream(client.dc, client.rwc, defaultStreamConfig, nil, func() {})
+	serverStr := newStream(server.dc, server.rwc, defaultStreamConfig, nil, func() {})
 
 	for i := 0; i < 10; i++ {
 		require.NoError(t, serverStr.writer.WriteMsg(&pb.Message{}))
@@ -252,7 +255,7 @@ func TestStreamSkipEmptyFrames(t *testing.T) {
 func TestStreamReadReturnsOnClose(t *testing.T) {
 	client, _ := getDetachedDataChannels(t)
 
-	clientStr := newStream(client.dc, client.rwc, func() {})
+	clientStr := newStream(client.dc, client.rwc, defaultStreamConfig, nil, func() {})
 	errChan := make(chan error, 1)
 	go func() {
 		_, err := clientStr.Read([]byte{0})
@@ -275,8 +278,8 @@ func TestStreamResets(t *testing.T) {
 	client, server := getDetachedDataChannels(t)
 
 	var clientDone, serverDone atomic.Bool
-	clientStr := newStream(client.dc, client.rwc, func() { clientDone.Store(true) })
-	serverStr := newStream(server.dc, server.rwc, func() { serverDone.Store(true) })
+	clientStr := newStream(client.dc, client.rwc, defaultStreamConfig, nil, func() { clientDone.Store(true) })
+	serverStr := newStream(server.dc, server.rwc, defaultStreamConfig, nil, func() { serverDone.Store(true) })
 
 	// send a foobar from the client
 	_, err := clientStr.Write([]byte("foobar"))
@@ -311,8 +314,8 @@ func TestStreamResets(t *testing.T) {
 func TestStreamReadDeadlineAsync(t *testing.T) {
 	client, server := getDetachedDataChannels(t)
 
-	clientStr := newStream(client.dc, client.rwc, func() {})
-	serverStr := newStream(server.dc, server.rwc, func() {})
+	clientStr := newStream(client.dc, client.rwc, defaultStreamConfig, nil, func() {})
+	serverStr := newStream(server.dc, server.rwc, defaultStreamConfig, nil, func() {})
 
 	timeout := 100 * time.Millisecond
 	if os.Getenv("CI") != "" {
@@ -342,8 +345,8 @@ func TestStreamReadDeadlineAsync(t *testing.T) {
 func TestStreamWriteDeadlineAsync(t *testing.T) {
 	client, server := getDetachedDataChannels(t)
 
-	clientStr := newStream(client.dc, client.rwc, func() {})
-	serverStr := newStream(server.dc, server.rwc, func() {})
+	clientStr := newStream(client.dc, client.rwc, defaultStreamConfig, nil, func() {})
+	serverStr := newStream(server.dc, server.rwc, defaultStreamConfig, nil, func() {})
 	_ = serverStr
 
 	b := make([]byte, 1024)
@@ -372,8 +375,8 @@ func TestStreamWriteDeadlineAsync(t *testing.T) {
 func TestStreamReadAfterClose(t *testing.T) {
 	client, server := getDetachedDataChannels(t)
 
-	clientStr := newStream(client.dc, client.rwc, func() {})
-	serverStr := newStream(server.dc, server.rwc, func() {})
+	clientStr := newStream(client.dc, client.rwc, defaultStreamConfig, nil, func() {})
+	serverStr := newStream(server.dc, server.rwc, defaultStreamConfig, nil, func() {})
 
 	serverStr.Close()
 	b := make([]byte, 1)
@@ -384,8 +387,8 @@ func TestStreamReadAfterClose(t *testing.T) {
 
 	client, server = getDetachedDataChannels(t)
 
-	clientStr = newStream(client.dc, client.rwc, func() {})
-	serverStr = newStream(server.dc, server.rwc, func() {})
+	clientStr = newStream(client.dc, client.rwc, defaultStreamConfig, nil, func() {})
+	serverStr = newStream(server.dc, server.rwc, defaultStreamConfig, nil, func() {})
 
 	serverStr.Reset()
 	b = make([]byte, 1)
@@ -399,8 +402,8 @@ func TestStreamCloseAfterFINACK(t *testing.T) {
 	client, server := getDetachedDataChannels(t)
 
 	done := make(chan bool, 1)
-	clientStr := newStream(client.dc, client.rwc, func() { done <- true })
-	serverStr := newStream(server.dc, server.rwc, func() {})
+	clientStr := newStream(client.dc, client.rwc, defaultStreamConfig, nil, func() { done <- true })
+	serverStr := newStream(server.dc, server.rwc, defaultStreamConfig, nil, func() {})
 
 	go func() {
 		err := clientStr.Close()
@@ -427,8 +430,8 @@ func TestStreamFinAckAfterStopSending(t *testing.T) {
 	client, server := getDetachedDataChannels(t)
 
 	done := make(chan bool, 1)
-	clientStr := newStream(client.dc, client.rwc, func() { done <- true })
-	serverStr := newStream(server.dc, server.rwc, func() {})
+	clientStr := newStream(client.dc, client.rwc, defaultStreamConfig, nil, func() { done <- true })
+	serverStr := newStream(server.dc, server.rwc, defaultStreamConfig, nil, func() {})
 
 	go func() {
 		clientStr.CloseRead()
@@ -460,8 +463,8 @@ func TestStreamConcurrentClose(t *testing.T) {
 
 	start := make(chan bool, 2)
 	done := make(chan bool, 2)
-	clientStr := newStream(client.dc, client.rwc, func() { done <- true })
-	serverStr := newStream(server.dc, server.rwc, func() { done <- true })
+	clientStr := newStream(client.dc, client.rwc, defaultStreamConfig, nil, func() { done <- true })
+	serverStr := newStream(server.dc, server.rwc, defaultStreamConfig, nil, func() { done <- true })
 
 	go func() {
 		start <- true
@@ -495,7 +498,7 @@ func TestStreamResetAfterClose(t *testing.T) {
 	client, server := getDetachedDataChannels(t)
 
 	done := make(chan bool, 2)
-	clientStr := newStream(client.dc, client.rwc, func() { done <- true })
+	clientStr := newStream(client.dc, client.rwc, defaultStreamConfig, nil, func() { done <- true })
 	clientStr.Close()
 
 	select {
@@ -520,7 +523,7 @@ func TestStreamDataChannelCloseOnFINACK(t *testing.T) {
 	client, server := getDetachedDataChannels(t)
 
 	done := make(chan bool, 1)
-	clientStr := newStream(client.dc, client.rwc, func() { done <- true })
+	clientStr := newStream(client.dc, client.rwc, defaultStreamConfig, nil, func() { done <- true })
 
 	clientStr.Close()
 
@@ -542,8 +545,8 @@ func TestStreamDataChannelCloseOnFINACK(t *testing.T) {
 func TestStreamChunking(t *testing.T) {
 	client, server := getDetachedDataChannels(t)
 
-	clientStr := newStream(client.dc, client.rwc, func() {})
-	serverStr := newStream(server.dc, server.rwc, func() {})
+	clientStr := newStream(client.dc, client.rwc, defaultStreamConfig, nil, func() {})
+	serverStr := newStream(server.dc, server.rwc, defaultStreamConfig, nil, func() {})
 
 	const N = (16 << 10) + 1000
 	go func() {
@@ -561,3 +564,35 @@ func TestStreamChunking(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, nn+n, N)
 }
+
+func TestStreamConfigChunking(t *testing.T) {
+	client, server := getDetachedDataChannels(t)
+
+	const chunkSize = 4 << 10
+	config := streamConfig{maxSendBuffer: 64 << 10, messageChunkSize: chunkSize}
+	clientStr := newStream(client.dc, client.rwc, config, nil, func() {})
+	serverStr := newStream(server.dc, server.rwc, defaultStreamConfig, nil, func() {})
+
+	const N = 3*chunkSize + 100
+	go func() {
+		_, err := clientStr.Write(make([]byte, N))
+		require.NoError(t, err)
+	}()
+
+	data := make([]byte, N)
+	var total int
+	for total < N {
+		n, err := serverStr.Read(data)
+		require.NoError(t, err)
+		require.LessOrEqual(t, n, chunkSize-protoOverhead-varintOverhead)
+		total += n
+	}
+	require.Equal(t, N, total)
+}
+
+func TestStreamConformance(t *testing.T) {
+	tmux.SubtestStreamAll(t, func(t *testing.T) (network.MuxedStream, network.MuxedStream) {
+		client, server := getDetachedDataChannels(t)
+		return newStream(client.dc, client.rwc, defaultStreamConfig, nil, func() {}), newStream(server.dc, server.rwc, defaultStreamConfig, nil, func() {})
+	})
+}
@@ -40,6 +40,33 @@ func (s *stream) Write(b []byte) (int, error) {
 		}
 	}()
 
+	// turn is our turn to write on the connection, see writeScheduler. We
+	// keep our place in the queue while waiting for state changes.
+	var turn *writeTurn
+	defer func() {
+		if turn != nil {
+			s.scheduler.release(turn)
+		}
+	}()
+
+	// stalled is the time we were blocked waiting for space in the send
+	// buffer, or for our turn to write
+	var stalled time.Duration
+	var stallStart time.Time
+	stall := func() {
+		if stallStart.IsZero() {
+			stallStart = time.Now()
+		}
+	}
+	defer func() {
+		if !stallStart.IsZero() {
+			stalled += time.Since(stallStart)
+		}
+		if stalled > 0 {
+			s.metrics.writeStalled(stalled)
+		}
+	}()
+
 	var n int
 	var msg pb.Message
 	for len(b) > 0 {
@@ -74,6 +101,7 @@ func (s *stream) Write(b []byte) (int, error) {
 
 		availableSpace := s.availableSendSpace()
 		if availableSpace < minMessageSize {
+			stall()
 			s.mx.Unlock()
 			select {
 			case <-writeDeadlineChan:
@@ -84,7 +112,36 @@ func (s *stream) Write(b []byte) (int, error) {
 			s.mx.Lock()
 			continue
 		}
-		end := maxMessageSize
+		if s.scheduler != nil {
+			if turn == nil {
+				turn = s.scheduler.enqueue()
+			}
+			select {
+			case <-turn.ready:
+			default:
+				stall()
+			}
+			s.mx.Unlock()
+			select {
+			case <-writeDeadlineChan:
+				s.mx.Lock()
+				return n, os.ErrDeadlineExceeded
+			case <-s.writeStateChanged:
+				s.mx.Lock()
+				continue
+			case <-turn.ready:
+			}
+			s.mx.Lock()
+			if s.closeForShutdownErr != nil || s.sendState != sendStateSending {
+				continue
+			}
+			availableSpace = min(s.availableSendSpace(), turn.space)
+		}
+		if !stallStart.IsZero() {
+			stalled += time.Since(stallStart)
+			stallStart = time.Time{}
+		}
+		end := s.config.messageChunkSize
 		if end > availableSpace {
 			end = availableSpace
 		}
@@ -93,7 +150,12 @@ func (s *stream) Write(b []byte) (int, error) {
 			end = len(b)
 		}
 		msg = pb.Message{Message: b[:end]}
-		if err := s.writer.WriteMsg(&msg); err != nil {
+		err := s.writer.WriteMsg(&msg)
+		if turn != nil {
+			s.scheduler.release(turn)
+			turn = nil
+		}
+		if err != nil {
 			return n, err
 		}
 		n += end
@@ -112,9 +174,9 @@ func (s *stream) SetWriteDeadline(t time.Time) error {
 
 func (s *stream) availableSendSpace() int {
 	buffered := int(s.dataChannel.BufferedAmount())
-	availableSpace := maxSendBuffer - buffered
+	availableSpace := s.config.maxSendBuffer - buffered
 	if availableSpace+maxTotalControlMessagesSize < 0 { // this should never happen, but better check
-		log.Errorw("data channel buffered more data than the maximum amount", "max", maxSendBuffer, "buffered", buffered)
+		log.Errorw("data channel buffered more data than the maximum amount", "max", s.config.maxSendBuffer, "buffered", buffered)
 	}
 	return availableSpace
 }
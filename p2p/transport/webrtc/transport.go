@@ -1,3 +1,5 @@
+//go:build !js
+
 // Package libp2pwebrtc implements the WebRTC transport for go-libp2p,
 // as described in https://github.com/libp2p/specs/tree/master/webrtc.
 //
@@ -35,6 +37,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/pnet"
 	"github.com/libp2p/go-libp2p/core/sec"
 	tpt "github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/security/membership"
 	"github.com/libp2p/go-libp2p/p2p/security/noise"
 	"github.com/libp2p/go-libp2p/p2p/transport/webrtc/pb"
 	"github.com/libp2p/go-msgio"
@@ -45,6 +48,7 @@ import (
 
 	"github.com/pion/datachannel"
 	"github.com/pion/webrtc/v3"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var webrtcComponent *ma.Component
@@ -87,18 +91,141 @@ type WebRTCTransport struct {
 	privKey      ic.PrivKey
 	noiseTpt     *noise.Transport
 	localPeerId  peer.ID
+	membership   *membership.Membership
 
 	// timeouts
 	peerConnectionTimeouts iceTimeouts
 
 	// in-flight connections
 	maxInFlightConnections uint32
+
+	// ICE servers used when dialing
+	iceServers      []webrtc.ICEServer
+	turnCredentials *turnCredentials
+
+	streamConfig streamConfig
+	// maxConnBufferedAmount is the maximum data enqueued on all the data channels
+	// of a connection, see writeScheduler. 0 means the default.
+	maxConnBufferedAmount int
+
+	enableMetrics     bool
+	metricsRegisterer prometheus.Registerer
+	metrics           *metricsTracer
 }
 
 var _ tpt.Transport = &WebRTCTransport{}
 
 type Option func(*WebRTCTransport) error
 
+// WithICEServers sets STUN and TURN servers that are used when dialing, and
+// by both sides of private-to-private connections (see PrivateTransport). TURN
+// servers allow connecting to peers that can't be reached directly, e.g.
+// from behind a symmetric NAT, via a relay candidate. TURN servers need a
+// Username and a Credential: a string for the default password credential
+// type, or a webrtc.OAuthCredential.
+func WithICEServers(servers ...webrtc.ICEServer) Option {
+	return func(t *WebRTCTransport) error {
+		for _, s := range servers {
+			if err := validateICEServer(s); err != nil {
+				return err
+			}
+		}
+		t.iceServers = append(t.iceServers, servers...)
+		return nil
+	}
+}
+
+// WithTURNCredentials sets a function that fetches ephemeral TURN credentials,
+// e.g. from the REST API of coturn. The credentials are cached, and refreshed
+// before they expire. The TURN servers are used in addition to the servers set
+// using WithICEServers. If no valid credentials can be fetched, dials proceed
+// without them.
+func WithTURNCredentials(fetch TURNCredentialsFunc) Option {
+	return func(t *WebRTCTransport) error {
+		if fetch == nil {
+			return errors.New("TURN credentials function must not be nil")
+		}
+		t.turnCredentials = newTURNCredentials(fetch)
+		return nil
+	}
+}
+
+// WithMaxBufferedAmount sets the maximum number of bytes a stream enqueues on
+// its data channel before writes block. Larger values allow for a higher
+// throughput per stream on links with a large bandwidth-delay product, at the
+// cost of memory, and of streams competing for the connection. It must be at
+// least the message chunk size. Defaults to 32 KiB.
+func WithMaxBufferedAmount(n int) Option {
+	return func(t *WebRTCTransport) error {
+		if n < minMessageSize {
+			return fmt.Errorf("max buffered amount must be at least %d bytes", minMessageSize)
+		}
+		t.streamConfig.maxSendBuffer = n
+		return nil
+	}
+}
+
+// WithMaxConnectionBufferedAmount sets the maximum number of bytes enqueued on
+// all the data channels of a connection. Streams writing on a connection take
+// turns, so that a busy stream can't delay the messages of the other streams
+// by more than this amount. It must be at least the maximum buffered amount of
+// a stream, see WithMaxBufferedAmount. Defaults to 128 KiB, or the maximum
+// buffered amount of a stream, if that is larger.
+func WithMaxConnectionBufferedAmount(n int) Option {
+	return func(t *WebRTCTransport) error {
+		if n < minMessageSize {
+			return fmt.Errorf("max connection buffered amount must be at least %d bytes", minMessageSize)
+		}
+		t.maxConnBufferedAmount = n
+		return nil
+	}
+}
+
+// WithMessageChunkSize sets the maximum size of the messages writes are split
+// into. It can't be larger than 16 KiB, the maximum message size peers accept,
+// which is also the default.
+func WithMessageChunkSize(n int) Option {
+	return func(t *WebRTCTransport) error {
+		if n < minMessageSize || n > maxMessageSize {
+			return fmt.Errorf("message chunk size must be between %d and %d bytes", minMessageSize, maxMessageSize)
+		}
+		t.streamConfig.messageChunkSize = n
+		return nil
+	}
+}
+
+// WithMetrics enables Prometheus metrics, registered with the default
+// registerer.
+func WithMetrics() Option {
+	return func(t *WebRTCTransport) error {
+		t.enableMetrics = true
+		return nil
+	}
+}
+
+// WithMetricsRegisterer enables Prometheus metrics, and registers them with
+// reg instead of the default registerer.
+func WithMetricsRegisterer(reg prometheus.Registerer) Option {
+	return func(t *WebRTCTransport) error {
+		if reg == nil {
+			return errors.New("registerer cannot be nil")
+		}
+		t.enableMetrics = true
+		t.metricsRegisterer = reg
+		return nil
+	}
+}
+
+// WithMembership requires remote peers to present a certificate of the
+// network of m in the Noise handshake, like the WithMembership option of the
+// Noise security transport.
+func WithMembership(m *membership.Membership) Option {
+	return func(t *WebRTCTransport) error {
+		t.membership = m
+		return nil
+	}
+}
+
 type iceTimeouts struct {
 	Disconnect time.Duration
 	Failed     time.Duration
@@ -139,16 +266,11 @@ func New(privKey ic.PrivKey, psk pnet.PSK, gater connmgr.ConnectionGater, rcmgr
 	config := webrtc.Configuration{
 		Certificates: []webrtc.Certificate{*cert},
 	}
-	noiseTpt, err := noise.New(noise.ID, privKey, nil)
-	if err != nil {
-		return nil, fmt.Errorf("unable to create noise transport: %w", err)
-	}
 	transport := &WebRTCTransport{
 		rcmgr:        rcmgr,
 		gater:        gater,
 		webrtcConfig: config,
 		privKey:      privKey,
-		noiseTpt:     noiseTpt,
 		localPeerId:  localPeerID,
 
 		peerConnectionTimeouts: iceTimeouts{
@@ -158,12 +280,36 @@ func New(privKey ic.PrivKey, psk pnet.PSK, gater connmgr.ConnectionGater, rcmgr
 		},
 
 		maxInFlightConnections: DefaultMaxInFlightConnections,
+		streamConfig:           defaultStreamConfig,
 	}
 	for _, opt := range opts {
 		if err := opt(transport); err != nil {
 			return nil, err
 		}
 	}
+	var noiseOpts []noise.Option
+	if transport.membership != nil {
+		noiseOpts = append(noiseOpts, noise.WithMembership(transport.membership))
+	}
+	transport.noiseTpt, err = noise.New(noise.ID, privKey, nil, noiseOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create noise transport: %w", err)
+	}
+	if c := transport.streamConfig; c.maxSendBuffer < c.messageChunkSize {
+		return nil, fmt.Errorf("max buffered amount (%d bytes) is smaller than the message chunk size (%d bytes)", c.maxSendBuffer, c.messageChunkSize)
+	}
+	if transport.maxConnBufferedAmount == 0 {
+		transport.maxConnBufferedAmount = max(defaultMaxConnBufferedAmount, transport.streamConfig.maxSendBuffer)
+	}
+	if transport.maxConnBufferedAmount < transport.streamConfig.maxSendBuffer {
+		return nil, fmt.Errorf("max connection buffered amount (%d bytes) is smaller than the max buffered amount (%d bytes)", transport.maxConnBufferedAmount, transport.streamConfig.maxSendBuffer)
+	}
+	if transport.enableMetrics {
+		if transport.metricsRegisterer == nil {
+			transport.metricsRegisterer = prometheus.DefaultRegisterer
+		}
+		transport.metrics = newMetricsTracer(transport.metricsRegisterer)
+	}
 	return transport, nil
 }
 
@@ -175,6 +321,10 @@ func (t *WebRTCTransport) Proxy() bool {
 	return false
 }
 
+func (t *WebRTCTransport) Capabilities() tpt.Capabilities {
+	return tpt.CapBrowser | tpt.CapCerthash | tpt.CapDatagrams
+}
+
 func (t *WebRTCTransport) CanDial(addr ma.Multiaddr) bool {
 	isValid, n := IsWebRTCDirectMultiaddr(addr)
 	return isValid && n > 0
@@ -252,11 +402,14 @@ func (t *WebRTCTransport) Dial(ctx context.Context, remoteMultiaddr ma.Multiaddr
 		scope.Done()
 		return nil, err
 	}
+	start := time.Now()
 	conn, err := t.dial(ctx, scope, remoteMultiaddr, p)
 	if err != nil {
+		t.metrics.connectionFailed("webrtc-direct", network.DirOutbound)
 		scope.Done()
 		return nil, err
 	}
+	t.metrics.connectionEstablished(conn.ConnState(), network.DirOutbound, time.Since(start))
 	return conn, nil
 }
 
@@ -322,7 +475,7 @@ func (t *WebRTCTransport) dial(ctx context.Context, scope network.ConnManagement
 		return nil, err
 	}
 
-	w, err = newWebRTCConnection(settingEngine, t.webrtcConfig)
+	w, err = newWebRTCConnection(settingEngine, t.dialConfig(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("instantiating peer connection failed: %w", err)
 	}
@@ -330,6 +483,11 @@ func (t *WebRTCTransport) dial(ctx context.Context, scope network.ConnManagement
 	errC := addOnConnectionStateChangeCallback(w.PeerConnection)
 
 	// do offer-answer exchange
+	//
+	// There's no signaling channel to exchange the descriptions on. The answer
+	// is derived from the multiaddr of the listener, and contains its only
+	// candidate, so the connectivity checks start as soon as the first local
+	// candidate is gathered, without waiting for gathering to complete.
 	offer, err := w.PeerConnection.CreateOffer(nil)
 	if err != nil {
 		return nil, fmt.Errorf("create offer: %w", err)
@@ -366,7 +524,7 @@ func (t *WebRTCTransport) dial(ctx context.Context, scope network.ConnManagement
 	if err != nil {
 		return nil, err
 	}
-	channel := newStream(w.HandshakeDataChannel, detached, func() {})
+	channel := newStream(w.HandshakeDataChannel, detached, defaultStreamConfig, nil, func() {})
 
 	remotePubKey, err := t.noiseHandshake(ctx, w.PeerConnection, channel, p, remoteHashFunction, false)
 	if err != nil {
@@ -410,6 +568,20 @@ func (t *WebRTCTransport) dial(ctx context.Context, scope network.ConnManagement
 	return conn, nil
 }
 
+// dialConfig returns the configuration for dialed peer connections, including
+// the ICE servers.
+func (t *WebRTCTransport) dialConfig(ctx context.Context) webrtc.Configuration {
+	config := t.webrtcConfig
+	if len(t.iceServers) == 0 && t.turnCredentials == nil {
+		return config
+	}
+	config.ICEServers = append([]webrtc.ICEServer{}, t.iceServers...)
+	if t.turnCredentials != nil {
+		config.ICEServers = append(config.ICEServers, t.turnCredentials.get(ctx)...)
+	}
+	return config
+}
+
 func genUfrag() string {
 	const (
 		uFragAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890"
@@ -617,35 +789,3 @@ func newWebRTCConnection(settings webrtc.SettingEngine, config webrtc.Configurat
 		IncomingDataChannels: incomingDataChannels,
 	}, nil
 }
-
-// IsWebRTCDirectMultiaddr returns whether addr is a /webrtc-direct multiaddr with the count of certhashes
-// in addr
-func IsWebRTCDirectMultiaddr(addr ma.Multiaddr) (bool, int) {
-	var foundUDP, foundWebRTC bool
-	certHashCount := 0
-	ma.ForEach(addr, func(c ma.Component) bool {
-		if !foundUDP {
-			if c.Protocol().Code == ma.P_UDP {
-				foundUDP = true
-			}
-			return true
-		}
-		if !foundWebRTC && foundUDP {
-			// protocol after udp must be webrtc-direct
-			if c.Protocol().Code != ma.P_WEBRTC_DIRECT {
-				return false
-			}
-			foundWebRTC = true
-			return true
-		}
-		if foundWebRTC {
-			if c.Protocol().Code == ma.P_CERTHASH {
-				certHashCount++
-			} else {
-				return false
-			}
-		}
-		return true
-	})
-	return foundUDP && foundWebRTC, certHashCount
-}
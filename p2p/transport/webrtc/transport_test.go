@@ -1,3 +1,5 @@
+//go:build !js
+
 package libp2pwebrtc
 
 import (
@@ -17,10 +19,14 @@ import (
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/security/membership"
+	ttransport "github.com/libp2p/go-libp2p/p2p/transport/testsuite"
 	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr/net"
 	"github.com/multiformats/go-multibase"
 	"github.com/multiformats/go-multihash"
+	"github.com/pion/webrtc/v3"
 	quicproxy "github.com/quic-go/quic-go/integrationtests/tools/proxy"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -40,6 +46,43 @@ func getTransport(t *testing.T, opts ...Option) (*WebRTCTransport, peer.ID) {
 	return transport, peerID
 }
 
+func TestStreamConfigOptions(t *testing.T) {
+	privKey, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+	require.NoError(t, err)
+
+	tr, err := New(privKey, nil, nil, nil, WithMaxBufferedAmount(1<<20), WithMessageChunkSize(8<<10))
+	require.NoError(t, err)
+	require.Equal(t, streamConfig{maxSendBuffer: 1 << 20, messageChunkSize: 8 << 10}, tr.streamConfig)
+
+	_, err = New(privKey, nil, nil, nil, WithMessageChunkSize(maxMessageSize+1))
+	require.Error(t, err)
+	_, err = New(privKey, nil, nil, nil, WithMaxBufferedAmount(8<<10))
+	require.ErrorContains(t, err, "smaller than the message chunk size")
+}
+
+func TestICEServersOption(t *testing.T) {
+	privKey, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+	require.NoError(t, err)
+
+	servers := []webrtc.ICEServer{
+		{URLs: []string{"stun:stun.example.com:3478"}},
+		{URLs: []string{"turn:turn.example.com:3478?transport=udp", "turns:turn.example.com:5349"}, Username: "user", Credential: "secret"},
+	}
+	tr, err := New(privKey, nil, nil, nil, WithICEServers(servers...))
+	require.NoError(t, err)
+	require.Equal(t, servers, tr.dialConfig(context.Background()).ICEServers)
+
+	for _, s := range []webrtc.ICEServer{
+		{},
+		{URLs: []string{"http://stun.example.com"}},
+		{URLs: []string{"turn:turn.example.com:3478"}},
+		{URLs: []string{"turn:turn.example.com:3478"}, Username: "user", Credential: 42},
+	} {
+		_, err := New(privKey, nil, nil, nil, WithICEServers(s))
+		require.Error(t, err, "%+v", s)
+	}
+}
+
 func TestIsWebRTCDirectMultiaddr(t *testing.T) {
 	invalid := []string{
 		"/ip4/1.2.3.4/tcp/10/",
@@ -194,6 +237,77 @@ func TestTransportWebRTC_CanListenSingle(t *testing.T) {
 	}
 }
 
+func TestTransportWebRTC_Membership(t *testing.T) {
+	authority, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	newMember := func(t *testing.T) (*WebRTCTransport, peer.ID) {
+		privKey, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+		require.NoError(t, err)
+		id, err := peer.IDFromPrivateKey(privKey)
+		require.NoError(t, err)
+		cert, err := membership.Issue(authority, &membership.Certificate{Network: "test", Peer: id, NotAfter: time.Now().Add(time.Hour)})
+		require.NoError(t, err)
+		m, err := membership.New(authority.GetPublic(), cert)
+		require.NoError(t, err)
+		tr, err := New(privKey, nil, nil, &network.NullResourceManager{}, WithMembership(m))
+		require.NoError(t, err)
+		return tr, id
+	}
+
+	tr, listeningPeer := newMember(t)
+	listener, err := tr.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/webrtc-direct"))
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	nonMember, _ := getTransport(t)
+	_, err = nonMember.Dial(ctx, listener.Multiaddr(), listeningPeer)
+	require.Error(t, err)
+
+	member, _ := newMember(t)
+	conn, err := member.Dial(ctx, listener.Multiaddr(), listeningPeer)
+	require.NoError(t, err)
+	conn.Close()
+}
+
+func TestTransportWebRTC_Suite(t *testing.T) {
+	ta, listeningPeer := getTransport(t)
+	tb, _ := getTransport(t)
+
+	// The stress tests with thousands of streams are skipped, since every
+	// stream is backed by an SCTP data channel, which makes them very slow.
+	// StreamReset and StreamOpenStress are skipped, since they expect yamux
+	// semantics for writes after a reset and for closing connections.
+	subtests := map[string]func(t *testing.T, ta, tb transport.Transport, maddr ma.Multiaddr, peerA peer.ID){
+		"Protocols":                  ttransport.SubtestProtocols,
+		"Basic":                      ttransport.SubtestBasic,
+		"Cancel":                     ttransport.SubtestCancel,
+		"PingPong":                   ttransport.SubtestPingPong,
+		"Stress1Conn1Stream1Msg":     ttransport.SubtestStress1Conn1Stream1Msg,
+		"Stress1Conn1Stream100Msg":   ttransport.SubtestStress1Conn1Stream100Msg,
+		"Stress1Conn100Stream100Msg": ttransport.SubtestStress1Conn100Stream100Msg,
+		"HandshakeFailure":           ttransport.SubtestHandshakeFailure,
+		"DuplicatedReorderedPackets": ttransport.SubtestDuplicatedReorderedPackets,
+		"LargeTransfer":              ttransport.SubtestLargeTransfer,
+	}
+	listenAddr := ma.StringCast("/ip4/127.0.0.1/udp/0/webrtc-direct")
+	for name, f := range subtests {
+		t.Run(name, func(t *testing.T) {
+			f(t, ta, tb, listenAddr, listeningPeer)
+		})
+	}
+}
+
 // WithListenerMaxInFlightConnections sets the maximum number of connections that are in-flight, i.e
 // they are being negotiated, or are waiting to be accepted.
 func WithListenerMaxInFlightConnections(m uint32) Option {
@@ -860,3 +974,32 @@ func TestMaxInFlightRequests(t *testing.T) {
 	require.Equal(t, count, int(success.Load()), "expected exactly 3 dial successes")
 	require.Equal(t, 1, int(fails.Load()), "expected exactly 1 dial failure")
 }
+
+func TestTransportWebRTC_TURNCredentialsAndICEState(t *testing.T) {
+	tr, listeningPeer := getTransport(t)
+	listener, err := tr.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/webrtc-direct"))
+	require.NoError(t, err)
+	defer listener.Close()
+
+	var fetched atomic.Int32
+	dialer, _ := getTransport(t, WithTURNCredentials(func(context.Context) (TURNCredentials, error) {
+		fetched.Add(1)
+		return TURNCredentials{Expires: time.Now().Add(time.Hour)}, nil
+	}))
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			<-conn.(*connection).ctx.Done()
+		}
+	}()
+	conn, err := dialer.Dial(context.Background(), listener.Multiaddr(), listeningPeer)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, int32(1), fetched.Load())
+
+	state := conn.ConnState()
+	require.NotNil(t, state.ICE)
+	require.Equal(t, "host", state.ICE.LocalCandidateType)
+	require.Equal(t, "host", state.ICE.RemoteCandidateType)
+}
@@ -0,0 +1,173 @@
+package libp2pwebrtc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pion/stun"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// turnFetchTimeout is the timeout for fetching TURN credentials.
+	turnFetchTimeout = 10 * time.Second
+	// turnRefreshBefore is how long before the TURN credentials expire they
+	// are refreshed. Credentials with a shorter lifetime are refreshed when
+	// half of their lifetime has passed.
+	turnRefreshBefore = 5 * time.Minute
+	// turnRetryInterval is the minimum interval between two attempts to
+	// fetch credentials, after a failed attempt.
+	turnRetryInterval = 10 * time.Second
+)
+
+// TURNCredentials are ephemeral credentials for TURN servers, e.g. obtained
+// from the REST API of coturn.
+type TURNCredentials struct {
+	// ICEServers are the TURN servers, with the Username and Credential to
+	// use for them.
+	ICEServers []webrtc.ICEServer
+	// Expires is the time the credentials expire.
+	Expires time.Time
+}
+
+// TURNCredentialsFunc fetches fresh TURN credentials.
+type TURNCredentialsFunc func(ctx context.Context) (TURNCredentials, error)
+
+// turnCredentials caches the credentials returned by a TURNCredentialsFunc,
+// and refreshes them before they expire.
+type turnCredentials struct {
+	fetch TURNCredentialsFunc
+	now   func() time.Time
+
+	mu       sync.Mutex
+	creds    TURNCredentials
+	fetched  time.Time
+	lastFail time.Time
+	inFlight chan struct{} // closed when the current fetch completes, nil if there is none
+}
+
+func newTURNCredentials(fetch TURNCredentialsFunc) *turnCredentials {
+	return &turnCredentials{fetch: fetch, now: time.Now}
+}
+
+// get returns the ICE servers of valid credentials. If the credentials are
+// about to expire, they are refreshed in the background. If they have expired,
+// get waits for fresh credentials, and returns nil if they can't be fetched.
+func (tc *turnCredentials) get(ctx context.Context) []webrtc.ICEServer {
+	tc.mu.Lock()
+	now := tc.now()
+	if now.Before(tc.creds.Expires) {
+		servers := tc.creds.ICEServers
+		if !now.Before(tc.refreshAt()) {
+			tc.startFetchLocked(now)
+		}
+		tc.mu.Unlock()
+		return servers
+	}
+	done := tc.startFetchLocked(now)
+	tc.mu.Unlock()
+	if done == nil {
+		// we failed to fetch credentials recently
+		return nil
+	}
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if !tc.now().Before(tc.creds.Expires) {
+		return nil
+	}
+	return tc.creds.ICEServers
+}
+
+// refreshAt returns the time the current credentials should be refreshed.
+func (tc *turnCredentials) refreshAt() time.Time {
+	lifetime := tc.creds.Expires.Sub(tc.fetched)
+	if lifetime < 2*turnRefreshBefore {
+		return tc.fetched.Add(lifetime / 2)
+	}
+	return tc.creds.Expires.Add(-turnRefreshBefore)
+}
+
+// startFetchLocked starts fetching credentials, unless a fetch is already in
+// flight, and returns a channel that's closed when the fetch completes. It
+// returns nil if the last attempt failed less than turnRetryInterval ago.
+func (tc *turnCredentials) startFetchLocked(now time.Time) chan struct{} {
+	if tc.inFlight != nil {
+		return tc.inFlight
+	}
+	if !tc.lastFail.IsZero() && now.Sub(tc.lastFail) < turnRetryInterval {
+		return nil
+	}
+	done := make(chan struct{})
+	tc.inFlight = done
+	go func() {
+		defer close(done)
+		ctx, cancel := context.WithTimeout(context.Background(), turnFetchTimeout)
+		defer cancel()
+		creds, err := tc.fetch(ctx)
+
+		tc.mu.Lock()
+		defer tc.mu.Unlock()
+		tc.inFlight = nil
+		if err != nil {
+			log.Warnw("failed to fetch TURN credentials", "error", err)
+			tc.lastFail = tc.now()
+			return
+		}
+		tc.lastFail = time.Time{}
+		servers := creds.ICEServers[:0:0]
+		for _, s := range creds.ICEServers {
+			if err := validateICEServer(s); err != nil {
+				log.Warnw("ignoring invalid TURN server", "urls", s.URLs, "error", err)
+				continue
+			}
+			servers = append(servers, s)
+		}
+		creds.ICEServers = servers
+		tc.creds = creds
+		tc.fetched = tc.now()
+	}()
+	return done
+}
+
+// validateICEServer checks that the URLs of s can be parsed, and that TURN
+// servers have credentials. Pion only validates the ICE servers when creating
+// a peer connection, which would make every dial fail.
+func validateICEServer(s webrtc.ICEServer) error {
+	if len(s.URLs) == 0 {
+		return errors.New("ICE server without URLs")
+	}
+	for _, u := range s.URLs {
+		uri, err := stun.ParseURI(u)
+		if err != nil {
+			return fmt.Errorf("invalid ICE server URL %q: %w", u, err)
+		}
+		if uri.Scheme != stun.SchemeTypeTURN && uri.Scheme != stun.SchemeTypeTURNS {
+			continue
+		}
+		if s.Username == "" || s.Credential == nil {
+			return fmt.Errorf("TURN server %q needs a username and a credential", u)
+		}
+		switch s.CredentialType {
+		case webrtc.ICECredentialTypePassword:
+			if _, ok := s.Credential.(string); !ok {
+				return fmt.Errorf("the password of TURN server %q must be a string", u)
+			}
+		case webrtc.ICECredentialTypeOauth:
+			if _, ok := s.Credential.(webrtc.OAuthCredential); !ok {
+				return fmt.Errorf("the credential of TURN server %q must be a webrtc.OAuthCredential", u)
+			}
+		default:
+			return fmt.Errorf("unsupported credential type for TURN server %q: %s", u, s.CredentialType)
+		}
+	}
+	return nil
+}
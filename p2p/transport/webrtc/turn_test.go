@@ -0,0 +1,110 @@
+package libp2pwebrtc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+)
+
+type mockTURNService struct {
+	mu      sync.Mutex
+	now     time.Time
+	fetches int
+	err     error
+}
+
+func (s *mockTURNService) clock() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.now
+}
+
+func (s *mockTURNService) advance(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = s.now.Add(d)
+}
+
+func (s *mockTURNService) fetch(context.Context) (TURNCredentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return TURNCredentials{}, s.err
+	}
+	s.fetches++
+	return TURNCredentials{
+		ICEServers: []webrtc.ICEServer{{
+			URLs:       []string{"turn:turn.example.com:3478"},
+			Username:   "user",
+			Credential: string(rune('0' + s.fetches)),
+		}},
+		Expires: s.now.Add(time.Hour),
+	}, nil
+}
+
+func (s *mockTURNService) numFetches() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+func TestTURNCredentialsRefresh(t *testing.T) {
+	s := &mockTURNService{now: time.Now()}
+	tc := newTURNCredentials(s.fetch)
+	tc.now = s.clock
+
+	// the first call waits for credentials
+	servers := tc.get(context.Background())
+	require.Len(t, servers, 1)
+	require.Equal(t, "1", servers[0].Credential)
+	require.Equal(t, 1, s.numFetches())
+
+	// valid credentials are cached
+	s.advance(time.Hour - turnRefreshBefore - time.Second)
+	require.Equal(t, "1", tc.get(context.Background())[0].Credential)
+	require.Equal(t, 1, s.numFetches())
+
+	// credentials about to expire are still used, but refreshed in the background
+	s.advance(2 * time.Second)
+	require.Equal(t, "1", tc.get(context.Background())[0].Credential)
+	require.Eventually(t, func() bool { return s.numFetches() == 2 }, time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool { return tc.get(context.Background())[0].Credential == "2" }, time.Second, 10*time.Millisecond)
+}
+
+func TestTURNCredentialsFetchError(t *testing.T) {
+	s := &mockTURNService{now: time.Now(), err: errors.New("service unavailable")}
+	tc := newTURNCredentials(s.fetch)
+	tc.now = s.clock
+
+	require.Empty(t, tc.get(context.Background()))
+	// don't retry immediately
+	s.mu.Lock()
+	s.err = nil
+	s.mu.Unlock()
+	require.Empty(t, tc.get(context.Background()))
+	require.Zero(t, s.numFetches())
+
+	s.advance(turnRetryInterval)
+	require.Len(t, tc.get(context.Background()), 1)
+	require.Equal(t, 1, s.numFetches())
+}
+
+func TestTURNCredentialsInvalidServers(t *testing.T) {
+	tc := newTURNCredentials(func(context.Context) (TURNCredentials, error) {
+		return TURNCredentials{
+			ICEServers: []webrtc.ICEServer{
+				{URLs: []string{"turn:turn1.example.com:3478"}},
+				{URLs: []string{"turn:turn2.example.com:3478"}, Username: "user", Credential: "secret"},
+			},
+			Expires: time.Now().Add(time.Hour),
+		}, nil
+	})
+	servers := tc.get(context.Background())
+	require.Len(t, servers, 1)
+	require.Equal(t, []string{"turn:turn2.example.com:3478"}, servers[0].URLs)
+}
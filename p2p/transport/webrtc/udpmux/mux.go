@@ -11,6 +11,7 @@ import (
 
 	logging "github.com/ipfs/go-log/v2"
 	pool "github.com/libp2p/go-buffer-pool"
+	"github.com/libp2p/go-libp2p/core/panics"
 	"github.com/pion/ice/v2"
 	"github.com/pion/stun"
 )
@@ -158,6 +159,11 @@ func (mux *UDPMux) readLoop() {
 }
 
 func (mux *UDPMux) processPacket(buf []byte, addr net.Addr) (processed bool) {
+	// A malformed packet must not take down the read loop. The buffer of a
+	// packet that caused a panic may still be referenced, so it isn't
+	// returned to the pool.
+	defer panics.Recover("webrtc-udpmux", "", func() { processed = true })
+
 	udpAddr, ok := addr.(*net.UDPAddr)
 	if !ok {
 		log.Errorf("received a non-UDP address: %s", addr)
@@ -0,0 +1,110 @@
+package libp2pwebrtc
+
+import (
+	"sync"
+	"time"
+)
+
+// schedulerPollInterval is the interval at which a blocked writeScheduler
+// checks whether data was sent. Data channels only notify us when their
+// buffered amount drops below the low threshold, which streams with little
+// data buffered never reach.
+const schedulerPollInterval = 5 * time.Millisecond
+
+// writeTurn is a stream's turn to write a message, see writeScheduler.
+type writeTurn struct {
+	// ready is closed when the turn is granted
+	ready chan struct{}
+	// space is the number of bytes the stream may write, set when the turn
+	// is granted
+	space int
+}
+
+// writeScheduler shares the send buffer of a connection between its streams.
+//
+// SCTP congestion control is per association, and the association sends the
+// messages of all data channels from a single queue in the order they were
+// written. A stream that fills its send buffer therefore delays the messages
+// of all other streams. To prevent a busy stream from starving the others,
+// streams take turns writing a message, in round-robin order, and only while
+// the total amount of data buffered on the connection is below maxBuffered.
+type writeScheduler struct {
+	maxBuffered int
+	// buffered returns the total amount of data buffered on the connection
+	buffered func() int
+	metrics  *metricsTracer
+
+	mx      sync.Mutex
+	queue   []*writeTurn
+	granted *writeTurn
+	timer   *time.Timer
+}
+
+func newWriteScheduler(maxBuffered int, buffered func() int, metrics *metricsTracer) *writeScheduler {
+	return &writeScheduler{maxBuffered: maxBuffered, buffered: buffered, metrics: metrics}
+}
+
+// enqueue queues a turn to write a message. The caller must release the turn
+// once it has written, or if it stops waiting for it.
+func (ws *writeScheduler) enqueue() *writeTurn {
+	t := &writeTurn{ready: make(chan struct{})}
+
+	ws.mx.Lock()
+	defer ws.mx.Unlock()
+	ws.queue = append(ws.queue, t)
+	ws.schedule()
+	return t
+}
+
+// release ends or cancels the turn t, and grants the next turn.
+func (ws *writeScheduler) release(t *writeTurn) {
+	ws.mx.Lock()
+	defer ws.mx.Unlock()
+
+	if ws.granted == t {
+		ws.granted = nil
+	} else {
+		for i, qt := range ws.queue {
+			if qt == t {
+				ws.queue = append(ws.queue[:i], ws.queue[i+1:]...)
+				break
+			}
+		}
+	}
+	ws.schedule()
+}
+
+// notify is called when data was sent on the connection.
+func (ws *writeScheduler) notify() {
+	ws.mx.Lock()
+	defer ws.mx.Unlock()
+	ws.schedule()
+}
+
+// schedule grants the next turn, if there's enough space in the send buffer.
+// It must be called with mx held.
+func (ws *writeScheduler) schedule() {
+	if ws.granted != nil || len(ws.queue) == 0 {
+		return
+	}
+	buffered := ws.buffered()
+	space := ws.maxBuffered - buffered
+	if space < minMessageSize {
+		if ws.timer == nil {
+			ws.timer = time.AfterFunc(schedulerPollInterval, func() {
+				ws.mx.Lock()
+				defer ws.mx.Unlock()
+				ws.timer = nil
+				ws.schedule()
+			})
+		}
+		return
+	}
+	t := ws.queue[0]
+	ws.queue[0] = nil
+	ws.queue = ws.queue[1:]
+	ws.granted = t
+	t.space = space
+	close(t.ready)
+	ws.metrics.connectionBuffered(buffered)
+}
@@ -0,0 +1,97 @@
+//go:build !js
+
+package libp2pwebrtc
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func requireGranted(t *testing.T, turn *writeTurn) {
+	t.Helper()
+	select {
+	case <-turn.ready:
+	case <-time.After(time.Second):
+		t.Fatal("turn not granted")
+	}
+}
+
+func requireNotGranted(t *testing.T, turn *writeTurn) {
+	t.Helper()
+	select {
+	case <-turn.ready:
+		t.Fatal("turn granted")
+	case <-time.After(3 * schedulerPollInterval):
+	}
+}
+
+func TestWriteSchedulerRoundRobin(t *testing.T) {
+	var buffered atomic.Int64
+	ws := newWriteScheduler(64<<10, func() int { return int(buffered.Load()) }, nil)
+
+	// a busy stream holds the turn, the others queue up behind it
+	a1 := ws.enqueue()
+	requireGranted(t, a1)
+	require.Equal(t, 64<<10, a1.space)
+	b := ws.enqueue()
+	c := ws.enqueue()
+	requireNotGranted(t, b)
+
+	// the busy stream queues its next write behind the other streams
+	buffered.Add(16 << 10)
+	ws.release(a1)
+	a2 := ws.enqueue()
+	requireGranted(t, b)
+	require.Equal(t, 48<<10, b.space)
+	requireNotGranted(t, c)
+	ws.release(b)
+	requireGranted(t, c)
+	requireNotGranted(t, a2)
+	ws.release(c)
+	requireGranted(t, a2)
+	ws.release(a2)
+}
+
+func TestWriteSchedulerWaitsForSpace(t *testing.T) {
+	var buffered atomic.Int64
+	ws := newWriteScheduler(64<<10, func() int { return int(buffered.Load()) }, nil)
+
+	buffered.Store(64<<10 - minMessageSize + 1)
+	turn := ws.enqueue()
+	requireNotGranted(t, turn)
+
+	// the scheduler polls the buffered amount, even without notifications
+	buffered.Store(32 << 10)
+	requireGranted(t, turn)
+	require.Equal(t, 32<<10, turn.space)
+	ws.release(turn)
+}
+
+func TestWriteSchedulerCancel(t *testing.T) {
+	ws := newWriteScheduler(64<<10, func() int { return 0 }, nil)
+
+	a := ws.enqueue()
+	requireGranted(t, a)
+	b := ws.enqueue()
+	c := ws.enqueue()
+	// b stops waiting, e.g. because its write deadline expired
+	ws.release(b)
+	ws.release(a)
+	requireGranted(t, c)
+	requireNotGranted(t, b)
+	ws.release(c)
+}
+
+func TestMaxConnectionBufferedAmountOption(t *testing.T) {
+	tr, _ := getTransport(t)
+	require.Equal(t, defaultMaxConnBufferedAmount, tr.maxConnBufferedAmount)
+
+	tr, _ = getTransport(t, WithMaxBufferedAmount(1<<20))
+	require.Equal(t, 1<<20, tr.maxConnBufferedAmount)
+
+	_, err := New(tr.privKey, nil, nil, nil, WithMaxBufferedAmount(1<<20), WithMaxConnectionBufferedAmount(64<<10))
+	require.Error(t, err)
+}
@@ -0,0 +1,306 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall/js"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// When compiled to WebAssembly, the transport dials using the browser's
+// WebSocket API. Listening is not possible in the browser.
+//
+// The WebTransport transport dials using the browser's WebTransport API. The
+// WebRTC transport is not available in the browser.
+
+const (
+	webSocketStateConnecting = 0
+	webSocketStateOpen       = 1
+	webSocketStateClosing    = 2
+	webSocketStateClosed     = 3
+
+	// maxBufferedAmount is the number of bytes the browser may have queued
+	// for sending before Write blocks.
+	maxBufferedAmount = 1 << 20 // 1 MiB
+	// bufferedAmountPollInterval is the interval at which a blocked Write
+	// checks if the browser's send buffer has drained.
+	bufferedAmountPollInterval = 5 * time.Millisecond
+)
+
+var errConnectionClosed = errors.New("connection is closed")
+
+func (t *WebsocketTransport) maDial(ctx context.Context, raddr ma.Multiaddr) (manet.Conn, error) {
+	wsurl, err := parseMultiaddr(raddr)
+	if err != nil {
+		return nil, err
+	}
+	isWss := wsurl.Scheme == "wss"
+	if isWss {
+		// The browser resolves the host name itself, and uses it for SNI.
+		if sni, err := raddr.ValueForProtocol(ma.P_SNI); err == nil && sni != "" {
+			wsurl.Host = sni + ":" + wsurl.Port()
+		}
+	}
+
+	c, err := newBrowserConn(js.Global().Get("WebSocket").New(wsurl.String()), isWss, wsurl.Host)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.waitForOpen(ctx); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	mnc, err := manet.WrapNetConn(c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return mnc, nil
+}
+
+// browserConn implements the net.Conn interface on top of a browser WebSocket.
+type browserConn struct {
+	js.Value
+
+	localAddr, remoteAddr net.Addr
+
+	openSignal  chan struct{}
+	closeSignal chan struct{} // closed when the WebSocket is closed
+	dataSignal  chan struct{} // receives when data is added to the buffer
+
+	handlers map[string]js.Func
+
+	mx            sync.Mutex
+	buf           bytes.Buffer
+	readDeadline  time.Time
+	writeDeadline time.Time
+	err           error
+
+	openOnce, closeOnce, closeSignalOnce sync.Once
+}
+
+var _ net.Conn = (*browserConn)(nil)
+
+func newBrowserConn(raw js.Value, secure bool, remoteHost string) (*browserConn, error) {
+	c := &browserConn{
+		Value:       raw,
+		localAddr:   NewAddrWithScheme("0.0.0.0:0", secure),
+		remoteAddr:  NewAddrWithScheme(remoteHost, secure),
+		openSignal:  make(chan struct{}),
+		closeSignal: make(chan struct{}),
+		dataSignal:  make(chan struct{}, 1),
+		handlers:    make(map[string]js.Func, 4),
+	}
+	c.Set("binaryType", "arraybuffer")
+	c.setHandler("onopen", func(js.Value) {
+		c.openOnce.Do(func() { close(c.openSignal) })
+	})
+	c.setHandler("onmessage", func(evt js.Value) {
+		data := evt.Get("data")
+		if data.Type() == js.TypeString {
+			// We only expect binary messages.
+			c.fail(errors.New("received unexpected text message"))
+			return
+		}
+		arr := js.Global().Get("Uint8Array").New(data)
+		b := make([]byte, arr.Length())
+		js.CopyBytesToGo(b, arr)
+		c.mx.Lock()
+		c.buf.Write(b)
+		c.mx.Unlock()
+		select {
+		case c.dataSignal <- struct{}{}:
+		default:
+		}
+	})
+	c.setHandler("onerror", func(js.Value) {
+		c.fail(errors.New("websocket error"))
+	})
+	c.setHandler("onclose", func(evt js.Value) {
+		if code := evt.Get("code").Int(); code != 1000 && code != 1005 {
+			c.fail(fmt.Errorf("websocket closed with code %d: %s", code, evt.Get("reason").String()))
+		}
+		c.signalClose()
+	})
+	return c, nil
+}
+
+func (c *browserConn) setHandler(name string, f func(evt js.Value)) {
+	h := js.FuncOf(func(_ js.Value, args []js.Value) any {
+		var evt js.Value
+		if len(args) > 0 {
+			evt = args[0]
+		}
+		f(evt)
+		return nil
+	})
+	c.handlers[name] = h
+	c.Set(name, h)
+}
+
+func (c *browserConn) fail(err error) {
+	c.mx.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.mx.Unlock()
+}
+
+func (c *browserConn) signalClose() {
+	c.closeSignalOnce.Do(func() { close(c.closeSignal) })
+}
+
+func (c *browserConn) waitForOpen(ctx context.Context) error {
+	select {
+	case <-c.openSignal:
+		return nil
+	case <-c.closeSignal:
+		c.mx.Lock()
+		defer c.mx.Unlock()
+		if c.err != nil {
+			return c.err
+		}
+		return errConnectionClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *browserConn) Read(b []byte) (int, error) {
+	for {
+		c.mx.Lock()
+		if c.buf.Len() > 0 {
+			n, _ := c.buf.Read(b)
+			c.mx.Unlock()
+			return n, nil
+		}
+		deadline := c.readDeadline
+		err := c.err
+		c.mx.Unlock()
+
+		select {
+		case <-c.closeSignal:
+			// Drain data that arrived before the connection was closed.
+			c.mx.Lock()
+			n, _ := c.buf.Read(b)
+			c.mx.Unlock()
+			if n > 0 {
+				return n, nil
+			}
+			if err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		default:
+		}
+
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return 0, os.ErrDeadlineExceeded
+			}
+			t := time.NewTimer(d)
+			defer t.Stop()
+			timeout = t.C
+		}
+		select {
+		case <-c.dataSignal:
+		case <-c.closeSignal:
+		case <-timeout:
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+func (c *browserConn) Write(b []byte) (int, error) {
+	if err := c.waitForBufferedAmount(); err != nil {
+		return 0, err
+	}
+	arr := js.Global().Get("Uint8Array").New(len(b))
+	js.CopyBytesToJS(arr, b)
+	c.Call("send", arr.Get("buffer"))
+	return len(b), nil
+}
+
+// waitForBufferedAmount blocks until the browser's send buffer has drained
+// below maxBufferedAmount. The browser doesn't apply any backpressure itself:
+// send never blocks, and queues an unlimited amount of data.
+// There's no event for the buffer draining, so we have to poll.
+func (c *browserConn) waitForBufferedAmount() error {
+	for {
+		if state := c.Get("readyState").Int(); state != webSocketStateOpen {
+			return errConnectionClosed
+		}
+		if c.Get("bufferedAmount").Int() <= maxBufferedAmount {
+			return nil
+		}
+		c.mx.Lock()
+		deadline := c.writeDeadline
+		c.mx.Unlock()
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return os.ErrDeadlineExceeded
+		}
+		select {
+		case <-time.After(bufferedAmountPollInterval):
+		case <-c.closeSignal:
+			return errConnectionClosed
+		}
+	}
+}
+
+// Close closes the connection. Only the first call to Close will receive the
+// close error, subsequent and concurrent calls will return nil.
+func (c *browserConn) Close() error {
+	c.closeOnce.Do(func() {
+		if state := c.Get("readyState").Int(); state == webSocketStateConnecting || state == webSocketStateOpen {
+			c.Call("close", 1000, "closed")
+		}
+		c.signalClose()
+		// The browser might still call the handlers, e.g. onclose once the
+		// closing handshake completes. Unset them before releasing them, since
+		// calling a released function panics.
+		for name, h := range c.handlers {
+			c.Set(name, js.Null())
+			h.Release()
+		}
+	})
+	return nil
+}
+
+func (c *browserConn) LocalAddr() net.Addr  { return c.localAddr }
+func (c *browserConn) RemoteAddr() net.Addr { return c.remoteAddr }
+
+func (c *browserConn) SetDeadline(t time.Time) error {
+	c.SetWriteDeadline(t)
+	return c.SetReadDeadline(t)
+}
+
+func (c *browserConn) SetReadDeadline(t time.Time) error {
+	c.mx.Lock()
+	c.readDeadline = t
+	c.mx.Unlock()
+	// Wake up a blocked Read, so it picks up the new deadline.
+	select {
+	case c.dataSignal <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (c *browserConn) SetWriteDeadline(t time.Time) error {
+	c.mx.Lock()
+	c.writeDeadline = t
+	c.mx.Unlock()
+	return nil
+}
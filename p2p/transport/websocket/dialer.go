@@ -0,0 +1,90 @@
+//go:build !js
+
+package websocket
+
+import (
+	"context"
+	"net"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+
+	ws "github.com/gorilla/websocket"
+)
+
+func (t *WebsocketTransport) maDial(ctx context.Context, raddr ma.Multiaddr) (manet.Conn, error) {
+	wsurl, err := parseMultiaddr(raddr)
+	if err != nil {
+		return nil, err
+	}
+	isWss := wsurl.Scheme == "wss"
+	var netDialer net.Dialer
+	if src := t.sourceIP(raddr); src != nil {
+		netDialer.LocalAddr = &net.TCPAddr{IP: src}
+	}
+	dialer := ws.Dialer{HandshakeTimeout: 30 * time.Second, NetDialContext: netDialer.DialContext}
+	if isWss {
+		sni := ""
+		sni, err = raddr.ValueForProtocol(ma.P_SNI)
+		if err != nil {
+			sni = ""
+		}
+
+		if sni != "" {
+			copytlsClientConf := t.tlsClientConf.Clone()
+			copytlsClientConf.ServerName = sni
+			dialer.TLSClientConfig = copytlsClientConf
+			ipAddr := wsurl.Host
+			// Setting the NetDialContext because we already have the resolved IP address, so we don't want to do another resolution.
+			// We set the `.Host` to the sni field so that the host header gets properly set.
+			dialer.NetDialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+				return netDialer.DialContext(ctx, "tcp", ipAddr)
+			}
+			wsurl.Host = sni + ":" + wsurl.Port()
+		} else {
+			dialer.TLSClientConfig = t.tlsClientConf
+		}
+	}
+
+	wscon, _, err := dialer.DialContext(ctx, wsurl.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	mnc, err := manet.WrapNetConn(NewConn(wscon, isWss))
+	if err != nil {
+		wscon.Close()
+		return nil, err
+	}
+	return mnc, nil
+}
+
+// sourceIP returns the local IP address to dial raddr from, or nil if the
+// transport should choose it.
+func (t *WebsocketTransport) sourceIP(raddr ma.Multiaddr) net.IP {
+	if t.dialSourceAddr == nil {
+		return nil
+	}
+	laddr := t.dialSourceAddr(raddr)
+	if laddr == nil {
+		return nil
+	}
+	ip, err := manet.ToIP(laddr)
+	if err != nil {
+		return nil
+	}
+	var ip4 bool
+	switch raddr.Protocols()[0].Code {
+	case ma.P_IP4, ma.P_DNS4:
+		ip4 = true
+	case ma.P_IP6, ma.P_DNS6:
+	default:
+		// /dns addresses may resolve to either family
+		return nil
+	}
+	if (ip.To4() != nil) != ip4 {
+		return nil
+	}
+	return ip
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/net/proxyproto"
 
 	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr/net"
@@ -19,6 +20,9 @@ type listener struct {
 	// The Go standard library sets the http.Server.TLSConfig no matter if this is a WS or WSS,
 	// so we can't rely on checking if server.TLSConfig is set.
 	isWss bool
+	// tlsTerminated is set if the TLS handshake is performed by the net.Listener,
+	// see WithSNIVirtualHosting.
+	tlsTerminated bool
 
 	laddr ma.Multiaddr
 
@@ -39,8 +43,11 @@ func (pwma *parsedWebsocketMultiaddr) toMultiaddr() ma.Multiaddr {
 }
 
 // newListener creates a new listener from a raw net.Listener.
-// tlsConf may be nil (for unencrypted websockets).
-func newListener(a ma.Multiaddr, tlsConf *tls.Config) (*listener, error) {
+// tlsConf may be nil (for unencrypted websockets). If sniVirtualHosting is set,
+// WSS listeners share their socket, see WithSNIVirtualHosting. If
+// proxyProtocol is set, connections from the trusted networks must send a
+// PROXY header, see WithProxyProtocol.
+func newListener(a ma.Multiaddr, tlsConf *tls.Config, sniVirtualHosting, proxyProtocol bool, trusted []*net.IPNet) (*listener, error) {
 	parsed, err := parseWebsocketMultiaddr(a)
 	if err != nil {
 		return nil, err
@@ -54,13 +61,23 @@ func newListener(a ma.Multiaddr, tlsConf *tls.Config) (*listener, error) {
 	if err != nil {
 		return nil, err
 	}
-	nl, err := net.Listen(lnet, lnaddr)
+	var nl net.Listener
+	tlsTerminated := parsed.isWSS && sniVirtualHosting
+	if tlsTerminated {
+		nl, err = listenSNI(lnet, lnaddr, sniName(parsed), tlsConf)
+	} else {
+		nl, err = net.Listen(lnet, lnaddr)
+	}
 	if err != nil {
 		return nil, err
 	}
+	if proxyProtocol {
+		nl = proxyproto.NewListener(nl, trusted...)
+	}
 
 	laddr, err := manet.FromNetAddr(nl.Addr())
 	if err != nil {
+		nl.Close()
 		return nil, err
 	}
 	first, _ := ma.SplitFirst(a)
@@ -81,6 +98,7 @@ func newListener(a ma.Multiaddr, tlsConf *tls.Config) (*listener, error) {
 	ln.server = http.Server{Handler: ln}
 	if parsed.isWSS {
 		ln.isWss = true
+		ln.tlsTerminated = tlsTerminated
 		ln.server.TLSConfig = tlsConf
 	}
 	return ln, nil
@@ -88,7 +106,7 @@ func newListener(a ma.Multiaddr, tlsConf *tls.Config) (*listener, error) {
 
 func (l *listener) serve() {
 	defer close(l.closed)
-	if !l.isWss {
+	if !l.isWss || l.tlsTerminated {
 		l.server.Serve(l.nl)
 	} else {
 		l.server.ServeTLS(l.nl, "", "")
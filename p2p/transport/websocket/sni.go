@@ -0,0 +1,212 @@
+package websocket
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// sniHandshakeTimeout is the time a client has to complete the TLS handshake
+// on a shared listener.
+const sniHandshakeTimeout = 10 * time.Second
+
+// WithSNIVirtualHosting allows WSS listeners to share a TCP socket, e.g. to
+// serve several DNS names (and hosts) on a single IP address and port.
+//
+// The name served by a listener is the DNS name of its multiaddr, e.g.
+// /dns4/a.example.com/tcp/443/tls/ws, or its SNI value, e.g.
+// /ip4/1.2.3.4/tcp/443/tls/sni/a.example.com/ws. Both are advertised as is, so
+// peers dial the name and send it as SNI. Incoming connections are routed to
+// the listener serving the name sent by the client, using that listener's TLS
+// configuration. A listener without a name receives the connections that
+// don't match any other listener.
+//
+// Listeners of all WebsocketTransports in the process that enable this option
+// share sockets, so each host can serve its own names with its own
+// certificates. WebTransport certificates are bound to the certhash of the
+// listen address rather than a DNS name, so there is no equivalent there.
+func WithSNIVirtualHosting() Option {
+	return func(t *WebsocketTransport) error {
+		t.sniVirtualHosting = true
+		return nil
+	}
+}
+
+var sniMuxes = struct {
+	sync.Mutex
+	m map[string]*sniMux
+}{m: make(map[string]*sniMux)}
+
+// sniMux accepts TLS connections on a shared socket, and routes them to the
+// listener serving the name sent by the client as SNI.
+type sniMux struct {
+	key string
+	nl  net.Listener
+
+	mu        sync.Mutex
+	listeners map[string]*sniListener // by lowercase name, "" for the default listener
+}
+
+// sniListener is a net.Listener for the TLS connections routed to a name.
+type sniListener struct {
+	mux     *sniMux
+	name    string
+	tlsConf *tls.Config
+
+	incoming  chan net.Conn
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// listenSNI returns a listener for the TLS connections on the given address
+// that are sent to name. The TLS handshake is completed before connections are
+// returned.
+func listenSNI(network, addr, name string, tlsConf *tls.Config) (net.Listener, error) {
+	tcpAddr, err := net.ResolveTCPAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	name = strings.ToLower(name)
+
+	sniMuxes.Lock()
+	defer sniMuxes.Unlock()
+
+	key := network + " " + tcpAddr.String()
+	m, ok := sniMuxes.m[key]
+	if !ok {
+		nl, err := net.ListenTCP(network, tcpAddr)
+		if err != nil {
+			return nil, err
+		}
+		// If the port was chosen by the OS, other listeners use the actual port.
+		key = network + " " + nl.Addr().String()
+		m = &sniMux{key: key, nl: nl, listeners: make(map[string]*sniListener)}
+		sniMuxes.m[key] = m
+		go m.serve()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.listeners[name]; ok {
+		if name == "" {
+			return nil, fmt.Errorf("already listening on %s without a name", m.nl.Addr())
+		}
+		return nil, fmt.Errorf("already listening on %s for %s", m.nl.Addr(), name)
+	}
+	l := &sniListener{
+		mux:      m,
+		name:     name,
+		tlsConf:  tlsConf,
+		incoming: make(chan net.Conn),
+		closed:   make(chan struct{}),
+	}
+	m.listeners[name] = l
+	return l, nil
+}
+
+func (m *sniMux) serve() {
+	for {
+		c, err := m.nl.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return
+		}
+		go m.handle(c)
+	}
+}
+
+func (m *sniMux) handle(c net.Conn) {
+	var target *sniListener
+	tlsConn := tls.Server(c, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			target = m.lookup(hello.ServerName)
+			if target == nil {
+				return nil, fmt.Errorf("no listener for %q", hello.ServerName)
+			}
+			return target.tlsConf, nil
+		},
+	})
+	c.SetDeadline(time.Now().Add(sniHandshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		c.Close()
+		return
+	}
+	c.SetDeadline(time.Time{})
+
+	select {
+	case target.incoming <- tlsConn:
+	case <-target.closed:
+		tlsConn.Close()
+	}
+}
+
+func (m *sniMux) lookup(name string) *sniListener {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if l, ok := m.listeners[strings.ToLower(name)]; ok {
+		return l
+	}
+	return m.listeners[""]
+}
+
+func (l *sniListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.incoming:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close removes the listener from the shared socket. The socket is closed
+// when its last listener is closed.
+func (l *sniListener) Close() error {
+	err := net.ErrClosed
+	l.closeOnce.Do(func() {
+		err = nil
+		close(l.closed)
+
+		sniMuxes.Lock()
+		defer sniMuxes.Unlock()
+		m := l.mux
+		m.mu.Lock()
+		delete(m.listeners, l.name)
+		last := len(m.listeners) == 0
+		m.mu.Unlock()
+		if last {
+			delete(sniMuxes.m, m.key)
+			m.nl.Close()
+		}
+	})
+	return err
+}
+
+func (l *sniListener) Addr() net.Addr {
+	return l.mux.nl.Addr()
+}
+
+// sniName returns the name a listener on the parsed multiaddr serves, see
+// WithSNIVirtualHosting.
+func sniName(parsed parsedWebsocketMultiaddr) string {
+	if parsed.sni != nil {
+		return parsed.sni.Value()
+	}
+	first, _ := ma.SplitFirst(parsed.restMultiaddr)
+	if first == nil {
+		return ""
+	}
+	switch first.Protocol().Code {
+	case ma.P_DNS, ma.P_DNS4, ma.P_DNS6:
+		return first.Value()
+	}
+	return ""
+}
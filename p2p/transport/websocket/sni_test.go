@@ -0,0 +1,124 @@
+package websocket
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/transport"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+// getTLSConfForName returns a TLS config with a self-signed certificate for
+// name, and adds the certificate to pool.
+func getTLSConfForName(t *testing.T, name string, pool *x509.CertPool) *tls.Config {
+	t.Helper()
+	certTempl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1234),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		DNSNames:              []string{name},
+	}
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	certBytes, err := x509.CreateCertificate(rand.Reader, certTempl, certTempl, &priv.PublicKey, priv)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(certBytes)
+	require.NoError(t, err)
+	pool.AddCert(cert)
+	return &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{cert.Raw},
+			PrivateKey:  priv,
+			Leaf:        cert,
+		}},
+	}
+}
+
+func acceptAndCloseConns(l transport.Listener) {
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+}
+
+func TestSNIVirtualHosting(t *testing.T) {
+	pool := x509.NewCertPool()
+
+	idA, uA := newSecureUpgrader(t)
+	serverA, err := New(uA, &network.NullResourceManager{}, WithTLSConfig(getTLSConfForName(t, "a.example.com", pool)), WithSNIVirtualHosting())
+	require.NoError(t, err)
+	idB, uB := newSecureUpgrader(t)
+	serverB, err := New(uB, &network.NullResourceManager{}, WithTLSConfig(getTLSConfForName(t, "b.example.com", pool)), WithSNIVirtualHosting())
+	require.NoError(t, err)
+
+	lA, err := serverA.Listen(ma.StringCast("/ip4/127.0.0.1/tcp/0/tls/sni/a.example.com/ws"))
+	require.NoError(t, err)
+	defer lA.Close()
+	acceptAndCloseConns(lA)
+	port, err := lA.Multiaddr().ValueForProtocol(ma.P_TCP)
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("/ip4/127.0.0.1/tcp/%s/tls/sni/a.example.com/ws", port), lA.Multiaddr().String())
+
+	// the same name can't be served twice
+	_, err = serverB.Listen(ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/tcp/%s/tls/sni/a.example.com/ws", port)))
+	require.Error(t, err)
+
+	lB, err := serverB.Listen(ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/tcp/%s/tls/sni/b.example.com/ws", port)))
+	require.NoError(t, err)
+	defer lB.Close()
+	acceptAndCloseConns(lB)
+	require.Equal(t, fmt.Sprintf("/ip4/127.0.0.1/tcp/%s/tls/sni/b.example.com/ws", port), lB.Multiaddr().String())
+
+	_, u := newSecureUpgrader(t)
+	client, err := New(u, &network.NullResourceManager{}, WithTLSClientConfig(&tls.Config{RootCAs: pool}))
+	require.NoError(t, err)
+	dial := func(addr ma.Multiaddr, p peer.ID) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := client.Dial(ctx, addr, p)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	// connections are routed by SNI, and the certificate of the name is used
+	require.NoError(t, dial(lA.Multiaddr(), idA))
+	require.NoError(t, dial(lB.Multiaddr(), idB))
+	require.Error(t, dial(ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/tcp/%s/tls/sni/c.example.com/ws", port)), idA))
+
+	// closing one listener doesn't affect the other names
+	lA.Close()
+	require.Error(t, dial(lA.Multiaddr(), idA))
+	require.NoError(t, dial(lB.Multiaddr(), idB))
+
+	// the socket is closed with the last listener
+	lB.Close()
+	nl, err := net.Listen("tcp", "127.0.0.1:"+port)
+	require.NoError(t, err)
+	nl.Close()
+}
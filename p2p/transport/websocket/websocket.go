@@ -4,9 +4,9 @@ package websocket
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"net"
 	"net/http"
-	"time"
 
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -80,6 +80,31 @@ func WithTLSConfig(conf *tls.Config) Option {
 	}
 }
 
+// WithProxyProtocol makes listeners read the PROXY protocol header sent by
+// load balancers and reverse proxies, see tcp.WithProxyProtocol. For WSS
+// listeners, the header precedes the TLS handshake. It can't be combined with
+// WithSNIVirtualHosting.
+func WithProxyProtocol(trusted ...*net.IPNet) Option {
+	return func(t *WebsocketTransport) error {
+		t.proxyProtocol = true
+		t.proxyTrusted = trusted
+		return nil
+	}
+}
+
+// WithDialSourceAddr sets a function that selects the local IP address to dial
+// raddr from, for example to use a different IP address depending on the
+// destination on a multi-homed host, see tcp.WithDialSourceAddr. The function
+// returns an IP multiaddr (e.g. /ip4/1.2.3.4), or nil to let the transport
+// choose the source address. Addresses of a different IP family than raddr
+// are ignored. It has no effect in the browser.
+func WithDialSourceAddr(f func(raddr ma.Multiaddr) ma.Multiaddr) Option {
+	return func(t *WebsocketTransport) error {
+		t.dialSourceAddr = f
+		return nil
+	}
+}
+
 // WebsocketTransport is the actual go-libp2p transport
 type WebsocketTransport struct {
 	upgrader transport.Upgrader
@@ -87,6 +112,13 @@ type WebsocketTransport struct {
 
 	tlsClientConf *tls.Config
 	tlsConf       *tls.Config
+
+	sniVirtualHosting bool
+
+	proxyProtocol bool
+	proxyTrusted  []*net.IPNet
+
+	dialSourceAddr func(raddr ma.Multiaddr) ma.Multiaddr
 }
 
 var _ transport.Transport = (*WebsocketTransport)(nil)
@@ -105,6 +137,9 @@ func New(u transport.Upgrader, rcmgr network.ResourceManager, opts ...Option) (*
 			return nil, err
 		}
 	}
+	if t.proxyProtocol && t.sniVirtualHosting {
+		return nil, errors.New("the PROXY protocol can't be used with SNI virtual hosting")
+	}
 	return t, nil
 }
 
@@ -120,6 +155,10 @@ func (t *WebsocketTransport) Proxy() bool {
 	return false
 }
 
+func (t *WebsocketTransport) Capabilities() transport.Capabilities {
+	return transport.CapBrowser
+}
+
 func (t *WebsocketTransport) Resolve(_ context.Context, maddr ma.Multiaddr) ([]ma.Multiaddr, error) {
 	parsed, err := parseWebsocketMultiaddr(maddr)
 	if err != nil {
@@ -181,55 +220,8 @@ func (t *WebsocketTransport) dialWithScope(ctx context.Context, raddr ma.Multiad
 	return &capableConn{CapableConn: conn}, nil
 }
 
-func (t *WebsocketTransport) maDial(ctx context.Context, raddr ma.Multiaddr) (manet.Conn, error) {
-	wsurl, err := parseMultiaddr(raddr)
-	if err != nil {
-		return nil, err
-	}
-	isWss := wsurl.Scheme == "wss"
-	dialer := ws.Dialer{HandshakeTimeout: 30 * time.Second}
-	if isWss {
-		sni := ""
-		sni, err = raddr.ValueForProtocol(ma.P_SNI)
-		if err != nil {
-			sni = ""
-		}
-
-		if sni != "" {
-			copytlsClientConf := t.tlsClientConf.Clone()
-			copytlsClientConf.ServerName = sni
-			dialer.TLSClientConfig = copytlsClientConf
-			ipAddr := wsurl.Host
-			// Setting the NetDial because we already have the resolved IP address, so we don't want to do another resolution.
-			// We set the `.Host` to the sni field so that the host header gets properly set.
-			dialer.NetDial = func(network, address string) (net.Conn, error) {
-				tcpAddr, err := net.ResolveTCPAddr(network, ipAddr)
-				if err != nil {
-					return nil, err
-				}
-				return net.DialTCP("tcp", nil, tcpAddr)
-			}
-			wsurl.Host = sni + ":" + wsurl.Port()
-		} else {
-			dialer.TLSClientConfig = t.tlsClientConf
-		}
-	}
-
-	wscon, _, err := dialer.DialContext(ctx, wsurl.String(), nil)
-	if err != nil {
-		return nil, err
-	}
-
-	mnc, err := manet.WrapNetConn(NewConn(wscon, isWss))
-	if err != nil {
-		wscon.Close()
-		return nil, err
-	}
-	return mnc, nil
-}
-
 func (t *WebsocketTransport) maListen(a ma.Multiaddr) (manet.Listener, error) {
-	l, err := newListener(a, t.tlsConf)
+	l, err := newListener(a, t.tlsConf, t.sniVirtualHosting, t.proxyProtocol, t.proxyTrusted)
 	if err != nil {
 		return nil, err
 	}
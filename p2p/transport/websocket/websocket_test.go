@@ -15,6 +15,7 @@ import (
 	"math/big"
 	"net"
 	"net/http"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
@@ -31,6 +32,7 @@ import (
 	"github.com/libp2p/go-libp2p/p2p/security/noise"
 	ttransport "github.com/libp2p/go-libp2p/p2p/transport/testsuite"
 
+	ws "github.com/gorilla/websocket"
 	ma "github.com/multiformats/go-multiaddr"
 	"github.com/stretchr/testify/require"
 )
@@ -473,6 +475,69 @@ func TestConcurrentClose(t *testing.T) {
 	}
 }
 
+func TestProxyProtocol(t *testing.T) {
+	_, u := newUpgrader(t)
+	tpt, err := New(u, &network.NullResourceManager{}, WithProxyProtocol())
+	require.NoError(t, err)
+	l, err := tpt.maListen(ma.StringCast("/ip4/127.0.0.1/tcp/0/ws"))
+	require.NoError(t, err)
+	defer l.Close()
+
+	dialer := ws.Dialer{NetDial: func(network, addr string) (net.Conn, error) {
+		c, err := net.Dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := c.Write([]byte("PROXY TCP4 192.0.2.1 127.0.0.1 56324 443\r\n")); err != nil {
+			c.Close()
+			return nil, err
+		}
+		return c, nil
+	}}
+	wsurl, err := parseMultiaddr(l.Multiaddr())
+	require.NoError(t, err)
+	c, _, err := dialer.Dial(wsurl.String(), nil)
+	require.NoError(t, err)
+	defer c.Close()
+
+	sc, err := l.Accept()
+	require.NoError(t, err)
+	defer sc.Close()
+	require.Equal(t, "/ip4/192.0.2.1/tcp/56324/ws", sc.RemoteMultiaddr().String())
+
+	_, err = New(u, &network.NullResourceManager{}, WithProxyProtocol(), WithSNIVirtualHosting())
+	require.Error(t, err)
+}
+
+func TestDialSourceAddr(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("127.0.0.2 is only routed to the loopback interface by default on Linux")
+	}
+	_, u := newUpgrader(t)
+	ta, err := New(u, &network.NullResourceManager{})
+	require.NoError(t, err)
+	l, err := ta.maListen(ma.StringCast("/ip4/127.0.0.1/tcp/0/ws"))
+	require.NoError(t, err)
+	defer l.Close()
+
+	tb, err := New(u, &network.NullResourceManager{}, WithDialSourceAddr(func(ma.Multiaddr) ma.Multiaddr {
+		return ma.StringCast("/ip4/127.0.0.2")
+	}))
+	require.NoError(t, err)
+	c, err := tb.maDial(context.Background(), l.Multiaddr())
+	require.NoError(t, err)
+	defer c.Close()
+
+	sc, err := l.Accept()
+	require.NoError(t, err)
+	defer sc.Close()
+	require.True(t, strings.HasPrefix(sc.RemoteMultiaddr().String(), "/ip4/127.0.0.2/tcp/"))
+
+	// addresses of the other IP family are ignored
+	require.Nil(t, tb.sourceIP(ma.StringCast("/ip6/::1/tcp/1234/ws")))
+	require.Nil(t, tb.sourceIP(ma.StringCast("/dns/example.com/tcp/1234/ws")))
+}
+
 func TestWriteZero(t *testing.T) {
 	_, u := newUpgrader(t)
 	tpt, err := New(u, &network.NullResourceManager{})
@@ -0,0 +1,421 @@
+package libp2pwebtransport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall/js"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	tpt "github.com/libp2p/go-libp2p/core/transport"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+)
+
+// When compiled to WebAssembly, the transport dials using the browser's
+// WebTransport API. The browser verifies the server's certificate against the
+// certificate hashes of the multiaddr, see
+// https://www.w3.org/TR/webtransport/#dom-webtransportoptions-servercertificatehashes.
+// Listening is not possible in the browser.
+
+var errConnectionClosed = errors.New("connection is closed")
+
+// ignorePromise is attached to the promises whose result we don't need, so
+// that rejections aren't reported as unhandled by the browser.
+var ignorePromise = js.FuncOf(func(js.Value, []js.Value) any { return nil })
+
+func (t *transport) dialConn(ctx context.Context, raddr ma.Multiaddr, url, _ string, p peer.ID, certHashes []multihash.DecodedMultihash, scope network.ConnManagementScope) (tpt.CapableConn, error) {
+	hashes := make([]any, 0, len(certHashes))
+	for _, h := range certHashes {
+		if h.Code != multihash.SHA2_256 {
+			// Browsers only support SHA-256 certificate hashes.
+			continue
+		}
+		value := js.Global().Get("Uint8Array").New(len(h.Digest))
+		js.CopyBytesToJS(value, h.Digest)
+		hashes = append(hashes, map[string]any{"algorithm": "sha-256", "value": value})
+	}
+	if len(hashes) == 0 {
+		return nil, errors.New("can't dial webtransport without SHA-256 certhashes in the browser")
+	}
+
+	// The browser resolves the host name itself, and uses it for SNI.
+	wt := js.Global().Get("WebTransport").New(url, map[string]any{"serverCertificateHashes": hashes})
+	c := newBrowserConn(t, wt, raddr, scope)
+	if _, err := awaitCtx(ctx, wt.Get("ready")); err != nil {
+		c.closeWithError(1)
+		return nil, err
+	}
+
+	str, err := c.openStream(ctx)
+	if err != nil {
+		c.closeWithError(1)
+		return nil, err
+	}
+	sconn, err := t.secureOutbound(ctx, str, p, certHashes)
+	str.Close()
+	if err != nil {
+		c.closeWithError(1)
+		return nil, err
+	}
+	c.connSecurityMultiaddrs = &connSecurityMultiaddrs{ConnSecurity: sconn, ConnMultiaddrs: c.addrs}
+	if t.gater != nil && !t.gater.InterceptSecured(network.DirOutbound, p, c.connSecurityMultiaddrs) {
+		c.closeWithError(uint32(errorCodeConnectionGating))
+		return nil, fmt.Errorf("secured connection gated")
+	}
+	return c, nil
+}
+
+// promiseResult is the outcome of a JavaScript promise.
+type promiseResult struct {
+	val js.Value
+	err error
+}
+
+// await returns a channel that receives the outcome of the promise p.
+func await(p js.Value) <-chan promiseResult {
+	ch := make(chan promiseResult, 1)
+	var onResolve, onReject js.Func
+	release := func() {
+		onResolve.Release()
+		onReject.Release()
+	}
+	onResolve = js.FuncOf(func(_ js.Value, args []js.Value) any {
+		ch <- promiseResult{val: firstArg(args)}
+		release()
+		return nil
+	})
+	onReject = js.FuncOf(func(_ js.Value, args []js.Value) any {
+		ch <- promiseResult{err: js.Error{Value: firstArg(args)}}
+		release()
+		return nil
+	})
+	p.Call("then", onResolve, onReject)
+	return ch
+}
+
+func awaitCtx(ctx context.Context, p js.Value) (js.Value, error) {
+	select {
+	case res := <-await(p):
+		return res.val, res.err
+	case <-ctx.Done():
+		return js.Undefined(), ctx.Err()
+	}
+}
+
+func firstArg(args []js.Value) js.Value {
+	if len(args) == 0 {
+		return js.Undefined()
+	}
+	return args[0]
+}
+
+// deadline is a deadline that can be changed while an operation waits for it.
+type deadline struct {
+	mx      sync.Mutex
+	t       time.Time
+	changed chan struct{}
+}
+
+func newDeadline() *deadline {
+	return &deadline{changed: make(chan struct{}, 1)}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mx.Lock()
+	d.t = t
+	d.mx.Unlock()
+	select {
+	case d.changed <- struct{}{}:
+	default:
+	}
+}
+
+// wait waits for the outcome of a promise, until the deadline expires.
+func (d *deadline) wait(ch <-chan promiseResult, closed <-chan struct{}) (promiseResult, error) {
+	for {
+		d.mx.Lock()
+		t := d.t
+		d.mx.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !t.IsZero() {
+			dur := time.Until(t)
+			if dur <= 0 {
+				return promiseResult{}, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(dur)
+			timeout = timer.C
+		}
+		var res promiseResult
+		var err error
+		done := true
+		select {
+		case res = <-ch:
+		case <-closed:
+			err = errConnectionClosed
+		case <-timeout:
+			err = os.ErrDeadlineExceeded
+		case <-d.changed:
+			done = false
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if done {
+			return res, err
+		}
+	}
+}
+
+// browserConn is a WebTransport session established by the browser.
+type browserConn struct {
+	*connSecurityMultiaddrs
+
+	transport *transport
+	wt        js.Value
+	addrs     *connMultiaddrs
+	scope     network.ConnManagementScope
+
+	// incoming is the reader of the bidirectional streams opened by the peer
+	incoming js.Value
+	// closed is closed when the session is closed
+	closed                          chan struct{}
+	closeOnce, doneOnce, closedOnce sync.Once
+}
+
+var _ tpt.CapableConn = &browserConn{}
+
+func newBrowserConn(t *transport, wt js.Value, raddr ma.Multiaddr, scope network.ConnManagementScope) *browserConn {
+	// The browser doesn't expose the local address.
+	local := ma.StringCast("/ip4/0.0.0.0/udp/0").Encapsulate(webtransportMA)
+	remote, _ := ma.SplitFunc(raddr, func(c ma.Component) bool { return c.Protocol().Code == ma.P_CERTHASH })
+	c := &browserConn{
+		transport: t,
+		wt:        wt,
+		addrs:     &connMultiaddrs{local: local, remote: remote},
+		scope:     scope,
+		incoming:  wt.Get("incomingBidirectionalStreams").Call("getReader"),
+		closed:    make(chan struct{}),
+	}
+	go func() {
+		<-await(wt.Get("closed"))
+		c.signalClosed()
+	}()
+	return c
+}
+
+func (c *browserConn) signalClosed() {
+	c.closedOnce.Do(func() { close(c.closed) })
+}
+
+func (c *browserConn) openStream(ctx context.Context) (*browserStream, error) {
+	select {
+	case res := <-await(c.wt.Call("createBidirectionalStream")):
+		if res.err != nil {
+			return nil, res.err
+		}
+		return newBrowserStream(c, res.val), nil
+	case <-c.closed:
+		return nil, errConnectionClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *browserConn) OpenStream(ctx context.Context) (network.MuxedStream, error) {
+	return c.openStream(ctx)
+}
+
+func (c *browserConn) AcceptStream() (network.MuxedStream, error) {
+	select {
+	case res := <-await(c.incoming.Call("read")):
+		if res.err != nil {
+			return nil, res.err
+		}
+		if res.val.Get("done").Bool() {
+			return nil, errConnectionClosed
+		}
+		return newBrowserStream(c, res.val.Get("value")), nil
+	case <-c.closed:
+		return nil, errConnectionClosed
+	}
+}
+
+// closeWithError closes the session. Unlike Close, it doesn't release the
+// scope, which is released by Dial if dialing fails.
+func (c *browserConn) closeWithError(code uint32) {
+	c.closeOnce.Do(func() {
+		c.wt.Call("close", map[string]any{"closeCode": code, "reason": ""})
+		c.signalClosed()
+	})
+}
+
+// Close closes the WebTransport session.
+func (c *browserConn) Close() error {
+	c.closeWithError(0)
+	c.doneOnce.Do(c.scope.Done)
+	return nil
+}
+
+func (c *browserConn) IsClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *browserConn) Scope() network.ConnScope { return c.scope }
+func (c *browserConn) Transport() tpt.Transport { return c.transport }
+
+// ConnState returns the state of the connection. The browser doesn't expose
+// the details of the TLS session.
+func (c *browserConn) ConnState() network.ConnectionState {
+	return network.ConnectionState{Transport: "webtransport"}
+}
+
+// browserStream is a bidirectional stream of a browserConn. It implements
+// net.Conn, as required for the Noise handshake on the first stream.
+type browserStream struct {
+	conn           *browserConn
+	reader, writer js.Value
+
+	readDeadline, writeDeadline *deadline
+
+	readMx sync.Mutex
+	buf    []byte
+	eof    bool
+	// pendingRead is the result of a read whose deadline expired
+	pendingRead <-chan promiseResult
+
+	writeMx sync.Mutex
+
+	closeReadOnce, closeWriteOnce sync.Once
+}
+
+var (
+	_ network.MuxedStream = &browserStream{}
+	_ net.Conn            = &browserStream{}
+)
+
+func newBrowserStream(c *browserConn, str js.Value) *browserStream {
+	return &browserStream{
+		conn:          c,
+		reader:        str.Get("readable").Call("getReader"),
+		writer:        str.Get("writable").Call("getWriter"),
+		readDeadline:  newDeadline(),
+		writeDeadline: newDeadline(),
+	}
+}
+
+func (s *browserStream) Read(b []byte) (int, error) {
+	s.readMx.Lock()
+	defer s.readMx.Unlock()
+
+	if len(s.buf) == 0 {
+		if s.eof {
+			return 0, io.EOF
+		}
+		if s.pendingRead == nil {
+			s.pendingRead = await(s.reader.Call("read"))
+		}
+		res, err := s.readDeadline.wait(s.pendingRead, s.conn.closed)
+		if err != nil {
+			return 0, err
+		}
+		s.pendingRead = nil
+		if res.err != nil {
+			return 0, network.ErrReset
+		}
+		if res.val.Get("done").Bool() {
+			s.eof = true
+			return 0, io.EOF
+		}
+		value := res.val.Get("value")
+		s.buf = make([]byte, value.Length())
+		js.CopyBytesToGo(s.buf, value)
+	}
+	n := copy(b, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// Write hands b to the browser, once the browser's send buffer has room for
+// it.
+func (s *browserStream) Write(b []byte) (int, error) {
+	s.writeMx.Lock()
+	defer s.writeMx.Unlock()
+
+	res, err := s.writeDeadline.wait(await(s.writer.Get("ready")), s.conn.closed)
+	if err != nil {
+		return 0, err
+	}
+	if res.err != nil {
+		return 0, network.ErrReset
+	}
+	arr := js.Global().Get("Uint8Array").New(len(b))
+	js.CopyBytesToJS(arr, b)
+	s.writer.Call("write", arr).Call("catch", ignorePromise)
+	return len(b), nil
+}
+
+func (s *browserStream) CloseRead() error {
+	s.closeReadOnce.Do(func() {
+		s.reader.Call("cancel").Call("catch", ignorePromise)
+	})
+	return nil
+}
+
+func (s *browserStream) CloseWrite() error {
+	s.closeWriteOnce.Do(func() {
+		s.writer.Call("close").Call("catch", ignorePromise)
+	})
+	return nil
+}
+
+func (s *browserStream) Close() error {
+	s.CloseRead()
+	return s.CloseWrite()
+}
+
+func (s *browserStream) Reset() error {
+	s.CloseRead()
+	s.closeWriteOnce.Do(func() {
+		s.writer.Call("abort").Call("catch", ignorePromise)
+	})
+	return nil
+}
+
+func (s *browserStream) SetDeadline(t time.Time) error {
+	s.SetReadDeadline(t)
+	return s.SetWriteDeadline(t)
+}
+
+func (s *browserStream) SetReadDeadline(t time.Time) error {
+	s.readDeadline.set(t)
+	return nil
+}
+
+func (s *browserStream) SetWriteDeadline(t time.Time) error {
+	s.writeDeadline.set(t)
+	return nil
+}
+
+func (s *browserStream) LocalAddr() net.Addr  { return browserAddr(s.conn.addrs.local.String()) }
+func (s *browserStream) RemoteAddr() net.Addr { return browserAddr(s.conn.addrs.remote.String()) }
+
+// browserAddr is the address of a browserConn.
+type browserAddr string
+
+func (a browserAddr) Network() string { return "webtransport" }
+func (a browserAddr) String() string  { return string(a) }
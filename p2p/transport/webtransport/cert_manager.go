@@ -11,6 +11,7 @@ import (
 
 	"github.com/benbjohnson/clock"
 	ic "github.com/libp2p/go-libp2p/core/crypto"
+	libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
 	ma "github.com/multiformats/go-multiaddr"
 	"github.com/multiformats/go-multihash"
 )
@@ -24,11 +25,20 @@ const validityMinusTwoSkew = certValidity - (2 * clockSkewAllowance)
 type certConfig struct {
 	tlsConf *tls.Config
 	sha256  [32]byte // cached from the tlsConf
+
+	unregisterTicketKeys func() // set while the config uses the session ticket key manager
 }
 
 func (c *certConfig) Start() time.Time { return c.tlsConf.Certificates[0].Leaf.NotBefore }
 func (c *certConfig) End() time.Time   { return c.tlsConf.Certificates[0].Leaf.NotAfter }
 
+func (c *certConfig) stopTicketKeys() {
+	if c != nil && c.unregisterTicketKeys != nil {
+		c.unregisterTicketKeys()
+		c.unregisterTicketKeys = nil
+	}
+}
+
 func newCertConfig(key ic.PrivKey, start, end time.Time) (*certConfig, error) {
 	conf, err := getTLSConf(key, start, end)
 	if err != nil {
@@ -40,11 +50,27 @@ func newCertConfig(key ic.PrivKey, start, end time.Time) (*certConfig, error) {
 	}, nil
 }
 
+// CertHash describes a certificate used by the WebTransport listeners.
+type CertHash struct {
+	// Multihash is the SHA-256 multihash of the certificate, as used in /certhash.
+	Multihash multihash.Multihash
+	NotBefore time.Time
+	NotAfter  time.Time
+	// Advertised is true if the hash is part of the listen addresses. A hash
+	// stops being advertised one hour before NotAfter, when the next
+	// certificate is generated. Connections that pin a hash that isn't
+	// advertised anymore are still accepted as long as the listener knows it.
+	Advertised bool
+}
+
 // Certificate renewal logic:
 //  1. On startup, we generate one cert that is valid from now (-1h, to allow for clock skew), and another
 //     cert that is valid from the expiry date of the first certificate (again, with allowance for clock skew).
 //  2. Once we reach 1h before expiry of the first certificate, we switch over to the second certificate.
 //     At the same time, we stop advertising the certhash of the first cert and generate the next cert.
+//  3. The certhash of the first cert is still sent to clients during the handshake, so that clients
+//     that dial an address advertised before the switch (containing the hashes of the first and
+//     second cert) can connect. This also holds after a restart, since certs are deterministic.
 type certManager struct {
 	clock     clock.Clock
 	ctx       context.Context
@@ -57,11 +83,14 @@ type certManager struct {
 	nextConfig    *certConfig // nil until we have passed half the certValidity of the current config
 	addrComp      ma.Multiaddr
 
+	// ticketKeys manages the session ticket keys of the current config, if set
+	ticketKeys *libp2ptls.SessionTicketKeyManager
+
 	serializedCertHashes [][]byte
 }
 
-func newCertManager(hostKey ic.PrivKey, clock clock.Clock) (*certManager, error) {
-	m := &certManager{clock: clock}
+func newCertManager(hostKey ic.PrivKey, clock clock.Clock, ticketKeys *libp2ptls.SessionTicketKeyManager) (*certManager, error) {
+	m := &certManager{clock: clock, ticketKeys: ticketKeys}
 	m.ctx, m.ctxCancel = context.WithCancel(context.Background())
 	if err := m.init(hostKey); err != nil {
 		return nil, err
@@ -100,6 +129,13 @@ func (m *certManager) init(hostKey ic.PrivKey) error {
 	// We want the certificate have been valid for at least one clockSkewAllowance
 	start = start.Add(-clockSkewAllowance)
 	startTime := getCurrentBucketStartTime(start, offset)
+	// Clients might still use an address containing the hash of the previous
+	// certificate. rollConfig moves it to lastConfig.
+	lastStart := startTime.Add(-validityMinusTwoSkew)
+	m.currentConfig, err = newCertConfig(hostKey, lastStart, lastStart.Add(certValidity))
+	if err != nil {
+		return err
+	}
 	m.nextConfig, err = newCertConfig(hostKey, startTime, startTime.Add(certValidity))
 	if err != nil {
 		return err
@@ -118,6 +154,11 @@ func (m *certManager) rollConfig(hostKey ic.PrivKey) error {
 	m.lastConfig = m.currentConfig
 	m.currentConfig = m.nextConfig
 	m.nextConfig = c
+	if m.ticketKeys != nil {
+		// Only the current config is used for handshakes.
+		m.lastConfig.stopTicketKeys()
+		m.currentConfig.unregisterTicketKeys = m.ticketKeys.Register(m.currentConfig.tlsConf)
+	}
 	if err := m.cacheSerializedCertHashes(); err != nil {
 		return err
 	}
@@ -166,9 +207,36 @@ func (m *certManager) AddrComponent() ma.Multiaddr {
 }
 
 func (m *certManager) SerializedCertHashes() [][]byte {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
 	return m.serializedCertHashes
 }
 
+// CertHashes returns the hashes of all certificates the listeners accept
+// connections for, ordered by NotBefore.
+func (m *certManager) CertHashes() []CertHash {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+
+	hashes := make([]CertHash, 0, 3)
+	for _, c := range []*certConfig{m.lastConfig, m.currentConfig, m.nextConfig} {
+		if c == nil {
+			continue
+		}
+		h, err := multihash.Encode(c.sha256[:], multihash.SHA2_256)
+		if err != nil {
+			continue // can't happen, the hash was already encoded by cacheSerializedCertHashes
+		}
+		hashes = append(hashes, CertHash{
+			Multihash:  h,
+			NotBefore:  c.Start(),
+			NotAfter:   c.End(),
+			Advertised: c != m.lastConfig,
+		})
+	}
+	return hashes
+}
+
 func (m *certManager) cacheSerializedCertHashes() error {
 	hashes := make([][32]byte, 0, 3)
 	if m.lastConfig != nil {
@@ -179,14 +247,16 @@ func (m *certManager) cacheSerializedCertHashes() error {
 		hashes = append(hashes, m.nextConfig.sha256)
 	}
 
-	m.serializedCertHashes = m.serializedCertHashes[:0]
+	// Allocate a new slice: SerializedCertHashes hands out the old one.
+	serialized := make([][]byte, 0, len(hashes))
 	for _, certHash := range hashes {
 		h, err := multihash.Encode(certHash[:], multihash.SHA2_256)
 		if err != nil {
 			return fmt.Errorf("failed to encode certificate hash: %w", err)
 		}
-		m.serializedCertHashes = append(m.serializedCertHashes, h)
+		serialized = append(serialized, h)
 	}
+	m.serializedCertHashes = serialized
 	return nil
 }
 
@@ -209,5 +279,8 @@ func (m *certManager) cacheAddrComponent() error {
 func (m *certManager) Close() error {
 	m.ctxCancel()
 	m.refCount.Wait()
+	m.mx.Lock()
+	m.currentConfig.stopTicketKeys()
+	m.mx.Unlock()
 	return nil
 }
@@ -10,6 +10,7 @@ import (
 
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/test"
+	libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
 
 	"github.com/benbjohnson/clock"
 	ma "github.com/multiformats/go-multiaddr"
@@ -46,7 +47,7 @@ func TestInitialCert(t *testing.T) {
 	cl.Add(1234567 * time.Hour)
 	priv, _, err := test.RandTestKeyPair(crypto.Ed25519, 256)
 	require.NoError(t, err)
-	m, err := newCertManager(priv, cl)
+	m, err := newCertManager(priv, cl, nil)
 	require.NoError(t, err)
 	defer m.Close()
 
@@ -70,7 +71,7 @@ func TestCertRenewal(t *testing.T) {
 	cl.Add(time.Hour * 24 * 365)
 	priv, _, err := test.SeededTestKeyPair(crypto.Ed25519, 256, 0)
 	require.NoError(t, err)
-	m, err := newCertManager(priv, cl)
+	m, err := newCertManager(priv, cl, nil)
 	require.NoError(t, err)
 	defer m.Close()
 
@@ -112,6 +113,98 @@ func TestCertRenewal(t *testing.T) {
 	require.Equal(t, second[1].Value(), third[0].Value())
 }
 
+// requireAccepts checks that a client dialing an address with the hashes in
+// addrComp can connect: the certificate presented by the server matches one of
+// the hashes, and the server sends all the hashes in the handshake.
+func requireAccepts(t *testing.T, m *certManager, addrComp ma.Multiaddr) {
+	t.Helper()
+	presented := certificateHashFromTLSConfig(m.GetConfig())
+	var found bool
+	for _, c := range splitMultiaddr(addrComp) {
+		h := certHashFromComponent(t, c)
+		if string(h) == string(presented[:]) {
+			found = true
+		}
+		var sent bool
+		for _, b := range m.SerializedCertHashes() {
+			mh, err := multihash.Decode(b)
+			require.NoError(t, err)
+			if string(mh.Digest) == string(h) {
+				sent = true
+			}
+		}
+		require.True(t, sent, "hash not sent during handshake")
+	}
+	require.True(t, found, "presented certificate not pinned")
+}
+
+func TestCertRotationOverlap(t *testing.T) {
+	cl := clock.NewMock()
+	cl.Add(time.Hour * 24 * 365)
+	priv, _, err := test.SeededTestKeyPair(crypto.Ed25519, 256, 0)
+	require.NoError(t, err)
+	m, err := newCertManager(priv, cl, nil)
+	require.NoError(t, err)
+	defer m.Close()
+
+	hashes := m.CertHashes()
+	require.Len(t, hashes, 3)
+	require.False(t, hashes[0].Advertised)
+	require.True(t, hashes[1].Advertised)
+	require.True(t, hashes[2].Advertised)
+	require.Equal(t, m.currentConfig.End(), hashes[1].NotAfter)
+	require.True(t, hashes[1].NotBefore.Before(hashes[2].NotBefore))
+
+	oldAddr := m.AddrComponent()
+	requireAccepts(t, m, oldAddr)
+
+	cl.Set(m.currentConfig.End().Add(-clockSkewAllowance + time.Second))
+	require.Eventually(t, func() bool { return !m.AddrComponent().Equal(oldAddr) }, 200*time.Millisecond, 10*time.Millisecond)
+	newAddr := m.AddrComponent()
+	requireAccepts(t, m, oldAddr)
+	requireAccepts(t, m, newAddr)
+	rotated := m.CertHashes()
+	require.Equal(t, hashes[1].Multihash, rotated[0].Multihash)
+	require.False(t, rotated[0].Advertised)
+	require.Equal(t, hashes[2], rotated[1])
+
+	// after a reboot, clients using the old address can still connect
+	m.Close()
+	m, err = newCertManager(priv, cl, nil)
+	require.NoError(t, err)
+	defer m.Close()
+	require.True(t, m.AddrComponent().Equal(newAddr))
+	requireAccepts(t, m, oldAddr)
+	requireAccepts(t, m, newAddr)
+}
+
+func TestCertManagerSessionTicketKeys(t *testing.T) {
+	cl := clock.NewMock()
+	cl.Add(time.Hour * 24 * 365)
+	priv, _, err := test.SeededTestKeyPair(crypto.Ed25519, 256, 0)
+	require.NoError(t, err)
+	keys, err := libp2ptls.NewSessionTicketKeyManager()
+	require.NoError(t, err)
+	defer keys.Close()
+	m, err := newCertManager(priv, cl, keys)
+	require.NoError(t, err)
+	defer m.Close()
+
+	m.mx.RLock()
+	first := m.currentConfig
+	require.NotNil(t, first.unregisterTicketKeys)
+	require.Nil(t, m.lastConfig.unregisterTicketKeys)
+	m.mx.RUnlock()
+
+	// only the current config uses the key manager
+	cl.Set(first.End().Add(-clockSkewAllowance + time.Second))
+	require.Eventually(t, func() bool { return m.GetConfig() != first.tlsConf }, 200*time.Millisecond, 10*time.Millisecond)
+	m.mx.RLock()
+	require.Nil(t, first.unregisterTicketKeys)
+	require.NotNil(t, m.currentConfig.unregisterTicketKeys)
+	m.mx.RUnlock()
+}
+
 func TestDeterministicCertsAcrossReboots(t *testing.T) {
 	// Run this test 100 times to make sure it's deterministic
 	runs := 100
@@ -120,7 +213,7 @@ func TestDeterministicCertsAcrossReboots(t *testing.T) {
 			cl := clock.NewMock()
 			priv, _, err := test.SeededTestKeyPair(crypto.Ed25519, 256, 0)
 			require.NoError(t, err)
-			m, err := newCertManager(priv, cl)
+			m, err := newCertManager(priv, cl, nil)
 			require.NoError(t, err)
 			defer m.Close()
 
@@ -132,7 +225,7 @@ func TestDeterministicCertsAcrossReboots(t *testing.T) {
 
 			cl.Add(time.Hour)
 			// reboot
-			m, err = newCertManager(priv, cl)
+			m, err = newCertManager(priv, cl, nil)
 			require.NoError(t, err)
 			defer m.Close()
 
@@ -2,9 +2,11 @@ package libp2pwebtransport
 
 import (
 	"context"
+	"crypto/tls"
 
 	"github.com/libp2p/go-libp2p/core/network"
 	tpt "github.com/libp2p/go-libp2p/core/transport"
+	p2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
 
 	ma "github.com/multiformats/go-multiaddr"
 	"github.com/quic-go/webtransport-go"
@@ -29,17 +31,23 @@ type conn struct {
 
 	transport *transport
 	session   *webtransport.Session
+	tlsState  *network.TLSConnectionState
 
 	scope network.ConnManagementScope
 }
 
 var _ tpt.CapableConn = &conn{}
 
-func newConn(tr *transport, sess *webtransport.Session, sconn *connSecurityMultiaddrs, scope network.ConnManagementScope) *conn {
+func newConn(tr *transport, sess *webtransport.Session, sconn *connSecurityMultiaddrs, cs *tls.ConnectionState, scope network.ConnManagementScope) *conn {
+	var tlsState *network.TLSConnectionState
+	if cs != nil {
+		tlsState = p2ptls.NewTLSConnectionState(*cs)
+	}
 	return &conn{
 		connSecurityMultiaddrs: sconn,
 		transport:              tr,
 		session:                sess,
+		tlsState:               tlsState,
 		scope:                  scope,
 	}
 }
@@ -78,5 +86,5 @@ func (c *conn) Scope() network.ConnScope { return c.scope }
 func (c *conn) Transport() tpt.Transport { return c.transport }
 
 func (c *conn) ConnState() network.ConnectionState {
-	return network.ConnectionState{Transport: "webtransport"}
+	return network.ConnectionState{Transport: "webtransport", TLS: c.tlsState}
 }
@@ -18,6 +18,7 @@ import (
 	"golang.org/x/crypto/hkdf"
 
 	ic "github.com/libp2p/go-libp2p/core/crypto"
+	tpt "github.com/libp2p/go-libp2p/core/transport"
 
 	"github.com/multiformats/go-multihash"
 	"github.com/quic-go/quic-go/http3"
@@ -86,7 +87,33 @@ func generateCert(key ic.PrivKey, start, end time.Time) (*x509.Certificate, *ecd
 	return ca, caPrivateKey, nil
 }
 
-func verifyRawCerts(rawCerts [][]byte, certHashes []multihash.DecodedMultihash) error {
+// CertValidityError is returned when dialing a peer whose certificate matches
+// the /certhash, but isn't valid at the local time. This usually means that
+// the clock of one of the peers is wrong.
+type CertValidityError struct {
+	NotBefore, NotAfter time.Time
+	// Now is the local time at which the certificate was verified.
+	Now time.Time
+}
+
+var _ tpt.ClockSkewError = &CertValidityError{}
+
+func (e *CertValidityError) Error() string {
+	return fmt.Sprintf("cert not valid (NotBefore: %s, NotAfter: %s, local time: %s)", e.NotBefore, e.NotAfter, e.Now)
+}
+
+// ClockSkew implements transport.ClockSkewError.
+func (e *CertValidityError) ClockSkew() time.Duration {
+	if e.Now.Before(e.NotBefore) {
+		return e.Now.Sub(e.NotBefore)
+	}
+	return e.Now.Sub(e.NotAfter)
+}
+
+// verifyRawCerts verifies the certificate chain against the certificate
+// hashes. Certificates that are not valid at now are accepted if the clock
+// skew that would explain it is at most skewTolerance.
+func verifyRawCerts(rawCerts [][]byte, certHashes []multihash.DecodedMultihash, now time.Time, skewTolerance time.Duration) error {
 	if len(rawCerts) < 1 {
 		return errors.New("no cert")
 	}
@@ -120,9 +147,13 @@ func verifyRawCerts(rawCerts [][]byte, certHashes []multihash.DecodedMultihash)
 	if l := cert.NotAfter.Sub(cert.NotBefore); l > 14*24*time.Hour {
 		return fmt.Errorf("cert must not be valid for longer than 14 days (NotBefore: %s, NotAfter: %s, Length: %s)", cert.NotBefore, cert.NotAfter, l)
 	}
-	now := time.Now()
 	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
-		return fmt.Errorf("cert not valid (NotBefore: %s, NotAfter: %s)", cert.NotBefore, cert.NotAfter)
+		err := &CertValidityError{NotBefore: cert.NotBefore, NotAfter: cert.NotAfter, Now: now}
+		skew := err.ClockSkew()
+		if skew.Abs() > skewTolerance {
+			return err
+		}
+		log.Warnf("accepting cert outside of its validity period, assuming a clock skew of %s", skew)
 	}
 	return nil
 }
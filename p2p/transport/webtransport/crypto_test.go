@@ -64,7 +64,7 @@ func TestCertificateVerification(t *testing.T) {
 
 	t.Run("accepting a valid cert", func(t *testing.T) {
 		validCert := generateCertWithKey(t, ecdsaKey, now, now.Add(14*24*time.Hour))
-		require.NoError(t, verifyRawCerts([][]byte{validCert.Raw}, []multihash.DecodedMultihash{sha256Multihash(t, validCert.Raw)}))
+		require.NoError(t, verifyRawCerts([][]byte{validCert.Raw}, []multihash.DecodedMultihash{sha256Multihash(t, validCert.Raw)}, now, 0))
 	})
 
 	for _, tc := range [...]struct {
@@ -95,7 +95,7 @@ func TestCertificateVerification(t *testing.T) {
 	} {
 		tc := tc
 		t.Run(fmt.Sprintf("rejecting invalid certificates: %s", tc.name), func(t *testing.T) {
-			err := verifyRawCerts([][]byte{tc.cert.Raw}, []multihash.DecodedMultihash{sha256Multihash(t, tc.cert.Raw)})
+			err := verifyRawCerts([][]byte{tc.cert.Raw}, []multihash.DecodedMultihash{sha256Multihash(t, tc.cert.Raw)}, now, 0)
 			require.Error(t, err)
 			require.Contains(t, err.Error(), tc.errStr)
 		})
@@ -127,7 +127,7 @@ func TestCertificateVerification(t *testing.T) {
 	} {
 		tc := tc
 		t.Run(fmt.Sprintf("rejecting invalid certificates: %s", tc.name), func(t *testing.T) {
-			err := verifyRawCerts(tc.certs, tc.hashes)
+			err := verifyRawCerts(tc.certs, tc.hashes, now, 0)
 			require.Error(t, err)
 			require.Contains(t, err.Error(), tc.errStr)
 		})
@@ -194,3 +194,25 @@ func TestDeterministicSig(t *testing.T) {
 		require.Equal(t, keyBytes, keyBytes2)
 	}
 }
+
+func TestCertificateVerificationClockSkew(t *testing.T) {
+	now := time.Now()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	cert := generateCertWithKey(t, key, now.Add(2*time.Hour), now.Add(2*time.Hour+14*24*time.Hour))
+	hashes := []multihash.DecodedMultihash{sha256Multihash(t, cert.Raw)}
+
+	err = verifyRawCerts([][]byte{cert.Raw}, hashes, now, time.Hour)
+	var cerr *CertValidityError
+	require.ErrorAs(t, err, &cerr)
+	require.Equal(t, now.Sub(cert.NotBefore), cerr.ClockSkew())
+	require.Less(t, cerr.ClockSkew(), -time.Hour)
+
+	require.NoError(t, verifyRawCerts([][]byte{cert.Raw}, hashes, now, 3*time.Hour))
+
+	// local clock ahead
+	later := cert.NotAfter.Add(time.Minute)
+	err = verifyRawCerts([][]byte{cert.Raw}, hashes, later, 0)
+	require.ErrorAs(t, err, &cerr)
+	require.Equal(t, time.Minute, cerr.ClockSkew())
+}
@@ -0,0 +1,35 @@
+//go:build !js
+
+package libp2pwebtransport
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	tpt "github.com/libp2p/go-libp2p/core/transport"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+)
+
+func (t *transport) dialConn(ctx context.Context, raddr ma.Multiaddr, url, sni string, p peer.ID, certHashes []multihash.DecodedMultihash, scope network.ConnManagementScope) (tpt.CapableConn, error) {
+	maddr, _ := ma.SplitFunc(raddr, func(c ma.Component) bool { return c.Protocol().Code == ma.P_WEBTRANSPORT })
+	sess, tlsState, err := t.dial(ctx, maddr, url, sni, certHashes)
+	if err != nil {
+		return nil, err
+	}
+	sconn, err := t.upgrade(ctx, sess, p, certHashes)
+	if err != nil {
+		sess.CloseWithError(1, "")
+		return nil, err
+	}
+	if t.gater != nil && !t.gater.InterceptSecured(network.DirOutbound, p, sconn) {
+		sess.CloseWithError(errorCodeConnectionGating, "")
+		return nil, fmt.Errorf("secured connection gated")
+	}
+	conn := newConn(t, sess, sconn, tlsState, scope)
+	t.addConn(sess, conn)
+	return conn, nil
+}
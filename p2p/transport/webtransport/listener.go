@@ -137,7 +137,7 @@ func (l *listener) httpHandlerWithConnScope(w http.ResponseWriter, r *http.Reque
 		return err
 	}
 
-	conn := newConn(l.transport, sess, sconn, connScope)
+	conn := newConn(l.transport, sess, sconn, r.TLS, connScope)
 	l.transport.addConn(sess, conn)
 	select {
 	case l.queue <- conn:
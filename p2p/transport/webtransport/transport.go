@@ -8,6 +8,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -18,8 +20,10 @@ import (
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/pnet"
 	tpt "github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/security/membership"
 	"github.com/libp2p/go-libp2p/p2p/security/noise"
 	"github.com/libp2p/go-libp2p/p2p/security/noise/pb"
+	libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
 	"github.com/libp2p/go-libp2p/p2p/transport/quicreuse"
 
 	"github.com/benbjohnson/clock"
@@ -60,11 +64,126 @@ func WithTLSClientConfig(c *tls.Config) Option {
 	}
 }
 
+// WithSessionTicketKeys makes the listeners encrypt TLS session tickets with
+// the keys managed by m, instead of keys generated for every certificate. This
+// allows rotating the keys on a schedule, or forcing a rotation if a key might
+// have been compromised. The same manager can be shared with other transports.
+func WithSessionTicketKeys(m *libp2ptls.SessionTicketKeyManager) Option {
+	return func(t *transport) error {
+		t.ticketKeys = m
+		return nil
+	}
+}
+
+// WithClockSkewTolerance makes the transport accept certificates of dialed
+// peers that aren't valid at the local time, as long as a clock skew of at
+// most d explains it. The certificate still has to match the /certhash of the
+// address. This is meant for devices whose clock can't be relied upon, at the
+// cost of accepting certificates that should have been rotated already.
+//
+// By default, such dials fail with a *CertValidityError.
+func WithClockSkewTolerance(d time.Duration) Option {
+	return func(t *transport) error {
+		if d < 0 {
+			return errors.New("clock skew tolerance must not be negative")
+		}
+		t.clockSkewTolerance = d
+		return nil
+	}
+}
+
+// WithMaxIncomingStreams sets the maximum number of concurrent bidirectional
+// streams a peer may open on a WebTransport connection.
+func WithMaxIncomingStreams(n int64) Option {
+	return func(t *transport) error {
+		if n <= 0 {
+			return errors.New("max incoming streams must be positive")
+		}
+		t.quicTuning.maxIncomingStreams = n
+		return nil
+	}
+}
+
+// WithStreamReceiveWindow sets the maximum flow control window of a stream.
+func WithStreamReceiveWindow(size uint64) Option {
+	return func(t *transport) error {
+		if size == 0 {
+			return errors.New("stream receive window must be positive")
+		}
+		t.quicTuning.maxStreamReceiveWindow = size
+		return nil
+	}
+}
+
+// WithConnectionReceiveWindow sets the maximum flow control window of a
+// connection. Window increases are still subject to the resource manager.
+func WithConnectionReceiveWindow(size uint64) Option {
+	return func(t *transport) error {
+		if size == 0 {
+			return errors.New("connection receive window must be positive")
+		}
+		t.quicTuning.maxConnectionReceiveWindow = size
+		return nil
+	}
+}
+
+// WithIdleTimeout sets the time after which an idle connection is closed.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(t *transport) error {
+		if d <= 0 {
+			return errors.New("idle timeout must be positive")
+		}
+		t.quicTuning.maxIdleTimeout = d
+		return nil
+	}
+}
+
+// WithMembership requires remote peers to present a certificate of the
+// network of m in the Noise handshake, like the WithMembership option of the
+// Noise security transport.
+func WithMembership(m *membership.Membership) Option {
+	return func(t *transport) error {
+		t.membership = m
+		return nil
+	}
+}
+
+// quicTuning holds the QUIC settings that can be set per transport. Zero
+// values keep the settings of the quicreuse.ConnManager. A tuned transport
+// can't share its UDP ports with the QUIC transport, so it doesn't report
+// CapSharedUDPPort.
+type quicTuning struct {
+	maxIncomingStreams         int64
+	maxStreamReceiveWindow     uint64
+	maxConnectionReceiveWindow uint64
+	maxIdleTimeout             time.Duration
+}
+
+// apply returns a copy of conf with the tuning applied.
+func (q quicTuning) apply(conf *quic.Config) *quic.Config {
+	conf = conf.Clone()
+	if q.maxIncomingStreams > 0 {
+		conf.MaxIncomingStreams = q.maxIncomingStreams
+	}
+	if q.maxStreamReceiveWindow > 0 {
+		conf.MaxStreamReceiveWindow = q.maxStreamReceiveWindow
+	}
+	if q.maxConnectionReceiveWindow > 0 {
+		conf.MaxConnectionReceiveWindow = q.maxConnectionReceiveWindow
+	}
+	if q.maxIdleTimeout > 0 {
+		conf.MaxIdleTimeout = q.maxIdleTimeout
+	}
+	return conf
+}
+
 type transport struct {
 	privKey ic.PrivKey
 	pid     peer.ID
 	clock   clock.Clock
 
+	clockSkewTolerance time.Duration
+
 	connManager *quicreuse.ConnManager
 	rcmgr       network.ResourceManager
 	gater       connmgr.ConnectionGater
@@ -75,8 +194,16 @@ type transport struct {
 	hasCertManager atomic.Bool // set to true once the certManager is initialized
 	staticTLSConf  *tls.Config
 	tlsClientConf  *tls.Config
+	ticketKeys     *libp2ptls.SessionTicketKeyManager
+
+	// QUIC tuning for WebTransport connections. Both configs are nil if no
+	// tuning option was used, in which case the ConnManager's configs are used.
+	quicTuning       quicTuning
+	quicClientConfig *quic.Config
+	quicServerConfig *quic.Config
 
-	noise *noise.Transport
+	noise      *noise.Transport
+	membership *membership.Membership
 
 	connMx sync.Mutex
 	conns  map[uint64]*conn // using quic-go's ConnectionTracingKey as map key
@@ -112,7 +239,17 @@ func New(key ic.PrivKey, psk pnet.PSK, connManager *quicreuse.ConnManager, gater
 			return nil, err
 		}
 	}
-	n, err := noise.New(noise.ID, key, nil)
+	if t.quicTuning != (quicTuning{}) {
+		// Browser-facing endpoints usually need different settings than the
+		// server-to-server links served by the QUIC transport.
+		t.quicClientConfig = t.quicTuning.apply(connManager.ClientConfig())
+		t.quicServerConfig = t.quicTuning.apply(connManager.ServerConfig())
+	}
+	var noiseOpts []noise.Option
+	if t.membership != nil {
+		noiseOpts = append(noiseOpts, noise.WithMembership(t.membership))
+	}
+	n, err := noise.New(noise.ID, key, nil, noiseOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -158,26 +295,10 @@ func (t *transport) dialWithScope(ctx context.Context, raddr ma.Multiaddr, p pee
 		return nil, err
 	}
 
-	maddr, _ := ma.SplitFunc(raddr, func(c ma.Component) bool { return c.Protocol().Code == ma.P_WEBTRANSPORT })
-	sess, err := t.dial(ctx, maddr, url, sni, certHashes)
-	if err != nil {
-		return nil, err
-	}
-	sconn, err := t.upgrade(ctx, sess, p, certHashes)
-	if err != nil {
-		sess.CloseWithError(1, "")
-		return nil, err
-	}
-	if t.gater != nil && !t.gater.InterceptSecured(network.DirOutbound, p, sconn) {
-		sess.CloseWithError(errorCodeConnectionGating, "")
-		return nil, fmt.Errorf("secured connection gated")
-	}
-	conn := newConn(t, sess, sconn, scope)
-	t.addConn(sess, conn)
-	return conn, nil
+	return t.dialConn(ctx, raddr, url, sni, p, certHashes, scope)
 }
 
-func (t *transport) dial(ctx context.Context, addr ma.Multiaddr, url, sni string, certHashes []multihash.DecodedMultihash) (*webtransport.Session, error) {
+func (t *transport) dial(ctx context.Context, addr ma.Multiaddr, url, sni string, certHashes []multihash.DecodedMultihash) (*webtransport.Session, *tls.ConnectionState, error) {
 	var tlsConf *tls.Config
 	if t.tlsClientConf != nil {
 		tlsConf = t.tlsClientConf.Clone()
@@ -190,34 +311,48 @@ func (t *transport) dial(ctx context.Context, addr ma.Multiaddr, url, sni string
 		tlsConf.ServerName = sni
 	}
 
+	var validityErr atomic.Pointer[CertValidityError]
 	if len(certHashes) > 0 {
 		// This is not insecure. We verify the certificate ourselves.
 		// See https://www.w3.org/TR/webtransport/#certificate-hashes.
 		tlsConf.InsecureSkipVerify = true
 		tlsConf.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
-			return verifyRawCerts(rawCerts, certHashes)
+			err := verifyRawCerts(rawCerts, certHashes, t.clock.Now(), t.clockSkewTolerance)
+			if cerr, ok := err.(*CertValidityError); ok {
+				validityErr.Store(cerr)
+			}
+			return err
 		}
 	}
-	conn, err := t.connManager.DialQUIC(ctx, addr, tlsConf, t.allowWindowIncrease)
+	conn, err := t.connManager.DialQUICWithConfig(ctx, addr, tlsConf, t.quicClientConfig, t.allowWindowIncrease)
 	if err != nil {
-		return nil, err
+		// The error returned by quic-go only contains the message of the
+		// TLS alert, so return the original error.
+		if cerr := validityErr.Load(); cerr != nil {
+			return nil, nil, cerr
+		}
+		return nil, nil, err
+	}
+	quicConf := t.quicClientConfig
+	if quicConf == nil {
+		quicConf = t.connManager.ClientConfig()
 	}
 	dialer := webtransport.Dialer{
 		RoundTripper: &http3.RoundTripper{
 			Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
 				return conn.(quic.EarlyConnection), nil
 			},
-			QuicConfig: t.connManager.ClientConfig().Clone(),
+			QuicConfig: quicConf.Clone(),
 		},
 	}
 	rsp, sess, err := dialer.Dial(ctx, url, nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
-		return nil, fmt.Errorf("invalid response status code: %d", rsp.StatusCode)
+		return nil, nil, fmt.Errorf("invalid response status code: %d", rsp.StatusCode)
 	}
-	return sess, err
+	return sess, rsp.TLS, err
 }
 
 func (t *transport) upgrade(ctx context.Context, sess *webtransport.Session, p peer.ID, certHashes []multihash.DecodedMultihash) (*connSecurityMultiaddrs, error) {
@@ -236,6 +371,20 @@ func (t *transport) upgrade(ctx context.Context, sess *webtransport.Session, p p
 	}
 	defer str.Close()
 
+	c, err := t.secureOutbound(ctx, &webtransportStream{Stream: str, wsess: sess}, p, certHashes)
+	if err != nil {
+		return nil, err
+	}
+	return &connSecurityMultiaddrs{
+		ConnSecurity:   c,
+		ConnMultiaddrs: &connMultiaddrs{local: local, remote: remote},
+	}, nil
+}
+
+// secureOutbound runs the Noise handshake on the first stream of a session,
+// and checks that the certificate hashes used to dial are a subset of the
+// certificate hashes sent by the server.
+func (t *transport) secureOutbound(ctx context.Context, str net.Conn, p peer.ID, certHashes []multihash.DecodedMultihash) (network.ConnSecurity, error) {
 	// Now run a Noise handshake (using early data) and get all the certificate hashes from the server.
 	// We will verify that the certhashes we used to dial is a subset of the certhashes we received from the server.
 	var verified bool
@@ -262,7 +411,7 @@ func (t *transport) upgrade(ctx context.Context, sess *webtransport.Session, p p
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Noise transport: %w", err)
 	}
-	c, err := n.SecureOutbound(ctx, &webtransportStream{Stream: str, wsess: sess}, p)
+	c, err := n.SecureOutbound(ctx, str, p)
 	if err != nil {
 		return nil, err
 	}
@@ -272,10 +421,7 @@ func (t *transport) upgrade(ctx context.Context, sess *webtransport.Session, p p
 	if !verified {
 		return nil, errors.New("didn't verify")
 	}
-	return &connSecurityMultiaddrs{
-		ConnSecurity:   c,
-		ConnMultiaddrs: &connMultiaddrs{local: local, remote: remote},
-	}, nil
+	return c, nil
 }
 
 func decodeCertHashesFromProtobuf(b [][]byte) ([]multihash.DecodedMultihash, error) {
@@ -296,6 +442,9 @@ func (t *transport) CanDial(addr ma.Multiaddr) bool {
 }
 
 func (t *transport) Listen(laddr ma.Multiaddr) (tpt.Listener, error) {
+	if runtime.GOOS == "js" {
+		return nil, errors.New("listening is not supported when compiled to WebAssembly")
+	}
 	isWebTransport, certhashCount := IsWebtransportMultiaddr(laddr)
 	if !isWebTransport {
 		return nil, fmt.Errorf("cannot listen on non-WebTransport addr: %s", laddr)
@@ -305,7 +454,7 @@ func (t *transport) Listen(laddr ma.Multiaddr) (tpt.Listener, error) {
 	}
 	if t.staticTLSConf == nil {
 		t.listenOnce.Do(func() {
-			t.certManager, t.listenOnceErr = newCertManager(t.privKey, t.clock)
+			t.certManager, t.listenOnceErr = newCertManager(t.privKey, t.clock, t.ticketKeys)
 			t.hasCertManager.Store(true)
 		})
 		if t.listenOnceErr != nil {
@@ -322,7 +471,9 @@ func (t *transport) Listen(laddr ma.Multiaddr) (tpt.Listener, error) {
 	}
 	tlsConf.NextProtos = append(tlsConf.NextProtos, http3.NextProtoH3)
 
-	ln, err := t.connManager.ListenQUIC(laddr, tlsConf, t.allowWindowIncrease)
+	// With custom QUIC tuning, the listener can't share its UDP port with the
+	// QUIC transport, since all ALPNs on a port share a single quic.Config.
+	ln, err := t.connManager.ListenQUICWithConfig(laddr, tlsConf, t.quicServerConfig, t.allowWindowIncrease)
 	if err != nil {
 		return nil, err
 	}
@@ -337,6 +488,16 @@ func (t *transport) Proxy() bool {
 	return false
 }
 
+func (t *transport) Capabilities() tpt.Capabilities {
+	caps := tpt.CapBrowser | tpt.CapCerthash
+	// With custom QUIC tuning, the listeners can't share their UDP port with
+	// the QUIC transport, see Listen.
+	if t.quicServerConfig == nil {
+		caps |= tpt.CapSharedUDPPort
+	}
+	return caps
+}
+
 func (t *transport) Close() error {
 	t.listenOnce.Do(func() {})
 	if t.certManager != nil {
@@ -410,6 +571,16 @@ func (t *transport) Resolve(_ context.Context, maddr ma.Multiaddr) ([]ma.Multiad
 	return []ma.Multiaddr{beforeQuicMA.Encapsulate(quicComponent).Encapsulate(sniComponent).Encapsulate(afterQuicMA)}, nil
 }
 
+// CertHashes returns the hashes of the certificates used by the listeners, with
+// their validity. External address publishers can use it to schedule updates
+// of the published addresses. If called before Listen, it returns false.
+func (t *transport) CertHashes() ([]CertHash, bool) {
+	if !t.hasCertManager.Load() {
+		return nil, false
+	}
+	return t.certManager.CertHashes(), true
+}
+
 // AddCertHashes adds the current certificate hashes to a multiaddress.
 // If called before Listen, it's a no-op.
 func (t *transport) AddCertHashes(m ma.Multiaddr) (ma.Multiaddr, bool) {
@@ -25,6 +25,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/test"
 	tpt "github.com/libp2p/go-libp2p/core/transport"
 	"github.com/libp2p/go-libp2p/p2p/transport/quicreuse"
+	ttransport "github.com/libp2p/go-libp2p/p2p/transport/testsuite"
 	libp2pwebtransport "github.com/libp2p/go-libp2p/p2p/transport/webtransport"
 
 	"github.com/benbjohnson/clock"
@@ -149,6 +150,52 @@ func TestTransport(t *testing.T) {
 	require.True(t, conn.IsClosed())
 }
 
+func TestTransportSuite(t *testing.T) {
+	serverID, serverKey := newIdentity(t)
+	_, clientKey := newIdentity(t)
+	ta, err := libp2pwebtransport.New(serverKey, nil, newConnManager(t), nil, nil)
+	require.NoError(t, err)
+	defer ta.(io.Closer).Close()
+	tb, err := libp2pwebtransport.New(clientKey, nil, newConnManager(t), nil, nil)
+	require.NoError(t, err)
+	defer tb.(io.Closer).Close()
+
+	ttransport.SubtestTransport(t, ta, tb, "/ip4/127.0.0.1/udp/0/quic-v1/webtransport", serverID)
+}
+
+func TestConnectionStateTLS(t *testing.T) {
+	serverID, serverKey := newIdentity(t)
+	tr, err := libp2pwebtransport.New(serverKey, nil, newConnManager(t), nil, nil)
+	require.NoError(t, err)
+	defer tr.(io.Closer).Close()
+	ln, err := tr.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1/webtransport"))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	_, clientKey := newIdentity(t)
+	tr2, err := libp2pwebtransport.New(clientKey, nil, newConnManager(t), nil, nil)
+	require.NoError(t, err)
+	defer tr2.(io.Closer).Close()
+	conn, err := tr2.Dial(context.Background(), ln.Multiaddr(), serverID)
+	require.NoError(t, err)
+	defer conn.Close()
+	serverConn, err := ln.Accept()
+	require.NoError(t, err)
+	defer serverConn.Close()
+
+	for _, c := range []tpt.CapableConn{conn, serverConn} {
+		state := c.ConnState().TLS
+		require.NotNil(t, state)
+		require.Equal(t, uint16(tls.VersionTLS13), state.Version)
+		require.Equal(t, http3.NextProtoH3, state.NegotiatedProtocol)
+		// WebTransport certificates are verified using their hash, and don't carry the libp2p extension.
+		require.Nil(t, state.PeerKeyExtension)
+	}
+	// The client doesn't present a certificate.
+	require.Len(t, conn.ConnState().TLS.PeerCertificates, 1)
+	require.Empty(t, serverConn.ConnState().TLS.PeerCertificates)
+}
+
 func TestHashVerification(t *testing.T) {
 	serverID, serverKey := newIdentity(t)
 	tr, err := libp2pwebtransport.New(serverKey, nil, newConnManager(t), nil, &network.NullResourceManager{})
@@ -245,6 +292,38 @@ func TestListenAddrValidity(t *testing.T) {
 	}
 }
 
+func TestQUICTuning(t *testing.T) {
+	_, key := newIdentity(t)
+	cm := newConnManager(t)
+	_, err := libp2pwebtransport.New(key, nil, cm, nil, &network.NullResourceManager{}, libp2pwebtransport.WithMaxIncomingStreams(0))
+	require.Error(t, err)
+	tr, err := libp2pwebtransport.New(key, nil, cm, nil, &network.NullResourceManager{})
+	require.NoError(t, err)
+	require.True(t, tpt.CapabilitiesOf(tr).Has(tpt.CapSharedUDPPort))
+	tr.(io.Closer).Close()
+
+	tr, err = libp2pwebtransport.New(key, nil, cm, nil, &network.NullResourceManager{},
+		libp2pwebtransport.WithMaxIncomingStreams(10),
+		libp2pwebtransport.WithStreamReceiveWindow(1<<20),
+		libp2pwebtransport.WithConnectionReceiveWindow(2<<20),
+		libp2pwebtransport.WithIdleTimeout(time.Minute),
+	)
+	require.NoError(t, err)
+	defer tr.(io.Closer).Close()
+	require.False(t, tpt.CapabilitiesOf(tr).Has(tpt.CapSharedUDPPort))
+
+	// A tuned WebTransport listener can't share its port with the QUIC transport.
+	quicLn, err := cm.ListenQUIC(ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1"), &tls.Config{NextProtos: []string{"libp2p"}}, nil)
+	require.NoError(t, err)
+	defer quicLn.Close()
+	_, err = tr.Listen(ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/udp/%d/quic-v1/webtransport", quicLn.Addr().(*net.UDPAddr).Port)))
+	require.Error(t, err)
+
+	ln, err := tr.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1/webtransport"))
+	require.NoError(t, err)
+	ln.Close()
+}
+
 func TestListenerAddrs(t *testing.T) {
 	_, key := newIdentity(t)
 	tr, err := libp2pwebtransport.New(key, nil, newConnManager(t), nil, &network.NullResourceManager{})